@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"hpc-toolkit/pkg/config"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	blueprintCmd.AddCommand(blueprintDiffCmd)
+	rootCmd.AddCommand(blueprintCmd)
+}
+
+var (
+	blueprintCmd = &cobra.Command{
+		Use:   "blueprint",
+		Short: "Inspect blueprints.",
+		Args:  cobra.NoArgs,
+	}
+	blueprintDiffCmd = &cobra.Command{
+		Use:   "diff BLUEPRINT_NAME NEW_BLUEPRINT_NAME",
+		Short: "Show the changes between two blueprints.",
+		Long: "Compare two blueprints as written, without expanding them, and print the deployment variables, " +
+			"deployment groups and modules added and removed, and the module settings changed.",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: filterYaml,
+		RunE:              runBlueprintDiffCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runBlueprintDiffCmd(cmd *cobra.Command, args []string) error {
+	prev, err := config.NewDeploymentConfig(args[0])
+	if err != nil {
+		return err
+	}
+	cur, err := config.NewDeploymentConfig(args[1])
+	if err != nil {
+		return err
+	}
+	writeChanges(os.Stdout, cur.Config.Diff(prev.Config))
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/cache"
+
+	"github.com/spf13/cobra"
+)
+
+const msgCLINoCache = "Do not read or write the module metadata and source cache."
+const msgCLIValidatorCacheTTL = "How long successful results of validators that call Google Cloud APIs are reused, 0 to always call the APIs."
+
+func init() {
+	cacheCmd.AddCommand(cacheCleanCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+var (
+	noCache  bool
+	cacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the module metadata and source cache.",
+		Long: fmt.Sprintf("Manage the cache of module metadata, fetched module sources and successful results of "+
+			"validators, stored in ~/.ghpc/cache unless %s is set.", cache.DirEnvVar),
+		Args: cobra.NoArgs,
+	}
+	cacheCleanCmd = &cobra.Command{
+		Use:          "clean",
+		Short:        "Remove all cached module metadata and sources.",
+		Long:         "Remove all cached module metadata, sources and results of validators.",
+		Args:         cobra.NoArgs,
+		RunE:         runCacheCleanCmd,
+		SilenceUsage: true,
+	}
+)
+
+func runCacheCleanCmd(cmd *cobra.Command, args []string) error {
+	d, err := cache.Dir()
+	if err != nil {
+		return err
+	}
+	if err := cache.Clean(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed cache at %s\n", d)
+	return nil
+}
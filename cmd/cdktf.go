@@ -0,0 +1,64 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"hpc-toolkit/pkg/cdktf"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/shell"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	exportCDKTFCmd.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+	exportCDKTFCmd.Flags().StringVarP(&cdktfDir, "out", "o", "", "Directory the cdktf project is written to")
+	exportCDKTFCmd.MarkFlagRequired("out")
+	rootCmd.AddCommand(exportCDKTFCmd)
+}
+
+var (
+	cdktfDir       string
+	exportCDKTFCmd = &cobra.Command{
+		Use:   "export-cdktf DEPLOYMENT_DIRECTORY",
+		Short: "Export a deployment as a CDK for Terraform project.",
+		Long: "Write a CDK for Terraform (cdktf) TypeScript project of a deployment directory, with a stack per " +
+			"Terraform deployment group and a TerraformHclModule per module.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runExportCDKTFCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runExportCDKTFCmd(cmd *cobra.Command, args []string) error {
+	deploymentRoot = args[0]
+	artifactsDir = getArtifactsDir(deploymentRoot)
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return err
+	}
+	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := cdktf.Write(dc.Config, deploymentRoot, cdktfDir); err != nil {
+		return errcode.New(errcode.WriteFailure, err)
+	}
+	return nil
+}
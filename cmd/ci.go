@@ -0,0 +1,146 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/ci"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/shell"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	exportCICmd.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+	exportCICmd.Flags().StringVar(&ciFormat, "format", ci.GitHub,
+		fmt.Sprintf("Format of the pipeline: %s", strings.Join(ci.Formats(), ", ")))
+	exportCICmd.Flags().StringVar(&ciBranch, "branch", "main", "Branch merges to which deploy the deployment")
+	exportCICmd.Flags().StringVarP(&ciFile, "file", "f", "", "Write the pipeline to this file instead of the standard output")
+	rootCmd.AddCommand(exportCICmd)
+}
+
+var (
+	ciFormat    string
+	ciBranch    string
+	ciFile      string
+	exportCICmd = &cobra.Command{
+		Use:   "export-ci DEPLOYMENT_DIRECTORY",
+		Short: "Generate a CI pipeline that plans and deploys a deployment.",
+		Long: "Generate a GitHub Actions workflow or GitLab CI pipeline for a deployment directory committed to a " +
+			"repository, with a job per deployment group that plans it on pull requests and a job per group that " +
+			"deploys it when changes are merged. Run it from the root of the repository.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runExportCICmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runExportCICmd(cmd *cobra.Command, args []string) error {
+	deploymentRoot = args[0]
+	artifactsDir = getArtifactsDir(deploymentRoot)
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return err
+	}
+	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	p, err := ciPipeline(dc.Config, deploymentRoot, ciBranch)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	var w io.Writer = os.Stdout
+	if ciFile != "" {
+		f, err := os.Create(ciFile)
+		if err != nil {
+			return errcode.New(errcode.WriteFailure, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := ci.Write(w, ciFormat, p); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	return nil
+}
+
+// ciPipeline returns the pipeline of the deployment directory, relative to the
+// current directory, the root of the repository. The Terraform state of the
+// groups must be kept in a remote backend, as each job runs on a new machine.
+func ciPipeline(bp config.Blueprint, deploymentDir string, branch string) (ci.Pipeline, error) {
+	name, err := bp.DeploymentName()
+	if err != nil {
+		return ci.Pipeline{}, err
+	}
+	dir, err := repoPath(deploymentDir)
+	if err != nil {
+		return ci.Pipeline{}, err
+	}
+	p := ci.Pipeline{Deployment: name, DeploymentDir: dir, Branch: branch}
+	deps := groupDependencies(bp, bp.DeploymentGroups)
+	for _, g := range bp.DeploymentGroups {
+		cg := ci.Group{Name: string(g.Name), Packer: g.Kind == config.PackerKind, Helm: g.Kind == config.HelmKind}
+		for _, d := range deps[g.Name] {
+			cg.Needs = append(cg.Needs, string(d))
+		}
+		if cg.Packer {
+			mods, err := g.PackerBuildOrder()
+			if err != nil {
+				return ci.Pipeline{}, err
+			}
+			for _, m := range mods {
+				cg.Modules = append(cg.Modules, string(m.ID))
+			}
+			cg.SharedVars = len(g.PackerSettings.Items()) > 0
+		} else if cg.Helm {
+			for _, m := range g.Modules {
+				cg.Modules = append(cg.Modules, string(m.ID))
+			}
+		} else if t := g.TerraformBackend.Type; t == "" || t == "local" {
+			return ci.Pipeline{}, fmt.Errorf(
+				"group %s keeps its Terraform state in the deployment directory, CI pipelines require a remote backend such as gcs", g.Name)
+		}
+		p.Groups = append(p.Groups, cg)
+	}
+	return p, nil
+}
+
+// repoPath returns the path of the directory relative to the current
+// directory, with forward slashes
+func repoPath(dir string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("deployment directory %s must be a subdirectory of the current directory, the root of the repository", dir)
+	}
+	return filepath.ToSlash(rel), nil
+}
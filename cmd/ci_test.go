@@ -0,0 +1,56 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"hpc-toolkit/pkg/ci"
+	"hpc-toolkit/pkg/config"
+
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestCIPipeline(c *C) {
+	gcs := config.TerraformBackend{Type: "gcs"}
+	bp := config.Blueprint{
+		Vars: config.NewDict(map[string]cty.Value{"deployment_name": cty.StringVal("hpc")}),
+		DeploymentGroups: []config.DeploymentGroup{
+			{Name: "primary", Kind: config.TerraformKind, TerraformBackend: gcs},
+			{Name: "image", Kind: config.PackerKind, Modules: []config.Module{{ID: "base", Kind: config.PackerKind}}},
+			{Name: "cluster", Kind: config.TerraformKind, TerraformBackend: gcs},
+		}}
+
+	p, err := ciPipeline(bp, "deployments/hpc", "main")
+	c.Assert(err, IsNil)
+	c.Check(p.Deployment, Equals, "hpc")
+	c.Check(p.DeploymentDir, Equals, "deployments/hpc")
+	c.Check(p.Groups, DeepEquals, []ci.Group{
+		{Name: "primary"},
+		{Name: "image", Packer: true, Modules: []string{"base"}},
+		// groups are deployed after the Packer groups before them
+		{Name: "cluster", Needs: []string{"image"}},
+	})
+
+	// the deployment directory must be in the repository
+	_, err = ciPipeline(bp, "..", "main")
+	c.Check(err, NotNil)
+
+	// the state of groups cannot be kept on the machine of a job
+	bp.DeploymentGroups[2].TerraformBackend = config.TerraformBackend{}
+	_, err = ciPipeline(bp, "deployments/hpc", "main")
+	c.Check(err, ErrorMatches, ".*group cluster keeps its Terraform state.*")
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/shell"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cloneCmd.Flags().StringVarP(&cloneOutputDir, "out", "o", "",
+		"Sets the output directory where the new deployment directory will be created "+
+			"(defaults to the directory containing the existing deployment).")
+	cloneCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
+	cloneCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	rootCmd.AddCommand(cloneCmd)
+}
+
+var (
+	cloneOutputDir string
+	cloneCmd       = &cobra.Command{
+		Use:   "clone-deployment DEPLOYMENT_DIRECTORY NEW_DEPLOYMENT_NAME",
+		Short: "Create a copy of a deployment with a new name.",
+		Long: "Create a new deployment with the settings of an existing deployment. The deployment name, " +
+			"the ghpc_deployment labels and the Terraform state prefixes are rewritten for the new name; " +
+			"Terraform state is not copied.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(2), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runCloneCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runCloneCmd(cmd *cobra.Command, args []string) error {
+	deploymentDir := filepath.Clean(args[0])
+	expandedBlueprintFile := filepath.Join(deploymentDir, defaultArtifactsDir, expandedBlueprintFilename)
+	dc, err := config.NewDeploymentConfig(expandedBlueprintFile)
+	if err != nil {
+		return err
+	}
+	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentDir); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if dc.Config, err = dc.Config.CloneDeployment(args[1]); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := setValidationLevel(&dc.Config, validationLevel); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := skipValidators(&dc); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	dc.Config.GhpcVersion = GitCommitInfo
+	if err := dc.ExpandConfig(); err != nil {
+		return err
+	}
+
+	outDir := cloneOutputDir
+	if outDir == "" {
+		outDir = filepath.Dir(deploymentDir)
+	}
+	return modulewriter.WriteDeployment(dc, outDir, false)
+}
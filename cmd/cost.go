@@ -0,0 +1,77 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/cost"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/offline"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func init() {
+	costCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	costCmd.Flags().StringSliceVar(&varsFiles, "vars-file", nil, msgCLIVarsFile)
+	costCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
+	costCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	costCmd.Flags().BoolVar(&allowExec, "allow-exec", false, msgCLIAllowExec)
+	costCmd.Flags().BoolVar(&noCache, "no-cache", false, msgCLINoCache)
+	costCmd.Flags().Float64Var(&maxMonthlyCost, "max-monthly-cost", 0,
+		"Fail if the estimated monthly cost of the deployment, in USD, exceeds this amount.")
+	rootCmd.AddCommand(costCmd)
+}
+
+var (
+	maxMonthlyCost float64
+	costCmd        = &cobra.Command{
+		Use:   "cost BLUEPRINT_NAME",
+		Short: "Estimate the cost of the deployment of the Environment Blueprint.",
+		Long: "Expands the Environment Blueprint as expand does and estimates the hourly and monthly cost of the " +
+			"instances, GPUs, disks and Filestore instances of each deployment group with the on-demand prices " +
+			"of the Cloud Billing Catalog.",
+		Run:               runCostCmd,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: filterYaml,
+	}
+)
+
+func runCostCmd(cmd *cobra.Command, args []string) {
+	if offline.Enabled {
+		checkErr(errors.New("ghpc cost reads prices from the Cloud Billing Catalog and cannot run in offline mode"))
+	}
+	dc := expandOrDie(args[0])
+
+	project := ""
+	if v := dc.Config.Vars.Get("project_id"); v != cty.NilVal && v.Type() == cty.String {
+		project = v.AsString()
+	}
+	pricer, err := cost.NewCatalogPricer(project)
+	checkErr(err)
+	est := cost.Price(dc.Config.BillableResources(), pricer)
+	name, _ := dc.Config.DeploymentName()
+	cost.WriteSummary(os.Stdout, name, est)
+
+	if maxMonthlyCost > 0 && est.Monthly("") > maxMonthlyCost {
+		checkErr(errcode.New(errcode.CostLimitExceeded, fmt.Errorf(
+			"the estimated monthly cost of $%.2f exceeds --max-monthly-cost of $%.2f", est.Monthly(""), maxMonthlyCost)))
+	}
+}
@@ -18,21 +18,45 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hpc-toolkit/pkg/cache"
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/gcsupload"
 	"hpc-toolkit/pkg/modulewriter"
-	"log"
+	"hpc-toolkit/pkg/policy"
+	"hpc-toolkit/pkg/wizard"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
 	"github.com/spf13/cobra"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 	"gopkg.in/yaml.v3"
 )
 
 const msgCLIVars = "Comma-separated list of name=value variables to override YAML configuration. Can be used multiple times."
+const msgCLIVarsFile = "YAML file of variables to override YAML configuration, decrypted with sops if it is encrypted with SOPS. Can be used multiple times; --vars take precedence."
 const msgCLIBackendConfig = "Comma-separated list of name=value variables to set Terraform backend configuration. Can be used multiple times."
+const msgCLIAllowExec = "Allow blueprint settings to be sourced from commands with $(exec(\"...\")), and validator plugins to run. Commands are run at expand time."
+const msgCLIPolicyBundle = "Rego policies evaluated against the expanded blueprint with the validators (defaults to $" + policy.BundleEnvVar + ")."
+const msgCLIEnableApis = "Enable the required APIs that are disabled in the project instead of failing validation; \"dry-run\" lists the APIs that would be enabled."
+const msgCLICreateBackendBucket = "Create the Cloud Storage bucket of the gcs Terraform backend, with uniform bucket-level access, if it does not exist."
+const msgCLIBackendBucketVersioning = "Enable object versioning on the backend bucket created by --create-backend-bucket."
+const msgCLIBackendBucketPreventPublicAccess = "Enforce public access prevention on the backend bucket created by --create-backend-bucket."
+const msgCLITerragrunt = "Write a " + modulewriter.TerragruntFilename + " in the directory of each Terraform group, with dependency blocks for the inputs from other groups."
+const msgCLIFlatten = "Write the deployment as a single Terraform root module, with a single state, instead of a directory per deployment group."
+const msgCLIInteractive = "Ask for the values of required deployment variables and module settings that are not set instead of failing."
+const msgCLINoToolkitLabels = "Do not add the ghpc_blueprint, ghpc_deployment and ghpc_role labels to the deployment and its modules."
+const msgCLIToolkitLabelPrefix = "Prefix of the keys of the labels ghpc adds, instead of \"" + config.DefaultToolkitLabelPrefix + "\"."
+const msgCLIBackup = "Overwrite an existing deployment directory, moving it without its Terraform state to a timestamped backup directory next to it."
+const msgCLIReorderGroups = "Move deployment groups after the groups whose outputs they use or they depend on, with a warning, instead of failing."
+const msgCLIProfile = "Profile of the blueprint whose overrides of deployment variables and module settings are applied; --vars take precedence."
+const msgCLIFrozenLockfile = "Fail if module sources resolve differently than recorded in " + config.LockfileName + ", instead of updating it."
 
 func init() {
 	createCmd.Flags().StringVarP(&bpFilenameDeprecated, "config", "c", "", "")
@@ -42,9 +66,27 @@ func init() {
 	createCmd.Flags().StringVarP(&outputDir, "out", "o", "",
 		"Sets the output directory where the HPC deployment directory will be created.")
 	createCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	createCmd.Flags().StringSliceVar(&varsFiles, "vars-file", nil, msgCLIVarsFile)
+	createCmd.Flags().BoolVar(&interactive, "interactive", false, msgCLIInteractive)
+	createCmd.Flags().StringVar(&profile, "profile", "", msgCLIProfile)
 	createCmd.Flags().StringSliceVar(&cliBEConfigVars, "backend-config", nil, msgCLIBackendConfig)
 	createCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
 	createCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	createCmd.Flags().BoolVar(&allowExec, "allow-exec", false, msgCLIAllowExec)
+	createCmd.Flags().BoolVar(&noCache, "no-cache", false, msgCLINoCache)
+	createCmd.Flags().DurationVar(&cache.ValidatorTTL, "validator-cache-ttl", cache.ValidatorTTL, msgCLIValidatorCacheTTL)
+	createCmd.Flags().BoolVar(&frozenLockfile, "frozen-lockfile", false, msgCLIFrozenLockfile)
+	createCmd.Flags().BoolVar(&reorderGroups, "reorder-groups", false, msgCLIReorderGroups)
+	createCmd.Flags().StringVar(&policyBundle, "policy-bundle", "", msgCLIPolicyBundle)
+	addEnableApisFlag(createCmd)
+	addToolkitLabelsFlags(createCmd)
+	createCmd.Flags().BoolVar(&createBackendBucket, "create-backend-bucket", false, msgCLICreateBackendBucket)
+	createCmd.Flags().BoolVar(&backendBucketVersioning, "backend-bucket-versioning", false, msgCLIBackendBucketVersioning)
+	createCmd.Flags().BoolVar(&backendBucketPreventPublicAccess, "backend-bucket-prevent-public-access", false,
+		msgCLIBackendBucketPreventPublicAccess)
+	createCmd.Flags().BoolVar(&terragrunt, "terragrunt", false, msgCLITerragrunt)
+	createCmd.Flags().BoolVar(&flatten, "flatten", false, msgCLIFlatten)
+	createCmd.Flags().BoolVar(&backupDeployment, "backup", false, msgCLIBackup)
 	createCmd.Flags().BoolVarP(&overwriteDeployment, "overwrite-deployment", "w", false,
 		"If specified, an existing deployment directory is overwritten by the new deployment. \n"+
 			"Note: Terraform state IS preserved. \n"+
@@ -57,9 +99,27 @@ var (
 	bpFilenameDeprecated string
 	outputDir            string
 	cliVariables         []string
+	varsFiles            []string
+	interactive          bool
+	profile              string
 
 	cliBEConfigVars     []string
+	allowExec           bool
+	frozenLockfile      bool
+	reorderGroups       bool
+	policyBundle        string
+	enableApis          string
+	noToolkitLabels     bool
+	toolkitLabelPrefix  string
 	overwriteDeployment bool
+	backupDeployment    bool
+	terragrunt          bool
+	flatten             bool
+
+	createBackendBucket              bool
+	backendBucketVersioning          bool
+	backendBucketPreventPublicAccess bool
+
 	validationLevel     string
 	validationLevelDesc = "Set validation level to one of (\"ERROR\", \"WARNING\", \"IGNORE\")"
 	validatorsToSkip    []string
@@ -75,50 +135,159 @@ var (
 	}
 )
 
+// addEnableApisFlag adds --enable-apis, which enables the APIs when given
+// without a value
+func addEnableApisFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&enableApis, "enable-apis", "false", msgCLIEnableApis)
+	cmd.Flags().Lookup("enable-apis").NoOptDefVal = "true"
+}
+
+// addToolkitLabelsFlags adds the flags overriding toolkit_labels of the
+// blueprint
+func addToolkitLabelsFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&noToolkitLabels, "no-toolkit-labels", false, msgCLINoToolkitLabels)
+	cmd.Flags().StringVar(&toolkitLabelPrefix, "toolkit-label-prefix", "", msgCLIToolkitLabelPrefix)
+}
+
+// setToolkitLabels overrides toolkit_labels of the blueprint with the flags
+func setToolkitLabels(bp *config.Blueprint) {
+	if noToolkitLabels {
+		bp.ToolkitLabels.Disabled = true
+	}
+	if toolkitLabelPrefix != "" {
+		bp.ToolkitLabels.Prefix = toolkitLabelPrefix
+	}
+}
+
 func runCreateCmd(cmd *cobra.Command, args []string) {
 	dc := expandOrDie(args[0])
+	dc.Terragrunt = terragrunt
+	dc.Backup = backupDeployment
+	if flatten {
+		checkErr(errcode.New(errcode.ConfigError, dc.Config.Flatten()))
+	}
+	if createBackendBucket {
+		checkErr(createBackendBuckets(dc.Config))
+	} else if backendBucketVersioning || backendBucketPreventPublicAccess {
+		checkErr(errcode.New(errcode.ConfigError,
+			errors.New("--backend-bucket-versioning and --backend-bucket-prevent-public-access require --create-backend-bucket")))
+	}
 	if err := modulewriter.WriteDeployment(dc, outputDir, overwriteDeployment); err != nil {
 		var target *modulewriter.OverwriteDeniedError
 		if errors.As(err, &target) {
 			fmt.Printf("\n%s\n", err.Error())
-			os.Exit(1)
-		} else {
-			log.Fatal(err)
+			os.Exit(errcode.ExitCode(err))
 		}
+		checkErr(err)
 	}
 }
 
-func expandOrDie(path string) config.DeploymentConfig {
-	dc, err := config.NewDeploymentConfig(path)
+// createBackendBuckets creates the buckets of the gcs backends of the
+// blueprint that do not exist
+func createBackendBuckets(bp config.Blueprint) error {
+	buckets, err := bp.BackendBuckets()
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	client, err := gcsupload.DefaultClient(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return errcode.New(errcode.WriteFailure, err)
+	}
+	for _, b := range buckets {
+		created, err := gcsupload.EnsureBucket(ctx, client, gcsupload.Bucket{
+			Name:                b.Name,
+			Project:             b.Project,
+			Location:            b.Location,
+			Labels:              b.Labels,
+			Versioning:          backendBucketVersioning,
+			PreventPublicAccess: backendBucketPreventPublicAccess,
+		})
+		if err != nil {
+			return errcode.New(errcode.WriteFailure, err)
+		}
+		if created {
+			fmt.Printf("Created backend bucket gs://%s in %s\n", b.Name, b.Location)
+		}
 	}
+	return nil
+}
+
+func expandOrDie(path string) config.DeploymentConfig {
+	cache.Enabled = !noCache
+	checkErr(errcode.New(errcode.ConfigError, checkDiagnosticsFormat()))
+	checkErr(errcode.New(errcode.ConfigError, useVault()))
+	dc, err := config.NewDeploymentConfig(path)
+	checkErr(err)
+	writeDiagnostics := collectDiagnostics(&dc)
+	// Defaults of the user-level configuration file, beneath the blueprint
+	checkErr(errcode.New(errcode.ConfigError, userConfig.ApplyBlueprint(&dc.Config)))
+	checkErr(errcode.New(errcode.ConfigError, dc.Config.ApplyProfile(profile)))
 	// Set properties from CLI
+	if err := setVarsFiles(&dc.Config, varsFiles); err != nil {
+		checkErr(errcode.New(errcode.ConfigError, fmt.Errorf("Failed to set the variables of --vars-file: %v", err)))
+	}
 	if err := setCLIVariables(&dc.Config, cliVariables); err != nil {
-		log.Fatalf("Failed to set the variables at CLI: %v", err)
+		checkErr(errcode.New(errcode.ConfigError, fmt.Errorf("Failed to set the variables at CLI: %v", err)))
 	}
 	if err := setBackendConfig(&dc.Config, cliBEConfigVars); err != nil {
-		log.Fatalf("Failed to set the backend config at CLI: %v", err)
+		checkErr(errcode.New(errcode.ConfigError, fmt.Errorf("Failed to set the backend config at CLI: %v", err)))
 	}
-	if err := setValidationLevel(&dc.Config, validationLevel); err != nil {
-		log.Fatal(err)
+	setToolkitLabels(&dc.Config)
+	checkErr(errcode.New(errcode.ConfigError, dc.Config.RecordProvenance(path)))
+	checkErr(errcode.New(errcode.ConfigError, setValidationLevel(&dc.Config, validationLevel)))
+	checkErr(errcode.New(errcode.ConfigError, skipValidators(&dc)))
+	dc.AllowExec = allowExec
+	dc.ReorderGroups = reorderGroups
+	if interactive {
+		dc.Prompt = promptValue(wizard.NewPrompter(os.Stdin, os.Stderr))
 	}
-	if err := skipValidators(&dc); err != nil {
-		log.Fatal(err)
+	dc.PolicyBundle = policyBundle
+	if dc.PolicyBundle == "" {
+		dc.PolicyBundle = os.Getenv(policy.BundleEnvVar)
 	}
+	dc.EnableApis, err = config.ParseEnableApisMode(enableApis)
+	checkErr(errcode.New(errcode.ConfigError, err))
 	if dc.Config.GhpcVersion != "" {
 		fmt.Printf("ghpc_version setting is ignored.")
 	}
 	dc.Config.GhpcVersion = GitCommitInfo
 
+	// Pin the versions of Terraform Registry modules
+	lockPath := filepath.Join(filepath.Dir(path), config.LockfileName)
+	lock, err := config.ReadLockfile(lockPath)
+	checkErr(errcode.New(errcode.ConfigError, err))
+	lock.Frozen = frozenLockfile
+	checkErr(errcode.New(errcode.SourceFetchFailure, dc.Config.ResolveModuleVersions(&lock)))
+
 	// Expand the blueprint
-	if err := dc.ExpandConfig(); err != nil {
-		log.Fatal(err)
-	}
+	checkErr(writeDiagnostics(dc.ExpandConfig()))
 
+	// Record the resolution of all non-local module sources
+	checkErr(errcode.New(errcode.SourceFetchFailure, dc.Config.LockModuleSources(&lock)))
+	if lock.Changed() {
+		checkErr(errcode.New(errcode.WriteFailure, lock.Write(lockPath)))
+	}
 	return dc
 }
 
+// setVarsFiles sets the variables of the files, in order
+func setVarsFiles(bp *config.Blueprint, files []string) error {
+	for _, f := range files {
+		vars, err := config.ReadVarsFile(f)
+		if err != nil {
+			return err
+		}
+		for k, v := range vars.Items() {
+			bp.Vars.Set(k, v)
+		}
+	}
+	return nil
+}
+
 func setCLIVariables(bp *config.Blueprint, s []string) error {
 	for _, cliVar := range s {
 		arr := strings.SplitN(cliVar, "=", 2)
@@ -137,6 +306,37 @@ func setCLIVariables(bp *config.Blueprint, s []string) error {
 	return nil
 }
 
+// promptValue returns a config.PromptFunc asking for values with p, parsed
+// as --vars values are and converted to the type asked for
+func promptValue(p *wizard.Prompter) config.PromptFunc {
+	return func(what string, description string, ty cty.Type) (cty.Value, error) {
+		question := fmt.Sprintf("Value of %s (%s)", what, typeexpr.TypeString(ty))
+		if description != "" {
+			question = fmt.Sprintf("%s\n%s", strings.TrimSpace(description), question)
+		}
+		var res cty.Value
+		err := p.Parse(question, func(s string) error {
+			if s == "" {
+				return fmt.Errorf("%s is required", what)
+			}
+			v := cty.StringVal(s)
+			if ty != cty.String {
+				var y config.YamlValue
+				if err := yaml.Unmarshal([]byte(s), &y); err != nil {
+					return fmt.Errorf("invalid value %q: %v", s, err)
+				}
+				v = y.Unwrap()
+			}
+			if _, err := convert.Convert(v, ty); err != nil {
+				return fmt.Errorf("invalid value %q, must be of type %s: %v", s, typeexpr.TypeString(ty), err)
+			}
+			res = v
+			return nil
+		})
+		return res, err
+	}
+}
+
 func setBackendConfig(bp *config.Blueprint, s []string) error {
 	if len(s) == 0 {
 		return nil // no op
@@ -163,16 +363,11 @@ func setBackendConfig(bp *config.Blueprint, s []string) error {
 
 // SetValidationLevel allows command-line tools to set the validation level
 func setValidationLevel(bp *config.Blueprint, s string) error {
-	switch s {
-	case "ERROR":
-		bp.ValidationLevel = config.ValidationError
-	case "WARNING":
-		bp.ValidationLevel = config.ValidationWarning
-	case "IGNORE":
-		bp.ValidationLevel = config.ValidationIgnore
-	default:
-		return fmt.Errorf("invalid validation level (\"ERROR\", \"WARNING\", \"IGNORE\")")
+	level, err := config.ParseValidationLevel(s)
+	if err != nil {
+		return err
 	}
+	bp.ValidationLevel = level
 	return nil
 }
 
@@ -15,7 +15,12 @@
 package cmd
 
 import (
+	"bytes"
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/wizard"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/zclconf/go-cty/cty"
 	. "gopkg.in/check.v1"
@@ -76,6 +81,36 @@ func (s *MySuite) TestSetCLIVariables(c *C) {
 	c.Check(bp.Vars, DeepEquals, config.Dict{})
 }
 
+func (s *MySuite) TestSetVarsFiles(c *C) {
+	dir := c.MkDir()
+	first, second := filepath.Join(dir, "first.yaml"), filepath.Join(dir, "second.yaml")
+	c.Assert(os.WriteFile(first, []byte("zone: us-central1-a\nnode_count: 4\n"), 0644), IsNil)
+	c.Assert(os.WriteFile(second, []byte("node_count: 8\n"), 0644), IsNil)
+
+	bp := config.Blueprint{}
+	bp.Vars.Set("zone", cty.StringVal("europe-west1-b"))
+	c.Assert(setVarsFiles(&bp, []string{first, second}), IsNil)
+	c.Check(bp.Vars.Items(), DeepEquals, map[string]cty.Value{
+		"zone":       cty.StringVal("us-central1-a"),
+		"node_count": cty.NumberIntVal(8),
+	})
+
+	c.Check(setVarsFiles(&bp, []string{filepath.Join(dir, "missing.yaml")}), ErrorMatches,
+		"failed to read variables file .*")
+}
+
+func (s *MySuite) TestSetToolkitLabels(c *C) {
+	defer func() { noToolkitLabels, toolkitLabelPrefix = false, "" }()
+
+	bp := config.Blueprint{ToolkitLabels: config.ToolkitLabels{Prefix: "acme_"}}
+	setToolkitLabels(&bp)
+	c.Check(bp.ToolkitLabels, DeepEquals, config.ToolkitLabels{Prefix: "acme_"})
+
+	noToolkitLabels, toolkitLabelPrefix = true, "hpc_"
+	setToolkitLabels(&bp)
+	c.Check(bp.ToolkitLabels, DeepEquals, config.ToolkitLabels{Disabled: true, Prefix: "hpc_"})
+}
+
 func (s *MySuite) TestSetBackendConfig(c *C) {
 	// Success
 	vars := []string{
@@ -128,3 +163,22 @@ func (s *MySuite) TestValidationLevels(c *C) {
 
 	c.Check(setValidationLevel(&bp, "INVALID"), NotNil)
 }
+
+func (s *MySuite) TestPromptValue(c *C) {
+	var out bytes.Buffer
+	prompt := promptValue(wizard.NewPrompter(strings.NewReader("\nmany\n3\n"), &out))
+	v, err := prompt("deployment variable node_count", "Number of nodes", cty.Number)
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, cty.NumberIntVal(3))
+	c.Check(out.String(), Matches, "(?s)Number of nodes\nValue of deployment variable node_count \\(number\\): .*is required.*must be of type number.*")
+
+	// strings are taken as they are typed
+	prompt = promptValue(wizard.NewPrompter(strings.NewReader("true\n"), &out))
+	v, err = prompt("setting name of module vm", "", cty.String)
+	c.Assert(err, IsNil)
+	c.Check(v, DeepEquals, cty.StringVal("true"))
+
+	// the end of the input aborts
+	_, err = prompt("setting name of module vm", "", cty.String)
+	c.Check(err, NotNil)
+}
@@ -16,13 +16,23 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"hpc-toolkit/pkg/audit"
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/health"
+	"hpc-toolkit/pkg/runner"
 	"hpc-toolkit/pkg/shell"
 	"log"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
 )
 
 func init() {
@@ -33,15 +43,52 @@ func init() {
 
 	autoApproveFlag := "auto-approve"
 	deployCmd.Flags().BoolVarP(&autoApprove, autoApproveFlag, "", false, "Automatically approve proposed changes")
+	deployCmd.Flags().BoolVar(&auditLog, "audit-log", false, msgAuditLog)
+	deployCmd.Flags().StringVar(&auditLogProject, "audit-log-project", "", msgAuditLogProject)
+	deployCmd.Flags().IntVar(&uploadRate, "upload-rate", 0, msgUploadRate)
+	deployCmd.Flags().BoolVar(&skipHealthChecks, "skip-health-checks", false, "Skip the health probes of modules after deployment")
+	deployCmd.Flags().DurationVar(&healthCheckTimeout, "health-check-timeout", 5*time.Minute,
+		"Time to wait for the health probes of modules to succeed after deployment")
+	deployCmd.Flags().StringVar(&onlyGroup, "only", "", "Deploy only this deployment group")
+	deployCmd.Flags().StringVar(&fromGroup, "from", "",
+		"Deploy this deployment group and the groups that follow it, e.g. to resume a failed deployment")
+	deployCmd.MarkFlagsMutuallyExclusive("only", "from")
+	deployCmd.Flags().BoolVar(&shell.RollbackOnFailure, "rollback-on-failure", false,
+		"If terraform apply fails, destroy the resources it created and restore the state from before it")
+	deployCmd.Flags().IntVar(&parallelism, "parallelism", 1,
+		"Maximum number of independent deployment groups deployed concurrently; requires --auto-approve if greater than 1")
+	deployCmd.Flags().IntVar(&maxAttempts, "max-attempts", 0,
+		"Maximum number of attempts to deploy a group failing with transient errors (default: the max_attempts of its retry settings, or 1)")
+	addOutputFlag(deployCmd)
+	deployCmd.Flags().StringVar(&planSummaryFile, "plan-summary-file", "",
+		"Also write the summaries of the changes planned for each group to this file, e.g. for a review")
+	addRunnerFlags(deployCmd)
 
 	rootCmd.AddCommand(deployCmd)
 }
 
+// errNotApplied is returned by the deployment of a group whose changes were
+// declined in part: the group is not marked applied, and the groups that
+// depend on it are not deployed
+var errNotApplied = errors.New("the proposed changes were declined")
+
+const msgAuditLog = "Write an entry recording the outcome to Cloud Logging when the command completes"
+const msgAuditLogProject = "Project to write the audit log entry to (default: the project_id deployment variable); implies --audit-log"
+
 var (
-	deploymentRoot string
-	autoApprove    bool
-	applyBehavior  shell.ApplyBehavior
-	deployCmd      = &cobra.Command{
+	deploymentRoot     string
+	autoApprove        bool
+	auditLog           bool
+	auditLogProject    string
+	skipHealthChecks   bool
+	healthCheckTimeout time.Duration
+	onlyGroup          string
+	fromGroup          string
+	parallelism        int
+	maxAttempts        int
+	planSummaryFile    string
+	applyBehavior      shell.ApplyBehavior
+	deployCmd          = &cobra.Command{
 		Use:               "deploy DEPLOYMENT_DIRECTORY",
 		Short:             "deploy all resources in a Toolkit deployment directory.",
 		Long:              "deploy all resources in a Toolkit deployment directory.",
@@ -59,8 +106,25 @@ func parseDeployArgs(cmd *cobra.Command, args []string) error {
 	deploymentRoot = args[0]
 	artifactsDir = getArtifactsDir(deploymentRoot)
 	if err := shell.CheckWritableDir(artifactsDir); err != nil {
+		return errcode.New(errcode.WriteFailure, err)
+	}
+	if parallelism < 1 {
+		return errcode.New(errcode.ConfigError, fmt.Errorf("--parallelism must be at least 1"))
+	}
+	// proposed changes of concurrent groups cannot be approved one at a time
+	if parallelism > 1 && applyBehavior != shell.AutomaticApply {
+		return errcode.New(errcode.ConfigError, fmt.Errorf("--parallelism greater than 1 requires --auto-approve"))
+	}
+	if maxAttempts < 0 {
+		return errcode.New(errcode.ConfigError, fmt.Errorf("--max-attempts must not be negative"))
+	}
+	if err := setOutputFormat(); err != nil {
 		return err
 	}
+	// proposed changes cannot be approved in a remote runner
+	if runnerName != runner.Local && applyBehavior != shell.AutomaticApply {
+		return errcode.New(errcode.ConfigError, fmt.Errorf("--runner %s requires --auto-approve", runnerName))
+	}
 
 	return nil
 }
@@ -80,65 +144,479 @@ func runDeployCmd(cmd *cobra.Command, args []string) error {
 	}
 
 	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if runnerName != runner.Local {
+		return runRemote(cmd, dc)
+	}
+	shell.ArtifactStore = dc.Config.ArtifactStore
+	if err := useVault(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	secrets, err := shell.UseSecretVars(dc.Config)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	remoteOutputs, err := shell.UseRemoteOutputVars(dc.Config)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	shell.MaskSecrets(append(append(dc.Config.SensitiveValues(), secrets...), remoteOutputs...))
+	if err := shell.UseTerraformCLIConfig(deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	state, err := shell.ReadDeployState(artifactsDir)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	groups, err := selectGroups(dc.Config, state, artifactsDir, onlyGroup, fromGroup)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if planSummaryFile != "" {
+		f, err := os.Create(planSummaryFile)
+		if err != nil {
+			return errcode.New(errcode.WriteFailure, err)
+		}
+		defer f.Close()
+		shell.WritePlanSummaries(f)
+		defer shell.WritePlanSummaries(nil)
+	}
+
+	entry, err := beginAudit(audit.Deploy, dc, expandedBlueprintFile)
+	if err != nil {
 		return err
 	}
+	deploy := func(g config.DeploymentGroup) error {
+		return withGroupEvents(g.Name, func() error {
+			return withGroupLog(g.Name, "deploy", func() error { return deployGroup(dc, g, expandedBlueprintFile) })
+		})
+	}
+	err = deployGroups(groups, groupDependencies(dc.Config, groups), state, entry, deploy)
+	if err == nil {
+		if werr := shell.WriteDeploymentOutputs(dc.Config, artifactsDir, "json"); werr != nil {
+			err = errcode.New(errcode.WriteFailure, werr)
+		}
+	}
+	if err == nil && !skipHealthChecks {
+		if pending := pendingGroups(dc.Config, state); len(pending) > 0 {
+			log.Printf("skipping health checks, groups %v have not been deployed", pending)
+		} else {
+			err = runHealthChecks(dc)
+		}
+	}
+	endAudit(entry, dc, err)
+	emitFinished("deploy", err)
+	return err
+}
 
-	for _, group := range dc.Config.DeploymentGroups {
-		groupDir := filepath.Join(deploymentRoot, string(group.Name))
-		if err = shell.ImportInputs(groupDir, artifactsDir, expandedBlueprintFile); err != nil {
-			return err
+// selectGroups returns the groups to deploy: all groups, only the group set
+// with --only, or the groups from the group set with --from onwards. The
+// groups whose outputs the selected groups use, the groups they depend on and
+// the Packer groups before them must be selected or have been applied
+// successfully before. Groups whose outputs were exported to artifactsDir, e.g.
+// with ghpc export-outputs after a manual apply, provide their outputs.
+func selectGroups(bp config.Blueprint, state shell.DeployState, artifactsDir string, only string, from string) ([]config.DeploymentGroup, error) {
+	groups := bp.DeploymentGroups
+	name := only
+	if from != "" {
+		name = from
+	}
+	if name != "" {
+		i := bp.GroupIndex(config.GroupName(name))
+		if i < 0 {
+			return nil, fmt.Errorf("deployment group %s does not exist", name)
+		}
+		groups = groups[i:]
+		if only != "" {
+			groups = groups[:1]
 		}
+	}
+
+	selected := map[config.GroupName]bool{}
+	for _, g := range groups {
+		selected[g.Name] = true
+	}
+	for _, g := range groups {
+		// the images of earlier Packer groups are used without referencing them
+		for _, p := range bp.DeploymentGroups[:bp.GroupIndex(g.Name)] {
+			if p.Kind == config.PackerKind && !selected[p.Name] && !state.IsApplied(p.Name) {
+				return nil, fmt.Errorf("group %s follows Packer group %s, which has not been deployed; deploy it first with --from %s", g.Name, p.Name, p.Name)
+			}
+		}
+		for _, r := range g.FindAllIntergroupReferences(bp) {
+			dep := bp.ModuleGroupOrDie(r.Module).Name
+			if !selected[dep] && !state.IsApplied(dep) && !shell.OutputsExported(artifactsDir, dep) {
+				return nil, fmt.Errorf("group %s uses outputs of group %s, which has not been deployed; deploy it first with --from %s", g.Name, dep, dep)
+			}
+		}
+		for _, dep := range g.DependsOn {
+			if !selected[dep] && !state.IsApplied(dep) {
+				return nil, fmt.Errorf("group %s depends on group %s, which has not been deployed; deploy it first with --from %s", g.Name, dep, dep)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// pendingGroups returns the groups that have not been applied successfully
+func pendingGroups(bp config.Blueprint, state shell.DeployState) []config.GroupName {
+	pending := []config.GroupName{}
+	for _, g := range bp.DeploymentGroups {
+		if !state.IsApplied(g.Name) {
+			pending = append(pending, g.Name)
+		}
+	}
+	return pending
+}
+
+// groupDependencies returns the groups each group must be deployed after,
+// among the groups to deploy: the groups whose outputs it uses, the groups it
+// depends on, and the Packer groups before it, whose images are used without
+// referencing them
+func groupDependencies(bp config.Blueprint, groups []config.DeploymentGroup) map[config.GroupName][]config.GroupName {
+	deployed := map[config.GroupName]bool{}
+	for _, g := range groups {
+		deployed[g.Name] = true
+	}
+	deps := map[config.GroupName][]config.GroupName{}
+	for i, g := range groups {
+		deps[g.Name] = []config.GroupName{}
+		add := func(d config.GroupName) {
+			if deployed[d] && !slices.Contains(deps[g.Name], d) {
+				deps[g.Name] = append(deps[g.Name], d)
+			}
+		}
+		for _, p := range groups[:i] {
+			if p.Kind == config.PackerKind {
+				add(p.Name)
+			}
+		}
+		for _, d := range g.Dependencies(bp) {
+			add(d)
+		}
+		slices.Sort(deps[g.Name])
+	}
+	return deps
+}
+
+// deployGroups deploys the groups in order, up to --parallelism at a time once
+// the groups they depend on are deployed. After a group fails, no more groups
+// are started and the groups being deployed are waited for; the deployment
+// can then be resumed from the first group that was not applied.
+func deployGroups(groups []config.DeploymentGroup, deps map[config.GroupName][]config.GroupName,
+	state shell.DeployState, entry *audit.Entry, deploy func(config.DeploymentGroup) error) error {
+	type result struct {
+		group config.GroupName
+		err   error
+	}
+	results := make(chan result)
+	started := map[config.GroupName]bool{}
+	done := map[config.GroupName]bool{}
+	ready := func(g config.GroupName) bool {
+		for _, d := range deps[g] {
+			if !done[d] {
+				return false
+			}
+		}
+		return true
+	}
+
+	running, succeeded := 0, 0
+	var failure error
+	var failed config.GroupName
+	for {
+		for _, g := range groups {
+			if failure != nil || running >= parallelism {
+				break
+			}
+			if started[g.Name] || !ready(g.Name) {
+				continue
+			}
+			started[g.Name], running = true, running+1
+			entry.AddGroup(string(g.Name))
+			go func(g config.DeploymentGroup) {
+				results <- result{g.Name, deploy(g)}
+			}(g)
+		}
+		if running == 0 {
+			if failed != "" {
+				log.Printf("deployment group %s failed; to resume the deployment, run: ghpc deploy %s --from %s",
+					failed, deploymentRoot, resumeGroup(groups, done))
+			}
+			return failure
+		}
+
+		r := <-results
+		running--
+		if errors.Is(r.err, errNotApplied) {
+			log.Printf("deployment group %s was not applied, the groups that depend on it are not deployed", r.group)
+			continue
+		}
+		if r.err != nil {
+			if failure == nil {
+				failed, failure = r.group, deployError(r.err, succeeded)
+			}
+			continue
+		}
+		done[r.group], succeeded = true, succeeded+1
+		if err := state.MarkApplied(artifactsDir, r.group); err != nil && failure == nil {
+			failure = errcode.New(errcode.WriteFailure, err)
+		}
+	}
+}
+
+// resumeGroup returns the first of the groups that was not applied, from
+// which the deployment is resumed; with --parallelism, groups before the
+// failed group may not have been started
+func resumeGroup(groups []config.DeploymentGroup, done map[config.GroupName]bool) config.GroupName {
+	for _, g := range groups {
+		if !done[g.Name] {
+			return g.Name
+		}
+	}
+	return ""
+}
+
+func deployGroup(dc config.DeploymentConfig, group config.DeploymentGroup, expandedBlueprintFile string) error {
+	groupDir := filepath.Join(deploymentRoot, string(group.Name))
+	if err := runGroupHooks(dc, group, "pre_deploy", group.Hooks.PreDeploy); err != nil {
+		return err
+	}
+	if err := uploadArtifacts(dc.Config.ArtifactsOf(group.Name)); err != nil {
+		return err
+	}
+	if err := shell.ImportInputs(groupDir, artifactsDir, expandedBlueprintFile); err != nil {
+		return err
+	}
+	// the sensitive outputs of earlier groups are inputs of the group
+	outputs, err := exportedOutputs(dc.Config)
+	if err != nil {
+		return err
+	}
+	shell.MaskSecrets(dc.Config.SensitiveOutputValues(outputs))
 
-		var err error
+	policy, err := shell.NewRetryPolicy(group.Retry, maxAttempts)
+	if err != nil {
+		return err
+	}
+	// the whole group is deployed again, as the plan of a failed apply is stale
+	err = policy.Do(fmt.Sprintf("deployment group %s", group.Name), func() error {
 		switch group.Kind {
 		case config.PackerKind:
-			// Packer groups are enforced to have length 1
-			moduleDir := filepath.Join(groupDir, string(group.Modules[0].ID))
-			err = deployPackerGroup(moduleDir)
+			if err := deployPackerGroup(groupDir, group); err != nil {
+				return err
+			}
+			return exportPackerImage(group)
+		case config.HelmKind:
+			return deployHelmGroup(groupDir, group)
 		case config.TerraformKind:
-			err = deployTerraformGroup(groupDir)
+			return deployTerraformGroup(groupDir, group)
 		default:
-			err = fmt.Errorf("group %s is an unsupported kind %s", groupDir, group.Kind.String())
+			return fmt.Errorf("group %s is an unsupported kind %s", groupDir, group.Kind.String())
 		}
+	})
+	if err != nil {
+		return err
+	}
+	return runGroupHooks(dc, group, "post_deploy", group.Hooks.PostDeploy)
+}
+
+// runGroupHooks runs the commands of a hook of the group in the group
+// directory, with the deployment variables and the outputs the group exported,
+// if any, in the environment
+func runGroupHooks(dc config.DeploymentConfig, group config.DeploymentGroup, hook string, commands []string) error {
+	if len(commands) == 0 {
+		return nil
+	}
+	outputs, err := shell.ReadOutputs(artifactsDir, group.Name)
+	if err != nil {
+		return err
+	}
+	env, err := shell.HookEnv(dc.Config, group.Name, deploymentRoot, outputs)
+	if err != nil {
+		return err
+	}
+	return shell.RunHooks(hook, group.Name, commands, filepath.Join(deploymentRoot, string(group.Name)), env)
+}
+
+// exportedOutputs reads the outputs exported by the groups of the deployment
+func exportedOutputs(bp config.Blueprint) (map[config.GroupName]map[string]cty.Value, error) {
+	outputs := map[config.GroupName]map[string]cty.Value{}
+	for _, g := range bp.DeploymentGroups {
+		o, err := shell.ReadOutputs(artifactsDir, g.Name)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		outputs[g.Name] = o
+	}
+	return outputs, nil
+}
 
+// runHealthChecks runs the health probes of the modules of the deployment and
+// reports the readiness of the deployment
+func runHealthChecks(dc config.DeploymentConfig) error {
+	outputs, err := exportedOutputs(dc.Config)
+	if err != nil {
+		return errcode.New(errcode.HealthCheckFailure, err)
+	}
+	checks := health.Checks(dc.Config, outputs)
+	if len(checks) == 0 {
+		return nil
+	}
+
+	log.Printf("running %d health checks, waiting up to %s for them to pass", len(checks), healthCheckTimeout)
+	results := health.Run(context.Background(), checks, healthCheckTimeout)
+	health.WriteSummary(shell.ConsoleOutput(), results)
+	failed := 0
+	for _, r := range results {
+		if !r.Ready() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errcode.New(errcode.HealthCheckFailure,
+			fmt.Errorf("%d of %d health checks failed, the deployment is not ready", failed, len(results)))
 	}
 	return nil
 }
 
-func deployPackerGroup(moduleDir string) error {
+// beginAudit starts the audit log entry of an action on the deployment
+func beginAudit(action audit.Action, dc config.DeploymentConfig, expandedBlueprintFile string) (*audit.Entry, error) {
+	name, err := dc.Config.DeploymentName()
+	if err != nil {
+		return nil, errcode.New(errcode.ConfigError, err)
+	}
+	entry, err := audit.Begin(action, name, dc.Config.BlueprintName, expandedBlueprintFile)
+	if err != nil {
+		return nil, errcode.New(errcode.ConfigError, err)
+	}
+	entry.GhpcVersion = dc.Config.GhpcVersion
+	entry.Profile = dc.Config.Profile
+	return entry, nil
+}
+
+// auditProject returns the project the audit log entry is written to
+func auditProject(dc config.DeploymentConfig) (string, error) {
+	if auditLogProject != "" {
+		return auditLogProject, nil
+	}
+	v := dc.Config.Vars.Get("project_id")
+	if v.IsNull() || v.Type() != cty.String {
+		return "", fmt.Errorf("deployment variable project_id must be a string, set --audit-log-project instead")
+	}
+	return v.AsString(), nil
+}
+
+// endAudit records the outcome of the action and, if requested, writes the
+// audit log entry. Failing to write the entry does not change the outcome.
+func endAudit(entry *audit.Entry, dc config.DeploymentConfig, err error) {
+	entry.End(err)
+	if !auditLog && auditLogProject == "" {
+		return
+	}
+	project, perr := auditProject(dc)
+	if perr == nil {
+		perr = audit.Write(context.Background(), project, *entry)
+	}
+	if perr != nil {
+		log.Printf("WARNING: failed to write audit log entry: %v", perr)
+		return
+	}
+	log.Printf("audit log entry written to project %s, log %s", project, audit.LogName)
+}
+
+// deployError classifies the failure of a group as a partial deployment when
+// the given number of groups preceding it had already been processed
+func deployError(err error, processed int) error {
+	if processed > 0 {
+		return errcode.New(errcode.PartialDeploy, err)
+	}
+	return errcode.New(errcode.DeployFailure, err)
+}
+
+// deployPackerGroup builds the images of the Packer modules of the group, in
+// the order of their dependencies. If a build is declined, the later builds,
+// which may use its image, are not run and errNotApplied is returned.
+func deployPackerGroup(groupDir string, group config.DeploymentGroup) error {
 	if err := shell.ConfigurePacker(); err != nil {
 		return err
 	}
-	c := shell.ProposedChanges{
-		Summary: fmt.Sprintf("Proposed change: use packer to build image in %s", moduleDir),
-		Full:    fmt.Sprintf("Proposed change: use packer to build image in %s", moduleDir),
+	modules, err := group.PackerBuildOrder()
+	if err != nil {
+		return err
 	}
-	buildImage := applyBehavior == shell.AutomaticApply || shell.ApplyChangesChoice(c)
-	if buildImage {
+	for _, mod := range modules {
+		moduleDir := filepath.Join(groupDir, string(mod.ID))
+		c := shell.ProposedChanges{
+			Summary: fmt.Sprintf("Proposed change: use packer to build image in %s", moduleDir),
+			Full:    fmt.Sprintf("Proposed change: use packer to build image in %s", moduleDir),
+		}
+		if applyBehavior != shell.AutomaticApply && !shell.ApplyChangesChoice(c) {
+			return errNotApplied
+		}
 		log.Printf("initializing packer module at %s", moduleDir)
-		if err := shell.ExecPackerCmd(moduleDir, false, "init", "."); err != nil {
+		if err := shell.ExecPackerCmd(moduleDir, false, shell.PackerArgs(groupDir, "init")...); err != nil {
 			return err
 		}
 		log.Printf("validating packer module at %s", moduleDir)
-		if err := shell.ExecPackerCmd(moduleDir, false, "validate", "."); err != nil {
+		if err := shell.ExecPackerCmd(moduleDir, false, shell.PackerArgs(groupDir, "validate")...); err != nil {
 			return err
 		}
 		log.Printf("building image using packer module at %s", moduleDir)
-		if err := shell.ExecPackerCmd(moduleDir, true, "build", "."); err != nil {
+		if err := shell.ExecPackerCmd(moduleDir, true, shell.PackerArgs(groupDir, "build")...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deployHelmGroup installs or upgrades the releases of the charts of the group,
+// in order, in the cluster of the current kubectl context
+func deployHelmGroup(groupDir string, group config.DeploymentGroup) error {
+	if err := shell.ConfigureHelm(); err != nil {
+		return err
+	}
+	for _, mod := range group.Modules {
+		moduleDir := filepath.Join(groupDir, string(mod.ID))
+		c := shell.ProposedChanges{
+			Summary: fmt.Sprintf("Proposed change: use helm to install or upgrade release %s of chart %s", config.HelmRelease(mod.ID), moduleDir),
+			Full:    fmt.Sprintf("Proposed change: use helm to install or upgrade release %s of chart %s", config.HelmRelease(mod.ID), moduleDir),
+		}
+		if applyBehavior != shell.AutomaticApply && !shell.ApplyChangesChoice(c) {
+			continue
+		}
+		log.Printf("installing helm chart at %s", moduleDir)
+		if err := shell.ExecHelmCmd(groupDir, true, shell.HelmInstallArgs(groupDir, group, mod)...); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func deployTerraformGroup(groupDir string) error {
-	tf, err := shell.ConfigureTerraform(groupDir)
+// exportPackerImage exports the images built by the Packer group, if modules
+// of later groups use them
+func exportPackerImage(group config.DeploymentGroup) error {
+	used := slices.ContainsFunc(group.Modules, func(m config.Module) bool { return len(m.Outputs) > 0 })
+	if !used {
+		return nil
+	}
+	return shell.ExportPackerOutputs(deploymentRoot, artifactsDir, group)
+}
+
+func deployTerraformGroup(groupDir string, group config.DeploymentGroup) error {
+	tf, err := shell.ConfigureGroupTerraform(groupDir, group)
 	if err != nil {
 		return err
 	}
+	if err := shell.MigrateBackend(tf, artifactsDir, group.Name); err != nil {
+		return err
+	}
+	if _, err := shell.BackupState(tf, deploymentRoot, group.Name); err != nil {
+		return err
+	}
 
 	if err = shell.ExportOutputs(tf, artifactsDir, applyBehavior); err != nil {
 		return err
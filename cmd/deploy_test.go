@@ -17,9 +17,21 @@ limitations under the License.
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/audit"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/shell"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
 	. "gopkg.in/check.v1"
 )
 
@@ -28,9 +40,304 @@ func (s *MySuite) TestDeployGroups(c *C) {
 	var err error
 	pathEnv := os.Getenv("PATH")
 	os.Setenv("PATH", "")
-	err = deployTerraformGroup(".")
+	err = deployTerraformGroup(".", config.DeploymentGroup{Name: "primary"})
 	c.Assert(err, NotNil)
-	err = deployPackerGroup(".")
+	err = deployPackerGroup(".", config.DeploymentGroup{Name: "image", Kind: config.PackerKind})
 	c.Assert(err, NotNil)
 	os.Setenv("PATH", pathEnv)
 }
+
+func (s *MySuite) TestDeployError(c *C) {
+	err := errors.New("apply failed")
+	c.Check(errcode.Of(deployError(err, 0)), Equals, errcode.DeployFailure)
+	c.Check(errcode.Of(deployError(err, 2)), Equals, errcode.PartialDeploy)
+	c.Check(deployError(nil, 2), IsNil)
+}
+
+func (s *MySuite) TestSelectGroups(c *C) {
+	bp := config.Blueprint{DeploymentGroups: []config.DeploymentGroup{
+		{Name: "primary", Modules: []config.Module{{ID: "network"}}},
+		{Name: "image", Modules: []config.Module{{ID: "packer"}}},
+		{Name: "cluster", Modules: []config.Module{{
+			ID: "nodes",
+			Settings: config.NewDict(map[string]cty.Value{
+				"network": config.ModuleRef("network", "network_self_link").AsExpression().AsValue(),
+			}),
+		}}},
+	}}
+	names := func(gs []config.DeploymentGroup) []config.GroupName {
+		res := []config.GroupName{}
+		for _, g := range gs {
+			res = append(res, g.Name)
+		}
+		return res
+	}
+	state := shell.DeployState{Applied: map[config.GroupName]time.Time{}}
+	artifactsDir := c.MkDir()
+
+	gs, err := selectGroups(bp, state, artifactsDir, "", "")
+	c.Assert(err, IsNil)
+	c.Check(names(gs), DeepEquals, []config.GroupName{"primary", "image", "cluster"})
+
+	gs, err = selectGroups(bp, state, artifactsDir, "image", "")
+	c.Assert(err, IsNil)
+	c.Check(names(gs), DeepEquals, []config.GroupName{"image"})
+
+	_, err = selectGroups(bp, state, artifactsDir, "", "missing")
+	c.Check(err, ErrorMatches, "deployment group missing does not exist")
+
+	// cluster uses outputs of primary, which has not been applied
+	_, err = selectGroups(bp, state, artifactsDir, "", "image")
+	c.Check(err, ErrorMatches, "group cluster uses outputs of group primary, .*")
+
+	// or whose outputs were exported after applying it manually
+	c.Assert(os.WriteFile(filepath.Join(artifactsDir, "primary_outputs.tfvars"), []byte{}, 0644), IsNil)
+	gs, err = selectGroups(bp, state, artifactsDir, "", "image")
+	c.Assert(err, IsNil)
+	c.Check(names(gs), DeepEquals, []config.GroupName{"image", "cluster"})
+	c.Assert(os.Remove(filepath.Join(artifactsDir, "primary_outputs.tfvars")), IsNil)
+
+	state.Applied["primary"] = time.Now()
+	gs, err = selectGroups(bp, state, artifactsDir, "", "image")
+	c.Assert(err, IsNil)
+	c.Check(names(gs), DeepEquals, []config.GroupName{"image", "cluster"})
+	c.Check(pendingGroups(bp, state), DeepEquals, []config.GroupName{"image", "cluster"})
+
+	// monitoring depends on cluster, which has not been applied
+	bp.DeploymentGroups = append(bp.DeploymentGroups, config.DeploymentGroup{
+		Name: "monitoring", DependsOn: []config.GroupName{"cluster"}})
+	_, err = selectGroups(bp, state, artifactsDir, "monitoring", "")
+	c.Check(err, ErrorMatches, "group monitoring depends on group cluster, which has not been deployed; .*")
+
+	// cluster follows the Packer group image, which has not been applied
+	bp.DeploymentGroups[1].Kind = config.PackerKind
+	_, err = selectGroups(bp, state, artifactsDir, "cluster", "")
+	c.Check(err, ErrorMatches, "group cluster follows Packer group image, which has not been deployed; deploy it first with --from image")
+	state.Applied["image"] = time.Now()
+	gs, err = selectGroups(bp, state, artifactsDir, "cluster", "")
+	c.Assert(err, IsNil)
+	c.Check(names(gs), DeepEquals, []config.GroupName{"cluster"})
+}
+
+func (s *MySuite) TestResumeGroup(c *C) {
+	groups := []config.DeploymentGroup{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	// b failed while c ran concurrently; a was never started
+	c.Check(resumeGroup(groups, map[config.GroupName]bool{"c": true}), Equals, config.GroupName("a"))
+	c.Check(resumeGroup(groups, map[config.GroupName]bool{"a": true}), Equals, config.GroupName("b"))
+	c.Check(resumeGroup(groups, map[config.GroupName]bool{"a": true, "b": true, "c": true}), Equals, config.GroupName(""))
+}
+
+func (s *MySuite) TestGroupDependencies(c *C) {
+	ref := func(m config.ModuleID) cty.Value {
+		return config.ModuleRef(m, "id").AsExpression().AsValue()
+	}
+	bp := config.Blueprint{DeploymentGroups: []config.DeploymentGroup{
+		{Name: "network", Kind: config.TerraformKind, Modules: []config.Module{{ID: "vpc"}}},
+		{Name: "storage", Kind: config.TerraformKind, Modules: []config.Module{{
+			ID: "fs", Settings: config.NewDict(map[string]cty.Value{"network": ref("vpc")})}}},
+		{Name: "image", Kind: config.PackerKind, Modules: []config.Module{{ID: "packer"}}},
+		{Name: "cluster", Kind: config.TerraformKind, Modules: []config.Module{{
+			ID: "nodes", Settings: config.NewDict(map[string]cty.Value{"network": ref("vpc"), "fs": ref("fs")})}}},
+	}}
+
+	c.Check(groupDependencies(bp, bp.DeploymentGroups), DeepEquals, map[config.GroupName][]config.GroupName{
+		"network": {},
+		"storage": {"network"},
+		"image":   {},
+		"cluster": {"image", "network", "storage"},
+	})
+	// groups that are not deployed are not waited for
+	c.Check(groupDependencies(bp, bp.DeploymentGroups[2:]), DeepEquals, map[config.GroupName][]config.GroupName{
+		"image":   {},
+		"cluster": {"image"},
+	})
+
+	// explicit dependencies are waited for like references
+	bp.DeploymentGroups = append(bp.DeploymentGroups, config.DeploymentGroup{
+		Name: "monitoring", Kind: config.TerraformKind, DependsOn: []config.GroupName{"storage", "cluster"}})
+	c.Check(groupDependencies(bp, bp.DeploymentGroups[3:])["monitoring"], DeepEquals, []config.GroupName{"cluster"})
+
+	// and destroyed after the groups that depend on them
+	c.Check(destroyOrder(bp)[0].Name, Equals, config.GroupName("monitoring"))
+}
+
+func (s *MySuite) TestSelectDestroyGroups(c *C) {
+	ref := func(m config.ModuleID) cty.Value {
+		return config.ModuleRef(m, "id").AsExpression().AsValue()
+	}
+	bp := config.Blueprint{DeploymentGroups: []config.DeploymentGroup{
+		{Name: "network", Kind: config.TerraformKind, Modules: []config.Module{{ID: "vpc"}}},
+		{Name: "image", Kind: config.PackerKind, Modules: []config.Module{{ID: "packer"}}},
+		{Name: "cluster", Kind: config.TerraformKind, Modules: []config.Module{{
+			ID: "nodes", Settings: config.NewDict(map[string]cty.Value{"network": ref("vpc")})}}},
+	}}
+	names := func(gs []config.DeploymentGroup) []config.GroupName {
+		res := []config.GroupName{}
+		for _, g := range gs {
+			res = append(res, g.Name)
+		}
+		return res
+	}
+	state := shell.DeployState{Applied: map[config.GroupName]time.Time{"network": time.Now()}}
+
+	gs, err := selectDestroyGroups(bp, state, "", false)
+	c.Assert(err, IsNil)
+	c.Check(names(gs), DeepEquals, []config.GroupName{"cluster", "image", "network"})
+
+	gs, err = selectDestroyGroups(bp, state, "network", false)
+	c.Assert(err, IsNil)
+	c.Check(names(gs), DeepEquals, []config.GroupName{"network"})
+
+	_, err = selectDestroyGroups(bp, state, "missing", false)
+	c.Check(err, ErrorMatches, "deployment group missing does not exist")
+
+	// cluster uses the outputs of network and is deployed
+	state.Applied["cluster"] = time.Now()
+	_, err = selectDestroyGroups(bp, state, "network", false)
+	c.Check(err, ErrorMatches, `deployed groups \[cluster\] depend on group network; .*`)
+	gs, err = selectDestroyGroups(bp, state, "network", true)
+	c.Assert(err, IsNil)
+	c.Check(names(gs), DeepEquals, []config.GroupName{"network"})
+
+	// monitoring depends on image and is deployed
+	bp.DeploymentGroups = append(bp.DeploymentGroups, config.DeploymentGroup{
+		Name: "monitoring", Kind: config.TerraformKind, DependsOn: []config.GroupName{"image"}})
+	state.Applied["monitoring"] = time.Now()
+	_, err = selectDestroyGroups(bp, state, "image", false)
+	c.Check(err, ErrorMatches, `deployed groups \[monitoring\] depend on group image; .*`)
+}
+
+func (s *MySuite) TestDeployGroupsConcurrently(c *C) {
+	defer func(d string, p int) { artifactsDir, parallelism = d, p }(artifactsDir, parallelism)
+	artifactsDir, parallelism = c.MkDir(), 2
+
+	groups := []config.DeploymentGroup{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	deps := map[config.GroupName][]config.GroupName{"a": {}, "b": {}, "c": {"a"}, "d": {"b", "c"}}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	finished := []config.GroupName{}
+	deploy := func(g config.DeploymentGroup) error {
+		mu.Lock()
+		for _, d := range deps[g.Name] {
+			c.Check(slices.Contains(finished, d), Equals, true, Commentf("%s started before %s finished", g.Name, d))
+		}
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		finished = append(finished, g.Name)
+		mu.Unlock()
+		if g.Name == "fail" {
+			return errors.New("apply failed")
+		}
+		return nil
+	}
+
+	state := shell.DeployState{Applied: map[config.GroupName]time.Time{}}
+	c.Assert(deployGroups(groups, deps, state, &audit.Entry{}, deploy), IsNil)
+	c.Check(maxRunning, Equals, 2)
+	c.Check(finished, HasLen, 4)
+	c.Check(pendingGroups(config.Blueprint{DeploymentGroups: groups}, state), DeepEquals, []config.GroupName{})
+
+	// groups depending on a failed group are not deployed
+	groups[0].Name, deps["c"] = "fail", []config.GroupName{"fail"}
+	delete(deps, "a")
+	deps["fail"] = []config.GroupName{}
+	finished = []config.GroupName{}
+	state = shell.DeployState{Applied: map[config.GroupName]time.Time{}}
+	err := deployGroups(groups, deps, state, &audit.Entry{}, deploy)
+	c.Check(err, ErrorMatches, "apply failed")
+	c.Check(state.IsApplied("b"), Equals, true)
+	c.Check(state.IsApplied("c"), Equals, false)
+	c.Check(state.IsApplied("d"), Equals, false)
+}
+
+func (s *MySuite) TestDeployGroupsNotApplied(c *C) {
+	defer func(d string, p int) { artifactsDir, parallelism = d, p }(artifactsDir, parallelism)
+	artifactsDir, parallelism = c.MkDir(), 1
+
+	groups := []config.DeploymentGroup{{Name: "image"}, {Name: "network"}, {Name: "cluster"}}
+	deps := map[config.GroupName][]config.GroupName{"image": {}, "network": {}, "cluster": {"image", "network"}}
+	deployed := []config.GroupName{}
+	deploy := func(g config.DeploymentGroup) error {
+		deployed = append(deployed, g.Name)
+		if g.Name == "image" {
+			return fmt.Errorf("group %s: %w", g.Name, errNotApplied)
+		}
+		return nil
+	}
+
+	// a declined group is neither a failure nor applied, and the groups
+	// depending on it are not deployed
+	state := shell.DeployState{Applied: map[config.GroupName]time.Time{}}
+	c.Check(deployGroups(groups, deps, state, &audit.Entry{}, deploy), IsNil)
+	c.Check(deployed, DeepEquals, []config.GroupName{"image", "network"})
+	c.Check(state.IsApplied("image"), Equals, false)
+	c.Check(state.IsApplied("network"), Equals, true)
+	c.Check(pendingGroups(config.Blueprint{DeploymentGroups: groups}, state), DeepEquals,
+		[]config.GroupName{"image", "cluster"})
+}
+
+func (s *MySuite) TestWithGroupEvents(c *C) {
+	var buf bytes.Buffer
+	shell.StreamEvents(&buf)
+	defer shell.StreamEvents(nil)
+
+	err := withGroupEvents("primary", func() error { return errors.New("apply failed") })
+	c.Check(err, ErrorMatches, "apply failed")
+	emitFinished("deploy", err)
+
+	var events []shell.Event
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e shell.Event
+		c.Assert(dec.Decode(&e), IsNil)
+		e.Time = time.Time{}
+		events = append(events, e)
+	}
+	c.Check(events, DeepEquals, []shell.Event{
+		{Type: shell.EventGroupStarted, Group: "primary"},
+		{Type: shell.EventGroupFinished, Group: "primary", Status: shell.StatusFailed, Message: "apply failed"},
+		{Type: shell.EventFinished, Command: "deploy", Status: shell.StatusFailed, Message: "apply failed"},
+	})
+}
+
+func (s *MySuite) TestSetOutputFormat(c *C) {
+	defer func(f string, b shell.ApplyBehavior) { outputFormat, applyBehavior = f, b }(outputFormat, applyBehavior)
+	defer shell.StreamEvents(nil)
+
+	outputFormat, applyBehavior = "json", shell.PromptBeforeApply
+	c.Check(setOutputFormat(), NotNil)
+	outputFormat = "yaml"
+	c.Check(setOutputFormat(), NotNil)
+	outputFormat, applyBehavior = "json", shell.AutomaticApply
+	c.Check(setOutputFormat(), IsNil)
+	c.Check(shell.EventsEnabled(), Equals, true)
+}
+
+func (s *MySuite) TestStatusReport(c *C) {
+	report := deploymentStatus{Deployment: "golden", Drifted: true, Groups: []shell.GroupStatus{
+		{Group: "primary", Status: shell.StatusDrifted, Drift: []shell.DriftedResource{
+			{Address: "google_storage_bucket.logs", Action: "delete"}}},
+		{Group: "image", Status: shell.StatusSkipped},
+	}}
+	var buf bytes.Buffer
+	c.Assert(writeStatusText(&buf, report), IsNil)
+	c.Check(buf.String(), Equals, `GROUP    STATUS   DRIFTED RESOURCES
+primary  drifted  1
+image    skipped  0
+primary: google_storage_bucket.logs (delete)
+`)
+	c.Check(errcode.Of(statusError(report)), Equals, errcode.DriftDetected)
+
+	report.Groups = append(report.Groups, shell.GroupStatus{Group: "cluster", Status: shell.StatusError, Error: "no credentials"})
+	err := statusError(report)
+	c.Check(err, ErrorMatches, `failed to determine the status of groups \[cluster\]`)
+
+	c.Check(statusError(deploymentStatus{Groups: []shell.GroupStatus{{Group: "primary", Status: shell.StatusInSync}}}), IsNil)
+}
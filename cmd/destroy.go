@@ -17,13 +17,15 @@ package cmd
 
 import (
 	"fmt"
+	"hpc-toolkit/pkg/audit"
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/modulewriter"
 	"hpc-toolkit/pkg/shell"
-	"os"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
 )
 
 func init() {
@@ -32,12 +34,19 @@ func init() {
 	destroyCmd.MarkFlagDirname(artifactsFlag)
 
 	destroyCmd.Flags().BoolVar(&autoApprove, "auto-approve", false, "Automatically approve proposed changes")
+	destroyCmd.Flags().BoolVar(&auditLog, "audit-log", false, msgAuditLog)
+	destroyCmd.Flags().StringVar(&auditLogProject, "audit-log-project", "", msgAuditLogProject)
+	addOutputFlag(destroyCmd)
+	destroyCmd.Flags().StringVar(&onlyGroup, "only", "", "Destroy only this deployment group")
+	destroyCmd.Flags().BoolVar(&forceDestroy, "force", false,
+		"With --only, destroy the group even if deployed groups use its outputs")
 
 	rootCmd.AddCommand(destroyCmd)
 }
 
 var (
-	destroyCmd = &cobra.Command{
+	forceDestroy bool
+	destroyCmd   = &cobra.Command{
 		Use:               "destroy DEPLOYMENT_DIRECTORY",
 		Short:             "destroy all resources in a Toolkit deployment directory.",
 		Long:              "destroy all resources in a Toolkit deployment directory.",
@@ -58,6 +67,9 @@ func parseDestroyArgs(cmd *cobra.Command, args []string) error {
 	if isDir, _ := shell.DirInfo(artifactsDir); !isDir {
 		return fmt.Errorf("artifacts path %s is not a directory", artifactsDir)
 	}
+	if err := setOutputFormat(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -70,41 +82,195 @@ func runDestroyCmd(cmd *cobra.Command, args []string) error {
 	}
 
 	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := shell.UseTerraformCLIConfig(deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := useVault(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	secrets, err := shell.UseSecretVars(dc.Config)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	remoteOutputs, err := shell.UseRemoteOutputVars(dc.Config)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	shell.MaskSecrets(append(append(dc.Config.SensitiveValues(), secrets...), remoteOutputs...))
+
+	state, err := shell.ReadDeployState(artifactsDir)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	groups, err := selectDestroyGroups(dc.Config, state, onlyGroup, forceDestroy)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	entry, err := beginAudit(audit.Destroy, dc, expandedBlueprintFile)
+	if err != nil {
 		return err
 	}
+	err = destroyGroups(dc, groups, state, entry)
+	endAudit(entry, dc, err)
+	emitFinished("destroy", err)
+	return err
+}
 
-	// destroy in reverse order of creation!
+// selectDestroyGroups returns the groups to destroy, in the order they are
+// destroyed: all groups, or only the group set with --only. A group whose
+// outputs are used by deployed groups, or that deployed groups depend on, is
+// only destroyed alone if forced.
+func selectDestroyGroups(bp config.Blueprint, state shell.DeployState, only string, force bool) ([]config.DeploymentGroup, error) {
+	if only == "" {
+		return destroyOrder(bp), nil
+	}
+	i := bp.GroupIndex(config.GroupName(only))
+	if i < 0 {
+		return nil, fmt.Errorf("deployment group %s does not exist", only)
+	}
+	group := bp.DeploymentGroups[i]
+	if dependents := deployedDependents(bp, state, group.Name); len(dependents) > 0 && !force {
+		return nil, fmt.Errorf("deployed groups %v depend on group %s; destroy them first or use --force", dependents, only)
+	}
+	return []config.DeploymentGroup{group}, nil
+}
+
+// destroyOrder returns the groups of the blueprint in reverse dependency
+// order: each group after all groups that depend on it, and otherwise in
+// reverse order of creation
+func destroyOrder(bp config.Blueprint) []config.DeploymentGroup {
+	groups := bp.DeploymentGroups
+	deps := groupDependencies(bp, groups)
+	dependents := map[config.GroupName]int{}
+	for _, ds := range deps {
+		for _, d := range ds {
+			dependents[d]++
+		}
+	}
+
+	order := []config.DeploymentGroup{}
+	done := map[config.GroupName]bool{}
+	for len(order) < len(groups) {
+		next := -1
+		for i := len(groups) - 1; i >= 0; i-- {
+			if !done[groups[i].Name] && dependents[groups[i].Name] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// groups only depend on the groups before them, so this is
+			// unreachable; fall back to reverse order of creation
+			for i := len(groups) - 1; i >= 0; i-- {
+				if !done[groups[i].Name] {
+					order = append(order, groups[i])
+				}
+			}
+			break
+		}
+		g := groups[next]
+		done[g.Name] = true
+		order = append(order, g)
+		for _, d := range deps[g.Name] {
+			dependents[d]--
+		}
+	}
+	return order
+}
+
+// deployedDependents returns the groups that use the outputs of the group or
+// depend on it, and have been deployed
+func deployedDependents(bp config.Blueprint, state shell.DeployState, group config.GroupName) []config.GroupName {
+	dependents := []config.GroupName{}
+	for _, g := range bp.DeploymentGroups {
+		if g.Name == group || !state.IsApplied(g.Name) {
+			continue
+		}
+		if slices.Contains(g.Dependencies(bp), group) {
+			dependents = append(dependents, g.Name)
+		}
+	}
+	return dependents
+}
+
+func destroyGroups(dc config.DeploymentConfig, groups []config.DeploymentGroup, state shell.DeployState, entry *audit.Entry) error {
 	packerManifests := []string{}
-	for i := len(dc.Config.DeploymentGroups) - 1; i >= 0; i-- {
-		group := dc.Config.DeploymentGroups[i]
+	for i, group := range groups {
 		groupDir := filepath.Join(deploymentRoot, string(group.Name))
 
 		var err error
 		switch group.Kind {
 		case config.PackerKind:
-			// Packer groups are enforced to have length 1
 			// TODO: destroyPackerGroup(moduleDir)
-			moduleDir := filepath.Join(groupDir, string(group.Modules[0].ID))
-			packerManifests = append(packerManifests, filepath.Join(moduleDir, "packer-manifest.json"))
-		case config.TerraformKind:
-			err = destroyTerraformGroup(groupDir)
+			for _, mod := range group.Modules {
+				moduleDir := filepath.Join(groupDir, string(mod.ID))
+				packerManifests = append(packerManifests, filepath.Join(moduleDir, modulewriter.PackerManifestName))
+			}
+		case config.TerraformKind, config.HelmKind:
+			entry.AddGroup(string(group.Name))
+			err = withGroupEvents(group.Name, func() error {
+				return withGroupLog(group.Name, "destroy", func() error {
+					destroy := func() error { return destroyTerraformGroup(groupDir, group) }
+					if group.Kind == config.HelmKind {
+						destroy = func() error { return destroyHelmGroup(groupDir, group) }
+					}
+					if err := destroy(); err != nil {
+						return err
+					}
+					if err := state.MarkDestroyed(artifactsDir, group.Name); err != nil {
+						return err
+					}
+					return runGroupHooks(dc, group, "post_destroy", group.Hooks.PostDestroy)
+				})
+			})
 		default:
 			err = fmt.Errorf("group %s is an unsupported kind %s", groupDir, group.Kind.String())
 		}
 		if err != nil {
-			return err
+			return deployError(err, i)
 		}
 	}
 
-	modulewriter.WritePackerDestroyInstructions(os.Stdout, packerManifests)
+	modulewriter.WritePackerDestroyInstructions(shell.ConsoleOutput(), packerManifests)
 	return nil
 }
 
-func destroyTerraformGroup(groupDir string) error {
-	tf, err := shell.ConfigureTerraform(groupDir)
+// destroyHelmGroup uninstalls the releases of the charts of the group, in
+// reverse order
+func destroyHelmGroup(groupDir string, group config.DeploymentGroup) error {
+	if err := shell.ConfigureHelm(); err != nil {
+		return err
+	}
+	for i := len(group.Modules) - 1; i >= 0; i-- {
+		mod := group.Modules[i]
+		c := shell.ProposedChanges{
+			Summary: fmt.Sprintf("Proposed change: use helm to uninstall release %s", config.HelmRelease(mod.ID)),
+			Full:    fmt.Sprintf("Proposed change: use helm to uninstall release %s", config.HelmRelease(mod.ID)),
+		}
+		if applyBehavior != shell.AutomaticApply && !shell.ApplyChangesChoice(c) {
+			continue
+		}
+		if err := shell.HelmUninstall(groupDir, group, mod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyTerraformGroup(groupDir string, group config.DeploymentGroup) error {
+	tf, err := shell.ConfigureGroupTerraform(groupDir, group)
 	if err != nil {
 		return err
 	}
+	if err := shell.MigrateBackend(tf, artifactsDir, group.Name); err != nil {
+		return err
+	}
+	if _, err := shell.BackupState(tf, deploymentRoot, group.Name); err != nil {
+		return err
+	}
 
 	return shell.Destroy(tf, applyBehavior)
 }
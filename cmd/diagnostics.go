@@ -0,0 +1,89 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/diagnostics"
+	"hpc-toolkit/pkg/errcode"
+
+	"golang.org/x/exp/slices"
+)
+
+var msgCLIDiagnosticsFormat = "Write the results of validators and policies in this format: " +
+	strings.Join(diagnostics.Formats(), ", ") + "."
+
+const msgCLIDiagnosticsOut = "File the results of validators and policies are written to, - for the standard output."
+
+var (
+	diagnosticsFormat string
+	diagnosticsOut    string
+)
+
+func checkDiagnosticsFormat() error {
+	if diagnosticsFormat != "" && !slices.Contains(diagnostics.Formats(), diagnosticsFormat) {
+		return fmt.Errorf("unknown diagnostics format %q, must be one of %s",
+			diagnosticsFormat, strings.Join(diagnostics.Formats(), ", "))
+	}
+	return nil
+}
+
+// collectDiagnostics makes dc report the results of validators and policies
+// if a diagnostics format is set, and returns the function that writes them
+// once the blueprint is expanded; errors of the expansion other than failed
+// validators are written as "config" diagnostics
+func collectDiagnostics(dc *config.DeploymentConfig) func(error) error {
+	if diagnosticsFormat == "" {
+		return func(err error) error { return err }
+	}
+	diags := []diagnostics.Diagnostic{}
+	dc.Report = func(d diagnostics.Diagnostic) { diags = append(diags, d) }
+	return func(err error) error {
+		if err != nil && errcode.Of(err) != errcode.ValidationFailure {
+			diags = append(diags, diagnostics.Diagnostic{
+				RuleID: "config", Severity: diagnostics.Error, Message: err.Error(), File: dc.File})
+		}
+		if werr := writeDiagnostics(diags); werr != nil && err == nil {
+			return errcode.New(errcode.WriteFailure, werr)
+		}
+		return err
+	}
+}
+
+func writeDiagnostics(diags []diagnostics.Diagnostic) error {
+	var w io.Writer = os.Stdout
+	if diagnosticsOut != "-" {
+		f, err := os.Create(diagnosticsOut)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return diagnostics.Write(w, diagnosticsFormat, diags, GitTagVersion)
+}
+
+// diagnosticsToStdout returns whether diagnostics are written to the standard
+// output, which other messages must then not be written to
+func diagnosticsToStdout() bool {
+	return diagnosticsFormat != "" && diagnosticsOut == "-"
+}
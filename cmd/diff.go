@@ -0,0 +1,76 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/config"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	diffCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	diffCmd.Flags().StringSliceVar(&varsFiles, "vars-file", nil, msgCLIVarsFile)
+	diffCmd.Flags().StringSliceVar(&cliBEConfigVars, "backend-config", nil, msgCLIBackendConfig)
+	diffCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
+	diffCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	diffCmd.Flags().BoolVar(&allowExec, "allow-exec", false, msgCLIAllowExec)
+	diffCmd.Flags().BoolVar(&noCache, "no-cache", false, msgCLINoCache)
+	diffCmd.Flags().DurationVar(&cache.ValidatorTTL, "validator-cache-ttl", cache.ValidatorTTL, msgCLIValidatorCacheTTL)
+	addToolkitLabelsFlags(diffCmd)
+	rootCmd.AddCommand(diffCmd)
+}
+
+var (
+	diffCmd = &cobra.Command{
+		Use:   "diff BLUEPRINT_NAME DEPLOYMENT_DIRECTORY",
+		Short: "Show the changes of a blueprint to an existing deployment.",
+		Long: "Expand the blueprint as create does and compare it to the expanded blueprint of an existing " +
+			"deployment, showing the deployment groups and modules added and removed, and the module settings " +
+			"and Terraform backends changed, without writing the deployment.",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: filterYaml,
+		RunE:              runDiffCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runDiffCmd(cmd *cobra.Command, args []string) error {
+	expandedBlueprintFile := filepath.Join(args[1], defaultArtifactsDir, expandedBlueprintFilename)
+	prev, err := config.NewDeploymentConfig(expandedBlueprintFile)
+	if err != nil {
+		return err
+	}
+	dc := expandOrDie(args[0])
+	writeChanges(os.Stdout, dc.Config.Diff(prev.Config))
+	return nil
+}
+
+func writeChanges(w io.Writer, changes []config.Change) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		return
+	}
+	for _, c := range changes {
+		fmt.Fprintln(w, c)
+	}
+}
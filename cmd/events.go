@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/shell"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+const msgOutputFormat = "Format of the progress written to the standard output: \"text\", or \"json\" for newline-delimited JSON events; \"json\" requires --auto-approve"
+
+var outputFormat string
+
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&outputFormat, "output", "text", msgOutputFormat)
+}
+
+// setOutputFormat streams the events of the deployment to the standard output
+// if --output is json
+func setOutputFormat() error {
+	switch outputFormat {
+	case "text":
+		return nil
+	case "json":
+		// the questions of prompts would be mixed with the events
+		if applyBehavior != shell.AutomaticApply {
+			return errcode.New(errcode.ConfigError, fmt.Errorf("--output json requires --auto-approve"))
+		}
+		shell.StreamEvents(os.Stdout)
+		return nil
+	default:
+		return errcode.New(errcode.ConfigError, fmt.Errorf("--output must be \"text\" or \"json\", got %q", outputFormat))
+	}
+}
+
+// withGroupEvents runs f, the deployment or destruction of a group, between
+// the events of the start and end of the group
+func withGroupEvents(group config.GroupName, f func() error) error {
+	shell.EmitEvent(shell.Event{Type: shell.EventGroupStarted, Group: group})
+	err := f()
+	shell.EmitEvent(finishedEvent(shell.EventGroupFinished, err, shell.Event{Group: group}))
+	return err
+}
+
+// emitFinished writes the event of the end of the command
+func emitFinished(command string, err error) {
+	shell.EmitEvent(finishedEvent(shell.EventFinished, err, shell.Event{Command: command}))
+}
+
+func finishedEvent(typ string, err error, e shell.Event) shell.Event {
+	e.Type, e.Status = typ, shell.StatusSucceeded
+	if err != nil {
+		e.Status, e.Message = shell.StatusFailed, err.Error()
+	}
+	return e
+}
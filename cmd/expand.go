@@ -17,6 +17,9 @@ package cmd
 
 import (
 	"fmt"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/errcode"
+	"os"
 
 	"github.com/spf13/cobra"
 )
@@ -29,14 +32,30 @@ func init() {
 	expandCmd.Flags().StringVarP(&outputFilename, "out", "o", "expanded.yaml",
 		"Output file for the expanded HPC Environment Definition.")
 	expandCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	expandCmd.Flags().StringSliceVar(&varsFiles, "vars-file", nil, msgCLIVarsFile)
+	expandCmd.Flags().BoolVar(&interactive, "interactive", false, msgCLIInteractive)
+	expandCmd.Flags().StringVar(&profile, "profile", "", msgCLIProfile)
 	expandCmd.Flags().StringSliceVar(&cliBEConfigVars, "backend-config", nil, msgCLIBackendConfig)
 	expandCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
 	expandCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	expandCmd.Flags().BoolVar(&allowExec, "allow-exec", false, msgCLIAllowExec)
+	expandCmd.Flags().BoolVar(&noCache, "no-cache", false, msgCLINoCache)
+	expandCmd.Flags().DurationVar(&cache.ValidatorTTL, "validator-cache-ttl", cache.ValidatorTTL, msgCLIValidatorCacheTTL)
+	expandCmd.Flags().BoolVar(&frozenLockfile, "frozen-lockfile", false, msgCLIFrozenLockfile)
+	expandCmd.Flags().BoolVar(&reorderGroups, "reorder-groups", false, msgCLIReorderGroups)
+	expandCmd.Flags().StringVar(&policyBundle, "policy-bundle", "", msgCLIPolicyBundle)
+	addEnableApisFlag(expandCmd)
+	addToolkitLabelsFlags(expandCmd)
+	expandCmd.Flags().StringVar(&diagnosticsFormat, "diagnostics-format", "", msgCLIDiagnosticsFormat)
+	expandCmd.Flags().StringVar(&diagnosticsOut, "diagnostics-out", "-", msgCLIDiagnosticsOut)
+	expandCmd.Flags().BoolVar(&showSecrets, "show-secrets", false,
+		"Write the values of sensitive deployment variables and module settings to the expanded blueprint.")
 	rootCmd.AddCommand(expandCmd)
 }
 
 var (
 	outputFilename string
+	showSecrets    bool
 	expandCmd      = &cobra.Command{
 		Use:               "expand BLUEPRINT_NAME",
 		Short:             "Expand the Environment Blueprint.",
@@ -49,6 +68,13 @@ var (
 
 func runExpandCmd(cmd *cobra.Command, args []string) {
 	dc := expandOrDie(args[0])
-	cobra.CheckErr(dc.ExportBlueprint(outputFilename))
-	fmt.Printf("Expanded Environment Definition created successfully, saved as %s.\n", outputFilename)
+	if !showSecrets {
+		dc.Config = dc.Config.Redacted()
+	}
+	checkErr(errcode.New(errcode.WriteFailure, dc.ExportBlueprint(outputFilename)))
+	out := os.Stdout
+	if diagnosticsToStdout() {
+		out = os.Stderr
+	}
+	fmt.Fprintf(out, "Expanded Environment Definition created successfully, saved as %s.\n", outputFilename)
 }
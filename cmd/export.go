@@ -18,6 +18,7 @@ package cmd
 import (
 	"fmt"
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/modulewriter"
 	"hpc-toolkit/pkg/shell"
 	"path/filepath"
@@ -29,6 +30,7 @@ func init() {
 	artifactsFlag := "artifacts"
 	exportCmd.Flags().StringVarP(&artifactsDir, artifactsFlag, "a", "", "Artifacts output directory (automatically configured if unset)")
 	exportCmd.MarkFlagDirname(artifactsFlag)
+	exportCmd.Flags().StringVar(&outputsFormat, "format", "json", "Format of the file the outputs of the deployment are written to, \"json\" or \"yaml\"")
 	rootCmd.AddCommand(exportCmd)
 }
 
@@ -37,11 +39,13 @@ var defaultArtifactsDir = filepath.Join(modulewriter.HiddenGhpcDirName, modulewr
 const expandedBlueprintFilename string = "expanded_blueprint.yaml"
 
 var (
-	artifactsDir string
-	exportCmd    = &cobra.Command{
-		Use:               "export-outputs DEPLOYMENT_GROUP_DIRECTORY",
-		Short:             "Export outputs from deployment group.",
-		Long:              "Export output values from deployment group to other deployment groups that depend upon them.",
+	artifactsDir  string
+	outputsFormat string
+	exportCmd     = &cobra.Command{
+		Use:   "export-outputs DEPLOYMENT_GROUP_DIRECTORY",
+		Short: "Export outputs from deployment group.",
+		Long: "Export output values from deployment group to other deployment groups that depend upon them. " +
+			"The outputs of the deployment selected by the outputs block of the blueprint are written to a single file in the artifacts directory.",
 		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
 		ValidArgsFunction: matchDirs,
 		PreRun:            parseExportImportArgs,
@@ -81,9 +85,12 @@ func getArtifactsDir(deploymentRoot string) string {
 func runExportCmd(cmd *cobra.Command, args []string) error {
 	groupDir := filepath.Clean(args[0])
 	deploymentGroup := config.GroupName(filepath.Base(args[0]))
+	if outputsFormat != "json" && outputsFormat != "yaml" {
+		return errcode.New(errcode.ConfigError, fmt.Errorf("--format must be \"json\" or \"yaml\", got %q", outputsFormat))
+	}
 
 	if err := shell.CheckWritableDir(artifactsDir); err != nil {
-		return err
+		return errcode.New(errcode.WriteFailure, err)
 	}
 
 	expandedBlueprintFile := filepath.Join(artifactsDir, expandedBlueprintFilename)
@@ -93,23 +100,33 @@ func runExportCmd(cmd *cobra.Command, args []string) error {
 	}
 
 	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
-		return err
+		return errcode.New(errcode.ConfigError, err)
 	}
+	shell.ArtifactStore = dc.Config.ArtifactStore
 
 	group, err := dc.Config.Group(deploymentGroup)
 	if err != nil {
-		return err
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if group.Kind == config.HelmKind {
+		return errcode.New(errcode.ConfigError, fmt.Errorf("group %s is a Helm group, which has no outputs", group.Name))
 	}
 	if group.Kind == config.PackerKind {
-		return fmt.Errorf("export command is unsupported on Packer modules because they do not have outputs")
+		if err := shell.ExportPackerOutputs(deploymentRoot, artifactsDir, group); err != nil {
+			return errcode.New(errcode.DeployFailure, err)
+		}
+		return nil
 	}
 
 	tf, err := shell.ConfigureTerraform(groupDir)
 	if err != nil {
-		return err
+		return errcode.New(errcode.DeployFailure, err)
 	}
 	if err = shell.ExportOutputs(tf, artifactsDir, shell.NeverApply); err != nil {
-		return err
+		return errcode.New(errcode.DeployFailure, err)
+	}
+	if err := shell.WriteDeploymentOutputs(dc.Config, artifactsDir, outputsFormat); err != nil {
+		return errcode.New(errcode.WriteFailure, err)
 	}
 	return nil
 }
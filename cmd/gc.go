@@ -0,0 +1,63 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/modulewriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	gcCmd.Flags().IntVar(&gcKeep, "keep", 3, "Number of the newest backups of each deployment that are kept.")
+	gcCmd.Flags().DurationVar(&gcOlderThan, "older-than", 0,
+		"Only remove backups older than this, e.g. 720h; all backups but those kept if not set.")
+	rootCmd.AddCommand(gcCmd)
+}
+
+var (
+	gcKeep      int
+	gcOlderThan time.Duration
+	gcCmd       = &cobra.Command{
+		Use:   "gc DEPLOYMENT_DIRECTORY...",
+		Short: "Remove old backups of deployments.",
+		Long: "Remove the backups of deployment directories made by `ghpc create --backup`, " +
+			"keeping the newest ones.",
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: matchDirs,
+		RunE:              runGcCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runGcCmd(cmd *cobra.Command, args []string) error {
+	if gcKeep < 0 {
+		return fmt.Errorf("--keep must not be negative, got %d", gcKeep)
+	}
+	for _, d := range args {
+		removed, err := modulewriter.PruneBackups(d, gcKeep, gcOlderThan, time.Now())
+		for _, b := range removed {
+			fmt.Printf("Removed backup %s\n", b.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
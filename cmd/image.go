@@ -0,0 +1,160 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/shell"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func init() {
+	imageBuildCmd.Flags().StringVarP(&outputDir, "out", "o", "",
+		"Sets the output directory where the HPC deployment directory will be created.")
+	imageBuildCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	imageBuildCmd.Flags().StringSliceVar(&varsFiles, "vars-file", nil, msgCLIVarsFile)
+	imageBuildCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
+	imageBuildCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	imageBuildCmd.Flags().BoolVar(&allowExec, "allow-exec", false, msgCLIAllowExec)
+	imageBuildCmd.Flags().BoolVar(&noCache, "no-cache", false, msgCLINoCache)
+	imageBuildCmd.Flags().DurationVar(&cache.ValidatorTTL, "validator-cache-ttl", cache.ValidatorTTL, msgCLIValidatorCacheTTL)
+	imageBuildCmd.Flags().BoolVar(&frozenLockfile, "frozen-lockfile", false, msgCLIFrozenLockfile)
+	imageBuildCmd.Flags().StringVar(&policyBundle, "policy-bundle", "", msgCLIPolicyBundle)
+	addEnableApisFlag(imageBuildCmd)
+	addToolkitLabelsFlags(imageBuildCmd)
+	imageBuildCmd.Flags().BoolVarP(&overwriteDeployment, "overwrite-deployment", "w", false,
+		"If specified, an existing deployment directory is overwritten by the new deployment.")
+	rootCmd.AddCommand(imageBuildCmd)
+}
+
+var imageBuildCmd = &cobra.Command{
+	Use:   "image-build BLUEPRINT_NAME",
+	Short: "Build the images of the Packer groups of a blueprint.",
+	Long: "Create the deployment of a blueprint, as create does, then initialize, validate and build its Packer " +
+		"groups, in order and without prompting. Terraform groups are not deployed. The built images are " +
+		"recorded in " + filepath.Join(defaultArtifactsDir, shell.BuiltImagesFilename) + " of the deployment.",
+	Run:               runImageBuildCmd,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: filterYaml,
+}
+
+func runImageBuildCmd(cmd *cobra.Command, args []string) {
+	dc := expandOrDie(args[0])
+	groups := packerGroups(dc.Config)
+	if len(groups) == 0 {
+		checkErr(errcode.New(errcode.ConfigError, errors.New("the blueprint has no Packer deployment groups")))
+	}
+	checkErr(errcode.New(errcode.ConfigError, shell.ConfigurePacker()))
+	if err := modulewriter.WriteDeployment(dc, outputDir, overwriteDeployment); err != nil {
+		var target *modulewriter.OverwriteDeniedError
+		if errors.As(err, &target) {
+			fmt.Printf("\n%s\n", err.Error())
+			os.Exit(errcode.ExitCode(err))
+		}
+		checkErr(err)
+	}
+
+	name, err := dc.Config.DeploymentName()
+	checkErr(errcode.New(errcode.ConfigError, err))
+	deploymentRoot = filepath.Join(outputDir, name)
+	artifactsDir = getArtifactsDir(deploymentRoot)
+	shell.ArtifactStore = dc.Config.ArtifactStore
+	shell.MaskSecrets(dc.Config.SensitiveValues())
+	expandedBlueprintFile := filepath.Join(artifactsDir, expandedBlueprintFilename)
+
+	for i, g := range groups {
+		err := withGroupLog(g.Name, "image-build", func() error {
+			return buildImage(dc.Config, g, expandedBlueprintFile)
+		})
+		checkErr(deployError(err, i))
+	}
+}
+
+// packerGroups returns the Packer groups of the blueprint, in order
+func packerGroups(bp config.Blueprint) []config.DeploymentGroup {
+	groups := []config.DeploymentGroup{}
+	for _, g := range bp.DeploymentGroups {
+		if g.Kind == config.PackerKind {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// buildImage builds the images of the Packer group, with the inputs they use
+// from earlier groups, and records them
+func buildImage(bp config.Blueprint, group config.DeploymentGroup, expandedBlueprintFile string) error {
+	groupDir := filepath.Join(deploymentRoot, string(group.Name))
+	if err := shell.ImportInputs(groupDir, artifactsDir, expandedBlueprintFile); err != nil {
+		return err
+	}
+	modules, err := group.PackerBuildOrder()
+	if err != nil {
+		return err
+	}
+	for _, mod := range modules {
+		moduleDir := filepath.Join(groupDir, string(mod.ID))
+		for _, command := range []string{"init", "validate", "build"} {
+			fmt.Printf("running packer %s in %s\n", command, moduleDir)
+			if err := shell.ExecPackerCmd(moduleDir, command == "build", shell.PackerArgs(groupDir, command)...); err != nil {
+				return err
+			}
+		}
+
+		name, err := shell.ImageFromManifest(moduleDir)
+		if err != nil {
+			return err
+		}
+		img := shell.BuiltImage{Group: group.Name, Module: mod.ID, Name: name, Family: imageFamily(bp, mod), BuiltAt: time.Now().UTC()}
+		if err := shell.RecordBuiltImage(artifactsDir, img); err != nil {
+			return err
+		}
+		fmt.Printf("module %s of group %s built image %s", mod.ID, group.Name, img.Name)
+		if img.Family != "" {
+			fmt.Printf(" in family %s", img.Family)
+		}
+		fmt.Println()
+	}
+	return exportPackerImage(group)
+}
+
+// imageFamily returns the image_family setting of the Packer module, empty if
+// it is not set or cannot be evaluated without deploying earlier groups
+func imageFamily(bp config.Blueprint, mod config.Module) string {
+	if !mod.Settings.Has("image_family") {
+		return ""
+	}
+	s, err := config.NewDict(map[string]cty.Value{"image_family": mod.Settings.Get("image_family")}).Eval(bp)
+	if err != nil {
+		return ""
+	}
+	v := s.Get("image_family")
+	if v.IsNull() || !v.IsKnown() || v.Type() != cty.String {
+		return ""
+	}
+	return v.AsString()
+}
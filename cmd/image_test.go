@@ -0,0 +1,50 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"hpc-toolkit/pkg/config"
+
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestPackerGroups(c *C) {
+	bp := config.Blueprint{DeploymentGroups: []config.DeploymentGroup{
+		{Name: "primary", Kind: config.TerraformKind},
+		{Name: "image", Kind: config.PackerKind},
+		{Name: "cluster", Kind: config.TerraformKind},
+	}}
+	groups := packerGroups(bp)
+	c.Assert(groups, HasLen, 1)
+	c.Check(groups[0].Name, Equals, config.GroupName("image"))
+}
+
+func (s *MySuite) TestImageFamily(c *C) {
+	bp := config.Blueprint{Vars: config.NewDict(map[string]cty.Value{
+		"family": cty.StringVal("slurm-image"),
+	})}
+	mod := func(v cty.Value) config.Module {
+		return config.Module{ID: "image", Settings: config.NewDict(map[string]cty.Value{"image_family": v})}
+	}
+
+	c.Check(imageFamily(bp, config.Module{ID: "image"}), Equals, "")
+	c.Check(imageFamily(bp, mod(cty.StringVal("literal"))), Equals, "literal")
+	c.Check(imageFamily(bp, mod(config.GlobalRef("family").AsExpression().AsValue())), Equals, "slurm-image")
+	// families that depend on earlier groups are not known before building
+	c.Check(imageFamily(bp, mod(config.ModuleRef("network", "name").AsExpression().AsValue())), Equals, "")
+}
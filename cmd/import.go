@@ -17,6 +17,7 @@ package cmd
 
 import (
 	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/shell"
 	"path/filepath"
 
@@ -47,7 +48,7 @@ func runImportCmd(cmd *cobra.Command, args []string) error {
 	groupDir := filepath.Clean(args[0])
 
 	if err := shell.CheckWritableDir(groupDir); err != nil {
-		return err
+		return errcode.New(errcode.WriteFailure, err)
 	}
 
 	expandedBlueprintFile := filepath.Join(artifactsDir, expandedBlueprintFilename)
@@ -57,11 +58,12 @@ func runImportCmd(cmd *cobra.Command, args []string) error {
 	}
 
 	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
-		return err
+		return errcode.New(errcode.ConfigError, err)
 	}
+	shell.ArtifactStore = dc.Config.ArtifactStore
 
 	if err := shell.ImportInputs(groupDir, artifactsDir, expandedBlueprintFile); err != nil {
-		return err
+		return errcode.New(errcode.DeployFailure, err)
 	}
 
 	return nil
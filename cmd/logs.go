@@ -0,0 +1,100 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/shell"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	logsCmd.Flags().BoolVar(&listLogs, "list", false, "List the logs of the group instead of printing the most recent one")
+	rootCmd.AddCommand(logsCmd)
+}
+
+var (
+	listLogs bool
+	logsCmd  = &cobra.Command{
+		Use:   "logs DEPLOYMENT_DIRECTORY [GROUP]",
+		Short: "Show the logs of deployment groups.",
+		Long: "Print the most recent log of the output of terraform, packer and hooks for a deployment group, " +
+			"or list the logs of all groups if no group is given.",
+		Args:              cobra.MatchAll(cobra.RangeArgs(1, 2), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runLogsCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runLogsCmd(cmd *cobra.Command, args []string) error {
+	var group config.GroupName
+	if len(args) > 1 {
+		group = config.GroupName(args[1])
+	}
+	logs, err := shell.ListGroupLogs(args[0], group)
+	if err != nil {
+		return err
+	}
+	if len(logs) == 0 {
+		if group != "" {
+			return fmt.Errorf("group %s has no logs in %s", group, shell.LogsDir(args[0]))
+		}
+		return fmt.Errorf("no logs in %s, logs are written by ghpc deploy and ghpc destroy", shell.LogsDir(args[0]))
+	}
+	if group == "" || listLogs {
+		return writeLogList(os.Stdout, logs)
+	}
+
+	f, err := os.Open(logs[len(logs)-1].Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+// writeLogList writes a table of the logs
+func writeLogList(w io.Writer, logs []shell.LogFile) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tCOMMAND\tTIME\tFILE")
+	for _, l := range logs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", l.Group, l.Command, l.Time.Format(time.RFC3339), l.Path)
+	}
+	return tw.Flush()
+}
+
+// withGroupLog runs f, a command for a group, recording the output of
+// terraform, packer and hooks in a new log of the group
+func withGroupLog(group config.GroupName, command string, f func() error) error {
+	l, err := shell.StartGroupLog(deploymentRoot, group, command)
+	if err != nil {
+		return err
+	}
+	err = f()
+	if cerr := l.Finish(err); err == nil {
+		err = cerr
+	}
+	return err
+}
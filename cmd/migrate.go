@@ -0,0 +1,59 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
+
+var (
+	migrateCmd = &cobra.Command{
+		Use:   "migrate BLUEPRINT_NAME",
+		Short: "Upgrade a blueprint to the current schema version.",
+		Long: "Rewrite a blueprint of an older schema version in place, e.g. renaming resource_groups to " +
+			"deployment_groups, and set its schema_version. Deprecated module sources and settings that have a " +
+			"replacement to use as is are replaced. Blueprints are otherwise migrated when imported, without " +
+			"being rewritten.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: filterYaml,
+		RunE:              runMigrateCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runMigrateCmd(cmd *cobra.Command, args []string) error {
+	applied, err := config.MigrateFile(args[0])
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if len(applied) == 0 {
+		fmt.Printf("%s is up to date with schema version %d.\n", args[0], config.SchemaVersion)
+		return nil
+	}
+	for _, m := range applied {
+		fmt.Printf("Migrated %s: %s\n", args[0], m)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/shell"
+	"path/filepath"
+	"regexp"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	providersLockCmd.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+	providersLockCmd.Flags().StringSliceVar(&lockPlatforms, "platform", nil,
+		"Platforms, e.g. linux_amd64, to record provider checksums for (defaults to the current platform).")
+	providersCmd.AddCommand(providersLockCmd)
+	rootCmd.AddCommand(providersCmd)
+}
+
+var (
+	lockPlatforms []string
+	providersCmd  = &cobra.Command{
+		Use:   "providers",
+		Short: "Manage the Terraform providers of a deployment.",
+		Args:  cobra.NoArgs,
+	}
+	providersLockCmd = &cobra.Command{
+		Use:   "lock DEPLOYMENT_DIRECTORY",
+		Short: "Write the dependency lock file of each Terraform deployment group.",
+		Long: "Select the versions of the providers of each Terraform deployment group and write them, with their " +
+			"checksums for each platform, to " + modulewriter.TFLockFileName + " in the group, so that deployments " +
+			"use the same providers on every machine. The lock files are kept when the deployment is overwritten.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runProvidersLockCmd,
+		SilenceUsage:      true,
+	}
+)
+
+var platformRegexp = regexp.MustCompile(`^[a-z0-9]+_[a-z0-9]+$`)
+
+// checkPlatforms ensures that platforms are of the form <os>_<arch>
+func checkPlatforms(platforms []string) error {
+	for _, p := range platforms {
+		if !platformRegexp.MatchString(p) {
+			return fmt.Errorf("--platform %q must be of the form <os>_<arch>, e.g. linux_amd64", p)
+		}
+	}
+	return nil
+}
+
+func runProvidersLockCmd(cmd *cobra.Command, args []string) error {
+	if err := checkPlatforms(lockPlatforms); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	deploymentRoot = args[0]
+	artifactsDir = getArtifactsDir(deploymentRoot)
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return err
+	}
+	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := shell.UseTerraformCLIConfig(deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	for _, g := range dc.Config.DeploymentGroups {
+		if g.Kind != config.TerraformKind {
+			continue
+		}
+		groupDir := filepath.Join(deploymentRoot, string(g.Name))
+		tf, err := shell.ConfigureGroupTerraform(groupDir, g)
+		if err != nil {
+			return err
+		}
+		if err := shell.LockProviders(tf, lockPlatforms); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", filepath.Join(groupDir, modulewriter.TFLockFileName))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestCheckPlatforms(c *C) {
+	c.Check(checkPlatforms(nil), IsNil)
+	c.Check(checkPlatforms([]string{"linux_amd64", "darwin_arm64", "windows_386"}), IsNil)
+	c.Check(checkPlatforms([]string{"linux_amd64", "linux"}), ErrorMatches, `--platform "linux" must be .*`)
+	c.Check(checkPlatforms([]string{"Linux_AMD64"}), NotNil)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(renameModuleCmd)
+}
+
+var (
+	renameModuleCmd = &cobra.Command{
+		Use:   "rename-module BLUEPRINT_NAME OLD_ID NEW_ID",
+		Short: "Rename a module of a blueprint and the references to it.",
+		Long: "Rename a module of a blueprint in place, and the references to it in use and in expressions. " +
+			"The previous ID is added to the moved_from of Terraform modules, so that the next create -w " +
+			"writes Terraform moved blocks that move the state of deployed modules.",
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: filterYaml,
+		RunE:              runRenameModuleCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runRenameModuleCmd(cmd *cobra.Command, args []string) error {
+	from, to := config.ModuleID(args[1]), config.ModuleID(args[2])
+	if err := config.RenameModuleFile(args[0], from, to); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	fmt.Printf("Renamed module %s to %s in %s\n", from, to, args[0])
+	return nil
+}
@@ -18,8 +18,14 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/offline"
+	"hpc-toolkit/pkg/shell"
+	"hpc-toolkit/pkg/tools"
+	"hpc-toolkit/pkg/userconfig"
 	"log"
 	"os"
 	"path/filepath"
@@ -27,6 +33,7 @@ import (
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
 )
 
 // Git references when use Makefile
@@ -50,9 +57,14 @@ HPC deployments on the Google Cloud Platform.`,
 				log.Fatalf("cmd.Help function failed: %s", err)
 			}
 		},
-		Version:     "v1.19.1",
-		Annotations: annotation,
+		PersistentPreRunE: preRun,
+		Version:           "v1.19.1",
+		Annotations:       annotation,
 	}
+	offlineMode bool
+	mirrorDir   string
+	// userConfig holds the defaults of the user-level configuration file
+	userConfig userconfig.Config
 )
 
 // Execute the root command
@@ -82,7 +94,85 @@ Commit info: {{index .Annotations "commitInfo"}}
 	return rootCmd.Execute()
 }
 
-func init() {}
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&offlineMode, "offline", false,
+		"Run without network access: read module sources from the mirror directory and skip validators that call Google Cloud APIs.")
+	rootCmd.PersistentFlags().StringVar(&mirrorDir, "mirror-dir", "",
+		"Mirror directory used in offline mode (defaults to $"+offline.MirrorDirEnvVar+").")
+	rootCmd.MarkPersistentFlagDirname("mirror-dir")
+}
+
+func preRun(cmd *cobra.Command, args []string) error {
+	if err := loadUserConfig(cmd); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := enableOffline(cmd, args); err != nil {
+		return err
+	}
+	// terraform and packer are not downloaded offline
+	tools.AutoInstall = !offline.Enabled
+	return nil
+}
+
+// loadUserConfig reads the user-level configuration file and applies the
+// defaults that are not set by flags or environment variables
+func loadUserConfig(cmd *cobra.Command) error {
+	var err error
+	if userConfig, err = userconfig.Load(); err != nil {
+		return err
+	}
+	if f := cmd.Flags().Lookup("validation-level"); f != nil && !f.Changed && userConfig.ValidationLevel != "" {
+		validationLevel = userConfig.ValidationLevel
+	}
+	return userConfig.ApplyEnv()
+}
+
+// useVault sets the environment variables of the credentials and secrets the
+// configuration file reads from Vault, which are then masked in logs
+func useVault() error {
+	c := userConfig.Vault
+	if !c.Enabled() {
+		return nil
+	}
+	cl, err := c.NewClient()
+	if err != nil {
+		return err
+	}
+	env, err := c.Fetch(context.Background(), cl, func(n string) bool { return os.Getenv(n) != "" })
+	if err != nil {
+		return err
+	}
+	for n, v := range env {
+		if err := os.Setenv(n, v); err != nil {
+			return err
+		}
+	}
+	shell.MaskSecrets(maps.Values(env))
+	return nil
+}
+
+func enableOffline(cmd *cobra.Command, args []string) error {
+	if !offlineMode {
+		return nil
+	}
+	return errcode.New(errcode.ConfigError, offline.Enable(mirrorDir))
+}
+
+// checkErr logs the error, prefixed with its error code, and exits with the
+// exit code of its error class
+func checkErr(err error) {
+	if err == nil {
+		return
+	}
+	log.Println(errorMessage(err))
+	os.Exit(errcode.ExitCode(err))
+}
+
+// errorMessage returns the error prefixed with its error code, e.g.
+// "error [SOURCE_FETCH_FAILURE]: ..."
+func errorMessage(err error) string {
+	return fmt.Sprintf("error [%s]: %v", errcode.Of(err), err)
+}
 
 // checkGitHashMismatch will compare the hash of the git repository vs the git
 // hash the ghpc binary was compiled against, if the git repository if found and
@@ -17,6 +17,9 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/errcode"
 	"os"
 	"path/filepath"
 	"testing"
@@ -194,6 +197,13 @@ func (s *MySuite) TestCheckGitHashMismatch(c *C) {
 	}
 }
 
+func (s *MySuite) TestErrorMessage(c *C) {
+	err := errcode.New(errcode.SourceFetchFailure, errors.New("failed to fetch module"))
+	c.Check(errorMessage(err), Equals, "error [SOURCE_FETCH_FAILURE]: failed to fetch module")
+	c.Check(errorMessage(fmt.Errorf("deploy: %w", err)), Equals, "error [SOURCE_FETCH_FAILURE]: deploy: failed to fetch module")
+	c.Check(errorMessage(errors.New("boom")), Equals, "error [UNKNOWN]: boom")
+}
+
 func checkPathsEqual(c *C, a, b string) {
 	a, err := filepath.EvalSymlinks(a)
 	if err != nil {
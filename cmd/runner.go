@@ -0,0 +1,93 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/runner"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+var (
+	runnerName    string
+	runnerImage   string
+	runnerProject string
+	runnerBucket  string
+	runnerTimeout time.Duration
+)
+
+// localDeployFlags are the flags of deploy that only apply to this machine,
+// which are not passed to ghpc run by a runner
+var localDeployFlags = []string{
+	"runner", "runner-image", "runner-project", "runner-bucket", "runner-timeout", "artifacts", "plan-summary-file"}
+
+func addRunnerFlags(c *cobra.Command) {
+	c.Flags().StringVar(&runnerName, "runner", runner.Local,
+		fmt.Sprintf("Where terraform and packer run: %s; remote runners require --auto-approve", strings.Join(runner.Names(), ", ")))
+	c.Flags().StringVar(&runnerImage, "runner-image", "",
+		"Container image providing ghpc, terraform, packer and tar, used by remote runners")
+	c.Flags().StringVar(&runnerProject, "runner-project", "",
+		"Project the Cloud Build runner builds in (default: the project_id deployment variable)")
+	c.Flags().StringVar(&runnerBucket, "runner-bucket", "",
+		"Cloud Storage bucket the Cloud Build runner stages the deployment directory, logs and workspace in")
+	c.Flags().DurationVar(&runnerTimeout, "runner-timeout", 2*time.Hour, "Maximum duration of Cloud Build builds")
+}
+
+// remoteArgs returns the arguments of the command run by a runner: the
+// command and the flags set on this machine that do not only apply to it
+func remoteArgs(c *cobra.Command) []string {
+	args := []string{c.Name()}
+	c.Flags().Visit(func(f *pflag.Flag) {
+		if !slices.Contains(localDeployFlags, f.Name) {
+			args = append(args, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+		}
+	})
+	return args
+}
+
+// runRemote runs the command on the deployment in the runner set with
+// --runner; the exit code of ghpc, when the runner reports it, is kept
+func runRemote(c *cobra.Command, dc config.DeploymentConfig) error {
+	project := runnerProject
+	if v := dc.Config.Vars.Get("project_id"); project == "" && !v.IsNull() && v.Type() == cty.String {
+		project = v.AsString()
+	}
+	ctx := context.Background()
+	r, err := runner.New(ctx, runnerName, runner.Options{
+		Image: runnerImage, Project: project, Bucket: runnerBucket, Timeout: runnerTimeout})
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	err = r.Run(ctx, deploymentRoot, remoteArgs(c), os.Stdout)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return errcode.New(errcode.FromExitCode(exitErr.ExitCode()), err)
+	}
+	return errcode.New(errcode.DeployFailure, err)
+}
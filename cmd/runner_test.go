@@ -0,0 +1,35 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestRemoteArgs(c *C) {
+	var only, image string
+	var autoApprove bool
+	cmd := &cobra.Command{Use: "deploy"}
+	cmd.Flags().StringVar(&only, "only", "", "")
+	cmd.Flags().BoolVar(&autoApprove, "auto-approve", false, "")
+	cmd.Flags().StringVar(&image, "runner-image", "", "")
+	c.Assert(cmd.Flags().Parse([]string{"--auto-approve", "--only", "cluster", "--runner-image", "ghpc:test"}), IsNil)
+
+	// flags that only apply to this machine are not passed
+	c.Check(remoteArgs(cmd), DeepEquals, []string{"deploy", "--auto-approve=true", "--only=cluster"})
+}
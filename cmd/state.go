@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/shell"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{stateListCmd, stateRestoreCmd} {
+		c.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+		stateCmd.AddCommand(c)
+	}
+	rootCmd.AddCommand(stateCmd)
+}
+
+var (
+	stateCmd = &cobra.Command{
+		Use:   "state",
+		Short: "Manage the backups of the Terraform state of deployment groups.",
+		Long: "Manage the backups of the Terraform state of deployment groups, saved by deploy and destroy " +
+			"before they change a group.",
+		Args: cobra.NoArgs,
+	}
+	stateListCmd = &cobra.Command{
+		Use:               "list DEPLOYMENT_GROUP_DIRECTORY",
+		Short:             "List the backups of the Terraform state of a deployment group.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRun:            parseExportImportArgs,
+		RunE:              runStateListCmd,
+		SilenceUsage:      true,
+	}
+	stateRestoreCmd = &cobra.Command{
+		Use:   "restore DEPLOYMENT_GROUP_DIRECTORY TIMESTAMP",
+		Short: "Restore the Terraform state of a deployment group from a backup.",
+		Long: "Replace the Terraform state of a deployment group with its backup of the timestamp listed by " +
+			"\"ghpc state list\". The current state is backed up first. Resources are not changed; " +
+			"run deploy to make them match the restored state.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(2), checkDir),
+		ValidArgsFunction: matchDirs,
+		PreRun:            parseExportImportArgs,
+		RunE:              runStateRestoreCmd,
+		SilenceUsage:      true,
+	}
+)
+
+// terraformGroupDir returns the group of the deployment group directory, which
+// must be a Terraform group
+func terraformGroupDir(groupDir string) (config.GroupName, error) {
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return "", err
+	}
+	group, err := dc.Config.Group(config.GroupName(filepath.Base(groupDir)))
+	if err != nil {
+		return "", errcode.New(errcode.ConfigError, err)
+	}
+	if group.Kind != config.TerraformKind {
+		return "", errcode.New(errcode.ConfigError, fmt.Errorf("group %s is not a Terraform group and has no state", group.Name))
+	}
+	return group.Name, nil
+}
+
+func runStateListCmd(cmd *cobra.Command, args []string) error {
+	group, err := terraformGroupDir(args[0])
+	if err != nil {
+		return err
+	}
+	backups, err := shell.ListStateBackups(deploymentRoot, group)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIMESTAMP\tTIME\tFILE")
+	for _, b := range backups {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", b.Timestamp, b.Time.Format(time.RFC3339), b.Path)
+	}
+	return tw.Flush()
+}
+
+func runStateRestoreCmd(cmd *cobra.Command, args []string) error {
+	group, err := terraformGroupDir(args[0])
+	if err != nil {
+		return err
+	}
+	if err := shell.CheckWritableDir(artifactsDir); err != nil {
+		return errcode.New(errcode.WriteFailure, err)
+	}
+	if err := shell.UseTerraformCLIConfig(deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	tf, err := shell.ConfigureTerraform(filepath.Clean(args[0]))
+	if err != nil {
+		return errcode.New(errcode.DeployFailure, err)
+	}
+	if err := shell.RestoreState(tf, deploymentRoot, group, args[1]); err != nil {
+		return errcode.New(errcode.DeployFailure, err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/shell"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	statusCmd.Flags().StringVarP(&artifactsDir, "artifacts", "a", "", "Artifacts output directory (automatically configured if unset)")
+	statusCmd.Flags().StringVar(&statusOutput, "output", "text", "Format of the report: \"text\" or \"json\"")
+	rootCmd.AddCommand(statusCmd)
+}
+
+var (
+	statusOutput string
+	statusCmd    = &cobra.Command{
+		Use:   "status DEPLOYMENT_DIRECTORY",
+		Short: "Report the deployment groups whose resources drifted.",
+		Long: "Run a refresh-only Terraform plan of each deployment group and report the groups whose resources " +
+			"were changed or deleted outside of Terraform. Exits with code 12 (DRIFT_DETECTED) if any group drifted.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runStatusCmd,
+		SilenceUsage:      true,
+	}
+)
+
+// deploymentStatus is the machine-readable report of ghpc status
+type deploymentStatus struct {
+	Deployment string              `json:"deployment"`
+	Drifted    bool                `json:"drifted"`
+	Groups     []shell.GroupStatus `json:"groups"`
+}
+
+func runStatusCmd(cmd *cobra.Command, args []string) error {
+	if statusOutput != "text" && statusOutput != "json" {
+		return errcode.New(errcode.ConfigError, fmt.Errorf("--output must be \"text\" or \"json\", got %q", statusOutput))
+	}
+	deploymentRoot = args[0]
+	artifactsDir = getArtifactsDir(deploymentRoot)
+	dc, err := config.NewDeploymentConfig(filepath.Join(artifactsDir, expandedBlueprintFilename))
+	if err != nil {
+		return err
+	}
+	if err := shell.ValidateDeploymentDirectory(dc.Config.DeploymentGroups, deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := shell.UseTerraformCLIConfig(deploymentRoot); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := useVault(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	// drift is detected by planning, which needs the values of all variables
+	if _, err := shell.UseSecretVars(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if _, err := shell.UseRemoteOutputVars(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	name, _ := dc.Config.DeploymentName()
+	report := deploymentStatus{Deployment: name, Groups: []shell.GroupStatus{}}
+	for _, g := range dc.Config.DeploymentGroups {
+		st := groupStatus(g)
+		report.Groups = append(report.Groups, st)
+		report.Drifted = report.Drifted || st.Status == shell.StatusDrifted
+	}
+
+	if statusOutput == "json" {
+		if err := writeStatusJSON(os.Stdout, report); err != nil {
+			return err
+		}
+	} else if err := writeStatusText(os.Stdout, report); err != nil {
+		return err
+	}
+	return statusError(report)
+}
+
+func groupStatus(g config.DeploymentGroup) shell.GroupStatus {
+	if g.Kind != config.TerraformKind {
+		// images built by Packer have no state to compare
+		return shell.GroupStatus{Group: g.Name, Status: shell.StatusSkipped}
+	}
+	tf, err := shell.ConfigureTerraform(filepath.Join(deploymentRoot, string(g.Name)))
+	if err != nil {
+		return shell.GroupStatus{Group: g.Name, Status: shell.StatusError, Error: err.Error()}
+	}
+	return shell.DetectDrift(tf, g.Name)
+}
+
+func writeStatusJSON(w io.Writer, report deploymentStatus) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func writeStatusText(w io.Writer, report deploymentStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tSTATUS\tDRIFTED RESOURCES")
+	for _, g := range report.Groups {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", g.Group, g.Status, len(g.Drift))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, g := range report.Groups {
+		for _, d := range g.Drift {
+			fmt.Fprintf(w, "%s: %s (%s)\n", g.Group, d.Address, d.Action)
+		}
+		if g.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", g.Group, g.Error)
+		}
+	}
+	return nil
+}
+
+// statusError returns an error if the status of a group could not be
+// determined, or DRIFT_DETECTED if a group drifted
+func statusError(report deploymentStatus) error {
+	failed, drifted := []config.GroupName{}, []config.GroupName{}
+	for _, g := range report.Groups {
+		switch g.Status {
+		case shell.StatusError:
+			failed = append(failed, g.Group)
+		case shell.StatusDrifted:
+			drifted = append(drifted, g.Group)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to determine the status of groups %v", failed)
+	}
+	if len(drifted) > 0 {
+		return errcode.New(errcode.DriftDetected, fmt.Errorf("groups %v drifted from their Terraform state", drifted))
+	}
+	return nil
+}
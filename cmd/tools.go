@@ -0,0 +1,72 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/tools"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+func init() {
+	toolsCmd.AddCommand(toolsInstallCmd)
+	rootCmd.AddCommand(toolsCmd)
+}
+
+var (
+	toolsCmd = &cobra.Command{
+		Use:   "tools",
+		Short: "Manage the terraform and packer binaries used by ghpc.",
+		Long: fmt.Sprintf("Manage the terraform and packer binaries used by ghpc. When the binary in PATH is missing "+
+			"or of an unsupported version, ghpc uses the one installed to ~/.ghpc/bin unless %s is set, "+
+			"installing it if needed.", tools.DirEnvVar),
+		Args: cobra.NoArgs,
+	}
+	toolsInstallCmd = &cobra.Command{
+		Use:          "install [TOOL...]",
+		Short:        "Install the versions of terraform and packer ghpc is tested with.",
+		Long:         "Download, verify and install terraform, packer, or both if none is given, e.g. to prepare machines that deploy offline.",
+		Args:         cobra.OnlyValidArgs,
+		ValidArgs:    toolNames(),
+		RunE:         runToolsInstallCmd,
+		SilenceUsage: true,
+	}
+)
+
+func toolNames() []string {
+	names := []string{}
+	for _, t := range tools.Tools() {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func runToolsInstallCmd(cmd *cobra.Command, args []string) error {
+	for _, t := range tools.Tools() {
+		if len(args) > 0 && !slices.Contains(args, t.Name) {
+			continue
+		}
+		path, err := t.Install()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s %s to %s\n", t.Name, t.Version, path)
+	}
+	return nil
+}
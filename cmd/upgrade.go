@@ -0,0 +1,85 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/modulewriter"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	upgradeCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	upgradeCmd.Flags().StringSliceVar(&varsFiles, "vars-file", nil, msgCLIVarsFile)
+	upgradeCmd.Flags().StringSliceVar(&cliBEConfigVars, "backend-config", nil, msgCLIBackendConfig)
+	upgradeCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
+	upgradeCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	upgradeCmd.Flags().BoolVar(&allowExec, "allow-exec", false, msgCLIAllowExec)
+	upgradeCmd.Flags().BoolVar(&noCache, "no-cache", false, msgCLINoCache)
+	upgradeCmd.Flags().DurationVar(&cache.ValidatorTTL, "validator-cache-ttl", cache.ValidatorTTL, msgCLIValidatorCacheTTL)
+	upgradeCmd.Flags().BoolVar(&backupDeployment, "backup", false, msgCLIBackup)
+	addToolkitLabelsFlags(upgradeCmd)
+	rootCmd.AddCommand(upgradeCmd)
+}
+
+var (
+	upgradeCmd = &cobra.Command{
+		Use:   "upgrade-deployment BLUEPRINT_NAME DEPLOYMENT_DIRECTORY",
+		Short: "Rewrite an existing deployment, moving the state of renamed modules.",
+		Long: "Rewrite an existing deployment from its blueprint as create -w does. Terraform modules renamed " +
+			"since the deployment was written, in the blueprint or by the toolkit, are found by their source and " +
+			"recorded in their moved_from, so that Terraform moved blocks move their state instead of " +
+			"destroying and recreating their resources.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(2), checkUpgradeDir),
+		ValidArgsFunction: filterYaml,
+		RunE:              runUpgradeCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func checkUpgradeDir(cmd *cobra.Command, args []string) error {
+	return checkDir(cmd, args[1:])
+}
+
+func runUpgradeCmd(cmd *cobra.Command, args []string) error {
+	deploymentDir := filepath.Clean(args[1])
+	expandedBlueprintFile := filepath.Join(deploymentDir, defaultArtifactsDir, expandedBlueprintFilename)
+	prev, err := config.NewDeploymentConfig(expandedBlueprintFile)
+	if err != nil {
+		return err
+	}
+
+	dc := expandOrDie(args[0])
+	dc.Backup = backupDeployment
+	name, err := dc.Config.DeploymentName()
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if name != filepath.Base(deploymentDir) {
+		return errcode.New(errcode.ConfigError, fmt.Errorf(
+			"the deployment_name %q of the blueprint is not the name of the deployment directory %s", name, deploymentDir))
+	}
+	for _, m := range dc.Config.InferMoves(prev.Config) {
+		fmt.Printf("Module %s of group %s was renamed %s, its state is moved\n", m.From, m.Group, m.To)
+	}
+	return modulewriter.WriteDeployment(dc, filepath.Dir(deploymentDir), true)
+}
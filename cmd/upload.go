@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/gcsupload"
+	"hpc-toolkit/pkg/modulewriter"
+	"log"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+const msgUploadRate = "Maximum bandwidth of artifact uploads in MiB/s, 0 for no limit"
+
+func init() {
+	uploadArtifactsCmd.Flags().StringVar(&uploadGroup, "group", "",
+		"Only upload the artifacts uploaded before the deployment group is deployed")
+	uploadArtifactsCmd.Flags().IntVar(&uploadRate, "upload-rate", 0, msgUploadRate)
+	rootCmd.AddCommand(uploadArtifactsCmd)
+}
+
+var (
+	uploadGroup        string
+	uploadRate         int
+	uploadArtifactsCmd = &cobra.Command{
+		Use:   "upload-artifacts DEPLOYMENT_DIRECTORY",
+		Short: "Upload the artifacts of a deployment to Cloud Storage.",
+		Long: "Upload the local files declared in the artifacts block of the blueprint to Cloud Storage. " +
+			"Unchanged files are skipped and interrupted uploads are resumed.",
+		Args:              cobra.MatchAll(cobra.ExactArgs(1), checkDir),
+		ValidArgsFunction: matchDirs,
+		RunE:              runUploadArtifactsCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runUploadArtifactsCmd(cmd *cobra.Command, args []string) error {
+	deploymentRoot = args[0]
+	expandedBlueprintFile := filepath.Join(getArtifactsDir(deploymentRoot), expandedBlueprintFilename)
+	dc, err := config.NewDeploymentConfig(expandedBlueprintFile)
+	if err != nil {
+		return err
+	}
+
+	artifacts := dc.Config.Artifacts
+	if uploadGroup != "" {
+		if _, err := dc.Config.Group(config.GroupName(uploadGroup)); err != nil {
+			return errcode.New(errcode.ConfigError, err)
+		}
+		artifacts = dc.Config.ArtifactsOf(config.GroupName(uploadGroup))
+	}
+	return errcode.New(errcode.DeployFailure, uploadArtifacts(artifacts))
+}
+
+// uploadArtifacts uploads the artifacts, resuming interrupted uploads of the
+// deployment at deploymentRoot
+func uploadArtifacts(artifacts []config.Artifact) error {
+	if len(artifacts) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	stateDir := filepath.Join(deploymentRoot, modulewriter.HiddenGhpcDirName, modulewriter.UploadsDirName)
+	u, err := gcsupload.NewUploader(ctx, stateDir, int64(uploadRate)<<20)
+	if err != nil {
+		return err
+	}
+	for _, a := range artifacts {
+		log.Printf("uploading artifact %s to %s", a.Name, a.Destination)
+		status, err := u.Upload(ctx, a.Name, a.Source, a.Destination)
+		if err != nil {
+			return fmt.Errorf("failed to upload artifact %s: %w", a.Name, err)
+		}
+		log.Printf("artifact %s %s", a.Name, status)
+	}
+	return nil
+}
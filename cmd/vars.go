@@ -0,0 +1,116 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/spf13/cobra"
+	"github.com/zclconf/go-cty/cty"
+	ctyJson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/slices"
+)
+
+func init() {
+	varsCmd.AddCommand(varsDescribeCmd)
+	rootCmd.AddCommand(varsCmd)
+}
+
+var (
+	varsCmd = &cobra.Command{
+		Use:   "vars",
+		Short: "Inspect the deployment variables of blueprints.",
+		Args:  cobra.NoArgs,
+	}
+	varsDescribeCmd = &cobra.Command{
+		Use:   "describe BLUEPRINT_NAME",
+		Short: "Print the documentation of the deployment variables of a blueprint as a Markdown table.",
+		Long: "Print the deployment variables of a blueprint as a Markdown table, with the type, description and " +
+			"default of their declarations in deployment_variables. Variables set in vars are listed with their " +
+			"value as default, and declared variables without a value are required.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: filterYaml,
+		RunE:              runVarsDescribeCmd,
+		SilenceUsage:      true,
+	}
+)
+
+func runVarsDescribeCmd(cmd *cobra.Command, args []string) error {
+	dc, err := config.NewDeploymentConfig(args[0])
+	if err != nil {
+		return err
+	}
+	return writeVariablesTable(os.Stdout, dc.Config.Redacted())
+}
+
+// markdownCell escapes a value for a cell of a Markdown table
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "\n", " ")
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+// writeVariablesTable writes the declared deployment variables and those set
+// in vars, sorted by name
+func writeVariablesTable(w io.Writer, bp config.Blueprint) error {
+	names := bp.DeclaredVariables()
+	for n := range bp.Vars.Items() {
+		if !slices.Contains(names, n) {
+			names = append(names, n)
+		}
+	}
+	slices.Sort(names)
+
+	fmt.Fprintln(w, "| Name | Description | Type | Default | Required |")
+	fmt.Fprintln(w, "|------|-------------|------|---------|:--------:|")
+	for _, n := range names {
+		d := bp.DeploymentVariables[n]
+		ty, err := d.TypeConstraint()
+		if err != nil {
+			return fmt.Errorf("deployment variable %s: invalid type %q: %v", n, d.Type, err)
+		}
+		def, required := "", true
+		if bp.Vars.Has(n) {
+			def, required = renderValue(bp.Vars.Get(n)), false
+		} else if d.Default != nil {
+			def, required = renderValue(d.Default.Unwrap()), false
+		}
+		req := "no"
+		if required {
+			req = "yes"
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s | %s |\n", markdownCell(n), markdownCell(d.Description),
+			markdownCell("`"+typeexpr.TypeString(ty)+"`"), def, req)
+	}
+	return nil
+}
+
+// renderValue renders a value as JSON in a code span, or its expression
+func renderValue(v cty.Value) string {
+	if e, is := config.IsExpressionValue(v); is {
+		return markdownCell("`" + string(e.Tokenize().Bytes()) + "`")
+	}
+	b, err := ctyJson.SimpleJSONValue{Value: v}.MarshalJSON()
+	if err != nil {
+		return ""
+	}
+	return markdownCell("`" + string(b) + "`")
+}
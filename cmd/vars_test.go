@@ -0,0 +1,49 @@
+/*
+Copyright 2023 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"hpc-toolkit/pkg/config"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestWriteVariablesTable(c *C) {
+	dc, err := config.NewDeploymentConfigFromBytes([]byte(`
+blueprint_name: table
+vars:
+  deployment_name: hpc
+deployment_variables:
+  node_count:
+    type: number
+    description: Number of | nodes
+  zones:
+    type: list(string)
+    default: [us-central1-a]
+deployment_groups: []
+`))
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(writeVariablesTable(&buf, dc.Config), IsNil)
+	c.Check(buf.String(), Equals, "| Name | Description | Type | Default | Required |\n"+
+		"|------|-------------|------|---------|:--------:|\n"+
+		"| deployment_name |  | `any` | `\"hpc\"` | no |\n"+
+		"| node_count | Number of \\| nodes | `number` |  | yes |\n"+
+		"| zones |  | `list(string)` | `[\"us-central1-a\"]` | no |\n")
+}
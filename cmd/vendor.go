@@ -0,0 +1,60 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/errcode"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	vendorCmd.Flags().StringVarP(&outputFilename, "out", "o", "expanded.yaml",
+		"Output file for the expanded HPC Environment Definition.")
+	vendorCmd.Flags().StringVar(&vendorDir, "vendor-dir", "vendor",
+		"Directory the module sources are downloaded to.")
+	vendorCmd.Flags().StringSliceVar(&cliVariables, "vars", nil, msgCLIVars)
+	vendorCmd.Flags().StringSliceVar(&varsFiles, "vars-file", nil, msgCLIVarsFile)
+	vendorCmd.Flags().StringSliceVar(&cliBEConfigVars, "backend-config", nil, msgCLIBackendConfig)
+	vendorCmd.Flags().StringVarP(&validationLevel, "validation-level", "l", "WARNING", validationLevelDesc)
+	vendorCmd.Flags().StringSliceVar(&validatorsToSkip, "skip-validators", nil, skipValidatorsDesc)
+	vendorCmd.Flags().BoolVar(&allowExec, "allow-exec", false, msgCLIAllowExec)
+	vendorCmd.Flags().BoolVar(&noCache, "no-cache", false, msgCLINoCache)
+	vendorCmd.Flags().BoolVar(&frozenLockfile, "frozen-lockfile", false, msgCLIFrozenLockfile)
+	vendorCmd.MarkFlagDirname("vendor-dir")
+	rootCmd.AddCommand(vendorCmd)
+}
+
+var (
+	vendorDir string
+	vendorCmd = &cobra.Command{
+		Use:   "vendor BLUEPRINT_NAME",
+		Short: "Vendor the remote modules of the Environment Blueprint.",
+		Long: "Expands the Environment Blueprint as expand does, downloads all remote and Terraform Registry " +
+			"modules to the vendor directory and rewrites their sources in the expanded blueprint to point to it.",
+		Run:               runVendorCmd,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: filterYaml,
+	}
+)
+
+func runVendorCmd(cmd *cobra.Command, args []string) {
+	dc := expandOrDie(args[0])
+	checkErr(errcode.New(errcode.SourceFetchFailure, dc.Config.VendorModules(vendorDir)))
+	checkErr(errcode.New(errcode.WriteFailure, dc.ExportBlueprint(outputFilename)))
+	fmt.Printf("Modules vendored to %s, expanded Environment Definition saved as %s.\n", vendorDir, outputFilename)
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmd defines command line utilities for ghpc
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/wizard"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	wizardCmd.Flags().StringVarP(&wizardOutput, "out", "o", "",
+		"Output file for the blueprint (defaults to <deployment name>.yaml).")
+	wizardCmd.Flags().StringVar(&wizardAnswers.Workload, "workload", "",
+		"Type of workload: "+strings.Join(wizard.Workloads(), ", "))
+	wizardCmd.Flags().StringVar(&wizardAnswers.Budget, "budget", "", "Budget: "+strings.Join(wizard.Budgets(), ", "))
+	wizardCmd.Flags().IntVar(&wizardAnswers.Nodes, "nodes", 0, "Maximum number of compute nodes")
+	wizardCmd.Flags().StringVar(&wizardAnswers.Accelerator, "accelerator", "",
+		"GPUs of AI training nodes: "+strings.Join(wizard.Accelerators(), ", "))
+	wizardCmd.Flags().IntVar(&wizardAnswers.GPUsPerNode, "gpus-per-node", 0, "Number of GPUs of AI training nodes")
+	wizardCmd.Flags().StringVar(&wizardAnswers.ProjectID, "project", "", "Project ID")
+	wizardCmd.Flags().StringVar(&wizardAnswers.DeploymentName, "deployment-name", "", "Deployment name")
+	wizardCmd.Flags().StringVar(&wizardAnswers.Region, "region", "", "Region")
+	wizardCmd.Flags().StringVar(&wizardAnswers.Zone, "zone", "", "Zone")
+	wizardCmd.Flags().BoolVar(&wizardNonInteractive, "non-interactive", false,
+		"Do not ask questions, use defaults for the answers not given as flags")
+	rootCmd.AddCommand(wizardCmd)
+}
+
+var (
+	wizardOutput         string
+	wizardAnswers        wizard.Answers
+	wizardNonInteractive bool
+	wizardCmd            = &cobra.Command{
+		Use:   "wizard",
+		Short: "Generate a starter blueprint by answering a few questions.",
+		Long: "Asks about the workload type, scale and budget of a cluster and generates a starter blueprint " +
+			"composed of Toolkit modules with settings suited to the answers. Questions answered by flags are " +
+			"not asked.",
+		Args:         cobra.NoArgs,
+		RunE:         runWizardCmd,
+		SilenceUsage: true,
+	}
+)
+
+func runWizardCmd(cmd *cobra.Command, args []string) error {
+	a := wizardAnswers
+	if wizardNonInteractive {
+		a = wizard.Complete(a)
+	} else {
+		var err error
+		p := wizard.NewPrompter(cmd.InOrStdin(), cmd.OutOrStdout())
+		if a, err = wizard.Ask(p, a); err != nil {
+			return errcode.New(errcode.ConfigError, err)
+		}
+	}
+
+	bp, err := wizard.Blueprint(a)
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	out := wizardOutput
+	if out == "" {
+		out = a.DeploymentName + ".yaml"
+	}
+	if _, err := os.Stat(out); !errors.Is(err, os.ErrNotExist) {
+		return errcode.New(errcode.WriteFailure, fmt.Errorf("%s already exists, choose another file with --out", out))
+	}
+	if err := os.WriteFile(out, bp, 0644); err != nil {
+		return errcode.New(errcode.WriteFailure, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Blueprint saved as %s. Review it, then create the deployment with:\n  ghpc create %s\n", out, out)
+	return nil
+}
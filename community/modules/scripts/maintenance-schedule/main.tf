@@ -0,0 +1,76 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+*/
+
+locals {
+  scale_jobs   = { for s in var.schedules : s.name => s if contains(["scale-down", "scale-up"], s.action) }
+  refresh_jobs = { for s in var.schedules : s.name => s if s.action == "image-refresh" }
+
+  roles = distinct(concat(
+    length(local.scale_jobs) > 0 ? ["roles/compute.instanceAdmin.v1"] : [],
+    length(local.refresh_jobs) > 0 ? ["roles/cloudbuild.builds.editor"] : [],
+  ))
+}
+
+resource "google_service_account" "scheduler" {
+  project      = var.project_id
+  account_id   = substr("${var.deployment_name}-maint", 0, 30)
+  display_name = "Maintenance scheduler for ${var.deployment_name}"
+}
+
+resource "google_project_iam_member" "scheduler" {
+  for_each = toset(local.roles)
+  project  = var.project_id
+  role     = each.value
+  member   = "serviceAccount:${google_service_account.scheduler.email}"
+}
+
+resource "google_cloud_scheduler_job" "scale" {
+  for_each  = local.scale_jobs
+  project   = var.project_id
+  region    = var.region
+  name      = "${var.deployment_name}-${each.key}"
+  schedule  = each.value.schedule
+  time_zone = each.value.time_zone
+
+  http_target {
+    http_method = "POST"
+    uri         = "https://compute.googleapis.com/compute/v1/${each.value.instance_group_manager}/resize?size=${each.value.size}"
+    oauth_token {
+      service_account_email = google_service_account.scheduler.email
+    }
+  }
+
+  depends_on = [google_project_iam_member.scheduler]
+}
+
+resource "google_cloud_scheduler_job" "image_refresh" {
+  for_each  = local.refresh_jobs
+  project   = var.project_id
+  region    = var.region
+  name      = "${var.deployment_name}-${each.key}"
+  schedule  = each.value.schedule
+  time_zone = each.value.time_zone
+
+  http_target {
+    http_method = "POST"
+    uri         = "https://cloudbuild.googleapis.com/v1/${each.value.build_trigger}:run"
+    oauth_token {
+      service_account_email = google_service_account.scheduler.email
+    }
+  }
+
+  depends_on = [google_project_iam_member.scheduler]
+}
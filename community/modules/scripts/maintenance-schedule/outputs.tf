@@ -0,0 +1,28 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+*/
+
+output "job_names" {
+  description = "Names of the Cloud Scheduler jobs created for the maintenance schedules"
+  value = concat(
+    [for j in google_cloud_scheduler_job.scale : j.name],
+    [for j in google_cloud_scheduler_job.image_refresh : j.name],
+  )
+}
+
+output "service_account_email" {
+  description = "Email of the service account used by the Cloud Scheduler jobs"
+  value       = google_service_account.scheduler.email
+}
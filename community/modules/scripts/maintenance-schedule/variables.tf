@@ -0,0 +1,56 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+*/
+
+variable "project_id" {
+  description = "Project in which the Cloud Scheduler jobs will be created"
+  type        = string
+}
+
+variable "region" {
+  description = "Region in which the Cloud Scheduler jobs will be created"
+  type        = string
+}
+
+variable "deployment_name" {
+  description = "Name of the deployment, used to name the Cloud Scheduler jobs"
+  type        = string
+}
+
+variable "schedules" {
+  description = <<-EOT
+    Maintenance operations to run on a schedule. Each schedule has:
+    name: unique name of the schedule;
+    schedule: cron expression of when the operation runs;
+    time_zone: time zone of the cron expression;
+    action: one of scale-down, scale-up or image-refresh;
+    instance_group_manager: ID of the managed instance group to resize (scale actions);
+    size: target size of the managed instance group (scale actions);
+    build_trigger: ID of the Cloud Build trigger to run (image-refresh action).
+    EOT
+  type = list(object({
+    name                   = string
+    schedule               = string
+    time_zone              = string
+    action                 = string
+    instance_group_manager = string
+    size                   = number
+    build_trigger          = string
+  }))
+  validation {
+    condition     = alltrue([for s in var.schedules : contains(["scale-down", "scale-up", "image-refresh"], s.action)])
+    error_message = "The action of each schedule must be one of scale-down, scale-up or image-refresh."
+  }
+}
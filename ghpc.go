@@ -18,6 +18,7 @@ package main
 import (
 	"embed"
 	"hpc-toolkit/cmd"
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/sourcereader"
 	"os"
 )
@@ -40,6 +41,6 @@ func main() {
 	cmd.GitCommitHash = gitCommitHash
 	cmd.GitInitialHash = gitInitialHash
 	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(errcode.ExitCode(err))
 	}
 }
@@ -0,0 +1,195 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit writes entries recording deploy and destroy actions to Cloud
+// Logging, for change-management audit requirements
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"hpc-toolkit/pkg/errcode"
+
+	"golang.org/x/oauth2/google"
+	logging "google.golang.org/api/logging/v2"
+	oauth2 "google.golang.org/api/oauth2/v2"
+	"google.golang.org/api/option"
+)
+
+// LogName is the name of the log audit entries are written to
+const LogName = "ghpc-audit"
+
+// Action is an action on a deployment that is audited
+type Action string
+
+// Audited actions
+const (
+	Deploy  Action = "deploy"
+	Destroy Action = "destroy"
+)
+
+// Outcomes of an action
+const (
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// Entry records who ran an action on which deployment, when, and with which
+// outcome. It is the JSON payload of the log entry.
+type Entry struct {
+	Action        Action    `json:"action"`
+	Outcome       string    `json:"outcome"`
+	ErrorCode     string    `json:"error_code,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Deployment    string    `json:"deployment"`
+	Blueprint     string    `json:"blueprint"`
+	BlueprintHash string    `json:"blueprint_hash"`
+	Profile       string    `json:"profile,omitempty"`
+	Groups        []string  `json:"groups"`
+	Principal     string    `json:"principal,omitempty"`
+	LocalUser     string    `json:"local_user,omitempty"`
+	Host          string    `json:"host,omitempty"`
+	GhpcVersion   string    `json:"ghpc_version,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+}
+
+// Begin starts the entry of an action on a deployment; the hash identifies
+// the blueprint by the content of the expanded blueprint file
+func Begin(action Action, deployment string, blueprint string, expandedBlueprintFile string) (*Entry, error) {
+	b, err := os.ReadFile(expandedBlueprintFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash blueprint: %w", err)
+	}
+	h := sha256.Sum256(b)
+	e := &Entry{
+		Action:        action,
+		Deployment:    deployment,
+		Blueprint:     blueprint,
+		BlueprintHash: "sha256:" + hex.EncodeToString(h[:]),
+		Groups:        []string{},
+		StartTime:     time.Now().UTC(),
+	}
+	if u, err := user.Current(); err == nil {
+		e.LocalUser = u.Username
+	}
+	if h, err := os.Hostname(); err == nil {
+		e.Host = h
+	}
+	return e, nil
+}
+
+// AddGroup records that the action affected a deployment group
+func (e *Entry) AddGroup(name string) {
+	e.Groups = append(e.Groups, name)
+}
+
+// End records the outcome of the action
+func (e *Entry) End(err error) {
+	e.EndTime = time.Now().UTC()
+	if err == nil {
+		e.Outcome, e.ErrorCode, e.Error = Succeeded, "", ""
+		return
+	}
+	e.Outcome = Failed
+	e.ErrorCode = string(errcode.Of(err))
+	e.Error = err.Error()
+}
+
+// logEntry returns the Cloud Logging entry for the entry
+func (e Entry) logEntry() (*logging.LogEntry, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	severity := "NOTICE"
+	if e.Outcome == Failed {
+		severity = "ERROR"
+	}
+	return &logging.LogEntry{
+		JsonPayload: payload,
+		Severity:    severity,
+		Timestamp:   e.EndTime.Format(time.RFC3339Nano),
+		Labels: map[string]string{
+			"action":          string(e.Action),
+			"outcome":         e.Outcome,
+			"ghpc_deployment": e.Deployment,
+			"ghpc_blueprint":  e.Blueprint,
+		},
+	}, nil
+}
+
+// principal returns the email of the account of the application default
+// credentials, if it can be determined
+func principal(ctx context.Context) string {
+	creds, err := google.FindDefaultCredentials(ctx, oauth2.UserinfoEmailScope)
+	if err != nil {
+		return ""
+	}
+	var sa struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if json.Unmarshal(creds.JSON, &sa) == nil && sa.ClientEmail != "" {
+		return sa.ClientEmail
+	}
+	tok, err := creds.TokenSource.Token()
+	if err != nil {
+		return ""
+	}
+	s, err := oauth2.NewService(ctx, option.WithoutAuthentication())
+	if err != nil {
+		return ""
+	}
+	info, err := s.Tokeninfo().AccessToken(tok.AccessToken).Do()
+	if err != nil {
+		return ""
+	}
+	return info.Email
+}
+
+// Write writes the entry to the audit log of the project, using the
+// application default credentials
+func Write(ctx context.Context, projectID string, e Entry, opts ...option.ClientOption) error {
+	if e.Principal == "" {
+		e.Principal = principal(ctx)
+	}
+	le, err := e.logEntry()
+	if err != nil {
+		return err
+	}
+
+	s, err := logging.NewService(ctx, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create Cloud Logging client: %w", err)
+	}
+	req := &logging.WriteLogEntriesRequest{
+		LogName: fmt.Sprintf("projects/%s/logs/%s", projectID, LogName),
+		Resource: &logging.MonitoredResource{
+			Type:   "global",
+			Labels: map[string]string{"project_id": projectID},
+		},
+		Entries: []*logging.LogEntry{le},
+	}
+	if _, err := s.Entries.Write(req).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to write audit log entry to project %s: %w", projectID, err)
+	}
+	return nil
+}
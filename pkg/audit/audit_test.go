@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hpc-toolkit/pkg/errcode"
+
+	"github.com/google/go-cmp/cmp"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/option"
+)
+
+func testEntry(t *testing.T) *Entry {
+	bp := filepath.Join(t.TempDir(), "expanded_blueprint.yaml")
+	if err := os.WriteFile(bp, []byte("blueprint_name: test\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Begin(Deploy, "dep", "test", bp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEntry(t *testing.T) {
+	e := testEntry(t)
+	h := sha256.Sum256([]byte("blueprint_name: test\n"))
+	if want := "sha256:" + hex.EncodeToString(h[:]); e.BlueprintHash != want {
+		t.Errorf("got blueprint hash %s, want %s", e.BlueprintHash, want)
+	}
+	e.AddGroup("primary")
+	e.AddGroup("compute")
+	e.End(errcode.New(errcode.PartialDeploy, errors.New("apply failed")))
+
+	if e.Outcome != Failed || e.ErrorCode != string(errcode.PartialDeploy) || e.Error != "apply failed" {
+		t.Errorf("unexpected outcome %q %q %q", e.Outcome, e.ErrorCode, e.Error)
+	}
+	le, err := e.logEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if le.Severity != "ERROR" {
+		t.Errorf("got severity %s, want ERROR", le.Severity)
+	}
+	var payload Entry
+	if err := json.Unmarshal(le.JsonPayload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"primary", "compute"}, payload.Groups); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	e.End(nil)
+	if e.Outcome != Succeeded || e.ErrorCode != "" {
+		t.Errorf("unexpected outcome %q %q", e.Outcome, e.ErrorCode)
+	}
+}
+
+func TestBeginMissingBlueprint(t *testing.T) {
+	if _, err := Begin(Destroy, "dep", "test", filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for missing blueprint")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var got logging.WriteLogEntriesRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	e := testEntry(t)
+	e.Principal = "admin@example.com"
+	e.End(nil)
+	err := Write(context.Background(), "proj", *e,
+		option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LogName != "projects/proj/logs/ghpc-audit" {
+		t.Errorf("got log name %s", got.LogName)
+	}
+	if len(got.Entries) != 1 || got.Entries[0].Severity != "NOTICE" {
+		t.Fatalf("unexpected entries %#v", got.Entries)
+	}
+	var payload Entry
+	if err := json.Unmarshal(got.Entries[0].JsonPayload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Principal != "admin@example.com" || payload.Outcome != Succeeded {
+		t.Errorf("unexpected payload %#v", payload)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache implements a content-addressed disk cache for module
+// metadata, fetched module sources and successful results of validators
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DirEnvVar overrides the default location of the cache
+const DirEnvVar = "GHPC_CACHE_DIR"
+
+const (
+	infoBucket       = "info"
+	sourcesBucket    = "sources"
+	validatorsBucket = "validators"
+)
+
+// Enabled controls whether the cache is read from and written to
+var Enabled = true
+
+// ValidatorTTL is how long successful results of validators are reused;
+// results are not cached if it is not positive
+var ValidatorTTL = time.Hour
+
+// replaced in tests
+var now = time.Now
+
+// Dir returns the root directory of the cache, ~/.ghpc/cache by default
+func Dir() (string, error) {
+	if d := os.Getenv(DirEnvVar); d != "" {
+		return d, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate cache directory: %w", err)
+	}
+	return filepath.Join(home, ".ghpc", "cache"), nil
+}
+
+// Clean removes all cached data
+func Clean() error {
+	d, err := Dir()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(d)
+}
+
+// Key returns a stable hex-encoded hash of the given parts
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		// length-prefix each part so that ("ab", "c") != ("a", "bc")
+		fmt.Fprintf(h, "%d:%s", len(p), p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashDir returns a hash of the names and contents of all regular files in
+// the directory tree rooted at dir
+func HashDir(dir string) (string, error) {
+	return HashFS(os.DirFS(dir))
+}
+
+// HashFS returns a hash of the names and contents of all regular files in
+// fsys. Git metadata, which differs between clones of a repository, is skipped.
+func HashFS(fsys fs.FS) (string, error) {
+	files := []string{}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return fs.SkipDir
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, p := range files {
+		f, err := fsys.Open(p)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00", p)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LoadInfo decodes the cached module metadata stored under key into v.
+// Returns false if the cache is disabled or holds no valid entry for key.
+func LoadInfo(key string, v interface{}) bool {
+	if !Enabled {
+		return false
+	}
+	d, err := Dir()
+	if err != nil {
+		return false
+	}
+	b, err := os.ReadFile(filepath.Join(d, infoBucket, key+".json"))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(b, v) == nil
+}
+
+// StoreInfo stores module metadata under key; no-op if the cache is disabled
+func StoreInfo(key string, v interface{}) error {
+	if !Enabled {
+		return nil
+	}
+	d, err := Dir()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(d, infoBucket, key+".json"), b)
+}
+
+// SourceDir returns the directory holding the cached copy of a fetched source
+// and whether it is populated. Returns "", false if the cache is disabled.
+func SourceDir(key string) (string, bool) {
+	if !Enabled {
+		return "", false
+	}
+	d, err := Dir()
+	if err != nil {
+		return "", false
+	}
+	p := filepath.Join(d, sourcesBucket, key)
+	fi, err := os.Stat(p)
+	return p, err == nil && fi.IsDir()
+}
+
+// StoreSource populates the cached copy of a source by calling fetch with a
+// temporary directory that is moved into place once fetch succeeds
+func StoreSource(key string, fetch func(dst string) error) (string, error) {
+	p, _ := SourceDir(key)
+	if p == "" {
+		return "", fmt.Errorf("cache is disabled")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(p), key+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	dst := filepath.Join(tmp, "src")
+	if err := fetch(dst); err != nil {
+		return "", err
+	}
+	os.RemoveAll(p)
+	if err := os.Rename(dst, p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+type validatorResult struct {
+	PassedAt time.Time `json:"passed_at"`
+}
+
+// ValidatorPassed returns true if the validator identified by key succeeded
+// within ValidatorTTL. Returns false if the cache is disabled.
+func ValidatorPassed(key string) bool {
+	if !Enabled || ValidatorTTL <= 0 {
+		return false
+	}
+	d, err := Dir()
+	if err != nil {
+		return false
+	}
+	b, err := os.ReadFile(filepath.Join(d, validatorsBucket, key+".json"))
+	if err != nil {
+		return false
+	}
+	var r validatorResult
+	if json.Unmarshal(b, &r) != nil {
+		return false
+	}
+	age := now().Sub(r.PassedAt)
+	return age >= 0 && age < ValidatorTTL
+}
+
+// StoreValidatorPass records that the validator identified by key succeeded;
+// no-op if the cache is disabled
+func StoreValidatorPass(key string) error {
+	if !Enabled || ValidatorTTL <= 0 {
+		return nil
+	}
+	d, err := Dir()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(validatorResult{PassedAt: now()})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(d, validatorsBucket, key+".json"), b)
+}
+
+func writeFileAtomic(p string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
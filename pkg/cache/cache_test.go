@@ -0,0 +1,162 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, p string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKey(t *testing.T) {
+	if Key("ab", "c") == Key("a", "bc") {
+		t.Error("expected keys of different parts to differ")
+	}
+	if Key("a", "b") != Key("a", "b") {
+		t.Error("expected keys of same parts to be equal")
+	}
+}
+
+func TestHashDir(t *testing.T) {
+	d := t.TempDir()
+	writeFile(t, filepath.Join(d, "main.tf"), "a")
+	writeFile(t, filepath.Join(d, "sub", "variables.tf"), "b")
+
+	h1, err := HashDir(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, _ := HashDir(d)
+	if h1 != h2 {
+		t.Error("expected hash to be stable")
+	}
+
+	writeFile(t, filepath.Join(d, "sub", "variables.tf"), "c")
+	h3, _ := HashDir(d)
+	if h1 == h3 {
+		t.Error("expected hash to change with content")
+	}
+
+	writeFile(t, filepath.Join(d, ".git", "HEAD"), "ref: refs/heads/main")
+	if h4, _ := HashDir(d); h4 != h3 {
+		t.Error("expected hash to ignore git metadata")
+	}
+
+	if _, err := HashDir(filepath.Join(d, "missing")); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
+
+func TestInfo(t *testing.T) {
+	t.Setenv(DirEnvVar, t.TempDir())
+	type info struct{ Name string }
+
+	var got info
+	if LoadInfo("k", &got) {
+		t.Error("expected cache miss")
+	}
+	if err := StoreInfo("k", info{Name: "zebra"}); err != nil {
+		t.Fatal(err)
+	}
+	if !LoadInfo("k", &got) || got.Name != "zebra" {
+		t.Errorf("expected cache hit, got %#v", got)
+	}
+
+	Enabled = false
+	defer func() { Enabled = true }()
+	if LoadInfo("k", &got) {
+		t.Error("expected cache miss when disabled")
+	}
+}
+
+func TestSource(t *testing.T) {
+	t.Setenv(DirEnvVar, t.TempDir())
+
+	if _, ok := SourceDir("s"); ok {
+		t.Error("expected cache miss")
+	}
+	if _, err := StoreSource("s", func(dst string) error { return errors.New("fetch failed") }); err == nil {
+		t.Error("expected error from fetch to be returned")
+	}
+	if _, ok := SourceDir("s"); ok {
+		t.Error("expected failed fetch to leave no entry")
+	}
+
+	p, err := StoreSource("s", func(dst string) error {
+		writeFile(t, filepath.Join(dst, "main.tf"), "a")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := SourceDir("s"); !ok || got != p {
+		t.Errorf("expected cache hit at %s, got %s", p, got)
+	}
+
+	if err := Clean(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := SourceDir("s"); ok {
+		t.Error("expected cache miss after clean")
+	}
+}
+
+func TestValidatorResults(t *testing.T) {
+	t.Setenv(DirEnvVar, t.TempDir())
+	defer func(f func() time.Time, ttl time.Duration) { now, ValidatorTTL = f, ttl }(now, ValidatorTTL)
+	start := time.Now()
+	now = func() time.Time { return start }
+	ValidatorTTL = time.Hour
+
+	if ValidatorPassed("v") {
+		t.Error("expected cache miss")
+	}
+	if err := StoreValidatorPass("v"); err != nil {
+		t.Fatal(err)
+	}
+	if !ValidatorPassed("v") {
+		t.Error("expected cache hit")
+	}
+
+	now = func() time.Time { return start.Add(2 * time.Hour) }
+	if ValidatorPassed("v") {
+		t.Error("expected expired result to miss")
+	}
+
+	now = func() time.Time { return start }
+	ValidatorTTL = 0
+	if ValidatorPassed("v") {
+		t.Error("expected cache miss when the TTL is 0")
+	}
+
+	ValidatorTTL = time.Hour
+	Enabled = false
+	defer func() { Enabled = true }()
+	if ValidatorPassed("v") {
+		t.Error("expected cache miss when disabled")
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cdktf exports deployments as CDK for Terraform (cdktf) TypeScript
+// projects, with a stack per Terraform deployment group
+package cdktf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/sourcereader"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+const cdktfJSON = `{
+  "language": "typescript",
+  "app": "npx ts-node main.ts",
+  "terraformProviders": [],
+  "terraformModules": [],
+  "context": {}
+}
+`
+
+const packageJSON = `{
+  "name": "%s",
+  "private": true,
+  "main": "main.js",
+  "scripts": {
+    "get": "cdktf get",
+    "synth": "cdktf synth"
+  },
+  "dependencies": {
+    "cdktf": "^0.20.0",
+    "constructs": "^10.3.0"
+  },
+  "devDependencies": {
+    "@types/node": "^20.0.0",
+    "ts-node": "^10.9.0",
+    "typescript": "^5.0.0"
+  }
+}
+`
+
+const tsconfigJSON = `{
+  "compilerOptions": {
+    "target": "ES2018",
+    "module": "CommonJS",
+    "lib": ["es2018"],
+    "strict": true,
+    "esModuleInterop": true,
+    "skipLibCheck": true
+  },
+  "include": ["main.ts"]
+}
+`
+
+// Write writes a cdktf project of the expanded blueprint of the deployment
+// directory in outDir. Sources of local modules, copied into the deployment
+// directory, are resolved relative to the project.
+func Write(bp config.Blueprint, deploymentDir string, outDir string) error {
+	name, err := bp.DeploymentName()
+	if err != nil {
+		return err
+	}
+	absDeployment, err := filepath.Abs(deploymentDir)
+	if err != nil {
+		return err
+	}
+	absOut, err := filepath.Abs(outDir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(absOut, absDeployment)
+	if err != nil {
+		return err
+	}
+	main := Main(bp, filepath.ToSlash(rel))
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	files := map[string]string{
+		"cdktf.json":    cdktfJSON,
+		"package.json":  fmt.Sprintf(packageJSON, name),
+		"tsconfig.json": tsconfigJSON,
+		"main.ts":       main,
+	}
+	for _, f := range maps.Keys(files) {
+		if err := os.WriteFile(filepath.Join(outDir, f), []byte(files[f]), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Main returns the main.ts of the project: a TerraformStack per Terraform
+// group, with a TerraformVariable per deployment variable it uses and a
+// TerraformHclModule per module. Outputs of other groups are passed to the
+// stack as TerraformLocals, which cdktf turns into cross-stack references;
+// outputs of Packer groups, which are not exported, become
+// TerraformVariables. deploymentDir is the deployment directory relative to
+// the project.
+func Main(bp config.Blueprint, deploymentDir string) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, `import * as path from "path";`)
+	fmt.Fprintln(&b, `import { App, TerraformHclModule, TerraformLocal, TerraformOutput, TerraformStack, TerraformVariable } from "cdktf";`)
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "const app = new App();")
+
+	for _, g := range bp.DeploymentGroups {
+		fmt.Fprintln(&b)
+		switch g.Kind {
+		case config.PackerKind:
+			fmt.Fprintf(&b, "// Packer group %s is not exported, build its images with ghpc image-build\n", g.Name)
+			continue
+		case config.HelmKind:
+			fmt.Fprintf(&b, "// Helm group %s is not exported, install its charts with ghpc deploy\n", g.Name)
+			continue
+		}
+		writeStack(&b, bp, g, deploymentDir)
+	}
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "app.synth();")
+	return b.String()
+}
+
+func writeStack(b *strings.Builder, bp config.Blueprint, g config.DeploymentGroup, deploymentDir string) {
+	stack := "stack_" + ident(string(g.Name))
+	fmt.Fprintf(b, "// Deployment group %s\n", g.Name)
+	fmt.Fprintf(b, "const %s = new TerraformStack(app, %s);\n", stack, quote(string(g.Name)))
+	if be := g.TerraformBackend; be.Type != "" {
+		conf := cty.ObjectVal(map[string]cty.Value{be.Type: be.Configuration.AsObject()})
+		fmt.Fprintf(b, "%s.addOverride(\"terraform.backend\", %s);\n", stack, value(conf, "", nil))
+	}
+
+	// labels are always passed, as they are implicitly added to modules
+	used := map[string]bool{"labels": true}
+	for _, m := range g.Modules {
+		for _, v := range config.GetUsedDeploymentVars(bp.WithModuleVars(m.Settings.AsObject())) {
+			used[v] = true
+		}
+	}
+	// module variables are written as locals
+	moduleVars := bp.GroupModuleVars(g)
+	for _, v := range moduleVars {
+		delete(used, v)
+	}
+	// the project of the group is always passed to the providers
+	if g.ProjectID != "" {
+		used[bp.TargetCloud().ProjectVar] = true
+	}
+	for _, v := range bp.ProviderVars() {
+		used[v] = true
+	}
+	// the provider blocks of a provider are a list if it has aliases
+	blocks := map[string][]cty.Value{}
+	names := []string{}
+	for _, pb := range bp.ProviderBlocks(g, bp.Vars.Items()) {
+		settings := map[string]cty.Value{}
+		if pb.Alias != "" {
+			settings["alias"] = cty.StringVal(pb.Alias)
+		}
+		for _, a := range pb.Arguments {
+			settings[a.Name] = a.Value
+			for _, v := range config.GetUsedDeploymentVars(a.Value) {
+				used[v] = true
+			}
+		}
+		for _, b := range pb.Blocks {
+			settings[b] = cty.EmptyObjectVal
+		}
+		if len(settings) == 0 {
+			continue
+		}
+		if _, ok := blocks[pb.Name]; !ok {
+			names = append(names, pb.Name)
+		}
+		blocks[pb.Name] = append(blocks[pb.Name], cty.ObjectVal(settings))
+	}
+	providers := map[string]cty.Value{}
+	for _, n := range names {
+		if len(blocks[n]) == 1 {
+			providers[n] = blocks[n][0]
+		} else {
+			providers[n] = cty.TupleVal(blocks[n])
+		}
+	}
+	if len(providers) > 0 {
+		fmt.Fprintf(b, "%s.addOverride(\"provider\", %s);\n", stack,
+			value(cty.ObjectVal(providers), "", nil))
+	}
+	groupVars := bp.GroupVars(g)
+	vars := groupVars.Items()
+	for _, n := range sortedKeys(vars) {
+		if used[n] {
+			fmt.Fprintf(b, "new TerraformVariable(%s, %s, { default: %s });\n", stack, quote(n), value(vars[n], "", nil))
+		}
+	}
+
+	// intergroup references are replaced with a local, or a variable for
+	// outputs of Packer groups
+	igc := map[string]string{}
+	refs := g.FindAllIntergroupReferences(bp)
+	sort.Slice(refs, func(i, j int) bool {
+		return config.AutomaticOutputName(refs[i].Name, refs[i].Module) < config.AutomaticOutputName(refs[j].Name, refs[j].Module)
+	})
+	for _, r := range refs {
+		n := config.AutomaticOutputName(r.Name, r.Module)
+		ref := fmt.Sprintf("module.%s.%s", r.Module, r.Name)
+		if bp.ModuleGroupOrDie(r.Module).Kind == config.TerraformKind {
+			fmt.Fprintf(b, "new TerraformLocal(%s, %s, %s.get(%s));\n", stack, quote(n), "module_"+ident(string(r.Module)), quote(r.Name))
+			igc[ref] = "local." + n
+		} else {
+			fmt.Fprintf(b, "new TerraformVariable(%s, %s, { description: %s });\n", stack, quote(n),
+				quote(fmt.Sprintf("Output %s of Packer module %s", r.Name, r.Module)))
+			igc[ref] = "var." + n
+		}
+	}
+
+	for _, n := range moduleVars {
+		igc["var."+n] = "local." + n
+	}
+	for _, n := range moduleVars {
+		fmt.Fprintf(b, "new TerraformLocal(%s, %s, %s);\n", stack, quote(n), value(bp.Vars.Get(n), "", igc))
+	}
+
+	for _, m := range g.Modules {
+		fmt.Fprintf(b, "const %s = new TerraformHclModule(%s, %s, {\n", "module_"+ident(string(m.ID)), stack, quote(string(m.ID)))
+		if sourcereader.IsLocalPath(m.DeploymentSource) {
+			src := filepath.ToSlash(filepath.Join(deploymentDir, string(g.Name), m.DeploymentSource))
+			fmt.Fprintf(b, "  source: path.resolve(__dirname, %s),\n", quote(src))
+		} else {
+			fmt.Fprintf(b, "  source: %s,\n", quote(m.DeploymentSource))
+			if m.Version != "" && sourcereader.IsRegistryPath(m.DeploymentSource) {
+				fmt.Fprintf(b, "  version: %s,\n", quote(m.Version))
+			}
+		}
+		fmt.Fprintf(b, "  variables: %s,\n", value(m.Settings.AsObject(), "  ", igc))
+		fmt.Fprintln(b, "});")
+		if len(m.Providers) > 0 {
+			// providers are passed by reference, e.g. "google.europe"
+			refs := map[string]cty.Value{}
+			for k, v := range m.Providers {
+				refs[k] = cty.StringVal(v)
+			}
+			fmt.Fprintf(b, "%s.addOverride(\"providers\", %s);\n", "module_"+ident(string(m.ID)),
+				value(cty.ObjectVal(refs), "", nil))
+		}
+	}
+
+	for _, m := range g.Modules {
+		for _, o := range m.Outputs {
+			opts := []string{fmt.Sprintf("value: %s.get(%s)", "module_"+ident(string(m.ID)), quote(o.Name))}
+			if o.Description != "" {
+				opts = append(opts, "description: "+quote(o.Description))
+			}
+			if o.Sensitive {
+				opts = append(opts, "sensitive: true")
+			}
+			fmt.Fprintf(b, "new TerraformOutput(%s, %s, { %s });\n", stack,
+				quote(config.AutomaticOutputName(o.Name, m.ID)), strings.Join(opts, ", "))
+		}
+	}
+}
+
+// value returns the TypeScript literal of the value; expressions are written
+// as Terraform interpolations, which cdktf passes through, with the
+// references to modules of other groups and to module variables replaced as
+// in igc
+func value(v cty.Value, indent string, igc map[string]string) string {
+	if e, is := config.IsExpressionValue(v); is {
+		s := strings.TrimSpace(string(e.Tokenize().Bytes()))
+		for _, r := range e.References() {
+			ref := fmt.Sprintf("module.%s.%s", r.Module, r.Name)
+			if r.GlobalVar {
+				ref = "var." + r.Name
+			}
+			if to, ok := igc[ref]; ok {
+				s = strings.ReplaceAll(s, ref, to)
+			}
+		}
+		return quote("${" + s + "}")
+	}
+	if v.IsNull() || !v.IsKnown() {
+		return "null"
+	}
+	ty := v.Type()
+	switch {
+	case ty == cty.String:
+		// literal strings must not be interpolated by Terraform
+		s := strings.ReplaceAll(v.AsString(), "${", "$${")
+		return quote(strings.ReplaceAll(s, "%{", "%%{"))
+	case ty.IsPrimitiveType():
+		b, err := ctyjson.Marshal(v, ty)
+		if err != nil {
+			return "null"
+		}
+		return string(b)
+	case ty.IsListType() || ty.IsTupleType() || ty.IsSetType():
+		if v.LengthInt() == 0 {
+			return "[]"
+		}
+		items := []string{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+			items = append(items, indent+"  "+value(e, indent+"  ", igc))
+		}
+		return "[\n" + strings.Join(items, ",\n") + ",\n" + indent + "]"
+	case ty.IsMapType() || ty.IsObjectType():
+		if v.LengthInt() == 0 {
+			return "{}"
+		}
+		m := v.AsValueMap()
+		items := []string{}
+		for _, k := range sortedKeys(m) {
+			key := k
+			if !identExp.MatchString(k) {
+				key = quote(k)
+			}
+			items = append(items, indent+"  "+key+": "+value(m[k], indent+"  ", igc))
+		}
+		return "{\n" + strings.Join(items, ",\n") + ",\n" + indent + "}"
+	}
+	return "null"
+}
+
+var (
+	identExp    = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+	nonIdentExp = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// ident returns a TypeScript identifier for the name
+func ident(name string) string {
+	return nonIdentExp.ReplaceAllString(name, "_")
+}
+
+// quote returns the string literal of s
+func quote(s string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,185 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cdktf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func blueprint() config.Blueprint {
+	return config.Blueprint{
+		Vars: config.NewDict(map[string]cty.Value{
+			"deployment_name": cty.StringVal("hpc"),
+			"project_id":      cty.StringVal("my-project"),
+			"labels":          cty.MapValEmpty(cty.String),
+		}),
+		DeploymentGroups: []config.DeploymentGroup{
+			{Name: "primary", Kind: config.TerraformKind, Modules: []config.Module{{
+				ID:               "network1",
+				Kind:             config.TerraformKind,
+				DeploymentSource: "./modules/embedded/modules/network/vpc",
+				Settings: config.NewDict(map[string]cty.Value{
+					"project_id": config.GlobalRef("project_id").AsExpression().AsValue(),
+				}),
+				Outputs: []modulereader.OutputInfo{{Name: "network_self_link"}},
+			}}},
+			{Name: "image", Kind: config.PackerKind, Modules: []config.Module{{ID: "builder", Kind: config.PackerKind}}},
+			{Name: "cluster", Kind: config.TerraformKind, TerraformBackend: config.TerraformBackend{
+				Type:          "gcs",
+				Configuration: config.NewDict(map[string]cty.Value{"bucket": cty.StringVal("state")}),
+			}, Modules: []config.Module{{
+				ID:               "compute",
+				Kind:             config.TerraformKind,
+				DeploymentSource: "registry.terraform.io/terraform-google-modules/vm/google//modules/compute_instance",
+				Version:          "~> 10.0",
+				Settings: config.NewDict(map[string]cty.Value{
+					"network": config.ModuleRef("network1", "network_self_link").AsExpression().AsValue(),
+					"image":   config.ModuleRef("builder", "image_name").AsExpression().AsValue(),
+					"script":  cty.StringVal("echo ${HOME}"),
+				}),
+			}}},
+		},
+	}
+}
+
+func TestMainTS(t *testing.T) {
+	got := Main(blueprint(), "../hpc")
+	for _, want := range []string{
+		`const stack_primary = new TerraformStack(app, "primary");`,
+		`stack_primary.addOverride("provider", {`,
+		`project: "${var.project_id}",`,
+		`new TerraformVariable(stack_primary, "project_id", { default: "my-project" });`,
+		`source: path.resolve(__dirname, "../hpc/primary/modules/embedded/modules/network/vpc"),`,
+		`project_id: "${var.project_id}",`,
+		`new TerraformOutput(stack_primary, "network_self_link_network1", { value: module_network1.get("network_self_link") });`,
+		"// Packer group image is not exported",
+		`stack_cluster.addOverride("terraform.backend", {`,
+		`new TerraformLocal(stack_cluster, "network_self_link_network1", module_network1.get("network_self_link"));`,
+		`new TerraformVariable(stack_cluster, "image_name_builder", {`,
+		`version: "~> 10.0",`,
+		`image: "${var.image_name_builder}",`,
+		`network: "${local.network_self_link_network1}",`,
+		`script: "echo $${HOME}",`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("main.ts does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestMainTSModuleVars(t *testing.T) {
+	bp := blueprint()
+	bp.Vars.Set("network", config.ModuleRef("network1", "network_self_link").AsExpression().AsValue())
+	bp.DeploymentGroups[2].Modules[0].Settings.Set("network", config.GlobalRef("network").AsExpression().AsValue())
+	got := Main(bp, "../hpc")
+	for _, want := range []string{
+		`new TerraformLocal(stack_cluster, "network", "${local.network_self_link_network1}");`,
+		`network: "${local.network}",`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("main.ts does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `new TerraformVariable(stack_cluster, "network"`) {
+		t.Errorf("main.ts declares module variable network as a variable:\n%s", got)
+	}
+}
+
+func TestMainTSGroupProject(t *testing.T) {
+	bp := blueprint()
+	bp.DeploymentGroups[2].ProjectID = "service-project"
+	bp.DeploymentGroups[2].ImpersonateServiceAccount = "deployer@service-project.iam.gserviceaccount.com"
+	got := Main(bp, "../hpc")
+	for _, want := range []string{
+		`new TerraformVariable(stack_primary, "project_id", { default: "my-project" });`,
+		`new TerraformVariable(stack_cluster, "project_id", { default: "service-project" });`,
+		`impersonate_service_account: "deployer@service-project.iam.gserviceaccount.com",`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("main.ts does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestMainTSProviders(t *testing.T) {
+	bp := blueprint()
+	bp.TerraformProviders = map[string]config.TerraformProvider{
+		"google.europe": {Configuration: config.NewDict(map[string]cty.Value{
+			"region": cty.StringVal("europe-west1"),
+		})},
+	}
+	bp.DeploymentGroups[0].Modules[0].Providers = map[string]string{"google": "google.europe"}
+	got := Main(bp, "../hpc")
+	for _, want := range []string{
+		`alias: "europe",`,
+		`region: "europe-west1",`,
+		`module_network1.addOverride("providers", {`,
+		`google: "google.europe",`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("main.ts does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestValue(t *testing.T) {
+	v := cty.ObjectVal(map[string]cty.Value{
+		"count":    cty.NumberIntVal(2),
+		"tags":     cty.ListVal([]cty.Value{cty.StringVal("a")}),
+		"some-key": cty.True,
+		"empty":    cty.ListValEmpty(cty.String),
+	})
+	// keys are sorted
+	want := `{
+  count: 2,
+  empty: [],
+  "some-key": true,
+  tags: [
+    "a",
+  ],
+}`
+	if diff := cmp.Diff(want, value(v, "", nil)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "cdktf")
+	if err := Write(blueprint(), filepath.Join(dir, "hpc"), out); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"cdktf.json", "package.json", "tsconfig.json", "main.ts"} {
+		if _, err := os.Stat(filepath.Join(out, f)); err != nil {
+			t.Error(err)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(out, "main.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"../hpc/primary/modules`) {
+		t.Errorf("local sources are not relative to the project:\n%s", b)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ci generates CI pipelines that plan the deployment groups of a
+// deployment on pull or merge requests and deploy them when changes are
+// merged
+package ci
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of pipelines
+const (
+	GitHub = "github"
+	GitLab = "gitlab"
+)
+
+// Formats returns the supported formats
+func Formats() []string {
+	return []string{GitHub, GitLab}
+}
+
+// Group is a deployment group planned and deployed by a job of each stage
+type Group struct {
+	Name string
+	// Packer is whether the group builds images with Packer
+	Packer bool
+	// Helm is whether the group installs Helm charts
+	Helm bool
+	// Modules are the Packer modules of a Packer group, in build order, or
+	// the charts of a Helm group
+	Modules []string
+	// SharedVars is whether the modules of a Packer group share variables
+	SharedVars bool
+	// Needs are the groups whose plan or deployment must come first
+	Needs []string
+}
+
+// Pipeline plans and deploys a deployment directory committed to a repository
+type Pipeline struct {
+	// Deployment is the name of the deployment
+	Deployment string
+	// DeploymentDir is the deployment directory, relative to the root of the
+	// repository
+	DeploymentDir string
+	// Branch is the branch merges to which deploy the deployment
+	Branch string
+	// Groups are the deployment groups, in the order they are deployed
+	Groups []Group
+}
+
+// Write writes the pipeline in the format
+func Write(w io.Writer, format string, p Pipeline) error {
+	var doc *yaml.Node
+	switch format {
+	case GitHub:
+		doc = gitHubWorkflow(p)
+	case GitLab:
+		doc = gitLabPipeline(p)
+	default:
+		return fmt.Errorf("unknown CI format %q, must be one of %s", format, strings.Join(Formats(), ", "))
+	}
+	fmt.Fprintf(w, "# Generated by ghpc export-ci for deployment %s; regenerate it after\n# changing the blueprint of the deployment.\n", p.Deployment)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// groupDir returns the directory of the group in the pipeline environment
+func groupDir(g Group) string {
+	return path.Join("$DEPLOYMENT_DIR", g.Name)
+}
+
+// planScript returns the commands that plan the changes to the group, with
+// the inputs it uses from earlier groups
+func planScript(g Group) []string {
+	dir := groupDir(g)
+	script := []string{fmt.Sprintf(`ghpc import-inputs "%s"`, dir)}
+	if g.Helm {
+		for _, m := range g.Modules {
+			chart := path.Join(dir, m)
+			script = append(script, fmt.Sprintf(`helm lint "%s" --values "%s"`, chart, path.Join(chart, "ghpc-values.yaml")))
+		}
+		return script
+	}
+	if !g.Packer {
+		return append(script,
+			fmt.Sprintf(`terraform -chdir="%s" init -input=false`, dir),
+			fmt.Sprintf(`terraform -chdir="%s" plan -input=false -lock=false`, dir))
+	}
+	varFile := ""
+	if g.SharedVars {
+		varFile = " -var-file=../shared.pkrvars.hcl"
+	}
+	for _, m := range g.Modules {
+		script = append(script, fmt.Sprintf(`(cd "%s" && packer init . && packer validate%s .)`, path.Join(dir, m), varFile))
+	}
+	return script
+}
+
+// deployScript returns the command that deploys the group
+func deployScript(g Group) []string {
+	return []string{fmt.Sprintf(`ghpc deploy "$DEPLOYMENT_DIR" --only %s --auto-approve`, g.Name)}
+}
+
+// artifactsPath is the directory of the deployment state and outputs
+// passed from each deploy job to the next
+func artifactsPath(p Pipeline) string {
+	return path.Join(p.DeploymentDir, ".ghpc", "artifacts")
+}
+
+// mapping returns a YAML mapping of the keys and values, in order
+func mapping(kv ...interface{}) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	for i := 0; i+1 < len(kv); i += 2 {
+		n.Content = append(n.Content, node(kv[i]), node(kv[i+1]))
+	}
+	return n
+}
+
+// sequence returns a YAML sequence of the items
+func sequence(items ...interface{}) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, i := range items {
+		n.Content = append(n.Content, node(i))
+	}
+	return n
+}
+
+// node returns the YAML node of a value; multi-line strings are written as
+// literal blocks
+func node(v interface{}) *yaml.Node {
+	switch v := v.(type) {
+	case *yaml.Node:
+		return v
+	case string:
+		n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+		if strings.Contains(v, "\n") {
+			n.Style = yaml.LiteralStyle
+		}
+		return n
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return sequence(items...)
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(v)}
+	default:
+		panic(fmt.Sprintf("unsupported YAML value %#v", v))
+	}
+}
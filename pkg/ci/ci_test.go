@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ci
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+func pipelineForTest() Pipeline {
+	return Pipeline{Deployment: "hpc", DeploymentDir: "deployments/hpc", Branch: "main", Groups: []Group{
+		{Name: "primary"},
+		{Name: "image", Packer: true, Modules: []string{"base", "gpu"}, SharedVars: true, Needs: []string{"primary"}},
+		{Name: "cluster", Needs: []string{"image", "primary"}},
+	}}
+}
+
+// writeForTest writes the pipeline in the format and parses it back
+func writeForTest(t *testing.T, format string) map[string]interface{} {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, format, pipelineForTest()); err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid YAML: %v\n%s", err, buf.String())
+	}
+	return doc
+}
+
+// get returns the value at the keys of nested mappings
+func get(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
+
+func TestGitHubWorkflow(t *testing.T) {
+	doc := writeForTest(t, GitHub)
+	jobs := get(doc, "jobs").(map[string]interface{})
+	want := []string{"deploy-cluster", "deploy-image", "deploy-primary", "plan-cluster", "plan-image", "plan-primary"}
+	got := maps.Keys(jobs)
+	slices.Sort(got)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff of jobs (-want +got):\n%s", diff)
+	}
+
+	// plans follow the dependencies of the groups, deployments their order
+	if diff := cmp.Diff([]interface{}{"plan-image", "plan-primary"}, get(jobs, "plan-cluster", "needs")); diff != "" {
+		t.Errorf("diff of needs (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]interface{}{"deploy-image"}, get(jobs, "deploy-cluster", "needs")); diff != "" {
+		t.Errorf("diff of needs (-want +got):\n%s", diff)
+	}
+	if got := get(jobs, "plan-primary", "if"); got != "github.event_name == 'pull_request'" {
+		t.Errorf("got condition %v of plan job", got)
+	}
+
+	plan := get(jobs, "plan-image", "steps").([]interface{})
+	run := get(plan[len(plan)-1], "run").(string)
+	if !strings.Contains(run, `(cd "$DEPLOYMENT_DIR/image/gpu" && packer init . && packer validate -var-file=../shared.pkrvars.hcl .)`) {
+		t.Errorf("plan of Packer group does not validate module gpu:\n%s", run)
+	}
+
+	// the deployment state is passed from each deploy job to the next
+	steps := get(jobs, "deploy-image", "steps").([]interface{})
+	if got := get(steps[2], "with", "name"); got != "ghpc-primary" {
+		t.Errorf("got downloaded artifact %v, want ghpc-primary", got)
+	}
+	if got := get(steps[3], "run"); got != "ghpc deploy \"$DEPLOYMENT_DIR\" --only image --auto-approve\n" {
+		t.Errorf("got deploy step %q", got)
+	}
+	if got := get(steps[4], "with", "path"); got != "deployments/hpc/.ghpc/artifacts" {
+		t.Errorf("got uploaded path %v", got)
+	}
+}
+
+func TestGitLabPipeline(t *testing.T) {
+	doc := writeForTest(t, GitLab)
+	if diff := cmp.Diff([]interface{}{}, get(doc, "plan:primary", "needs")); diff != "" {
+		t.Errorf("diff of needs (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]interface{}{"deploy:primary"}, get(doc, "deploy:image", "needs")); diff != "" {
+		t.Errorf("diff of needs (-want +got):\n%s", diff)
+	}
+	if got := get(doc, "deploy:image", "resource_group"); got != "ghpc-hpc" {
+		t.Errorf("got resource group %v", got)
+	}
+	rules := get(doc, "deploy:cluster", "rules").([]interface{})
+	if got := get(rules[0], "if"); got != `$CI_COMMIT_BRANCH == "main"` {
+		t.Errorf("got rule %v", got)
+	}
+	if got := get(doc, "default", "image"); got != "$GHPC_IMAGE" {
+		t.Errorf("got image %v", got)
+	}
+}
+
+func TestHelmPlan(t *testing.T) {
+	g := Group{Name: "apps", Helm: true, Modules: []string{"kueue"}}
+	want := []string{
+		`ghpc import-inputs "$DEPLOYMENT_DIR/apps"`,
+		`helm lint "$DEPLOYMENT_DIR/apps/kueue" --values "$DEPLOYMENT_DIR/apps/kueue/ghpc-values.yaml"`,
+	}
+	if diff := cmp.Diff(want, planScript(g)); diff != "" {
+		t.Errorf("diff of plan (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "jenkins", pipelineForTest()); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
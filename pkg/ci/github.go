@@ -0,0 +1,101 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ci
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var invalidJobID = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// gitHubJobID returns the ID of the job of the stage for the group
+func gitHubJobID(stage string, group string) string {
+	return stage + "-" + invalidJobID.ReplaceAllString(group, "_")
+}
+
+// gitHubSetup returns the steps that check out the repository and
+// authenticate to Google Cloud with Workload Identity Federation
+func gitHubSetup() []interface{} {
+	return []interface{}{
+		mapping("uses", "actions/checkout@v4"),
+		mapping("uses", "google-github-actions/auth@v2", "with", mapping(
+			"workload_identity_provider", "${{ vars.GCP_WORKLOAD_IDENTITY_PROVIDER }}",
+			"service_account", "${{ vars.GCP_SERVICE_ACCOUNT }}")),
+	}
+}
+
+// gitHubJob returns a job of the stage, run when the condition is true in a
+// container of the ghpc image
+func gitHubJob(condition string, needs []string, steps []interface{}) *yaml.Node {
+	job := mapping(
+		"if", condition,
+		"runs-on", "ubuntu-latest",
+		"container", "${{ vars.GHPC_IMAGE }}")
+	if len(needs) > 0 {
+		job.Content = append(job.Content, node("needs"), node(needs))
+	}
+	job.Content = append(job.Content, node("steps"), sequence(steps...))
+	return job
+}
+
+func script(lines []string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// gitHubWorkflow returns a workflow with a plan job per group on pull
+// requests and a deploy job per group on pushes to the branch. Deploy jobs run
+// one after the other and pass the deployment state to the next job as an
+// artifact.
+func gitHubWorkflow(p Pipeline) *yaml.Node {
+	trigger := mapping("branches", []string{p.Branch}, "paths", []string{p.DeploymentDir + "/**"})
+	jobs := mapping()
+	for _, g := range p.Groups {
+		needs := []string{}
+		for _, n := range g.Needs {
+			needs = append(needs, gitHubJobID("plan", n))
+		}
+		steps := append(gitHubSetup(), mapping("name", "Plan "+g.Name, "run", script(planScript(g))))
+		jobs.Content = append(jobs.Content, node(gitHubJobID("plan", g.Name)),
+			gitHubJob("github.event_name == 'pull_request'", needs, steps))
+	}
+	for i, g := range p.Groups {
+		needs := []string{}
+		steps := gitHubSetup()
+		if i > 0 {
+			prev := p.Groups[i-1].Name
+			needs = append(needs, gitHubJobID("deploy", prev))
+			steps = append(steps, mapping("uses", "actions/download-artifact@v4", "with", mapping(
+				"name", gitHubJobID("ghpc", prev), "path", artifactsPath(p))))
+		}
+		steps = append(steps,
+			mapping("name", "Deploy "+g.Name, "run", script(deployScript(g))),
+			mapping("uses", "actions/upload-artifact@v4", "with", mapping(
+				"name", gitHubJobID("ghpc", g.Name), "path", artifactsPath(p), "include-hidden-files", true)))
+		jobs.Content = append(jobs.Content, node(gitHubJobID("deploy", g.Name)),
+			gitHubJob("github.event_name == 'push'", needs, steps))
+	}
+
+	return mapping(
+		"name", "ghpc "+p.Deployment,
+		"on", mapping("pull_request", trigger, "push", trigger),
+		"permissions", mapping("contents", "read", "id-token", "write"),
+		// deployments of the same deployment are not run concurrently
+		"concurrency", mapping("group", "ghpc-"+p.Deployment, "cancel-in-progress", false),
+		"env", mapping("DEPLOYMENT_DIR", p.DeploymentDir),
+		"jobs", jobs)
+}
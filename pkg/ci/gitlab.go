@@ -0,0 +1,81 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ci
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// gitLabCredentials writes the configuration of Workload Identity Federation
+// with the ID token of the job as application default credentials
+const gitLabCredentials = `echo "$GCP_ID_TOKEN" > "$CI_PROJECT_DIR/.gcp-id-token"
+cat > "$GOOGLE_APPLICATION_CREDENTIALS" <<CREDENTIALS
+{
+  "type": "external_account",
+  "audience": "//iam.googleapis.com/$GCP_WORKLOAD_IDENTITY_PROVIDER",
+  "subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+  "token_url": "https://sts.googleapis.com/v1/token",
+  "credential_source": {"file": "$CI_PROJECT_DIR/.gcp-id-token"},
+  "service_account_impersonation_url": "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/$GCP_SERVICE_ACCOUNT:generateAccessToken"
+}
+CREDENTIALS
+`
+
+// gitLabJob returns a job of the stage, run when the rule matches
+func gitLabJob(stage string, rule string, needs []string, lines []string) *yaml.Node {
+	return mapping(
+		"stage", stage,
+		"rules", sequence(mapping("if", rule)),
+		"needs", needs,
+		"script", lines)
+}
+
+// gitLabPipeline returns a pipeline with a plan job per group on merge
+// requests and a deploy job per group on commits to the branch. Deploy jobs
+// run one after the other and pass the deployment state to the next job as an
+// artifact.
+func gitLabPipeline(p Pipeline) *yaml.Node {
+	doc := mapping(
+		"stages", []string{"plan", "deploy"},
+		"variables", mapping(
+			"DEPLOYMENT_DIR", p.DeploymentDir,
+			"GOOGLE_APPLICATION_CREDENTIALS", "$CI_PROJECT_DIR/.gcp-credentials.json"),
+		"default", mapping(
+			"image", "$GHPC_IMAGE",
+			"id_tokens", mapping("GCP_ID_TOKEN", mapping("aud", "https://iam.googleapis.com/$GCP_WORKLOAD_IDENTITY_PROVIDER")),
+			"before_script", []string{gitLabCredentials}))
+
+	for _, g := range p.Groups {
+		needs := []string{}
+		for _, n := range g.Needs {
+			needs = append(needs, "plan:"+n)
+		}
+		doc.Content = append(doc.Content, node("plan:"+g.Name),
+			gitLabJob("plan", `$CI_PIPELINE_SOURCE == "merge_request_event"`, needs, planScript(g)))
+	}
+	for i, g := range p.Groups {
+		needs := []string{}
+		if i > 0 {
+			needs = append(needs, "deploy:"+p.Groups[i-1].Name)
+		}
+		job := gitLabJob("deploy", `$CI_COMMIT_BRANCH == "`+p.Branch+`"`, needs, deployScript(g))
+		job.Content = append(job.Content,
+			// deployments of the same deployment are not run concurrently
+			node("resource_group"), node("ghpc-"+p.Deployment),
+			node("artifacts"), mapping("paths", []string{artifactsPath(p)}))
+		doc.Content = append(doc.Content, node("deploy:"+g.Name), job)
+	}
+	return doc
+}
@@ -0,0 +1,116 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"hpc-toolkit/pkg/gcsupload"
+)
+
+var artifactNameExp = regexp.MustCompile(`^[a-z]([-_a-z0-9]*[a-z0-9])?$`)
+
+// Artifact is a local file, e.g. a startup bundle or a container image
+// archive, that is uploaded to Cloud Storage before a deployment group is
+// deployed
+type Artifact struct {
+	Name string `yaml:"name"`
+	// Source is the path of the local file
+	Source string `yaml:"source"`
+	// Destination is the gs://<bucket>/<object> URL the file is uploaded to
+	Destination string `yaml:"destination"`
+	// DeploymentGroup is the group the artifact is uploaded before, the
+	// first group if unset
+	DeploymentGroup GroupName `yaml:"deployment_group,omitempty"`
+}
+
+// ArtifactsOf returns the artifacts uploaded before the group is deployed
+func (bp Blueprint) ArtifactsOf(group GroupName) []Artifact {
+	first := len(bp.DeploymentGroups) > 0 && bp.DeploymentGroups[0].Name == group
+	as := []Artifact{}
+	for _, a := range bp.Artifacts {
+		if a.DeploymentGroup == group || (a.DeploymentGroup == "" && first) {
+			as = append(as, a)
+		}
+	}
+	return as
+}
+
+// checkArtifacts validates the artifacts block
+func checkArtifacts(bp Blueprint) error {
+	names := map[string]bool{}
+	destinations := map[string]bool{}
+	for _, a := range bp.Artifacts {
+		if !artifactNameExp.MatchString(a.Name) {
+			return fmt.Errorf("artifact name %q must contain only lowercase letters, numbers, dashes and underscores", a.Name)
+		}
+		if names[a.Name] {
+			return fmt.Errorf("artifact names must be unique: %s used more than once", a.Name)
+		}
+		names[a.Name] = true
+
+		if a.Source == "" {
+			return fmt.Errorf("artifact %s: source is required", a.Name)
+		}
+		if fi, err := os.Stat(a.Source); err != nil || !fi.Mode().IsRegular() {
+			return fmt.Errorf("artifact %s: source %s is not a file", a.Name, a.Source)
+		}
+		if _, _, err := gcsupload.ParseURL(a.Destination); err != nil {
+			return fmt.Errorf("artifact %s: invalid destination: %w", a.Name, err)
+		}
+		if destinations[a.Destination] {
+			return fmt.Errorf("artifact %s: destination %s is used by another artifact", a.Name, a.Destination)
+		}
+		destinations[a.Destination] = true
+
+		if a.DeploymentGroup != "" {
+			if _, err := bp.Group(a.DeploymentGroup); err != nil {
+				return fmt.Errorf("artifact %s: %w", a.Name, err)
+			}
+		}
+	}
+	return checkArtifactStore(bp.ArtifactStore)
+}
+
+// checkArtifactStore checks that the artifact store, if set, is a
+// gs://<bucket>/<prefix> URL; the prefix is optional
+func checkArtifactStore(store string) error {
+	if store == "" {
+		return nil
+	}
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(store, "gs://"), "/")
+	if !strings.HasPrefix(store, "gs://") || bucket == "" {
+		return fmt.Errorf("artifact_store %q must be a gs://<bucket>/<prefix> URL", store)
+	}
+	return nil
+}
+
+// absArtifactSources makes the sources of artifacts absolute, so that they
+// can be uploaded from any directory
+func (bp *Blueprint) absArtifactSources() error {
+	for i := range bp.Artifacts {
+		a := &bp.Artifacts[i]
+		abs, err := filepath.Abs(a.Source)
+		if err != nil {
+			return fmt.Errorf("artifact %s: %w", a.Name, err)
+		}
+		a.Source = abs
+	}
+	return nil
+}
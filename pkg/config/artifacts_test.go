@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "bundle.tar.gz")
+	if err := os.WriteFile(src, []byte("bundle"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	valid := Artifact{Name: "bundle", Source: src, Destination: "gs://b/bundle.tar.gz"}
+
+	for _, tc := range []struct {
+		name      string
+		artifacts []Artifact
+		wantErr   bool
+	}{
+		{"valid", []Artifact{valid}, false},
+		{"group", []Artifact{{Name: "bundle", Source: src, Destination: "gs://b/o", DeploymentGroup: "primary"}}, false},
+		{"bad name", []Artifact{{Name: "Bundle", Source: src, Destination: "gs://b/o"}}, true},
+		{"duplicate name", []Artifact{valid, {Name: "bundle", Source: src, Destination: "gs://b/other"}}, true},
+		{"duplicate destination", []Artifact{valid, {Name: "other", Source: src, Destination: valid.Destination}}, true},
+		{"missing source", []Artifact{{Name: "bundle", Source: filepath.Join(dir, "missing"), Destination: "gs://b/o"}}, true},
+		{"directory source", []Artifact{{Name: "bundle", Source: dir, Destination: "gs://b/o"}}, true},
+		{"bad destination", []Artifact{{Name: "bundle", Source: src, Destination: "gs://b/"}}, true},
+		{"unknown group", []Artifact{{Name: "bundle", Source: src, Destination: "gs://b/o", DeploymentGroup: "other"}}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := Blueprint{
+				DeploymentGroups: []DeploymentGroup{{Name: "primary"}},
+				Artifacts:        tc.artifacts,
+			}
+			if err := checkArtifacts(bp); (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckArtifactStore(t *testing.T) {
+	for _, tc := range []struct {
+		store   string
+		wantErr bool
+	}{
+		{"", false},
+		{"gs://b", false},
+		{"gs://b/deployments/hpc", false},
+		{"gs://", true},
+		{"s3://b/deployments", true},
+	} {
+		if err := checkArtifactStore(tc.store); (err != nil) != tc.wantErr {
+			t.Errorf("%q: got error %v, want error: %v", tc.store, err, tc.wantErr)
+		}
+	}
+}
+
+func TestArtifactsOf(t *testing.T) {
+	bp := Blueprint{
+		DeploymentGroups: []DeploymentGroup{{Name: "primary"}, {Name: "cluster"}},
+		Artifacts: []Artifact{
+			{Name: "a"},
+			{Name: "b", DeploymentGroup: "cluster"},
+			{Name: "c", DeploymentGroup: "primary"},
+		},
+	}
+	names := func(as []Artifact) []string {
+		r := []string{}
+		for _, a := range as {
+			r = append(r, a.Name)
+		}
+		return r
+	}
+	if diff := cmp.Diff([]string{"a", "c"}, names(bp.ArtifactsOf("primary"))); diff != "" {
+		t.Errorf("primary artifacts diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"b"}, names(bp.ArtifactsOf("cluster"))); diff != "" {
+		t.Errorf("cluster artifacts diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestAbsArtifactSources(t *testing.T) {
+	bp := Blueprint{Artifacts: []Artifact{{Name: "a", Source: "build/a.tar"}, {Name: "b", Source: "/tmp/b.tar"}}}
+	if err := bp.absArtifactSources(); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	want := []string{filepath.Join(wd, "build/a.tar"), "/tmp/b.tar"}
+	for i, a := range bp.Artifacts {
+		if a.Source != want[i] {
+			t.Errorf("artifact %s: got source %q, want %q", a.Name, a.Source, want[i])
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+// defaultBucketLocation is the location of backend buckets of deployments
+// without a region
+const defaultBucketLocation = "US"
+
+// BackendBucket is a Cloud Storage bucket the Terraform state of the
+// deployment is stored in
+type BackendBucket struct {
+	Name     string
+	Project  string
+	Location string
+	Labels   map[string]string
+}
+
+// BackendBuckets returns the buckets of the gcs backends of the Terraform
+// groups, in the project and region of the deployment and with its labels.
+// Buckets are located in the "US" multi-region if region is not set.
+func (bp Blueprint) BackendBuckets() ([]BackendBucket, error) {
+	names := []string{}
+	for _, g := range bp.DeploymentGroups {
+		be := g.TerraformBackend
+		if g.Kind != TerraformKind || be.Type != "gcs" {
+			continue
+		}
+		b := be.Configuration.Get("bucket")
+		if b.IsNull() || b.Type() != cty.String || b.AsString() == "" {
+			return nil, fmt.Errorf("group %s: the gcs backend must set bucket", g.Name)
+		}
+		if !slices.Contains(names, b.AsString()) {
+			names = append(names, b.AsString())
+		}
+	}
+	if len(names) == 0 {
+		return []BackendBucket{}, nil
+	}
+
+	project := bp.Vars.Get("project_id")
+	if project.IsNull() || project.Type() != cty.String || project.AsString() == "" {
+		return nil, fmt.Errorf("deployment variable project_id must be set to create backend buckets")
+	}
+	location := defaultBucketLocation
+	if r := bp.Vars.Get("region"); !r.IsNull() && r.Type() == cty.String && r.AsString() != "" {
+		location = r.AsString()
+	}
+	labels := map[string]string{}
+	if l := bp.Vars.Get("labels"); !l.IsNull() && (l.Type().IsObjectType() || l.Type().IsMapType()) {
+		for k, v := range l.AsValueMap() {
+			if !v.IsNull() && v.Type() == cty.String {
+				labels[k] = v.AsString()
+			}
+		}
+	}
+
+	buckets := []BackendBucket{}
+	for _, n := range names {
+		buckets = append(buckets, BackendBucket{Name: n, Project: project.AsString(), Location: location, Labels: labels})
+	}
+	return buckets, nil
+}
+
+// usesModule returns whether the module uses, or references an output of, the
+// module id
+func usesModule(m Module, id ModuleID) bool {
+	if m.uses(id) {
+		return true
+	}
+	found := false
+	cty.Walk(m.Settings.AsObject(), func(p cty.Path, v cty.Value) (bool, error) {
+		if e, is := IsExpressionValue(v); is {
+			for _, r := range e.References() {
+				found = found || (!r.GlobalVar && r.Module == id)
+			}
+		}
+		return true, nil
+	})
+	return found
+}
+
+// splitBackendModules moves the modules that set a terraform_backend into
+// deployment groups of their own, named <group>-<module>, so that their state
+// is stored in that backend. The group of a module is deployed before the
+// group it is split from, or after it if the module uses other modules of the
+// group. A group whose modules all set a backend keeps its first module.
+// The new groups are deployed to the project, as the service account and
+// after the groups of the group they are split from, and the groups that
+// depend on that group also depend on them. Fails if the name of a new group
+// is already taken, or if the group is a bootstrap group or has hooks, which
+// are not split.
+func (bp *Blueprint) splitBackendModules() error {
+	// what each group name is taken by, to report collisions
+	taken := map[GroupName]string{}
+	for _, g := range bp.DeploymentGroups {
+		taken[g.Name] = fmt.Sprintf("deployment group %s", g.Name)
+	}
+	// the new groups of each group that is split
+	splitNames := map[GroupName][]GroupName{}
+	groups := []DeploymentGroup{}
+	for _, g := range bp.DeploymentGroups {
+		rest, split := []Module{}, []Module{}
+		for _, m := range g.Modules {
+			if m.TerraformBackend == nil {
+				rest = append(rest, m)
+			} else {
+				split = append(split, m)
+			}
+		}
+		if len(split) == 0 {
+			groups = append(groups, g)
+			continue
+		}
+		if len(rest) == 0 {
+			g.TerraformBackend = *split[0].TerraformBackend
+			split[0].TerraformBackend = nil
+			rest, split = split[:1], split[1:]
+		}
+
+		before, after := []DeploymentGroup{}, []DeploymentGroup{}
+		for _, m := range split {
+			if m.Kind == PackerKind {
+				return fmt.Errorf("module %s: Packer modules cannot set terraform_backend", m.ID)
+			}
+			if m.Kind == HelmKind {
+				return fmt.Errorf("module %s: Helm modules cannot set terraform_backend", m.ID)
+			}
+			if g.IsBootstrap() {
+				return fmt.Errorf("module %s sets terraform_backend, but group %s is a bootstrap group: "+
+					"set terraform_backend on the group instead", m.ID, g.Name)
+			}
+			if !g.Hooks.empty() {
+				return fmt.Errorf("module %s sets terraform_backend, but group %s has hooks: "+
+					"move it to a deployment group of its own", m.ID, g.Name)
+			}
+			uses, used := false, false
+			for _, o := range rest {
+				uses = uses || usesModule(m, o.ID)
+				used = used || usesModule(o, m.ID)
+			}
+			if uses && used {
+				return fmt.Errorf("module %s sets terraform_backend, but uses and is used by modules of group %s: "+
+					"move it to a deployment group of its own", m.ID, g.Name)
+			}
+			name := GroupName(fmt.Sprintf("%s-%s", g.Name, m.ID))
+			if other, ok := taken[name]; ok {
+				return fmt.Errorf("module %s of group %s sets terraform_backend and would be moved to a new group %s, "+
+					"but that name is taken by %s: rename the module or the group", m.ID, g.Name, name, other)
+			}
+			taken[name] = fmt.Sprintf("the group of module %s of group %s", m.ID, g.Name)
+			sg := DeploymentGroup{
+				Name:             name,
+				Kind:             m.Kind,
+				TerraformBackend: *m.TerraformBackend,
+				Retry:            g.Retry.Clone(),
+				ProjectID:        g.ProjectID,
+				DependsOn:        slices.Clone(g.DependsOn),
+
+				ImpersonateServiceAccount: g.ImpersonateServiceAccount,
+			}
+			splitNames[g.Name] = append(splitNames[g.Name], name)
+			m.TerraformBackend = nil
+			sg.Modules = []Module{m}
+			if uses {
+				after = append(after, sg)
+			} else {
+				before = append(before, sg)
+			}
+		}
+		g.Modules = rest
+		groups = append(groups, before...)
+		groups = append(groups, g)
+		groups = append(groups, after...)
+	}
+	for i, g := range groups {
+		for _, d := range g.DependsOn {
+			groups[i].DependsOn = append(groups[i].DependsOn, splitNames[d]...)
+		}
+	}
+	bp.DeploymentGroups = groups
+	return nil
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestBackendBuckets(t *testing.T) {
+	gcs := func(bucket string) TerraformBackend {
+		return TerraformBackend{Type: "gcs", Configuration: NewDict(map[string]cty.Value{
+			"bucket": cty.StringVal(bucket),
+		})}
+	}
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("test-project"),
+			"labels": cty.ObjectVal(map[string]cty.Value{
+				"ghpc_deployment": cty.StringVal("golden"),
+			}),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Kind: TerraformKind, TerraformBackend: gcs("state")},
+			{Name: "image", Kind: PackerKind},
+			{Name: "secondary", Kind: TerraformKind, TerraformBackend: gcs("state")},
+			{Name: "local", Kind: TerraformKind},
+		},
+	}
+
+	got, err := bp.BackendBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BackendBucket{{
+		Name: "state", Project: "test-project", Location: "US",
+		Labels: map[string]string{"ghpc_deployment": "golden"},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// buckets are located in the region of the deployment
+	bp.Vars.Set("region", cty.StringVal("us-central1"))
+	if got, err = bp.BackendBuckets(); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Location != "us-central1" {
+		t.Errorf("got location %q, want us-central1", got[0].Location)
+	}
+
+	bp.Vars = NewDict(map[string]cty.Value{})
+	if _, err := bp.BackendBuckets(); err == nil {
+		t.Error("expected an error without project_id")
+	}
+
+	bp.DeploymentGroups = []DeploymentGroup{{Name: "primary", Kind: TerraformKind, TerraformBackend: gcs("")}}
+	if _, err := bp.BackendBuckets(); err == nil {
+		t.Error("expected an error for a backend without bucket")
+	}
+}
+
+func TestSplitBackendModules(t *testing.T) {
+	secret := &TerraformBackend{Type: "gcs", Configuration: NewDict(map[string]cty.Value{
+		"bucket": cty.StringVal("secret-state"),
+	})}
+	ref := func(id ModuleID) Dict {
+		return NewDict(map[string]cty.Value{"x": ModuleRef(id, "x").AsExpression().AsValue()})
+	}
+	groupNames := func(bp Blueprint) []GroupName {
+		names := []GroupName{}
+		for _, g := range bp.DeploymentGroups {
+			names = append(names, g.Name)
+		}
+		return names
+	}
+
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{
+		Name: "primary",
+		Kind: TerraformKind,
+		Modules: []Module{
+			{ID: "network", Kind: TerraformKind},
+			{ID: "keys", Kind: TerraformKind, TerraformBackend: secret},
+			{ID: "vm", Kind: TerraformKind, Settings: ref("keys")},
+			{ID: "db", Kind: TerraformKind, Use: []ModuleUse{{Module: "network"}}, TerraformBackend: secret},
+		},
+	}}}
+	if err := bp.splitBackendModules(); err != nil {
+		t.Fatal(err)
+	}
+	want := []GroupName{"primary-keys", "primary", "primary-db"}
+	if diff := cmp.Diff(want, groupNames(bp)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	g := bp.DeploymentGroups[0]
+	if len(g.Modules) != 1 || g.Modules[0].ID != "keys" || g.Modules[0].TerraformBackend != nil {
+		t.Errorf("got unexpected modules %v", g.Modules)
+	}
+	if g.TerraformBackend.Type != "gcs" || g.TerraformBackend.Configuration.Get("bucket") != cty.StringVal("secret-state") {
+		t.Errorf("got backend %v, want the backend of the module", g.TerraformBackend)
+	}
+	if len(bp.DeploymentGroups[1].Modules) != 2 {
+		t.Errorf("got modules %v, want network and vm", bp.DeploymentGroups[1].Modules)
+	}
+
+	// splitting is idempotent
+	if err := bp.splitBackendModules(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, groupNames(bp)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// the new groups keep the project, service account and dependencies of
+	// the group, and the groups depending on it depend on them
+	bp = Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "network", Kind: TerraformKind, Modules: []Module{{ID: "vpc", Kind: TerraformKind}}},
+		{
+			Name:                      "primary",
+			Kind:                      TerraformKind,
+			ProjectID:                 "service-project",
+			ImpersonateServiceAccount: "deployer@host.iam.gserviceaccount.com",
+			DependsOn:                 []GroupName{"network"},
+			Modules: []Module{
+				{ID: "vm", Kind: TerraformKind},
+				{ID: "keys", Kind: TerraformKind, TerraformBackend: secret},
+			},
+		},
+		{Name: "monitoring", Kind: TerraformKind, DependsOn: []GroupName{"primary"},
+			Modules: []Module{{ID: "dashboard", Kind: TerraformKind}}},
+	}}
+	if err := bp.splitBackendModules(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]GroupName{"network", "primary-keys", "primary", "monitoring"}, groupNames(bp)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	sg := bp.DeploymentGroups[1]
+	if sg.ProjectID != "service-project" {
+		t.Errorf("got project %q, want service-project", sg.ProjectID)
+	}
+	if sg.ImpersonateServiceAccount != "deployer@host.iam.gserviceaccount.com" {
+		t.Errorf("got service account %q, want the service account of the group", sg.ImpersonateServiceAccount)
+	}
+	if diff := cmp.Diff([]GroupName{"network"}, sg.DependsOn); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]GroupName{"primary", "primary-keys"}, bp.DeploymentGroups[3].DependsOn); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// a group of a single module uses the backend of the module
+	bp = Blueprint{DeploymentGroups: []DeploymentGroup{{
+		Name: "primary", Kind: TerraformKind, Modules: []Module{{ID: "keys", Kind: TerraformKind, TerraformBackend: secret}},
+	}}}
+	if err := bp.splitBackendModules(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]GroupName{"primary"}, groupNames(bp)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if bp.DeploymentGroups[0].TerraformBackend.Type != "gcs" {
+		t.Errorf("got backend %v, want the backend of the module", bp.DeploymentGroups[0].TerraformBackend)
+	}
+
+	// a module cannot be split from modules it uses and is used by
+	bp = Blueprint{DeploymentGroups: []DeploymentGroup{{
+		Name: "primary",
+		Kind: TerraformKind,
+		Modules: []Module{
+			{ID: "network", Kind: TerraformKind, Settings: ref("keys")},
+			{ID: "keys", Kind: TerraformKind, Settings: ref("network"), TerraformBackend: secret},
+		},
+	}}}
+	if err := bp.splitBackendModules(); err == nil {
+		t.Error("expected an error for a module that uses and is used by the modules of its group")
+	}
+
+	// a module cannot be split from a bootstrap group, which creates the
+	// project it would be deployed to
+	bp = Blueprint{DeploymentGroups: []DeploymentGroup{{
+		Name:             "bootstrap",
+		Kind:             TerraformKind,
+		BootstrapProject: &Dict{},
+		Modules: []Module{
+			{ID: "project", Kind: TerraformKind},
+			{ID: "keys", Kind: TerraformKind, TerraformBackend: secret},
+		},
+	}}}
+	err := bp.splitBackendModules()
+	if err == nil || !strings.Contains(err.Error(), "group bootstrap is a bootstrap group") {
+		t.Errorf("got error %v, want an error for a bootstrap group", err)
+	}
+
+	// nor from a group with hooks, which would run for either group only
+	bp = Blueprint{DeploymentGroups: []DeploymentGroup{{
+		Name:  "primary",
+		Kind:  TerraformKind,
+		Hooks: GroupHooks{PostDeploy: []string{"./notify.sh"}},
+		Modules: []Module{
+			{ID: "network", Kind: TerraformKind},
+			{ID: "keys", Kind: TerraformKind, TerraformBackend: secret},
+		},
+	}}}
+	err = bp.splitBackendModules()
+	if err == nil || !strings.Contains(err.Error(), "group primary has hooks") {
+		t.Errorf("got error %v, want an error for a group with hooks", err)
+	}
+
+	// the name of a new group cannot be taken by an existing group
+	bp = Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "primary", Kind: TerraformKind, Modules: []Module{
+			{ID: "network", Kind: TerraformKind},
+			{ID: "keys", Kind: TerraformKind, TerraformBackend: secret},
+		}},
+		{Name: "primary-keys", Kind: TerraformKind, Modules: []Module{{ID: "vm", Kind: TerraformKind}}},
+	}}
+	err = bp.splitBackendModules()
+	wantErr := "module keys of group primary sets terraform_backend and would be moved to a new group primary-keys, " +
+		"but that name is taken by deployment group primary-keys: rename the module or the group"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("got error %v, want %q", err, wantErr)
+	}
+
+	// nor by another new group
+	bp = Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "a", Kind: TerraformKind, Modules: []Module{
+			{ID: "network", Kind: TerraformKind},
+			{ID: "b-c", Kind: TerraformKind, TerraformBackend: secret},
+		}},
+		{Name: "a-b", Kind: TerraformKind, Modules: []Module{
+			{ID: "vm", Kind: TerraformKind},
+			{ID: "c", Kind: TerraformKind, TerraformBackend: secret},
+		}},
+	}}
+	err = bp.splitBackendModules()
+	wantErr = "module c of group a-b sets terraform_backend and would be moved to a new group a-b-c, " +
+		"but that name is taken by the group of module b-c of group a: rename the module or the group"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("got error %v, want %q", err, wantErr)
+	}
+}
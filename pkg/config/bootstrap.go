@@ -0,0 +1,164 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	// BootstrapModuleID is the ID of the module injected into a bootstrap
+	// group to create the deployment project
+	BootstrapModuleID ModuleID = "bootstrap_project"
+	bootstrapSource   string   = "community/modules/project/new-project"
+)
+
+// IsBootstrap returns true if the group creates the deployment project
+func (g DeploymentGroup) IsBootstrap() bool {
+	return g.BootstrapProject != nil
+}
+
+// bootstrapGroup returns the index of the bootstrap group, -1 if there is none
+func (bp Blueprint) bootstrapGroup() int {
+	for i, g := range bp.DeploymentGroups {
+		if g.IsBootstrap() {
+			return i
+		}
+	}
+	return -1
+}
+
+// addBootstrapModules injects the project module into bootstrap groups. The
+// settings of the bootstrap_project block are passed to the module as is.
+// Groups that already contain the module (e.g. an expanded blueprint) are
+// left unchanged.
+func (bp *Blueprint) addBootstrapModules() {
+	for i := range bp.DeploymentGroups {
+		grp := &bp.DeploymentGroups[i]
+		if !grp.IsBootstrap() {
+			continue
+		}
+		found := false
+		for _, m := range grp.Modules {
+			found = found || m.ID == BootstrapModuleID
+		}
+		if found {
+			continue
+		}
+		mod := Module{
+			ID:     BootstrapModuleID,
+			Source: bootstrapSource,
+			Kind:   TerraformKind,
+		}
+		for k, v := range grp.BootstrapProject.Items() {
+			mod.Settings.Set(k, v)
+		}
+		grp.Modules = append([]Module{mod}, grp.Modules...)
+	}
+}
+
+// checkBootstrapGroups ensures that there is at most one bootstrap group, that
+// it is the first group and that it creates the project named by
+// vars.project_id
+func checkBootstrapGroups(bp Blueprint) error {
+	count := 0
+	for i, g := range bp.DeploymentGroups {
+		if !g.IsBootstrap() {
+			continue
+		}
+		count++
+		if count > 1 {
+			return fmt.Errorf("%s: %s", errorMessages["multipleBootstrap"], g.Name)
+		}
+		if i != 0 {
+			return fmt.Errorf("%s: %s", errorMessages["bootstrapNotFirst"], g.Name)
+		}
+		if g.Kind == PackerKind || g.Kind == HelmKind {
+			return fmt.Errorf("bootstrap group %s cannot be \"kind: %s\"", g.Name, g.Kind)
+		}
+		if !bp.Vars.Has("project_id") {
+			return fmt.Errorf("bootstrap group %s requires deployment variable project_id", g.Name)
+		}
+	}
+	return nil
+}
+
+// setBootstrapApis enables on the new project the union of the APIs required
+// by all other modules of the blueprint, unless activate_apis is set explicitly
+func (bp *Blueprint) setBootstrapApis() error {
+	bi := bp.bootstrapGroup()
+	if bi < 0 {
+		return nil
+	}
+	mod, err := bp.Module(BootstrapModuleID)
+	if err != nil || mod.Settings.Has("activate_apis") {
+		return err
+	}
+
+	apis := map[string]bool{}
+	for _, g := range bp.DeploymentGroups[bi+1:] {
+		for _, m := range g.Modules {
+			for _, a := range m.RequiredApis["$(vars.project_id)"] {
+				apis[a] = true
+			}
+		}
+	}
+	keys := maps.Keys(apis)
+	sort.Strings(keys)
+	vals := []cty.Value{}
+	for _, a := range keys {
+		vals = append(vals, cty.StringVal(a))
+	}
+	mod.Settings.Set("activate_apis", cty.TupleVal(vals))
+	return nil
+}
+
+// wireBootstrapProject replaces references to vars.project_id in the settings
+// of modules outside of the bootstrap group with the project_id output of the
+// project module, so that those groups are deployed into the created project
+func (bp *Blueprint) wireBootstrapProject() error {
+	bi := bp.bootstrapGroup()
+	if bi < 0 {
+		return nil
+	}
+	varRef := GlobalRef("project_id").AsExpression().Tokenize().Bytes()
+	modRef := ModuleRef(BootstrapModuleID, "project_id").AsExpression().AsValue()
+	for ig := range bp.DeploymentGroups {
+		if ig == bi {
+			continue
+		}
+		for im := range bp.DeploymentGroups[ig].Modules {
+			mod := &bp.DeploymentGroups[ig].Modules[im]
+			for k, v := range mod.Settings.Items() {
+				nv, err := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+					if e, is := IsExpressionValue(v); is && bytes.Equal(e.Tokenize().Bytes(), varRef) {
+						return modRef, nil
+					}
+					return v, nil
+				})
+				if err != nil {
+					return err
+				}
+				mod.Settings.Set(k, nv)
+			}
+		}
+	}
+	return nil
+}
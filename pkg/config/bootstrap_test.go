@@ -0,0 +1,134 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func bootstrapBlueprintForTest() Blueprint {
+	settings := NewDict(map[string]cty.Value{
+		"billing_account": cty.StringVal("000000-000000-000000"),
+		"org_id":          cty.StringVal("123"),
+	})
+	bootstrap := Module{ID: BootstrapModuleID, Source: bootstrapSource, Kind: TerraformKind}
+	setTestModuleInfo(bootstrap, modulereader.ModuleInfo{
+		Inputs: []modulereader.VarInfo{
+			{Name: "project_id", Type: "string"},
+			{Name: "billing_account", Type: "string", Required: true},
+			{Name: "org_id", Type: "string", Required: true},
+			{Name: "activate_apis", Type: "list(string)"},
+		},
+		Outputs: []modulereader.OutputInfo{{Name: "project_id"}},
+	})
+	vm := Module{ID: "vm", Source: "./modules/vm", Kind: TerraformKind}
+	setTestModuleInfo(vm, modulereader.ModuleInfo{
+		Inputs:       []modulereader.VarInfo{{Name: "project_id", Type: "string", Required: true}},
+		RequiredApis: []string{"compute.googleapis.com", "storage.googleapis.com"},
+	})
+
+	return Blueprint{
+		BlueprintName: "greenfield",
+		Vars: NewDict(map[string]cty.Value{
+			"deployment_name": cty.StringVal("greenfield"),
+			"project_id":      cty.StringVal("new-project"),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "bootstrap", BootstrapProject: &settings},
+			{Name: "primary", Modules: []Module{vm}},
+		},
+	}
+}
+
+func TestExpandBootstrapProject(t *testing.T) {
+	dc := DeploymentConfig{Config: bootstrapBlueprintForTest()}
+	if err := dc.ExpandConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	bootstrap := dc.Config.DeploymentGroups[0]
+	if len(bootstrap.Modules) != 1 || bootstrap.Modules[0].ID != BootstrapModuleID {
+		t.Fatalf("expected project module in bootstrap group, got %#v", bootstrap.Modules)
+	}
+	proj := bootstrap.Modules[0]
+	if got := proj.Settings.Get("billing_account"); !got.RawEquals(cty.StringVal("000000-000000-000000")) {
+		t.Errorf("expected billing_account to be passed to project module, got %#v", got)
+	}
+	wantApis := cty.TupleVal([]cty.Value{
+		cty.StringVal("compute.googleapis.com"),
+		cty.StringVal("storage.googleapis.com"),
+	})
+	if got := proj.Settings.Get("activate_apis"); !got.RawEquals(wantApis) {
+		t.Errorf("got activate_apis %#v, want %#v", got, wantApis)
+	}
+	wantVar := GlobalRef("project_id").AsExpression().AsValue()
+	if got := proj.Settings.Get("project_id"); !got.RawEquals(wantVar) {
+		t.Errorf("got project module project_id %#v, want %#v", got, wantVar)
+	}
+
+	vm := dc.Config.DeploymentGroups[1].Modules[0]
+	wantRef := ModuleRef(BootstrapModuleID, "project_id").AsExpression().AsValue()
+	if got := vm.Settings.Get("project_id"); !got.RawEquals(wantRef) {
+		t.Errorf("got vm project_id %#v, want %#v", got, wantRef)
+	}
+
+	for _, v := range dc.Config.Validators {
+		if v.Validator == testProjectExistsName.String() {
+			t.Errorf("expected %s validator to be skipped for bootstrap project", v.Validator)
+		}
+	}
+
+	// expanding an expanded blueprint does not inject the module again
+	dc.Config.addBootstrapModules()
+	if n := len(dc.Config.DeploymentGroups[0].Modules); n != 1 {
+		t.Errorf("expected 1 module in bootstrap group, got %d", n)
+	}
+}
+
+func TestCheckBootstrapGroups(t *testing.T) {
+	settings := NewDict(map[string]cty.Value{})
+	type test struct {
+		name   string
+		modify func(bp *Blueprint)
+		err    bool
+	}
+	tests := []test{
+		{"valid", func(bp *Blueprint) {}, false},
+		{"not first", func(bp *Blueprint) {
+			g := bp.DeploymentGroups
+			bp.DeploymentGroups = []DeploymentGroup{g[1], g[0]}
+		}, true},
+		{"multiple", func(bp *Blueprint) {
+			bp.DeploymentGroups[1].BootstrapProject = &settings
+		}, true},
+		{"no project_id", func(bp *Blueprint) {
+			bp.Vars = NewDict(map[string]cty.Value{})
+		}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := bootstrapBlueprintForTest()
+			tc.modify(&bp)
+			err := checkBootstrapGroups(bp)
+			if tc.err != (err != nil) {
+				t.Errorf("got unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Clone returns a deep copy of the Dict. Values are immutable and are shared.
+func (d Dict) Clone() Dict {
+	return NewDict(d.Items())
+}
+
+func cloneStringSliceMap(m map[string][]string) map[string][]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string][]string, len(m))
+	for k, v := range m {
+		c[k] = slices.Clone(v)
+	}
+	return c
+}
+
+// Clone returns a deep copy of the module
+func (m Module) Clone() Module {
+	c := m
+	c.Use = slices.Clone(m.Use)
+	for i, u := range c.Use {
+		c.Use[i].Map = maps.Clone(u.Map)
+	}
+	c.WrapSettings = maps.Clone(m.WrapSettings)
+	c.Outputs = slices.Clone(m.Outputs)
+	c.Settings = m.Settings.Clone()
+	c.RequiredApis = cloneStringSliceMap(m.RequiredApis)
+	c.HealthProbes = slices.Clone(m.HealthProbes)
+	c.Providers = maps.Clone(m.Providers)
+	c.SensitiveSettings = slices.Clone(m.SensitiveSettings)
+	c.MovedFrom = slices.Clone(m.MovedFrom)
+	if m.Integrity != nil {
+		i := *m.Integrity
+		c.Integrity = &i
+	}
+	if m.TerraformBackend != nil {
+		be := TerraformBackend{Type: m.TerraformBackend.Type, Configuration: m.TerraformBackend.Configuration.Clone()}
+		c.TerraformBackend = &be
+	}
+	return c
+}
+
+// Clone returns a deep copy of the deployment group
+func (g DeploymentGroup) Clone() DeploymentGroup {
+	c := g
+	c.TerraformBackend.Configuration = g.TerraformBackend.Configuration.Clone()
+	if g.Modules != nil {
+		c.Modules = make([]Module, len(g.Modules))
+		for i, m := range g.Modules {
+			c.Modules[i] = m.Clone()
+		}
+	}
+	if g.BootstrapProject != nil {
+		bp := g.BootstrapProject.Clone()
+		c.BootstrapProject = &bp
+	}
+	c.Hooks = g.Hooks.Clone()
+	c.Retry = g.Retry.Clone()
+	c.PackerSettings = g.PackerSettings.Clone()
+	c.DependsOn = slices.Clone(g.DependsOn)
+	return c
+}
+
+// Clone returns a deep copy of the blueprint
+func (bp Blueprint) Clone() Blueprint {
+	c := bp
+	if bp.Validators != nil {
+		c.Validators = make([]validatorConfig, len(bp.Validators))
+		for i, v := range bp.Validators {
+			c.Validators[i] = v
+			c.Validators[i].Inputs = v.Inputs.Clone()
+		}
+	}
+	c.Vars = bp.Vars.Clone()
+	if bp.DeploymentGroups != nil {
+		c.DeploymentGroups = make([]DeploymentGroup, len(bp.DeploymentGroups))
+		for i, g := range bp.DeploymentGroups {
+			c.DeploymentGroups[i] = g.Clone()
+		}
+	}
+	c.TerraformBackendDefaults.Configuration = bp.TerraformBackendDefaults.Configuration.Clone()
+	if bp.MaintenanceSchedules != nil {
+		c.MaintenanceSchedules = make([]Dict, len(bp.MaintenanceSchedules))
+		for i, s := range bp.MaintenanceSchedules {
+			c.MaintenanceSchedules[i] = s.Clone()
+		}
+	}
+	c.Artifacts = slices.Clone(bp.Artifacts)
+	c.Outputs = slices.Clone(bp.Outputs)
+	if bp.RemoteOutputs != nil {
+		c.RemoteOutputs = make([]RemoteOutputs, len(bp.RemoteOutputs))
+		for i, ro := range bp.RemoteOutputs {
+			ro.Outputs = slices.Clone(ro.Outputs)
+			c.RemoteOutputs[i] = ro
+		}
+	}
+	if bp.Profiles != nil {
+		c.Profiles = make(map[string]Profile, len(bp.Profiles))
+		for n, p := range bp.Profiles {
+			settings := make(map[ModuleID]Dict, len(p.Settings))
+			for id, d := range p.Settings {
+				settings[id] = d.Clone()
+			}
+			c.Profiles[n] = Profile{Vars: p.Vars.Clone(), Settings: settings}
+		}
+	}
+	if bp.DeploymentVariables != nil {
+		c.DeploymentVariables = make(map[string]VariableDeclaration, len(bp.DeploymentVariables))
+		for n, d := range bp.DeploymentVariables {
+			if d.Default != nil {
+				v := *d.Default
+				d.Default = &v
+			}
+			d.Validation = slices.Clone(d.Validation)
+			c.DeploymentVariables[n] = d
+		}
+	}
+	c.LabelPolicy = bp.LabelPolicy.Clone()
+	if bp.Provenance != nil {
+		p := *bp.Provenance
+		c.Provenance = &p
+	}
+	if bp.TerraformProviders != nil {
+		c.TerraformProviders = make(map[string]TerraformProvider, len(bp.TerraformProviders))
+		for k, p := range bp.TerraformProviders {
+			p.Configuration = p.Configuration.Clone()
+			c.TerraformProviders[k] = p
+		}
+	}
+	return c
+}
+
+// CloneDeployment returns a copy of the blueprint of a deployment for a new
+// deployment named name. The deployment name, the ghpc_deployment labels and
+// the prefixes of GCS backends derived from the deployment name are rewritten;
+// all other settings are preserved. It is an error if a GCS backend prefix
+// does not contain the deployment name, as the new deployment would otherwise
+// share the Terraform state of the existing one.
+func (bp Blueprint) CloneDeployment(name string) (Blueprint, error) {
+	old, err := bp.DeploymentName()
+	if err != nil {
+		return Blueprint{}, err
+	}
+	c := bp.Clone()
+	c.Vars.Set("deployment_name", cty.StringVal(name))
+	if _, err := c.DeploymentName(); err != nil {
+		return Blueprint{}, err
+	}
+
+	rename := func(v cty.Value) cty.Value {
+		if v.IsNull() || !v.IsKnown() || !(v.Type().IsObjectType() || v.Type().IsMapType()) {
+			return v
+		}
+		labels, key := v.AsValueMap(), bp.ToolkitLabels.Key(deploymentLabel)
+		if l, ok := labels[key]; ok && l.Type() == cty.String && l.AsString() == old {
+			labels[key] = cty.StringVal(name)
+			return cty.ObjectVal(labels)
+		}
+		return v
+	}
+	if c.Vars.Has("labels") {
+		c.Vars.Set("labels", rename(c.Vars.Get("labels")))
+	}
+	c.WalkModules(func(m *Module) error {
+		if m.Settings.Has("labels") {
+			m.Settings.Set("labels", rename(m.Settings.Get("labels")))
+		}
+		return nil
+	})
+
+	if err := renameStatePrefix(&c.TerraformBackendDefaults, old, name); err != nil {
+		return Blueprint{}, fmt.Errorf("terraform_backend_defaults: %w", err)
+	}
+	for i := range c.DeploymentGroups {
+		g := &c.DeploymentGroups[i]
+		if err := renameStatePrefix(&g.TerraformBackend, old, name); err != nil {
+			return Blueprint{}, fmt.Errorf("deployment group %s: %w", g.Name, err)
+		}
+	}
+	return c, nil
+}
+
+// renameStatePrefix replaces the path segments of the prefix of a GCS backend
+// that equal the old deployment name with the new one
+func renameStatePrefix(be *TerraformBackend, old string, name string) error {
+	if be.Type != "gcs" || !be.Configuration.Has("prefix") {
+		return nil
+	}
+	v := be.Configuration.Get("prefix")
+	if v.Type() != cty.String || !v.IsKnown() || v.IsNull() {
+		return fmt.Errorf("the prefix of the gcs backend must be a string to clone the deployment")
+	}
+	segments := strings.Split(v.AsString(), "/")
+	renamed := false
+	for i, s := range segments {
+		if s == old {
+			segments[i], renamed = name, true
+		}
+	}
+	if !renamed {
+		return fmt.Errorf("the prefix %q of the gcs backend does not contain the deployment name %q, "+
+			"the cloned deployment would share its Terraform state", v.AsString(), old)
+	}
+	be.Configuration.Set("prefix", cty.StringVal(strings.Join(segments, "/")))
+	return nil
+}
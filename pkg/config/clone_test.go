@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func cloneTestBlueprint() Blueprint {
+	gcs := func(prefix string) TerraformBackend {
+		return TerraformBackend{Type: "gcs", Configuration: NewDict(map[string]cty.Value{
+			"bucket": cty.StringVal("state"),
+			"prefix": cty.StringVal(prefix),
+		})}
+	}
+	return Blueprint{
+		BlueprintName: "bp",
+		Vars: NewDict(map[string]cty.Value{
+			"project_id":      cty.StringVal("p1"),
+			"deployment_name": cty.StringVal("team-a"),
+			"labels": cty.ObjectVal(map[string]cty.Value{
+				"ghpc_blueprint":  cty.StringVal("bp"),
+				"ghpc_deployment": cty.StringVal("team-a"),
+				"owner":           cty.StringVal("team-a"),
+			}),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", TerraformBackend: gcs("bp/team-a/primary"), Modules: []Module{
+				{ID: "image", Kind: PackerKind, Settings: NewDict(map[string]cty.Value{
+					"labels": cty.ObjectVal(map[string]cty.Value{
+						"ghpc_deployment": cty.StringVal("team-a"),
+						"ghpc_role":       cty.StringVal("packer"),
+					}),
+				})},
+			}},
+			{Name: "custom", TerraformBackend: gcs("clusters/team-a-state/team-a")},
+			{Name: "local"},
+		},
+	}
+}
+
+func TestClone(t *testing.T) {
+	bp := cloneTestBlueprint()
+	bp.Outputs = []DeploymentOutput{{Name: "ip", Value: "$(login.external_ip)"}}
+	bp.RemoteOutputs = []RemoteOutputs{{Source: "gs://bucket/net", Outputs: []string{"network"}}}
+	bp.DeploymentVariables = map[string]VariableDeclaration{
+		"zone": {Validation: []VariableValidation{{Condition: "value != ''"}}},
+	}
+
+	c := bp.Clone()
+	c.Outputs[0].Sensitive = true
+	c.RemoteOutputs[0].Outputs[0] = "subnetwork"
+	c.DeploymentVariables["zone"].Validation[0].Condition = "true"
+
+	if bp.Outputs[0].Sensitive {
+		t.Error("changing the outputs of the clone changed the original")
+	}
+	if got := bp.RemoteOutputs[0].Outputs[0]; got != "network" {
+		t.Errorf("changing the remote outputs of the clone changed the original to %q", got)
+	}
+	if got := bp.DeploymentVariables["zone"].Validation[0].Condition; got != "value != ''" {
+		t.Errorf("changing the variable rules of the clone changed the original to %q", got)
+	}
+}
+
+func TestCloneDeployment(t *testing.T) {
+	bp := cloneTestBlueprint()
+	c, err := bp.CloneDeployment("team-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := c.DeploymentName(); got != "team-b" {
+		t.Errorf("got deployment name %q, want %q", got, "team-b")
+	}
+	labels := c.Vars.Get("labels").AsValueMap()
+	if got := labels["ghpc_deployment"].AsString(); got != "team-b" {
+		t.Errorf("got ghpc_deployment label %q, want %q", got, "team-b")
+	}
+	if got := labels["owner"].AsString(); got != "team-a" {
+		t.Errorf("got owner label %q, want it preserved", got)
+	}
+	modLabels := c.DeploymentGroups[0].Modules[0].Settings.Get("labels").AsValueMap()
+	if got := modLabels["ghpc_deployment"].AsString(); got != "team-b" {
+		t.Errorf("got module ghpc_deployment label %q, want %q", got, "team-b")
+	}
+
+	prefixes := map[GroupName]string{
+		"primary": "bp/team-b/primary",
+		"custom":  "clusters/team-a-state/team-b",
+	}
+	for _, g := range c.DeploymentGroups {
+		want, ok := prefixes[g.Name]
+		if !ok {
+			if g.TerraformBackend.Type != "" {
+				t.Errorf("group %s: got backend %q, want none", g.Name, g.TerraformBackend.Type)
+			}
+			continue
+		}
+		if got := g.TerraformBackend.Configuration.Get("prefix").AsString(); got != want {
+			t.Errorf("group %s: got prefix %q, want %q", g.Name, got, want)
+		}
+	}
+
+	// the original blueprint is unchanged
+	if got, _ := bp.DeploymentName(); got != "team-a" {
+		t.Errorf("original deployment name changed to %q", got)
+	}
+	if got := bp.DeploymentGroups[0].TerraformBackend.Configuration.Get("prefix").AsString(); got != "bp/team-a/primary" {
+		t.Errorf("original prefix changed to %q", got)
+	}
+}
+
+func TestCloneDeploymentErrors(t *testing.T) {
+	bp := cloneTestBlueprint()
+	if _, err := bp.CloneDeployment("Team_B"); err == nil {
+		t.Error("expected an error for an invalid deployment name")
+	}
+
+	bp.DeploymentGroups[1].TerraformBackend.Configuration.Set("prefix", cty.StringVal("shared/state"))
+	if _, err := bp.CloneDeployment("team-b"); err == nil {
+		t.Error("expected an error for a prefix that would share the Terraform state")
+	}
+}
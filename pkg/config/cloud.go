@@ -0,0 +1,311 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Clouds blueprints can target
+const (
+	GCP   = "gcp"
+	AWS   = "aws"
+	Azure = "azure"
+)
+
+// Provider is a Terraform provider configured in the root module of
+// Terraform groups
+type Provider struct {
+	Name    string
+	Source  string
+	Version string
+	// Settings are the arguments of the provider block, in order
+	Settings []ProviderSetting
+	// Blocks are empty nested blocks the provider requires, e.g. features of
+	// azurerm
+	Blocks []string
+}
+
+// ProviderSetting is an argument of a provider block, set to a deployment
+// variable if the blueprint defines it
+type ProviderSetting struct {
+	Name string
+	Var  string
+}
+
+// Cloud holds the conventions ghpc applies to deployments on a cloud
+type Cloud struct {
+	Name      string
+	Providers []Provider
+	// LabelsSetting is the setting of modules the labels deployment variable
+	// and the ghpc labels are merged into
+	LabelsSetting string
+	// ProjectVar is the deployment variable of the project resources are
+	// created in, if the cloud has projects
+	ProjectVar string
+	// Backend is the Terraform backend storing state in the cloud
+	Backend string
+	// validators returns the default validators of the cloud for the
+	// deployment variables; those of Google Cloud are added by
+	// addDefaultValidators
+	validators func(vars Dict) []validatorConfig
+}
+
+const googleProviderVersion = "~> 4.65.2"
+
+var googleProviderSettings = []ProviderSetting{
+	{Name: "project", Var: "project_id"},
+	{Name: "zone", Var: "zone"},
+	{Name: "region", Var: "region"},
+}
+
+var clouds = map[string]Cloud{
+	GCP: {
+		Name: GCP,
+		Providers: []Provider{
+			{Name: "google", Source: "hashicorp/google", Version: googleProviderVersion, Settings: googleProviderSettings},
+			{Name: "google-beta", Source: "hashicorp/google-beta", Version: googleProviderVersion, Settings: googleProviderSettings},
+		},
+		LabelsSetting: "labels",
+		ProjectVar:    "project_id",
+		Backend:       "gcs",
+	},
+	AWS: {
+		Name: AWS,
+		Providers: []Provider{
+			{Name: "aws", Source: "hashicorp/aws", Version: "~> 5.0",
+				Settings: []ProviderSetting{{Name: "region", Var: "region"}}},
+		},
+		// AWS resources are tagged rather than labeled
+		LabelsSetting: "tags",
+		Backend:       "s3",
+		validators:    awsValidators,
+	},
+	Azure: {
+		Name: Azure,
+		Providers: []Provider{
+			{Name: "azurerm", Source: "hashicorp/azurerm", Version: "~> 3.0",
+				Settings: []ProviderSetting{{Name: "subscription_id", Var: "subscription_id"}},
+				Blocks:   []string{"features"}},
+		},
+		LabelsSetting: "tags",
+		Backend:       "azurerm",
+		validators:    azureValidators,
+	},
+}
+
+// Clouds returns the names of the clouds blueprints can target
+func Clouds() []string {
+	names := maps.Keys(clouds)
+	slices.Sort(names)
+	return names
+}
+
+// TargetCloud returns the cloud the blueprint targets, Google Cloud if it
+// does not set cloud
+func (bp Blueprint) TargetCloud() Cloud {
+	if c, ok := clouds[bp.Cloud]; ok {
+		return c
+	}
+	return clouds[GCP]
+}
+
+// checkCloud ensures that the blueprint targets a known cloud and only uses
+// features of ghpc available on it
+func checkCloud(bp Blueprint) error {
+	if bp.Cloud == "" {
+		return nil
+	}
+	if _, ok := clouds[bp.Cloud]; !ok {
+		return fmt.Errorf("cloud must be one of %s, got %q", strings.Join(Clouds(), ", "), bp.Cloud)
+	}
+	if bp.Cloud == GCP {
+		return nil
+	}
+	if i := bp.bootstrapGroup(); i >= 0 {
+		return fmt.Errorf("group %s: bootstrap groups create Google Cloud projects and require cloud %q", bp.DeploymentGroups[i].Name, GCP)
+	}
+	if bp.ArtifactStore != "" {
+		return fmt.Errorf("artifact_store is a Cloud Storage URL and requires cloud %q", GCP)
+	}
+	backend := bp.TargetCloud().Backend
+	if bp.TerraformBackendDefaults.Type == "gcs" {
+		return fmt.Errorf("terraform_backend_defaults: the gcs backend requires cloud %q, use the %s backend", GCP, backend)
+	}
+	for _, g := range bp.DeploymentGroups {
+		if g.TerraformBackend.Type == "gcs" {
+			return fmt.Errorf("group %s: the gcs backend requires cloud %q, use the %s backend", g.Name, GCP, backend)
+		}
+	}
+	return nil
+}
+
+// awsValidators returns the default validators of AWS deployments, for the
+// region and availability_zone deployment variables that exist
+func awsValidators(vars Dict) []validatorConfig {
+	defaults := []validatorConfig{{Validator: testAwsCredentialsName.String()}}
+	regionRef := GlobalRef("region").AsExpression().AsValue()
+	if vars.Has("region") {
+		defaults = append(defaults, validatorConfig{
+			Validator: testAwsRegionExistsName.String(),
+			Inputs:    NewDict(map[string]cty.Value{"region": regionRef}),
+		})
+	}
+	if vars.Has("region") && vars.Has("availability_zone") {
+		defaults = append(defaults, validatorConfig{
+			Validator: testAwsZoneInRegionName.String(),
+			Inputs: NewDict(map[string]cty.Value{
+				"region":            regionRef,
+				"availability_zone": GlobalRef("availability_zone").AsExpression().AsValue(),
+			}),
+		})
+	}
+	return defaults
+}
+
+func (dc *DeploymentConfig) testAwsCredentials(c validatorConfig) error {
+	funcName := testAwsCredentialsName.String()
+	if err := c.check(testAwsCredentialsName, []string{}); err != nil {
+		return err
+	}
+	if err := cachedTest(funcName, validators.TestAwsCredentials); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, funcName)
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testAwsRegionExists(c validatorConfig) error {
+	funcName := testAwsRegionExistsName.String()
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
+
+	if err := c.check(testAwsRegionExistsName, []string{"region"}); err != nil {
+		return err
+	}
+	m, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+
+	err = cachedTest(funcName, func() error {
+		return validators.TestAwsRegionExists(m["region"])
+	}, m["region"])
+	if err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testAwsZoneInRegion(c validatorConfig) error {
+	funcName := testAwsZoneInRegionName.String()
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
+
+	if err := c.check(testAwsZoneInRegionName, []string{"availability_zone", "region"}); err != nil {
+		return err
+	}
+	m, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+
+	err = cachedTest(funcName, func() error {
+		return validators.TestAwsZoneInRegion(m["availability_zone"], m["region"])
+	}, m["availability_zone"], m["region"])
+	if err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+// azureValidators returns the default validators of Azure deployments, for
+// the subscription_id and location deployment variables that exist
+func azureValidators(vars Dict) []validatorConfig {
+	if !vars.Has("subscription_id") {
+		return []validatorConfig{}
+	}
+	subscriptionRef := GlobalRef("subscription_id").AsExpression().AsValue()
+	defaults := []validatorConfig{{
+		Validator: testAzureSubscriptionExistsName.String(),
+		Inputs:    NewDict(map[string]cty.Value{"subscription_id": subscriptionRef}),
+	}}
+	if vars.Has("location") {
+		defaults = append(defaults, validatorConfig{
+			Validator: testAzureLocationExistsName.String(),
+			Inputs: NewDict(map[string]cty.Value{
+				"subscription_id": subscriptionRef,
+				"location":        GlobalRef("location").AsExpression().AsValue(),
+			}),
+		})
+	}
+	return defaults
+}
+
+func (dc *DeploymentConfig) testAzureSubscriptionExists(c validatorConfig) error {
+	funcName := testAzureSubscriptionExistsName.String()
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
+
+	if err := c.check(testAzureSubscriptionExistsName, []string{"subscription_id"}); err != nil {
+		return err
+	}
+	m, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+
+	err = cachedTest(funcName, func() error {
+		return validators.TestAzureSubscriptionExists(m["subscription_id"])
+	}, m["subscription_id"])
+	if err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testAzureLocationExists(c validatorConfig) error {
+	funcName := testAzureLocationExistsName.String()
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
+
+	if err := c.check(testAzureLocationExistsName, []string{"location", "subscription_id"}); err != nil {
+		return err
+	}
+	m, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+
+	err = cachedTest(funcName, func() error {
+		return validators.TestAzureLocationExists(m["subscription_id"], m["location"])
+	}, m["subscription_id"], m["location"])
+	if err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestCheckCloud(t *testing.T) {
+	aws := func() Blueprint {
+		return Blueprint{Cloud: AWS, DeploymentGroups: []DeploymentGroup{
+			{Name: "network", Kind: TerraformKind, TerraformBackend: TerraformBackend{Type: "s3"}},
+		}}
+	}
+	if err := checkCloud(aws()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkCloud(Blueprint{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := checkCloud(Blueprint{Cloud: "oci"}); err == nil {
+		t.Error("expected an error for an unknown cloud")
+	}
+
+	bp := aws()
+	bp.DeploymentGroups[0].TerraformBackend.Type = "gcs"
+	if err := checkCloud(bp); err == nil {
+		t.Error("expected an error for a gcs backend on AWS")
+	}
+
+	bp = aws()
+	bp.ArtifactStore = "gs://bucket/prefix"
+	if err := checkCloud(bp); err == nil {
+		t.Error("expected an error for an artifact store on AWS")
+	}
+
+	bp = aws()
+	bp.DeploymentGroups[0].BootstrapProject = &Dict{}
+	if err := checkCloud(bp); err == nil {
+		t.Error("expected an error for a bootstrap group on AWS")
+	}
+}
+
+func TestAwsDefaults(t *testing.T) {
+	vpc := Module{ID: "vpc", Kind: TerraformKind, Source: "./aws/vpc"}
+	modulereader.SetModuleInfo(vpc.Source, vpc.Kind.String(), modulereader.ModuleInfo{
+		Inputs: []modulereader.VarInfo{{Name: "tags"}},
+	})
+	dc := DeploymentConfig{Config: Blueprint{
+		BlueprintName: "pcluster",
+		Cloud:         AWS,
+		Vars: NewDict(map[string]cty.Value{
+			"deployment_name":   cty.StringVal("hpc"),
+			"region":            cty.StringVal("us-east-1"),
+			"availability_zone": cty.StringVal("us-east-1a"),
+		}),
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{vpc}}},
+		TerraformBackendDefaults: TerraformBackend{
+			Type:          "s3",
+			Configuration: NewDict(map[string]cty.Value{"bucket": cty.StringVal("state")}),
+		},
+	}}
+
+	if err := dc.addMetadataToModules(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.expandBackends(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.addDefaultValidators(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.combineLabels(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, v := range dc.Config.Validators {
+		got = append(got, v.Validator)
+	}
+	want := []string{"test_module_not_used", "test_deployment_variable_not_used",
+		"test_aws_credentials", "test_aws_region_exists", "test_aws_zone_in_region"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff of validators (-want +got):\n%s", diff)
+	}
+
+	g := dc.Config.DeploymentGroups[0]
+	if key := g.TerraformBackend.Configuration.Get("key"); key != cty.StringVal("pcluster/hpc/primary/terraform.tfstate") {
+		t.Errorf("got key %#v", key)
+	}
+
+	m := g.Modules[0]
+	if m.RequiredApis != nil {
+		t.Errorf("got required APIs %v, want none", m.RequiredApis)
+	}
+	if diff := cmp.Diff("merge", m.WrapSettings["tags"]); diff != "" {
+		t.Errorf("diff of tags (-want +got):\n%s", diff)
+	}
+	if m.Settings.Has("labels") {
+		t.Error("AWS modules are tagged, not labeled")
+	}
+}
+
+func TestAzureDefaults(t *testing.T) {
+	dc := DeploymentConfig{Config: Blueprint{
+		BlueprintName: "cyclecloud",
+		Cloud:         Azure,
+		Vars: NewDict(map[string]cty.Value{
+			"deployment_name": cty.StringVal("hpc"),
+			"subscription_id": cty.StringVal("0000"),
+			"location":        cty.StringVal("eastus"),
+		}),
+		DeploymentGroups: []DeploymentGroup{{Name: "primary"}},
+		TerraformBackendDefaults: TerraformBackend{
+			Type:          "azurerm",
+			Configuration: NewDict(map[string]cty.Value{"container_name": cty.StringVal("tfstate")}),
+		},
+	}}
+	if err := checkCloud(dc.Config); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.expandBackends(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.addDefaultValidators(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, v := range dc.Config.Validators {
+		got = append(got, v.Validator)
+	}
+	want := []string{"test_module_not_used", "test_deployment_variable_not_used",
+		"test_azure_subscription_exists", "test_azure_location_exists"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff of validators (-want +got):\n%s", diff)
+	}
+
+	be := dc.Config.DeploymentGroups[0].TerraformBackend
+	if key := be.Configuration.Get("key"); key != cty.StringVal("cyclecloud/hpc/primary/terraform.tfstate") {
+		t.Errorf("got key %#v", key)
+	}
+}
@@ -20,16 +20,20 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"gopkg.in/yaml.v3"
 
+	"hpc-toolkit/pkg/diagnostics"
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/sourcereader"
 )
 
 const (
@@ -59,29 +63,33 @@ var errorMessages = map[string]string{
 	"noOutput":             "Output not found for a variable",
 	"groupNotFound":        "The group ID was not found",
 	"cannotUsePacker":      "Packer modules cannot be used by other modules",
+	"packerCycle":          "Packer modules of a group cannot use each other in a cycle",
+	"invalidExec":          "invalid exec expression",
+	"execDisabled":         "exec expressions are disabled, use --allow-exec to enable them",
+	"invalidSecret":        "invalid secret reference",
+	"secretNotVar":         "secret references can only be values of deployment variables, which modules use in their place",
+	"multipleBootstrap":    "only one deployment group can be a bootstrap group",
+	"bootstrapNotFirst":    "the bootstrap group must be the first deployment group",
+	"versionNotRegistry":   "a module version can only be set for Terraform Registry modules",
 	// validator
-	"emptyID":            "a module id cannot be empty",
-	"emptySource":        "a module source cannot be empty",
-	"wrongKind":          "a module kind is invalid",
-	"extraSetting":       "a setting was added that is not found in the module",
-	"settingWithPeriod":  "a setting name contains a period, which is not supported; variable subfields cannot be set independently in a blueprint.",
-	"settingInvalidChar": "a setting name must begin with a non-numeric character and all characters must be either letters, numbers, dashes ('-') or underscores ('_').",
-	"duplicateGroup":     "group names must be unique",
-	"duplicateID":        "module IDs must be unique",
-	"emptyGroupName":     "group name must be set for each deployment group",
-	"illegalChars":       "invalid character(s) found in group name",
-	"invalidOutput":      "requested output was not found in the module",
-	"varNotDefined":      "variable not defined",
-	"valueNotString":     "value was not of type string",
-	"valueEmptyString":   "value is an empty string",
-	"labelNameReqs":      "name must begin with a lowercase letter, can only contain lowercase letters, numeric characters, underscores and dashes, and must be between 1 and 63 characters long",
-	"labelValueReqs":     "value can only contain lowercase letters, numeric characters, underscores and dashes, and must be between 0 and 63 characters long",
-}
-
-// map[moved module path]replacing module path
-var movedModules = map[string]string{
-	"community/modules/scheduler/cloud-batch-job":        "modules/scheduler/batch-job-template",
-	"community/modules/scheduler/cloud-batch-login-node": "modules/scheduler/batch-login-node",
+	"emptyID":               "a module id cannot be empty",
+	"emptySource":           "a module source cannot be empty",
+	"wrongKind":             "a module kind is invalid",
+	"extraSetting":          "a setting was added that is not found in the module",
+	"extraSensitiveSetting": "a sensitive setting was marked that is not found in the module",
+	"extraWrappedSetting":   "a wrapped setting was set that is not found in the module",
+	"settingWithPeriod":     "a setting name contains a period, which is not supported; variable subfields cannot be set independently in a blueprint.",
+	"settingInvalidChar":    "a setting name must begin with a non-numeric character and all characters must be either letters, numbers, dashes ('-') or underscores ('_').",
+	"duplicateGroup":        "group names must be unique",
+	"duplicateID":           "module IDs must be unique",
+	"emptyGroupName":        "group name must be set for each deployment group",
+	"illegalChars":          "invalid character(s) found in group name",
+	"invalidOutput":         "requested output was not found in the module",
+	"varNotDefined":         "variable not defined",
+	"valueNotString":        "value was not of type string",
+	"valueEmptyString":      "value is an empty string",
+	"labelNameReqs":         "name must begin with a lowercase letter, can only contain lowercase letters, numeric characters, underscores and dashes, and must be between 1 and 63 characters long",
+	"labelValueReqs":        "value can only contain lowercase letters, numeric characters, underscores and dashes, and must be between 0 and 63 characters long",
 }
 
 // GroupName is the name of a deployment group
@@ -104,6 +112,30 @@ type DeploymentGroup struct {
 	TerraformBackend TerraformBackend `yaml:"terraform_backend"`
 	Modules          []Module         `yaml:"modules"`
 	Kind             ModuleKind
+	// BootstrapProject, if set, makes this a bootstrap group that creates the
+	// deployment project; its settings are passed to the project module
+	BootstrapProject *Dict `yaml:"bootstrap_project,omitempty"`
+	// Hooks are commands run before and after the group is deployed or
+	// destroyed
+	Hooks GroupHooks `yaml:"hooks,omitempty"`
+	// Retry retries deploying the group after transient errors
+	Retry GroupRetry `yaml:"retry,omitempty"`
+	// PackerSettings are the settings shared by the Packer modules of a
+	// "kind: packer" group
+	PackerSettings Dict `yaml:"packer_settings,omitempty"`
+	// Namespace is the Kubernetes namespace the charts of a "kind: helm"
+	// group are installed in, the namespace of the current kubectl context if
+	// unset
+	Namespace string `yaml:"namespace,omitempty"`
+	// ProjectID, if set, replaces the project of the deployment in the
+	// group, e.g. to deploy to the service project of a Shared VPC
+	ProjectID string `yaml:"project_id,omitempty"`
+	// ImpersonateServiceAccount is the service account the group is deployed
+	// as, by Terraform and its gcs backend
+	ImpersonateServiceAccount string `yaml:"impersonate_service_account,omitempty"`
+	// DependsOn lists earlier groups the group is deployed after, in addition
+	// to the groups whose outputs it uses
+	DependsOn []GroupName `yaml:"depends_on,omitempty"`
 }
 
 // Module return the module with the given ID
@@ -168,7 +200,7 @@ type TerraformBackend struct {
 	Configuration Dict
 }
 
-// ModuleKind abstracts Toolkit module kinds (presently: packer/terraform)
+// ModuleKind abstracts Toolkit module kinds (presently: packer/terraform/helm)
 type ModuleKind struct {
 	kind string
 }
@@ -182,6 +214,9 @@ var TerraformKind = ModuleKind{kind: "terraform"}
 // PackerKind is the kind for Packer modules (should be treated as const)
 var PackerKind = ModuleKind{kind: "packer"}
 
+// HelmKind is the kind for Helm charts (should be treated as const)
+var HelmKind = ModuleKind{kind: "helm"}
+
 // UnmarshalYAML implements a custom unmarshaler from YAML string to ModuleKind
 func (mk *ModuleKind) UnmarshalYAML(n *yaml.Node) error {
 	var kind string
@@ -192,7 +227,7 @@ func (mk *ModuleKind) UnmarshalYAML(n *yaml.Node) error {
 		mk.kind = kind
 		return nil
 	}
-	return fmt.Errorf(yamlErrorMsg, n.Line, "kind must be \"packer\" or \"terraform\" or removed from YAML")
+	return fmt.Errorf(yamlErrorMsg, n.Line, "kind must be \"packer\", \"terraform\" or \"helm\" or removed from YAML")
 }
 
 // MarshalYAML implements a custom marshaler from ModuleKind to YAML string
@@ -200,10 +235,67 @@ func (mk ModuleKind) MarshalYAML() (interface{}, error) {
 	return mk.String(), nil
 }
 
+// ModuleUse is a module used by another module. The outputs of the used
+// module are set as the inputs of the same name of the using module, or as
+// the inputs Map maps them to. If Merge is set, its outputs are also merged
+// into the list settings the blueprint sets.
+type ModuleUse struct {
+	Module ModuleID
+	Map    map[string]string
+	Merge  bool
+}
+
+// UnmarshalYAML implements a custom unmarshaler from the ID of the used
+// module, or a mapping of its ID, map and merge, to ModuleUse
+func (u *ModuleUse) UnmarshalYAML(n *yaml.Node) error {
+	const yamlErrorMsg string = "block beginning at line %d: %s"
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&u.Module)
+	}
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf(yamlErrorMsg, n.Line, "use must list module IDs or mappings of module, map and merge")
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if k := n.Content[i].Value; k != "module" && k != "map" && k != "merge" {
+			return fmt.Errorf(yamlErrorMsg, n.Line, fmt.Sprintf("field %s not found in use, expected module, map or merge", k))
+		}
+	}
+	var v moduleUseYAML
+	if err := n.Decode(&v); err != nil {
+		return err
+	}
+	if v.Module == "" {
+		return fmt.Errorf(yamlErrorMsg, n.Line, "use is missing the module")
+	}
+	u.Module, u.Map, u.Merge = v.Module, v.Map, v.Merge
+	return nil
+}
+
+// MarshalYAML implements a custom marshaler from ModuleUse to the ID of the
+// used module, if it has no map and does not merge
+func (u ModuleUse) MarshalYAML() (interface{}, error) {
+	if len(u.Map) == 0 && !u.Merge {
+		return string(u.Module), nil
+	}
+	return moduleUseYAML{Module: u.Module, Map: u.Map, Merge: u.Merge}, nil
+}
+
+// moduleUseYAML is the YAML mapping of a ModuleUse with a map or merge
+type moduleUseYAML struct {
+	Module ModuleID          `yaml:"module"`
+	Map    map[string]string `yaml:"map,omitempty"`
+	Merge  bool              `yaml:"merge,omitempty"`
+}
+
+// uses returns whether the module lists the module id in use
+func (m Module) uses(id ModuleID) bool {
+	return slices.ContainsFunc(m.Use, func(u ModuleUse) bool { return u.Module == id })
+}
+
 // IsValidModuleKind ensures that the user has specified a supported kind
 func IsValidModuleKind(kind string) bool {
 	return kind == TerraformKind.String() || kind == PackerKind.String() ||
-		kind == UnknownKind.String()
+		kind == HelmKind.String() || kind == UnknownKind.String()
 }
 
 func (mk ModuleKind) String() string {
@@ -222,6 +314,14 @@ const (
 	testZoneInRegionName
 	testApisEnabledName
 	testDeploymentVariableNotUsedName
+	testMachineTypesAvailableName
+	testIamPermissionsName
+	testBudgetName
+	testAwsCredentialsName
+	testAwsRegionExistsName
+	testAwsZoneInRegionName
+	testAzureSubscriptionExistsName
+	testAzureLocationExistsName
 )
 
 // this enum will be used to control how fatal validator failures will be
@@ -236,6 +336,21 @@ func isValidValidationLevel(level int) bool {
 	return !(level > ValidationIgnore || level < ValidationError)
 }
 
+// ParseValidationLevel parses a validation level: "ERROR", "WARNING" or
+// "IGNORE"
+func ParseValidationLevel(s string) (int, error) {
+	switch s {
+	case "ERROR":
+		return ValidationError, nil
+	case "WARNING":
+		return ValidationWarning, nil
+	case "IGNORE":
+		return ValidationIgnore, nil
+	default:
+		return 0, fmt.Errorf("invalid validation level (\"ERROR\", \"WARNING\", \"IGNORE\")")
+	}
+}
+
 func (v validatorName) String() string {
 	switch v {
 	case testProjectExistsName:
@@ -252,6 +367,22 @@ func (v validatorName) String() string {
 		return "test_module_not_used"
 	case testDeploymentVariableNotUsedName:
 		return "test_deployment_variable_not_used"
+	case testMachineTypesAvailableName:
+		return "test_machine_types_available"
+	case testIamPermissionsName:
+		return "test_iam_permissions"
+	case testBudgetName:
+		return "test_budget"
+	case testAwsCredentialsName:
+		return "test_aws_credentials"
+	case testAwsRegionExistsName:
+		return "test_aws_region_exists"
+	case testAwsZoneInRegionName:
+		return "test_aws_zone_in_region"
+	case testAzureSubscriptionExistsName:
+		return "test_azure_subscription_exists"
+	case testAzureLocationExistsName:
+		return "test_azure_location_exists"
 	default:
 		return "unknown_validator"
 	}
@@ -261,6 +392,28 @@ type validatorConfig struct {
 	Validator string
 	Inputs    Dict
 	Skip      bool
+	// Expression is a CEL expression over the deployment variables and the
+	// modules that must be true; it makes Validator the name of a validator
+	// declared in the blueprint instead of a built-in validator
+	Expression string `yaml:"expression,omitempty"`
+	// Message is printed when Expression is false
+	Message string `yaml:"message,omitempty"`
+	// Command is a validator plugin, run with the expanded blueprint on its
+	// standard input; it makes Validator the name of a validator declared in
+	// the blueprint instead of a built-in validator
+	Command []string `yaml:"command,omitempty"`
+	// Level overrides the validation level of the blueprint for this
+	// validator: "ERROR", "WARNING" or "IGNORE"
+	Level string `yaml:"level,omitempty"`
+}
+
+// level returns the validation level of the validator, global if the
+// validator does not set its own
+func (v validatorConfig) level(global int) int {
+	if l, err := ParseValidationLevel(v.Level); err == nil {
+		return l
+	}
+	return global
 }
 
 func (v *validatorConfig) check(name validatorName, requiredInputs []string) error {
@@ -295,28 +448,69 @@ type ModuleID string
 // Module stores YAML definition of an HPC cluster component defined in a blueprint
 type Module struct {
 	Source string
+	// Version - is the version constraint of a Terraform Registry module,
+	// pinned to an exact version when the blueprint is created
+	Version string `yaml:"version,omitempty"`
 	// DeploymentSource - is source to be used for this module in written deployment.
 	DeploymentSource string `yaml:"-"` // "-" prevents user from specifying it
 	Kind             ModuleKind
 	ID               ModuleID
-	Use              []ModuleID
-	WrapSettingsWith map[string][]string
-	Outputs          []modulereader.OutputInfo `yaml:"outputs,omitempty"`
-	Settings         Dict
-	RequiredApis     map[string][]string `yaml:"required_apis"`
+	Use              []ModuleUse
+	// WrapSettings - are the HCL functions settings are wrapped with when
+	// written, by the name of the setting, e.g. labels: merge
+	WrapSettings map[string]string         `yaml:"wrap_settings,omitempty"`
+	Outputs      []modulereader.OutputInfo `yaml:"outputs,omitempty"`
+	Settings     Dict
+	RequiredApis map[string][]string `yaml:"required_apis"`
+	// Integrity - is the expected checksum and/or signature of the module
+	Integrity *sourcereader.Integrity `yaml:"integrity,omitempty"`
+	// HealthProbes - are run after deployment to check the module is ready,
+	// defaults to the probes declared in the metadata of the module
+	HealthProbes []modulereader.HealthProbe `yaml:"health_probes,omitempty"`
+	// TerraformBackend - overrides the backend of the group for this module,
+	// which is then deployed as a group of its own
+	TerraformBackend *TerraformBackend `yaml:"terraform_backend,omitempty"`
+	// Providers - pass providers of the deployment to the module, by the
+	// name the module uses for them, e.g. google: google.europe
+	Providers map[string]string `yaml:"providers,omitempty"`
+	// SensitiveSettings - are the settings whose values are secrets, which
+	// are redacted from expanded blueprints and masked in logs
+	SensitiveSettings []string `yaml:"sensitive_settings,omitempty"`
+	// LabelMerge - is how the labels of the deployment are merged into those
+	// of the module: "module" (default), "deployment" or "none"
+	LabelMerge string `yaml:"label_merge,omitempty"`
+	// MovedFrom - are the previous IDs of a Terraform module, whose state is
+	// moved to the module by Terraform moved blocks
+	MovedFrom []ModuleID `yaml:"moved_from,omitempty"`
+}
+
+// writesExpressions returns whether the settings of the module are written as
+// HCL expressions, which can be wrapped with functions, rather than as values
+func (m Module) writesExpressions() bool {
+	return m.Kind != PackerKind && m.Kind != HelmKind
 }
 
-// createWrapSettingsWith ensures WrapSettingsWith field is not nil, if it is
-// a new map is created.
-func (m *Module) createWrapSettingsWith() {
-	if m.WrapSettingsWith == nil {
-		m.WrapSettingsWith = make(map[string][]string)
+// wrapSetting wraps the setting with the HCL function fn when written, if the
+// settings of the module are written as expressions
+func (m *Module) wrapSetting(setting string, fn string) {
+	if !m.writesExpressions() {
+		return
 	}
+	if m.WrapSettings == nil {
+		m.WrapSettings = make(map[string]string)
+	}
+	m.WrapSettings[setting] = fn
+}
+
+// infoSource returns the source the ModuleInfo of the module is read from,
+// which includes the version of Terraform Registry modules
+func (m Module) infoSource() string {
+	return sourcereader.VersionedSource(m.Source, m.Version)
 }
 
 // InfoOrDie returns the ModuleInfo for the module or panics
 func (m Module) InfoOrDie() modulereader.ModuleInfo {
-	mi, err := modulereader.GetModuleInfo(m.Source, m.Kind.String())
+	mi, err := modulereader.GetModuleInfo(m.infoSource(), m.Kind.String())
 	if err != nil {
 		panic(err)
 	}
@@ -329,31 +523,172 @@ func (m Module) InfoOrDie() modulereader.ModuleInfo {
 // integer is primarily for internal purposes even if it can be set in blueprint
 type Blueprint struct {
 	BlueprintName            string `yaml:"blueprint_name"`
+	SchemaVersion            int    `yaml:"schema_version,omitempty"`
 	GhpcVersion              string `yaml:"ghpc_version,omitempty"`
 	Validators               []validatorConfig
 	ValidationLevel          int `yaml:"validation_level,omitempty"`
 	Vars                     Dict
 	DeploymentGroups         []DeploymentGroup `yaml:"deployment_groups"`
 	TerraformBackendDefaults TerraformBackend  `yaml:"terraform_backend_defaults"`
+	MaintenanceSchedules     []Dict            `yaml:"maintenance_schedules,omitempty"`
+	Artifacts                []Artifact        `yaml:"artifacts,omitempty"`
+	// ArtifactStore is the gs://<bucket>/<prefix> URL the outputs of
+	// deployment groups are shared through, in addition to the deployment
+	// directory
+	ArtifactStore string `yaml:"artifact_store,omitempty"`
+	// Cloud is the cloud the deployment targets, "gcp" if not set
+	Cloud string `yaml:"cloud,omitempty"`
+	// DeploymentVariables declare the types, descriptions, defaults and
+	// validation rules of deployment variables
+	DeploymentVariables map[string]VariableDeclaration `yaml:"deployment_variables,omitempty"`
+	// TerraformProviders customizes the providers of the Terraform groups
+	TerraformProviders map[string]TerraformProvider `yaml:"terraform_providers,omitempty"`
+	// LabelPolicy constrains the labels of the deployment and its modules
+	LabelPolicy *LabelPolicy `yaml:"label_policy,omitempty"`
+	// ToolkitLabels controls the labels ghpc adds to the deployment and its
+	// modules
+	ToolkitLabels ToolkitLabels `yaml:"toolkit_labels,omitempty"`
+	// Provenance records the git revision of the blueprint
+	Provenance *Provenance `yaml:"provenance,omitempty"`
+	// Outputs are the module outputs surfaced as outputs of the deployment
+	Outputs []DeploymentOutput `yaml:"outputs,omitempty"`
+	// RemoteOutputs are the outputs of other deployments read into deployment
+	// variables
+	RemoteOutputs []RemoteOutputs `yaml:"remote_outputs,omitempty"`
+	// Profiles are named overrides of deployment variables and module settings
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+	// Profile is the name of the profile the deployment was created with, set
+	// by ghpc
+	Profile string `yaml:"profile,omitempty"`
 }
 
 // DeploymentConfig is a container for the imported YAML data and supporting data for
 // creating the blueprint from it
 type DeploymentConfig struct {
 	Config Blueprint
+	// AllowExec enables evaluation of `$(exec("cmd"))` expressions at expand time
+	AllowExec bool
+	// PolicyBundle is the path of the Rego policies evaluated against the
+	// expanded blueprint, with the validators; no policies if empty
+	PolicyBundle string
+	// EnableApis sets whether test_apis_enabled enables the required APIs that
+	// are disabled instead of failing
+	EnableApis EnableApisMode
+	// Terragrunt sets whether a terragrunt.hcl is written in the directory of
+	// Terraform groups
+	Terragrunt bool
+	// ReorderGroups sets whether deployment groups are moved after the groups
+	// they depend on instead of failing validation
+	ReorderGroups bool
+	// Backup sets whether an overwritten deployment directory is moved, without
+	// its state, to a timestamped directory next to it instead of removed
+	Backup bool
+	// Prompt, if set, asks for the values of required deployment variables
+	// and module settings that are not set instead of failing
+	Prompt PromptFunc
+	// File is the path of the blueprint, empty if it was not read from a file
+	File string
+	// Report, if set, receives the results of validators and policies
+	Report func(diagnostics.Diagnostic)
+	// positions of the validators, modules and variables in File
+	positions map[string]position
+}
+
+// EnableApisMode sets what test_apis_enabled does with disabled APIs
+type EnableApisMode int
+
+// Modes of test_apis_enabled
+const (
+	// EnableApisNever fails for disabled APIs
+	EnableApisNever EnableApisMode = iota
+	// EnableApisDryRun lists the disabled APIs that would be enabled, and fails
+	EnableApisDryRun
+	// EnableApisAlways enables the disabled APIs
+	EnableApisAlways
+)
+
+// ParseEnableApisMode parses a mode of enabling APIs: "true", "false" or
+// "dry-run"
+func ParseEnableApisMode(s string) (EnableApisMode, error) {
+	switch s {
+	case "false":
+		return EnableApisNever, nil
+	case "dry-run":
+		return EnableApisDryRun, nil
+	case "true":
+		return EnableApisAlways, nil
+	default:
+		return EnableApisNever, fmt.Errorf("invalid mode of enabling APIs %q (\"true\", \"false\", \"dry-run\")", s)
+	}
 }
 
 // ExpandConfig expands the yaml config in place
 func (dc *DeploymentConfig) ExpandConfig() error {
-	if err := dc.Config.checkMovedModules(); err != nil {
+	if err := dc.expandBlueprint(false); err != nil {
 		return err
 	}
+	return dc.validate()
+}
+
+// Expand returns the expanded deployment config, leaving dc unchanged.
+// Unlike ExpandConfig, validators are added to the blueprint but not run,
+// exec expressions fail regardless of AllowExec, remote outputs that are not
+// set in vars fail instead of being read, Prompt is not called and artifact
+// sources are left as written, so the result only depends on dc and on the
+// metadata of its modules.
+func (dc DeploymentConfig) Expand() (DeploymentConfig, error) {
+	res := dc
+	res.Config = dc.Config.Clone()
+	res.AllowExec = false
+	res.Prompt = nil
+	if err := res.expandBlueprint(true); err != nil {
+		return DeploymentConfig{}, err
+	}
+	return res, nil
+}
+
+// expandBlueprint runs all steps of the expansion but the validators. If
+// pure, neither remote outputs nor the working directory are read.
+func (dc *DeploymentConfig) expandBlueprint(pure bool) error {
+	readRemote := ReadRemoteOutputs
+	if pure {
+		readRemote = func(string) (map[string]RemoteOutput, error) {
+			return nil, errors.New("remote outputs are not read by Expand, set them in vars or use ExpandConfig")
+		}
+	}
+	if err := dc.applyExecSettings(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := dc.checkDeprecations(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := dc.Config.applyRemoteOutputs(readRemote); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := dc.Config.applyVariableDeclarations(dc.Prompt); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
 	dc.Config.setGlobalLabels()
 	dc.Config.addKindToModules()
-	dc.validateConfig()
-	dc.expand()
-	dc.validate()
-	return nil
+	dc.Config.addBootstrapModules()
+	if err := dc.Config.addMaintenanceModule(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := dc.Config.splitBackendModules(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := dc.orderGroups(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := dc.validateConfig(); err != nil {
+		return err
+	}
+	if !pure {
+		if err := dc.Config.absArtifactSources(); err != nil {
+			return errcode.New(errcode.ConfigError, err)
+		}
+	}
+	return dc.expand()
 }
 
 func (bp *Blueprint) setGlobalLabels() {
@@ -376,8 +711,8 @@ func (m Module) listUnusedModules() []ModuleID {
 
 	unused := []ModuleID{}
 	for _, w := range m.Use {
-		if !used[w] {
-			unused = append(unused, w)
+		if !used[w.Module] {
+			unused = append(unused, w.Module)
 		}
 	}
 	return unused
@@ -406,14 +741,18 @@ func (dc *DeploymentConfig) listUnusedDeploymentVariables() []string {
 	var usedVars = map[string]bool{
 		"labels":          true,
 		"deployment_name": true,
+		"max_budget":      true,
 	}
 
 	dc.Config.WalkModules(func(m *Module) error {
-		for _, v := range GetUsedDeploymentVars(m.Settings.AsObject()) {
+		for _, v := range GetUsedDeploymentVars(dc.Config.WithModuleVars(m.Settings.AsObject())) {
 			usedVars[v] = true
 		}
 		return nil
 	})
+	for _, v := range dc.Config.ProviderVars() {
+		usedVars[v] = true
+	}
 
 	unusedVars := []string{}
 	for k := range dc.Config.Vars.Items() {
@@ -425,45 +764,56 @@ func (dc *DeploymentConfig) listUnusedDeploymentVariables() []string {
 	return unusedVars
 }
 
-func (bp Blueprint) checkMovedModules() error {
-	var err error
-	bp.WalkModules(func(m *Module) error {
-		if replacement, ok := movedModules[strings.Trim(m.Source, "./")]; ok {
-			err = fmt.Errorf("the blueprint references modules that have moved")
-			fmt.Printf(
-				"A module you are using has moved. %s has been replaced with %s. Please update the source in your blueprint and try again.\n",
-				m.Source, replacement)
-		}
-		return nil
-	})
-	return err
-}
-
 // NewDeploymentConfig is a constructor for DeploymentConfig
 func NewDeploymentConfig(configFilename string) (DeploymentConfig, error) {
-	blueprint, err := importBlueprint(configFilename)
+	blueprint, b, err := importBlueprint(configFilename)
 	if err != nil {
-		return DeploymentConfig{}, err
+		return DeploymentConfig{}, errcode.New(errcode.ConfigError, err)
 	}
-	return DeploymentConfig{Config: blueprint}, nil
+	return DeploymentConfig{Config: blueprint, File: configFilename, positions: blueprintPositions(b)}, nil
 }
 
-// ImportBlueprint imports the blueprint configuration provided.
-func importBlueprint(blueprintFilename string) (Blueprint, error) {
-	var blueprint Blueprint
+// NewDeploymentConfigFromBytes is like NewDeploymentConfig, but parses the
+// blueprint from its YAML content rather than reading it from a file
+func NewDeploymentConfigFromBytes(b []byte) (DeploymentConfig, error) {
+	blueprint, err := parseBlueprint(b, "<bytes>")
+	if err != nil {
+		return DeploymentConfig{}, errcode.New(errcode.ConfigError, err)
+	}
+	return DeploymentConfig{Config: blueprint}, nil
+}
 
-	reader, err := os.Open(blueprintFilename)
+// ImportBlueprint imports the blueprint configuration provided, and returns
+// it with the content of the file, decrypted if it is encrypted with SOPS.
+func importBlueprint(blueprintFilename string) (Blueprint, []byte, error) {
+	b, err := readYamlFile(blueprintFilename)
 	if err != nil {
-		return blueprint, fmt.Errorf("%s, filename=%s: %v",
+		return Blueprint{}, nil, fmt.Errorf("%s, filename=%s: %v",
 			errorMessages["fileLoadError"], blueprintFilename, err)
 	}
+	bp, err := parseBlueprint(b, blueprintFilename)
+	return bp, b, err
+}
 
-	decoder := yaml.NewDecoder(reader)
+// parseBlueprint parses the YAML content of a blueprint, name identifies the
+// blueprint in error messages
+func parseBlueprint(b []byte, name string) (Blueprint, error) {
+	var blueprint Blueprint
+
+	b, migrated, err := Migrate(b)
+	if err != nil {
+		return blueprint, fmt.Errorf("failed to migrate blueprint %s: %w", name, err)
+	}
+	if len(migrated) > 0 {
+		log.Printf("WARNING: blueprint %s is of an older schema version and was migrated: %s; "+
+			"run `ghpc migrate` to update it", name, strings.Join(migrated, "; "))
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
 	decoder.KnownFields(true)
 
-	if err = decoder.Decode(&blueprint); err != nil {
+	if err := decoder.Decode(&blueprint); err != nil {
 		return blueprint, fmt.Errorf(errorMessages["yamlUnmarshalError"],
-			blueprintFilename, err)
+			name, err)
 	}
 
 	// if the validation level has been explicitly set to an invalid value
@@ -475,20 +825,32 @@ func importBlueprint(blueprintFilename string) (Blueprint, error) {
 	return blueprint, nil
 }
 
-// ExportBlueprint exports the internal representation of a blueprint config
-func (dc DeploymentConfig) ExportBlueprint(outputFilename string) error {
+// CanonicalYAML returns the YAML serialization of the blueprint. Equal
+// blueprints have identical serializations, which makes them suitable for
+// comparison and golden files.
+func (bp Blueprint) CanonicalYAML() ([]byte, error) {
 	var buf bytes.Buffer
-	buf.WriteString(YamlLicense)
-	buf.WriteString("\n")
 	encoder := yaml.NewEncoder(&buf)
 	encoder.SetIndent(2)
-	err := encoder.Encode(&dc.Config)
+	err := encoder.Encode(&bp)
 	encoder.Close()
-	d := buf.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", errorMessages["yamlMarshalError"], err)
+	}
+	return buf.Bytes(), nil
+}
 
+// ExportBlueprint exports the internal representation of a blueprint config
+func (dc DeploymentConfig) ExportBlueprint(outputFilename string) error {
+	y, err := dc.Config.CanonicalYAML()
 	if err != nil {
-		return fmt.Errorf("%s: %w", errorMessages["yamlMarshalError"], err)
+		return err
 	}
+	var buf bytes.Buffer
+	buf.WriteString(YamlLicense)
+	buf.WriteString("\n")
+	buf.Write(y)
+	d := buf.Bytes()
 
 	err = ioutil.WriteFile(outputFilename, d, 0644)
 	if err != nil {
@@ -509,13 +871,58 @@ func (bp *Blueprint) addKindToModules() {
 	})
 }
 
+// maxModuleWorkers bounds the number of modules read or fetched at once
+const maxModuleWorkers = 16
+
+// RunModuleWorkers runs work for each of n modules, at most maxModuleWorkers
+// at a time, and returns the errors by module index
+func RunModuleWorkers(n int, work func(i int) error) []error {
+	errs := make([]error, n)
+	sem := make(chan struct{}, maxModuleWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = work(i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
 // checkModulesInfo ensures each module in the blueprint has known detailed
-// metadata (inputs, outputs)
+// metadata (inputs, outputs). Modules are read concurrently, errors are
+// reported in the order modules appear in the blueprint.
 func (bp *Blueprint) checkModulesInfo() error {
-	return bp.WalkModules(func(m *Module) error {
-		_, err := modulereader.GetModuleInfo(m.Source, m.Kind.String())
+	mods := []Module{}
+	bp.WalkModules(func(m *Module) error {
+		mods = append(mods, *m)
+		return nil
+	})
+
+	errs := RunModuleWorkers(len(mods), func(i int) error {
+		_, err := modulereader.GetModuleInfo(mods[i].infoSource(), mods[i].Kind.String())
 		return err
 	})
+
+	failed := []string{}
+	var first error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if first == nil {
+			first = err
+		}
+		failed = append(failed, fmt.Sprintf("module %s: %v", mods[i].ID, err))
+	}
+	if len(failed) > 1 {
+		return fmt.Errorf("failed to read %d modules:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return first
 }
 
 // checkModulesAndGroups ensures:
@@ -564,7 +971,7 @@ func checkModulesAndGroups(groups []DeploymentGroup) error {
 func checkUsedModuleNames(bp Blueprint) error {
 	return bp.WalkModules(func(mod *Module) error {
 		for _, used := range mod.Use {
-			if err := validateModuleReference(bp, *mod, used); err != nil {
+			if err := validateModuleReference(bp, *mod, used.Module); err != nil {
 				return err
 			}
 		}
@@ -602,45 +1009,127 @@ func checkBackends(bp Blueprint) error {
 }
 
 // validateConfig runs a set of simple early checks on the imported input YAML
-func (dc *DeploymentConfig) validateConfig() {
-	_, err := dc.Config.DeploymentName()
-	if err != nil {
-		log.Fatal(err)
+func (dc *DeploymentConfig) validateConfig() error {
+	if _, err := dc.Config.DeploymentName(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
-	err = dc.Config.checkBlueprintName()
-	if err != nil {
-		log.Fatal(err)
+	if err := dc.Config.checkBlueprintName(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
 
-	if err = dc.validateVars(); err != nil {
-		log.Fatal(err)
+	if err := dc.validateVars(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
 
-	if err = dc.Config.checkModulesInfo(); err != nil {
-		log.Fatal(err)
+	if err := checkModuleVersions(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
 
-	if err = checkModulesAndGroups(dc.Config.DeploymentGroups); err != nil {
-		log.Fatal(err)
+	if err := checkModuleIntegrity(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := dc.Config.checkModulesInfo(); err != nil {
+		return errcode.New(errcode.SourceFetchFailure, err)
+	}
+
+	if err := checkModulesAndGroups(dc.Config.DeploymentGroups); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
 
 	// checkPackerGroups must come after checkModulesAndGroups, in which group
 	// Kind is set and aligned with module Kinds
-	if err = checkPackerGroups(dc.Config.DeploymentGroups); err != nil {
-		log.Fatal(err)
+	if err := checkPackerGroups(dc.Config.DeploymentGroups); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	if err := checkHelmGroups(dc.Config.DeploymentGroups); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkBootstrapGroups(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkCloud(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkTerraformProviders(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkGroupProjects(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkGroupHooks(dc.Config.DeploymentGroups); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkGroupRetry(dc.Config.DeploymentGroups); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkGroupDependsOn(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
 
-	if err = checkUsedModuleNames(dc.Config); err != nil {
-		log.Fatal(err)
+	if err := checkProfiles(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
 
-	if err = checkBackends(dc.Config); err != nil {
-		log.Fatal(err)
+	if err := checkToolkitLabels(dc.Config.ToolkitLabels); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
 
-	if err = checkModuleSettings(dc.Config); err != nil {
-		log.Fatal(err)
+	if err := checkLabelPolicy(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
 	}
+
+	if err := checkUsedModuleNames(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkMovedFrom(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkBackends(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkModuleSettings(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkModuleVars(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkModuleOutputs(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkHealthProbes(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkWrapSettings(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkArtifacts(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := checkDeploymentOutputs(dc.Config); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := dc.checkValidators(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	return nil
 }
 
 // SkipValidator marks validator(s) as skipped,
@@ -771,6 +1260,9 @@ func checkModuleSettings(bp Blueprint) error {
 	return bp.WalkModules(func(m *Module) error {
 		return cty.Walk(m.Settings.AsObject(), func(p cty.Path, v cty.Value) (bool, error) {
 			if e, is := IsExpressionValue(v); is {
+				if _, raw := e.(RawExpression); raw && !m.writesExpressions() {
+					return false, fmt.Errorf("module %s: settings of %s modules are written as values, which cannot be raw HCL expressions", m.ID, m.Kind)
+				}
 				for _, r := range e.References() {
 					if err := validateModuleSettingReference(bp, *m, r); err != nil {
 						return false, err
@@ -782,11 +1274,48 @@ func checkModuleSettings(bp Blueprint) error {
 	})
 }
 
-func checkPackerGroups(groups []DeploymentGroup) error {
-	for _, group := range groups {
-		if group.Kind == PackerKind && len(group.Modules) != 1 {
-			return fmt.Errorf("group %s is \"kind: packer\" but has more than 1 module; separate each packer module into its own deployment group", group.Name)
+// checkWrapSettings validates the functions settings are wrapped with in the
+// blueprint, those declared in module metadata are validated when it is read
+func checkWrapSettings(bp Blueprint) error {
+	return bp.WalkModules(func(m *Module) error {
+		if len(m.WrapSettings) == 0 {
+			return nil
 		}
-	}
-	return nil
+		if !m.writesExpressions() {
+			return fmt.Errorf("module %s: settings of %s modules are written as values, which cannot be wrapped", m.ID, m.Kind)
+		}
+		settings := maps.Keys(m.WrapSettings)
+		slices.Sort(settings)
+		for _, setting := range settings {
+			if fn := m.WrapSettings[setting]; !modulereader.IsWrapperName(fn) {
+				return fmt.Errorf("module %s: %q is not a valid function name to wrap setting %s with", m.ID, fn, setting)
+			}
+		}
+		return nil
+	})
+}
+
+// checkHealthProbes validates the health probes set in the blueprint, probes
+// declared in module metadata are validated when the metadata is read
+func checkHealthProbes(bp Blueprint) error {
+	return bp.WalkModules(func(m *Module) error {
+		if len(m.HealthProbes) == 0 {
+			return nil
+		}
+		if m.Kind != TerraformKind {
+			return fmt.Errorf("module %s: health probes are only supported for Terraform modules", m.ID)
+		}
+		outputs := m.InfoOrDie().Outputs
+		for _, p := range m.HealthProbes {
+			if err := p.Check(outputs); err != nil {
+				return fmt.Errorf("module %s: %v", m.ID, err)
+			}
+			for _, name := range p.VarReferences() {
+				if !bp.Vars.Has(name) {
+					return fmt.Errorf("module %s: health probe %s references $(vars.%s), which is not a deployment variable", m.ID, p.Name, name)
+				}
+			}
+		}
+		return nil
+	})
 }
@@ -24,13 +24,17 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"hpc-toolkit/pkg/cache"
 	"hpc-toolkit/pkg/modulereader"
 
 	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
 	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -61,10 +65,9 @@ deployment_groups:
 `)
 	testModules = []Module{
 		{
-			Source:           "./modules/network/vpc",
-			Kind:             TerraformKind,
-			ID:               "vpc",
-			WrapSettingsWith: make(map[string][]string),
+			Source: "./modules/network/vpc",
+			Kind:   TerraformKind,
+			ID:     "vpc",
 			Settings: NewDict(map[string]cty.Value{
 				"network_name": cty.StringVal("$\"${var.deployment_name}_net\""),
 				"project_id":   cty.StringVal("project_name"),
@@ -160,23 +163,21 @@ func cleanErrorRegexp(errRegexp string) string {
 }
 
 func setTestModuleInfo(mod Module, info modulereader.ModuleInfo) {
-	modulereader.SetModuleInfo(mod.Source, mod.Kind.String(), info)
+	modulereader.SetModuleInfo(mod.infoSource(), mod.Kind.String(), info)
 }
 
 func getDeploymentConfigForTest() DeploymentConfig {
 	testModule := Module{
-		Source:           "testSource",
-		Kind:             TerraformKind,
-		ID:               "testModule",
-		Use:              []ModuleID{},
-		WrapSettingsWith: make(map[string][]string),
+		Source: "testSource",
+		Kind:   TerraformKind,
+		ID:     "testModule",
+		Use:    []ModuleUse{},
 	}
 	testModuleWithLabels := Module{
-		Source:           "./role/source",
-		ID:               "testModuleWithLabels",
-		Kind:             TerraformKind,
-		Use:              []ModuleID{},
-		WrapSettingsWith: make(map[string][]string),
+		Source: "./role/source",
+		ID:     "testModuleWithLabels",
+		Kind:   TerraformKind,
+		Use:    []ModuleUse{},
 		Settings: NewDict(map[string]cty.Value{
 			"moduleLabel": cty.StringVal("moduleLabelValue"),
 		}),
@@ -322,7 +323,7 @@ func getMultiGroupDeploymentConfig() DeploymentConfig {
 			matchingIntragroupName1: cty.StringVal("explicit-intra-value"),
 			matchingIntragroupName2: ModuleRef(mod0.ID, matchingIntragroupName2).AsExpression().AsValue(),
 		}),
-		Use: []ModuleID{mod0.ID},
+		Use: []ModuleUse{{Module: mod0.ID}},
 	}
 	setTestModuleInfo(mod1, testModuleInfo1)
 
@@ -335,7 +336,7 @@ func getMultiGroupDeploymentConfig() DeploymentConfig {
 		ID:     "TestModule2",
 		Kind:   TerraformKind,
 		Source: testModuleSource2,
-		Use:    []ModuleID{mod0.ID},
+		Use:    []ModuleUse{{Module: mod0.ID}},
 	}
 	setTestModuleInfo(mod2, testModuleInfo2)
 
@@ -393,7 +394,7 @@ func getDeploymentConfigWithTestModuleEmptyKind() DeploymentConfig {
 // config.go
 func (s *MySuite) TestExpandConfig(c *C) {
 	dc := getBasicDeploymentConfigWithTestModule()
-	dc.ExpandConfig()
+	c.Assert(dc.ExpandConfig(), IsNil)
 }
 
 func (s *MySuite) TestCheckModulesAndGroups(c *C) {
@@ -418,6 +419,57 @@ func (s *MySuite) TestCheckModulesAndGroups(c *C) {
 	}
 }
 
+func (s *MySuite) TestCheckModulesInfo(c *C) {
+	good := Module{ID: "good", Source: "./good/source", Kind: TerraformKind}
+	setTestModuleInfo(good, modulereader.ModuleInfo{})
+	{ // All modules readable
+		bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Modules: []Module{good, good}}}}
+		c.Check(bp.checkModulesInfo(), IsNil)
+	}
+	{ // Single failure is reported as is
+		bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Modules: []Module{
+			good, {ID: "bad", Source: "not/a/source", Kind: TerraformKind}}}}}
+		c.Check(bp.checkModulesInfo(), ErrorMatches, "Source is not valid: not/a/source")
+	}
+	{ // Multiple failures are reported in blueprint order
+		mods := []Module{}
+		for i := 0; i < 3*maxModuleWorkers; i++ {
+			mods = append(mods, good)
+		}
+		mods = append(mods,
+			Module{ID: "bad1", Source: "not/a/source1", Kind: TerraformKind},
+			Module{ID: "bad0", Source: "not/a/source0", Kind: TerraformKind})
+		bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Modules: mods}}}
+		c.Check(bp.checkModulesInfo(), ErrorMatches,
+			"failed to read 2 modules:\nmodule bad1: Source is not valid: not/a/source1\nmodule bad0: Source is not valid: not/a/source0")
+	}
+}
+
+func (s *MySuite) TestRunModuleWorkers(c *C) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	errs := RunModuleWorkers(3*maxModuleWorkers, func(i int) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if i%2 == 1 {
+			return fmt.Errorf("module %d", i)
+		}
+		return nil
+	})
+	c.Check(maxRunning <= maxModuleWorkers, Equals, true)
+	c.Assert(errs, HasLen, 3*maxModuleWorkers)
+	c.Check(errs[0], IsNil)
+	c.Check(errs[3], ErrorMatches, "module 3")
+}
+
 func (s *MySuite) TestListUnusedModules(c *C) {
 	{ // No modules in "use"
 		m := Module{ID: "m"}
@@ -427,7 +479,7 @@ func (s *MySuite) TestListUnusedModules(c *C) {
 	{ // Useful
 		m := Module{
 			ID:  "m",
-			Use: []ModuleID{"w"},
+			Use: []ModuleUse{{Module: "w"}},
 			Settings: NewDict(map[string]cty.Value{
 				"x": cty.True.Mark(ProductOfModuleUse{"w"})})}
 		c.Check(m.listUnusedModules(), DeepEquals, []ModuleID{})
@@ -436,7 +488,7 @@ func (s *MySuite) TestListUnusedModules(c *C) {
 	{ // Unused
 		m := Module{
 			ID:  "m",
-			Use: []ModuleID{"w", "u"},
+			Use: []ModuleUse{{Module: "w"}, {Module: "u"}},
 			Settings: NewDict(map[string]cty.Value{
 				"x": cty.True.Mark(ProductOfModuleUse{"w"})})}
 		c.Check(m.listUnusedModules(), DeepEquals, []ModuleID{"u"})
@@ -607,7 +659,7 @@ func (s *MySuite) TestNewBlueprint(c *C) {
 }
 
 func (s *MySuite) TestImportBlueprint(c *C) {
-	obtainedBlueprint, err := importBlueprint(simpleYamlFilename)
+	obtainedBlueprint, _, err := importBlueprint(simpleYamlFilename)
 	c.Assert(err, IsNil)
 	c.Assert(obtainedBlueprint.BlueprintName,
 		Equals, expectedSimpleBlueprint.BlueprintName)
@@ -725,7 +777,7 @@ dragon: "Lews Therin Telamon"`)
 	file.Close()
 
 	// should fail on strict unmarshal as field does not match schema
-	_, err := importBlueprint(filename)
+	_, _, err := importBlueprint(filename)
 	c.Check(err, NotNil)
 }
 
@@ -742,6 +794,8 @@ func (s *MySuite) TestExportBlueprint(c *C) {
 }
 
 func TestMain(m *testing.M) {
+	// do not read or pollute the disk cache of the user
+	cache.Enabled = false
 	setup()
 	code := m.Run()
 	teardown()
@@ -755,6 +809,24 @@ func (s *MySuite) TestValidationLevels(c *C) {
 
 	c.Check(isValidValidationLevel(-1), Equals, false)
 	c.Check(isValidValidationLevel(3), Equals, false)
+
+	for s, want := range map[string]int{"ERROR": ValidationError, "WARNING": ValidationWarning, "IGNORE": ValidationIgnore} {
+		got, err := ParseValidationLevel(s)
+		c.Check(err, IsNil)
+		c.Check(got, Equals, want)
+	}
+	_, err := ParseValidationLevel("warning")
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestParseEnableApisMode(c *C) {
+	for s, want := range map[string]EnableApisMode{"false": EnableApisNever, "dry-run": EnableApisDryRun, "true": EnableApisAlways} {
+		got, err := ParseEnableApisMode(s)
+		c.Check(err, IsNil)
+		c.Check(got, Equals, want)
+	}
+	_, err := ParseEnableApisMode("yes")
+	c.Check(err, NotNil)
 }
 
 func (s *MySuite) TestCheckMovedModules(c *C) {
@@ -762,17 +834,18 @@ func (s *MySuite) TestCheckMovedModules(c *C) {
 		DeploymentGroups: []DeploymentGroup{
 			{Modules: []Module{
 				{Source: "some/module/that/has/not/moved"}}}}}
+	dc := DeploymentConfig{Config: bp}
 
 	// base case should not err
-	c.Assert(bp.checkMovedModules(), IsNil)
+	c.Assert(dc.checkDeprecations(), IsNil)
 
 	// embedded moved
 	bp.DeploymentGroups[0].Modules[0].Source = "community/modules/scheduler/cloud-batch-job"
-	c.Assert(bp.checkMovedModules(), NotNil)
+	c.Assert(dc.checkDeprecations(), NotNil)
 
 	// local moved
 	bp.DeploymentGroups[0].Modules[0].Source = "./community/modules/scheduler/cloud-batch-job"
-	c.Assert(bp.checkMovedModules(), NotNil)
+	c.Assert(dc.checkDeprecations(), NotNil)
 }
 
 func (s *MySuite) TestValidatorConfigCheck(c *C) {
@@ -978,6 +1051,7 @@ func (s *MySuite) TestValidateModuleSettingReference(c *C) {
 	mod21 := Module{ID: "mod21", Source: "./mod21", Kind: TerraformKind}
 	mod22 := Module{ID: "mod22", Source: "./mod22", Kind: TerraformKind}
 	pkr := Module{ID: "pkr", Source: "./pkr", Kind: PackerKind}
+	pkr2 := Module{ID: "pkr2", Source: "./pkr2", Kind: PackerKind}
 
 	bp := Blueprint{
 		Vars: NewDict(map[string]cty.Value{
@@ -985,7 +1059,7 @@ func (s *MySuite) TestValidateModuleSettingReference(c *C) {
 		}),
 		DeploymentGroups: []DeploymentGroup{
 			{Name: "group1", Modules: []Module{mod11}},
-			{Name: "groupP", Modules: []Module{pkr}},
+			{Name: "groupP", Modules: []Module{pkr, pkr2}},
 			{Name: "group2", Modules: []Module{mod21, mod22}},
 		},
 	}
@@ -1022,6 +1096,13 @@ func (s *MySuite) TestValidateModuleSettingReference(c *C) {
 
 	// Fail. packer module
 	c.Check(vld(bp, mod21, ModuleRef("pkr", "outPkr")), NotNil)
+
+	// FAIL. image of a packer module of the same group
+	c.Check(vld(bp, pkr2, ModuleRef("pkr", "image_name")), NotNil)
+
+	// OK. packer modules of a group use each other to order their builds
+	c.Check(validateModuleReference(bp, pkr2, "pkr"), IsNil)
+	c.Check(validateModuleReference(bp, mod21, "pkr"), NotNil)
 }
 
 func (s *MySuite) TestCheckModuleSettings(c *C) {
@@ -1036,4 +1117,36 @@ func (s *MySuite) TestCheckModuleSettings(c *C) {
 
 	bp.Vars.Set("zebra", cty.StringVal("stripes"))
 	c.Check(checkModuleSettings(bp), IsNil)
+
+	// raw HCL expressions are only written by Terraform modules
+	raw, err := ParseRawExpression("upper(var.zebra)")
+	c.Assert(err, IsNil)
+	bp.DeploymentGroups[0].Modules[0].Settings.Set("black", raw.AsValue())
+	c.Check(checkModuleSettings(bp), IsNil)
+	bp.DeploymentGroups[0].Modules[0].Kind = PackerKind
+	c.Check(checkModuleSettings(bp), ErrorMatches, ".*cannot be raw HCL expressions.*")
+}
+
+func (s *MySuite) TestModuleUseYAML(c *C) {
+	y := `- network1
+- module: network2
+  map:
+    subnetwork_self_link: subnet
+- module: homefs
+  merge: true
+`
+	var use []ModuleUse
+	c.Assert(yaml.Unmarshal([]byte(y), &use), IsNil)
+	c.Check(use, DeepEquals, []ModuleUse{
+		{Module: "network1"},
+		{Module: "network2", Map: map[string]string{"subnetwork_self_link": "subnet"}},
+		{Module: "homefs", Merge: true},
+	})
+
+	out, err := yaml.Marshal(use)
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, y)
+
+	c.Check(yaml.Unmarshal([]byte("- map: {a: b}"), &use), ErrorMatches, ".*use is missing the module")
+	c.Check(yaml.Unmarshal([]byte("- {module: a, maps: {a: b}}"), &use), ErrorMatches, ".*field maps not found.*")
 }
@@ -0,0 +1,128 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package configtest provides helpers to test blueprint expansion against
+// golden files, without reading modules from the filesystem
+package configtest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulereader"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UpdateEnvVar, when set to a non-empty value, makes AssertGolden write the
+// golden files instead of comparing against them
+const UpdateEnvVar = "GHPC_UPDATE_GOLDEN"
+
+// Names of the files of a fixture directory
+const (
+	BlueprintFile = "blueprint.yaml"
+	ModulesFile   = "modules.yaml"
+	ExpandedFile  = "expanded.yaml"
+)
+
+// ModuleFixture is the metadata of a module used by a fixture blueprint
+type ModuleFixture struct {
+	Source string
+	Kind   string
+	Info   modulereader.ModuleInfo
+}
+
+// SetModuleInfo registers the metadata of modules, given as a YAML list of
+// ModuleFixture, so that expansion does not read the modules
+func SetModuleInfo(t testing.TB, b []byte) {
+	t.Helper()
+	var mods []ModuleFixture
+	if err := yaml.Unmarshal(b, &mods); err != nil {
+		t.Fatalf("failed to parse module fixtures: %v", err)
+	}
+	for _, m := range mods {
+		kind := m.Kind
+		if kind == "" {
+			kind = config.TerraformKind.String()
+		}
+		modulereader.SetModuleInfo(m.Source, kind, m.Info)
+	}
+}
+
+// Expand parses and expands the blueprint, failing the test on errors
+func Expand(t testing.TB, blueprint []byte) config.DeploymentConfig {
+	t.Helper()
+	dc, err := config.NewDeploymentConfigFromBytes(blueprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, err := dc.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exp
+}
+
+// AssertGolden compares got with the content of the golden file
+func AssertGolden(t testing.TB, got []byte, golden string) {
+	t.Helper()
+	if os.Getenv(UpdateEnvVar) != "" {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read golden file, set %s=1 to create it: %v", UpdateEnvVar, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match %s, set %s=1 to update it\ngot:\n%s\nwant:\n%s",
+			golden, UpdateEnvVar, got, want)
+	}
+}
+
+// RunFixtures runs a subtest for each fixture directory in dir. A fixture
+// consists of a blueprint, the metadata of its modules, and the golden
+// canonical serialization of the expanded blueprint.
+func RunFixtures(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		fixture := filepath.Join(dir, e.Name())
+		t.Run(e.Name(), func(t *testing.T) {
+			if mods, err := os.ReadFile(filepath.Join(fixture, ModulesFile)); err == nil {
+				SetModuleInfo(t, mods)
+			}
+			bp, err := os.ReadFile(filepath.Join(fixture, BlueprintFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Expand(t, bp).Config.CanonicalYAML()
+			if err != nil {
+				t.Fatal(err)
+			}
+			AssertGolden(t, got, filepath.Join(fixture, ExpandedFile))
+		})
+	}
+}
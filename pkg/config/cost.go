@@ -0,0 +1,158 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+
+	"hpc-toolkit/pkg/cost"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// BillableResources returns the billable resources of the modules of an
+// expanded blueprint: the instances of modules with a machine_type setting, with their
+// accelerators and disks, and Filestore instances. Instances are counted from
+// the instance_count, node_count_static or node_count setting, 1 if none is
+// set; nodes that are only created on demand are not counted.
+func (bp Blueprint) BillableResources() []cost.Resource {
+	res := []cost.Resource{}
+	for _, g := range bp.DeploymentGroups {
+		for _, m := range g.Modules {
+			s := costSettings{bp: bp, m: m}
+			zone := s.str("zone", bp.Vars.Get("zone"))
+			region := s.str("region", bp.Vars.Get("region"))
+			if i := strings.LastIndex(zone, "-"); region == "" && i > 0 {
+				region = zone[:i]
+			}
+			add := func(kind string, typ string, quantity float64) {
+				if typ == "" || quantity <= 0 {
+					return
+				}
+				res = append(res, cost.Resource{
+					Group: string(g.Name), Module: string(m.ID), Kind: kind, Type: typ,
+					Region: region, Zone: zone, Quantity: quantity})
+			}
+
+			if strings.HasSuffix(m.Source, "filestore") {
+				add(cost.Filestore, s.str("filestore_tier", cty.StringVal("BASIC_HDD")), s.num("size_gb", 1024))
+				continue
+			}
+			mt := s.str("machine_type", cty.NilVal)
+			if mt == "" {
+				continue
+			}
+			count := s.count()
+			add(cost.Instance, mt, count)
+			for _, a := range s.accelerators() {
+				add(cost.Accelerator, a.typ, a.count*count)
+			}
+			if size := s.num("disk_size_gb", 0); size > 0 {
+				add(cost.Disk, s.str("disk_type", cty.StringVal("pd-standard")), size*count)
+			}
+		}
+	}
+	return res
+}
+
+// costSettings evaluates the settings of a module that determine its cost
+type costSettings struct {
+	bp Blueprint
+	m  Module
+}
+
+// get returns the value of the setting, with references to deployment
+// variables evaluated, or null if it is not set or cannot be evaluated
+func (s costSettings) get(name string) cty.Value {
+	if !s.m.Settings.Has(name) {
+		return cty.NilVal
+	}
+	v, err := cty.Transform(s.m.Settings.Get(name), func(p cty.Path, v cty.Value) (cty.Value, error) {
+		if e, is := IsExpressionValue(v); is {
+			return e.Eval(s.bp)
+		}
+		return v, nil
+	})
+	if err != nil || !v.IsWhollyKnown() {
+		return cty.NilVal
+	}
+	return v
+}
+
+func (s costSettings) str(name string, def cty.Value) string {
+	v := s.get(name)
+	if v == cty.NilVal || v.IsNull() {
+		v = def
+	}
+	if v == cty.NilVal || v.IsNull() || v.Type() != cty.String {
+		return ""
+	}
+	return v.AsString()
+}
+
+func (s costSettings) num(name string, def float64) float64 {
+	return costNumber(s.get(name), def)
+}
+
+func costNumber(v cty.Value, def float64) float64 {
+	if v == cty.NilVal || v.IsNull() || v.Type() != cty.Number {
+		return def
+	}
+	f, _ := v.AsBigFloat().Float64()
+	return f
+}
+
+func (s costSettings) count() float64 {
+	for _, name := range []string{"instance_count", "node_count_static", "node_count"} {
+		if v := s.get(name); v != cty.NilVal && !v.IsNull() {
+			return costNumber(v, 1)
+		}
+	}
+	return 1
+}
+
+type accelerator struct {
+	typ   string
+	count float64
+}
+
+// accelerators returns the accelerators of an instance, set by the
+// guest_accelerator list or the gpu object
+func (s costSettings) accelerators() []accelerator {
+	objs := []cty.Value{}
+	if v := s.get("guest_accelerator"); v != cty.NilVal && !v.IsNull() && v.CanIterateElements() {
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+			objs = append(objs, e)
+		}
+	}
+	if v := s.get("gpu"); v != cty.NilVal && !v.IsNull() {
+		objs = append(objs, v)
+	}
+
+	res := []accelerator{}
+	for _, o := range objs {
+		if !o.Type().IsObjectType() && !o.Type().IsMapType() {
+			continue
+		}
+		m := o.AsValueMap()
+		t, ok := m["type"]
+		if !ok || t.IsNull() || t.Type() != cty.String {
+			continue
+		}
+		res = append(res, accelerator{typ: t.AsString(), count: costNumber(m["count"], 1)})
+	}
+	return res
+}
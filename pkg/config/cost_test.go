@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"hpc-toolkit/pkg/cost"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func costTestBlueprint() Blueprint {
+	return Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"deployment_name": cty.StringVal("dep"),
+			"region":          cty.StringVal("us-central1"),
+			"zone":            cty.StringVal("us-central1-a"),
+		}),
+		DeploymentGroups: []DeploymentGroup{{
+			Name: "primary",
+			Modules: []Module{{
+				ID:     "homefs",
+				Source: "modules/file-system/filestore",
+				Settings: NewDict(map[string]cty.Value{
+					"filestore_tier": cty.StringVal("BASIC_SSD"),
+					"size_gb":        cty.NumberIntVal(2560),
+				}),
+			}, {
+				ID:     "network",
+				Source: "modules/network/vpc",
+			}},
+		}, {
+			Name: "compute",
+			Modules: []Module{{
+				ID:     "gpu_nodes",
+				Source: "modules/compute/vm-instance",
+				Settings: NewDict(map[string]cty.Value{
+					"machine_type":   cty.StringVal("a2-highgpu-2g"),
+					"instance_count": cty.NumberIntVal(4),
+					"zone":           MustParseExpression("var.zone").AsValue(),
+					"disk_size_gb":   cty.NumberIntVal(100),
+					"disk_type":      cty.StringVal("pd-ssd"),
+					"guest_accelerator": cty.TupleVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+						"type":  cty.StringVal("nvidia-tesla-a100"),
+						"count": cty.NumberIntVal(2),
+					})}),
+				}),
+			}, {
+				ID:     "login",
+				Source: "community/modules/scheduler/schedmd-slurm-gcp-v5-login",
+				Settings: NewDict(map[string]cty.Value{
+					"machine_type": cty.StringVal("n2-standard-4"),
+					"zone":         cty.StringVal("europe-west4-b"),
+				}),
+			}},
+		}},
+	}
+}
+
+func TestBillableResources(t *testing.T) {
+	want := []cost.Resource{
+		{Group: "primary", Module: "homefs", Kind: cost.Filestore, Type: "BASIC_SSD", Region: "us-central1", Zone: "us-central1-a", Quantity: 2560},
+		{Group: "compute", Module: "gpu_nodes", Kind: cost.Instance, Type: "a2-highgpu-2g", Region: "us-central1", Zone: "us-central1-a", Quantity: 4},
+		{Group: "compute", Module: "gpu_nodes", Kind: cost.Accelerator, Type: "nvidia-tesla-a100", Region: "us-central1", Zone: "us-central1-a", Quantity: 8},
+		{Group: "compute", Module: "gpu_nodes", Kind: cost.Disk, Type: "pd-ssd", Region: "us-central1", Zone: "us-central1-a", Quantity: 400},
+		{Group: "compute", Module: "login", Kind: cost.Instance, Type: "n2-standard-4", Region: "us-central1", Zone: "europe-west4-b", Quantity: 1},
+	}
+	if diff := cmp.Diff(want, costTestBlueprint().BillableResources()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestBillableResourcesRegionFromZone(t *testing.T) {
+	bp := costTestBlueprint()
+	bp.Vars = NewDict(map[string]cty.Value{"zone": cty.StringVal("europe-west4-b")})
+	got := bp.BillableResources()
+	if got[len(got)-1].Region != "europe-west4" {
+		t.Errorf("got region %q, want europe-west4", got[len(got)-1].Region)
+	}
+}
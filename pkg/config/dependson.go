@@ -0,0 +1,54 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// checkGroupDependsOn ensures that the groups each group depends on exist and
+// come before it, as groups are created in order
+func checkGroupDependsOn(bp Blueprint) error {
+	for i, g := range bp.DeploymentGroups {
+		for j, d := range g.DependsOn {
+			if slices.Contains(g.DependsOn[:j], d) {
+				return fmt.Errorf("group %s depends on group %s more than once", g.Name, d)
+			}
+			k := bp.GroupIndex(d)
+			switch {
+			case k < 0:
+				return fmt.Errorf("group %s depends on group %s, which does not exist", g.Name, d)
+			case k == i:
+				return fmt.Errorf("group %s can not depend on itself", g.Name)
+			case k > i:
+				return fmt.Errorf("group %s depends on group %s, which is in a later position; move it before %s", g.Name, d, g.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Dependencies returns the groups the group is deployed after: the groups
+// whose outputs it uses and the groups it depends on explicitly, sorted
+func (dg DeploymentGroup) Dependencies(bp Blueprint) []GroupName {
+	deps := append([]GroupName{}, dg.DependsOn...)
+	for _, r := range dg.FindAllIntergroupReferences(bp) {
+		deps = append(deps, bp.ModuleGroupOrDie(r.Module).Name)
+	}
+	slices.Sort(deps)
+	return slices.Compact(deps)
+}
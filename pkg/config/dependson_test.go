@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestCheckGroupDependsOn(t *testing.T) {
+	bp := func(deps ...GroupName) Blueprint {
+		return Blueprint{DeploymentGroups: []DeploymentGroup{
+			{Name: "primary"},
+			{Name: "cluster", DependsOn: deps},
+			{Name: "monitoring"},
+		}}
+	}
+	if err := checkGroupDependsOn(bp("primary")); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+	for _, deps := range [][]GroupName{
+		{"missing"},
+		{"cluster"},
+		{"monitoring"},
+		{"primary", "primary"},
+	} {
+		if err := checkGroupDependsOn(bp(deps...)); err == nil {
+			t.Errorf("expected an error for %v", deps)
+		}
+	}
+}
+
+func TestGroupDependencies(t *testing.T) {
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "primary", Modules: []Module{{ID: "network"}}},
+		{Name: "storage", Modules: []Module{{ID: "fs"}}},
+		{Name: "cluster", Modules: []Module{{
+			ID: "nodes",
+			Settings: NewDict(map[string]cty.Value{
+				"network": ModuleRef("network", "id").AsExpression().AsValue(),
+				"fs":      ModuleRef("fs", "id").AsExpression().AsValue(),
+			}),
+		}}, DependsOn: []GroupName{"storage"}},
+		{Name: "monitoring", DependsOn: []GroupName{"primary", "cluster"}},
+	}}
+	for _, tc := range []struct {
+		group int
+		want  []GroupName
+	}{
+		{0, []GroupName{}},
+		{2, []GroupName{"primary", "storage"}},
+		{3, []GroupName{"cluster", "primary"}},
+	} {
+		got := bp.DeploymentGroups[tc.group].Dependencies(bp)
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("%s: diff (-want +got):\n%s", bp.DeploymentGroups[tc.group].Name, diff)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"hpc-toolkit/pkg/diagnostics"
+
+	"gopkg.in/yaml.v3"
+)
+
+// deprecation is a module source, or a setting of a module, that is
+// deprecated
+type deprecation struct {
+	// module is the source of the module whose setting is deprecated, empty
+	// if old is a module source
+	module string
+	// old is the deprecated module source or setting
+	old string
+	// new replaces old, if any
+	new string
+	// usable is set if old still works and is only warned about; the others
+	// are removed and fail the blueprint
+	usable bool
+	// rewrite is set if ghpc migrate can replace old by new as is
+	rewrite bool
+	// note tells how to migrate if old can not be replaced by new as is
+	note string
+}
+
+const slurmV5Note = "the settings of the V5 modules differ, see their README"
+
+// deprecatedModules are the deprecated module sources, relative to the root
+// of the toolkit
+var deprecatedModules = []deprecation{
+	{old: "community/modules/scheduler/cloud-batch-job", new: "modules/scheduler/batch-job-template", rewrite: true},
+	{old: "community/modules/scheduler/cloud-batch-login-node", new: "modules/scheduler/batch-login-node", rewrite: true},
+	{old: "community/modules/scheduler/SchedMD-slurm-on-gcp-controller",
+		new: "community/modules/scheduler/schedmd-slurm-gcp-v5-controller", usable: true, note: slurmV5Note},
+	{old: "community/modules/scheduler/SchedMD-slurm-on-gcp-login-node",
+		new: "community/modules/scheduler/schedmd-slurm-gcp-v5-login", usable: true, note: slurmV5Note},
+	{old: "community/modules/compute/SchedMD-slurm-on-gcp-partition",
+		new: "community/modules/compute/schedmd-slurm-gcp-v5-partition", usable: true,
+		note: slurmV5Note + "; machines are configured with schedmd-slurm-gcp-v5-node-group"},
+}
+
+// deprecatedSettings are the deprecated settings of modules
+var deprecatedSettings = []deprecation{
+	{module: "modules/network/vpc", old: "subnetwork_size", new: "default_primary_subnetwork_size", rewrite: true},
+	{module: "modules/network/vpc", old: "primary_subnetwork", new: "subnetworks",
+		note: "merge primary_subnetwork and additional_subnetworks into subnetworks"},
+	{module: "modules/network/vpc", old: "additional_subnetworks", new: "subnetworks",
+		note: "merge primary_subnetwork and additional_subnetworks into subnetworks"},
+	{module: "modules/scripts/startup-script", old: "prepend_ansible_installer", new: "install_ansible",
+		note: "set install_ansible to false to not install Ansible"},
+	{module: "modules/scheduler/batch-login-node", old: "job_filename", new: "job_data"},
+	{module: "modules/scheduler/batch-login-node", old: "job_id", new: "job_data"},
+	{module: "modules/scheduler/batch-login-node", old: "job_template_contents", new: "job_data"},
+	{module: "community/modules/project/service-account", old: "names", new: "name",
+		note: "create one service account per module"},
+	{module: "community/modules/project/service-account", old: "descriptions", new: "description",
+		note: "create one service account per module"},
+	{module: "community/modules/project/service-account", old: "prefix",
+		note: "the prefix is set by deployment_name"},
+}
+
+// embeddedSource returns the source of a module relative to the root of the
+// toolkit, for embedded and local sources
+func embeddedSource(source string) string {
+	return strings.Trim(source, "./")
+}
+
+// message describes the deprecation to the user of a module
+func (d deprecation) message(mod ModuleID) string {
+	what := "module " + d.old
+	if d.module != "" {
+		what = fmt.Sprintf("setting %s of %s", d.old, d.module)
+	}
+	msg := fmt.Sprintf("module %s: %s has been removed", mod, what)
+	if d.usable {
+		msg = fmt.Sprintf("module %s: %s is deprecated", mod, what)
+	}
+	if d.new != "" {
+		msg += ", use " + d.new + " instead"
+	}
+	if d.note != "" {
+		msg += "; " + d.note
+	}
+	if d.rewrite {
+		msg += "; run `ghpc migrate` to update the blueprint"
+	}
+	return msg
+}
+
+// checkDeprecations warns about the deprecated module sources and settings
+// used by the blueprint, and fails if any of them is removed
+func (dc DeploymentConfig) checkDeprecations() error {
+	removed := []string{}
+	check := func(d deprecation, mod ModuleID) {
+		msg := d.message(mod)
+		if !d.usable {
+			removed = append(removed, msg)
+			return
+		}
+		log.Printf("WARNING: %s", msg)
+		dc.report("deprecation", diagnostics.Warning, msg, "modules."+string(mod))
+	}
+	dc.Config.WalkModules(func(m *Module) error {
+		source := embeddedSource(m.Source)
+		for _, d := range deprecatedModules {
+			if d.old == source {
+				check(d, m.ID)
+			}
+		}
+		for _, d := range deprecatedSettings {
+			if d.module == source && m.Settings.Has(d.old) {
+				check(d, m.ID)
+			}
+		}
+		return nil
+	})
+	if len(removed) > 0 {
+		return fmt.Errorf("the blueprint uses removed modules or settings:\n%s", strings.Join(removed, "\n"))
+	}
+	return nil
+}
+
+// rewriteDeprecations replaces the deprecated module sources and settings of
+// a blueprint document that have a replacement to use as is, and returns the
+// descriptions of the replacements
+func rewriteDeprecations(root *yaml.Node) []string {
+	applied := []string{}
+	groups := mappingValue(root, "deployment_groups")
+	if groups == nil {
+		return applied
+	}
+	for _, g := range groups.Content {
+		mods := mappingValue(g, "modules")
+		if mods == nil {
+			continue
+		}
+		for _, m := range mods.Content {
+			src := mappingValue(m, "source")
+			if src == nil {
+				continue
+			}
+			for _, d := range deprecatedModules {
+				if d.rewrite && embeddedSource(src.Value) == d.old {
+					applied = append(applied, fmt.Sprintf("replaced module %s by %s", src.Value, d.new))
+					src.Value = d.new
+				}
+			}
+			settings := mappingValue(m, "settings")
+			if settings == nil {
+				continue
+			}
+			for _, d := range deprecatedSettings {
+				if d.rewrite && embeddedSource(src.Value) == d.module &&
+					mappingValue(settings, d.old) != nil && mappingValue(settings, d.new) == nil {
+					renameKey(settings, d.old, d.new)
+					applied = append(applied, fmt.Sprintf("renamed setting %s of %s to %s", d.old, src.Value, d.new))
+				}
+			}
+		}
+	}
+	return applied
+}
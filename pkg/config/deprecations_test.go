@@ -0,0 +1,104 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/diagnostics"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestCheckDeprecations(t *testing.T) {
+	vpc := Module{ID: "net", Source: "./modules/network/vpc", Settings: NewDict(map[string]cty.Value{
+		"subnetwork_size": cty.NumberIntVal(16),
+	})}
+	ctrl := Module{ID: "ctrl", Source: "community/modules/scheduler/SchedMD-slurm-on-gcp-controller"}
+	login := Module{ID: "login", Source: "modules/scheduler/batch-login-node", Settings: NewDict(map[string]cty.Value{
+		"job_id": cty.StringVal("job"),
+	})}
+	blueprint := func(mods ...Module) Blueprint {
+		return Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: mods}}}
+	}
+
+	// deprecated modules are reported as warnings
+	got := []diagnostics.Diagnostic{}
+	dc := DeploymentConfig{Config: blueprint(ctrl), Report: func(d diagnostics.Diagnostic) { got = append(got, d) }}
+	if err := dc.checkDeprecations(); err != nil {
+		t.Fatal(err)
+	}
+	want := []diagnostics.Diagnostic{{RuleID: "deprecation", Severity: diagnostics.Warning,
+		Message: "module ctrl: module community/modules/scheduler/SchedMD-slurm-on-gcp-controller is deprecated, " +
+			"use community/modules/scheduler/schedmd-slurm-gcp-v5-controller instead; " + slurmV5Note}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// removed settings fail, with their replacement
+	dc = DeploymentConfig{Config: blueprint(ctrl, vpc, login)}
+	err := dc.checkDeprecations()
+	for _, msg := range []string{
+		"setting job_id of modules/scheduler/batch-login-node has been removed",
+		"setting subnetwork_size of modules/network/vpc has been removed, use default_primary_subnetwork_size instead; " +
+			"run `ghpc migrate` to update the blueprint",
+	} {
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("got %v, want error containing %q", err, msg)
+		}
+	}
+	if strings.Contains(err.Error(), "SchedMD-slurm-on-gcp-controller") {
+		t.Errorf("got %v, want no error for deprecated module", err)
+	}
+}
+
+func TestMigrateFileDeprecations(t *testing.T) {
+	bp := `blueprint_name: deprecated
+deployment_groups:
+  - group: primary
+    modules:
+      - id: net
+        source: modules/network/vpc
+        settings:
+          subnetwork_size: 16
+      - id: job
+        source: ./community/modules/scheduler/cloud-batch-job
+`
+	path := filepath.Join(t.TempDir(), "bp.yaml")
+	if err := os.WriteFile(path, []byte(bp), 0600); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := MigrateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("got changes %v, want 2", applied)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer(
+		"subnetwork_size", "default_primary_subnetwork_size",
+		"./community/modules/scheduler/cloud-batch-job", "modules/scheduler/batch-job-template").Replace(bp)
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+
+	"hpc-toolkit/pkg/diagnostics"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// position is the line and column of a node of the blueprint file
+type position struct {
+	line   int
+	column int
+}
+
+// blueprintPositions returns the positions of the validators, modules and
+// deployment variables of a blueprint by "validators.<name>",
+// "modules.<id>" and "vars.<name>"; it is empty if the blueprint cannot be
+// parsed
+func blueprintPositions(b []byte) map[string]position {
+	pos := map[string]position{}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil || len(doc.Content) == 0 {
+		return pos
+	}
+	// migrating keeps the positions of the renamed keys
+	migrateNode(&doc)
+	// value returns the value of key in a mapping node
+	value := func(n *yaml.Node, key string) *yaml.Node {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				return n.Content[i+1]
+			}
+		}
+		return nil
+	}
+	at := func(n *yaml.Node) position { return position{n.Line, n.Column} }
+
+	root := doc.Content[0]
+	if vars := value(root, "vars"); vars != nil && vars.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(vars.Content); i += 2 {
+			pos["vars."+vars.Content[i].Value] = at(vars.Content[i])
+		}
+	}
+	if vs := value(root, "validators"); vs != nil {
+		for _, v := range vs.Content {
+			if name := value(v, "validator"); name != nil {
+				pos["validators."+name.Value] = at(v)
+			}
+		}
+	}
+	if groups := value(root, "deployment_groups"); groups != nil {
+		for _, g := range groups.Content {
+			mods := value(g, "modules")
+			if mods == nil {
+				continue
+			}
+			for _, m := range mods.Content {
+				if id := value(m, "id"); id != nil {
+					pos["modules."+id.Value] = at(m)
+				}
+			}
+		}
+	}
+	return pos
+}
+
+// report reports a diagnostic located at the first of the subjects, e.g.
+// "validators.<name>", found in the blueprint
+func (dc DeploymentConfig) report(ruleID string, severity string, message string, subjects ...string) {
+	if dc.Report == nil {
+		return
+	}
+	d := diagnostics.Diagnostic{RuleID: ruleID, Severity: severity, Message: message, File: dc.File}
+	for _, s := range subjects {
+		if p, ok := dc.positions[s]; ok {
+			d.Line, d.Column = p.line, p.column
+			break
+		}
+	}
+	dc.Report(d)
+}
+
+// reportValidator reports the result of a validator, with what it logged
+func (dc DeploymentConfig) reportValidator(v validatorConfig, level int, logged string, err error) {
+	if err == nil {
+		dc.report(v.Validator, diagnostics.Pass, "", validatorSubjects(v)...)
+		return
+	}
+	severity := diagnostics.Error
+	if level == ValidationWarning {
+		severity = diagnostics.Warning
+	}
+	msg := err.Error()
+	if logged != "" {
+		msg = logged + "\n" + msg
+	}
+	dc.report(v.Validator, severity, msg, validatorSubjects(v)...)
+}
+
+// validatorSubjects returns the subjects a diagnostic of the validator is
+// located at: its declaration, or the deployment variables of its inputs
+func validatorSubjects(v validatorConfig) []string {
+	subjects := []string{"validators." + v.Validator}
+	inputs := v.Inputs.Items()
+	keys := maps.Keys(inputs)
+	slices.Sort(keys)
+	for _, k := range keys {
+		if e, is := IsExpressionValue(inputs[k]); is {
+			for _, r := range e.References() {
+				if r.GlobalVar {
+					subjects = append(subjects, "vars."+r.Name)
+				}
+			}
+		}
+	}
+	return subjects
+}
+
+// captureLog runs f and returns what it logged, which is still written to
+// the log, when diagnostics are reported
+func (dc DeploymentConfig) captureLog(f func() error) (string, error) {
+	if dc.Report == nil {
+		return "", f()
+	}
+	prev, flags := log.Writer(), log.Flags()
+	var buf bytes.Buffer
+	log.SetOutput(io.MultiWriter(prev, &buf))
+	// messages are captured without timestamps, as validate logs them
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(prev)
+		log.SetFlags(flags)
+	}()
+	err := f()
+	return strings.TrimSpace(buf.String()), err
+}
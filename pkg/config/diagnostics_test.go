@@ -0,0 +1,104 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"hpc-toolkit/pkg/diagnostics"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const positionsBlueprint = `blueprint_name: bp
+vars:
+  project_id: p
+  zone: us-central1-a
+validators:
+- validator: test_module_not_used
+  skip: true
+deployment_groups:
+- group: primary
+  modules:
+  - id: network
+    source: modules/network/vpc
+  - source: modules/compute/vm-instance
+    id: vm
+`
+
+func TestBlueprintPositions(t *testing.T) {
+	want := map[string]position{
+		"vars.project_id":                 {3, 3},
+		"vars.zone":                       {4, 3},
+		"validators.test_module_not_used": {6, 3},
+		"modules.network":                 {11, 5},
+		"modules.vm":                      {13, 5},
+	}
+	if diff := cmp.Diff(want, blueprintPositions([]byte(positionsBlueprint)), cmp.AllowUnexported(position{})); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if got := blueprintPositions([]byte("[")); len(got) != 0 {
+		t.Errorf("want no positions for an invalid blueprint, got %v", got)
+	}
+}
+
+func TestReportValidators(t *testing.T) {
+	dc := DeploymentConfig{
+		Config: Blueprint{
+			Vars: NewDict(map[string]cty.Value{"zone": cty.StringVal("us-central1-a")}),
+			Validators: []validatorConfig{
+				{Validator: "zone_set", Expression: `vars.zone != ""`},
+				{Validator: "zone_in_europe", Expression: `vars.zone.startsWith("europe-")`, Message: "not in Europe", Level: "WARNING"},
+				{Validator: "unknown_validator"},
+			},
+			ValidationLevel: ValidationWarning,
+		},
+		File: "bp.yaml",
+		positions: map[string]position{
+			"validators.zone_in_europe": {7, 3},
+		},
+	}
+	var got []diagnostics.Diagnostic
+	dc.Report = func(d diagnostics.Diagnostic) { got = append(got, d) }
+	if err := dc.executeValidators(); err == nil {
+		t.Fatal("want an error for the unknown validator")
+	}
+	want := []diagnostics.Diagnostic{
+		{RuleID: "zone_set", Severity: diagnostics.Pass, File: "bp.yaml"},
+		{RuleID: "zone_in_europe", Severity: diagnostics.Warning, File: "bp.yaml", Line: 7, Column: 3,
+			Message: "not in Europe\n" + `vars.zone.startsWith("europe-") is false` + "\nvalidator zone_in_europe failed"},
+		{RuleID: "unknown_validator", Severity: diagnostics.Error, File: "bp.yaml",
+			Message: "unknown_validator is not an implemented validator"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidatorSubjects(t *testing.T) {
+	v := validatorConfig{
+		Validator: testZoneInRegionName.String(),
+		Inputs: NewDict(map[string]cty.Value{
+			"zone":       GlobalRef("zone").AsExpression().AsValue(),
+			"project_id": GlobalRef("project_id").AsExpression().AsValue(),
+			"region":     cty.StringVal("us-central1"),
+		}),
+	}
+	want := []string{"validators.test_zone_in_region", "vars.project_id", "vars.zone"}
+	if diff := cmp.Diff(want, validatorSubjects(v)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
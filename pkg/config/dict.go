@@ -90,20 +90,35 @@ type YamlValue struct {
 	v cty.Value
 }
 
-// Unwrap returns wrapped cty.Value.
+// Unwrap returns wrapped cty.Value, null if the YAML value is null, as
+// YAML nulls are not passed to UnmarshalYAML
 func (y YamlValue) Unwrap() cty.Value {
+	if y.v == cty.NilVal {
+		return cty.NullVal(cty.DynamicPseudoType)
+	}
 	return y.v
 }
 
+// MarshalYAML implements custom YAML marshaling.
+func (y YamlValue) MarshalYAML() (interface{}, error) {
+	g, err := NewDict(map[string]cty.Value{"v": y.v}).MarshalYAML()
+	if err != nil {
+		return nil, err
+	}
+	return g.(map[string]interface{})["v"], nil
+}
+
 // UnmarshalYAML implements custom YAML unmarshaling.
 func (y *YamlValue) UnmarshalYAML(n *yaml.Node) error {
 	var err error
-	switch n.Kind {
-	case yaml.ScalarNode:
+	switch {
+	case n.Tag == RawHCLTag:
+		err = y.unmarshalRaw(n)
+	case n.Kind == yaml.ScalarNode:
 		err = y.unmarshalScalar(n)
-	case yaml.MappingNode:
+	case n.Kind == yaml.MappingNode:
 		err = y.unmarshalObject(n)
-	case yaml.SequenceNode:
+	case n.Kind == yaml.SequenceNode:
 		err = y.unmarshalTuple(n)
 	default:
 		err = fmt.Errorf("line %d: cannot decode node with unknown kind %d", n.Line, n.Kind)
@@ -130,6 +145,18 @@ func (y *YamlValue) unmarshalScalar(n *yaml.Node) error {
 			return err
 		}
 		y.v = e.AsValue()
+	} else if y.v.Type() == cty.String && isExecString(y.v.AsString()) { // exec, evaluated at expand time
+		if _, err := extractExecCommand(y.v.AsString()); err != nil {
+			return err
+		}
+	} else if y.v.Type() == cty.String && isSecretString(y.v.AsString()) { // secret, read at deploy time
+		if _, err := parseSecretRef(y.v.AsString()); err != nil {
+			return err
+		}
+	} else if y.v.Type() == cty.String && isRemoteOutputString(y.v.AsString()) { // remote output, read at deploy time
+		if _, err := parseRemoteOutputRef(y.v.AsString()); err != nil {
+			return err
+		}
 	} else if y.v.Type() == cty.String && hasVariable(y.v.AsString()) { // "simple" variable
 		e, err := SimpleVarToExpression(y.v.AsString())
 		if err != nil {
@@ -140,6 +167,19 @@ func (y *YamlValue) unmarshalScalar(n *yaml.Node) error {
 	return nil
 }
 
+// unmarshalRaw unmarshals a string tagged !hcl as a raw HCL expression
+func (y *YamlValue) unmarshalRaw(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: %s can only tag a string", n.Line, RawHCLTag)
+	}
+	e, err := ParseRawExpression(n.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid HCL expression: %w", n.Line, err)
+	}
+	y.v = e.AsValue()
+	return nil
+}
+
 func (y *YamlValue) unmarshalObject(n *yaml.Node) error {
 	var my map[string]YamlValue
 	if err := n.Decode(&my); err != nil {
@@ -147,20 +187,21 @@ func (y *YamlValue) unmarshalObject(n *yaml.Node) error {
 	}
 	mv := map[string]cty.Value{}
 	for k, y := range my {
-		mv[k] = y.v
+		mv[k] = y.Unwrap()
 	}
 	y.v = cty.ObjectVal(mv)
 	return nil
 }
 
 func (y *YamlValue) unmarshalTuple(n *yaml.Node) error {
-	var ly []YamlValue
-	if err := n.Decode(&ly); err != nil {
-		return err
-	}
+	// elements are decoded one by one, as decoding a slice drops nulls
 	lv := []cty.Value{}
-	for _, y := range ly {
-		lv = append(lv, y.v)
+	for _, c := range n.Content {
+		var e YamlValue
+		if err := c.Decode(&e); err != nil {
+			return err
+		}
+		lv = append(lv, e.Unwrap())
 	}
 	y.v = cty.TupleVal(lv)
 	return nil
@@ -173,7 +214,7 @@ func (d *Dict) UnmarshalYAML(n *yaml.Node) error {
 		return err
 	}
 	for k, y := range m {
-		d.Set(k, y.v)
+		d.Set(k, y.Unwrap())
 	}
 	return nil
 }
@@ -120,6 +120,71 @@ m2:
 	}
 }
 
+func TestYAMLDecodeRaw(t *testing.T) {
+	yml := `
+inline: !hcl concat(var.tags, [module.net.tag])
+heredoc: !hcl |
+  <<-EOT
+    echo ${var.name}
+  EOT
+`
+	var got Dict
+	if err := yaml.Unmarshal([]byte(yml), &got); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	e, is := IsExpressionValue(got.Get("inline"))
+	if _, raw := e.(RawExpression); !is || !raw {
+		t.Fatalf("got %#v, want raw expression", got.Get("inline"))
+	}
+	if diff := cmp.Diff([]Reference{GlobalRef("tags"), ModuleRef("net", "tag")}, e.References()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	e, _ = IsExpressionValue(got.Get("heredoc"))
+	if diff := cmp.Diff("<<-EOT\n  echo ${var.name}\nEOT", string(e.Tokenize().Bytes())); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	for _, bad := range []string{"a: !hcl [1, 2]", "a: !hcl concat(", "a: !hcl local.b"} {
+		var d Dict
+		if err := yaml.Unmarshal([]byte(bad), &d); err == nil {
+			t.Errorf("%q: got no error", bad)
+		}
+	}
+}
+
+func TestYAMLDecodeNull(t *testing.T) {
+	yml := `
+n1: null
+n2: ~
+m1:
+  f1:
+l1: [1, null]
+`
+	null := cty.NullVal(cty.DynamicPseudoType)
+	want := Dict{}
+	want.
+		Set("n1", null).
+		Set("n2", null).
+		Set("m1", cty.ObjectVal(map[string]cty.Value{"f1": null})).
+		Set("l1", cty.TupleVal([]cty.Value{cty.NumberIntVal(1), null}))
+	var got Dict
+	if err := yaml.Unmarshal([]byte(yml), &got); err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if diff := cmp.Diff(want.Items(), got.Items(), ctydebug.CmpOptions); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	out, err := yaml.Marshal(got)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	wantOut := "l1:\n    - 1\n    - null\nm1:\n    f1: null\nn1: null\nn2: null\n"
+	if diff := cmp.Diff(wantOut, string(out)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
 func TestMarshalYAML(t *testing.T) {
 	d := Dict{}
 	d.
@@ -0,0 +1,209 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyJson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Kinds of the changes between blueprints
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is a difference between two blueprints
+type Change struct {
+	// Kind is Added, Removed or Changed
+	Kind string
+	// Path of the changed element, e.g. modules.network.settings.region
+	Path string
+	// Old and New are the values before and after the change, empty if the
+	// element was added or removed
+	Old string
+	New string
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", c.Path, c.New)
+	case Removed:
+		return fmt.Sprintf("- %s: %s", c.Path, c.Old)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", c.Path, c.Old, c.New)
+	}
+}
+
+// comparableValue replaces the expressions nested in v by their text, so that
+// values read from an expanded blueprint compare equal to those expanded
+func comparableValue(v cty.Value) cty.Value {
+	r, _ := cty.Transform(v, func(_ cty.Path, v cty.Value) (cty.Value, error) {
+		if e, is := IsExpressionValue(v); is {
+			return e.makeYamlExpressionValue(), nil
+		}
+		return v, nil
+	})
+	return r
+}
+
+// displayValue renders a value as JSON, with its expressions as text
+func displayValue(v cty.Value) string {
+	b, err := ctyJson.SimpleJSONValue{Value: comparableValue(v)}.MarshalJSON()
+	if err != nil {
+		return v.GoString()
+	}
+	return string(b)
+}
+
+func backendValue(b TerraformBackend) cty.Value {
+	return cty.ObjectVal(map[string]cty.Value{
+		"type":          cty.StringVal(backendType(b)),
+		"configuration": b.Configuration.AsObject(),
+	})
+}
+
+// differ collects the changes between two blueprints
+type differ struct {
+	changes []Change
+}
+
+func (d *differ) add(kind string, path string, old string, new string) {
+	d.changes = append(d.changes, Change{Kind: kind, Path: path, Old: old, New: new})
+}
+
+// values records the change of a value, if any; sensitive values are
+// redacted
+func (d *differ) values(path string, old cty.Value, new cty.Value, sensitive bool) {
+	if comparableValue(old).RawEquals(comparableValue(new)) {
+		return
+	}
+	d.add(Changed, path, d.render(old, sensitive), d.render(new, sensitive))
+}
+
+// dicts records the items added to, removed from and changed in a dict
+func (d *differ) dicts(path string, old Dict, new Dict, sensitive func(string) bool) {
+	oi, ni := old.Items(), new.Items()
+	keys := maps.Keys(oi)
+	for k := range ni {
+		if _, ok := oi[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		ov, inOld := oi[k]
+		nv, inNew := ni[k]
+		p := path + "." + k
+		switch {
+		case !inOld:
+			d.add(Added, p, "", d.render(nv, sensitive(k)))
+		case !inNew:
+			d.add(Removed, p, d.render(ov, sensitive(k)), "")
+		default:
+			d.values(p, ov, nv, sensitive(k))
+		}
+	}
+}
+
+// render displays a value, redacted if sensitive unless it is an expression
+func (d *differ) render(v cty.Value, sensitive bool) string {
+	if _, is := IsExpressionValue(v); sensitive && !is {
+		return RedactedValue
+	}
+	return displayValue(v)
+}
+
+func (d *differ) backends(path string, old TerraformBackend, new TerraformBackend) {
+	d.values(path, backendValue(old), backendValue(new), false)
+}
+
+func (d *differ) modules(prev Blueprint, bp Blueprint) {
+	prevGroups := map[ModuleID]GroupName{}
+	prev.WalkModules(func(m *Module) error {
+		g, _ := prev.ModuleGroup(m.ID)
+		prevGroups[m.ID] = g.Name
+		return nil
+	})
+	for _, g := range bp.DeploymentGroups {
+		for _, m := range g.Modules {
+			p := "modules." + string(m.ID)
+			pm, err := prev.Module(m.ID)
+			if err != nil {
+				d.add(Added, p, "", m.Source)
+				continue
+			}
+			if pg := prevGroups[m.ID]; pg != g.Name {
+				d.add(Changed, p+".group", string(pg), string(g.Name))
+			}
+			if pm.Source != m.Source {
+				d.add(Changed, p+".source", pm.Source, m.Source)
+			}
+			if pm.Version != m.Version {
+				d.add(Changed, p+".version", pm.Version, m.Version)
+			}
+			sensitive := func(s string) bool {
+				return slices.Contains(m.SensitiveSettings, s) || slices.Contains(pm.SensitiveSettings, s)
+			}
+			d.dicts(p+".settings", pm.Settings, m.Settings, sensitive)
+		}
+	}
+	prev.WalkModules(func(m *Module) error {
+		if _, err := bp.Module(m.ID); err != nil {
+			d.add(Removed, "modules."+string(m.ID), m.Source, "")
+		}
+		return nil
+	})
+}
+
+func (d *differ) groups(prev Blueprint, bp Blueprint) {
+	for _, g := range bp.DeploymentGroups {
+		p := "deployment_groups." + string(g.Name)
+		pg, err := prev.Group(g.Name)
+		if err != nil {
+			d.add(Added, p, "", g.Kind.String())
+			continue
+		}
+		if pg.Kind != g.Kind {
+			d.add(Changed, p+".kind", pg.Kind.String(), g.Kind.String())
+		}
+		d.backends(p+".terraform_backend", pg.TerraformBackend, g.TerraformBackend)
+	}
+	for _, g := range prev.DeploymentGroups {
+		if _, err := bp.Group(g.Name); err != nil {
+			d.add(Removed, "deployment_groups."+string(g.Name), g.Kind.String(), "")
+		}
+	}
+}
+
+// Diff compares the blueprint with a previous version of it and returns the
+// deployment variables, deployment groups, modules and module settings added,
+// removed and changed, and the changes to Terraform backends. The values of
+// sensitive variables and settings are redacted.
+func (bp Blueprint) Diff(prev Blueprint) []Change {
+	d := differ{changes: []Change{}}
+	sensitive := append(bp.SensitiveVars(), prev.SensitiveVars()...)
+	d.dicts("vars", prev.Vars, bp.Vars, func(n string) bool { return slices.Contains(sensitive, n) })
+	d.backends("terraform_backend_defaults", prev.TerraformBackendDefaults, bp.TerraformBackendDefaults)
+	d.groups(prev, bp)
+	d.modules(prev, bp)
+	return d.changes
+}
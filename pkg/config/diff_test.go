@@ -0,0 +1,150 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestDiff(t *testing.T) {
+	blueprint := func(machineType string, extra bool, bucket string) Blueprint {
+		vm := Module{ID: "vm", Source: "modules/compute/vm-instance", Kind: TerraformKind,
+			SensitiveSettings: []string{"password"},
+			Settings: NewDict(map[string]cty.Value{
+				"machine_type": cty.StringVal(machineType),
+				"network":      ModuleRef("net", "network_self_link").AsExpression().AsValue(),
+				"password":     cty.StringVal(machineType),
+			})}
+		mods := []Module{{ID: "net", Source: "modules/network/vpc", Kind: TerraformKind}, vm}
+		if extra {
+			mods = append(mods, Module{ID: "fs", Source: "modules/file-system/filestore", Kind: TerraformKind})
+		}
+		bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Kind: TerraformKind, Modules: mods}}}
+		if bucket != "" {
+			bp.TerraformBackendDefaults = TerraformBackend{Type: "gcs", Configuration: NewDict(map[string]cty.Value{
+				"bucket": cty.StringVal(bucket),
+			})}
+		}
+		return bp
+	}
+	prev := blueprint("n2-standard-2", false, "")
+
+	type test struct {
+		name string
+		bp   Blueprint
+		want []Change
+	}
+	tests := []test{
+		{"unchanged", blueprint("n2-standard-2", false, ""), []Change{}},
+		{"setting changed", blueprint("n2-standard-4", false, ""), []Change{
+			{Kind: Changed, Path: "modules.vm.settings.machine_type", Old: `"n2-standard-2"`, New: `"n2-standard-4"`},
+			{Kind: Changed, Path: "modules.vm.settings.password", Old: RedactedValue, New: RedactedValue},
+		}},
+		{"module added", blueprint("n2-standard-2", true, ""), []Change{
+			{Kind: Added, Path: "modules.fs", New: "modules/file-system/filestore"},
+		}},
+		{"backend changed", blueprint("n2-standard-2", false, "state"), []Change{
+			{Kind: Changed, Path: "terraform_backend_defaults",
+				Old: `{"configuration":{},"type":"local"}`,
+				New: `{"configuration":{"bucket":"state"},"type":"gcs"}`},
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, tc.bp.Diff(prev)); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// removals are the reverse of additions
+	got := prev.Diff(blueprint("n2-standard-2", true, ""))
+	want := []Change{{Kind: Removed, Path: "modules.fs", Old: "modules/file-system/filestore"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiffVars(t *testing.T) {
+	blueprint := func(vars map[string]cty.Value) Blueprint {
+		return Blueprint{
+			Vars:                NewDict(vars),
+			DeploymentVariables: map[string]VariableDeclaration{"token": {Sensitive: true}},
+		}
+	}
+	prev := blueprint(map[string]cty.Value{
+		"region": cty.StringVal("us-central1"),
+		"zone":   cty.StringVal("us-central1-a"),
+		"token":  cty.StringVal("secret"),
+	})
+	bp := blueprint(map[string]cty.Value{
+		"region":     cty.StringVal("us-east1"),
+		"token":      cty.StringVal("other"),
+		"node_count": cty.NumberIntVal(4),
+	})
+	want := []Change{
+		{Kind: Added, Path: "vars.node_count", New: "4"},
+		{Kind: Changed, Path: "vars.region", Old: `"us-central1"`, New: `"us-east1"`},
+		{Kind: Changed, Path: "vars.token", Old: RedactedValue, New: RedactedValue},
+		{Kind: Removed, Path: "vars.zone", Old: `"us-central1-a"`},
+	}
+	if diff := cmp.Diff(want, bp.Diff(prev)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiffExported(t *testing.T) {
+	// a blueprint does not differ from its expanded form
+	bp := Blueprint{
+		BlueprintName: "diff",
+		Vars:          NewDict(map[string]cty.Value{"region": cty.StringVal("us-central1")}),
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Kind: TerraformKind, Modules: []Module{{
+			ID: "net", Source: "modules/network/vpc", Kind: TerraformKind,
+			Settings: NewDict(map[string]cty.Value{
+				"region": GlobalRef("region").AsExpression().AsValue(),
+				"labels": cty.ObjectVal(map[string]cty.Value{"a": cty.StringVal("b")}),
+			}),
+		}}}},
+	}
+	y, err := bp.CanonicalYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc, err := NewDeploymentConfigFromBytes(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bp.Diff(dc.Config); len(got) != 0 {
+		t.Errorf("got unexpected changes %v", got)
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	for _, tc := range []struct {
+		c    Change
+		want string
+	}{
+		{Change{Kind: Added, Path: "modules.fs", New: "fs"}, "+ modules.fs: fs"},
+		{Change{Kind: Removed, Path: "modules.fs", Old: "fs"}, "- modules.fs: fs"},
+		{Change{Kind: Changed, Path: "modules.fs.version", Old: "1", New: "2"}, "~ modules.fs.version: 1 -> 2"},
+	} {
+		if got := tc.c.String(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Matches strings of form `$(exec(...))`, the arguments are validated separately
+var execExp *regexp.Regexp = regexp.MustCompile(`^\$\(\s*exec\((.*)\)\s*\)$`)
+
+// isExecString checks if the entire string is an exec expression
+func isExecString(s string) bool {
+	return execExp.MatchString(s)
+}
+
+// extractExecCommand takes `$(exec("cmd"))` and returns `cmd`
+func extractExecCommand(s string) (string, error) {
+	contents := execExp.FindStringSubmatch(s)
+	if len(contents) != 2 {
+		return "", fmt.Errorf("%#v is not an exec expression", s)
+	}
+	e, diag := hclsyntax.ParseExpression([]byte(contents[1]), "", hcl.Pos{})
+	if diag.HasErrors() {
+		return "", fmt.Errorf("%s %#v: %s", errorMessages["invalidExec"], s, diag.Error())
+	}
+	if len(e.Variables()) > 0 {
+		return "", fmt.Errorf("%s %#v: command must be a string literal", errorMessages["invalidExec"], s)
+	}
+	v, diag := e.Value(nil)
+	if diag.HasErrors() || v.Type() != cty.String {
+		return "", fmt.Errorf("%s %#v: command must be a string literal", errorMessages["invalidExec"], s)
+	}
+	cmd := strings.TrimSpace(v.AsString())
+	if cmd == "" {
+		return "", fmt.Errorf("%s %#v: command is empty", errorMessages["invalidExec"], s)
+	}
+	return cmd, nil
+}
+
+// runExecCommand runs the command in a shell and returns its trimmed stdout
+func runExecCommand(command string) (string, error) {
+	out, err := exec.Command("sh", "-c", command).Output()
+	if err != nil {
+		var stderr string
+		if ee, ok := err.(*exec.ExitError); ok {
+			stderr = strings.TrimSpace(string(ee.Stderr))
+		}
+		return "", fmt.Errorf("command %#v failed: %v %s", command, err, stderr)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// evalExecValues replaces every `$(exec("cmd"))` string in the value with
+// output of the command. Errors if any exec is found and allowExec is false.
+func evalExecValues(v cty.Value, allowExec bool) (cty.Value, error) {
+	return cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		if v.IsMarked() || v.Type() != cty.String || v.IsNull() || !v.IsKnown() || !isExecString(v.AsString()) {
+			return v, nil
+		}
+		if !allowExec {
+			return cty.NilVal, fmt.Errorf("%s: %#v", errorMessages["execDisabled"], v.AsString())
+		}
+		cmd, err := extractExecCommand(v.AsString())
+		if err != nil {
+			return cty.NilVal, err
+		}
+		out, err := runExecCommand(cmd)
+		if err != nil {
+			return cty.NilVal, err
+		}
+		return cty.StringVal(out), nil
+	})
+}
+
+// applyExecSettings evaluates exec expressions in deployment variables and
+// module settings, replacing them by the output of the command.
+func (dc *DeploymentConfig) applyExecSettings() error {
+	vars, err := evalExecValues(dc.Config.Vars.AsObject(), dc.AllowExec)
+	if err != nil {
+		return fmt.Errorf("deployment variables: %w", err)
+	}
+	dc.Config.Vars = NewDict(vars.AsValueMap())
+
+	return dc.Config.WalkModules(func(m *Module) error {
+		s, err := evalExecValues(m.Settings.AsObject(), dc.AllowExec)
+		if err != nil {
+			return fmt.Errorf("module %s: %w", m.ID, err)
+		}
+		m.Settings = NewDict(s.AsValueMap())
+		return nil
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExtractExecCommand(t *testing.T) {
+	type test struct {
+		input string
+		want  string
+		err   bool
+	}
+	tests := []test{
+		{`$(exec("git rev-parse --short HEAD"))`, "git rev-parse --short HEAD", false},
+		{`$(exec("echo \"a b\""))`, `echo "a b"`, false},
+		{`$(exec(""))`, "", true},
+		{`$(exec(vars.cmd))`, "", true},
+		{`$(exec(42))`, "", true},
+		{`$(exec("a", "b"))`, "", true},
+		{`$(vars.zone)`, "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := extractExecCommand(tc.input)
+			if tc.err != (err != nil) {
+				t.Errorf("got unexpected error: %s", err)
+			}
+			if err == nil && got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplyExecSettings(t *testing.T) {
+	var y YamlValue
+	if err := yaml.Unmarshal([]byte(`$(exec("echo hello"))`), &y); err != nil {
+		t.Fatal(err)
+	}
+	exec := y.Unwrap()
+	dc := DeploymentConfig{Config: Blueprint{
+		Vars: NewDict(map[string]cty.Value{"build": exec}),
+		DeploymentGroups: []DeploymentGroup{{Modules: []Module{{
+			ID: "image",
+			Settings: NewDict(map[string]cty.Value{
+				"labels": cty.ObjectVal(map[string]cty.Value{"build": exec}),
+			}),
+		}}}},
+	}}
+
+	if err := dc.applyExecSettings(); err == nil {
+		t.Error("expected error when exec is not allowed")
+	}
+
+	dc.AllowExec = true
+	if err := dc.applyExecSettings(); err != nil {
+		t.Fatal(err)
+	}
+	hello := cty.StringVal("hello")
+	if got := dc.Config.Vars.Get("build"); !got.RawEquals(hello) {
+		t.Errorf("got %#v, want %#v", got, hello)
+	}
+	labels := dc.Config.DeploymentGroups[0].Modules[0].Settings.Get("labels")
+	if got := labels.GetAttr("build"); !got.RawEquals(hello) {
+		t.Errorf("got %#v, want %#v", got, hello)
+	}
+}
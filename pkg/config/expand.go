@@ -18,10 +18,12 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
 	"strings"
 
 	"path/filepath"
 
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/modulereader"
 
 	"github.com/zclconf/go-cty/cty"
@@ -30,9 +32,10 @@ import (
 )
 
 const (
-	blueprintLabel  string = "ghpc_blueprint"
-	deploymentLabel string = "ghpc_deployment"
-	roleLabel       string = "ghpc_role"
+	// names of the labels ghpc adds, following the prefix of ToolkitLabels
+	blueprintLabel  string = "blueprint"
+	deploymentLabel string = "deployment"
+	roleLabel       string = "role"
 )
 
 var (
@@ -45,53 +48,101 @@ var (
 
 // expand expands variables and strings in the yaml config. Used directly by
 // ExpandConfig for the create and expand commands.
-func (dc *DeploymentConfig) expand() {
+func (dc *DeploymentConfig) expand() error {
 	if err := dc.addMetadataToModules(); err != nil {
 		log.Printf("could not determine required APIs: %v", err)
 	}
 
+	if err := dc.Config.checkProviderRequirements(); err != nil {
+		return errcode.New(errcode.ConfigError,
+			fmt.Errorf("incompatible provider requirements: %w", err))
+	}
+
+	if err := dc.Config.setBootstrapApis(); err != nil {
+		return errcode.New(errcode.ConfigError,
+			fmt.Errorf("failed to set APIs of the bootstrap project: %w", err))
+	}
+
 	if err := dc.expandBackends(); err != nil {
-		log.Fatalf("failed to apply default backend to deployment groups: %v", err)
+		return errcode.New(errcode.ConfigError,
+			fmt.Errorf("failed to apply default backend to deployment groups: %w", err))
 	}
 
 	if err := dc.addDefaultValidators(); err != nil {
-		log.Fatalf(
-			"failed to update validators when expanding the config: %v", err)
+		return errcode.New(errcode.ConfigError,
+			fmt.Errorf("failed to update validators when expanding the config: %w", err))
 	}
 
 	if err := dc.combineLabels(); err != nil {
-		log.Fatalf(
-			"failed to update module labels when expanding the config: %v", err)
+		return errcode.New(errcode.ConfigError,
+			fmt.Errorf("failed to update module labels when expanding the config: %w", err))
 	}
 
 	if err := dc.applyUseModules(); err != nil {
-		log.Fatalf(
-			"failed to apply \"use\" modules when expanding the config: %v", err)
+		return errcode.New(errcode.ConfigError,
+			fmt.Errorf("failed to apply \"use\" modules when expanding the config: %w", err))
 	}
 
 	if err := dc.applyGlobalVariables(); err != nil {
-		log.Fatalf(
-			"failed to apply deployment variables in modules when expanding the config: %v",
-			err)
+		return errcode.New(errcode.ConfigError,
+			fmt.Errorf("failed to apply deployment variables in modules when expanding the config: %w", err))
+	}
+
+	if err := dc.Config.checkOutputTypes(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := dc.Config.checkSecretReferences(); err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+
+	if err := dc.Config.wireBootstrapProject(); err != nil {
+		return errcode.New(errcode.ConfigError,
+			fmt.Errorf("failed to wire the bootstrap project into deployment groups: %w", err))
 	}
 
+	dc.Config.applyMetadataWrapSettings()
 	dc.Config.populateOutputs()
+	dc.Config.warnUnusedOutputs()
+	return nil
+}
+
+// applyMetadataWrapSettings wraps the settings of Terraform modules with the
+// functions declared in the metadata of the modules, unless already wrapped
+// or set to null
+func (bp *Blueprint) applyMetadataWrapSettings() {
+	bp.WalkModules(func(mod *Module) error {
+		if mod.Kind != TerraformKind {
+			return nil
+		}
+		for setting, fn := range mod.InfoOrDie().WrapSettings {
+			if _, wrapped := mod.WrapSettings[setting]; !wrapped && mod.Settings.Has(setting) && !mod.Settings.Get(setting).IsNull() {
+				mod.wrapSetting(setting, fn)
+			}
+		}
+		return nil
+	})
 }
 
 func (dc *DeploymentConfig) addMetadataToModules() error {
 	return dc.Config.WalkModules(func(mod *Module) error {
-		if mod.RequiredApis != nil {
+		if mod.HealthProbes == nil && mod.Kind == TerraformKind {
+			mod.HealthProbes = mod.InfoOrDie().HealthProbes
+		}
+		// APIs are enabled per project, which only Google Cloud has
+		project := dc.Config.TargetCloud().ProjectVar
+		if mod.RequiredApis != nil || project == "" {
 			return nil
 		}
-		if dc.Config.Vars.Get("project_id").Type() != cty.String {
-			return fmt.Errorf("global variable project_id must be defined")
+		if dc.Config.Vars.Get(project).Type() != cty.String {
+			return fmt.Errorf("global variable %s must be defined", project)
 		}
 		requiredAPIs := mod.InfoOrDie().RequiredApis
 		if requiredAPIs == nil {
 			requiredAPIs = []string{}
 		}
 		mod.RequiredApis = map[string][]string{
-			"$(vars.project_id)": requiredAPIs,
+			fmt.Sprintf("$(vars.%s)", project): requiredAPIs,
 		}
 		return nil
 	})
@@ -103,7 +154,10 @@ func (dc *DeploymentConfig) expandBackends() error {
 	// 2. If top-level TerraformBackendDefaults is defined, insert that
 	//    backend into resource groups which have no explicit
 	//    TerraformBackend
-	// 3. In all cases, add a prefix for GCS backends if one is not defined
+	// 3. In all cases, add a prefix for GCS backends and a key for S3 and
+	//    azurerm backends if one is not defined
+	// 4. GCS backends of groups that impersonate a service account access
+	//    the state as that service account
 	blueprint := &dc.Config
 	defaults := blueprint.TerraformBackendDefaults
 	if defaults.Type != "" {
@@ -125,6 +179,21 @@ func (dc *DeploymentConfig) expandBackends() error {
 				prefix += "/" + string(grp.Name)
 				be.Configuration.Set("prefix", cty.StringVal(prefix))
 			}
+			if (be.Type == "s3" || be.Type == "azurerm") && !be.Configuration.Has("key") {
+				key := blueprint.BlueprintName
+				if deployment, err := blueprint.DeploymentName(); err == nil {
+					key += "/" + deployment
+				}
+				key += "/" + string(grp.Name) + "/terraform.tfstate"
+				be.Configuration.Set("key", cty.StringVal(key))
+			}
+		}
+	}
+	for i := range blueprint.DeploymentGroups {
+		grp := &blueprint.DeploymentGroups[i]
+		be := &grp.TerraformBackend
+		if sa := grp.ImpersonateServiceAccount; sa != "" && be.Type == "gcs" && !be.Configuration.Has(ImpersonateSetting) {
+			be.Configuration.Set(ImpersonateSetting, cty.StringVal(sa))
 		}
 	}
 	return nil
@@ -143,8 +212,7 @@ func getModuleInputMap(inputs []modulereader.VarInfo) map[string]string {
 func (mod *Module) addListValue(settingName string, value cty.Value) error {
 	var cur []cty.Value
 	if !mod.Settings.Has(settingName) {
-		mod.createWrapSettingsWith()
-		mod.WrapSettingsWith[settingName] = []string{"flatten([", "])"}
+		mod.wrapSetting(settingName, "flatten")
 		cur = []cty.Value{}
 	} else {
 		v := mod.Settings.Get(settingName)
@@ -158,29 +226,87 @@ func (mod *Module) addListValue(settingName string, value cty.Value) error {
 	return nil
 }
 
+// isListType returns whether a module input of the type is merged from the
+// outputs of used modules: lists, sets and tuples
+func isListType(inputType string) bool {
+	for _, t := range []string{"list", "set", "tuple"} {
+		if strings.HasPrefix(inputType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeListValue appends the output of a used module to a list setting set
+// in the blueprint, flattening both, unless the setting already references
+// the output or is set to null
+func (mod *Module) mergeListValue(settingName string, value cty.Value, ref Reference) error {
+	cur := mod.Settings.Get(settingName)
+	if cur.IsNull() || referencesOutput(cur, ref) {
+		return nil
+	}
+	switch fn, wrapped := mod.WrapSettings[settingName]; {
+	case !wrapped:
+		mod.wrapSetting(settingName, "flatten")
+		mod.Settings.Set(settingName, cty.TupleVal([]cty.Value{cur}))
+	case fn != "flatten":
+		return fmt.Errorf("module %s: cannot merge the output %s into setting %s, which is wrapped with %s",
+			mod.ID, ref.Name, settingName, fn)
+	}
+	return mod.addListValue(settingName, value)
+}
+
+// referencesOutput returns whether a value references the output
+func referencesOutput(v cty.Value, ref Reference) bool {
+	found := false
+	cty.Walk(v, func(p cty.Path, v cty.Value) (bool, error) {
+		if e, is := IsExpressionValue(v); is && slices.Contains(e.References(), ref) {
+			found = true
+		}
+		return !found, nil
+	})
+	return found
+}
+
 // useModule matches input variables in a "using" module to output values
 // from a "used" module. It may be used iteratively to successively apply used
 // modules in order of precedence. New input variables are added to the using
 // module as Toolkit variable references (in same format as a blueprint). If
 // the input variable already has a setting, it is ignored, unless the value is
-// a list, in which case output values are appended and flattened using HCL.
+// a list, set or tuple added by a previous call, in which case output values
+// are appended and flattened using HCL.
 //
 //	mod: "using" module as defined above
 //	useMod: "used" module as defined above
-//	settingsToIgnore: a list of module settings not to modify for any reason;
+//	mapping: the inputs the outputs of useMod are set as, by output name
+//	merge: whether outputs are merged into settingsToIgnore of list type
+//	settingsToIgnore: a list of module settings not to modify, unless merged;
 //	 typical usage will be to leave explicit blueprint settings unmodified
 func useModule(
 	mod *Module,
 	useMod Module,
+	mapping map[string]string,
+	merge bool,
 	settingsToIgnore []string,
 ) error {
 	modInputsMap := getModuleInputMap(mod.InfoOrDie().Inputs)
-	for _, useOutput := range useMod.InfoOrDie().Outputs {
+	outputs := useMod.InfoOrDie().Outputs
+	mapped := maps.Keys(mapping)
+	slices.Sort(mapped)
+	for _, output := range mapped {
+		if !slices.ContainsFunc(outputs, func(o modulereader.OutputInfo) bool { return o.Name == output }) {
+			return fmt.Errorf("module %s uses the output %s of module %s, which has no such output",
+				mod.ID, output, useMod.ID)
+		}
+		if _, ok := modInputsMap[mapping[output]]; !ok {
+			return fmt.Errorf("module %s maps the output %s of module %s to the input %s, which it does not have",
+				mod.ID, output, useMod.ID, mapping[output])
+		}
+	}
+	for _, useOutput := range outputs {
 		settingName := useOutput.Name
-
-		// explicitly ignore these settings (typically those in blueprint)
-		if slices.Contains(settingsToIgnore, settingName) {
-			continue
+		if input, ok := mapping[useOutput.Name]; ok {
+			settingName = input
 		}
 
 		// Skip settings that do not have matching module inputs
@@ -188,20 +314,30 @@ func useModule(
 		if !ok {
 			continue
 		}
+		isList := isListType(inputType)
+		ref := ModuleRef(useMod.ID, useOutput.Name)
+		v := ref.AsExpression().
+			AsValue().
+			Mark(ProductOfModuleUse{Module: useMod.ID})
+
+		// explicitly ignore these settings (typically those in blueprint),
+		// unless asked to merge the outputs into those of list type that can
+		// be flattened
+		if slices.Contains(settingsToIgnore, settingName) {
+			if merge && isList && mod.writesExpressions() {
+				if err := mod.mergeListValue(settingName, v, ref); err != nil {
+					return err
+				}
+			}
+			continue
+		}
 
 		// skip settings that are not of list type, but already have a value
 		// these were probably added by a previous call to this function
-		alreadySet := mod.Settings.Has(settingName)
-		isList := strings.HasPrefix(inputType, "list")
-		if alreadySet && !isList {
+		if mod.Settings.Has(settingName) && !isList {
 			continue
 		}
 
-		v := ModuleRef(useMod.ID, settingName).
-			AsExpression().
-			AsValue().
-			Mark(ProductOfModuleUse{Module: useMod.ID})
-
 		if !isList {
 			mod.Settings.Set(settingName, v)
 		} else {
@@ -219,11 +355,11 @@ func (dc *DeploymentConfig) applyUseModules() error {
 	return dc.Config.WalkModules(func(m *Module) error {
 		settingsInBlueprint := maps.Keys(m.Settings.Items())
 		for _, u := range m.Use {
-			used, err := dc.Config.Module(u)
+			used, err := dc.Config.Module(u.Module)
 			if err != nil {
 				return err
 			}
-			if err := useModule(m, *used, settingsInBlueprint); err != nil {
+			if err := useModule(m, *used, u.Map, u.Merge, settingsInBlueprint); err != nil {
 				return err
 			}
 		}
@@ -255,31 +391,45 @@ func getRole(source string) string {
 
 // combineLabels sets defaults for labels based on other variables and merges
 // the global labels defined in Vars with module setting labels. It also
-// determines the role and sets it for each module independently.
+// determines the role and sets it for each module independently. The label
+// policy of the blueprint, if any, is enforced on the labels.
 func (dc *DeploymentConfig) combineLabels() error {
 	vars := &dc.Config.Vars
-	defaults := map[string]cty.Value{
-		blueprintLabel:  cty.StringVal(dc.Config.BlueprintName),
-		deploymentLabel: vars.Get("deployment_name"),
+	tl := dc.Config.ToolkitLabels
+	defaults := map[string]cty.Value{}
+	if !tl.Disabled {
+		defaults[tl.Key(blueprintLabel)] = cty.StringVal(dc.Config.BlueprintName)
+		defaults[tl.Key(deploymentLabel)] = vars.Get("deployment_name")
+	}
+	if !tl.Disabled && dc.Config.Profile != "" {
+		defaults[tl.Key(profileLabel)] = cty.StringVal(dc.Config.Profile)
+	}
+	for k, v := range dc.Config.provenanceLabels() {
+		defaults[k] = v
 	}
 	labels := "labels"
 	if !vars.Has(labels) { // Shouldn't happen if blueprint was properly constructed
 		vars.Set(labels, cty.EmptyObjectVal)
 	}
+	lp := newLabelEnforcer(*dc)
 	gl := mergeLabels(vars.Get(labels).AsValueMap(), defaults)
+	gl = lp.enforce("the deployment", "vars.labels", gl)
 	vars.Set(labels, cty.ObjectVal(gl))
 
-	return dc.Config.WalkModules(func(mod *Module) error {
-		return combineModuleLabels(mod, *dc)
-	})
+	if err := dc.Config.WalkModules(func(mod *Module) error {
+		return combineModuleLabels(mod, *dc, lp)
+	}); err != nil {
+		return err
+	}
+	return lp.err()
 }
 
-func combineModuleLabels(mod *Module, dc DeploymentConfig) error {
-	mod.createWrapSettingsWith()
-	labels := "labels"
+func combineModuleLabels(mod *Module, dc DeploymentConfig, lp *labelEnforcer) error {
+	// labels are merged into the setting of the cloud, e.g. tags on AWS
+	labels := dc.Config.TargetCloud().LabelsSetting
 
-	// previously expanded blueprint, user written BPs do not use `WrapSettingsWith`
-	if _, ok := mod.WrapSettingsWith[labels]; ok {
+	// previously expanded blueprint, or labels wrapped in the blueprint
+	if _, ok := mod.WrapSettings[labels]; ok {
 		return nil // Do nothing
 	}
 
@@ -288,6 +438,11 @@ func combineModuleLabels(mod *Module, dc DeploymentConfig) error {
 		return nil
 	}
 
+	// labels set to null are left unset
+	if mod.Settings.Has(labels) && mod.Settings.Get(labels).IsNull() {
+		return nil
+	}
+
 	modLabels := map[string]cty.Value{}
 	if mod.Settings.Has(labels) {
 		// Cast into map so we can index into them
@@ -302,19 +457,40 @@ func combineModuleLabels(mod *Module, dc DeploymentConfig) error {
 		}
 	}
 	// Add the role (e.g. compute, network, etc)
-	if _, exists := modLabels[roleLabel]; !exists {
-		modLabels[roleLabel] = cty.StringVal(getRole(mod.Source))
+	tl := dc.Config.ToolkitLabels
+	if _, exists := modLabels[tl.Key(roleLabel)]; !exists && !tl.Disabled {
+		modLabels[tl.Key(roleLabel)] = cty.StringVal(getRole(mod.Source))
+	}
+
+	modLabels = lp.enforce("module "+string(mod.ID), "modules."+string(mod.ID), modLabels)
+
+	g := dc.Config.Vars.Get("labels").AsValueMap()
+	switch mod.LabelMerge {
+	case LabelMergeNone:
+		lp.require(*mod, modLabels)
+		mod.Settings.Set(labels, cty.ObjectVal(modLabels))
+		return nil
+	case LabelMergeDeployment:
+		lp.require(*mod, mergeLabels(g, modLabels))
+	default:
+		lp.require(*mod, mergeLabels(modLabels, g))
 	}
 
 	if mod.Kind == TerraformKind {
 		// Terraform module labels to be expressed as
-		// `merge(var.labels, { ghpc_role=..., **settings.labels })`
-		mod.WrapSettingsWith[labels] = []string{"merge(", ")"}
-		ref := GlobalRef(labels).AsExpression()
-		args := []cty.Value{ref.AsValue(), cty.ObjectVal(modLabels)}
+		// `merge(var.labels, { ghpc_role=..., **settings.labels })`, the
+		// arguments are swapped if the labels of the deployment win
+		mod.wrapSetting(labels, "merge")
+		ref := GlobalRef("labels").AsExpression().AsValue()
+		args := []cty.Value{ref, cty.ObjectVal(modLabels)}
+		if mod.LabelMerge == LabelMergeDeployment {
+			args = []cty.Value{cty.ObjectVal(modLabels), ref}
+		}
 		mod.Settings.Set(labels, cty.TupleVal(args))
-	} else if mod.Kind == PackerKind {
-		g := dc.Config.Vars.Get(labels).AsValueMap()
+	} else if mod.LabelMerge == LabelMergeDeployment {
+		// Packer and Helm modules take the labels as a value
+		mod.Settings.Set(labels, cty.ObjectVal(mergeLabels(g, modLabels)))
+	} else {
 		mod.Settings.Set(labels, cty.ObjectVal(mergeLabels(modLabels, g)))
 	}
 	return nil
@@ -335,7 +511,7 @@ func mergeLabels(a map[string]cty.Value, b map[string]cty.Value) map[string]cty.
 	return r
 }
 
-func (bp Blueprint) applyGlobalVarsInModule(mod *Module) error {
+func (bp Blueprint) applyGlobalVarsInModule(mod *Module, prompt PromptFunc) error {
 	mi := mod.InfoOrDie()
 	for _, input := range mi.Inputs {
 		// Module setting exists? Nothing more needs to be done.
@@ -343,13 +519,26 @@ func (bp Blueprint) applyGlobalVarsInModule(mod *Module) error {
 			continue
 		}
 
-		// If it's not set, is there a global we can use?
-		if bp.Vars.Has(input.Name) {
+		// If it's not set, is there a global we can use? Module variables
+		// are only used explicitly, as they may refer to this module.
+		if bp.Vars.Has(input.Name) && !bp.IsModuleVar(input.Name) {
 			ref := GlobalRef(input.Name)
 			mod.Settings.Set(input.Name, ref.AsExpression().AsValue())
 			continue
 		}
 
+		if input.Required && prompt != nil {
+			ty, err := parseTypeConstraint(input.Type)
+			if err != nil {
+				ty = cty.DynamicPseudoType
+			}
+			v, err := prompt(fmt.Sprintf("setting %s of module %s", input.Name, mod.ID), input.Description, ty)
+			if err != nil {
+				return err
+			}
+			mod.Settings.Set(input.Name, v)
+			continue
+		}
 		if input.Required {
 			// It's not explicitly set, and not global is set
 			// Fail if no default has been set
@@ -365,7 +554,7 @@ func (bp Blueprint) applyGlobalVarsInModule(mod *Module) error {
 // applies them to module settings if not already set.
 func (dc *DeploymentConfig) applyGlobalVariables() error {
 	return dc.Config.WalkModules(func(mod *Module) error {
-		return dc.Config.applyGlobalVarsInModule(mod)
+		return dc.Config.applyGlobalVarsInModule(mod, dc.Prompt)
 	})
 }
 
@@ -374,9 +563,14 @@ func AutomaticOutputName(outputName string, moduleID ModuleID) string {
 	return outputName + "_" + string(moduleID)
 }
 
+// PackerImageOutput is the output of Packer modules that modules of later
+// deployment groups can reference: the name of the image built by the module,
+// read from its Packer manifest
+const PackerImageOutput = "image_name"
+
 // Checks validity of reference to a module:
 // * module exists;
-// * module is not a Packer module;
+// * module is not a Packer module, unless both are Packer modules of a group;
 // * module is not in a later deployment group.
 func validateModuleReference(bp Blueprint, from Module, toID ModuleID) error {
 	to, err := bp.Module(toID)
@@ -385,9 +579,18 @@ func validateModuleReference(bp Blueprint, from Module, toID ModuleID) error {
 	}
 
 	if to.Kind == PackerKind {
+		// Packer modules use the modules of their group they are built after
+		if from.Kind == PackerKind && bp.ModuleGroupOrDie(from.ID).hasModule(to.ID) {
+			return nil
+		}
 		return fmt.Errorf("%s: %s", errorMessages["cannotUsePacker"], to.ID)
 	}
+	return validateGroupOrder(bp, from, *to)
+}
 
+// validateGroupOrder checks that the module to is not in a later deployment
+// group than the module from
+func validateGroupOrder(bp Blueprint, from Module, to Module) error {
 	fg := bp.ModuleGroupOrDie(from.ID)
 	tg := bp.ModuleGroupOrDie(to.ID)
 	fgi := slices.IndexFunc(bp.DeploymentGroups, func(g DeploymentGroup) bool { return g.Name == fg.Name })
@@ -400,7 +603,7 @@ func validateModuleReference(bp Blueprint, from Module, toID ModuleID) error {
 
 // Checks validity of reference to a module output:
 // * reference to an existing global variable;
-// * reference to a module is valid;
+// * reference to a module is valid, or to the image of a Packer module;
 // * referenced module output exists.
 func validateModuleSettingReference(bp Blueprint, mod Module, r Reference) error {
 	// simplest case to evaluate is a deployment variable's existence
@@ -411,6 +614,12 @@ func validateModuleSettingReference(bp Blueprint, mod Module, r Reference) error
 		return nil
 	}
 
+	if tm, err := bp.Module(r.Module); err == nil && tm.Kind == PackerKind && r.Name == PackerImageOutput {
+		if bp.ModuleGroupOrDie(mod.ID).hasModule(tm.ID) {
+			return fmt.Errorf("the image of Packer module %s can only be used by modules of later groups", tm.ID)
+		}
+		return validateGroupOrder(bp, mod, *tm)
+	}
 	if err := validateModuleReference(bp, mod, r.Module); err != nil {
 		return err
 	}
@@ -440,7 +649,10 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 		dc.Config.Validators = []validatorConfig{}
 	}
 
-	projectIDExists := dc.Config.Vars.Has("project_id")
+	// a bootstrap group creates the project at deploy time, so project-level
+	// checks cannot succeed beforehand
+	gcp := dc.Config.TargetCloud().Name == GCP
+	projectIDExists := gcp && dc.Config.Vars.Has("project_id") && dc.Config.bootstrapGroup() < 0
 	projectRef := GlobalRef("project_id").AsExpression().AsValue()
 
 	regionExists := dc.Config.Vars.Has("region")
@@ -465,8 +677,17 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 
 	// it is safe to run this validator even if vars.project_id is undefined;
 	// it will likely fail but will do so helpfully to the user
-	defaults = append(defaults,
-		validatorConfig{Validator: "test_apis_enabled"})
+	if gcp && dc.Config.bootstrapGroup() < 0 {
+		defaults = append(defaults,
+			validatorConfig{Validator: "test_apis_enabled"})
+	}
+
+	if projectIDExists {
+		defaults = append(defaults, validatorConfig{
+			Validator: testIamPermissionsName.String(),
+			Inputs:    NewDict(map[string]cty.Value{"project_id": projectRef}),
+		})
+	}
 
 	if projectIDExists && regionExists {
 		defaults = append(defaults, validatorConfig{
@@ -499,6 +720,30 @@ func (dc *DeploymentConfig) addDefaultValidators() error {
 		})
 	}
 
+	if projectIDExists && zoneExists {
+		defaults = append(defaults, validatorConfig{
+			Validator: testMachineTypesAvailableName.String(),
+			Inputs: NewDict(map[string]cty.Value{
+				"project_id": projectRef,
+				"zone":       zoneRef,
+			}),
+		})
+	}
+
+	if projectIDExists && dc.Config.Vars.Has("max_budget") {
+		defaults = append(defaults, validatorConfig{
+			Validator: testBudgetName.String(),
+			Inputs: NewDict(map[string]cty.Value{
+				"project_id": projectRef,
+				"max_budget": GlobalRef("max_budget").AsExpression().AsValue(),
+			}),
+		})
+	}
+
+	if v := dc.Config.TargetCloud().validators; v != nil {
+		defaults = append(defaults, v(dc.Config.Vars)...)
+	}
+
 	used := map[string]bool{}
 	for _, v := range dc.Config.Validators {
 		used[v.Validator] = true
@@ -525,11 +770,12 @@ func (dg DeploymentGroup) FindAllIntergroupReferences(bp Blueprint) []Reference
 	return maps.Keys(igcRefs)
 }
 
-// FindIntergroupReferences finds all references to other groups used in the given value
+// FindIntergroupReferences finds all references to other groups used in the
+// given value, including through module variables
 func FindIntergroupReferences(v cty.Value, mod Module, bp Blueprint) []Reference {
 	g := bp.ModuleGroupOrDie(mod.ID)
 	res := map[Reference]bool{}
-	cty.Walk(v, func(p cty.Path, v cty.Value) (bool, error) {
+	cty.Walk(bp.WithModuleVars(v), func(p cty.Path, v cty.Value) (bool, error) {
 		e, is := IsExpressionValue(v)
 		if !is {
 			return true, nil
@@ -555,8 +801,17 @@ func (bp *Blueprint) populateOutputs() {
 		return nil
 	})
 
+	// visit references in a stable order so that expansion is deterministic
+	sorted := maps.Keys(refs)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Module != sorted[j].Module {
+			return sorted[i].Module < sorted[j].Module
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
 	bp.WalkModules(func(m *Module) error {
-		for r := range refs {
+		for _, r := range sorted {
 			if r.Module != m.ID {
 				continue // find IGC references pointing to this module
 			}
@@ -567,11 +822,95 @@ func (bp *Blueprint) populateOutputs() {
 				Name:        r.Name,
 				Description: "Automatically-generated output exported for use by later deployment groups",
 				Sensitive:   true,
+				Managed:     true,
 			})
 
 		}
+		// outputs referenced by health probes are read after deployment
+		for _, p := range m.HealthProbes {
+			for _, name := range p.SelfReferences() {
+				if slices.ContainsFunc(m.Outputs, func(o modulereader.OutputInfo) bool { return o.Name == name }) {
+					continue
+				}
+				m.Outputs = append(m.Outputs, modulereader.OutputInfo{
+					Name:        name,
+					Description: "Automatically-generated output exported for use by health probes",
+					Managed:     true,
+				})
+			}
+		}
+		// outputs of the deployment are exported by the groups of their modules
+		for _, o := range bp.Outputs {
+			r, err := o.Reference()
+			if err != nil || r.Module != m.ID {
+				continue
+			}
+			i := slices.IndexFunc(m.Outputs, func(mo modulereader.OutputInfo) bool { return mo.Name == r.Name })
+			if i >= 0 {
+				m.Outputs[i].Sensitive = m.Outputs[i].Sensitive || o.Sensitive
+				continue
+			}
+			desc := o.Description
+			if desc == "" {
+				desc = fmt.Sprintf("Automatically-generated output exported as output %s of the deployment", o.Name)
+			}
+			m.Outputs = append(m.Outputs, modulereader.OutputInfo{Name: r.Name, Description: desc, Sensitive: o.Sensitive, Managed: true})
+		}
+		// Terraform requires outputs of sensitive values to be sensitive
+		if m.Kind == TerraformKind && len(m.Outputs) > 0 {
+			meta := m.InfoOrDie().GetOutputsAsMap()
+			for i, o := range m.Outputs {
+				m.Outputs[i].Sensitive = o.Sensitive || meta[o.Name].Sensitive
+			}
+		}
+		return nil
+	})
+}
+
+// unusedOutputs returns the outputs listed in the blueprint for Terraform
+// modules that no later deployment group, health probe or output of the
+// deployment uses
+func (bp Blueprint) unusedOutputs() []Reference {
+	used := map[Reference]bool{}
+	bp.WalkModules(func(m *Module) error {
+		for _, r := range FindIntergroupReferences(m.Settings.AsObject(), *m, bp) {
+			used[r] = true
+		}
+		for _, p := range m.HealthProbes {
+			for _, name := range p.SelfReferences() {
+				used[ModuleRef(m.ID, name)] = true
+			}
+		}
+		return nil
+	})
+	for _, o := range bp.Outputs {
+		if r, err := o.Reference(); err == nil {
+			used[r] = true
+		}
+	}
+
+	unused := []Reference{}
+	bp.WalkModules(func(m *Module) error {
+		if m.Kind != TerraformKind {
+			return nil
+		}
+		for _, o := range m.Outputs {
+			if r := ModuleRef(m.ID, o.Name); !o.Managed && !used[r] {
+				unused = append(unused, r)
+			}
+		}
 		return nil
 	})
+	return unused
+}
+
+// warnUnusedOutputs warns of outputs listed in the blueprint that are only
+// exported as Terraform outputs of their group
+func (bp Blueprint) warnUnusedOutputs() {
+	for _, r := range bp.unusedOutputs() {
+		log.Printf("warning: output %s of module %s is not used by other deployment groups, it is only exported as an output of group %s",
+			r.Name, r.Module, bp.ModuleGroupOrDie(r.Module).Name)
+	}
 }
 
 // OutputNames returns the group-level output names constructed from module ID
@@ -25,7 +25,7 @@ import (
 func (s *MySuite) TestExpand(c *C) {
 	dc := getDeploymentConfigForTest()
 	fmt.Println("TEST_DEBUG: If tests die without report, check TestExpand")
-	dc.expand()
+	c.Assert(dc.expand(), IsNil)
 }
 
 func (s *MySuite) TestExpandBackends(c *C) {
@@ -96,7 +96,7 @@ func (s *MySuite) TestUseModule(c *C) {
 		setTestModuleInfo(mod, modulereader.ModuleInfo{})
 		setTestModuleInfo(usedMod, modulereader.ModuleInfo{})
 
-		err := useModule(&mod, usedMod, []string{})
+		err := useModule(&mod, usedMod, nil, false, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings, DeepEquals, Dict{})
 	}
@@ -108,7 +108,7 @@ func (s *MySuite) TestUseModule(c *C) {
 		setTestModuleInfo(usedMod, modulereader.ModuleInfo{
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
-		err := useModule(&mod, usedMod, []string{})
+		err := useModule(&mod, usedMod, nil, false, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings, DeepEquals, Dict{})
 	}
@@ -122,7 +122,7 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{})
+		err := useModule(&mod, usedMod, nil, false, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
 			"val1": ref.Mark(useMark),
@@ -139,7 +139,7 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{"val1"})
+		err := useModule(&mod, usedMod, nil, false, []string{"val1"})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{"val1": ref})
 	}
@@ -155,7 +155,7 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{})
+		err := useModule(&mod, usedMod, nil, false, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{"val1": ref.Mark(useMark)})
 	}
@@ -168,7 +168,7 @@ func (s *MySuite) TestUseModule(c *C) {
 		setTestModuleInfo(usedMod, modulereader.ModuleInfo{
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
-		err := useModule(&mod, usedMod, []string{})
+		err := useModule(&mod, usedMod, nil, false, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
 			"val1": cty.TupleVal([]cty.Value{
@@ -187,7 +187,7 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{})
+		err := useModule(&mod, usedMod, nil, false, []string{})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
 			"val1": cty.TupleVal([]cty.Value{
@@ -207,11 +207,89 @@ func (s *MySuite) TestUseModule(c *C) {
 			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
 		})
 
-		err := useModule(&mod, usedMod, []string{"val1"})
+		err := useModule(&mod, usedMod, nil, false, []string{"val1"})
 		c.Check(err, IsNil)
 		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
 			"val1": cty.TupleVal([]cty.Value{ref})})
 	}
+
+	{ // Pass: Setting in blueprint, Input is List, explicit empty list is kept
+		mod := Module{ID: "lime", Source: "limeTree"}
+		mod.Settings.Set("val1", cty.EmptyTupleVal)
+		setTestModuleInfo(mod, modulereader.ModuleInfo{
+			Inputs: []modulereader.VarInfo{{Name: "val1", Type: "list(string)"}},
+		})
+		setTestModuleInfo(usedMod, modulereader.ModuleInfo{
+			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
+		})
+
+		err := useModule(&mod, usedMod, nil, false, []string{"val1"})
+		c.Check(err, IsNil)
+		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{"val1": cty.EmptyTupleVal})
+		c.Check(mod.WrapSettings["val1"], Equals, "")
+	}
+
+	{ // Pass: Setting in blueprint, Input is List, merged outputs go after it
+		mod := Module{ID: "lime", Source: "limeTree"}
+		explicit := cty.TupleVal([]cty.Value{cty.StringVal("a")})
+		mod.Settings.Set("val1", explicit)
+		setTestModuleInfo(mod, modulereader.ModuleInfo{
+			Inputs: []modulereader.VarInfo{{Name: "val1", Type: "set(string)"}},
+		})
+		setTestModuleInfo(usedMod, modulereader.ModuleInfo{
+			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
+		})
+
+		err := useModule(&mod, usedMod, nil, true, []string{"val1"})
+		c.Check(err, IsNil)
+		want := map[string]cty.Value{"val1": cty.TupleVal([]cty.Value{explicit, ref.Mark(useMark)})}
+		c.Check(mod.Settings.Items(), DeepEquals, want)
+		c.Check(mod.WrapSettings["val1"], Equals, "flatten")
+
+		// merging again, e.g. an expanded blueprint, is a no-op
+		err = useModule(&mod, usedMod, nil, true, []string{"val1"})
+		c.Check(err, IsNil)
+		c.Check(mod.Settings.Items(), DeepEquals, want)
+	}
+
+	{ // Pass: Setting set to null in blueprint, outputs are not merged
+		mod := Module{ID: "lime", Source: "limeTree"}
+		mod.Settings.Set("val1", cty.NullVal(cty.DynamicPseudoType))
+		setTestModuleInfo(mod, modulereader.ModuleInfo{
+			Inputs: []modulereader.VarInfo{{Name: "val1", Type: "list(string)"}},
+		})
+		setTestModuleInfo(usedMod, modulereader.ModuleInfo{
+			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
+		})
+
+		err := useModule(&mod, usedMod, nil, true, []string{"val1"})
+		c.Check(err, IsNil)
+		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
+			"val1": cty.NullVal(cty.DynamicPseudoType)})
+		c.Check(mod.WrapSettings["val1"], Equals, "")
+	}
+
+	{ // Pass: Output mapped to an input of another name
+		mod := Module{ID: "lime", Source: "limeTree"}
+		setTestModuleInfo(mod, modulereader.ModuleInfo{
+			Inputs: []modulereader.VarInfo{{Name: "val2", Type: "number"}, varInfoNumber},
+		})
+		setTestModuleInfo(usedMod, modulereader.ModuleInfo{
+			Outputs: []modulereader.OutputInfo{{Name: "val1"}},
+		})
+
+		err := useModule(&mod, usedMod, map[string]string{"val1": "val2"}, false, []string{})
+		c.Check(err, IsNil)
+		c.Check(mod.Settings.Items(), DeepEquals, map[string]cty.Value{
+			"val2": ref.Mark(useMark),
+		})
+
+		// Fail: unknown output or input
+		c.Check(useModule(&mod, usedMod, map[string]string{"val3": "val2"}, false, []string{}), ErrorMatches,
+			".*has no such output")
+		c.Check(useModule(&mod, usedMod, map[string]string{"val1": "val3"}, false, []string{}), ErrorMatches,
+			".*which it does not have")
+	}
 }
 
 func (s *MySuite) TestApplyUseModules(c *C) {
@@ -227,7 +305,7 @@ func (s *MySuite) TestApplyUseModules(c *C) {
 		using := Module{
 			ID:     "usingModule",
 			Source: "path/using",
-			Use:    []ModuleID{"usedModule"},
+			Use:    []ModuleUse{{Module: "usedModule"}},
 		}
 		used := Module{ID: "usedModule", Source: "path/used"}
 
@@ -293,6 +371,12 @@ func (s *MySuite) TestCombineLabels(c *C) {
 	khaki := Module{Source: "brown/oak", Kind: TerraformKind, ID: "khaki"}
 	setTestModuleInfo(khaki, infoWithLabels)
 
+	// labels set to null
+	plum := Module{Source: "purple/grape", Kind: TerraformKind, ID: "plum", Settings: NewDict(map[string]cty.Value{
+		"labels": cty.NullVal(cty.DynamicPseudoType),
+	})}
+	setTestModuleInfo(plum, infoWithLabels)
+
 	// has no labels set, also module has no labels input
 	silver := Module{Source: "ivory/black", Kind: TerraformKind, ID: "silver"}
 	setTestModuleInfo(silver, modulereader.ModuleInfo{Inputs: []modulereader.VarInfo{}})
@@ -312,7 +396,7 @@ func (s *MySuite) TestCombineLabels(c *C) {
 				"deployment_name": cty.StringVal("golden"),
 			}),
 			DeploymentGroups: []DeploymentGroup{
-				{Name: "lime", Modules: []Module{coral, khaki, silver}},
+				{Name: "lime", Modules: []Module{coral, khaki, silver, plum}},
 				{Name: "pink", Modules: []Module{orange}},
 			},
 		},
@@ -330,7 +414,7 @@ func (s *MySuite) TestCombineLabels(c *C) {
 	lime := dc.Config.DeploymentGroups[0]
 	// Labels are set and override role
 	coral = lime.Modules[0]
-	c.Check(coral.WrapSettingsWith["labels"], DeepEquals, []string{"merge(", ")"})
+	c.Check(coral.WrapSettings["labels"], Equals, "merge")
 	c.Check(coral.Settings.Get("labels"), DeepEquals, cty.TupleVal([]cty.Value{
 		labelsRef,
 		cty.ObjectVal(map[string]cty.Value{
@@ -340,7 +424,7 @@ func (s *MySuite) TestCombineLabels(c *C) {
 	}))
 	// Labels are not set, infer role from module.source
 	khaki = lime.Modules[1]
-	c.Check(khaki.WrapSettingsWith["labels"], DeepEquals, []string{"merge(", ")"})
+	c.Check(khaki.WrapSettings["labels"], Equals, "merge")
 	c.Check(khaki.Settings.Get("labels"), DeepEquals, cty.TupleVal([]cty.Value{
 		labelsRef,
 		cty.ObjectVal(map[string]cty.Value{
@@ -348,13 +432,18 @@ func (s *MySuite) TestCombineLabels(c *C) {
 	}))
 	// No labels input
 	silver = lime.Modules[2]
-	c.Check(silver.WrapSettingsWith["labels"], IsNil)
+	c.Check(silver.WrapSettings["labels"], Equals, "")
 	c.Check(silver.Settings.Get("labels"), DeepEquals, cty.NilVal)
 
+	// Labels set to null are left unset
+	plum = lime.Modules[3]
+	c.Check(plum.WrapSettings["labels"], Equals, "")
+	c.Check(plum.Settings.Get("labels"), DeepEquals, cty.NullVal(cty.DynamicPseudoType))
+
 	// Packer, include global include explicitly
 	// Keep overridden ghpc_deployment=navy
 	orange = dc.Config.DeploymentGroups[1].Modules[0]
-	c.Check(orange.WrapSettingsWith["labels"], IsNil)
+	c.Check(orange.WrapSettings["labels"], Equals, "")
 	c.Check(orange.Settings.Get("labels"), DeepEquals, cty.ObjectVal(map[string]cty.Value{
 		"ghpc_blueprint":  cty.StringVal("simple"),
 		"ghpc_deployment": cty.StringVal("navy"),
@@ -496,6 +585,14 @@ func (s *MySuite) TestValidateModuleReference(c *C) {
 	// Reference packer module (bad)
 	c.Check(validateModuleReference(bp, y, pkr.ID), NotNil)
 
+	// Reference the image of a packer module in an earlier group (good)
+	c.Check(validateModuleSettingReference(bp, y, ModuleRef(pkr.ID, PackerImageOutput)), IsNil)
+
+	// Reference the image of a packer module in a later group (bad)
+	c.Check(validateModuleSettingReference(bp, a, ModuleRef(pkr.ID, PackerImageOutput)), NotNil)
+
+	// Reference another output of a packer module (bad)
+	c.Check(validateModuleSettingReference(bp, y, ModuleRef(pkr.ID, "manifest")), NotNil)
 }
 
 func (s *MySuite) TestIntersection(c *C) {
@@ -22,6 +22,7 @@ import (
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
 )
 
 // Reference is data struct that represents a reference to a variable.
@@ -208,6 +209,10 @@ type Expression interface {
 	key() expressionKey
 }
 
+// terraformRoots are the roots of the references that expressions can make,
+// besides deployment variables and module outputs, which Terraform resolves
+var terraformRoots = []string{"path", "terraform"}
+
 // ParseExpression returns Expression
 func ParseExpression(s string) (Expression, error) {
 	e, diag := hclsyntax.ParseExpression([]byte(s), "", hcl.Pos{})
@@ -220,13 +225,16 @@ func ParseExpression(s string) (Expression, error) {
 		wToks[i] = &hclwrite.Token{Type: st.Type, Bytes: st.Bytes}
 	}
 
-	ts := e.Variables()
-	rs := make([]Reference, len(ts))
-	for i, t := range ts {
-		var err error
-		if rs[i], err = TraversalToReference(t); err != nil {
+	rs := []Reference{}
+	for _, t := range e.Variables() {
+		if slices.Contains(terraformRoots, t.RootName()) {
+			continue
+		}
+		r, err := TraversalToReference(t)
+		if err != nil {
 			return nil, err
 		}
+		rs = append(rs, r)
 	}
 	return BaseExpression{e: e, toks: wToks, rs: rs}, nil
 }
@@ -299,6 +307,79 @@ func (e BaseExpression) AsValue() cty.Value {
 	return cty.DynamicVal.Mark(k)
 }
 
+// RawHCLTag is the YAML tag of settings whose value is a raw HCL expression
+const RawHCLTag = "!hcl"
+
+// RawExpression is an HCL expression that is written to the deployment
+// verbatim, e.g. a multi-line expression or a heredoc. It is set with the
+// !hcl tag and its value is only known after deployment.
+type RawExpression struct {
+	src string
+	rs  []Reference
+}
+
+// ParseRawExpression returns the RawExpression of the source s
+func ParseRawExpression(s string) (Expression, error) {
+	src := strings.TrimSpace(s)
+	// heredocs must be terminated by a newline
+	e, diag := hclsyntax.ParseExpression([]byte(src+"\n"), "", hcl.Pos{Line: 1, Column: 1})
+	if diag.HasErrors() {
+		return nil, diag
+	}
+	rs := []Reference{}
+	for _, t := range e.Variables() {
+		if slices.Contains(terraformRoots, t.RootName()) {
+			continue
+		}
+		r, err := TraversalToReference(t)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(rs, r) {
+			rs = append(rs, r)
+		}
+	}
+	return RawExpression{src: src, rs: rs}, nil
+}
+
+// Eval returns an unknown value, raw expressions are evaluated by Terraform
+func (e RawExpression) Eval(bp Blueprint) (cty.Value, error) {
+	return cty.DynamicVal, nil
+}
+
+// Tokenize returns the source of the expression as a single token, so that
+// it is written as is
+func (e RawExpression) Tokenize() hclwrite.Tokens {
+	return hclwrite.TokensForIdentifier(e.src)
+}
+
+// References return Reference for all variables used in the expression
+func (e RawExpression) References() []Reference {
+	return slices.Clone(e.rs)
+}
+
+// makeYamlExpressionValue returns the expression as an HCL literal, multi-line
+// ones end with a newline to terminate heredocs
+func (e RawExpression) makeYamlExpressionValue() cty.Value {
+	if strings.Contains(e.src, "\n") {
+		return cty.StringVal("((" + e.src + "\n))")
+	}
+	return cty.StringVal("((" + e.src + "))")
+}
+
+// key returns unique identifier of this expression in universe of all possible expressions.
+// `ex1.key() == ex2.key()` => `ex1` and `ex2` are identical.
+func (e RawExpression) key() expressionKey {
+	return expressionKey{k: RawHCLTag + " " + e.src}
+}
+
+// AsValue returns a cty.Value that represents the expression.
+func (e RawExpression) AsValue() cty.Value {
+	k := e.key()
+	globalExpressions[k] = e
+	return cty.DynamicVal.Mark(k)
+}
+
 // To associate cty.Value with Expression we use cty.Value.Mark
 // See: https://pkg.go.dev/github.com/zclconf/go-cty/cty#Value.Mark
 // "Marks" should be of hashable type, sadly Expression isn't one.
@@ -15,6 +15,7 @@
 package config
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -97,6 +98,56 @@ func TestIsYamlHclLiteral(t *testing.T) {
 	}
 }
 
+func TestParseRawExpression(t *testing.T) {
+	type test struct {
+		input string
+		refs  []Reference
+		err   bool
+	}
+	tests := []test{
+		{"var.green", []Reference{GlobalRef("green")}, false},
+		{"  merge(var.a,\n  module.box.b)\n", []Reference{GlobalRef("a"), ModuleRef("box", "b")}, false},
+		{`file("${path.module}/startup.sh")`, []Reference{}, false},
+		{"<<EOT\n${var.green}\nEOT", []Reference{GlobalRef("green")}, false},
+		{"local.green", nil, true},
+		{"var.green +", nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			e, err := ParseRawExpression(tc.input)
+			if tc.err != (err != nil) {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(tc.refs, e.References()); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+			// raw expressions are written as is and only known after deployment
+			if got := string(e.Tokenize().Bytes()); got != strings.TrimSpace(tc.input) {
+				t.Errorf("got tokens %q", got)
+			}
+			if v, err := e.Eval(Blueprint{}); err != nil || v.IsKnown() {
+				t.Errorf("got %#v, %v, want unknown value", v, err)
+			}
+			// and are literals in expanded blueprints
+			l, is := IsYamlExpressionLiteral(e.makeYamlExpressionValue())
+			if !is {
+				t.Fatalf("got %#v, want literal", e.makeYamlExpressionValue())
+			}
+			if _, err := ParseExpression(l); err != nil {
+				t.Errorf("literal %q does not parse: %v", l, err)
+			}
+		})
+	}
+
+	raw, _ := ParseRawExpression("var.green")
+	if raw.key() == MustParseExpression("var.green").key() {
+		t.Error("raw and parsed expressions have the same key")
+	}
+}
+
 func TestSimpleVarToExpression(t *testing.T) {
 	type test struct {
 		input string
@@ -0,0 +1,65 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+)
+
+// Flatten merges the deployment groups of the blueprint into a single
+// Terraform group, named after the first group, so that the deployment is
+// written as one root module with a single Terraform state. References
+// between groups become references between modules of the root module. The
+// group uses the Terraform backend of the first group; hooks and retry
+// settings may only be set on one of the groups, as the order of the groups
+// is lost.
+func (bp *Blueprint) Flatten() error {
+	if len(bp.DeploymentGroups) < 2 {
+		return nil
+	}
+	flat := DeploymentGroup{
+		Name:             bp.DeploymentGroups[0].Name,
+		TerraformBackend: bp.DeploymentGroups[0].TerraformBackend,
+		Kind:             TerraformKind,
+	}
+	var hooked, retried GroupName
+	for _, g := range bp.DeploymentGroups {
+		if g.Kind != TerraformKind {
+			return fmt.Errorf("cannot flatten the deployment: group %s is a %s group, only Terraform groups can be flattened", g.Name, g.Kind)
+		}
+		if g.IsBootstrap() {
+			return fmt.Errorf("cannot flatten the deployment: group %s creates the deployment project and must be deployed first", g.Name)
+		}
+		if len(g.Hooks.PreDeploy)+len(g.Hooks.PostDeploy)+len(g.Hooks.PostDestroy) > 0 {
+			if hooked != "" {
+				return fmt.Errorf("cannot flatten the deployment: groups %s and %s both have hooks", hooked, g.Name)
+			}
+			hooked, flat.Hooks = g.Name, g.Hooks
+		}
+		if g.Retry.MaxAttempts > 1 {
+			if retried != "" {
+				return fmt.Errorf("cannot flatten the deployment: groups %s and %s both have retry settings", retried, g.Name)
+			}
+			retried, flat.Retry = g.Name, g.Retry
+		}
+		flat.Modules = append(flat.Modules, g.Modules...)
+	}
+	bp.DeploymentGroups = []DeploymentGroup{flat}
+	// artifacts are uploaded before the only group
+	for i := range bp.Artifacts {
+		bp.Artifacts[i].DeploymentGroup = ""
+	}
+	return nil
+}
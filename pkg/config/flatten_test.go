@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestFlatten(t *testing.T) {
+	backend := TerraformBackend{Type: "gcs", Configuration: NewDict(map[string]cty.Value{
+		"bucket": cty.StringVal("state"),
+		"prefix": cty.StringVal("dep/primary"),
+	})}
+	network := Module{ID: "network", Kind: TerraformKind}
+	vm := Module{ID: "vm", Kind: TerraformKind, Settings: NewDict(map[string]cty.Value{
+		"network": ModuleRef("network", "network_self_link").AsExpression().AsValue(),
+	})}
+	hooks := GroupHooks{PostDeploy: []string{"./check.sh"}}
+	blueprint := func() Blueprint {
+		return Blueprint{
+			DeploymentGroups: []DeploymentGroup{
+				{Name: "primary", Kind: TerraformKind, TerraformBackend: backend, Modules: []Module{network.Clone()}},
+				{Name: "cluster", Kind: TerraformKind, Modules: []Module{vm.Clone()}, Hooks: hooks.Clone()},
+			},
+			Artifacts: []Artifact{{Name: "bundle", DeploymentGroup: "cluster"}},
+		}
+	}
+
+	bp := blueprint()
+	if err := bp.Flatten(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bp.DeploymentGroups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(bp.DeploymentGroups))
+	}
+	g := bp.DeploymentGroups[0]
+	if g.Name != "primary" || g.TerraformBackend.Type != "gcs" {
+		t.Errorf("got group %s with backend %q, want primary with gcs", g.Name, g.TerraformBackend.Type)
+	}
+	if diff := cmp.Diff([]ModuleID{"network", "vm"}, moduleIDs(g.Modules)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(hooks, g.Hooks); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if got := bp.ArtifactsOf("primary"); len(got) != 1 {
+		t.Errorf("got %d artifacts of the group, want 1", len(got))
+	}
+
+	bp = blueprint()
+	bp.DeploymentGroups[0].Hooks = hooks.Clone()
+	if err := bp.Flatten(); err == nil {
+		t.Error("expected an error for hooks on several groups")
+	}
+
+	bp = blueprint()
+	bp.DeploymentGroups[0].Kind = PackerKind
+	if err := bp.Flatten(); err == nil {
+		t.Error("expected an error for a Packer group")
+	}
+
+	bp = blueprint()
+	bp.DeploymentGroups[0].BootstrapProject = &Dict{}
+	if err := bp.Flatten(); err == nil {
+		t.Error("expected an error for a bootstrap group")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/config/configtest"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestExpandGolden(t *testing.T) {
+	configtest.RunFixtures(t, filepath.Join("testdata", "expand"))
+}
+
+func TestExpandIsPure(t *testing.T) {
+	dir := filepath.Join("testdata", "expand", "simple")
+	mods, err := os.ReadFile(filepath.Join(dir, configtest.ModulesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configtest.SetModuleInfo(t, mods)
+	bp, err := os.ReadFile(filepath.Join(dir, configtest.BlueprintFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := configtest.Expand(t, bp)
+	before, err := first.Config.CanonicalYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// expanding an expanded blueprint is a no-op and leaves the input unchanged
+	second, err := first.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := first.Config.CanonicalYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("Expand modified its input:\nbefore:\n%s\nafter:\n%s", before, after)
+	}
+	again, err := second.Config.CanonicalYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, again) {
+		t.Errorf("expansion is not idempotent:\nfirst:\n%s\nsecond:\n%s", before, again)
+	}
+}
+
+func TestExpandHasNoSideEffects(t *testing.T) {
+	dir := filepath.Join("testdata", "expand", "simple")
+	mods, err := os.ReadFile(filepath.Join(dir, configtest.ModulesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configtest.SetModuleInfo(t, mods)
+	bp, err := os.ReadFile(filepath.Join(dir, configtest.BlueprintFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func() config.DeploymentConfig {
+		dc, err := config.NewDeploymentConfigFromBytes(bp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dc
+	}
+
+	// exec expressions are not run, even if allowed
+	marker := filepath.Join(t.TempDir(), "ran")
+	dc := parse()
+	dc.AllowExec = true
+	dc.Config.Vars.Set("owner", cty.StringVal(`$(exec("touch `+marker+`"))`))
+	if _, err := dc.Expand(); err == nil {
+		t.Error("expected an error for an exec expression")
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("Expand ran the exec command: %v", err)
+	}
+
+	// remote outputs are not read
+	dc = parse()
+	dc.Config.RemoteOutputs = []config.RemoteOutputs{{Source: t.TempDir()}}
+	if _, err := dc.Expand(); err == nil || !strings.Contains(err.Error(), "not read by Expand") {
+		t.Errorf("got error %v, want an error for remote outputs", err)
+	}
+
+	// required variables are not prompted for
+	dc = parse()
+	dc.Config.DeploymentVariables = map[string]config.VariableDeclaration{"zone": {Type: "string"}}
+	dc.Prompt = func(string, string, cty.Type) (cty.Value, error) {
+		t.Error("Expand prompted for a variable")
+		return cty.StringVal("us-central1-a"), nil
+	}
+	if _, err := dc.Expand(); err == nil || !strings.Contains(err.Error(), "zone is required") {
+		t.Errorf("got error %v, want an error for the required variable", err)
+	}
+}
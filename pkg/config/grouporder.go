@@ -0,0 +1,155 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// groupDependency is a group another group must be deployed after, and why
+type groupDependency struct {
+	group  int
+	reason string
+}
+
+// groupDependencyGraph returns, for each group, the groups it must come
+// after: the groups of the modules its modules use or reference, including
+// through module variables, and the groups in its depends_on. References to
+// modules and groups that do not exist are left to validation.
+func (bp Blueprint) groupDependencyGraph() [][]groupDependency {
+	modGroup := map[ModuleID]int{}
+	for i, g := range bp.DeploymentGroups {
+		for _, m := range g.Modules {
+			if _, ok := modGroup[m.ID]; !ok {
+				modGroup[m.ID] = i
+			}
+		}
+	}
+
+	graph := make([][]groupDependency, len(bp.DeploymentGroups))
+	for i, g := range bp.DeploymentGroups {
+		add := func(j int, reason string) {
+			if j != i && !slices.ContainsFunc(graph[i], func(d groupDependency) bool { return d.group == j }) {
+				graph[i] = append(graph[i], groupDependency{j, reason})
+			}
+		}
+		for _, m := range g.Modules {
+			for _, u := range m.Use {
+				if j, ok := modGroup[u.Module]; ok {
+					add(j, fmt.Sprintf("module %s uses module %s", m.ID, u.Module))
+				}
+			}
+			for _, r := range valueReferences(bp.WithModuleVars(m.Settings.AsObject())) {
+				if j, ok := modGroup[r.Module]; ok && !r.GlobalVar {
+					add(j, fmt.Sprintf("module %s references $(%s.%s)", m.ID, r.Module, r.Name))
+				}
+			}
+		}
+		for _, d := range g.DependsOn {
+			if j := bp.GroupIndex(d); j >= 0 {
+				add(j, "depends_on")
+			}
+		}
+	}
+	return graph
+}
+
+// groupOrder returns the indices of the groups in the order they can be
+// deployed in: each group after the groups it depends on, and otherwise in
+// the order of the blueprint. It fails with the chain of references of a
+// cycle if there is no such order.
+func (bp Blueprint) groupOrder() ([]int, error) {
+	graph := bp.groupDependencyGraph()
+	placed := make([]bool, len(graph))
+	ready := func(i int) bool {
+		for _, d := range graph[i] {
+			if !placed[d.group] {
+				return false
+			}
+		}
+		return true
+	}
+
+	order := []int{}
+	for len(order) < len(graph) {
+		next := -1
+		for i := range graph {
+			if !placed[i] && ready(i) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, bp.groupCycleError(graph, placed)
+		}
+		placed[next] = true
+		order = append(order, next)
+	}
+	return order, nil
+}
+
+// groupCycleError reports a cycle among the groups that are not placed, each
+// of which depends on another one that is not placed
+func (bp Blueprint) groupCycleError(graph [][]groupDependency, placed []bool) error {
+	path := []int{slices.Index(placed, false)}
+	reasons := []string{}
+	for {
+		cur := path[len(path)-1]
+		i := slices.IndexFunc(graph[cur], func(d groupDependency) bool { return !placed[d.group] })
+		d := graph[cur][i]
+		if start := slices.Index(path, d.group); start >= 0 {
+			path, reasons = append(path[start:], d.group), append(reasons[start:], d.reason)
+			break
+		}
+		path, reasons = append(path, d.group), append(reasons, d.reason)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "deployment groups can not be ordered, they depend on each other: group %s", bp.DeploymentGroups[path[0]].Name)
+	for i, r := range reasons {
+		if i > 0 {
+			sb.WriteString(", which")
+		}
+		fmt.Fprintf(&sb, " depends on group %s (%s)", bp.DeploymentGroups[path[i+1]].Name, r)
+	}
+	return fmt.Errorf("%s", sb.String())
+}
+
+// orderGroups checks that the groups can be ordered so that each group comes
+// after the groups it depends on and, if ReorderGroups is set, moves them in
+// that order
+func (dc *DeploymentConfig) orderGroups() error {
+	order, err := dc.Config.groupOrder()
+	if err != nil {
+		return err
+	}
+	if !dc.ReorderGroups || slices.IsSorted(order) {
+		return nil
+	}
+	groups := make([]DeploymentGroup, len(order))
+	names := make([]string, len(order))
+	for i, j := range order {
+		groups[i] = dc.Config.DeploymentGroups[j]
+		names[i] = string(groups[i].Name)
+	}
+	dc.Config.DeploymentGroups = groups
+	log.Printf("warning: deployment groups were reordered so that each group comes after the groups it depends on: %s",
+		strings.Join(names, ", "))
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func orderBlueprint() Blueprint {
+	ref := func(m ModuleID) Dict {
+		return NewDict(map[string]cty.Value{"id": ModuleRef(m, "id").AsExpression().AsValue()})
+	}
+	return Blueprint{DeploymentGroups: []DeploymentGroup{
+		{Name: "monitoring", Modules: []Module{{ID: "dashboard"}}, DependsOn: []GroupName{"cluster"}},
+		{Name: "cluster", Modules: []Module{{ID: "nodes", Settings: ref("vpc")}}},
+		{Name: "primary", Modules: []Module{{ID: "vpc"}}},
+		{Name: "storage", Modules: []Module{{ID: "fs", Use: []ModuleUse{{Module: "vpc"}}}}},
+	}}
+}
+
+func TestGroupOrder(t *testing.T) {
+	bp := orderBlueprint()
+	got, err := bp.groupOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int{2, 1, 0, 3}, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// primary references cluster, which references primary
+	bp.DeploymentGroups[2].Modules[0].Settings = NewDict(map[string]cty.Value{
+		"peer": ModuleRef("nodes", "id").AsExpression().AsValue()})
+	_, err = bp.groupOrder()
+	want := "deployment groups can not be ordered, they depend on each other: " +
+		"group cluster depends on group primary (module nodes references $(vpc.id)), " +
+		"which depends on group cluster (module vpc references $(nodes.id))"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestOrderGroups(t *testing.T) {
+	names := func(bp Blueprint) []GroupName {
+		res := []GroupName{}
+		for _, g := range bp.DeploymentGroups {
+			res = append(res, g.Name)
+		}
+		return res
+	}
+
+	dc := DeploymentConfig{Config: orderBlueprint()}
+	if err := dc.orderGroups(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]GroupName{"monitoring", "cluster", "primary", "storage"}, names(dc.Config)); diff != "" {
+		t.Errorf("groups are reordered without ReorderGroups, diff (-want +got):\n%s", diff)
+	}
+
+	dc.ReorderGroups = true
+	if err := dc.orderGroups(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]GroupName{"primary", "cluster", "monitoring", "storage"}, names(dc.Config)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestCheckHealthProbes(t *testing.T) {
+	mod := Module{ID: "web", Source: "./modules/health-web", Kind: TerraformKind}
+	setTestModuleInfo(mod, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{{Name: "hostname"}}})
+
+	type test struct {
+		name  string
+		kind  ModuleKind
+		probe modulereader.HealthProbe
+		err   bool
+	}
+	tests := []test{
+		{"ok", TerraformKind, modulereader.HealthProbe{Name: "p", URL: "https://$(self.hostname)/$(vars.zone)"}, false},
+		{"unknown output", TerraformKind, modulereader.HealthProbe{Name: "p", URL: "https://$(self.ip)"}, true},
+		{"unknown var", TerraformKind, modulereader.HealthProbe{Name: "p", URL: "https://$(vars.region)"}, true},
+		{"invalid", TerraformKind, modulereader.HealthProbe{Name: "p"}, true},
+		{"packer", PackerKind, modulereader.HealthProbe{Name: "p", URL: "https://x"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := mod
+			m.Kind = tc.kind
+			m.HealthProbes = []modulereader.HealthProbe{tc.probe}
+			bp := Blueprint{
+				Vars:             NewDict(map[string]cty.Value{"zone": cty.StringVal("us-central1-a")}),
+				DeploymentGroups: []DeploymentGroup{{Modules: []Module{m}}},
+			}
+			err := checkHealthProbes(bp)
+			if tc.err != (err != nil) {
+				t.Errorf("got unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dns1123Label is the format of Kubernetes namespaces and Helm release names
+var dns1123Label = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// HelmRelease returns the name of the Helm release of a module of a
+// "kind: helm" group: its ID, with underscores replaced by dashes
+func HelmRelease(id ModuleID) string {
+	return strings.ReplaceAll(string(id), "_", "-")
+}
+
+// checkHelmGroups ensures that only Helm groups have a namespace, and that
+// namespaces and the release names of Helm modules are valid and unique
+func checkHelmGroups(groups []DeploymentGroup) error {
+	releases := map[string]ModuleID{}
+	for _, g := range groups {
+		if g.Kind != HelmKind {
+			if g.Namespace != "" {
+				return fmt.Errorf("group %s sets namespace but is not \"kind: helm\"", g.Name)
+			}
+			continue
+		}
+		if g.Namespace != "" && !dns1123Label.MatchString(g.Namespace) {
+			return fmt.Errorf("namespace %q of group %s must consist of lowercase letters, digits and dashes", g.Namespace, g.Name)
+		}
+		for _, m := range g.Modules {
+			r := HelmRelease(m.ID)
+			if !dns1123Label.MatchString(r) || len(r) > 53 {
+				return fmt.Errorf("module %s: the ID of Helm modules must consist of at most 53 lowercase letters, digits, dashes and underscores", m.ID)
+			}
+			key := g.Namespace + "/" + r
+			if other, ok := releases[key]; ok {
+				return fmt.Errorf("modules %s and %s are installed as the same Helm release %s", other, m.ID, r)
+			}
+			releases[key] = m.ID
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+)
+
+func TestHelmRelease(t *testing.T) {
+	if got := HelmRelease("slurm_operator"); got != "slurm-operator" {
+		t.Errorf("got %q, want slurm-operator", got)
+	}
+}
+
+func TestCheckHelmGroups(t *testing.T) {
+	groups := func() []DeploymentGroup {
+		return []DeploymentGroup{
+			{Name: "cluster", Kind: TerraformKind, Modules: []Module{{ID: "gke", Kind: TerraformKind}}},
+			{Name: "apps", Kind: HelmKind, Namespace: "kueue-system", Modules: []Module{
+				{ID: "kueue", Kind: HelmKind},
+				{ID: "slurm_operator", Kind: HelmKind},
+			}},
+		}
+	}
+	if err := checkHelmGroups(groups()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	gs := groups()
+	gs[0].Namespace = "default"
+	if err := checkHelmGroups(gs); err == nil {
+		t.Error("expected an error for a namespace of a Terraform group")
+	}
+
+	gs = groups()
+	gs[1].Namespace = "Kueue"
+	if err := checkHelmGroups(gs); err == nil {
+		t.Error("expected an error for an invalid namespace")
+	}
+
+	gs = groups()
+	gs[1].Modules = append(gs[1].Modules, Module{ID: "slurm-operator", Kind: HelmKind})
+	if err := checkHelmGroups(gs); err == nil {
+		t.Error("expected an error for modules installed as the same release")
+	}
+
+	gs = groups()
+	gs[1].Modules[0].ID = "Kueue"
+	if err := checkHelmGroups(gs); err == nil {
+		t.Error("expected an error for an invalid release name")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// GroupHooks are shell commands run by "ghpc deploy" and "ghpc destroy"
+// before and after a deployment group is deployed or destroyed
+type GroupHooks struct {
+	PreDeploy   []string `yaml:"pre_deploy,omitempty"`
+	PostDeploy  []string `yaml:"post_deploy,omitempty"`
+	PostDestroy []string `yaml:"post_destroy,omitempty"`
+}
+
+// Clone returns a deep copy of the hooks
+func (h GroupHooks) Clone() GroupHooks {
+	return GroupHooks{
+		PreDeploy:   slices.Clone(h.PreDeploy),
+		PostDeploy:  slices.Clone(h.PostDeploy),
+		PostDestroy: slices.Clone(h.PostDestroy),
+	}
+}
+
+// empty returns whether no hook is set
+func (h GroupHooks) empty() bool {
+	return len(h.PreDeploy) == 0 && len(h.PostDeploy) == 0 && len(h.PostDestroy) == 0
+}
+
+// checkGroupHooks ensures that no hook command is empty
+func checkGroupHooks(groups []DeploymentGroup) error {
+	for _, g := range groups {
+		for kind, cmds := range map[string][]string{
+			"pre_deploy":   g.Hooks.PreDeploy,
+			"post_deploy":  g.Hooks.PostDeploy,
+			"post_destroy": g.Hooks.PostDestroy,
+		} {
+			for i, c := range cmds {
+				if strings.TrimSpace(c) == "" {
+					return fmt.Errorf("hook %s[%d] of group %s: command must not be empty", kind, i, g.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+)
+
+func TestGroupHooksYAML(t *testing.T) {
+	in := `
+group: primary
+modules: []
+hooks:
+  pre_deploy:
+  - ./check-quota.sh
+  post_deploy:
+  - curl -X POST https://cmdb.example.com/clusters -d "$GHPC_DEPLOYMENT_NAME"
+`
+	var g DeploymentGroup
+	if err := yaml.Unmarshal([]byte(in), &g); err != nil {
+		t.Fatal(err)
+	}
+	want := GroupHooks{
+		PreDeploy:  []string{"./check-quota.sh"},
+		PostDeploy: []string{`curl -X POST https://cmdb.example.com/clusters -d "$GHPC_DEPLOYMENT_NAME"`},
+	}
+	if diff := cmp.Diff(want, g.Hooks); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// groups without hooks are written without them
+	out, err := yaml.Marshal(DeploymentGroup{Name: "primary"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "hooks") {
+		t.Errorf("got unexpected hooks in YAML:\n%s", out)
+	}
+}
+
+func TestCheckGroupHooks(t *testing.T) {
+	ok := []DeploymentGroup{{Name: "primary", Hooks: GroupHooks{PostDestroy: []string{"echo bye"}}}}
+	if err := checkGroupHooks(ok); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+	bad := []DeploymentGroup{{Name: "primary", Hooks: GroupHooks{PostDeploy: []string{"echo hi", " "}}}}
+	if err := checkGroupHooks(bad); err == nil {
+		t.Error("expected an error for an empty command")
+	}
+}
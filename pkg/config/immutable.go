@@ -0,0 +1,139 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ImmutableChange is a change to a module setting that forces the recreation
+// of the module resources when applied to an existing deployment
+type ImmutableChange struct {
+	Group   GroupName
+	Module  ModuleID
+	Setting string
+}
+
+func (c ImmutableChange) String() string {
+	return fmt.Sprintf("group %s, module %s: changing setting %q will recreate its resources",
+		c.Group, c.Module, c.Setting)
+}
+
+// settingValue returns a comparable form of a module setting: expressions that
+// only reference deployment variables are evaluated against bp, other
+// expressions (e.g. module outputs) are compared by their text
+func settingValue(bp Blueprint, v cty.Value) cty.Value {
+	r, _ := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		e, is := IsExpressionValue(v)
+		if !is {
+			return v, nil
+		}
+		for _, r := range e.References() {
+			if !r.GlobalVar {
+				return e.makeYamlExpressionValue(), nil
+			}
+		}
+		if ev, err := e.Eval(bp); err == nil {
+			return ev, nil
+		}
+		return e.makeYamlExpressionValue(), nil
+	})
+	return r
+}
+
+// ImmutableChanges compares the blueprint with the blueprint of an existing
+// deployment and returns the changes to immutable settings of modules in the
+// groups for which deployed returns true. Modules that are added, removed or
+// whose source or version changed are not reported.
+func (bp Blueprint) ImmutableChanges(prev Blueprint, deployed func(GroupName) bool) []ImmutableChange {
+	changes := []ImmutableChange{}
+	for _, g := range bp.DeploymentGroups {
+		if g.Kind != TerraformKind || !deployed(g.Name) {
+			continue
+		}
+		for _, m := range g.Modules {
+			pm, err := prev.Module(m.ID)
+			if err != nil || pm.Source != m.Source || pm.Version != m.Version {
+				continue
+			}
+			for _, input := range m.InfoOrDie().Inputs {
+				if !input.Immutable {
+					continue
+				}
+				set, wasSet := m.Settings.Has(input.Name), pm.Settings.Has(input.Name)
+				if !set && !wasSet {
+					continue
+				}
+				changed := set != wasSet
+				if !changed {
+					cur := settingValue(bp, m.Settings.Get(input.Name))
+					old := settingValue(prev, pm.Settings.Get(input.Name))
+					changed = !cur.RawEquals(old)
+				}
+				if changed {
+					changes = append(changes, ImmutableChange{g.Name, m.ID, input.Name})
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// BackendChange is a change to the Terraform backend of a deployment group,
+// whose state must be migrated to the new backend
+type BackendChange struct {
+	Group GroupName
+	// From and To are the types of the backends, "local" if not set
+	From string
+	To   string
+}
+
+func (c BackendChange) String() string {
+	if c.From == c.To {
+		return fmt.Sprintf("group %s: the configuration of its %s backend changed", c.Group, c.To)
+	}
+	return fmt.Sprintf("group %s: its backend changed from %s to %s", c.Group, c.From, c.To)
+}
+
+func backendType(b TerraformBackend) string {
+	if b.Type == "" {
+		return "local"
+	}
+	return b.Type
+}
+
+// BackendChanges compares the blueprint with the blueprint of an existing
+// deployment and returns the changes to the Terraform backends of the groups
+// for which deployed returns true
+func (bp Blueprint) BackendChanges(prev Blueprint, deployed func(GroupName) bool) []BackendChange {
+	changes := []BackendChange{}
+	for _, g := range bp.DeploymentGroups {
+		if g.Kind != TerraformKind || !deployed(g.Name) {
+			continue
+		}
+		pg, err := prev.Group(g.Name)
+		if err != nil || pg.Kind != TerraformKind {
+			continue
+		}
+		cur, old := g.TerraformBackend, pg.TerraformBackend
+		if cur.Type == old.Type && cur.Configuration.AsObject().RawEquals(old.Configuration.AsObject()) {
+			continue
+		}
+		changes = append(changes, BackendChange{Group: g.Name, From: backendType(old), To: backendType(cur)})
+	}
+	return changes
+}
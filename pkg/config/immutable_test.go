@@ -0,0 +1,130 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestImmutableChanges(t *testing.T) {
+	vm := Module{ID: "vm", Source: "./modules/immutable-vm", Kind: TerraformKind}
+	setTestModuleInfo(vm, modulereader.ModuleInfo{
+		Inputs: []modulereader.VarInfo{
+			{Name: "zone", Immutable: true},
+			{Name: "name_prefix", Immutable: true},
+			{Name: "network", Immutable: true},
+			{Name: "machine_type"},
+		},
+	})
+	blueprint := func(zone string, machineType string, namePrefix cty.Value) Blueprint {
+		m := vm
+		m.Settings = NewDict(map[string]cty.Value{
+			"zone":         GlobalRef("zone").AsExpression().AsValue(),
+			"machine_type": cty.StringVal(machineType),
+			"network":      ModuleRef("net", "network_self_link").AsExpression().AsValue(),
+		})
+		if namePrefix != cty.NilVal {
+			m.Settings.Set("name_prefix", namePrefix)
+		}
+		return Blueprint{
+			Vars: NewDict(map[string]cty.Value{"zone": cty.StringVal(zone)}),
+			DeploymentGroups: []DeploymentGroup{
+				{Name: "primary", Kind: TerraformKind, Modules: []Module{m}},
+			},
+		}
+	}
+	deployed := func(GroupName) bool { return true }
+
+	prev := blueprint("us-central1-a", "c2-standard-60", cty.NilVal)
+	type test struct {
+		name string
+		bp   Blueprint
+		want []string
+	}
+	tests := []test{
+		{"unchanged", blueprint("us-central1-a", "c2-standard-60", cty.NilVal), nil},
+		{"mutable setting", blueprint("us-central1-a", "c2-standard-30", cty.NilVal), nil},
+		{"immutable via var", blueprint("us-central1-b", "c2-standard-60", cty.NilVal), []string{"zone"}},
+		{"immutable set", blueprint("us-central1-a", "c2-standard-60", cty.StringVal("vm")), []string{"name_prefix"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := []string{}
+			for _, c := range tc.bp.ImmutableChanges(prev, deployed) {
+				got = append(got, c.Setting)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got changes %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("got changes %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+
+	changed := blueprint("us-central1-b", "c2-standard-60", cty.NilVal)
+	if got := changed.ImmutableChanges(prev, func(GroupName) bool { return false }); len(got) != 0 {
+		t.Errorf("expected no changes for groups that are not deployed, got %v", got)
+	}
+}
+
+func TestBackendChanges(t *testing.T) {
+	gcs := func(bucket string) TerraformBackend {
+		return TerraformBackend{Type: "gcs", Configuration: NewDict(map[string]cty.Value{
+			"bucket": cty.StringVal(bucket),
+			"prefix": cty.StringVal("golden/primary"),
+		})}
+	}
+	blueprint := func(b TerraformBackend) Blueprint {
+		return Blueprint{DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Kind: TerraformKind, TerraformBackend: b},
+			{Name: "image", Kind: PackerKind},
+		}}
+	}
+	deployed := func(GroupName) bool { return true }
+
+	type test struct {
+		name string
+		prev TerraformBackend
+		cur  TerraformBackend
+		want []BackendChange
+	}
+	tests := []test{
+		{"unchanged", gcs("state"), gcs("state"), []BackendChange{}},
+		{"local to gcs", TerraformBackend{}, gcs("state"), []BackendChange{{Group: "primary", From: "local", To: "gcs"}}},
+		{"bucket renamed", gcs("state"), gcs("state-2"), []BackendChange{{Group: "primary", From: "gcs", To: "gcs"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := blueprint(tc.cur).BackendChanges(blueprint(tc.prev), deployed)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// groups that are not deployed have no state to migrate
+	got := blueprint(gcs("state")).BackendChanges(blueprint(TerraformBackend{}), func(GroupName) bool { return false })
+	if len(got) != 0 {
+		t.Errorf("got unexpected changes %v", got)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"hpc-toolkit/pkg/diagnostics"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// LabelPolicy constrains the labels of the deployment and of its modules; it
+// is enforced when the blueprint is expanded
+type LabelPolicy struct {
+	// Required are the keys every module taking labels must be labeled with
+	Required []string `yaml:"required,omitempty"`
+	// Allowed maps keys to regular expressions their values must fully match
+	Allowed map[string]string `yaml:"allowed,omitempty"`
+	// Denied are the keys no label may use
+	Denied []string `yaml:"denied,omitempty"`
+	// Sanitize lowercases keys and values and replaces their invalid
+	// characters on Google Cloud instead of failing
+	Sanitize bool `yaml:"sanitize,omitempty"`
+}
+
+// Clone returns a deep copy of the label policy
+func (p *LabelPolicy) Clone() *LabelPolicy {
+	if p == nil {
+		return nil
+	}
+	c := *p
+	c.Required = slices.Clone(p.Required)
+	c.Denied = slices.Clone(p.Denied)
+	if p.Allowed != nil {
+		c.Allowed = maps.Clone(p.Allowed)
+	}
+	return &c
+}
+
+// DefaultToolkitLabelPrefix is the prefix of the labels ghpc adds, such as
+// ghpc_deployment
+const DefaultToolkitLabelPrefix = "ghpc_"
+
+// ToolkitLabels controls the labels ghpc adds: the blueprint and deployment
+// names to the deployment labels and the role to the labels of each module
+type ToolkitLabels struct {
+	// Disabled stops ghpc from adding labels
+	Disabled bool `yaml:"disabled,omitempty"`
+	// Prefix of the keys of the labels, "ghpc_" if not set
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+// Key returns the key of the toolkit label name, e.g. ghpc_role for role
+func (t ToolkitLabels) Key(name string) string {
+	if t.Prefix == "" {
+		return DefaultToolkitLabelPrefix + name
+	}
+	return t.Prefix + name
+}
+
+var toolkitLabelPrefixExp = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// checkToolkitLabels ensures that the keys of the toolkit labels are valid
+// label keys
+func checkToolkitLabels(t ToolkitLabels) error {
+	if t.Prefix == "" {
+		return nil
+	}
+	if !toolkitLabelPrefixExp.MatchString(t.Prefix) {
+		return fmt.Errorf("toolkit_labels: prefix %q must start with a lowercase letter and contain only lowercase letters, digits, underscores and dashes", t.Prefix)
+	}
+	if k := t.Key(deploymentLabel); len(k) > maxLabelLength {
+		return fmt.Errorf("toolkit_labels: prefix %q is too long, label %q is longer than %d characters", t.Prefix, k, maxLabelLength)
+	}
+	return nil
+}
+
+// Label merge strategies of modules, set by label_merge
+const (
+	// LabelMergeModule merges the deployment labels into those of the module,
+	// the labels of the module win; the default
+	LabelMergeModule = "module"
+	// LabelMergeDeployment merges the deployment labels into those of the
+	// module, the labels of the deployment win
+	LabelMergeDeployment = "deployment"
+	// LabelMergeNone labels the module with its own labels only
+	LabelMergeNone = "none"
+)
+
+var labelMergeStrategies = []string{LabelMergeModule, LabelMergeDeployment, LabelMergeNone}
+
+// Google Cloud labels are at most 63 lowercase letters, digits, underscores
+// and dashes; keys start with a letter
+const maxLabelLength = 63
+
+var (
+	labelKeyExp       = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueExp     = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+	invalidLabelChars = regexp.MustCompile(`[^a-z0-9_-]`)
+)
+
+// checkLabelPolicy ensures that the patterns of the label policy are valid
+// regular expressions and that modules use known merge strategies
+func checkLabelPolicy(bp Blueprint) error {
+	if p := bp.LabelPolicy; p != nil {
+		for _, k := range p.Denied {
+			if slices.Contains(p.Required, k) {
+				return fmt.Errorf("label_policy: label %q is both required and denied", k)
+			}
+		}
+		for k, re := range p.Allowed {
+			if _, err := regexp.Compile(re); err != nil {
+				return fmt.Errorf("label_policy: invalid pattern %q of label %q: %v", re, k, err)
+			}
+		}
+	}
+	var err error
+	bp.WalkModules(func(m *Module) error {
+		if m.LabelMerge != "" && !slices.Contains(labelMergeStrategies, m.LabelMerge) {
+			err = fmt.Errorf("module %s: label_merge must be one of %s, got %q",
+				m.ID, strings.Join(labelMergeStrategies, ", "), m.LabelMerge)
+		}
+		return err
+	})
+	return err
+}
+
+// labelEnforcer applies the label policy of a blueprint and collects its
+// violations
+type labelEnforcer struct {
+	dc         DeploymentConfig
+	policy     LabelPolicy
+	enabled    bool
+	gcp        bool
+	allowed    map[string]*regexp.Regexp
+	violations []string
+}
+
+func newLabelEnforcer(dc DeploymentConfig) *labelEnforcer {
+	e := labelEnforcer{dc: dc, allowed: map[string]*regexp.Regexp{}}
+	if dc.Config.LabelPolicy == nil {
+		return &e
+	}
+	e.enabled = true
+	e.policy = *dc.Config.LabelPolicy
+	e.gcp = dc.Config.TargetCloud().Name == GCP
+	for k, re := range e.policy.Allowed {
+		// patterns were compiled by checkLabelPolicy
+		e.allowed[k] = regexp.MustCompile(`^(?:` + re + `)$`)
+	}
+	return &e
+}
+
+func (e *labelEnforcer) violate(subject string, format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	e.violations = append(e.violations, msg)
+	e.dc.report("label_policy", diagnostics.Error, msg, subject)
+}
+
+// enforce returns the labels of owner, sanitized if the policy sanitizes
+// labels, and records the labels violating the policy. Only the values of
+// literal labels are checked, expressions are evaluated by Terraform.
+func (e *labelEnforcer) enforce(owner string, subject string, labels map[string]cty.Value) map[string]cty.Value {
+	if !e.enabled {
+		return labels
+	}
+	keys := maps.Keys(labels)
+	slices.Sort(keys)
+	r := map[string]cty.Value{}
+	from := map[string]string{}
+	for _, k := range keys {
+		v, key := labels[k], e.checkChars(owner, subject, "key", k)
+		if prev, ok := from[key]; ok {
+			e.violate(subject, "labels %q and %q of %s are both sanitized to %q", prev, k, owner, key)
+			continue
+		}
+		from[key] = k
+		if slices.Contains(e.policy.Denied, key) {
+			e.violate(subject, "%s uses denied label %q", owner, key)
+		}
+		if s, ok := literalString(v); ok {
+			s = e.checkChars(owner, subject, fmt.Sprintf("value of label %q", key), s)
+			if re, ok := e.allowed[key]; ok && !re.MatchString(s) {
+				e.violate(subject, "value %q of label %q of %s does not match %q", s, key, owner, e.policy.Allowed[key])
+			}
+			v = cty.StringVal(s)
+		}
+		r[key] = v
+	}
+	return r
+}
+
+// checkChars sanitizes a key or value of a Google Cloud label if the policy
+// sanitizes labels, or records it as a violation if it is invalid
+func (e *labelEnforcer) checkChars(owner string, subject string, what string, s string) string {
+	if !e.gcp {
+		return s
+	}
+	isKey := what == "key"
+	valid := labelValueExp.MatchString(s)
+	if isKey {
+		valid = labelKeyExp.MatchString(s)
+	}
+	if valid {
+		return s
+	}
+	if !e.policy.Sanitize {
+		e.violate(subject, "%s of %s: %q is not a valid label %s", what, owner, s, what)
+		return s
+	}
+	c := sanitizeLabel(s, isKey)
+	e.dc.report("label_policy", diagnostics.Warning,
+		fmt.Sprintf("%s of %s: sanitized %q to %q", what, owner, s, c), subject)
+	return c
+}
+
+// require records the required labels missing from the effective labels of
+// a module
+func (e *labelEnforcer) require(mod Module, labels map[string]cty.Value) {
+	for _, k := range e.policy.Required {
+		if _, ok := labels[k]; !ok {
+			e.violate("modules."+string(mod.ID), "module %s is missing required label %q", mod.ID, k)
+		}
+	}
+}
+
+func (e *labelEnforcer) err() error {
+	if len(e.violations) == 0 {
+		return nil
+	}
+	return errors.New("labels violate label_policy:\n  - " + strings.Join(e.violations, "\n  - "))
+}
+
+// sanitizeLabel lowercases s, replaces the characters not allowed in Google
+// Cloud labels with underscores and truncates it; keys not starting with a
+// letter are prefixed with "l"
+func sanitizeLabel(s string, isKey bool) string {
+	s = invalidLabelChars.ReplaceAllString(strings.ToLower(s), "_")
+	if isKey && (s == "" || s[0] < 'a' || s[0] > 'z') {
+		s = "l" + s
+	}
+	if len(s) > maxLabelLength {
+		s = s[:maxLabelLength]
+	}
+	return s
+}
+
+// literalString returns the value of v if it is a known string rather than an
+// expression
+func literalString(v cty.Value) (string, bool) {
+	if v.IsMarked() || !v.IsKnown() || v.IsNull() || v.Type() != cty.String {
+		return "", false
+	}
+	return v.AsString(), true
+}
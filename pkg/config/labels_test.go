@@ -0,0 +1,237 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/diagnostics"
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func labelPolicyConfig(policy *LabelPolicy, labels map[string]cty.Value, mods ...Module) DeploymentConfig {
+	for _, m := range mods {
+		setTestModuleInfo(m, modulereader.ModuleInfo{Inputs: []modulereader.VarInfo{{Name: "labels"}}})
+	}
+	return DeploymentConfig{Config: Blueprint{
+		BlueprintName: "policy",
+		Vars: NewDict(map[string]cty.Value{
+			"deployment_name": cty.StringVal("golden"),
+			"labels":          cty.ObjectVal(labels),
+		}),
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: mods}},
+		LabelPolicy:      policy,
+	}}
+}
+
+func TestCheckLabelPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		policy  *LabelPolicy
+		merge   string
+		wantErr string
+	}{
+		{"none", nil, "", ""},
+		{"valid", &LabelPolicy{Required: []string{"team"}, Allowed: map[string]string{"env": "dev|prod"}}, LabelMergeNone, ""},
+		{"bad pattern", &LabelPolicy{Allowed: map[string]string{"env": "("}}, "", "invalid pattern"},
+		{"required and denied", &LabelPolicy{Required: []string{"team"}, Denied: []string{"team"}}, "", "both required and denied"},
+		{"bad merge", nil, "both", "label_merge must be one of"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := Blueprint{
+				DeploymentGroups: []DeploymentGroup{{Modules: []Module{{ID: "net", LabelMerge: tc.merge}}}},
+				LabelPolicy:      tc.policy,
+			}
+			err := checkLabelPolicy(bp)
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("got unexpected error: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("got error %v, want error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLabelPolicyViolations(t *testing.T) {
+	policy := &LabelPolicy{
+		Required: []string{"team"},
+		Allowed:  map[string]string{"env": "dev|prod"},
+		Denied:   []string{"owner"},
+	}
+	net := Module{ID: "net", Kind: TerraformKind, Source: "./labels/net", Settings: NewDict(map[string]cty.Value{
+		"labels": cty.ObjectVal(map[string]cty.Value{
+			"owner": cty.StringVal("alice"),
+			"Env":   cty.StringVal("dev"),
+		}),
+	})}
+	dc := labelPolicyConfig(policy, map[string]cty.Value{"env": cty.StringVal("staging")}, net)
+	var got []diagnostics.Diagnostic
+	dc.Report = func(d diagnostics.Diagnostic) { got = append(got, d) }
+
+	err := dc.combineLabels()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	msgs := []string{}
+	for _, d := range got {
+		if d.RuleID != "label_policy" || d.Severity != diagnostics.Error {
+			t.Errorf("got diagnostic %#v", d)
+		}
+		msgs = append(msgs, d.Message)
+	}
+	want := []string{
+		`value "staging" of label "env" of the deployment does not match "dev|prod"`,
+		`key of module net: "Env" is not a valid label key`,
+		`module net uses denied label "owner"`,
+		`module net is missing required label "team"`,
+	}
+	if diff := cmp.Diff(want, msgs); diff != "" {
+		t.Errorf("diff of diagnostics (-want +got):\n%s", diff)
+	}
+	for _, m := range want {
+		if !strings.Contains(err.Error(), m) {
+			t.Errorf("error %q does not contain %q", err, m)
+		}
+	}
+}
+
+func TestLabelPolicySanitize(t *testing.T) {
+	policy := &LabelPolicy{Required: []string{"team"}, Sanitize: true}
+	pkr := Module{ID: "image", Kind: PackerKind, Source: "./labels/image", Settings: NewDict(map[string]cty.Value{
+		"labels": cty.ObjectVal(map[string]cty.Value{"9lives": cty.StringVal("Cat.Food")}),
+	})}
+	dc := labelPolicyConfig(policy, map[string]cty.Value{"Team": cty.StringVal("HPC Ops")}, pkr)
+	dc.Config.Vars.Set("deployment_name", cty.StringVal("Golden"))
+	warnings := 0
+	dc.Report = func(d diagnostics.Diagnostic) {
+		if d.Severity != diagnostics.Warning {
+			t.Errorf("got diagnostic %#v", d)
+		}
+		warnings++
+	}
+
+	if err := dc.combineLabels(); err != nil {
+		t.Fatal(err)
+	}
+	if warnings != 5 {
+		t.Errorf("got %d warnings, want 5", warnings)
+	}
+	want := cty.ObjectVal(map[string]cty.Value{
+		"ghpc_blueprint":  cty.StringVal("policy"),
+		"ghpc_deployment": cty.StringVal("golden"),
+		"ghpc_role":       cty.StringVal("labels"),
+		"team":            cty.StringVal("hpc_ops"),
+		"l9lives":         cty.StringVal("cat_food"),
+	})
+	got := dc.Config.DeploymentGroups[0].Modules[0].Settings.Get("labels")
+	if !got.RawEquals(want) {
+		t.Errorf("got labels %#v, want %#v", got, want)
+	}
+}
+
+func TestLabelMerge(t *testing.T) {
+	modLabels := cty.ObjectVal(map[string]cty.Value{"env": cty.StringVal("dev"), "ghpc_role": cty.StringVal("net")})
+	merged := func(env string) cty.Value {
+		return cty.ObjectVal(map[string]cty.Value{
+			"env":             cty.StringVal(env),
+			"ghpc_role":       cty.StringVal("net"),
+			"ghpc_blueprint":  cty.StringVal("policy"),
+			"ghpc_deployment": cty.StringVal("golden"),
+		})
+	}
+	ref := GlobalRef("labels").AsExpression().AsValue()
+	for _, tc := range []struct {
+		merge string
+		kind  ModuleKind
+		want  cty.Value
+		wrap  bool
+	}{
+		{"", TerraformKind, cty.TupleVal([]cty.Value{ref, modLabels}), true},
+		{LabelMergeDeployment, TerraformKind, cty.TupleVal([]cty.Value{modLabels, ref}), true},
+		{LabelMergeNone, TerraformKind, modLabels, false},
+		{"", PackerKind, merged("dev"), false},
+		{LabelMergeDeployment, PackerKind, merged("prod"), false},
+		{LabelMergeNone, PackerKind, modLabels, false},
+	} {
+		t.Run(tc.merge+"/"+tc.kind.String(), func(t *testing.T) {
+			m := Module{ID: "net", Kind: tc.kind, Source: "./labels/merge", LabelMerge: tc.merge,
+				Settings: NewDict(map[string]cty.Value{"labels": modLabels})}
+			dc := labelPolicyConfig(nil, map[string]cty.Value{"env": cty.StringVal("prod")}, m)
+			if err := dc.combineLabels(); err != nil {
+				t.Fatal(err)
+			}
+			got := dc.Config.DeploymentGroups[0].Modules[0]
+			if l := got.Settings.Get("labels"); !l.RawEquals(tc.want) {
+				t.Errorf("got labels %#v, want %#v", l, tc.want)
+			}
+			if _, ok := got.WrapSettings["labels"]; ok != tc.wrap {
+				t.Errorf("got wrapped %t, want %t", ok, tc.wrap)
+			}
+		})
+	}
+}
+
+func TestToolkitLabels(t *testing.T) {
+	m := Module{ID: "net", Kind: TerraformKind, Source: "./network/vpc"}
+	for _, tc := range []struct {
+		name   string
+		labels ToolkitLabels
+		want   map[string]cty.Value
+		role   map[string]cty.Value
+	}{
+		{"default", ToolkitLabels{},
+			map[string]cty.Value{"ghpc_blueprint": cty.StringVal("policy"), "ghpc_deployment": cty.StringVal("golden")},
+			map[string]cty.Value{"ghpc_role": cty.StringVal("network")}},
+		{"prefix", ToolkitLabels{Prefix: "acme-"},
+			map[string]cty.Value{"acme-blueprint": cty.StringVal("policy"), "acme-deployment": cty.StringVal("golden")},
+			map[string]cty.Value{"acme-role": cty.StringVal("network")}},
+		{"disabled", ToolkitLabels{Disabled: true}, map[string]cty.Value{}, map[string]cty.Value{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dc := labelPolicyConfig(nil, map[string]cty.Value{}, m)
+			dc.Config.ToolkitLabels = tc.labels
+			if err := dc.combineLabels(); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := dc.Config.Vars.Get("labels"), cty.ObjectVal(tc.want); !got.RawEquals(want) {
+				t.Errorf("got deployment labels %#v, want %#v", got, want)
+			}
+			got := dc.Config.DeploymentGroups[0].Modules[0].Settings.Get("labels").AsValueSlice()[1]
+			if want := cty.ObjectVal(tc.role); !got.RawEquals(want) {
+				t.Errorf("got module labels %#v, want %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckToolkitLabels(t *testing.T) {
+	for prefix, valid := range map[string]bool{
+		"":                      true,
+		"acme_":                 true,
+		"Acme_":                 false,
+		"1acme":                 false,
+		strings.Repeat("a", 53): true,
+		strings.Repeat("a", 54): false,
+	} {
+		if err := checkToolkitLabels(ToolkitLabels{Prefix: prefix}); (err == nil) != valid {
+			t.Errorf("prefix %q: got error %v, want valid %t", prefix, err, valid)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/offline"
+	"hpc-toolkit/pkg/sourcereader"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LockfileName is the name of the lockfile, written next to the blueprint
+const LockfileName = "ghpc.lock"
+
+const lockfileHeader = "# This file is maintained by ghpc, manual edits may be lost.\n" +
+	"# It records what module sources were resolved to; commit it alongside\n" +
+	"# the blueprint to create reproducible deployments.\n"
+
+// replaced in tests
+var (
+	resolveRegistryVersion = sourcereader.ResolveRegistryVersion
+	resolveSource          = sourcereader.Resolve
+)
+
+// LockedModule records what a non-local module source was resolved to: the
+// exact version a version constraint of a Terraform Registry module resolved
+// to, the commit of a git source, and the checksum of the module files
+type LockedModule struct {
+	Source     string `yaml:"source"`
+	Constraint string `yaml:"constraint,omitempty"`
+	Version    string `yaml:"version,omitempty"`
+	Commit     string `yaml:"commit,omitempty"`
+	Checksum   string `yaml:"checksum,omitempty"`
+}
+
+// Lockfile records how module sources were resolved, so that later runs use
+// the same versions, or fail if Frozen and sources resolve differently.
+// Blueprints may share a lockfile.
+type Lockfile struct {
+	Modules []LockedModule `yaml:"modules"`
+	// Frozen lockfiles are never changed: resolutions that are missing from
+	// the lockfile or that differ from it are errors
+	Frozen  bool `yaml:"-"`
+	changed bool
+}
+
+// mismatch returns an error for a resolution that differs from the lockfile
+func mismatch(format string, a ...interface{}) error {
+	return errcode.New(errcode.LockfileMismatch, fmt.Errorf(format, a...))
+}
+
+// ReadLockfile reads the lockfile at path, a missing file is an empty lockfile
+func ReadLockfile(path string) (Lockfile, error) {
+	var l Lockfile
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return l, fmt.Errorf("failed to read lockfile %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(b, &l); err != nil {
+		return l, fmt.Errorf("failed to parse lockfile %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Changed returns true if resolutions were added since the lockfile was read
+func (l Lockfile) Changed() bool {
+	return l.changed
+}
+
+// Write writes the lockfile to path
+func (l Lockfile) Write(path string) error {
+	mods := append([]LockedModule{}, l.Modules...)
+	sort.Slice(mods, func(i, j int) bool {
+		if mods[i].Source != mods[j].Source {
+			return mods[i].Source < mods[j].Source
+		}
+		return mods[i].Constraint < mods[j].Constraint
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(lockfileHeader)
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(Lockfile{Modules: mods})
+	encoder.Close()
+	if err != nil {
+		return fmt.Errorf("failed to encode lockfile: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write lockfile %s: %w", path, err)
+	}
+	return nil
+}
+
+// entry returns the entry of a source and version constraint, nil if none
+func (l *Lockfile) entry(source string, constraint string) *LockedModule {
+	for i, m := range l.Modules {
+		if m.Source == source && m.Constraint == constraint {
+			return &l.Modules[i]
+		}
+	}
+	return nil
+}
+
+func (l *Lockfile) record(m LockedModule) *LockedModule {
+	l.Modules = append(l.Modules, m)
+	l.changed = true
+	return &l.Modules[len(l.Modules)-1]
+}
+
+// checkModuleVersions ensures that versions are only set for Terraform
+// Registry modules
+func checkModuleVersions(bp Blueprint) error {
+	return bp.WalkModules(func(m *Module) error {
+		if m.Version == "" {
+			return nil
+		}
+		if !sourcereader.IsRegistryPath(m.Source) || m.Kind != TerraformKind {
+			return fmt.Errorf("%s: module %s", errorMessages["versionNotRegistry"], m.ID)
+		}
+		return nil
+	})
+}
+
+// checkModuleIntegrity ensures that the integrity of modules can be verified
+func checkModuleIntegrity(bp Blueprint) error {
+	return bp.WalkModules(func(m *Module) error {
+		if m.Integrity == nil {
+			return nil
+		}
+		if err := m.Integrity.Check(); err != nil {
+			return fmt.Errorf("module %s: %v", m.ID, err)
+		}
+		return nil
+	})
+}
+
+// ResolveModuleVersions pins the version of Terraform Registry modules to an
+// exact version: the version recorded in the lockfile for the same source and
+// constraint, or else the latest version matching the constraint, which is
+// then recorded in the lockfile. Modules without a version are pinned to the
+// latest release.
+func (bp *Blueprint) ResolveModuleVersions(lock *Lockfile) error {
+	return bp.WalkModules(func(m *Module) error {
+		if !sourcereader.IsRegistryPath(m.Source) {
+			return nil
+		}
+		constraint := strings.TrimSpace(m.Version)
+		if e := lock.entry(m.Source, constraint); e != nil {
+			m.Version = e.Version
+			return nil
+		}
+		if lock.Frozen {
+			return mismatch("module %s: %s with version %q is missing from the lockfile", m.ID, m.Source, constraint)
+		}
+		v, exact := sourcereader.ExactVersion(constraint)
+		if !exact {
+			var err error
+			if v, err = resolveRegistryVersion(m.Source, constraint); err != nil {
+				return fmt.Errorf("failed to resolve version of module %s: %w", m.ID, err)
+			}
+		}
+		lock.record(LockedModule{Source: m.Source, Constraint: constraint, Version: v})
+		m.Version = v
+		return nil
+	})
+}
+
+// LockModuleSources records the commit and checksum that the sources of
+// non-local modules resolve to in the lockfile, after ResolveModuleVersions
+// pinned the versions of Terraform Registry modules. Changed resolutions are
+// updated with a warning, or are errors if the lockfile is frozen.
+func (bp Blueprint) LockModuleSources(lock *Lockfile) error {
+	seen := map[string]bool{}
+	return bp.WalkModules(func(m *Module) error {
+		registry := sourcereader.IsRegistryPath(m.Source)
+		if !registry && !sourcereader.IsRemotePath(m.Source) {
+			return nil
+		}
+		modPath := m.infoSource()
+		if seen[modPath] {
+			return nil
+		}
+		seen[modPath] = true
+
+		res, err := resolveSource(modPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve source of module %s: %w", m.ID, err)
+		}
+
+		entries := []*LockedModule{}
+		if registry {
+			// all version constraints that resolved to the pinned version
+			for i, e := range lock.Modules {
+				if e.Source == m.Source && e.Version == m.Version {
+					entries = append(entries, &lock.Modules[i])
+				}
+			}
+		} else if e := lock.entry(m.Source, ""); e != nil {
+			entries = append(entries, e)
+		}
+		if len(entries) == 0 {
+			if lock.Frozen {
+				return mismatch("module %s: %s is missing from the lockfile", m.ID, m.Source)
+			}
+			entries = append(entries, lock.record(LockedModule{Source: m.Source, Version: m.Version}))
+		}
+
+		for _, e := range entries {
+			commit := res.Commit
+			if offline.Enabled && commit == "" {
+				commit = e.Commit // commits are not resolved offline
+			}
+			if e.Commit == commit && e.Checksum == res.Checksum {
+				continue
+			}
+			if lock.Frozen {
+				return mismatch("module %s: %s resolved to commit %q and checksum %q, the lockfile records commit %q and checksum %q",
+					m.ID, m.Source, commit, res.Checksum, e.Commit, e.Checksum)
+			}
+			if e.Checksum != "" || e.Commit != "" {
+				log.Printf("WARNING: module %s: %s changed since it was recorded in the lockfile, updating it", m.ID, m.Source)
+			}
+			e.Commit, e.Checksum = commit, res.Checksum
+			lock.changed = true
+		}
+		return nil
+	})
+}
@@ -0,0 +1,232 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/sourcereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const registryNetwork = "registry.terraform.io/terraform-google-modules/network/google"
+
+func TestResolveModuleVersions(t *testing.T) {
+	resolved := []string{}
+	defer func(f func(string, string) (string, error)) { resolveRegistryVersion = f }(resolveRegistryVersion)
+	resolveRegistryVersion = func(source string, constraint string) (string, error) {
+		resolved = append(resolved, constraint)
+		switch constraint {
+		case "~> 7.0":
+			return "7.3.0", nil
+		case "":
+			return "8.0.0", nil
+		}
+		return "", fmt.Errorf("no version of %s matches constraint %q", source, constraint)
+	}
+
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{
+		{ID: "net0", Source: registryNetwork, Version: "~> 7.0"},
+		{ID: "net1", Source: registryNetwork, Version: "~> 7.0"},
+		{ID: "net2", Source: registryNetwork, Version: "= 6.0.0"},
+		{ID: "net3", Source: registryNetwork},
+		{ID: "vm", Source: "modules/compute/vm-instance"},
+	}}}}
+	lock := Lockfile{Modules: []LockedModule{{Source: registryNetwork, Version: "7.9.0"}}}
+	if err := bp.ResolveModuleVersions(&lock); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, m := range bp.DeploymentGroups[0].Modules {
+		got = append(got, m.Version)
+	}
+	if diff := cmp.Diff([]string{"7.3.0", "7.3.0", "6.0.0", "7.9.0", ""}, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	// only the constraint missing from the lockfile is resolved, once
+	if diff := cmp.Diff([]string{"~> 7.0"}, resolved); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if !lock.Changed() {
+		t.Error("expected lockfile to be changed")
+	}
+
+	path := filepath.Join(t.TempDir(), LockfileName)
+	if err := lock.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadLockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LockedModule{
+		{Source: registryNetwork, Version: "7.9.0"},
+		{Source: registryNetwork, Constraint: "= 6.0.0", Version: "6.0.0"},
+		{Source: registryNetwork, Constraint: "~> 7.0", Version: "7.3.0"},
+	}
+	if diff := cmp.Diff(want, read.Modules); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if read.Changed() {
+		t.Error("expected lockfile read from disk to be unchanged")
+	}
+
+	bp.DeploymentGroups[0].Modules[0].Version = "~> 9.0"
+	if err := bp.ResolveModuleVersions(&read); err == nil {
+		t.Error("expected error for unsatisfiable constraint")
+	}
+
+	read.Frozen = true
+	bp.DeploymentGroups[0].Modules[0].Version = "~> 8.0"
+	err = bp.ResolveModuleVersions(&read)
+	if errcode.Of(err) != errcode.LockfileMismatch {
+		t.Errorf("expected lockfile mismatch for new constraint of frozen lockfile, got %v", err)
+	}
+}
+
+func TestLockModuleSources(t *testing.T) {
+	const git = "github.com/org/repo//modules/vpc?ref=main"
+	const archive = "https://example.com/vpc.tar.gz"
+	resolutions := map[string]sourcereader.Resolution{
+		git:     {Commit: "1111111111111111111111111111111111111111", Checksum: "sha256:aa"},
+		archive: {Checksum: "sha256:bb"},
+		sourcereader.VersionedSource(registryNetwork, "7.3.0"): {Checksum: "sha256:cc"},
+	}
+	resolved := 0
+	defer func(f func(string) (sourcereader.Resolution, error)) { resolveSource = f }(resolveSource)
+	resolveSource = func(modPath string) (sourcereader.Resolution, error) {
+		resolved++
+		if r, ok := resolutions[modPath]; ok {
+			return r, nil
+		}
+		return sourcereader.Resolution{}, fmt.Errorf("unexpected source %s", modPath)
+	}
+
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{
+		{ID: "a", Source: git},
+		{ID: "b", Source: git},
+		{ID: "c", Source: archive},
+		{ID: "d", Source: registryNetwork, Version: "7.3.0"},
+		{ID: "e", Source: "modules/network/vpc"},
+		{ID: "f", Source: "./modules/vpc"},
+	}}}}
+	lock := Lockfile{Modules: []LockedModule{{Source: registryNetwork, Constraint: "~> 7.0", Version: "7.3.0"}}}
+	if err := bp.LockModuleSources(&lock); err != nil {
+		t.Fatal(err)
+	}
+	if resolved != 3 {
+		t.Errorf("expected each source to be resolved once, got %d resolutions", resolved)
+	}
+	want := []LockedModule{
+		{Source: registryNetwork, Constraint: "~> 7.0", Version: "7.3.0", Checksum: "sha256:cc"},
+		{Source: git, Commit: "1111111111111111111111111111111111111111", Checksum: "sha256:aa"},
+		{Source: archive, Checksum: "sha256:bb"},
+	}
+	if diff := cmp.Diff(want, lock.Modules); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// unchanged sources are accepted by a frozen lockfile
+	frozen := Lockfile{Modules: lock.Modules, Frozen: true}
+	if err := bp.LockModuleSources(&frozen); err != nil {
+		t.Fatal(err)
+	}
+	if frozen.Changed() {
+		t.Error("expected frozen lockfile to be unchanged")
+	}
+
+	// the branch moved
+	resolutions[git] = sourcereader.Resolution{Commit: "2222222222222222222222222222222222222222", Checksum: "sha256:dd"}
+	err := bp.LockModuleSources(&frozen)
+	if errcode.Of(err) != errcode.LockfileMismatch {
+		t.Errorf("expected lockfile mismatch, got %v", err)
+	}
+	if err := bp.LockModuleSources(&lock); err != nil {
+		t.Fatal(err)
+	}
+	if e := lock.entry(git, ""); e.Commit != "2222222222222222222222222222222222222222" {
+		t.Errorf("expected lockfile to be updated, got %#v", e)
+	}
+
+	// new sources are not accepted by a frozen lockfile
+	frozen = Lockfile{Frozen: true}
+	if err := bp.LockModuleSources(&frozen); errcode.Of(err) != errcode.LockfileMismatch {
+		t.Errorf("expected lockfile mismatch, got %v", err)
+	}
+}
+
+func TestReadMissingLockfile(t *testing.T) {
+	lock, err := ReadLockfile(filepath.Join(t.TempDir(), LockfileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(Lockfile{}, lock, cmpopts.IgnoreUnexported(Lockfile{})); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckModuleVersions(t *testing.T) {
+	type test struct {
+		mod Module
+		err bool
+	}
+	tests := []test{
+		{Module{ID: "a", Source: registryNetwork, Kind: TerraformKind, Version: "~> 7.0"}, false},
+		{Module{ID: "b", Source: registryNetwork, Kind: TerraformKind}, false},
+		{Module{ID: "c", Source: "modules/network/vpc", Kind: TerraformKind}, false},
+		{Module{ID: "d", Source: "modules/network/vpc", Kind: TerraformKind, Version: "1.0.0"}, true},
+		{Module{ID: "e", Source: registryNetwork, Kind: PackerKind, Version: "1.0.0"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.mod.ID), func(t *testing.T) {
+			bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Modules: []Module{tc.mod}}}}
+			err := checkModuleVersions(bp)
+			if tc.err != (err != nil) {
+				t.Errorf("got unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckModuleIntegrity(t *testing.T) {
+	type test struct {
+		mod Module
+		err bool
+	}
+	sum := "sha256:" + strings.Repeat("a", 64)
+	tests := []test{
+		{Module{ID: "a", Source: "modules/network/vpc"}, false},
+		{Module{ID: "b", Source: "modules/network/vpc", Integrity: &sourcereader.Integrity{SHA256: sum}}, false},
+		{Module{ID: "c", Source: "modules/network/vpc", Integrity: &sourcereader.Integrity{}}, true},
+		{Module{ID: "d", Source: "modules/network/vpc", Integrity: &sourcereader.Integrity{SHA256: "abc"}}, true},
+		{Module{ID: "e", Source: "modules/network/vpc", Integrity: &sourcereader.Integrity{Signature: "c2ln"}}, true},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.mod.ID), func(t *testing.T) {
+			bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Modules: []Module{tc.mod}}}}
+			err := checkModuleIntegrity(bp)
+			if tc.err != (err != nil) {
+				t.Errorf("got unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// MaintenanceModuleID is the ID of the module injected into the last
+	// deployment group to create the maintenance schedule jobs
+	MaintenanceModuleID ModuleID = "maintenance_schedule"
+	maintenanceSource   string   = "community/modules/scripts/maintenance-schedule"
+)
+
+var scheduleNameExp = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// settings of a maintenance schedule, and the actions that require them
+var maintenanceSettings = map[string][]string{
+	"name":                   nil,
+	"schedule":               nil,
+	"time_zone":              nil,
+	"action":                 nil,
+	"instance_group_manager": {"scale-down", "scale-up"},
+	"size":                   {"scale-down", "scale-up"},
+	"build_trigger":          {"image-refresh"},
+}
+
+var maintenanceActions = []string{"scale-down", "scale-up", "image-refresh"}
+
+// literal returns the string value of setting k of s, which must be set and
+// must not be an expression
+func literal(s Dict, k string) (string, error) {
+	v := s.Get(k)
+	if v.IsNull() || v.Type() != cty.String {
+		return "", fmt.Errorf("%s must be a string", k)
+	}
+	return v.AsString(), nil
+}
+
+func checkMaintenanceSchedule(s Dict) error {
+	for k := range s.Items() {
+		if _, ok := maintenanceSettings[k]; !ok {
+			return fmt.Errorf("%s: %s", errorMessages["extraSetting"], k)
+		}
+	}
+	name, err := literal(s, "name")
+	if err != nil {
+		return err
+	}
+	if !scheduleNameExp.MatchString(name) {
+		return fmt.Errorf("name %q must contain only lowercase letters, numbers and dashes", name)
+	}
+	cron, err := literal(s, "schedule")
+	if err != nil {
+		return err
+	}
+	if len(strings.Fields(cron)) != 5 {
+		return fmt.Errorf("schedule %q of %s is not a valid cron expression", cron, name)
+	}
+	if s.Has("time_zone") {
+		if _, err := literal(s, "time_zone"); err != nil {
+			return err
+		}
+	}
+	action, err := literal(s, "action")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(maintenanceActions, action) {
+		return fmt.Errorf("action %q of %s must be one of %s", action, name, strings.Join(maintenanceActions, ", "))
+	}
+	for k, actions := range maintenanceSettings {
+		if slices.Contains(actions, action) && !s.Has(k) {
+			return fmt.Errorf("%s is required for action %s of %s", k, action, name)
+		}
+	}
+	return nil
+}
+
+// checkMaintenanceSchedules validates the maintenance_schedules block
+func (bp Blueprint) checkMaintenanceSchedules() error {
+	seen := map[string]bool{}
+	for i, s := range bp.MaintenanceSchedules {
+		if err := checkMaintenanceSchedule(s); err != nil {
+			return fmt.Errorf("invalid maintenance schedule #%d: %w", i, err)
+		}
+		name := s.Get("name").AsString()
+		if seen[name] {
+			return fmt.Errorf("maintenance schedule names must be unique: %s used more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// maintenanceGroup returns the index of the group the maintenance module is
+// added to: the last group of terraform modules, -1 if there is none
+func (bp Blueprint) maintenanceGroup() int {
+	for i := len(bp.DeploymentGroups) - 1; i >= 0; i-- {
+		g := bp.DeploymentGroups[i]
+		if len(g.Modules) > 0 && g.Modules[0].Kind == TerraformKind {
+			return i
+		}
+	}
+	return -1
+}
+
+// addMaintenanceModule adds the module creating the Cloud Scheduler jobs of
+// the maintenance schedules to the last terraform group, so that the jobs are
+// created after, and destroyed before, the resources they operate on. Blueprints
+// that already contain the module (e.g. an expanded blueprint) are left unchanged.
+func (bp *Blueprint) addMaintenanceModule() error {
+	if len(bp.MaintenanceSchedules) == 0 {
+		return nil
+	}
+	if err := bp.checkMaintenanceSchedules(); err != nil {
+		return err
+	}
+	if _, err := bp.Module(MaintenanceModuleID); err == nil {
+		return nil
+	}
+	gi := bp.maintenanceGroup()
+	if gi < 0 {
+		return fmt.Errorf("maintenance schedules require a deployment group of terraform modules")
+	}
+
+	schedules := []cty.Value{}
+	for _, s := range bp.MaintenanceSchedules {
+		o := map[string]cty.Value{
+			"time_zone":              cty.StringVal("Etc/UTC"),
+			"instance_group_manager": cty.StringVal(""),
+			"size":                   cty.NumberIntVal(0),
+			"build_trigger":          cty.StringVal(""),
+		}
+		for k, v := range s.Items() {
+			o[k] = v
+		}
+		schedules = append(schedules, cty.ObjectVal(o))
+	}
+
+	grp := &bp.DeploymentGroups[gi]
+	grp.Modules = append(grp.Modules, Module{
+		ID:       MaintenanceModuleID,
+		Source:   maintenanceSource,
+		Kind:     TerraformKind,
+		Settings: NewDict(map[string]cty.Value{"schedules": cty.TupleVal(schedules)}),
+	})
+	return nil
+}
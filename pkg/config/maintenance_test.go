@@ -0,0 +1,108 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func scaleDownSchedule() Dict {
+	return NewDict(map[string]cty.Value{
+		"name":                   cty.StringVal("nightly"),
+		"schedule":               cty.StringVal("0 20 * * 1-5"),
+		"action":                 cty.StringVal("scale-down"),
+		"instance_group_manager": ModuleRef("workers", "instance_group_manager").AsExpression().AsValue(),
+		"size":                   cty.NumberIntVal(0),
+	})
+}
+
+func TestCheckMaintenanceSchedule(t *testing.T) {
+	type test struct {
+		name   string
+		modify func(s *Dict)
+		err    bool
+	}
+	tests := []test{
+		{"valid", func(s *Dict) {}, false},
+		{"valid image refresh", func(s *Dict) {
+			s.Set("action", cty.StringVal("image-refresh"))
+			s.Set("build_trigger", cty.StringVal("projects/p/triggers/t"))
+		}, false},
+		{"unknown setting", func(s *Dict) { s.Set("zone", cty.StringVal("us-central1-a")) }, true},
+		{"bad name", func(s *Dict) { s.Set("name", cty.StringVal("Nightly")) }, true},
+		{"bad cron", func(s *Dict) { s.Set("schedule", cty.StringVal("@daily")) }, true},
+		{"bad action", func(s *Dict) { s.Set("action", cty.StringVal("reboot")) }, true},
+		{"missing size", func(s *Dict) {
+			m := s.Items()
+			delete(m, "size")
+			*s = NewDict(m)
+		}, true},
+		{"missing build trigger", func(s *Dict) { s.Set("action", cty.StringVal("image-refresh")) }, true},
+		{"action is expression", func(s *Dict) {
+			s.Set("action", GlobalRef("action").AsExpression().AsValue())
+		}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := scaleDownSchedule()
+			tc.modify(&s)
+			err := checkMaintenanceSchedule(s)
+			if tc.err != (err != nil) {
+				t.Errorf("got unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestAddMaintenanceModule(t *testing.T) {
+	workers := Module{ID: "workers", Source: "./modules/mig", Kind: TerraformKind}
+	image := Module{ID: "image", Source: "./modules/image", Kind: PackerKind}
+	bp := Blueprint{
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Modules: []Module{workers}},
+			{Name: "packer", Modules: []Module{image}},
+		},
+		MaintenanceSchedules: []Dict{scaleDownSchedule()},
+	}
+	if err := bp.addMaintenanceModule(); err != nil {
+		t.Fatal(err)
+	}
+	primary := bp.DeploymentGroups[0].Modules
+	if len(primary) != 2 || primary[1].ID != MaintenanceModuleID {
+		t.Fatalf("expected maintenance module to be added to the last terraform group, got %#v", primary)
+	}
+	s := primary[1].Settings.Get("schedules").Index(cty.NumberIntVal(0))
+	if got := s.GetAttr("time_zone"); !got.RawEquals(cty.StringVal("Etc/UTC")) {
+		t.Errorf("expected default time_zone, got %#v", got)
+	}
+	if got := s.GetAttr("build_trigger"); !got.RawEquals(cty.StringVal("")) {
+		t.Errorf("expected empty build_trigger, got %#v", got)
+	}
+
+	// adding again is a no-op
+	if err := bp.addMaintenanceModule(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(bp.DeploymentGroups[0].Modules); n != 2 {
+		t.Errorf("expected 2 modules, got %d", n)
+	}
+
+	bp.MaintenanceSchedules = append(bp.MaintenanceSchedules, scaleDownSchedule())
+	if err := bp.addMaintenanceModule(); err == nil {
+		t.Error("expected error for duplicate schedule names")
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaVersion is the version of the blueprint schema of this release.
+// Blueprints of older versions are migrated when imported.
+const SchemaVersion = 3
+
+// migration upgrades a blueprint to version, from the version before
+type migration struct {
+	version     int
+	description string
+	apply       func(root *yaml.Node)
+}
+
+var migrations = []migration{
+	{2, "renamed resource_groups to deployment_groups and resources to modules", func(root *yaml.Node) {
+		renameKey(root, "resource_groups", "deployment_groups")
+		if groups := mappingValue(root, "deployment_groups"); groups != nil {
+			for _, g := range groups.Content {
+				renameKey(g, "resources", "modules")
+			}
+		}
+	}},
+	{3, "replaced wrapsettingswith of modules by wrap_settings", func(root *yaml.Node) {
+		for _, m := range moduleNodes(root) {
+			migrateWrapSettings(m)
+		}
+	}},
+}
+
+// legacyWrapperExp matches the prefix a setting was wrapped with before
+// schema version 3, e.g. "flatten([", capturing the name of the function
+var legacyWrapperExp = regexp.MustCompile(`^([a-z][a-z0-9_]*)\(`)
+
+// migrateWrapSettings replaces the wrapsettingswith of a module, the prefix
+// and suffix each setting is wrapped with, by wrap_settings, the name of the
+// function each setting is wrapped with
+func migrateWrapSettings(mod *yaml.Node) {
+	wrap := mappingValue(mod, "wrapsettingswith")
+	if wrap == nil {
+		return
+	}
+	if wrap.Kind == yaml.MappingNode {
+		content := []*yaml.Node{}
+		for i := 0; i+1 < len(wrap.Content); i += 2 {
+			v := wrap.Content[i+1]
+			if v.Kind != yaml.SequenceNode || len(v.Content) == 0 {
+				continue
+			}
+			if m := legacyWrapperExp.FindStringSubmatch(v.Content[0].Value); m != nil {
+				fn := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: m[1]}
+				content = append(content, wrap.Content[i], fn)
+			}
+		}
+		wrap.Content, wrap.Style = content, 0
+	}
+	renameKey(mod, "wrapsettingswith", "wrap_settings")
+	if wrap.Kind != yaml.MappingNode || len(wrap.Content) == 0 {
+		deleteKey(mod, "wrap_settings")
+	}
+}
+
+// deleteKey removes a key of a mapping node
+func deleteKey(n *yaml.Node, key string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content = append(n.Content[:i], n.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// mappingValue returns the value of key in a mapping node, nil if absent
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// renameKey renames a key of a mapping node, unless the new key is set
+func renameKey(n *yaml.Node, from string, to string) {
+	if n.Kind != yaml.MappingNode || mappingValue(n, to) != nil {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == from {
+			n.Content[i].Value = to
+		}
+	}
+}
+
+// schemaVersion returns the schema_version of a blueprint; blueprints without
+// one are of the latest version whose keys they use, the current otherwise
+func schemaVersion(root *yaml.Node) (int, error) {
+	if v := mappingValue(root, "schema_version"); v != nil {
+		n, err := strconv.Atoi(v.Value)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("schema_version must be a positive integer, got %q", v.Value)
+		}
+		return n, nil
+	}
+	if mappingValue(root, "resource_groups") != nil {
+		return 1, nil
+	}
+	for _, m := range moduleNodes(root) {
+		if mappingValue(m, "wrapsettingswith") != nil {
+			return 2, nil
+		}
+	}
+	return SchemaVersion, nil
+}
+
+// setSchemaVersion sets the schema_version of a blueprint, after its
+// blueprint_name if it has none
+func setSchemaVersion(root *yaml.Node, version int) {
+	val := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	if v := mappingValue(root, "schema_version"); v != nil {
+		*v = *val
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "schema_version"}
+	at := 0
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "blueprint_name" {
+			at = i + 2
+		}
+	}
+	content := append([]*yaml.Node{}, root.Content[:at]...)
+	content = append(content, key, val)
+	root.Content = append(content, root.Content[at:]...)
+}
+
+// migrateNode upgrades a blueprint document to SchemaVersion in place and
+// returns the descriptions of the migrations applied
+func migrateNode(doc *yaml.Node) ([]string, error) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	version, err := schemaVersion(root)
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("schema_version %d is newer than the version %d supported by this release of ghpc, "+
+			"please upgrade ghpc", version, SchemaVersion)
+	}
+	applied := []string{}
+	for _, m := range migrations {
+		if m.version > version {
+			m.apply(root)
+			applied = append(applied, m.description)
+		}
+	}
+	if len(applied) > 0 {
+		setSchemaVersion(root, SchemaVersion)
+	}
+	return applied, nil
+}
+
+// encodeBlueprint serializes a blueprint document, with its comments
+func encodeBlueprint(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	err := encoder.Encode(doc)
+	encoder.Close()
+	return buf.Bytes(), err
+}
+
+// Migrate upgrades the YAML content of a blueprint of an older schema version
+// to SchemaVersion. It returns the migrated content, with its comments, and
+// the descriptions of the migrations applied; the content is returned as is
+// if no migration applies or if it is not valid YAML.
+func Migrate(b []byte) ([]byte, []string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return b, nil, nil // left to the blueprint parser to report
+	}
+	applied, err := migrateNode(&doc)
+	if err != nil || len(applied) == 0 {
+		return b, nil, err
+	}
+	m, err := encodeBlueprint(&doc)
+	if err != nil {
+		return b, nil, err
+	}
+	return m, applied, nil
+}
+
+// MigrateFile rewrites a blueprint file of an older schema version in place,
+// replacing the deprecated module sources and settings that have a
+// replacement to use as is, and returns the descriptions of the changes.
+// SOPS-encrypted blueprints are not rewritten, as that would store them
+// decrypted.
+func MigrateFile(filename string) ([]string, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if isSopsEncrypted(b) {
+		return nil, fmt.Errorf("%s is encrypted with SOPS, decrypt it with sops -d to migrate it", filename)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf(errorMessages["yamlUnmarshalError"], filename, err)
+	}
+	applied, err := migrateNode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Content) > 0 {
+		applied = append(applied, rewriteDeprecations(doc.Content[0])...)
+	}
+	if len(applied) == 0 {
+		return nil, nil
+	}
+	migrated, err := encodeBlueprint(&doc)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	return applied, os.WriteFile(filename, migrated, info.Mode())
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const legacyBlueprint = `blueprint_name: legacy
+vars:
+  deployment_name: legacy
+# the network
+resource_groups:
+- group: primary
+  resources:
+  - id: network
+    source: modules/network/vpc
+`
+
+func TestMigrate(t *testing.T) {
+	got, applied, err := Migrate([]byte(legacyBlueprint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `blueprint_name: legacy
+schema_version: 3
+vars:
+  deployment_name: legacy
+# the network
+deployment_groups:
+  - group: primary
+    modules:
+      - id: network
+        source: modules/network/vpc
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if len(applied) != 2 {
+		t.Errorf("got migrations %v, want 2", applied)
+	}
+
+	// current blueprints are left as is
+	again, applied, err := Migrate(got)
+	if err != nil || len(applied) != 0 || string(again) != string(got) {
+		t.Errorf("got %q, %v, %v, want no migration", again, applied, err)
+	}
+}
+
+func TestMigrateVersions(t *testing.T) {
+	type test struct {
+		name    string
+		yaml    string
+		applied int
+		err     string
+	}
+	tests := []test{
+		{"unversioned", "blueprint_name: a\ndeployment_groups: []\n", 0, ""},
+		{"current", "blueprint_name: a\nschema_version: 3\n", 0, ""},
+		{"versioned legacy", "blueprint_name: a\nschema_version: 1\n", 2, ""},
+		{"versioned 2", "blueprint_name: a\nschema_version: 2\n", 1, ""},
+		{"newer", "blueprint_name: a\nschema_version: 4\n", 0, "please upgrade ghpc"},
+		{"invalid", "blueprint_name: a\nschema_version: two\n", 0, "positive integer"},
+		{"not yaml", "blueprint_name: [", 0, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, applied, err := Migrate([]byte(tc.yaml))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) != tc.applied {
+				t.Errorf("got migrations %v, want %d", applied, tc.applied)
+			}
+		})
+	}
+}
+
+func TestMigrateWrapSettings(t *testing.T) {
+	expanded := `blueprint_name: a
+deployment_groups:
+  - group: primary
+    modules:
+      - id: network
+        wrapsettingswith: {}
+      - id: vm
+        wrapsettingswith:
+          labels:
+            - merge(
+            - )
+          network_storage:
+            - flatten([
+            - '])'
+`
+	got, applied, err := Migrate([]byte(expanded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `blueprint_name: a
+schema_version: 3
+deployment_groups:
+  - group: primary
+    modules:
+      - id: network
+      - id: vm
+        wrap_settings:
+          labels: merge
+          network_storage: flatten
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if len(applied) != 1 {
+		t.Errorf("got migrations %v, want 1", applied)
+	}
+}
+
+func TestParseLegacyBlueprint(t *testing.T) {
+	dc, err := NewDeploymentConfigFromBytes([]byte(legacyBlueprint))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc.Config.SchemaVersion != SchemaVersion {
+		t.Errorf("got schema_version %d, want %d", dc.Config.SchemaVersion, SchemaVersion)
+	}
+	if _, err := dc.Config.Module("network"); err != nil {
+		t.Error(err)
+	}
+	if pos, ok := blueprintPositions([]byte(legacyBlueprint))["modules.network"]; !ok || pos.line != 8 {
+		t.Errorf("got position %v, want line 8", pos)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// IsModuleVar reports whether the deployment variable refers to outputs of
+// modules, directly or through other deployment variables. The values of
+// module variables are only known once the modules are deployed, they are
+// written as locals of the Terraform groups that use them.
+func (bp Blueprint) IsModuleVar(name string) bool {
+	return bp.isModuleVar(name, map[string]bool{})
+}
+
+func (bp Blueprint) isModuleVar(name string, seen map[string]bool) bool {
+	if seen[name] || !bp.Vars.Has(name) {
+		return false
+	}
+	seen[name] = true
+	for _, r := range valueReferences(bp.Vars.Get(name)) {
+		if !r.GlobalVar || bp.isModuleVar(r.Name, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleVarsUsedBy returns the sorted names of the module variables the value
+// refers to, directly or through other module variables
+func (bp Blueprint) ModuleVarsUsedBy(v cty.Value) []string {
+	used := map[string]bool{}
+	var visit func(v cty.Value)
+	visit = func(v cty.Value) {
+		for _, r := range valueReferences(v) {
+			if r.GlobalVar && !used[r.Name] && bp.IsModuleVar(r.Name) {
+				used[r.Name] = true
+				visit(bp.Vars.Get(r.Name))
+			}
+		}
+	}
+	visit(v)
+	names := maps.Keys(used)
+	slices.Sort(names)
+	return names
+}
+
+// GroupModuleVars returns the sorted names of the module variables used by
+// the modules of the group
+func (bp Blueprint) GroupModuleVars(g DeploymentGroup) []string {
+	settings := []cty.Value{}
+	for _, m := range g.Modules {
+		settings = append(settings, m.Settings.AsObject())
+	}
+	return bp.ModuleVarsUsedBy(cty.TupleVal(settings))
+}
+
+// WithModuleVars returns the value along with the values of the module
+// variables it uses, so that their references are found as those of the value
+func (bp Blueprint) WithModuleVars(v cty.Value) cty.Value {
+	vs := []cty.Value{v}
+	for _, n := range bp.ModuleVarsUsedBy(v) {
+		vs = append(vs, bp.Vars.Get(n))
+	}
+	return cty.TupleVal(vs)
+}
+
+// toolkitVars returns the deployment variables the toolkit reads itself,
+// which can not be module variables
+func (bp Blueprint) toolkitVars() []string {
+	vars := []string{"deployment_name", "labels"}
+	if p := bp.TargetCloud().ProjectVar; p != "" {
+		vars = append(vars, p)
+	}
+	return append(vars, bp.ProviderVars()...)
+}
+
+// checkModuleVars checks that module variables refer to existing modules and
+// are only used by Terraform modules, which can refer to the outputs they use
+func checkModuleVars(bp Blueprint) error {
+	for n, v := range bp.Vars.Items() {
+		for _, r := range valueReferences(v) {
+			if _, err := bp.Module(r.Module); !r.GlobalVar && err != nil {
+				return fmt.Errorf("deployment variable %s: %w", n, err)
+			}
+		}
+	}
+
+	return bp.WalkModules(func(m *Module) error {
+		for _, n := range bp.ModuleVarsUsedBy(m.Settings.AsObject()) {
+			if m.Kind != TerraformKind {
+				return fmt.Errorf("module %s: deployment variable %s refers to module outputs, which can only be used by Terraform modules", m.ID, n)
+			}
+			for _, r := range valueReferences(bp.Vars.Get(n)) {
+				if err := validateModuleSettingReference(bp, *m, r); err != nil {
+					return fmt.Errorf("deployment variable %s: %w", n, err)
+				}
+			}
+		}
+		return nil
+	})
+}
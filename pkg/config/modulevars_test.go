@@ -0,0 +1,115 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func moduleVarsBlueprint() Blueprint {
+	net := Module{ID: "net", Source: "./net", Kind: TerraformKind}
+	setTestModuleInfo(net, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{{Name: "subnet"}}})
+	vm := Module{ID: "vm", Source: "./vm", Kind: TerraformKind, Settings: NewDict(map[string]cty.Value{
+		"subnets": GlobalRef("subnets").AsExpression().AsValue(),
+		"zone":    GlobalRef("zone").AsExpression().AsValue(),
+	})}
+	setTestModuleInfo(vm, modulereader.ModuleInfo{})
+	return Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"zone":    cty.StringVal("us-central1-a"),
+			"subnet":  ModuleRef("net", "subnet").AsExpression().AsValue(),
+			"subnets": cty.TupleVal([]cty.Value{GlobalRef("subnet").AsExpression().AsValue(), GlobalRef("zone").AsExpression().AsValue()}),
+			"unused":  ModuleRef("net", "subnet").AsExpression().AsValue(),
+		}),
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "one", Modules: []Module{net}},
+			{Name: "two", Modules: []Module{vm}},
+		},
+	}
+}
+
+func TestModuleVars(t *testing.T) {
+	bp := moduleVarsBlueprint()
+	for n, want := range map[string]bool{"zone": false, "subnet": true, "subnets": true, "missing": false} {
+		if got := bp.IsModuleVar(n); got != want {
+			t.Errorf("IsModuleVar(%q) = %v, want %v", n, got, want)
+		}
+	}
+
+	if diff := cmp.Diff([]string{"subnet", "subnets"}, bp.GroupModuleVars(bp.DeploymentGroups[1])); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if got := bp.GroupModuleVars(bp.DeploymentGroups[0]); len(got) > 0 {
+		t.Errorf("got module vars %v, want none", got)
+	}
+
+	// the outputs module variables use are intergroup references of the modules using them
+	vm := bp.DeploymentGroups[1].Modules[0]
+	got := FindIntergroupReferences(vm.Settings.AsObject(), vm, bp)
+	if diff := cmp.Diff([]Reference{ModuleRef("net", "subnet")}, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckModuleVars(t *testing.T) {
+	if err := checkModuleVars(moduleVarsBlueprint()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	type test struct {
+		edit func(bp *Blueprint)
+		err  string
+	}
+	tests := map[string]test{
+		"unknown module": {func(bp *Blueprint) {
+			bp.Vars.Set("unused", ModuleRef("nope", "subnet").AsExpression().AsValue())
+		}, "deployment variable unused: "},
+		"unknown output": {func(bp *Blueprint) {
+			bp.Vars.Set("subnet", ModuleRef("net", "nope").AsExpression().AsValue())
+		}, "module net did not have output nope"},
+		"later group": {func(bp *Blueprint) {
+			g := bp.DeploymentGroups
+			g[0], g[1] = g[1], g[0]
+		}, "net is in a later group"},
+		"packer": {func(bp *Blueprint) {
+			bp.DeploymentGroups[1].Modules[0].Kind = PackerKind
+		}, "can only be used by Terraform modules"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			bp := moduleVarsBlueprint()
+			tc.edit(&bp)
+			err := checkModuleVars(bp)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestToolkitVarsCanNotBeModuleVars(t *testing.T) {
+	bp := moduleVarsBlueprint()
+	bp.Vars.Set("deployment_name", ModuleRef("net", "subnet").AsExpression().AsValue())
+	err := bp.resolveVarReferences()
+	if err == nil || !strings.Contains(err.Error(), "deployment variable deployment_name can not refer to module outputs") {
+		t.Errorf("got error %v", err)
+	}
+}
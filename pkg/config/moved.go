@@ -0,0 +1,118 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// Move is the renaming of a Terraform module of a deployment group
+type Move struct {
+	Group GroupName
+	From  ModuleID
+	To    ModuleID
+}
+
+// checkMovedFrom checks that the previous IDs of modules are not the IDs of
+// other modules, and that only Terraform modules have them
+func checkMovedFrom(bp Blueprint) error {
+	movedTo := map[ModuleID]ModuleID{}
+	return bp.WalkModules(func(m *Module) error {
+		if len(m.MovedFrom) > 0 && m.Kind != TerraformKind {
+			return fmt.Errorf("module %s: moved_from is only supported by Terraform modules", m.ID)
+		}
+		for _, id := range m.MovedFrom {
+			if _, err := bp.Module(id); err == nil {
+				return fmt.Errorf("module %s: moved_from %s is the ID of a module of the blueprint", m.ID, id)
+			}
+			if other, ok := movedTo[id]; ok {
+				return fmt.Errorf("modules %s and %s are both moved from %s", other, m.ID, id)
+			}
+			movedTo[id] = m.ID
+		}
+		return nil
+	})
+}
+
+// groupModules returns the Terraform modules of a group of the blueprint by
+// ID, nil if the blueprint has no such Terraform group
+func (bp Blueprint) groupModules(n GroupName) map[ModuleID]*Module {
+	g, err := bp.Group(n)
+	if err != nil || g.Kind != TerraformKind {
+		return nil
+	}
+	mods := map[ModuleID]*Module{}
+	for i := range g.Modules {
+		mods[g.Modules[i].ID] = &g.Modules[i]
+	}
+	return mods
+}
+
+// InferMoves adds the renamings of the Terraform modules of the blueprint
+// since a previous version of it to their moved_from, and returns them. A
+// module is renamed if it is the only module of its group added with the
+// source of the only module of the group removed. The moved_from of the
+// previous version are kept, as their state may not have been moved yet.
+func (bp *Blueprint) InferMoves(prev Blueprint) []Move {
+	moves := []Move{}
+	for gi := range bp.DeploymentGroups {
+		g := &bp.DeploymentGroups[gi]
+		prevMods := prev.groupModules(g.Name)
+		if g.Kind != TerraformKind || prevMods == nil {
+			continue
+		}
+		claimed := map[ModuleID]bool{}
+		added := map[string][]*Module{}
+		for i := range g.Modules {
+			m := &g.Modules[i]
+			for _, id := range m.MovedFrom {
+				claimed[id] = true
+			}
+			if pm, ok := prevMods[m.ID]; ok {
+				for _, id := range pm.MovedFrom {
+					if !slices.Contains(m.MovedFrom, id) {
+						m.MovedFrom = append(m.MovedFrom, id)
+					}
+					claimed[id] = true
+				}
+			} else if len(m.MovedFrom) == 0 && m.Kind == TerraformKind {
+				added[m.Source] = append(added[m.Source], m)
+			}
+		}
+		removed := map[string][]ModuleID{}
+		for _, pm := range prevMods {
+			if _, err := bp.Module(pm.ID); err != nil && !claimed[pm.ID] {
+				removed[pm.Source] = append(removed[pm.Source], pm.ID)
+			}
+		}
+		for src, mods := range added {
+			if len(mods) != 1 || len(removed[src]) != 1 {
+				continue
+			}
+			from := removed[src][0]
+			mods[0].MovedFrom = append(mods[0].MovedFrom, from)
+			moves = append(moves, Move{Group: g.Name, From: from, To: mods[0].ID})
+		}
+	}
+	slices.SortFunc(moves, func(a, b Move) bool {
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.To < b.To
+	})
+	return moves
+}
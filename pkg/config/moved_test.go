@@ -0,0 +1,107 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInferMoves(t *testing.T) {
+	mod := func(id ModuleID, source string, movedFrom ...ModuleID) Module {
+		return Module{ID: id, Source: source, Kind: TerraformKind, MovedFrom: movedFrom}
+	}
+	blueprint := func(mods ...Module) Blueprint {
+		return Blueprint{DeploymentGroups: []DeploymentGroup{
+			{Name: "primary", Kind: TerraformKind, Modules: mods},
+			{Name: "image", Kind: PackerKind, Modules: []Module{{ID: "img", Kind: PackerKind}}},
+		}}
+	}
+	prev := blueprint(
+		mod("network1", "modules/network/vpc"),
+		mod("homefs", "modules/file-system/filestore"),
+		mod("scratch", "modules/file-system/filestore", "scratchfs"))
+
+	type test struct {
+		name      string
+		bp        Blueprint
+		want      []Move
+		movedFrom map[ModuleID][]ModuleID
+	}
+	tests := []test{
+		{"unchanged", blueprint(
+			mod("network1", "modules/network/vpc"),
+			mod("homefs", "modules/file-system/filestore"),
+			mod("scratch", "modules/file-system/filestore")),
+			[]Move{}, map[ModuleID][]ModuleID{"scratch": {"scratchfs"}}},
+		{"renamed", blueprint(
+			mod("network", "modules/network/vpc"),
+			mod("homefs", "modules/file-system/filestore"),
+			mod("scratch", "modules/file-system/filestore")),
+			[]Move{{Group: "primary", From: "network1", To: "network"}},
+			map[ModuleID][]ModuleID{"network": {"network1"}}},
+		{"ambiguous", blueprint(
+			mod("network1", "modules/network/vpc"),
+			mod("home", "modules/file-system/filestore"),
+			mod("data", "modules/file-system/filestore")),
+			[]Move{}, map[ModuleID][]ModuleID{"home": nil, "data": nil}},
+		{"declared", blueprint(
+			mod("network", "modules/network/vpc", "network1"),
+			mod("homefs", "modules/file-system/filestore"),
+			mod("scratch", "modules/file-system/filestore")),
+			[]Move{}, map[ModuleID][]ModuleID{"network": {"network1"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bp := tc.bp
+			if diff := cmp.Diff(tc.want, bp.InferMoves(prev)); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+			for id, want := range tc.movedFrom {
+				m, err := bp.Module(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(want, m.MovedFrom); diff != "" {
+					t.Errorf("moved_from of %s diff (-want +got):\n%s", id, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckMovedFrom(t *testing.T) {
+	blueprint := func(mods ...Module) Blueprint {
+		return Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: mods}}}
+	}
+	ok := blueprint(Module{ID: "network", Kind: TerraformKind, MovedFrom: []ModuleID{"network1"}})
+	if err := checkMovedFrom(ok); err != nil {
+		t.Error(err)
+	}
+	for name, bp := range map[string]Blueprint{
+		"existing id": blueprint(
+			Module{ID: "network", Kind: TerraformKind, MovedFrom: []ModuleID{"vpc"}},
+			Module{ID: "vpc", Kind: TerraformKind}),
+		"moved twice": blueprint(
+			Module{ID: "a", Kind: TerraformKind, MovedFrom: []ModuleID{"old"}},
+			Module{ID: "b", Kind: TerraformKind, MovedFrom: []ModuleID{"old"}}),
+		"packer": blueprint(Module{ID: "img", Kind: PackerKind, MovedFrom: []ModuleID{"image"}}),
+	} {
+		if err := checkMovedFrom(bp); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// DeploymentOutput surfaces an output of a module as an output of the
+// deployment, which ghpc export-outputs and ghpc deploy write, along with the
+// other outputs of the deployment, to a single file
+type DeploymentOutput struct {
+	Name string `yaml:"name"`
+	// Value is a reference to an output of a Terraform module, e.g.
+	// $(login.external_ip)
+	Value       string `yaml:"value"`
+	Description string `yaml:"description,omitempty"`
+	Sensitive   bool   `yaml:"sensitive,omitempty"`
+}
+
+// Reference returns the module output the deployment output surfaces
+func (o DeploymentOutput) Reference() (Reference, error) {
+	e, err := SimpleVarToExpression(o.Value)
+	if err != nil {
+		return Reference{}, fmt.Errorf("output %s: %w", o.Name, err)
+	}
+	r := e.References()[0]
+	if r.GlobalVar {
+		return Reference{}, fmt.Errorf("output %s: value must refer to a module output, got %s", o.Name, o.Value)
+	}
+	return r, nil
+}
+
+// checkDeploymentOutputs validates the outputs block
+func checkDeploymentOutputs(bp Blueprint) error {
+	names := map[string]bool{}
+	for _, o := range bp.Outputs {
+		if !hclsyntax.ValidIdentifier(o.Name) {
+			return fmt.Errorf("output name %q must be a valid identifier", o.Name)
+		}
+		if names[o.Name] {
+			return fmt.Errorf("output names must be unique: %s used more than once", o.Name)
+		}
+		names[o.Name] = true
+
+		r, err := o.Reference()
+		if err != nil {
+			return err
+		}
+		m, err := bp.Module(r.Module)
+		if err != nil {
+			return fmt.Errorf("output %s: %w", o.Name, err)
+		}
+		if m.Kind != TerraformKind {
+			return fmt.Errorf("output %s: module %s is a %s module, only outputs of Terraform modules can be outputs of the deployment", o.Name, m.ID, m.Kind)
+		}
+		if err := validateModuleSettingReference(bp, *m, r); err != nil {
+			return fmt.Errorf("output %s: %w", o.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func outputsBlueprint() Blueprint {
+	bp := moduleVarsBlueprint()
+	bp.Outputs = []DeploymentOutput{
+		{Name: "subnet", Value: "$(net.subnet)", Sensitive: true},
+	}
+	return bp
+}
+
+func TestCheckDeploymentOutputs(t *testing.T) {
+	if err := checkDeploymentOutputs(outputsBlueprint()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	type test struct {
+		output DeploymentOutput
+		err    string
+	}
+	tests := map[string]test{
+		"invalid name":   {DeploymentOutput{Name: "1st", Value: "$(net.subnet)"}, "must be a valid identifier"},
+		"duplicate":      {DeploymentOutput{Name: "subnet", Value: "$(net.subnet)"}, "used more than once"},
+		"var":            {DeploymentOutput{Name: "zone", Value: "$(vars.zone)"}, "must refer to a module output"},
+		"literal":        {DeploymentOutput{Name: "zone", Value: "us-central1-a"}, "output zone: "},
+		"unknown module": {DeploymentOutput{Name: "ip", Value: "$(login.ip)"}, "output ip: "},
+		"unknown output": {DeploymentOutput{Name: "ip", Value: "$(net.ip)"}, "module net did not have output ip"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			bp := outputsBlueprint()
+			bp.Outputs = append(bp.Outputs, tc.output)
+			err := checkDeploymentOutputs(bp)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+
+	bp := outputsBlueprint()
+	bp.DeploymentGroups[0].Modules[0].Kind = PackerKind
+	if err := checkDeploymentOutputs(bp); err == nil || !strings.Contains(err.Error(), "only outputs of Terraform modules") {
+		t.Errorf("got error %v, want packer module error", err)
+	}
+}
+
+func TestPopulateDeploymentOutputs(t *testing.T) {
+	bp := outputsBlueprint()
+	bp.Vars = NewDict(nil)
+	bp.DeploymentGroups = bp.DeploymentGroups[:1]
+	bp.populateOutputs()
+
+	want := []modulereader.OutputInfo{{
+		Name:        "subnet",
+		Description: "Automatically-generated output exported as output subnet of the deployment",
+		Sensitive:   true,
+		Managed:     true,
+	}}
+	if diff := cmp.Diff(want, bp.DeploymentGroups[0].Modules[0].Outputs); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// outputs already exported are not duplicated
+	bp.populateOutputs()
+	if got := bp.DeploymentGroups[0].Modules[0].Outputs; len(got) != 1 {
+		t.Errorf("got outputs %v, want one", got)
+	}
+}
+
+func TestUnusedOutputs(t *testing.T) {
+	bp := moduleVarsBlueprint()
+	net := &bp.DeploymentGroups[0].Modules[0]
+	setTestModuleInfo(*net, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{{Name: "subnet"}, {Name: "network"}, {Name: "nat_ips"}}})
+	net.Outputs = []modulereader.OutputInfo{{Name: "subnet"}, {Name: "network"}, {Name: "nat_ips"}}
+	bp.Outputs = []DeploymentOutput{{Name: "network", Value: "$(net.network)"}}
+	bp.populateOutputs()
+
+	// subnet is used by the later group, network is an output of the deployment
+	if diff := cmp.Diff([]Reference{ModuleRef("net", "nat_ips")}, bp.unusedOutputs()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// outputs added by ghpc are never reported
+	net.Outputs = []modulereader.OutputInfo{{Name: "nat_ips", Managed: true}}
+	if got := bp.unusedOutputs(); len(got) > 0 {
+		t.Errorf("got unused outputs %v, want none", got)
+	}
+}
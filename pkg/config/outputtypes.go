@@ -0,0 +1,130 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"golang.org/x/exp/slices"
+)
+
+// OutputType returns the type of an output of a module, as inferred from the
+// module, or cty.DynamicPseudoType if it is only known after deployment
+func (bp Blueprint) OutputType(r Reference) cty.Type {
+	m, err := bp.Module(r.Module)
+	if err != nil || r.GlobalVar {
+		return cty.DynamicPseudoType
+	}
+	if m.Kind == PackerKind && r.Name == PackerImageOutput {
+		return cty.String
+	}
+	if m.Kind != TerraformKind {
+		return cty.DynamicPseudoType
+	}
+	outputs := m.InfoOrDie().Outputs
+	i := slices.IndexFunc(outputs, func(o modulereader.OutputInfo) bool { return o.Name == r.Name })
+	if i < 0 || outputs[i].Type == "" {
+		return cty.DynamicPseudoType
+	}
+	ty, err := parseTypeConstraint(outputs[i].Type)
+	if err != nil {
+		return cty.DynamicPseudoType
+	}
+	return ty
+}
+
+// outputOf returns the module output v is set to, either directly or through
+// a module variable
+func (bp Blueprint) outputOf(v cty.Value) (Reference, bool) {
+	e, is := IsExpressionValue(v)
+	if !is {
+		return Reference{}, false
+	}
+	rs := e.References()
+	if len(rs) != 1 || !isReferenceExpression(e, rs[0]) {
+		return Reference{}, false
+	}
+	if !rs[0].GlobalVar {
+		return rs[0], true
+	}
+	if bp.IsModuleVar(rs[0].Name) {
+		return bp.outputOf(bp.Vars.Get(rs[0].Name))
+	}
+	return Reference{}, false
+}
+
+// isReferenceExpression returns true if e is just the reference r
+func isReferenceExpression(e Expression, r Reference) bool {
+	s := strings.TrimSpace(string(e.Tokenize().Bytes()))
+	return s == strings.TrimSpace(string(r.AsExpression().Tokenize().Bytes()))
+}
+
+// convertible returns an error if values of type from can not be converted to
+// type to; types that are only known after deployment are convertible
+func convertible(from cty.Type, to cty.Type) error {
+	if from == cty.DynamicPseudoType || to == cty.DynamicPseudoType || from.Equals(to) {
+		return nil
+	}
+	if convert.GetConversionUnsafe(from, to) == nil {
+		return fmt.Errorf("type %s can not be converted to type %s", typeexpr.TypeString(from), typeexpr.TypeString(to))
+	}
+	return nil
+}
+
+// checkOutputTypes checks that the types of the module outputs settings and
+// typed deployment variables are set to can be converted to their types
+func (bp Blueprint) checkOutputTypes() error {
+	for _, name := range bp.DeclaredVariables() {
+		d := bp.DeploymentVariables[name]
+		r, ok := bp.outputOf(bp.Vars.Get(name))
+		if d.Type == "" || !ok {
+			continue
+		}
+		ty, err := d.TypeConstraint()
+		if err != nil {
+			continue // reported when the declarations are applied
+		}
+		if err := convertible(bp.OutputType(r), ty); err != nil {
+			return fmt.Errorf("deployment variable %s is set to output %s of module %s: %v", name, r.Name, r.Module, err)
+		}
+	}
+
+	return bp.WalkModules(func(m *Module) error {
+		inputs := map[string]string{}
+		for _, in := range m.InfoOrDie().Inputs {
+			inputs[in.Name] = in.Type
+		}
+		for setting, v := range m.Settings.Items() {
+			r, ok := bp.outputOf(v)
+			if !ok {
+				continue
+			}
+			ty, err := parseTypeConstraint(inputs[setting])
+			if err != nil {
+				continue
+			}
+			if err := convertible(bp.OutputType(r), ty); err != nil {
+				return fmt.Errorf("module %s: setting %s is set to output %s of module %s: %v", m.ID, setting, r.Name, r.Module, err)
+			}
+		}
+		return nil
+	})
+}
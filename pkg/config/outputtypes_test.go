@@ -0,0 +1,101 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func outputTypesBlueprint() Blueprint {
+	net := Module{ID: "net", Source: "./net", Kind: TerraformKind}
+	setTestModuleInfo(net, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{
+		{Name: "subnets", Type: "list(string)"},
+		{Name: "name", Type: "string"},
+		{Name: "id"},
+	}})
+	vm := Module{ID: "vm", Source: "./vm", Kind: TerraformKind, Settings: NewDict(map[string]cty.Value{
+		"subnetworks": ModuleRef("net", "subnets").AsExpression().AsValue(),
+		"network":     GlobalRef("network").AsExpression().AsValue(),
+		"network_id":  ModuleRef("net", "id").AsExpression().AsValue(),
+	})}
+	setTestModuleInfo(vm, modulereader.ModuleInfo{Inputs: []modulereader.VarInfo{
+		{Name: "subnetworks", Type: "set(string)"},
+		{Name: "network", Type: "string"},
+		{Name: "network_id", Type: "number"},
+	}})
+	return Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"network": ModuleRef("net", "name").AsExpression().AsValue(),
+		}),
+		DeploymentVariables: map[string]VariableDeclaration{"network": {Type: "string"}},
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "one", Modules: []Module{net}},
+			{Name: "two", Modules: []Module{vm}},
+		},
+	}
+}
+
+func TestOutputType(t *testing.T) {
+	bp := outputTypesBlueprint()
+	for r, want := range map[Reference]cty.Type{
+		ModuleRef("net", "subnets"): cty.List(cty.String),
+		ModuleRef("net", "id"):      cty.DynamicPseudoType,
+		ModuleRef("net", "nope"):    cty.DynamicPseudoType,
+		ModuleRef("nope", "name"):   cty.DynamicPseudoType,
+	} {
+		if got := bp.OutputType(r); !got.Equals(want) {
+			t.Errorf("OutputType(%v) = %#v, want %#v", r, got, want)
+		}
+	}
+}
+
+func TestCheckOutputTypes(t *testing.T) {
+	// lists convert to sets, types only known after deployment are not checked
+	if err := outputTypesBlueprint().checkOutputTypes(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	type test struct {
+		edit func(bp *Blueprint)
+		err  string
+	}
+	tests := map[string]test{
+		"setting": {func(bp *Blueprint) {
+			bp.DeploymentGroups[1].Modules[0].Settings.Set("network_id", ModuleRef("net", "subnets").AsExpression().AsValue())
+		}, "module vm: setting network_id is set to output subnets of module net: type list(string) can not be converted to type number"},
+		"module variable": {func(bp *Blueprint) {
+			bp.Vars.Set("network", ModuleRef("net", "subnets").AsExpression().AsValue())
+			bp.DeploymentVariables["network"] = VariableDeclaration{}
+		}, "module vm: setting network is set to output subnets of module net"},
+		"declared type": {func(bp *Blueprint) {
+			bp.DeploymentVariables["network"] = VariableDeclaration{Type: "list(string)"}
+		}, "deployment variable network is set to output name of module net: type string can not be converted to type list(string)"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			bp := outputTypesBlueprint()
+			tc.edit(&bp)
+			err := bp.checkOutputTypes()
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+// PackerBuildOrder returns the modules of the Packer group in the order their
+// images are built: a module is built after the modules of the group it uses,
+// and otherwise in the order of the blueprint
+func (g DeploymentGroup) PackerBuildOrder() ([]Module, error) {
+	order := []Module{}
+	built := map[ModuleID]bool{}
+	for len(order) < len(g.Modules) {
+		next := slices.IndexFunc(g.Modules, func(m Module) bool {
+			if built[m.ID] {
+				return false
+			}
+			for _, u := range m.Use {
+				if g.hasModule(u.Module) && !built[u.Module] {
+					return false
+				}
+			}
+			return true
+		})
+		if next < 0 {
+			ids := []string{}
+			for _, m := range g.Modules {
+				if !built[m.ID] {
+					ids = append(ids, string(m.ID))
+				}
+			}
+			return nil, fmt.Errorf("%s: group %s, modules %s", errorMessages["packerCycle"], g.Name, strings.Join(ids, ", "))
+		}
+		built[g.Modules[next].ID] = true
+		order = append(order, g.Modules[next])
+	}
+	return order, nil
+}
+
+func (g DeploymentGroup) hasModule(id ModuleID) bool {
+	return slices.ContainsFunc(g.Modules, func(m Module) bool { return m.ID == id })
+}
+
+// checkPackerGroups ensures that only Packer groups have packer_settings, that
+// these only use deployment variables and are not also set by the modules, and
+// that the modules of the group can be built in order
+func checkPackerGroups(groups []DeploymentGroup) error {
+	for _, g := range groups {
+		settings := g.PackerSettings.Items()
+		if g.Kind != PackerKind {
+			if len(settings) > 0 {
+				return fmt.Errorf("group %s sets packer_settings but is not \"kind: packer\"", g.Name)
+			}
+			continue
+		}
+		for k, v := range settings {
+			if err := checkGlobalReferences(v); err != nil {
+				return fmt.Errorf("packer_settings.%s of group %s: %w", k, g.Name, err)
+			}
+			for _, m := range g.Modules {
+				if m.Settings.Has(k) {
+					return fmt.Errorf("module %s sets %s, which is already set by packer_settings of group %s", m.ID, k, g.Name)
+				}
+			}
+		}
+		if _, err := g.PackerBuildOrder(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkGlobalReferences ensures that the value only references deployment
+// variables
+func checkGlobalReferences(v cty.Value) error {
+	return cty.Walk(v, func(p cty.Path, v cty.Value) (bool, error) {
+		e, is := IsExpressionValue(v)
+		if !is {
+			return true, nil
+		}
+		for _, r := range e.References() {
+			if !r.GlobalVar {
+				return false, fmt.Errorf("only deployment variables can be referenced, got module %s", r.Module)
+			}
+		}
+		return true, nil
+	})
+}
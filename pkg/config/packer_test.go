@@ -0,0 +1,95 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+func moduleIDs(mods []Module) []ModuleID {
+	ids := []ModuleID{}
+	for _, m := range mods {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestPackerBuildOrder(t *testing.T) {
+	base := Module{ID: "base", Kind: PackerKind}
+	gpu := Module{ID: "gpu", Kind: PackerKind, Use: []ModuleUse{{Module: "base"}}}
+	login := Module{ID: "login", Kind: PackerKind}
+	g := DeploymentGroup{Name: "images", Kind: PackerKind, Modules: []Module{gpu, login, base}}
+
+	got, err := g.PackerBuildOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ModuleID{"login", "base", "gpu"}
+	if ids := moduleIDs(got); !slices.Equal(ids, want) {
+		t.Errorf("got order %v, want %v", ids, want)
+	}
+
+	g.Modules[2].Use = []ModuleUse{{Module: "gpu"}}
+	if _, err := g.PackerBuildOrder(); err == nil {
+		t.Error("expected an error for modules that use each other")
+	}
+}
+
+func TestCheckPackerGroups(t *testing.T) {
+	base := Module{ID: "base", Kind: PackerKind}
+	gpu := Module{ID: "gpu", Kind: PackerKind, Use: []ModuleUse{{Module: "base"}}}
+	shared := NewDict(map[string]cty.Value{
+		"zone":       GlobalRef("zone").AsExpression().AsValue(),
+		"disk_size":  cty.NumberIntVal(100),
+		"network_id": cty.StringVal("default"),
+	})
+	groups := func() []DeploymentGroup {
+		return []DeploymentGroup{
+			{Name: "images", Kind: PackerKind, Modules: []Module{base.Clone(), gpu.Clone()}, PackerSettings: shared.Clone()},
+			{Name: "cluster", Kind: TerraformKind, Modules: []Module{{ID: "vm", Kind: TerraformKind}}},
+		}
+	}
+
+	if err := checkPackerGroups(groups()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	gs := groups()
+	gs[0].Modules[1].Settings.Set("disk_size", cty.NumberIntVal(200))
+	if err := checkPackerGroups(gs); err == nil {
+		t.Error("expected an error for a module setting a shared setting")
+	}
+
+	gs = groups()
+	gs[0].PackerSettings.Set("network_id", ModuleRef("vm", "network_id").AsExpression().AsValue())
+	if err := checkPackerGroups(gs); err == nil {
+		t.Error("expected an error for a shared setting referencing a module")
+	}
+
+	gs = groups()
+	gs[1].PackerSettings = shared.Clone()
+	if err := checkPackerGroups(gs); err == nil {
+		t.Error("expected an error for packer_settings of a Terraform group")
+	}
+
+	gs = groups()
+	gs[0].Modules[0].Use = []ModuleUse{{Module: "gpu"}}
+	if err := checkPackerGroups(gs); err == nil {
+		t.Error("expected an error for modules that use each other")
+	}
+}
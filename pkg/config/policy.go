@@ -0,0 +1,49 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"hpc-toolkit/pkg/policy"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PolicyInput returns the blueprint as the JSON document policies are
+// evaluated against, which has the same structure as the expanded blueprint
+// written by "ghpc expand"
+func (bp Blueprint) PolicyInput() ([]byte, error) {
+	y, err := bp.CanonicalYAML()
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(y, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// evaluatePolicies evaluates the policies of the policy bundle against the
+// expanded blueprint
+func (dc DeploymentConfig) evaluatePolicies() ([]policy.Result, error) {
+	input, err := dc.Config.PolicyInput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the input of the policies: %w", err)
+	}
+	return policy.Evaluate(dc.PolicyBundle, input)
+}
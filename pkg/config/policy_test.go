@@ -0,0 +1,64 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestPolicyInput(t *testing.T) {
+	bp := Blueprint{
+		BlueprintName: "bp",
+		Vars:          NewDict(map[string]cty.Value{"zone": cty.StringVal("us-central1-a")}),
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{{
+			ID:     "login",
+			Source: "modules/compute/vm-instance",
+			Kind:   TerraformKind,
+			Settings: NewDict(map[string]cty.Value{
+				"machine_type":       cty.StringVal("n2-standard-4"),
+				"disable_public_ips": cty.False,
+				"instance_count":     cty.NumberIntVal(2),
+			}),
+		}}}},
+	}
+	b, err := bp.PolicyInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	mod := got["deployment_groups"].([]interface{})[0].(map[string]interface{})["modules"].([]interface{})[0].(map[string]interface{})
+	if mod["id"] != "login" || mod["kind"] != "terraform" {
+		t.Errorf("got module %v", mod)
+	}
+	want := map[string]interface{}{
+		"machine_type":       "n2-standard-4",
+		"disable_public_ips": false,
+		"instance_count":     float64(2),
+	}
+	if diff := cmp.Diff(want, mod["settings"]); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"zone": "us-central1-a"}, got["vars"]); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// name of the label of the profile the deployment was created with, following
+// the prefix of ToolkitLabels
+const profileLabel string = "profile"
+
+// Profile is a named set of overrides of deployment variables and module
+// settings, e.g. for the dev, staging and prod environments of a blueprint
+type Profile struct {
+	// Vars override the deployment variables
+	Vars Dict `yaml:"vars"`
+	// Settings override settings of the modules, by module ID
+	Settings map[ModuleID]Dict `yaml:"settings,omitempty"`
+}
+
+// ApplyProfile applies the overrides of the profile named name and records it
+// as the profile of the deployment. A profile recorded by an earlier expansion
+// is not applied again, and no other profile can be applied over it.
+func (bp *Blueprint) ApplyProfile(name string) error {
+	if name == "" || name == bp.Profile {
+		return nil
+	}
+	if bp.Profile != "" {
+		return fmt.Errorf("the blueprint was expanded with profile %s, it can not be created with profile %s", bp.Profile, name)
+	}
+	p, ok := bp.Profiles[name]
+	if !ok {
+		names := maps.Keys(bp.Profiles)
+		slices.Sort(names)
+		if len(names) == 0 {
+			return fmt.Errorf("profile %s is not defined, the blueprint has no profiles", name)
+		}
+		return fmt.Errorf("profile %s is not defined, profiles of the blueprint are %s", name, strings.Join(names, ", "))
+	}
+
+	for k, v := range p.Vars.Items() {
+		bp.Vars.Set(k, v)
+	}
+	for id, settings := range p.Settings {
+		m, err := bp.Module(id)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", name, err)
+		}
+		for k, v := range settings.Items() {
+			m.Settings.Set(k, v)
+		}
+	}
+	bp.Profile = name
+	return nil
+}
+
+// checkProfiles checks the names of the profiles, which label deployments, and
+// that the modules they set settings of exist
+func checkProfiles(bp Blueprint) error {
+	names := maps.Keys(bp.Profiles)
+	slices.Sort(names)
+	for _, n := range names {
+		if !artifactNameExp.MatchString(n) {
+			return fmt.Errorf("profile name %q must contain only lowercase letters, numbers, dashes and underscores", n)
+		}
+		ids := maps.Keys(bp.Profiles[n].Settings)
+		slices.Sort(ids)
+		for _, id := range ids {
+			if _, err := bp.Module(id); err != nil {
+				return fmt.Errorf("profile %s: %w", n, err)
+			}
+		}
+	}
+	if _, ok := bp.Profiles[bp.Profile]; bp.Profile != "" && !ok {
+		return fmt.Errorf("profile %s is not defined", bp.Profile)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+func profilesBlueprint(t *testing.T) Blueprint {
+	in := `
+vars:
+  deployment_name: test
+  machine_type: n2-standard-2
+profiles:
+  prod:
+    vars:
+      machine_type: c2-standard-60
+    settings:
+      compute:
+        node_count: 100
+  dev: {}
+deployment_groups:
+- group: primary
+  modules:
+  - id: compute
+    source: modules/compute
+    settings:
+      node_count: 2
+      zone: us-central1-a
+`
+	var bp Blueprint
+	if err := yaml.Unmarshal([]byte(in), &bp); err != nil {
+		t.Fatal(err)
+	}
+	return bp
+}
+
+func TestApplyProfile(t *testing.T) {
+	bp := profilesBlueprint(t)
+	if err := bp.ApplyProfile("prod"); err != nil {
+		t.Fatal(err)
+	}
+	if got := bp.Vars.Get("machine_type"); !got.RawEquals(cty.StringVal("c2-standard-60")) {
+		t.Errorf("got machine_type %#v", got)
+	}
+	m, _ := bp.Module("compute")
+	want := map[string]cty.Value{"node_count": cty.NumberIntVal(100), "zone": cty.StringVal("us-central1-a")}
+	if diff := cmp.Diff(want, m.Settings.Items(), cmp.Comparer(cty.Value.RawEquals)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if bp.Profile != "prod" {
+		t.Errorf("got profile %q, want prod", bp.Profile)
+	}
+
+	// the profile and profiles are kept in the expanded blueprint
+	b, err := yaml.Marshal(bp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expanded Blueprint
+	if err := yaml.Unmarshal(b, &expanded); err != nil {
+		t.Fatal(err)
+	}
+	prod := expanded.Profiles["prod"]
+	if expanded.Profile != "prod" || !prod.Vars.Has("machine_type") {
+		t.Errorf("profile is not recorded in the expanded blueprint:\n%s", b)
+	}
+
+	// the recorded profile is not applied again, nor replaced
+	if err := bp.ApplyProfile("prod"); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+	if err := bp.ApplyProfile("dev"); err == nil {
+		t.Error("expected an error applying profile dev over prod")
+	}
+
+	bp = profilesBlueprint(t)
+	err = bp.ApplyProfile("staging")
+	if want := "profile staging is not defined, profiles of the blueprint are dev, prod"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestCheckProfiles(t *testing.T) {
+	bp := profilesBlueprint(t)
+	if err := checkProfiles(bp); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		edit func(*Blueprint)
+		want string
+	}{
+		{func(bp *Blueprint) { bp.Profiles["Prod"] = Profile{} }, "profile name \"Prod\""},
+		{func(bp *Blueprint) { bp.Profiles["dev"] = Profile{Settings: map[ModuleID]Dict{"login": {}}} }, "profile dev: "},
+		{func(bp *Blueprint) { bp.Profile = "staging" }, "profile staging is not defined"},
+	} {
+		bp := profilesBlueprint(t)
+		tc.edit(&bp)
+		if err := checkProfiles(bp); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got error %v, want %q", err, tc.want)
+		}
+	}
+}
+
+func TestProfileLabel(t *testing.T) {
+	dc := DeploymentConfig{Config: Blueprint{
+		BlueprintName: "bp",
+		Vars:          NewDict(map[string]cty.Value{"deployment_name": cty.StringVal("test")}),
+		Profile:       "prod",
+	}}
+	if err := dc.combineLabels(); err != nil {
+		t.Fatal(err)
+	}
+	got := dc.Config.Vars.Get("labels").GetAttr("ghpc_profile")
+	if !got.RawEquals(cty.StringVal("prod")) {
+		t.Errorf("got label ghpc_profile %#v, want \"prod\"", got)
+	}
+}
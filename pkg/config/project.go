@@ -0,0 +1,59 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ImpersonateSetting is the setting of the Google providers and of the gcs
+// backend that sets the service account they impersonate
+const ImpersonateSetting = "impersonate_service_account"
+
+// checkGroupProjects ensures that only Terraform groups of Google Cloud
+// deployments override the project or impersonate a service account
+func checkGroupProjects(bp Blueprint) error {
+	for _, g := range bp.DeploymentGroups {
+		if g.ProjectID == "" && g.ImpersonateServiceAccount == "" {
+			continue
+		}
+		if bp.TargetCloud().Name != GCP {
+			return fmt.Errorf("group %s: project_id and impersonate_service_account require cloud %q", g.Name, GCP)
+		}
+		if g.Kind != TerraformKind {
+			return fmt.Errorf("group %s: project_id and impersonate_service_account are only supported by \"kind: terraform\" groups", g.Name)
+		}
+		if g.ProjectID != "" && g.BootstrapProject != nil {
+			return fmt.Errorf("group %s: bootstrap groups create the project of the deployment and cannot set project_id", g.Name)
+		}
+		if sa := g.ImpersonateServiceAccount; sa != "" && !strings.Contains(sa, "@") {
+			return fmt.Errorf("group %s: impersonate_service_account must be the email of a service account, got %q", g.Name, sa)
+		}
+	}
+	return nil
+}
+
+// GroupVars returns the deployment variables of the group: those of the
+// blueprint, with the project variable set to the project_id of the group
+func (bp Blueprint) GroupVars(g DeploymentGroup) Dict {
+	vars := NewDict(bp.Vars.Items())
+	if p := bp.TargetCloud().ProjectVar; g.ProjectID != "" && p != "" {
+		vars.Set(p, cty.StringVal(g.ProjectID))
+	}
+	return vars
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestCheckGroupProjects(t *testing.T) {
+	blueprint := func() Blueprint {
+		return Blueprint{DeploymentGroups: []DeploymentGroup{
+			{Name: "host", Kind: TerraformKind},
+			{Name: "service", Kind: TerraformKind, ProjectID: "service-project",
+				ImpersonateServiceAccount: "deployer@service-project.iam.gserviceaccount.com"},
+		}}
+	}
+	if err := checkGroupProjects(blueprint()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for name, edit := range map[string]func(*Blueprint){
+		"aws":             func(bp *Blueprint) { bp.Cloud = AWS },
+		"packer":          func(bp *Blueprint) { bp.DeploymentGroups[1].Kind = PackerKind },
+		"bootstrap":       func(bp *Blueprint) { bp.DeploymentGroups[1].BootstrapProject = &Dict{} },
+		"service account": func(bp *Blueprint) { bp.DeploymentGroups[1].ImpersonateServiceAccount = "deployer" },
+	} {
+		bp := blueprint()
+		edit(&bp)
+		if err := checkGroupProjects(bp); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestGroupVars(t *testing.T) {
+	bp := Blueprint{Vars: NewDict(map[string]cty.Value{
+		"project_id": cty.StringVal("host-project"),
+		"region":     cty.StringVal("us-central1"),
+	})}
+	host := DeploymentGroup{Name: "host"}
+	service := DeploymentGroup{Name: "service", ProjectID: "service-project"}
+
+	if got := bp.GroupVars(host); !got.AsObject().RawEquals(bp.Vars.AsObject()) {
+		t.Errorf("GroupVars(host) = %#v, want the variables of the blueprint", got.Items())
+	}
+	got := bp.GroupVars(service)
+	if p := got.Get("project_id"); p != cty.StringVal("service-project") {
+		t.Errorf("project_id = %#v, want service-project", p)
+	}
+	if p := bp.Vars.Get("project_id"); p != cty.StringVal("host-project") {
+		t.Errorf("the variables of the blueprint were modified: project_id = %#v", p)
+	}
+}
+
+func TestExpandBackendsImpersonation(t *testing.T) {
+	sa := "deployer@service-project.iam.gserviceaccount.com"
+	dc := DeploymentConfig{Config: Blueprint{
+		BlueprintName:            "bp",
+		TerraformBackendDefaults: TerraformBackend{Type: "gcs"},
+		DeploymentGroups: []DeploymentGroup{
+			{Name: "host"},
+			{Name: "service", ImpersonateServiceAccount: sa},
+		}}}
+	if err := dc.expandBackends(); err != nil {
+		t.Fatal(err)
+	}
+	if dc.Config.DeploymentGroups[0].TerraformBackend.Configuration.Has(ImpersonateSetting) {
+		t.Errorf("the backend of group host must not impersonate a service account")
+	}
+	if got := dc.Config.DeploymentGroups[1].TerraformBackend.Configuration.Get(ImpersonateSetting); got != cty.StringVal(sa) {
+		t.Errorf("%s = %#v, want %s", ImpersonateSetting, got, sa)
+	}
+}
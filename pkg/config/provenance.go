@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ProvenanceOutput is the Terraform output of each group holding the
+// provenance of the blueprint, if Provenance.Outputs is set
+const ProvenanceOutput = "ghpc_provenance"
+
+// names of the provenance labels, following the prefix of ToolkitLabels
+const (
+	commitLabel string = "commit"
+	dirtyLabel  string = "dirty"
+)
+
+// Provenance records the git revision of the blueprint a deployment was
+// created from. It is recorded in the expanded blueprint if the blueprint sets
+// provenance, even to an empty map.
+type Provenance struct {
+	// Labels adds the commit and dirty labels to the deployment labels
+	Labels bool `yaml:"labels,omitempty"`
+	// Outputs adds the ghpc_provenance output to Terraform groups
+	Outputs bool `yaml:"outputs,omitempty"`
+	// Commit is the SHA of the HEAD commit of the git repository of the
+	// blueprint, set by ghpc
+	Commit string `yaml:"commit,omitempty"`
+	// Dirty is set if tracked files of the repository have uncommitted changes
+	Dirty bool `yaml:"dirty,omitempty"`
+	// Path of the blueprint relative to the root of the repository
+	Path string `yaml:"path,omitempty"`
+}
+
+// RecordProvenance records the git revision of the blueprint at path if the
+// blueprint sets provenance, the revision was not recorded by an earlier
+// expansion and the blueprint is in a git repository with commits
+func (bp *Blueprint) RecordProvenance(path string) error {
+	p := bp.Provenance
+	if p == nil || p.Commit != "" {
+		return nil
+	}
+	abs, err := filepath.Abs(path)
+	if err == nil {
+		abs, err = filepath.EvalSymlinks(abs)
+	}
+	if err != nil {
+		return err
+	}
+	repo, err := git.PlainOpenWithOptions(filepath.Dir(abs), &git.PlainOpenOptions{DetectDotGit: true})
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open the git repository of %s: %v", path, err)
+	}
+	head, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil // no commits yet
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the HEAD of the git repository of %s: %v", path, err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to read the worktree of the git repository of %s: %v", path, err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return fmt.Errorf("failed to read the status of the git repository of %s: %v", path, err)
+	}
+	root, err := filepath.EvalSymlinks(wt.Filesystem.Root())
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return err
+	}
+
+	p.Commit = head.Hash().String()
+	p.Path = filepath.ToSlash(rel)
+	p.Dirty = false
+	for _, s := range status {
+		// untracked files do not change the blueprint
+		if s.Worktree != git.Untracked && (s.Staging != git.Unmodified || s.Worktree != git.Unmodified) {
+			p.Dirty = true
+			break
+		}
+	}
+	return nil
+}
+
+// provenanceLabels returns the labels of the recorded provenance, if the
+// blueprint opts in to them
+func (bp Blueprint) provenanceLabels() map[string]cty.Value {
+	p, tl := bp.Provenance, bp.ToolkitLabels
+	if p == nil || !p.Labels || p.Commit == "" || tl.Disabled {
+		return map[string]cty.Value{}
+	}
+	return map[string]cty.Value{
+		tl.Key(commitLabel): cty.StringVal(p.Commit),
+		tl.Key(dirtyLabel):  cty.StringVal(fmt.Sprint(p.Dirty)),
+	}
+}
+
+// ProvenanceValue returns the recorded provenance as an object, or a null
+// value if the blueprint does not opt in to the provenance outputs
+func (bp Blueprint) ProvenanceValue() cty.Value {
+	p := bp.Provenance
+	if p == nil || !p.Outputs || p.Commit == "" {
+		return cty.NilVal
+	}
+	return cty.ObjectVal(map[string]cty.Value{
+		"commit": cty.StringVal(p.Commit),
+		"dirty":  cty.BoolVal(p.Dirty),
+		"path":   cty.StringVal(p.Path),
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// provenanceRepo returns a git repository with a committed blueprint at
+// blueprints/hpc.yaml and the SHA of the commit
+func provenanceRepo(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "blueprints"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "blueprints", "hpc.yaml"), []byte("blueprint_name: hpc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("blueprints/hpc.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	h, err := wt.Commit("blueprint", &git.CommitOptions{Author: sig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, h.String()
+}
+
+func TestRecordProvenance(t *testing.T) {
+	dir, sha := provenanceRepo(t)
+	path := filepath.Join(dir, "blueprints", "hpc.yaml")
+
+	record := func(bp Blueprint, path string) *Provenance {
+		t.Helper()
+		if err := bp.RecordProvenance(path); err != nil {
+			t.Fatal(err)
+		}
+		return bp.Provenance
+	}
+
+	if p := record(Blueprint{}, path); p != nil {
+		t.Errorf("recorded provenance %#v without opting in", p)
+	}
+
+	clean := &Provenance{Labels: true, Commit: sha, Path: "blueprints/hpc.yaml"}
+	if diff := cmp.Diff(clean, record(Blueprint{Provenance: &Provenance{Labels: true}}, path)); diff != "" {
+		t.Errorf("diff of provenance (-want +got):\n%s", diff)
+	}
+
+	// untracked files do not make the repository dirty
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := record(Blueprint{Provenance: &Provenance{}}, path); got.Dirty {
+		t.Error("untracked file made the repository dirty")
+	}
+
+	if err := os.WriteFile(path, []byte("blueprint_name: changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := record(Blueprint{Provenance: &Provenance{}}, path); !got.Dirty {
+		t.Error("modified blueprint did not make the repository dirty")
+	}
+
+	// the provenance of an expanded blueprint is kept
+	recorded := &Provenance{Commit: "0123abcd", Path: "other.yaml"}
+	if diff := cmp.Diff(recorded, record(Blueprint{Provenance: &Provenance{Commit: "0123abcd", Path: "other.yaml"}}, path)); diff != "" {
+		t.Errorf("diff of provenance (-want +got):\n%s", diff)
+	}
+
+	// blueprints outside of git repositories have no provenance
+	outside := filepath.Join(t.TempDir(), "hpc.yaml")
+	if err := os.WriteFile(outside, []byte("blueprint_name: hpc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := record(Blueprint{Provenance: &Provenance{}}, outside); got.Commit != "" {
+		t.Errorf("got provenance %#v outside of a git repository", got)
+	}
+}
+
+func TestProvenanceLabelsAndOutputs(t *testing.T) {
+	p := &Provenance{Commit: "0123abcd", Dirty: true, Path: "hpc.yaml"}
+	bp := Blueprint{Provenance: p}
+	if got := bp.provenanceLabels(); len(got) != 0 {
+		t.Errorf("got labels %#v without opting in", got)
+	}
+	if got := bp.ProvenanceValue(); !got.IsNull() {
+		t.Errorf("got output %#v without opting in", got)
+	}
+
+	p.Labels, p.Outputs = true, true
+	bp.ToolkitLabels.Prefix = "acme_"
+	wantLabels := cty.ObjectVal(map[string]cty.Value{
+		"acme_commit": cty.StringVal("0123abcd"),
+		"acme_dirty":  cty.StringVal("true"),
+	})
+	if got := cty.ObjectVal(bp.provenanceLabels()); !got.RawEquals(wantLabels) {
+		t.Errorf("got labels %#v, want %#v", got, wantLabels)
+	}
+	wantOutput := cty.ObjectVal(map[string]cty.Value{
+		"commit": cty.StringVal("0123abcd"),
+		"dirty":  cty.True,
+		"path":   cty.StringVal("hpc.yaml"),
+	})
+	if got := bp.ProvenanceValue(); !got.RawEquals(wantOutput) {
+		t.Errorf("got output %#v, want %#v", got, wantOutput)
+	}
+
+	bp.ToolkitLabels.Disabled = true
+	if got := bp.provenanceLabels(); len(got) != 0 {
+		t.Errorf("got labels %#v with toolkit labels disabled", got)
+	}
+}
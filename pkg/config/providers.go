@@ -0,0 +1,230 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// TerraformProvider customizes a provider of the Terraform deployment groups,
+// set in terraform_providers by its name, or by "<name>.<alias>" for an
+// additional configuration of the provider
+type TerraformProvider struct {
+	// Source and Version are written to required_providers; they default to
+	// those of the provider of the cloud of the same name
+	Source  string `yaml:"source,omitempty"`
+	Version string `yaml:"version,omitempty"`
+	// Configuration sets arguments of the provider block, which may reference
+	// deployment variables, over those set by ghpc
+	Configuration Dict `yaml:"configuration,omitempty"`
+}
+
+// ProviderBlock is a provider block of a Terraform deployment group
+type ProviderBlock struct {
+	Name  string
+	Alias string
+	// Arguments are literal values or expressions of deployment variables
+	Arguments []ProviderArgument
+	// Blocks are empty nested blocks, e.g. features of azurerm
+	Blocks []string
+}
+
+// ProviderArgument is an argument of a provider block
+type ProviderArgument struct {
+	Name  string
+	Value cty.Value
+}
+
+// Ref returns the reference to the provider block in the providers argument
+// of modules, e.g. "google.europe"
+func (b ProviderBlock) Ref() string {
+	if b.Alias == "" {
+		return b.Name
+	}
+	return b.Name + "." + b.Alias
+}
+
+func (b *ProviderBlock) set(name string, v cty.Value) {
+	for i, a := range b.Arguments {
+		if a.Name == name {
+			b.Arguments[i].Value = v
+			return
+		}
+	}
+	b.Arguments = append(b.Arguments, ProviderArgument{name, v})
+}
+
+// googleSources are the sources of the providers that impersonate the
+// service account of a group
+var googleSources = []string{"hashicorp/google", "hashicorp/google-beta"}
+
+// RequiredProviders returns the providers of the deployment groups, with
+// their source and version: those of the cloud, then the other providers of
+// terraform_providers by name
+func (bp Blueprint) RequiredProviders() []Provider {
+	res := []Provider{}
+	known := map[string]bool{}
+	for _, p := range bp.TargetCloud().Providers {
+		known[p.Name] = true
+		if tp, ok := bp.TerraformProviders[p.Name]; ok {
+			if tp.Source != "" {
+				p.Source = tp.Source
+			}
+			if tp.Version != "" {
+				p.Version = tp.Version
+			}
+		}
+		res = append(res, p)
+	}
+	keys := maps.Keys(bp.TerraformProviders)
+	slices.Sort(keys)
+	for _, k := range keys {
+		if strings.Contains(k, ".") || known[k] {
+			continue
+		}
+		tp := bp.TerraformProviders[k]
+		res = append(res, Provider{Name: k, Source: tp.Source, Version: tp.Version})
+	}
+	return res
+}
+
+// ProviderBlocks returns the provider blocks of a Terraform group: those of
+// the required providers, with the settings of the cloud that are set to the
+// deployment variables in vars, then their aliases, which start from the
+// arguments of the provider they configure. The configuration of
+// terraform_providers is set over them, and the Google providers
+// impersonate the service account of the group, if any.
+func (bp Blueprint) ProviderBlocks(g DeploymentGroup, vars map[string]cty.Value) []ProviderBlock {
+	res := []ProviderBlock{}
+	for _, p := range bp.RequiredProviders() {
+		base := ProviderBlock{Name: p.Name, Blocks: slices.Clone(p.Blocks)}
+		for _, s := range p.Settings {
+			if _, ok := vars[s.Var]; ok {
+				base.set(s.Name, GlobalRef(s.Var).AsExpression().AsValue())
+			}
+		}
+		if sa := g.ImpersonateServiceAccount; sa != "" && slices.Contains(googleSources, p.Source) {
+			base.set(ImpersonateSetting, cty.StringVal(sa))
+		}
+
+		configure := func(b ProviderBlock, key string) ProviderBlock {
+			b.Arguments = slices.Clone(b.Arguments)
+			conf := bp.TerraformProviders[key].Configuration
+			items := conf.Items()
+			names := maps.Keys(items)
+			slices.Sort(names)
+			for _, n := range names {
+				b.set(n, items[n])
+			}
+			return b
+		}
+		block := configure(base, p.Name)
+		res = append(res, block)
+
+		aliases := []string{}
+		for k := range bp.TerraformProviders {
+			if name, alias, ok := strings.Cut(k, "."); ok && name == p.Name {
+				aliases = append(aliases, alias)
+			}
+		}
+		slices.Sort(aliases)
+		for _, a := range aliases {
+			ab := block
+			ab.Alias = a
+			res = append(res, configure(ab, p.Name+"."+a))
+		}
+	}
+	return res
+}
+
+// ProviderVars returns the deployment variables referenced by the
+// configuration of terraform_providers
+func (bp Blueprint) ProviderVars() []string {
+	res := []string{}
+	for _, tp := range bp.TerraformProviders {
+		res = append(res, GetUsedDeploymentVars(tp.Configuration.AsObject())...)
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+// checkTerraformProviders ensures that the providers of terraform_providers
+// and the providers of modules are valid
+func checkTerraformProviders(bp Blueprint) error {
+	names := map[string]bool{}
+	for _, p := range bp.RequiredProviders() {
+		names[p.Name] = true
+	}
+	refs := map[string]bool{}
+	for k, tp := range bp.TerraformProviders {
+		name, alias, isAlias := strings.Cut(k, ".")
+		if !hclsyntax.ValidIdentifier(name) || (isAlias && !hclsyntax.ValidIdentifier(alias)) {
+			return fmt.Errorf("terraform_providers: %q must be the name of a provider, or <name>.<alias>", k)
+		}
+		if isAlias {
+			if !names[name] {
+				return fmt.Errorf("terraform_providers: %s is an alias of provider %s, which is not a provider of the deployment", k, name)
+			}
+			if tp.Source != "" || tp.Version != "" {
+				return fmt.Errorf("terraform_providers: alias %s cannot set source or version, they are those of provider %s", k, name)
+			}
+		} else if tp.Source == "" && !slices.ContainsFunc(bp.TargetCloud().Providers, func(p Provider) bool { return p.Name == k }) {
+			return fmt.Errorf("terraform_providers: provider %s must set source", k)
+		}
+		for _, n := range GetUsedDeploymentVars(tp.Configuration.AsObject()) {
+			if !bp.Vars.Has(n) {
+				return fmt.Errorf("terraform_providers: %s references vars.%s, which is not defined", k, n)
+			}
+		}
+		err := cty.Walk(tp.Configuration.AsObject(), func(_ cty.Path, v cty.Value) (bool, error) {
+			if e, is := IsExpressionValue(v); is {
+				for _, r := range e.References() {
+					if !r.GlobalVar {
+						return false, fmt.Errorf("terraform_providers: %s can only reference deployment variables", k)
+					}
+				}
+			}
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		refs[k] = true
+	}
+	for n := range names {
+		refs[n] = true
+	}
+
+	return bp.WalkModules(func(m *Module) error {
+		if len(m.Providers) == 0 {
+			return nil
+		}
+		if m.Kind != TerraformKind {
+			return fmt.Errorf("module %s: providers can only be set for Terraform modules", m.ID)
+		}
+		for local, ref := range m.Providers {
+			if !refs[ref] {
+				return fmt.Errorf("module %s: provider %s is set to %s, which is not a provider of the deployment", m.ID, local, ref)
+			}
+		}
+		return nil
+	})
+}
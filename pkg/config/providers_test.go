@@ -0,0 +1,125 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func providersBlueprint() Blueprint {
+	return Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("my-project"),
+			"region":     cty.StringVal("us-central1"),
+		}),
+		TerraformProviders: map[string]TerraformProvider{
+			"google": {Version: "~> 5.0"},
+			"google.europe": {Configuration: NewDict(map[string]cty.Value{
+				"region": cty.StringVal("europe-west1"),
+			})},
+			"kubernetes": {Source: "hashicorp/kubernetes", Configuration: NewDict(map[string]cty.Value{
+				"config_path": cty.StringVal("~/.kube/config"),
+			})},
+		},
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Kind: TerraformKind, Modules: []Module{
+			{ID: "vm", Kind: TerraformKind, Providers: map[string]string{"google": "google.europe"}},
+		}}},
+	}
+}
+
+func TestRequiredProviders(t *testing.T) {
+	got := providersBlueprint().RequiredProviders()
+	want := []Provider{
+		{Name: "google", Source: "hashicorp/google", Version: "~> 5.0", Settings: googleProviderSettings},
+		{Name: "google-beta", Source: "hashicorp/google-beta", Version: googleProviderVersion, Settings: googleProviderSettings},
+		{Name: "kubernetes", Source: "hashicorp/kubernetes"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestProviderBlocks(t *testing.T) {
+	bp := providersBlueprint()
+	g := DeploymentGroup{Name: "primary", ImpersonateServiceAccount: "sa@my-project.iam.gserviceaccount.com"}
+	vars := map[string]cty.Value{"project_id": cty.StringVal("my-project")}
+
+	projectRef := GlobalRef("project_id").AsExpression().AsValue()
+	sa := cty.StringVal(g.ImpersonateServiceAccount)
+	want := []ProviderBlock{
+		{Name: "google", Arguments: []ProviderArgument{{"project", projectRef}, {ImpersonateSetting, sa}}},
+		{Name: "google", Alias: "europe", Arguments: []ProviderArgument{
+			{"project", projectRef}, {ImpersonateSetting, sa}, {"region", cty.StringVal("europe-west1")}}},
+		{Name: "google-beta", Arguments: []ProviderArgument{{"project", projectRef}, {ImpersonateSetting, sa}}},
+		{Name: "kubernetes", Arguments: []ProviderArgument{{"config_path", cty.StringVal("~/.kube/config")}}},
+	}
+	got := bp.ProviderBlocks(g, vars)
+	if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b cty.Value) bool { return a.RawEquals(b) })); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if ref := got[1].Ref(); ref != "google.europe" {
+		t.Errorf("Ref() = %q, want google.europe", ref)
+	}
+}
+
+func TestProviderVars(t *testing.T) {
+	bp := providersBlueprint()
+	bp.TerraformProviders["google.europe"] = TerraformProvider{Configuration: NewDict(map[string]cty.Value{
+		"region": GlobalRef("region").AsExpression().AsValue(),
+	})}
+	if diff := cmp.Diff([]string{"region"}, bp.ProviderVars()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckTerraformProviders(t *testing.T) {
+	if err := checkTerraformProviders(providersBlueprint()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for name, edit := range map[string]func(*Blueprint){
+		"invalid name": func(bp *Blueprint) { bp.TerraformProviders["google europe"] = TerraformProvider{} },
+		"unknown alias": func(bp *Blueprint) {
+			bp.TerraformProviders["aws.east"] = TerraformProvider{}
+		},
+		"alias version": func(bp *Blueprint) {
+			bp.TerraformProviders["google.europe"] = TerraformProvider{Version: "~> 5.1"}
+		},
+		"no source": func(bp *Blueprint) { bp.TerraformProviders["helm"] = TerraformProvider{} },
+		"undefined var": func(bp *Blueprint) {
+			bp.TerraformProviders["google.europe"] = TerraformProvider{Configuration: NewDict(map[string]cty.Value{
+				"zone": GlobalRef("zone").AsExpression().AsValue(),
+			})}
+		},
+		"module reference": func(bp *Blueprint) {
+			bp.TerraformProviders["google.europe"] = TerraformProvider{Configuration: NewDict(map[string]cty.Value{
+				"region": ModuleRef("vm", "region").AsExpression().AsValue(),
+			})}
+		},
+		"unknown module provider": func(bp *Blueprint) {
+			bp.DeploymentGroups[0].Modules[0].Providers = map[string]string{"google": "google.asia"}
+		},
+		"packer module provider": func(bp *Blueprint) { bp.DeploymentGroups[0].Modules[0].Kind = PackerKind },
+	} {
+		bp := providersBlueprint()
+		edit(&bp)
+		if err := checkTerraformProviders(bp); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
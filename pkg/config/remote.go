@@ -0,0 +1,250 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/gcsupload"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// RemoteOutputs reads the outputs of a separately deployed deployment into
+// deployment variables, e.g. the network of a long-lived deployment shared by
+// short-lived cluster deployments
+type RemoteOutputs struct {
+	// Source is the artifact_store of the other deployment, a
+	// gs://<bucket>/<prefix> URL, or its deployment directory
+	Source string `yaml:"source"`
+	// Outputs are the names of the outputs read, all outputs if empty
+	Outputs []string `yaml:"outputs,omitempty"`
+	// Prefix is prepended to the names of the outputs to name the variables
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+// remoteOutputsFile is the file ghpc deploy writes the outputs of a
+// deployment to, in its artifacts directory and artifact store
+const remoteOutputsFile = "deployment_outputs.json"
+
+var errNoRemoteOutputs = errors.New("no outputs found; deploy the deployment, with an outputs block, first")
+
+// Matches strings of form `$(remote_output("SOURCE", "OUTPUT"))`
+var remoteOutputExp *regexp.Regexp = regexp.MustCompile(
+	`^\$\(\s*remote_output\(\s*("(?:[^"\\]|\\.)*")\s*,\s*("(?:[^"\\]|\\.)*")\s*\)\s*\)$`)
+
+// RemoteOutput is an output of a deployment as written to its outputs file
+type RemoteOutput struct {
+	Value     cty.Value
+	Sensitive bool
+}
+
+// RemoteOutputRef is a reference to a sensitive output of another deployment,
+// the value of a deployment variable that is only read when the deployment
+// is deployed, so that the output is never written to the deployment
+type RemoteOutputRef struct {
+	Source string
+	Output string
+}
+
+// String returns the reference as the value of a deployment variable
+func (r RemoteOutputRef) String() string {
+	return fmt.Sprintf("$(remote_output(%q, %q))", r.Source, r.Output)
+}
+
+// Read returns the value of the output
+func (r RemoteOutputRef) Read() (cty.Value, error) {
+	outputs, err := ReadRemoteOutputs(r.Source)
+	if err != nil {
+		return cty.NilVal, fmt.Errorf("remote_outputs of %s: %w", r.Source, err)
+	}
+	o, ok := outputs[r.Output]
+	if !ok {
+		return cty.NilVal, fmt.Errorf("remote_outputs of %s: the deployment has no output %s", r.Source, r.Output)
+	}
+	return o.Value, nil
+}
+
+// parseRemoteOutputRef takes `$(remote_output("SOURCE", "OUTPUT"))` and
+// returns the reference
+func parseRemoteOutputRef(s string) (RemoteOutputRef, error) {
+	m := remoteOutputExp.FindStringSubmatch(s)
+	if m == nil {
+		return RemoteOutputRef{}, fmt.Errorf("%#v is not a remote output reference", s)
+	}
+	src, err := strconv.Unquote(m[1])
+	if err != nil {
+		return RemoteOutputRef{}, fmt.Errorf("invalid remote output reference %#v: %w", s, err)
+	}
+	out, err := strconv.Unquote(m[2])
+	if err != nil {
+		return RemoteOutputRef{}, fmt.Errorf("invalid remote output reference %#v: %w", s, err)
+	}
+	return RemoteOutputRef{Source: src, Output: out}, nil
+}
+
+// isRemoteOutputString checks if the entire string is a remote output reference
+func isRemoteOutputString(s string) bool {
+	return remoteOutputExp.MatchString(s)
+}
+
+// remoteOutputRefOf returns the remote output reference that v is, if any
+func remoteOutputRefOf(v cty.Value) (RemoteOutputRef, bool) {
+	if v.IsMarked() || v.Type() != cty.String || v.IsNull() || !v.IsKnown() || !isRemoteOutputString(v.AsString()) {
+		return RemoteOutputRef{}, false
+	}
+	r, err := parseRemoteOutputRef(v.AsString())
+	return r, err == nil
+}
+
+// RemoteOutputVars returns the references of the deployment variables whose
+// values are sensitive outputs of other deployments, by variable name
+func (bp Blueprint) RemoteOutputVars() map[string]RemoteOutputRef {
+	res := map[string]RemoteOutputRef{}
+	for n, v := range bp.Vars.Items() {
+		if r, ok := remoteOutputRefOf(v); ok {
+			res[n] = r
+		}
+	}
+	return res
+}
+
+// ReadRemoteOutputs reads the outputs of the deployment at source
+func ReadRemoteOutputs(source string) (map[string]RemoteOutput, error) {
+	var b []byte
+	var err error
+	if strings.HasPrefix(source, "gs://") {
+		b, err = downloadRemoteOutputs(source)
+	} else {
+		b, err = os.ReadFile(filepath.Join(source, ".ghpc", "artifacts", remoteOutputsFile))
+		if errors.Is(err, os.ErrNotExist) {
+			err = errNoRemoteOutputs
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]struct {
+		Value     json.RawMessage `json:"value"`
+		Sensitive bool            `json:"sensitive"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", remoteOutputsFile, err)
+	}
+	res := map[string]RemoteOutput{}
+	for n, o := range raw {
+		if o.Value == nil {
+			return nil, fmt.Errorf("invalid %s: output %s has no value", remoteOutputsFile, n)
+		}
+		ty, err := ctyjson.ImpliedType(o.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: output %s: %w", remoteOutputsFile, n, err)
+		}
+		v, err := ctyjson.Unmarshal(o.Value, ty)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: output %s: %w", remoteOutputsFile, n, err)
+		}
+		res[n] = RemoteOutput{Value: v, Sensitive: o.Sensitive}
+	}
+	return res, nil
+}
+
+// downloadRemoteOutputs downloads the outputs of the deployment whose
+// artifact store is store
+func downloadRemoteOutputs(store string) ([]byte, error) {
+	ctx := context.Background()
+	u, err := gcsupload.NewUploader(ctx, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "ghpc-remote-outputs-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, remoteOutputsFile)
+	found, err := u.Download(ctx, strings.TrimSuffix(store, "/")+"/"+remoteOutputsFile, dst)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errNoRemoteOutputs
+	}
+	return os.ReadFile(dst)
+}
+
+// applyRemoteOutputs sets the deployment variables of the remote outputs.
+// Variables set in the blueprint or on the command line take precedence, and
+// the outputs are not read, with read, if all the variables are set.
+// Sensitive outputs are set as references, read when the deployment is
+// deployed, and their variables are declared sensitive.
+func (bp *Blueprint) applyRemoteOutputs(read func(string) (map[string]RemoteOutput, error)) error {
+	for _, ro := range bp.RemoteOutputs {
+		if ro.Source == "" {
+			return errors.New("remote_outputs: source is required")
+		}
+		if len(ro.Outputs) > 0 && !slices.ContainsFunc(ro.Outputs, func(n string) bool { return !bp.Vars.Has(ro.Prefix + n) }) {
+			continue
+		}
+
+		outputs, err := read(ro.Source)
+		if err != nil {
+			return fmt.Errorf("remote_outputs of %s: %w", ro.Source, err)
+		}
+		names := ro.Outputs
+		if len(names) == 0 {
+			names = maps.Keys(outputs)
+			slices.Sort(names)
+		}
+		for _, n := range names {
+			o, ok := outputs[n]
+			if !ok {
+				return fmt.Errorf("remote_outputs of %s: the deployment has no output %s", ro.Source, n)
+			}
+			name := ro.Prefix + n
+			if bp.Vars.Has(name) {
+				continue
+			}
+			if !o.Sensitive {
+				bp.Vars.Set(name, o.Value)
+				continue
+			}
+			bp.Vars.Set(name, cty.StringVal(RemoteOutputRef{Source: ro.Source, Output: n}.String()))
+			if bp.DeploymentVariables == nil {
+				bp.DeploymentVariables = map[string]VariableDeclaration{}
+			}
+			d, declared := bp.DeploymentVariables[name]
+			if !declared {
+				d.Type = typeexpr.TypeString(o.Value.Type())
+			}
+			d.Sensitive = true
+			bp.DeploymentVariables[name] = d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestApplyRemoteOutputs(t *testing.T) {
+	dir := t.TempDir()
+	artifacts := filepath.Join(dir, ".ghpc", "artifacts")
+	if err := os.MkdirAll(artifacts, 0755); err != nil {
+		t.Fatal(err)
+	}
+	outputs := `{
+  "network_name": {"value": "net", "sensitive": false},
+  "subnetworks": {"value": ["a", "b"], "sensitive": false},
+  "db_password": {"value": "hunter2", "sensitive": true}
+}`
+	if err := os.WriteFile(filepath.Join(artifacts, remoteOutputsFile), []byte(outputs), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bp := Blueprint{
+		Vars: NewDict(map[string]cty.Value{"network_name": cty.StringVal("mine")}),
+		RemoteOutputs: []RemoteOutputs{
+			{Source: dir, Outputs: []string{"network_name"}},
+			{Source: dir, Prefix: "shared_"},
+		},
+	}
+	if err := bp.applyRemoteOutputs(ReadRemoteOutputs); err != nil {
+		t.Fatal(err)
+	}
+	ref := RemoteOutputRef{Source: dir, Output: "db_password"}
+	want := map[string]cty.Value{
+		// set in the blueprint
+		"network_name":        cty.StringVal("mine"),
+		"shared_network_name": cty.StringVal("net"),
+		"shared_subnetworks":  cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+		// sensitive, read when the deployment is deployed
+		"shared_db_password": cty.StringVal(ref.String()),
+	}
+	if diff := cmp.Diff(want, bp.Vars.Items(), cmp.Comparer(cty.Value.RawEquals)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	wantDecls := map[string]VariableDeclaration{
+		"shared_db_password": {Type: "string", Sensitive: true},
+	}
+	if diff := cmp.Diff(wantDecls, bp.DeploymentVariables); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[string]RemoteOutputRef{"shared_db_password": ref}, bp.RemoteOutputVars()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	v, err := ref.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.RawEquals(cty.StringVal("hunter2")) {
+		t.Errorf("got %#v, want \"hunter2\"", v)
+	}
+
+	// outputs are not read if all the variables are set
+	bp = Blueprint{
+		Vars:          NewDict(map[string]cty.Value{"network_name": cty.StringVal("mine")}),
+		RemoteOutputs: []RemoteOutputs{{Source: filepath.Join(dir, "missing"), Outputs: []string{"network_name"}}},
+	}
+	if err := bp.applyRemoteOutputs(ReadRemoteOutputs); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		ro   RemoteOutputs
+		want string
+	}{
+		{RemoteOutputs{}, "source is required"},
+		{RemoteOutputs{Source: filepath.Join(dir, "missing")}, "no outputs found"},
+		{RemoteOutputs{Source: dir, Outputs: []string{"network_self_link"}}, "the deployment has no output network_self_link"},
+	} {
+		bp := Blueprint{RemoteOutputs: []RemoteOutputs{tc.ro}}
+		if err := bp.applyRemoteOutputs(ReadRemoteOutputs); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%#v: got error %v, want %q", tc.ro, err, tc.want)
+		}
+	}
+}
+
+func TestParseRemoteOutputRef(t *testing.T) {
+	for _, r := range []RemoteOutputRef{
+		{Source: "gs://bucket/network", Output: "password"},
+		{Source: `/dir with "quotes"`, Output: "token"},
+	} {
+		got, err := parseRemoteOutputRef(r.String())
+		if err != nil {
+			t.Errorf("%#v: %v", r, err)
+		} else if got != r {
+			t.Errorf("got %#v, want %#v", got, r)
+		}
+	}
+
+	for _, s := range []string{
+		`$(remote_output("gs://bucket"))`,
+		`$(vars.remote_output)`,
+		`remote_output("a", "b")`,
+	} {
+		if _, err := parseRemoteOutputRef(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
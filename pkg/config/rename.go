@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// moduleNodes returns the nodes of the modules of a blueprint document
+func moduleNodes(root *yaml.Node) []*yaml.Node {
+	mods := []*yaml.Node{}
+	groups := mappingValue(root, "deployment_groups")
+	if groups == nil {
+		return mods
+	}
+	for _, g := range groups.Content {
+		if ms := mappingValue(g, "modules"); ms != nil {
+			mods = append(mods, ms.Content...)
+		}
+	}
+	return mods
+}
+
+// renameReferences replaces the references to a module in the strings of a
+// node: $(from.output), and module.from.output in HCL literals and raw HCL
+func renameReferences(n *yaml.Node, from ModuleID, to ModuleID) {
+	simple := regexp.MustCompile(`(^|[^\\])\$\(` + regexp.QuoteMeta(string(from)) + `\.`)
+	literal := regexp.MustCompile(`\bmodule\.` + regexp.QuoteMeta(string(from)) + `([^\w-]|$)`)
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode && n.Tag == "!!str" {
+			n.Value = simple.ReplaceAllString(n.Value, "${1}$$("+string(to)+".")
+			if _, is := IsYamlExpressionLiteral(cty.StringVal(n.Value)); is {
+				n.Value = literal.ReplaceAllString(n.Value, "module."+string(to)+"${1}")
+			}
+		}
+		if n.Kind == yaml.ScalarNode && n.Tag == RawHCLTag {
+			n.Value = literal.ReplaceAllString(n.Value, "module."+string(to)+"${1}")
+		}
+		for i, c := range n.Content {
+			if n.Kind == yaml.MappingNode && i%2 == 0 {
+				continue // keys
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+}
+
+// renameModule renames a module of a blueprint document, and the references
+// to it. The previous ID is added to the moved_from of Terraform modules.
+func renameModule(root *yaml.Node, from ModuleID, to ModuleID) error {
+	if !hclsyntax.ValidIdentifier(string(to)) {
+		return fmt.Errorf("%q is not a valid module ID", to)
+	}
+	var mod *yaml.Node
+	for _, m := range moduleNodes(root) {
+		id := mappingValue(m, "id")
+		if id == nil {
+			continue
+		}
+		switch ModuleID(id.Value) {
+		case to:
+			return fmt.Errorf("the blueprint already has a module %s", to)
+		case from:
+			mod = m
+		}
+	}
+	if mod == nil {
+		return fmt.Errorf("the blueprint has no module %s", from)
+	}
+
+	for _, m := range moduleNodes(root) {
+		if use := mappingValue(m, "use"); use != nil {
+			for _, u := range use.Content {
+				if um := mappingValue(u, "module"); um != nil {
+					u = um // use with a map
+				}
+				if ModuleID(u.Value) == from {
+					u.Value = string(to)
+				}
+			}
+		}
+	}
+	renameReferences(root, from, to)
+	mappingValue(mod, "id").Value = string(to)
+
+	if kind := mappingValue(mod, "kind"); kind != nil && kind.Value != TerraformKind.String() {
+		return nil
+	}
+	moved := mappingValue(mod, "moved_from")
+	if moved == nil {
+		moved = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+		mod.Content = append(mod.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "moved_from"}, moved)
+	}
+	// renaming a module back to a previous ID does not move it from itself
+	content := []*yaml.Node{}
+	for _, c := range moved.Content {
+		if ModuleID(c.Value) != to {
+			content = append(content, c)
+		}
+	}
+	moved.Content = append(content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(from)})
+	return nil
+}
+
+// RenameModuleFile renames a module of a blueprint file in place, and the
+// references to it in use and in expressions. The previous ID is added to the
+// moved_from of Terraform modules, so that the state of deployed modules is
+// moved. SOPS-encrypted blueprints are not rewritten.
+func RenameModuleFile(filename string, from ModuleID, to ModuleID) error {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if isSopsEncrypted(b) {
+		return fmt.Errorf("%s is encrypted with SOPS, decrypt it with sops -d to rename its modules", filename)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf(errorMessages["yamlUnmarshalError"], filename, err)
+	}
+	if len(doc.Content) == 0 {
+		return fmt.Errorf("the blueprint has no module %s", from)
+	}
+	if err := renameModule(doc.Content[0], from, to); err != nil {
+		return err
+	}
+	renamed, err := encodeBlueprint(&doc)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, renamed, info.Mode())
+}
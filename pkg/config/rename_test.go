@@ -0,0 +1,134 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenameModuleFile(t *testing.T) {
+	bp := `blueprint_name: rename
+deployment_groups:
+  - group: primary
+    modules:
+      # the network
+      - id: network1
+        source: modules/network/vpc
+      - id: network1-peer
+        source: modules/network/vpc
+      - id: vm
+        source: modules/compute/vm-instance
+        use: [network1]
+        settings:
+          subnetwork: $(network1.subnetwork_self_link)
+          peer: $(network1-peer.network_name)
+          escaped: \$(network1.network_name)
+          tags: ((concat([module.network1.network_name], [module.network1-peer.network_name])))
+      - id: vm2
+        source: modules/compute/vm-instance
+        use:
+          - module: network1
+            map: {network_self_link: network}
+  - group: image
+    modules:
+      - id: image
+        source: modules/packer/custom-image
+        kind: packer
+`
+	path := filepath.Join(t.TempDir(), "bp.yaml")
+	if err := os.WriteFile(path, []byte(bp), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := RenameModuleFile(path, "network1", "network"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `blueprint_name: rename
+deployment_groups:
+  - group: primary
+    modules:
+      # the network
+      - id: network
+        source: modules/network/vpc
+        moved_from: [network1]
+      - id: network1-peer
+        source: modules/network/vpc
+      - id: vm
+        source: modules/compute/vm-instance
+        use: [network]
+        settings:
+          subnetwork: $(network.subnetwork_self_link)
+          peer: $(network1-peer.network_name)
+          escaped: \$(network1.network_name)
+          tags: ((concat([module.network.network_name], [module.network1-peer.network_name])))
+      - id: vm2
+        source: modules/compute/vm-instance
+        use:
+          - module: network
+            map: {network_self_link: network}
+  - group: image
+    modules:
+      - id: image
+        source: modules/packer/custom-image
+        kind: packer
+`
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// renaming back does not move the module from itself
+	if err := RenameModuleFile(path, "network", "network1"); err != nil {
+		t.Fatal(err)
+	}
+	dc, err := NewDeploymentConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := dc.Config.Module("network1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]ModuleID{"network"}, m.MovedFrom); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// Packer modules have no state to move
+	if err := RenameModuleFile(path, "image", "img"); err != nil {
+		t.Fatal(err)
+	}
+	if dc, err = NewDeploymentConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = dc.Config.Module("img"); err != nil || len(m.MovedFrom) != 0 {
+		t.Errorf("got %v, %v, want module img without moved_from", m, err)
+	}
+
+	for name, ids := range map[string][2]ModuleID{
+		"unknown":  {"nope", "other"},
+		"existing": {"vm", "img"},
+		"invalid":  {"vm", "my vm"},
+	} {
+		if err := RenameModuleFile(path, ids[0], ids[1]); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
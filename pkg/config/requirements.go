@@ -0,0 +1,233 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// versionRange is the range of versions allowed by version constraints; a nil
+// bound is unbounded
+type versionRange struct {
+	lo, hi       *version.Version
+	loInc, hiInc bool
+}
+
+func (r versionRange) empty() bool {
+	if r.lo == nil || r.hi == nil {
+		return false
+	}
+	c := r.lo.Compare(r.hi)
+	return c > 0 || (c == 0 && !(r.loInc && r.hiInc))
+}
+
+func (r versionRange) intersect(o versionRange) versionRange {
+	if o.lo != nil {
+		if r.lo == nil || o.lo.GreaterThan(r.lo) {
+			r.lo, r.loInc = o.lo, o.loInc
+		} else if o.lo.Equal(r.lo) {
+			r.loInc = r.loInc && o.loInc
+		}
+	}
+	if o.hi != nil {
+		if r.hi == nil || o.hi.LessThan(r.hi) {
+			r.hi, r.hiInc = o.hi, o.hiInc
+		} else if o.hi.Equal(r.hi) {
+			r.hiInc = r.hiInc && o.hiInc
+		}
+	}
+	return r
+}
+
+var constraintRegexp = regexp.MustCompile(`^\s*(=|!=|>=|>|<=|<|~>)?\s*(\S+)\s*$`)
+
+// parseVersionRange returns the range of versions allowed by Terraform
+// version constraints, e.g. ">= 4.42, < 5.0"; "!=" constraints are ignored,
+// as they do not narrow the range enough to conflict
+func parseVersionRange(constraints string) (versionRange, error) {
+	r := versionRange{}
+	for _, c := range strings.Split(constraints, ",") {
+		m := constraintRegexp.FindStringSubmatch(c)
+		if m == nil {
+			return r, fmt.Errorf("invalid version constraint %q", strings.TrimSpace(c))
+		}
+		v, err := version.NewVersion(m[2])
+		if err != nil {
+			return r, fmt.Errorf("invalid version constraint %q: %w", strings.TrimSpace(c), err)
+		}
+		var cr versionRange
+		switch m[1] {
+		case "", "=":
+			cr = versionRange{lo: v, hi: v, loInc: true, hiInc: true}
+		case "!=":
+			continue
+		case ">=":
+			cr = versionRange{lo: v, loInc: true}
+		case ">":
+			cr = versionRange{lo: v}
+		case "<=":
+			cr = versionRange{hi: v, hiInc: true}
+		case "<":
+			cr = versionRange{hi: v}
+		case "~>":
+			cr = versionRange{lo: v, loInc: true, hi: pessimisticBound(m[2], v)}
+		}
+		r = r.intersect(cr)
+	}
+	return r, nil
+}
+
+// pessimisticBound returns the exclusive upper bound of "~> v": the version
+// with the second to last of the segments given in s incremented, e.g. 4.66
+// for 4.65.2, or nil if s has a single segment
+func pessimisticBound(s string, v *version.Version) *version.Version {
+	given := strings.Count(strings.SplitN(strings.TrimPrefix(s, "v"), "-", 2)[0], ".") + 1
+	if given < 2 {
+		return nil
+	}
+	segs := v.Segments()[:given-1]
+	segs[len(segs)-1]++
+	parts := make([]string, len(segs))
+	for i, s := range segs {
+		parts[i] = fmt.Sprint(s)
+	}
+	return version.Must(version.NewVersion(strings.Join(parts, ".")))
+}
+
+// normalizeProviderSource returns the source address of a provider in the
+// form "<namespace>/<type>", defaulting to the hashicorp namespace
+func normalizeProviderSource(name string, source string) string {
+	if source == "" {
+		return "hashicorp/" + name
+	}
+	return strings.TrimPrefix(strings.ToLower(source), "registry.terraform.io/")
+}
+
+// providerConstraint is a version constraint of a provider and what set it
+type providerConstraint struct {
+	owner      string
+	constraint string
+	r          versionRange
+}
+
+// GroupRequiredProviders returns the required providers of a Terraform
+// group, with the version constraints the modules of the group declare in
+// required_providers merged into those of the deployment, when they narrow
+// them. It fails, naming the modules, if the modules require different
+// sources for a provider or constraints that no version satisfies.
+func (bp Blueprint) GroupRequiredProviders(g DeploymentGroup) ([]Provider, error) {
+	res := bp.RequiredProviders()
+	sources := map[string]string{}
+	sourceOwners := map[string]string{}
+	constraints := map[string][]providerConstraint{}
+	for _, p := range res {
+		owner := "the deployment"
+		if tp, ok := bp.TerraformProviders[p.Name]; ok && (tp.Source != "" || tp.Version != "") {
+			owner = "terraform_providers"
+		}
+		sources[p.Name] = normalizeProviderSource(p.Name, p.Source)
+		sourceOwners[p.Name] = owner
+		if p.Version == "" {
+			continue
+		}
+		r, err := parseVersionRange(p.Version)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", p.Name, err)
+		}
+		constraints[p.Name] = append(constraints[p.Name], providerConstraint{owner, p.Version, r})
+	}
+
+	if g.Kind != TerraformKind {
+		return res, nil
+	}
+	for _, m := range g.Modules {
+		if m.Kind != TerraformKind {
+			continue
+		}
+		owner := fmt.Sprintf("module %s", m.ID)
+		for _, req := range m.InfoOrDie().RequiredProviders {
+			if src, ok := sources[req.Name]; !ok {
+				sources[req.Name] = normalizeProviderSource(req.Name, req.Source)
+				sourceOwners[req.Name] = owner
+			} else if req.Source != "" && normalizeProviderSource(req.Name, req.Source) != src {
+				return nil, fmt.Errorf("provider %s: %s requires source %q, but %s requires %q",
+					req.Name, owner, req.Source, sourceOwners[req.Name], src)
+			}
+			for _, vc := range req.VersionConstraints {
+				r, err := parseVersionRange(vc)
+				if err != nil {
+					return nil, fmt.Errorf("provider %s: %s: %w", req.Name, owner, err)
+				}
+				constraints[req.Name] = append(constraints[req.Name], providerConstraint{owner, vc, r})
+			}
+		}
+	}
+
+	names := maps.Keys(constraints)
+	slices.Sort(names)
+	for _, n := range names {
+		if err := checkProviderConstraints(n, constraints[n]); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, p := range res {
+		cs := constraints[p.Name]
+		if len(cs) == 0 {
+			continue
+		}
+		r, merged := cs[0].r, []string{cs[0].constraint}
+		for _, c := range cs[1:] {
+			if n := r.intersect(c.r); n != r && !slices.Contains(merged, c.constraint) {
+				r = n
+				merged = append(merged, c.constraint)
+			}
+		}
+		res[i].Version = strings.Join(merged, ", ")
+	}
+	return res, nil
+}
+
+// checkProviderConstraints fails if no version of the provider satisfies the
+// constraints, naming the first two that are incompatible; ranges of versions
+// that intersect pairwise all intersect, so checking pairs is enough
+func checkProviderConstraints(name string, cs []providerConstraint) error {
+	for i, a := range cs {
+		for _, b := range cs[i+1:] {
+			if a.r.intersect(b.r).empty() {
+				return fmt.Errorf("provider %s: version constraints %q of %s and %q of %s are incompatible",
+					name, a.constraint, a.owner, b.constraint, b.owner)
+			}
+		}
+	}
+	return nil
+}
+
+// checkProviderRequirements ensures that the providers the modules of each
+// Terraform group require are compatible
+func (bp Blueprint) checkProviderRequirements() error {
+	for _, g := range bp.DeploymentGroups {
+		if _, err := bp.GroupRequiredProviders(g); err != nil {
+			return fmt.Errorf("deployment group %s: %w", g.Name, err)
+		}
+	}
+	return nil
+}
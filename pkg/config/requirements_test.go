@@ -0,0 +1,150 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseVersionRange(t *testing.T) {
+	type test struct {
+		constraint string
+		version    string
+		want       bool
+	}
+	tests := []test{
+		{"~> 4.65.2", "4.65.9", true},
+		{"~> 4.65.2", "4.66.0", false},
+		{"~> 3.0", "3.99", true},
+		{"~> 3.0", "4.0", false},
+		{"~> 4", "7.1", true},
+		{">= 4.42, < 5.0", "4.99", true},
+		{">= 4.42, < 5.0", "5.0", false},
+		{"> 1.0", "1.0", false},
+		{"<= 1.0", "1.0", true},
+		{"1.2.3", "1.2.3", true},
+		{"= 1.2.3", "1.2.4", false},
+		{"!= 1.2.3, >= 1.0", "1.2.3", true}, // != is ignored
+	}
+	for _, tc := range tests {
+		t.Run(tc.constraint+" "+tc.version, func(t *testing.T) {
+			r, err := parseVersionRange(tc.constraint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, _ := parseVersionRange("= " + tc.version)
+			if got := !r.intersect(v).empty(); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := parseVersionRange(">= banana"); err == nil {
+		t.Error("expected error for invalid version")
+	}
+}
+
+// requirementsGroup returns a group of modules, in a directory of their own,
+// requiring the providers
+func requirementsGroup(dir string, reqs ...[]modulereader.ProviderRequirement) DeploymentGroup {
+	g := DeploymentGroup{Name: "primary", Kind: TerraformKind}
+	for i, r := range reqs {
+		m := Module{ID: ModuleID(string(rune('a' + i))), Kind: TerraformKind, Source: "./modules/" + dir + "/" + string(rune('a'+i))}
+		modulereader.SetModuleInfo(m.Source, m.Kind.String(), modulereader.ModuleInfo{RequiredProviders: r})
+		g.Modules = append(g.Modules, m)
+	}
+	return g
+}
+
+func TestGroupRequiredProviders(t *testing.T) {
+	bp := Blueprint{}
+	g := requirementsGroup("merged",
+		[]modulereader.ProviderRequirement{
+			{Name: "google", Source: "hashicorp/google", VersionConstraints: []string{">= 3.83"}},
+			{Name: "random", Source: "hashicorp/random", VersionConstraints: []string{"~> 3.0"}}},
+		[]modulereader.ProviderRequirement{
+			{Name: "google", Source: "registry.terraform.io/hashicorp/google", VersionConstraints: []string{">= 4.65.5"}}},
+	)
+	got, err := bp.GroupRequiredProviders(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Provider{
+		{Name: "google", Source: "hashicorp/google", Version: googleProviderVersion + ", >= 4.65.5", Settings: googleProviderSettings},
+		{Name: "google-beta", Source: "hashicorp/google-beta", Version: googleProviderVersion, Settings: googleProviderSettings},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestGroupRequiredProvidersConflicts(t *testing.T) {
+	type test struct {
+		name string
+		bp   Blueprint
+		g    DeploymentGroup
+		err  string
+	}
+	tests := []test{
+		{"deployment", Blueprint{}, requirementsGroup("deployment",
+			[]modulereader.ProviderRequirement{{Name: "google", VersionConstraints: []string{">= 5.0"}}}),
+			`provider google: version constraints "~> 4.65.2" of the deployment and ">= 5.0" of module a are incompatible`},
+		{"modules", Blueprint{}, requirementsGroup("modules",
+			[]modulereader.ProviderRequirement{{Name: "random", VersionConstraints: []string{"~> 3.0"}}},
+			[]modulereader.ProviderRequirement{{Name: "random", VersionConstraints: []string{">= 4.0"}}}),
+			`provider random: version constraints "~> 3.0" of module a and ">= 4.0" of module b are incompatible`},
+		{"terraform_providers", Blueprint{TerraformProviders: map[string]TerraformProvider{"google": {Version: "~> 5.0"}}},
+			requirementsGroup("terraform_providers", []modulereader.ProviderRequirement{{Name: "google", VersionConstraints: []string{">= 4.42, < 5.0"}}}),
+			`provider google: version constraints "~> 5.0" of terraform_providers and ">= 4.42, < 5.0" of module a are incompatible`},
+		{"three modules", Blueprint{}, requirementsGroup("three_modules",
+			[]modulereader.ProviderRequirement{{Name: "random", VersionConstraints: []string{">= 3.0, < 4.0"}}},
+			[]modulereader.ProviderRequirement{{Name: "random", VersionConstraints: []string{"< 3.5"}}},
+			[]modulereader.ProviderRequirement{{Name: "random", VersionConstraints: []string{"> 3.5"}}}),
+			`provider random: version constraints "< 3.5" of module b and "> 3.5" of module c are incompatible`},
+		{"source", Blueprint{}, requirementsGroup("source",
+			[]modulereader.ProviderRequirement{{Name: "google", Source: "example/google"}}),
+			`provider google: module a requires source "example/google", but the deployment requires "hashicorp/google"`},
+		{"invalid", Blueprint{}, requirementsGroup("invalid",
+			[]modulereader.ProviderRequirement{{Name: "random", VersionConstraints: []string{">= three"}}}),
+			`provider random: module a: invalid version constraint ">= three"`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.bp.GroupRequiredProviders(tc.g)
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("got %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCheckProviderConstraints(t *testing.T) {
+	cs := []providerConstraint{}
+	for i, c := range []string{">= 1.0, < 2.0", "< 1.5", ">= 1.0, != 1.2"} {
+		r, err := parseVersionRange(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs = append(cs, providerConstraint{owner: string(rune('a' + i)), constraint: c, r: r})
+	}
+	if err := checkProviderConstraints("random", cs); err != nil {
+		t.Errorf("got %v, want no error", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// GroupRetry retries deploying a group when terraform or packer fail with a
+// transient error, such as exceeded quotas or unavailable APIs
+type GroupRetry struct {
+	// MaxAttempts is the number of times the group is deployed before giving
+	// up, 1 if not set
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// InitialBackoff and MaxBackoff are durations, such as "30s" or "5m", of the
+	// wait before the second attempt, doubled after each attempt, and of the
+	// longest wait
+	InitialBackoff string `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     string `yaml:"max_backoff,omitempty"`
+	// Patterns are regular expressions of the errors that are retried; a
+	// default set of transient errors is retried if not set
+	Patterns []string `yaml:"patterns,omitempty"`
+}
+
+// Clone returns a deep copy of the retry settings
+func (r GroupRetry) Clone() GroupRetry {
+	c := r
+	c.Patterns = slices.Clone(r.Patterns)
+	return c
+}
+
+// checkGroupRetry ensures that the retry settings of the groups are valid
+func checkGroupRetry(groups []DeploymentGroup) error {
+	for _, g := range groups {
+		r := g.Retry
+		if r.MaxAttempts < 0 {
+			return fmt.Errorf("retry of group %s: max_attempts must not be negative", g.Name)
+		}
+		for name, d := range map[string]string{"initial_backoff": r.InitialBackoff, "max_backoff": r.MaxBackoff} {
+			if d == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(d); err != nil {
+				return fmt.Errorf("retry of group %s: %s must be a duration such as \"30s\": %v", g.Name, name, err)
+			}
+		}
+		for _, p := range r.Patterns {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("retry of group %s: invalid pattern %q: %v", g.Name, p, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+)
+
+func TestGroupRetryYAML(t *testing.T) {
+	in := `
+group: primary
+modules: []
+retry:
+  max_attempts: 3
+  initial_backoff: 1m
+  patterns:
+  - ZONE_RESOURCE_POOL_EXHAUSTED
+`
+	var g DeploymentGroup
+	if err := yaml.Unmarshal([]byte(in), &g); err != nil {
+		t.Fatal(err)
+	}
+	want := GroupRetry{MaxAttempts: 3, InitialBackoff: "1m", Patterns: []string{"ZONE_RESOURCE_POOL_EXHAUSTED"}}
+	if diff := cmp.Diff(want, g.Retry); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCheckGroupRetry(t *testing.T) {
+	ok := []DeploymentGroup{{Name: "primary", Retry: GroupRetry{MaxAttempts: 2, MaxBackoff: "5m", Patterns: []string{"quota"}}}}
+	if err := checkGroupRetry(ok); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+	for _, r := range []GroupRetry{
+		{MaxAttempts: -1},
+		{InitialBackoff: "soon"},
+		{Patterns: []string{"("}},
+	} {
+		if err := checkGroupRetry([]DeploymentGroup{{Name: "primary", Retry: r}}); err == nil {
+			t.Errorf("expected an error for %#v", r)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Matches strings of form `$(secret....)`, the reference is validated separately
+var secretExp *regexp.Regexp = regexp.MustCompile(`^\$\(\s*secret\.(.*?)\s*\)$`)
+
+// Matches the name of a version of a secret, the version is optional
+var secretNameExp *regexp.Regexp = regexp.MustCompile(
+	`^projects/([^/\s]+)/secrets/([^/\s]+)(?:/versions/([^/\s]+))?$`)
+
+// SecretRef is a reference to a version of a Secret Manager secret, the value
+// of a deployment variable that is only read when the deployment is deployed
+type SecretRef struct {
+	Project string
+	Secret  string
+	Version string
+}
+
+// Name returns the resource name of the version of the secret
+func (r SecretRef) Name() string {
+	return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", r.Project, r.Secret, r.Version)
+}
+
+// isSecretString checks if the entire string is a secret reference
+func isSecretString(s string) bool {
+	return secretExp.MatchString(s)
+}
+
+// parseSecretRef takes `$(secret.projects/p/secrets/s/versions/v)` and returns
+// the reference, the version defaults to "latest"
+func parseSecretRef(s string) (SecretRef, error) {
+	contents := secretExp.FindStringSubmatch(s)
+	if len(contents) != 2 {
+		return SecretRef{}, fmt.Errorf("%#v is not a secret reference", s)
+	}
+	m := secretNameExp.FindStringSubmatch(contents[1])
+	if m == nil {
+		return SecretRef{}, fmt.Errorf("%s %#v: expected $(secret.projects/PROJECT/secrets/SECRET/versions/VERSION)",
+			errorMessages["invalidSecret"], s)
+	}
+	r := SecretRef{Project: m[1], Secret: m[2], Version: m[3]}
+	if r.Version == "" {
+		r.Version = "latest"
+	}
+	return r, nil
+}
+
+// secretRefOf returns the secret reference that v is, if any
+func secretRefOf(v cty.Value) (SecretRef, bool) {
+	if v.IsMarked() || v.Type() != cty.String || v.IsNull() || !v.IsKnown() || !isSecretString(v.AsString()) {
+		return SecretRef{}, false
+	}
+	r, err := parseSecretRef(v.AsString())
+	return r, err == nil
+}
+
+// SecretVars returns the references of the deployment variables whose values
+// are secret references, by variable name
+func (bp Blueprint) SecretVars() map[string]SecretRef {
+	res := map[string]SecretRef{}
+	for n, v := range bp.Vars.Items() {
+		if r, ok := secretRefOf(v); ok {
+			res[n] = r
+		}
+	}
+	return res
+}
+
+// isDeployTimeRef checks if v is a secret or remote output reference, whose
+// value is only read when the deployment is deployed
+func isDeployTimeRef(v cty.Value) bool {
+	_, secret := secretRefOf(v)
+	_, remote := remoteOutputRefOf(v)
+	return secret || remote
+}
+
+// findSecretRef returns the first secret reference nested in v
+func findSecretRef(v cty.Value) (string, bool) {
+	found := ""
+	cty.Walk(v, func(_ cty.Path, v cty.Value) (bool, error) {
+		if _, ok := secretRefOf(v); ok && found == "" {
+			found = v.AsString()
+		}
+		return found == "", nil
+	})
+	return found, found != ""
+}
+
+// checkSecretReferences checks that secret references are only values of
+// deployment variables, and that those variables, and those of sensitive
+// remote outputs, are only used by Terraform modules, as their values are
+// passed to Terraform when it is run and are never written to the deployment
+func (bp Blueprint) checkSecretReferences() error {
+	names := append(maps.Keys(bp.SecretVars()), maps.Keys(bp.RemoteOutputVars())...)
+	slices.Sort(names)
+	for _, g := range bp.DeploymentGroups {
+		if s, ok := findSecretRef(g.PackerSettings.AsObject()); ok {
+			return fmt.Errorf("%s, found %#v in packer_settings of group %s", errorMessages["secretNotVar"], s, g.Name)
+		}
+		if s, ok := findSecretRef(g.TerraformBackend.Configuration.AsObject()); ok {
+			return fmt.Errorf("%s, found %#v in terraform_backend of group %s", errorMessages["secretNotVar"], s, g.Name)
+		}
+		for _, m := range g.Modules {
+			if s, ok := findSecretRef(m.Settings.AsObject()); ok {
+				return fmt.Errorf("%s, found %#v in settings of module %s", errorMessages["secretNotVar"], s, m.ID)
+			}
+			if g.Kind == TerraformKind {
+				continue
+			}
+			used := GetUsedDeploymentVars(m.Settings.AsObject())
+			for _, n := range names {
+				if slices.Contains(used, n) {
+					return fmt.Errorf("deployment variable %s is a secret reference or sensitive remote output, "+
+						"which only Terraform modules can use, but %s module %s uses it", n, g.Kind, m.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	type test struct {
+		input string
+		want  SecretRef
+		err   bool
+	}
+	tests := []test{
+		{`$(secret.projects/p/secrets/db/versions/3)`, SecretRef{"p", "db", "3"}, false},
+		{`$(secret.projects/p/secrets/db/versions/latest)`, SecretRef{"p", "db", "latest"}, false},
+		{`$(secret.projects/p/secrets/db)`, SecretRef{"p", "db", "latest"}, false},
+		{`$( secret.projects/p/secrets/db )`, SecretRef{"p", "db", "latest"}, false},
+		{`$(secret.db)`, SecretRef{}, true},
+		{`$(secret.projects/p/secrets/db/versions/)`, SecretRef{}, true},
+		{`$(vars.zone)`, SecretRef{}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := parseSecretRef(tc.input)
+			if tc.err != (err != nil) {
+				t.Errorf("got unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnmarshalSecretRef(t *testing.T) {
+	var y YamlValue
+	if err := yaml.Unmarshal([]byte(`$(secret.projects/p/secrets/db/versions/latest)`), &y); err != nil {
+		t.Fatal(err)
+	}
+	// kept as is, to be read when the deployment is deployed
+	want := cty.StringVal("$(secret.projects/p/secrets/db/versions/latest)")
+	if got := y.Unwrap(); !got.RawEquals(want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	if err := yaml.Unmarshal([]byte(`$(secret.db_password)`), &y); err == nil {
+		t.Error("expected error for invalid secret reference")
+	}
+}
+
+func TestCheckSecretReferences(t *testing.T) {
+	secret := cty.StringVal("$(secret.projects/p/secrets/db/versions/latest)")
+	usePassword := NewDict(map[string]cty.Value{
+		"password": GlobalRef("db_password").AsExpression().AsValue(),
+	})
+	blueprint := func(kind ModuleKind, settings Dict) Blueprint {
+		return Blueprint{
+			Vars: NewDict(map[string]cty.Value{"db_password": secret, "zone": cty.StringVal("us-central1-a")}),
+			DeploymentGroups: []DeploymentGroup{{Name: "primary", Kind: kind, Modules: []Module{
+				{ID: "db", Kind: kind, Settings: settings},
+			}}},
+		}
+	}
+
+	bp := blueprint(TerraformKind, usePassword)
+	if diff := cmp.Diff(map[string]SecretRef{"db_password": {"p", "db", "latest"}}, bp.SecretVars()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if err := bp.checkSecretReferences(); err != nil {
+		t.Errorf("got unexpected error: %s", err)
+	}
+
+	bp = blueprint(PackerKind, usePassword)
+	if err := bp.checkSecretReferences(); err == nil {
+		t.Error("expected error for secret used by a Packer module")
+	}
+
+	bp = blueprint(TerraformKind, NewDict(map[string]cty.Value{
+		"passwords": cty.TupleVal([]cty.Value{secret}),
+	}))
+	if err := bp.checkSecretReferences(); err == nil {
+		t.Error("expected error for secret reference in module settings")
+	}
+}
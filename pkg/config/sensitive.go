@@ -0,0 +1,160 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
+)
+
+// RedactedValue replaces the values of sensitive deployment variables and
+// module settings in redacted blueprints
+const RedactedValue = "(sensitive)"
+
+// SensitiveVars returns the names of the deployment variables declared
+// sensitive, sorted
+func (bp Blueprint) SensitiveVars() []string {
+	res := []string{}
+	for _, n := range bp.DeclaredVariables() {
+		if bp.DeploymentVariables[n].Sensitive {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// Redacted returns a copy of the blueprint with the values of its sensitive
+// deployment variables and module settings replaced by RedactedValue;
+// settings set to expressions are kept, as they hold no secret
+func (bp Blueprint) Redacted() Blueprint {
+	c := bp.Clone()
+	for _, n := range c.SensitiveVars() {
+		d := c.DeploymentVariables[n]
+		if d.Default != nil {
+			d.Default = &YamlValue{cty.StringVal(RedactedValue)}
+		}
+		c.DeploymentVariables[n] = d
+		if c.Vars.Has(n) {
+			c.Vars.Set(n, cty.StringVal(RedactedValue))
+		}
+	}
+	c.WalkModules(func(m *Module) error {
+		for _, s := range m.SensitiveSettings {
+			if !m.Settings.Has(s) {
+				continue
+			}
+			if _, is := IsExpressionValue(m.Settings.Get(s)); !is {
+				m.Settings.Set(s, cty.StringVal(RedactedValue))
+			}
+		}
+		return nil
+	})
+	return c
+}
+
+// SensitiveValues returns the values of the sensitive deployment variables
+// and module settings, and of the strings, numbers and bools nested in them,
+// as they are printed
+func (bp Blueprint) SensitiveValues() []string {
+	res := []string{}
+	for _, n := range bp.SensitiveVars() {
+		if bp.Vars.Has(n) {
+			res = append(res, PrintedValues(bp.Vars.Get(n))...)
+		}
+	}
+	bp.WalkModules(func(m *Module) error {
+		for _, s := range m.SensitiveSettings {
+			if m.Settings.Has(s) {
+				res = append(res, PrintedValues(m.Settings.Get(s))...)
+			}
+		}
+		return nil
+	})
+	slices.Sort(res)
+	return slices.Compact(res)
+}
+
+// PrintedValues returns the strings, numbers and bools in v, as they are
+// printed; expressions are skipped
+func PrintedValues(v cty.Value) []string {
+	res := []string{}
+	cty.Walk(v, func(_ cty.Path, v cty.Value) (bool, error) {
+		if _, is := IsExpressionValue(v); is || v.IsNull() || !v.IsKnown() {
+			return false, nil
+		}
+		switch v.Type() {
+		case cty.String:
+			res = append(res, v.AsString())
+		case cty.Number:
+			res = append(res, v.AsBigFloat().Text('f', -1))
+		case cty.Bool:
+			res = append(res, fmt.Sprint(v.True()))
+		}
+		return true, nil
+	})
+	return res
+}
+
+// SensitiveOutputs returns the sensitive outputs of modules: the outputs
+// Terraform modules declare sensitive, and the outputs listed as sensitive in
+// the blueprint. Outputs ghpc adds for use by later groups are sensitive only
+// to keep them from the output of Terraform, and are not included.
+func (bp Blueprint) SensitiveOutputs() []Reference {
+	res := []Reference{}
+	bp.WalkModules(func(m *Module) error {
+		if m.Kind != TerraformKind {
+			return nil
+		}
+		for _, o := range m.InfoOrDie().Outputs {
+			if o.Sensitive {
+				res = append(res, ModuleRef(m.ID, o.Name))
+			}
+		}
+		for _, o := range m.Outputs {
+			if o.Sensitive && !o.Managed {
+				res = append(res, ModuleRef(m.ID, o.Name))
+			}
+		}
+		return nil
+	})
+	for _, o := range bp.Outputs {
+		if r, err := o.Reference(); err == nil && o.Sensitive {
+			res = append(res, r)
+		}
+	}
+	slices.SortFunc(res, func(a, b Reference) bool {
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		return a.Name < b.Name
+	})
+	return slices.Compact(res)
+}
+
+// SensitiveOutputValues returns the values of the sensitive outputs among the
+// outputs exported by the deployment groups, by group, as they are printed
+func (bp Blueprint) SensitiveOutputValues(outputs map[GroupName]map[string]cty.Value) []string {
+	res := []string{}
+	for _, r := range bp.SensitiveOutputs() {
+		g := bp.ModuleGroupOrDie(r.Module)
+		if v, ok := outputs[g.Name][AutomaticOutputName(r.Name, r.Module)]; ok {
+			res = append(res, PrintedValues(v)...)
+		}
+	}
+	slices.Sort(res)
+	return slices.Compact(res)
+}
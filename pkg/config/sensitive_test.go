@@ -0,0 +1,131 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func sensitiveBlueprint() Blueprint {
+	pwd := YamlValue{cty.StringVal("hunter22")}
+	return Blueprint{
+		Vars: NewDict(map[string]cty.Value{
+			"project_id":  cty.StringVal("my-project"),
+			"db_password": cty.StringVal("hunter22"),
+		}),
+		DeploymentVariables: map[string]VariableDeclaration{
+			"project_id":  {},
+			"db_password": {Sensitive: true, Default: &pwd},
+		},
+		DeploymentGroups: []DeploymentGroup{{Name: "primary", Kind: TerraformKind, Modules: []Module{
+			{ID: "db", Kind: TerraformKind, SensitiveSettings: []string{"password", "token"}, Settings: NewDict(map[string]cty.Value{
+				"password": MustParseExpression("var.db_password").AsValue(),
+				"token": cty.ObjectVal(map[string]cty.Value{
+					"key":  cty.StringVal("s3cr3t"),
+					"port": cty.NumberIntVal(5432),
+				}),
+				"user": cty.StringVal("admin"),
+			})},
+		}}},
+	}
+}
+
+func TestSensitiveVars(t *testing.T) {
+	got := sensitiveBlueprint().SensitiveVars()
+	if diff := cmp.Diff([]string{"db_password"}, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	bp := sensitiveBlueprint()
+	r := bp.Redacted()
+
+	if got := r.Vars.Get("db_password"); !got.Equals(cty.StringVal(RedactedValue)).True() {
+		t.Errorf("got db_password %#v, want redacted", got)
+	}
+	if got := r.DeploymentVariables["db_password"].Default.Unwrap(); !got.Equals(cty.StringVal(RedactedValue)).True() {
+		t.Errorf("got db_password default %#v, want redacted", got)
+	}
+	if got := r.Vars.Get("project_id"); !got.Equals(cty.StringVal("my-project")).True() {
+		t.Errorf("got project_id %#v, want unchanged", got)
+	}
+
+	mod := r.DeploymentGroups[0].Modules[0]
+	if _, is := IsExpressionValue(mod.Settings.Get("password")); !is {
+		t.Errorf("got password %#v, want expression kept", mod.Settings.Get("password"))
+	}
+	if got := mod.Settings.Get("token"); !got.Equals(cty.StringVal(RedactedValue)).True() {
+		t.Errorf("got token %#v, want redacted", got)
+	}
+	if got := mod.Settings.Get("user"); !got.Equals(cty.StringVal("admin")).True() {
+		t.Errorf("got user %#v, want unchanged", got)
+	}
+
+	// the original blueprint is left untouched
+	if got := bp.Vars.Get("db_password"); !got.Equals(cty.StringVal("hunter22")).True() {
+		t.Errorf("original blueprint was redacted, got db_password %#v", got)
+	}
+}
+
+func TestSensitiveValues(t *testing.T) {
+	got := sensitiveBlueprint().SensitiveValues()
+	want := []string{"5432", "hunter22", "s3cr3t"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSensitiveOutputs(t *testing.T) {
+	bp := sensitiveBlueprint()
+	db := &bp.DeploymentGroups[0].Modules[0]
+	db.Source = "./db"
+	setTestModuleInfo(*db, modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{
+		{Name: "host"}, {Name: "password", Sensitive: true}, {Name: "token"}, {Name: "user"}}})
+	db.Outputs = []modulereader.OutputInfo{
+		{Name: "token", Sensitive: true},
+		{Name: "host", Sensitive: true, Managed: true},
+	}
+	bp.Outputs = []DeploymentOutput{{Name: "db_user", Value: "$(db.user)", Sensitive: true}}
+
+	want := []Reference{ModuleRef("db", "password"), ModuleRef("db", "token"), ModuleRef("db", "user")}
+	if diff := cmp.Diff(want, bp.SensitiveOutputs()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	outputs := map[GroupName]map[string]cty.Value{"primary": {
+		"host_db":     cty.StringVal("10.0.0.2"),
+		"password_db": cty.StringVal("hunter22"),
+		"token_db":    cty.ObjectVal(map[string]cty.Value{"key": cty.StringVal("t0k3n")}),
+	}}
+	if diff := cmp.Diff([]string{"hunter22", "t0k3n"}, bp.SensitiveOutputValues(outputs)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// outputs the modules declare sensitive are sensitive outputs of the group
+	bp.populateOutputs()
+	got := map[string]bool{}
+	for _, o := range db.Outputs {
+		got[o.Name] = o.Sensitive
+	}
+	if diff := cmp.Diff(map[string]bool{"token": true, "host": true, "user": true}, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SopsBinary is the sops command that decrypts SOPS-encrypted files
+var SopsBinary = "sops"
+
+// isSopsEncrypted checks if the YAML content was encrypted by SOPS, which
+// records its metadata, including a MAC of the content, under "sops"
+func isSopsEncrypted(b []byte) bool {
+	var doc struct {
+		Sops struct {
+			Mac string `yaml:"mac"`
+		} `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return false
+	}
+	return doc.Sops.Mac != ""
+}
+
+// decryptSops decrypts the SOPS-encrypted YAML file with sops, which reads
+// the KMS or age keys that encrypted it from its usual configuration, e.g.
+// the application default credentials or SOPS_AGE_KEY_FILE
+func decryptSops(filename string) ([]byte, error) {
+	if _, err := exec.LookPath(SopsBinary); err != nil {
+		return nil, fmt.Errorf("%s is encrypted with SOPS, install sops to decrypt it: %w", filename, err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(SopsBinary, "--decrypt", "--input-type", "yaml", "--output-type", "yaml", filename)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s with sops: %v %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// readYamlFile reads a YAML file, decrypting it if it is encrypted with SOPS
+func readYamlFile(filename string) ([]byte, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !isSopsEncrypted(b) {
+		return b, nil
+	}
+	return decryptSops(filename)
+}
+
+// ReadVarsFile reads deployment variables from a YAML file, which may be
+// encrypted with SOPS
+func ReadVarsFile(filename string) (Dict, error) {
+	b, err := readYamlFile(filename)
+	if err != nil {
+		return Dict{}, fmt.Errorf("failed to read variables file %s: %w", filename, err)
+	}
+	var vars map[string]YamlValue
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	if err := decoder.Decode(&vars); err != nil {
+		return Dict{}, fmt.Errorf("failed to parse variables file %s: %w", filename, err)
+	}
+	var res Dict
+	for k, v := range vars {
+		res.Set(k, v.Unwrap())
+	}
+	return res, nil
+}
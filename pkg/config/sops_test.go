@@ -0,0 +1,124 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// sopsMetadata is the metadata SOPS appends to the files it encrypts
+const sopsMetadata = `sops:
+  age:
+  - recipient: age1example
+  lastmodified: "2023-06-01T12:00:00Z"
+  mac: ENC[AES256_GCM,data:bWFj,iv:aXY=,tag:dGFn,type:str]
+  version: 3.7.3
+`
+
+const sopsEncryptedVars = "db_password: ENC[AES256_GCM,data:dGVzdA==,iv:aXY=,tag:dGFn,type:str]\n" + sopsMetadata
+
+// fakeSops makes SopsBinary a script printing out, and returns the file its
+// arguments are recorded in
+func fakeSops(t *testing.T, out string) string {
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\ncat <<'EOF'\n" + out + "EOF\n"
+	path := filepath.Join(dir, "sops")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := SopsBinary
+	SopsBinary = path
+	t.Cleanup(func() { SopsBinary = old })
+	return args
+}
+
+func TestIsSopsEncrypted(t *testing.T) {
+	type test struct {
+		input string
+		want  bool
+	}
+	tests := []test{
+		{sopsEncryptedVars, true},
+		{"db_password: hunter22\n", false},
+		{"sops: not metadata\n", false},
+		{"vars: [\n", false},
+	}
+	for _, tc := range tests {
+		if got := isSopsEncrypted([]byte(tc.input)); got != tc.want {
+			t.Errorf("isSopsEncrypted(%q) = %t, want %t", tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestReadVarsFile(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.yaml")
+	if err := os.WriteFile(plain, []byte("zone: us-central1-a\nnode_count: 4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadVarsFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get("node_count"); !v.RawEquals(cty.NumberIntVal(4)) {
+		t.Errorf("got node_count %#v, want 4", v)
+	}
+
+	encrypted := filepath.Join(dir, "secrets.enc.yaml")
+	if err := os.WriteFile(encrypted, []byte(sopsEncryptedVars), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := fakeSops(t, "db_password: hunter22\n")
+	got, err = ReadVarsFile(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := got.Get("db_password"); !v.RawEquals(cty.StringVal("hunter22")) {
+		t.Errorf("got db_password %#v, want decrypted value", v)
+	}
+	b, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "--decrypt --input-type yaml --output-type yaml " + encrypted + "\n"; string(b) != want {
+		t.Errorf("sops run with %q, want %q", b, want)
+	}
+
+	SopsBinary = filepath.Join(dir, "no-sops")
+	if _, err := ReadVarsFile(encrypted); err == nil {
+		t.Error("expected error when sops is not installed")
+	}
+}
+
+func TestImportSopsBlueprint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bp.yaml")
+	encrypted := "blueprint_name: ENC[AES256_GCM,data:dGVzdA==,iv:aXY=,tag:dGFn,type:str]\n" + sopsMetadata
+	if err := os.WriteFile(path, []byte(encrypted), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fakeSops(t, "blueprint_name: secret-bp\n")
+	bp, _, err := importBlueprint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bp.BlueprintName != "secret-bp" {
+		t.Errorf("got blueprint_name %q, want %q", bp.BlueprintName, "secret-bp")
+	}
+}
@@ -19,16 +19,26 @@ package config
 import (
 	"fmt"
 	"log"
+	"math/big"
+	"os"
 	"regexp"
 	"strings"
 
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/cost"
+	"hpc-toolkit/pkg/diagnostics"
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/offline"
+	"hpc-toolkit/pkg/policy"
 	"hpc-toolkit/pkg/validators"
 
 	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
+	"google.golang.org/api/option"
 	"gopkg.in/yaml.v3"
 )
 
@@ -50,24 +60,24 @@ func (err *InvalidSettingError) Error() string {
 }
 
 // validate is the top-level function for running the validation suite.
-func (dc DeploymentConfig) validate() {
+func (dc DeploymentConfig) validate() error {
 	// Drop the flags for log to improve readability only for running the validation suite
 	log.SetFlags(0)
+	// Set it back to the initial value
+	defer log.SetFlags(log.LstdFlags)
 
 	// variables should be validated before running validators
 	if err := dc.executeValidators(); err != nil {
-		log.Fatal(err)
+		return errcode.New(errcode.ValidationFailure, err)
 	}
 
 	if err := dc.validateModules(); err != nil {
-		log.Fatal(err)
+		return errcode.New(errcode.ConfigError, err)
 	}
 	if err := dc.validateModuleSettings(); err != nil {
-		log.Fatal(err)
+		return errcode.New(errcode.ConfigError, err)
 	}
-
-	// Set it back to the initial value
-	log.SetFlags(log.LstdFlags)
+	return nil
 }
 
 // performs validation of global variables
@@ -80,20 +90,38 @@ func (dc DeploymentConfig) executeValidators() error {
 	}
 
 	for _, validator := range dc.Config.Validators {
-		if validator.Skip {
+		level := validator.level(dc.Config.ValidationLevel)
+		if validator.Skip || level == ValidationIgnore {
+			continue
+		}
+		if offline.Enabled && networkValidators[validator.Validator] {
+			log.Printf("warning: validator %s requires network access and is skipped in offline mode", validator.Validator)
 			continue
 		}
 
 		f, ok := implementedValidators[validator.Validator]
+		if validator.Expression != "" {
+			f, ok = dc.testExpression, true
+		}
+		if len(validator.Command) > 0 {
+			f, ok = dc.testPlugin, true
+		}
 		if !ok {
 			errored = true
-			log.Printf("%s is not an implemented validator", validator.Validator)
+			msg := fmt.Sprintf("%s is not an implemented validator", validator.Validator)
+			log.Print(msg)
+			dc.report(validator.Validator, diagnostics.Error, msg, validatorSubjects(validator)...)
 			continue
 		}
 
-		if err := f(validator); err != nil {
+		logged, err := dc.captureLog(func() error { return f(validator) })
+		// plugins report their own diagnostics
+		if len(validator.Command) == 0 {
+			dc.reportValidator(validator, level, logged, err)
+		}
+		if err != nil {
 			var prefix string
-			switch dc.Config.ValidationLevel {
+			switch level {
 			case ValidationWarning:
 				warned = true
 				prefix = "warning: "
@@ -112,6 +140,28 @@ func (dc DeploymentConfig) executeValidators() error {
 
 	}
 
+	if dc.PolicyBundle != "" {
+		results, err := dc.evaluatePolicies()
+		if err != nil {
+			return err
+		}
+		// deny results fail like validators, warn results are only reported
+		for _, r := range results {
+			prefix := "warning: "
+			if r.Level == policy.Deny {
+				if dc.Config.ValidationLevel == ValidationWarning {
+					warned = true
+				} else {
+					errored = true
+					prefix = "error: "
+				}
+			}
+			log.Print(prefix, r)
+			log.Println()
+			dc.report(policy.Package+"."+r.Level, strings.TrimSuffix(prefix, ": "), r.Message)
+		}
+	}
+
 	if warned || errored {
 		log.Println("One or more blueprint validators has failed. See messages above for suggested")
 		log.Println("actions. General troubleshooting guidance and instructions for configuring")
@@ -149,7 +199,9 @@ func (dc DeploymentConfig) validateVars() error {
 		if !ty.IsObjectType() && !ty.IsMapType() {
 			return errors.New("vars.labels must be a map of strings")
 		}
-		if labels.LengthInt() > maxLabels {
+		// the labels of other clouds, such as AWS tags, have other limits
+		gcp := dc.Config.TargetCloud().Name == GCP
+		if gcp && labels.LengthInt() > maxLabels {
 			// GCP resources cannot have more than 64 labels, so enforce this upper bound here
 			// to do some early validation. Modules may add more labels, leading to potential
 			// deployment failures.
@@ -160,6 +212,9 @@ func (dc DeploymentConfig) validateVars() error {
 				return errors.New("vars.labels must be a map of strings")
 			}
 			labelValue := v.AsString()
+			if !gcp {
+				continue
+			}
 
 			// Check that label names are valid
 			if !isValidLabelName(labelName) {
@@ -181,14 +236,20 @@ func (dc DeploymentConfig) validateVars() error {
 		}
 	}
 
-	err := cty.Walk(vars.AsObject(), func(p cty.Path, v cty.Value) (bool, error) {
-		if e, is := IsExpressionValue(v); is {
-			return false, fmt.Errorf("can not use expressions in vars block, got %#v", e.makeYamlExpressionValue().AsString())
+	// module variables are written as locals, other variables are values
+	for key, val := range vars.Items() {
+		if dc.Config.IsModuleVar(key) {
+			continue
+		}
+		err := cty.Walk(val, func(p cty.Path, v cty.Value) (bool, error) {
+			if e, is := IsExpressionValue(v); is {
+				return false, fmt.Errorf("can not use expressions in vars block, got %#v", e.makeYamlExpressionValue().AsString())
+			}
+			return true, nil
+		})
+		if err != nil {
+			return err
 		}
-		return true, nil
-	})
-	if err != nil {
-		return err
 	}
 
 	return nil
@@ -226,21 +287,75 @@ func validateOutputs(mod Module) error {
 
 	// Ensure output exists in the underlying modules
 	for _, output := range mod.Outputs {
+		if mod.Kind == PackerKind && output.Name == PackerImageOutput {
+			continue
+		}
 		if _, ok := outputsMap[output.Name]; !ok {
-			return fmt.Errorf("%s, module: %s output: %s",
-				errorMessages["invalidOutput"], mod.ID, output.Name)
+			return fmt.Errorf("%s, module: %s output: %s%s",
+				errorMessages["invalidOutput"], mod.ID, output.Name, outputHint(output.Name, maps.Keys(outputsMap)))
 		}
 	}
 	return nil
 }
 
+// outputHint suggests the outputs closest to a missing output, or lists the
+// outputs of the module if none is close
+func outputHint(name string, outputs []string) string {
+	if len(outputs) == 0 {
+		return "; the module has no outputs"
+	}
+	slices.Sort(outputs)
+	near := []string{}
+	for _, o := range outputs {
+		if editDistance(name, o) <= 1+len(name)/4 || strings.Contains(o, name) || strings.Contains(name, o) {
+			near = append(near, o)
+		}
+	}
+	if len(near) > 0 {
+		return fmt.Sprintf("; did you mean %s?", strings.Join(near, " or "))
+	}
+	return fmt.Sprintf("; outputs of the module are %s", strings.Join(outputs, ", "))
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// checkModuleOutputs ensures the outputs requested of modules in the
+// blueprint are outputs of the modules
+func checkModuleOutputs(bp Blueprint) error {
+	return bp.WalkModules(func(m *Module) error {
+		return validateOutputs(*m)
+	})
+}
+
 // validateModules ensures parameters set in modules are set correctly.
 func (dc DeploymentConfig) validateModules() error {
 	return dc.Config.WalkModules(func(m *Module) error {
-		if err := validateModule(*m); err != nil {
-			return err
-		}
-		return validateOutputs(*m)
+		return validateModule(*m)
 	})
 }
 
@@ -287,6 +402,22 @@ func validateSettings(
 		}
 
 	}
+	for _, k := range mod.SensitiveSettings {
+		if _, ok := cVars.Inputs[k]; !ok {
+			return &InvalidSettingError{
+				fmt.Sprintf("%s\nModule ID: %s Sensitive setting: %s",
+					errorMessages["extraSensitiveSetting"], mod.ID, k),
+			}
+		}
+	}
+	for k := range mod.WrapSettings {
+		if _, ok := cVars.Inputs[k]; !ok {
+			return &InvalidSettingError{
+				fmt.Sprintf("%s\nModule ID: %s Wrapped setting: %s",
+					errorMessages["extraWrappedSetting"], mod.ID, k),
+			}
+		}
+	}
 	return nil
 }
 
@@ -295,7 +426,7 @@ func validateSettings(
 func (dc DeploymentConfig) validateModuleSettings() error {
 	for _, grp := range dc.Config.DeploymentGroups {
 		for _, mod := range grp.Modules {
-			info, err := modulereader.GetModuleInfo(mod.Source, mod.Kind.String())
+			info, err := modulereader.GetModuleInfo(mod.infoSource(), mod.Kind.String())
 			if err != nil {
 				errStr := "failed to get info for module at %s while validating module settings"
 				return errors.Wrapf(err, errStr, mod.Source)
@@ -309,6 +440,23 @@ func (dc DeploymentConfig) validateModuleSettings() error {
 	return nil
 }
 
+// validators that call the APIs of clouds
+var networkValidators = map[string]bool{
+	testApisEnabledName.String():             true,
+	testProjectExistsName.String():           true,
+	testRegionExistsName.String():            true,
+	testZoneExistsName.String():              true,
+	testZoneInRegionName.String():            true,
+	testMachineTypesAvailableName.String():   true,
+	testIamPermissionsName.String():          true,
+	testBudgetName.String():                  true,
+	testAwsCredentialsName.String():          true,
+	testAwsRegionExistsName.String():         true,
+	testAwsZoneInRegionName.String():         true,
+	testAzureSubscriptionExistsName.String(): true,
+	testAzureLocationExistsName.String():     true,
+}
+
 func (dc *DeploymentConfig) getValidators() map[string]func(validatorConfig) error {
 	allValidators := map[string]func(validatorConfig) error{
 		testApisEnabledName.String():               dc.testApisEnabled,
@@ -318,6 +466,14 @@ func (dc *DeploymentConfig) getValidators() map[string]func(validatorConfig) err
 		testZoneInRegionName.String():              dc.testZoneInRegion,
 		testModuleNotUsedName.String():             dc.testModuleNotUsed,
 		testDeploymentVariableNotUsedName.String(): dc.testDeploymentVariableNotUsed,
+		testMachineTypesAvailableName.String():     dc.testMachineTypesAvailable,
+		testIamPermissionsName.String():            dc.testIamPermissions,
+		testBudgetName.String():                    dc.testBudget,
+		testAwsCredentialsName.String():            dc.testAwsCredentials,
+		testAwsRegionExistsName.String():           dc.testAwsRegionExists,
+		testAwsZoneInRegionName.String():           dc.testAwsZoneInRegion,
+		testAzureSubscriptionExistsName.String():   dc.testAzureSubscriptionExists,
+		testAzureLocationExistsName.String():       dc.testAzureLocationExists,
 	}
 	return allValidators
 }
@@ -375,7 +531,23 @@ func (dc *DeploymentConfig) testApisEnabled(c validatorConfig) error {
 			}
 			project = v.AsString()
 		}
-		err := validators.TestApisEnabled(project, apis)
+		// the APIs that would be enabled are listed even if the validator
+		// passed recently
+		if dc.EnableApis == EnableApisDryRun {
+			if err := dc.enableApis(project, apis); err != nil {
+				log.Println(err)
+				errored = true
+				continue
+			}
+		}
+		err := cachedTest(testApisEnabledName.String(), func() error {
+			if dc.EnableApis == EnableApisAlways {
+				if err := dc.enableApis(project, apis); err != nil {
+					return err
+				}
+			}
+			return validators.TestApisEnabled(project, apis, clientOptions...)
+		}, append([]string{project}, apis...)...)
 		if err != nil {
 			log.Println(err)
 			errored = true
@@ -388,6 +560,25 @@ func (dc *DeploymentConfig) testApisEnabled(c validatorConfig) error {
 	return nil
 }
 
+// enableApis enables the APIs that are disabled in project, or lists them in
+// dry-run mode, as set by dc.EnableApis
+func (dc *DeploymentConfig) enableApis(project string, apis []string) error {
+	if dc.EnableApis == EnableApisNever {
+		return nil
+	}
+	disabled, err := validators.DisabledApis(project, apis, clientOptions...)
+	if err != nil || len(disabled) == 0 {
+		return err
+	}
+	if dc.EnableApis == EnableApisDryRun {
+		for _, api := range disabled {
+			log.Printf("%s: would be enabled in project %s (dry run)", api, project)
+		}
+		return nil
+	}
+	return validators.EnableApis(project, disabled, clientOptions...)
+}
+
 func (dc *DeploymentConfig) testProjectExists(c validatorConfig) error {
 	funcName := testProjectExistsName.String()
 	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
@@ -401,7 +592,10 @@ func (dc *DeploymentConfig) testProjectExists(c validatorConfig) error {
 		return err
 	}
 
-	if err = validators.TestProjectExists(m["project_id"]); err != nil {
+	err = cachedTest(funcName, func() error {
+		return validators.TestProjectExists(m["project_id"])
+	}, m["project_id"])
+	if err != nil {
 		log.Print(err)
 		return fmt.Errorf(funcErrorMsg)
 	}
@@ -421,7 +615,10 @@ func (dc *DeploymentConfig) testRegionExists(c validatorConfig) error {
 		return err
 	}
 
-	if err = validators.TestRegionExists(m["project_id"], m["region"]); err != nil {
+	err = cachedTest(funcName, func() error {
+		return validators.TestRegionExists(m["project_id"], m["region"])
+	}, m["project_id"], m["region"])
+	if err != nil {
 		log.Print(err)
 		return fmt.Errorf(funcErrorMsg)
 	}
@@ -441,7 +638,10 @@ func (dc *DeploymentConfig) testZoneExists(c validatorConfig) error {
 		return err
 	}
 
-	if err = validators.TestZoneExists(m["project_id"], m["zone"]); err != nil {
+	err = cachedTest(funcName, func() error {
+		return validators.TestZoneExists(m["project_id"], m["zone"])
+	}, m["project_id"], m["zone"])
+	if err != nil {
 		log.Print(err)
 		return fmt.Errorf(funcErrorMsg)
 	}
@@ -461,13 +661,182 @@ func (dc *DeploymentConfig) testZoneInRegion(c validatorConfig) error {
 		return err
 	}
 
-	if err = validators.TestZoneInRegion(m["project_id"], m["zone"], m["region"]); err != nil {
+	err = cachedTest(funcName, func() error {
+		return validators.TestZoneInRegion(m["project_id"], m["zone"], m["region"])
+	}, m["project_id"], m["zone"], m["region"])
+	if err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testMachineTypesAvailable(c validatorConfig) error {
+	funcName := testMachineTypesAvailableName.String()
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
+
+	if err := c.check(testMachineTypesAvailableName, []string{"project_id", "zone"}); err != nil {
+		return err
+	}
+	m, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+
+	zones := requestedMachineTypes(dc.Config, m["zone"])
+	names := maps.Keys(zones)
+	slices.Sort(names)
+	failed := false
+	for _, zone := range names {
+		t := zones[zone]
+		args := append([]string{m["project_id"], zone, "machine_types"}, t.machineTypes...)
+		args = append(append(args, "accelerator_types"), t.acceleratorTypes...)
+		err := cachedTest(funcName, func() error {
+			return validators.TestMachineTypesAvailable(m["project_id"], zone, t.machineTypes, t.acceleratorTypes, clientOptions...)
+		}, args...)
+		if err != nil {
+			log.Print(err)
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+// testIamPermissions is not cached: the permissions are those of the
+// application default credentials, which can change without changing its
+// inputs
+func (dc *DeploymentConfig) testIamPermissions(c validatorConfig) error {
+	funcName := testIamPermissionsName.String()
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
+
+	if err := c.check(testIamPermissionsName, []string{"project_id"}); err != nil {
+		return err
+	}
+	m, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+
+	permissions := dc.Config.requiredPermissions()
+	serviceAccount := os.Getenv(validators.ImpersonateEnvVar)
+	if err := validators.TestIamPermissions(m["project_id"], serviceAccount, permissions); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+// testBudget is not cached: prices change, and so do the resources of the
+// blueprint without changing its inputs
+func (dc *DeploymentConfig) testBudget(c validatorConfig) error {
+	funcName := testBudgetName.String()
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, funcName)
+
+	if err := c.check(testBudgetName, []string{"max_budget", "project_id"}); err != nil {
+		return err
+	}
+	in, err := c.Inputs.Eval(dc.Config)
+	if err != nil {
+		log.Print(funcErrorMsg)
+		return err
+	}
+	projectID := in.Get("project_id")
+	if projectID.Type() != cty.String {
+		return fmt.Errorf("validator inputs must be strings, project_id is a %s", projectID.Type())
+	}
+	maxBudget, err := convert.Convert(in.Get("max_budget"), cty.Number)
+	if err != nil || maxBudget.IsNull() || !maxBudget.IsKnown() {
+		return fmt.Errorf("max_budget must be the maximum monthly cost of the deployment in USD, got %#v", in.Get("max_budget"))
+	}
+	budget, _ := maxBudget.AsBigFloat().Float64()
+
+	err = validators.TestBudget(budget, dc.Config.BillableResources(), func() (cost.Pricer, error) {
+		return newPricer(projectID.AsString())
+	})
+	if err != nil {
 		log.Print(err)
 		return fmt.Errorf(funcErrorMsg)
 	}
 	return nil
 }
 
+// requiredPermissions returns the IAM permissions required by the modules of
+// the blueprint, sorted and without duplicates
+func (bp Blueprint) requiredPermissions() []string {
+	permissions := []string{}
+	bp.WalkModules(func(m *Module) error {
+		permissions = append(permissions, m.InfoOrDie().RequiredPermissions...)
+		return nil
+	})
+	slices.Sort(permissions)
+	return slices.Compact(permissions)
+}
+
+type zoneTypes struct {
+	machineTypes     []string
+	acceleratorTypes []string
+}
+
+// requestedMachineTypes returns the machine types and accelerator types of
+// the machine_type, guest_accelerator and gpu settings of all modules, by the
+// zone setting of the module or zone if it is not set. Settings that are not
+// known before deployment are skipped.
+func requestedMachineTypes(bp Blueprint, zone string) map[string]zoneTypes {
+	zones := map[string]zoneTypes{}
+	add := func(zone string, mt string, at string) {
+		t := zones[zone]
+		if mt != "" && !slices.Contains(t.machineTypes, mt) {
+			t.machineTypes = append(t.machineTypes, mt)
+		}
+		if at != "" && !slices.Contains(t.acceleratorTypes, at) {
+			t.acceleratorTypes = append(t.acceleratorTypes, at)
+		}
+		zones[zone] = t
+	}
+	str := func(v interface{}) string {
+		s, _ := v.(string)
+		return s
+	}
+
+	for _, g := range bp.DeploymentGroups {
+		for _, m := range g.Modules {
+			settings := map[string]interface{}{}
+			for k, v := range m.Settings.Items() {
+				settings[k] = evalSetting(bp, v, fmt.Sprintf("setting %s of module %s", k, m.ID))
+			}
+			z := zone
+			if s := str(settings["zone"]); s != "" {
+				z = s
+			}
+			add(z, str(settings["machine_type"]), "")
+			if gpu, ok := settings["gpu"].(map[string]interface{}); ok {
+				add(z, "", str(gpu["type"]))
+			}
+			if gas, ok := settings["guest_accelerator"].([]interface{}); ok {
+				for _, ga := range gas {
+					if ga, ok := ga.(map[string]interface{}); ok {
+						add(z, "", str(ga["type"]))
+					}
+				}
+			}
+		}
+	}
+	for z, t := range zones {
+		if len(t.machineTypes) == 0 && len(t.acceleratorTypes) == 0 {
+			delete(zones, z)
+			continue
+		}
+		slices.Sort(t.machineTypes)
+		slices.Sort(t.acceleratorTypes)
+	}
+	return zones
+}
+
 func (dc *DeploymentConfig) testModuleNotUsed(c validatorConfig) error {
 	if err := c.check(testModuleNotUsedName, []string{}); err != nil {
 		return err
@@ -503,6 +872,225 @@ func (dc *DeploymentConfig) testDeploymentVariableNotUsed(c validatorConfig) err
 	return nil
 }
 
+// checkValidators ensures that the levels of validators are valid and that
+// validators declared in the blueprint have unique names, distinct from those
+// of built-in validators, and valid expressions
+func (dc *DeploymentConfig) checkValidators() error {
+	builtin := dc.getValidators()
+	names := map[string]bool{}
+	for _, v := range dc.Config.Validators {
+		if v.Level != "" {
+			if _, err := ParseValidationLevel(v.Level); err != nil {
+				return fmt.Errorf("validator %s: %v", v.Validator, err)
+			}
+		}
+		if v.Expression == "" && v.Message != "" {
+			return fmt.Errorf("validator %s: message can only be set with an expression", v.Validator)
+		}
+		if v.Expression == "" && len(v.Command) == 0 {
+			continue
+		}
+		if v.Expression != "" && len(v.Command) > 0 {
+			return fmt.Errorf("validator %s: expression and command cannot both be set", v.Validator)
+		}
+		if v.Validator == "" {
+			return fmt.Errorf("validator with expression %q or command %q must have a name", v.Expression, v.Command)
+		}
+		if _, ok := builtin[v.Validator]; ok {
+			return fmt.Errorf("validator %s with an expression or command cannot have the name of a built-in validator", v.Validator)
+		}
+		if names[v.Validator] {
+			return fmt.Errorf("validator %s is declared more than once", v.Validator)
+		}
+		names[v.Validator] = true
+		if len(v.Command) > 0 {
+			continue
+		}
+		if len(v.Inputs.Items()) > 0 {
+			return fmt.Errorf("validator %s: inputs cannot be set with an expression", v.Validator)
+		}
+		if _, err := validators.CompileExpression(v.Expression); err != nil {
+			return fmt.Errorf("validator %s: invalid expression %q: %v", v.Validator, v.Expression, err)
+		}
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) testExpression(c validatorConfig) error {
+	e, err := validators.CompileExpression(c.Expression)
+	if err != nil {
+		return err
+	}
+	if err := validators.TestExpression(e, expressionActivation(dc.Config), c.Message); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsgTemplate, c.Validator)
+	}
+	return nil
+}
+
+// testPlugin runs the command of a validator plugin, which is only allowed
+// with AllowExec like other commands of blueprints, and reports each of its
+// diagnostics
+func (dc *DeploymentConfig) testPlugin(c validatorConfig) error {
+	funcErrorMsg := fmt.Sprintf(funcErrorMsgTemplate, c.Validator)
+	severity := func(s string) string {
+		if s == validators.SeverityError && c.level(dc.Config.ValidationLevel) == ValidationError {
+			return diagnostics.Error
+		}
+		return diagnostics.Warning
+	}
+
+	diags, err := dc.runPlugin(c)
+	if err != nil {
+		log.Print(err)
+		dc.report(c.Validator, severity(validators.SeverityError), err.Error(), validatorSubjects(c)...)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	if len(diags) == 0 {
+		dc.report(c.Validator, diagnostics.Pass, "", validatorSubjects(c)...)
+	}
+	for _, d := range diags {
+		subjects := append([]string{"modules." + d.Module}, validatorSubjects(c)...)
+		dc.report(c.Validator, severity(d.Severity), d.Message, subjects...)
+	}
+	if err := validators.TestPlugin(c.Validator, diags); err != nil {
+		log.Print(err)
+		return fmt.Errorf(funcErrorMsg)
+	}
+	return nil
+}
+
+func (dc *DeploymentConfig) runPlugin(c validatorConfig) ([]validators.Diagnostic, error) {
+	if !dc.AllowExec {
+		return nil, fmt.Errorf("validator %s runs %s; validator plugins run commands, pass --allow-exec to allow them", c.Validator, c.Command[0])
+	}
+	inputs, err := evalValidatorInputsAsStrings(c.Inputs, dc.Config)
+	if err != nil {
+		return nil, err
+	}
+	bp, err := dc.Config.PolicyInput()
+	if err != nil {
+		return nil, err
+	}
+	input := validators.PluginInput{Validator: c.Validator, Inputs: inputs, Blueprint: bp}
+	return validators.RunPlugin(c.Command, input)
+}
+
+// expressionActivation returns the variables of the expressions of validators:
+// "vars", the deployment variables, and "modules", the modules by ID with
+// their id, source, kind, group and settings. Settings that reference outputs
+// of other modules are unknown.
+func expressionActivation(bp Blueprint) map[string]interface{} {
+	eval := func(v cty.Value, what string) interface{} { return evalSetting(bp, v, what) }
+
+	vars := map[string]interface{}{}
+	for k, v := range bp.Vars.Items() {
+		vars[k] = eval(v, "deployment variable "+k)
+	}
+	modules := map[string]interface{}{}
+	for _, g := range bp.DeploymentGroups {
+		for _, m := range g.Modules {
+			settings := map[string]interface{}{}
+			for k, v := range m.Settings.Items() {
+				settings[k] = eval(v, fmt.Sprintf("setting %s of module %s", k, m.ID))
+			}
+			modules[string(m.ID)] = map[string]interface{}{
+				"id":       string(m.ID),
+				"source":   m.Source,
+				"kind":     m.Kind.String(),
+				"group":    string(g.Name),
+				"settings": settings,
+			}
+		}
+	}
+	return map[string]interface{}{"vars": vars, "modules": modules}
+}
+
+// evalSetting evaluates the expressions of a setting or deployment variable
+// and converts it with celValue; values that cannot be evaluated are unknown
+func evalSetting(bp Blueprint, v cty.Value, what string) interface{} {
+	r, err := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		if e, is := IsExpressionValue(v); is {
+			return e.Eval(bp)
+		}
+		return v, nil
+	})
+	if err != nil {
+		return validators.Unknown(what)
+	}
+	return celValue(r, what)
+}
+
+// celValue converts a value to its representation in validator expressions
+func celValue(v cty.Value, what string) interface{} {
+	switch {
+	case !v.IsKnown():
+		return validators.Unknown(what)
+	case v.IsNull():
+		return nil
+	}
+	ty := v.Type()
+	switch {
+	case ty == cty.String:
+		return v.AsString()
+	case ty == cty.Bool:
+		return v.True()
+	case ty == cty.Number:
+		bf := v.AsBigFloat()
+		if i, acc := bf.Int64(); bf.IsInt() && acc == big.Exact {
+			return i
+		}
+		f, _ := bf.Float64()
+		return f
+	case ty.IsListType() || ty.IsTupleType() || ty.IsSetType():
+		l := []interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+			l = append(l, celValue(e, what))
+		}
+		return l
+	case ty.IsMapType() || ty.IsObjectType():
+		m := map[string]interface{}{}
+		for it := v.ElementIterator(); it.Next(); {
+			k, e := it.Element()
+			m[k.AsString()] = celValue(e, what)
+		}
+		return m
+	default:
+		return validators.Unknown(what)
+	}
+}
+
+// newPricer returns the pricer that test_budget estimates the cost of the
+// deployment with, the on-demand prices of the Cloud Billing Catalog
+var newPricer = func(projectID string) (cost.Pricer, error) {
+	p, err := cost.NewCatalogPricer(projectID)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// clientOptions are passed to the Google Cloud API clients of the validators,
+// tests set them to use fake endpoints
+var clientOptions []option.ClientOption
+
+// cachedTest runs test, a validator that calls Google Cloud APIs with args,
+// unless it succeeded with the same arguments within the TTL of the cache
+func cachedTest(name string, test func() error, args ...string) error {
+	key := cache.Key(append([]string{name}, args...)...)
+	if cache.ValidatorPassed(key) {
+		return nil
+	}
+	if err := test(); err != nil {
+		return err
+	}
+	if err := cache.StoreValidatorPass(key); err != nil {
+		log.Printf("warning: failed to cache the result of validator %s: %v", name, err)
+	}
+	return nil
+}
+
 // Helper function to evaluate validator inputs and make sure that all values are strings.
 func evalValidatorInputsAsStrings(inputs Dict, bp Blueprint) (map[string]string, error) {
 	ev, err := inputs.Eval(bp)
@@ -15,16 +15,29 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/cost"
+	"hpc-toolkit/pkg/diagnostics"
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/offline"
+	"hpc-toolkit/pkg/validators"
 
 	"github.com/pkg/errors"
 	"github.com/zclconf/go-cty/cty"
 	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
+	"google.golang.org/api/option"
 	. "gopkg.in/check.v1"
 )
 
@@ -115,6 +128,23 @@ func (s *MySuite) TestValidateSettings(c *C) {
 		c.Assert(err, IsNil)
 	}
 
+	// Succeeds: Sensitive setting of the module
+	info.Inputs = []modulereader.VarInfo{{Name: testSettingName}}
+	mod.Settings = NewDict(map[string]cty.Value{testSettingName: testSettingValue})
+	mod.SensitiveSettings = []string{testSettingName}
+	err = validateSettings(mod, info)
+	c.Assert(err, IsNil)
+
+	// Fails: Sensitive setting not found in the module
+	mod.SensitiveSettings = []string{"not_an_input"}
+	err = validateSettings(mod, info)
+	c.Check(errors.As(err, &e), Equals, true)
+
+	// Fails: Wrapped setting not found in the module
+	mod.SensitiveSettings = nil
+	mod.WrapSettings = map[string]string{"not_an_input": "flatten"}
+	err = validateSettings(mod, info)
+	c.Check(errors.As(err, &e), Equals, true)
 }
 
 func (s *MySuite) TestValidateModule(c *C) {
@@ -174,22 +204,42 @@ func (s *MySuite) TestValidateOutputs(c *C) {
 		{Name: "waldo"}}
 	expErr := fmt.Sprintf("%s.*", errorMessages["invalidOutput"])
 	c.Assert(validateOutputs(mod), ErrorMatches, expErr)
+
+	// Misspelled outputs are suggested the closest outputs of the module
+	modulereader.SetModuleInfo(mod.Source, mod.Kind.String(), modulereader.ModuleInfo{
+		Outputs: []modulereader.OutputInfo{
+			{Name: "velvet"}, {Name: "external_ip"}, {Name: "internal_ip"}}})
+	mod.Outputs = []modulereader.OutputInfo{{Name: "extrenal_ip"}}
+	c.Assert(validateOutputs(mod), ErrorMatches, ".*output: extrenal_ip; did you mean external_ip\\?")
+	mod.Outputs = []modulereader.OutputInfo{{Name: "ip"}}
+	c.Assert(validateOutputs(mod), ErrorMatches, ".*output: ip; did you mean external_ip or internal_ip\\?")
+	mod.Outputs = []modulereader.OutputInfo{{Name: "waldo"}}
+	c.Assert(validateOutputs(mod), ErrorMatches, ".*output: waldo; outputs of the module are external_ip, internal_ip, velvet")
+
+	// Missing outputs are reported before the deployment is expanded
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "g", Modules: []Module{mod}}}}
+	c.Assert(checkModuleOutputs(bp), ErrorMatches, expErr)
 }
 
 func (s *MySuite) TestAddDefaultValidators(c *C) {
 	dc := getDeploymentConfigForTest()
 	dc.addDefaultValidators()
-	c.Assert(dc.Config.Validators, HasLen, 4)
+	c.Assert(dc.Config.Validators, HasLen, 5)
 
 	dc.Config.Validators = nil
 	dc.Config.Vars.Set("region", cty.StringVal("us-central1"))
 	dc.addDefaultValidators()
-	c.Assert(dc.Config.Validators, HasLen, 5)
+	c.Assert(dc.Config.Validators, HasLen, 6)
 
 	dc.Config.Validators = nil
 	dc.Config.Vars.Set("zone", cty.StringVal("us-central1-c"))
 	dc.addDefaultValidators()
-	c.Assert(dc.Config.Validators, HasLen, 7)
+	c.Assert(dc.Config.Validators, HasLen, 9)
+
+	dc.Config.Validators = nil
+	dc.Config.Vars.Set("max_budget", cty.NumberIntVal(1000))
+	dc.addDefaultValidators()
+	c.Assert(dc.Config.Validators, HasLen, 10)
 }
 
 func (s *MySuite) TestMergeBlueprintRequirements(c *C) {
@@ -252,6 +302,12 @@ func (s *MySuite) TestExecuteValidators(c *C) {
 
 	err = dc.executeValidators()
 	c.Assert(err, ErrorMatches, validationErrorMsg)
+
+	// validators that require network access are skipped offline
+	defer func() { offline.Enabled = false }()
+	offline.Enabled = true
+	err = dc.executeValidators()
+	c.Assert(err, IsNil)
 }
 
 func (s *MySuite) TestApisEnabledValidator(c *C) {
@@ -279,6 +335,94 @@ func (s *MySuite) TestApisEnabledValidator(c *C) {
 	c.Assert(err, ErrorMatches, tooManyInputRegex)
 }
 
+func (s *MySuite) TestEnableApis(c *C) {
+	dc := getDeploymentConfigForTest()
+
+	// disabled APIs are not looked up unless they are enabled
+	c.Check(dc.enableApis("invalid-project", []string{"compute.googleapis.com"}), IsNil)
+
+	// no API calls are made when no APIs are required
+	dc.EnableApis = EnableApisAlways
+	c.Check(dc.enableApis("invalid-project", []string{}), IsNil)
+
+	dc.EnableApis = EnableApisDryRun
+	c.Check(dc.enableApis("invalid-project", []string{"compute.googleapis.com"}), NotNil)
+}
+
+// fakeServiceUsage serves the Service Usage API for project p, in which the
+// services of disabled are disabled until they are enabled
+func fakeServiceUsage(disabled map[string]bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "projects/p/services:batchGet"):
+			services := []map[string]interface{}{}
+			for _, name := range r.URL.Query()["names"] {
+				api := strings.TrimPrefix(name, "projects/p/services/")
+				state := "ENABLED"
+				if disabled[api] {
+					state = "DISABLED"
+				}
+				services = append(services, map[string]interface{}{
+					"name": name, "state": state, "config": map[string]string{"name": api}})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"services": services})
+		case strings.HasSuffix(r.URL.Path, "projects/p/services:batchEnable"):
+			var req struct {
+				ServiceIds []string `json:"serviceIds"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			for _, api := range req.ServiceIds {
+				delete(disabled, api)
+			}
+			fmt.Fprint(w, `{"name": "operations/enable", "done": true}`)
+		default:
+			http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+		}
+	}))
+}
+
+func (s *MySuite) TestApisEnabledValidator_FakeServiceUsage(c *C) {
+	defer func() { cache.Enabled = false }()
+	cache.Enabled = true
+	defer os.Unsetenv(cache.DirEnvVar)
+	os.Setenv(cache.DirEnvVar, c.MkDir())
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	disabled := map[string]bool{"file.googleapis.com": true}
+	srv := fakeServiceUsage(disabled)
+	defer srv.Close()
+	defer func() { clientOptions = nil }()
+	clientOptions = []option.ClientOption{option.WithEndpoint(srv.URL + "/"), option.WithoutAuthentication()}
+
+	dc := getDeploymentConfigForTest()
+	dc.Config.DeploymentGroups[0].Modules[0].RequiredApis = map[string][]string{
+		"p": {"compute.googleapis.com", "file.googleapis.com"}}
+	v := validatorConfig{Validator: testApisEnabledName.String()}
+
+	// disabled APIs fail the validator, in dry-run mode they are listed
+	c.Check(dc.testApisEnabled(v), NotNil)
+	c.Check(logs.String(), Matches, "(?s).*file.googleapis.com: service is disabled in project p.*")
+	dc.EnableApis = EnableApisDryRun
+	c.Check(dc.testApisEnabled(v), NotNil)
+	c.Check(logs.String(), Matches, "(?s).*file.googleapis.com: would be enabled in project p \\(dry run\\).*")
+	c.Check(disabled, DeepEquals, map[string]bool{"file.googleapis.com": true})
+
+	// disabled APIs are enabled
+	dc.EnableApis = EnableApisAlways
+	c.Check(dc.testApisEnabled(v), IsNil)
+	c.Check(logs.String(), Matches, "(?s).*file.googleapis.com: enabling service in project p.*")
+	c.Check(disabled, DeepEquals, map[string]bool{})
+
+	// the dry-run listing is printed even if the validator result is cached
+	disabled["file.googleapis.com"] = true
+	logs.Reset()
+	dc.EnableApis = EnableApisDryRun
+	c.Check(dc.testApisEnabled(v), IsNil)
+	c.Check(logs.String(), Matches, "(?s).*file.googleapis.com: would be enabled in project p \\(dry run\\).*")
+}
+
 // this function tests that the "gateway" functions in this package for our
 // validators fail under various conditions; it does not test the actual Cloud
 // API calls in the validators package; we will defer success testing until the
@@ -385,3 +529,437 @@ func (s *MySuite) TestZoneInRegionValidator(c *C) {
 
 	// TODO: implement a mock client to test success of test_zone_in_region
 }
+
+func (s *MySuite) TestMachineTypesAvailableValidator(c *C) {
+	dc := getDeploymentConfigForTest()
+
+	// test validator fails for config without validator id
+	c.Assert(dc.testMachineTypesAvailable(validatorConfig{}), ErrorMatches, passedWrongValidatorRegex)
+
+	// test validator fails for config without any inputs
+	mtValidator := validatorConfig{Validator: testMachineTypesAvailableName.String()}
+	c.Assert(dc.testMachineTypesAvailable(mtValidator), ErrorMatches, missingRequiredInputRegex)
+
+	// test validator fails when input global variables are undefined
+	mtValidator.Inputs.
+		Set("project_id", MustParseExpression("var.project_id").AsValue()).
+		Set("zone", MustParseExpression("var.zone").AsValue())
+	c.Assert(dc.testMachineTypesAvailable(mtValidator), NotNil)
+
+	// test validator succeeds without calling APIs when no types are requested
+	dc.Config.Vars.
+		Set("project_id", cty.StringVal("invalid-project")).
+		Set("zone", cty.StringVal("us-central1-a"))
+	c.Assert(dc.testMachineTypesAvailable(mtValidator), IsNil)
+
+	dc.Config.DeploymentGroups[0].Modules[0].Settings.Set("machine_type", cty.StringVal("c2-standard-60"))
+	c.Assert(dc.testMachineTypesAvailable(mtValidator), NotNil)
+}
+
+// fakeCompute serves the machine types and accelerator types of project p,
+// available holds the zones of each type
+func fakeCompute(available map[string][]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		switch {
+		// projects/p/zones/<zone>/<kind>/<type>
+		case len(parts) == 6 && parts[0] == "projects" && parts[2] == "zones":
+			if slices.Contains(available[parts[5]], parts[3]) {
+				fmt.Fprintf(w, `{"name": %q}`, parts[5])
+				return
+			}
+		// projects/p/aggregated/<kind>?filter=name = <type>
+		case len(parts) == 4 && parts[0] == "projects" && parts[2] == "aggregated":
+			name := strings.TrimPrefix(r.URL.Query().Get("filter"), "name = ")
+			items := map[string]interface{}{}
+			for _, z := range available[name] {
+				items["zones/"+z] = map[string]interface{}{parts[3]: []map[string]string{{"name": name}}}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+			return
+		}
+		http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+	}))
+}
+
+func (s *MySuite) TestMachineTypesAvailableValidator_FakeCompute(c *C) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	srv := fakeCompute(map[string][]string{
+		"c2-standard-60": {"us-central1-a", "us-central1-b"},
+		"h3-standard-88": {"europe-west4-a", "us-east1-b", "us-central1-f", "us-central1-c", "asia-east1-a"},
+		"nvidia-l4":      {"us-west1-b", "asia-east1-a"},
+	})
+	defer srv.Close()
+	defer func() { clientOptions = nil }()
+	clientOptions = []option.ClientOption{option.WithEndpoint(srv.URL + "/"), option.WithoutAuthentication()}
+
+	dc := getDeploymentConfigForTest()
+	dc.Config.Vars.
+		Set("project_id", cty.StringVal("p")).
+		Set("zone", cty.StringVal("us-central1-a"))
+	mtValidator := validatorConfig{Validator: testMachineTypesAvailableName.String()}
+	mtValidator.Inputs.
+		Set("project_id", MustParseExpression("var.project_id").AsValue()).
+		Set("zone", MustParseExpression("var.zone").AsValue())
+	settings := &dc.Config.DeploymentGroups[0].Modules[0].Settings
+
+	// available machine type
+	settings.Set("machine_type", cty.StringVal("c2-standard-60"))
+	c.Check(dc.testMachineTypesAvailable(mtValidator), IsNil)
+	c.Check(logs.String(), Equals, "")
+
+	// unavailable types are logged with the nearby zones that offer them
+	settings.Set("machine_type", cty.StringVal("h3-standard-88"))
+	settings.Set("guest_accelerator", cty.TupleVal([]cty.Value{cty.ObjectVal(map[string]cty.Value{
+		"type":  cty.StringVal("nvidia-l4"),
+		"count": cty.NumberIntVal(1),
+	})}))
+	c.Check(dc.testMachineTypesAvailable(mtValidator), NotNil)
+	c.Check(logs.String(), Matches, "(?s).*machine type h3-standard-88 is not available in zone us-central1-a of project ID p; "+
+		"it is available in us-central1-c, us-central1-f, us-east1-b\n.*")
+	c.Check(logs.String(), Matches, "(?s).*accelerator type nvidia-l4 is not available in zone us-central1-a of project ID p; "+
+		"it is available in us-west1-b, asia-east1-a\n.*")
+
+	// types that no zone offers
+	logs.Reset()
+	settings.Set("machine_type", cty.StringVal("x9-standard-1"))
+	settings.Set("guest_accelerator", cty.ListValEmpty(cty.DynamicPseudoType))
+	c.Check(dc.testMachineTypesAvailable(mtValidator), NotNil)
+	c.Check(logs.String(), Matches, "(?s).*machine type x9-standard-1 is not available in zone us-central1-a of project ID p; "+
+		"it is not available in any zone of the project\n.*")
+}
+
+func (s *MySuite) TestIamPermissionsValidator(c *C) {
+	dc := getDeploymentConfigForTest()
+
+	// test validator fails for config without validator id
+	c.Assert(dc.testIamPermissions(validatorConfig{}), ErrorMatches, passedWrongValidatorRegex)
+
+	// test validator fails for config without any inputs
+	iamValidator := validatorConfig{Validator: testIamPermissionsName.String()}
+	c.Assert(dc.testIamPermissions(iamValidator), ErrorMatches, missingRequiredInputRegex)
+
+	// test validator succeeds without calling APIs when no permissions are required
+	iamValidator.Inputs.Set("project_id", MustParseExpression("var.project_id").AsValue())
+	dc.Config.Vars.Set("project_id", cty.StringVal("invalid-project"))
+	c.Assert(dc.Config.requiredPermissions(), DeepEquals, []string{})
+	c.Assert(dc.testIamPermissions(iamValidator), IsNil)
+
+	setTestModuleInfo(dc.Config.DeploymentGroups[0].Modules[0], modulereader.ModuleInfo{
+		RequiredPermissions: []string{"compute.instances.create"}})
+	c.Assert(dc.testIamPermissions(iamValidator), NotNil)
+
+	// TODO: implement a mock client to test success of test_iam_permissions
+}
+
+func (s *MySuite) TestBudgetValidator(c *C) {
+	dc := getDeploymentConfigForTest()
+
+	// test validator fails for config without validator id
+	c.Assert(dc.testBudget(validatorConfig{}), ErrorMatches, passedWrongValidatorRegex)
+
+	// test validator fails for config without any inputs
+	budgetValidator := validatorConfig{Validator: testBudgetName.String()}
+	c.Assert(dc.testBudget(budgetValidator), ErrorMatches, missingRequiredInputRegex)
+
+	// test validator fails when max_budget is not a number
+	budgetValidator.Inputs.
+		Set("project_id", MustParseExpression("var.project_id").AsValue()).
+		Set("max_budget", MustParseExpression("var.max_budget").AsValue())
+	dc.Config.Vars.
+		Set("project_id", cty.StringVal("invalid-project")).
+		Set("max_budget", cty.StringVal("a lot"))
+	c.Assert(dc.testBudget(budgetValidator), ErrorMatches, "max_budget must be .*")
+
+	// test validator succeeds without calling APIs when nothing is billable
+	dc.Config.Vars.Set("max_budget", cty.NumberIntVal(1000))
+	c.Assert(dc.testBudget(budgetValidator), IsNil)
+	dc.Config.Vars.Set("max_budget", cty.StringVal("1000"))
+	c.Assert(dc.testBudget(budgetValidator), IsNil)
+}
+
+// stubPricer prices resources by their type
+type stubPricer map[string]float64
+
+func (p stubPricer) HourlyPrice(r cost.Resource) (float64, error) {
+	if price, ok := p[r.Type]; ok {
+		return price, nil
+	}
+	return 0, fmt.Errorf("no price of %s", r.Type)
+}
+
+func (s *MySuite) TestBudgetValidator_StubPricer(c *C) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer func(f func(string) (cost.Pricer, error)) { newPricer = f }(newPricer)
+	var pricedProject string
+	newPricer = func(projectID string) (cost.Pricer, error) {
+		pricedProject = projectID
+		return stubPricer{"c2-standard-60": 3}, nil
+	}
+
+	dc := getDeploymentConfigForTest()
+	budgetValidator := validatorConfig{Validator: testBudgetName.String()}
+	budgetValidator.Inputs.
+		Set("project_id", MustParseExpression("var.project_id").AsValue()).
+		Set("max_budget", MustParseExpression("var.max_budget").AsValue())
+	dc.Config.Vars.
+		Set("project_id", cty.StringVal("p")).
+		Set("zone", cty.StringVal("us-central1-a")).
+		Set("max_budget", cty.NumberIntVal(3000))
+	dc.Config.DeploymentGroups[0].Modules[0].Settings.Set("machine_type", cty.StringVal("c2-standard-60"))
+
+	// $3 per hour is $2190 per month
+	c.Check(dc.testBudget(budgetValidator), IsNil)
+	c.Check(pricedProject, Equals, "p")
+
+	dc.Config.Vars.Set("max_budget", cty.NumberIntVal(2000))
+	c.Check(dc.testBudget(budgetValidator), NotNil)
+	c.Check(logs.String(), Matches, "(?s).*deployment group group1 is estimated to cost \\$2190.00 per month.*")
+	c.Check(logs.String(), Matches, "(?s).*\\$2190.00, exceeds max_budget of \\$2000.00.*")
+
+	// resources without a price are left out of the estimate
+	logs.Reset()
+	dc.Config.DeploymentGroups[0].Modules[1].Settings.Set("machine_type", cty.StringVal("x9-standard-1"))
+	dc.Config.Vars.Set("max_budget", cty.NumberIntVal(3000))
+	c.Check(dc.testBudget(budgetValidator), IsNil)
+	c.Check(logs.String(), Matches, "(?s).*warning: x9-standard-1 instance of module testModuleWithLabels is not included in the estimated cost: no price of x9-standard-1.*")
+
+	// failing to create the pricer fails the validator
+	newPricer = func(string) (cost.Pricer, error) { return nil, errors.New("no credentials") }
+	c.Check(dc.testBudget(budgetValidator), NotNil)
+	c.Check(logs.String(), Matches, "(?s).*no credentials.*")
+}
+
+func (s *MySuite) TestRequiredPermissions(c *C) {
+	dc := getDeploymentConfigForTest()
+	mods := dc.Config.DeploymentGroups[0].Modules
+	setTestModuleInfo(mods[0], modulereader.ModuleInfo{
+		RequiredPermissions: []string{"compute.instances.create", "compute.disks.create"}})
+	bucket := Module{ID: "bucket", Source: "./modules/bucket", Kind: TerraformKind}
+	setTestModuleInfo(bucket, modulereader.ModuleInfo{
+		RequiredPermissions: []string{"storage.buckets.create", "compute.disks.create"}})
+	dc.Config.DeploymentGroups[0].Modules = append(mods, bucket)
+
+	c.Check(dc.Config.requiredPermissions(), DeepEquals, []string{
+		"compute.disks.create",
+		"compute.instances.create",
+		"storage.buckets.create",
+	})
+}
+
+func (s *MySuite) TestRequestedMachineTypes(c *C) {
+	dc := getDeploymentConfigForTest()
+	dc.Config.Vars.Set("zone", cty.StringVal("us-central1-a"))
+	mods := dc.Config.DeploymentGroups[0].Modules
+	mods[0].Settings = NewDict(map[string]cty.Value{
+		"machine_type": cty.StringVal("a2-highgpu-1g"),
+		"guest_accelerator": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{
+				"type":  cty.StringVal("nvidia-tesla-a100"),
+				"count": cty.NumberIntVal(1),
+			}),
+		}),
+	})
+	mods = append(mods,
+		Module{ID: "gpus", Settings: NewDict(map[string]cty.Value{
+			"zone":         GlobalRef("zone").AsExpression().AsValue(),
+			"machine_type": cty.StringVal("n1-standard-8"),
+			"gpu": cty.ObjectVal(map[string]cty.Value{
+				"type":  cty.StringVal("nvidia-tesla-t4"),
+				"count": cty.NumberIntVal(2),
+			}),
+		})},
+		Module{ID: "elsewhere", Settings: NewDict(map[string]cty.Value{
+			"zone":         cty.StringVal("europe-west4-b"),
+			"machine_type": cty.StringVal("a2-highgpu-1g"),
+		})},
+		// machine types that are outputs of other modules are unknown
+		Module{ID: "unknown", Settings: NewDict(map[string]cty.Value{
+			"machine_type": ModuleRef("gpus", "machine_type").AsExpression().AsValue(),
+		})},
+	)
+	dc.Config.DeploymentGroups[0].Modules = mods
+
+	c.Check(requestedMachineTypes(dc.Config, "us-central1-a"), DeepEquals, map[string]zoneTypes{
+		"us-central1-a": {
+			machineTypes:     []string{"a2-highgpu-1g", "n1-standard-8"},
+			acceleratorTypes: []string{"nvidia-tesla-a100", "nvidia-tesla-t4"},
+		},
+		"europe-west4-b": {machineTypes: []string{"a2-highgpu-1g"}},
+	})
+}
+
+func (s *MySuite) TestCheckValidators(c *C) {
+	dc := getDeploymentConfigForTest()
+	c.Check(dc.checkValidators(), IsNil)
+
+	dc.Config.Validators = []validatorConfig{
+		{Validator: testModuleNotUsedName.String()},
+		{Validator: "zone_in_region", Expression: "vars.zone.startsWith(vars.region)", Message: "zone must be in region"},
+	}
+	c.Check(dc.checkValidators(), IsNil)
+
+	type test struct {
+		v   validatorConfig
+		err string
+	}
+	for _, t := range []test{
+		{validatorConfig{Expression: "true"}, ".*must have a name"},
+		{validatorConfig{Validator: testProjectExistsName.String(), Expression: "true"}, ".*name of a built-in validator"},
+		{validatorConfig{Validator: "zone_in_region", Expression: "true"}, ".*declared more than once"},
+		{validatorConfig{Validator: "bad", Expression: "vars.zone =="}, ".*invalid expression.*"},
+		{validatorConfig{Validator: "bad", Expression: "true", Inputs: NewDict(map[string]cty.Value{"a": cty.True})}, ".*inputs cannot be set.*"},
+		{validatorConfig{Validator: testModuleNotUsedName.String(), Message: "m"}, ".*message can only be set with an expression"},
+		{validatorConfig{Command: []string{"true"}}, ".*must have a name"},
+		{validatorConfig{Validator: "both", Expression: "true", Command: []string{"true"}}, ".*cannot both be set"},
+		{validatorConfig{Validator: "zone_in_region", Command: []string{"true"}}, ".*declared more than once"},
+	} {
+		dc.Config.Validators = []validatorConfig{
+			{Validator: "zone_in_region", Expression: "true"},
+			t.v,
+		}
+		c.Check(dc.checkValidators(), ErrorMatches, t.err)
+	}
+}
+
+func (s *MySuite) TestPluginValidator(c *C) {
+	dc := getDeploymentConfigForTest()
+	out := filepath.Join(c.MkDir(), "input.json")
+	plugin := validatorConfig{
+		Validator: "naming",
+		Command:   []string{"sh", "-c", `cat > "$0"; echo '{"diagnostics": [{"severity": "warning", "message": "m"}]}'`, out},
+		Inputs:    NewDict(map[string]cty.Value{"prefix": cty.StringVal("hpc-")}),
+	}
+	dc.Config.Validators = []validatorConfig{plugin}
+	c.Check(dc.checkValidators(), IsNil)
+
+	var diags []diagnostics.Diagnostic
+	dc.Report = func(d diagnostics.Diagnostic) { diags = append(diags, d) }
+
+	// plugins run commands, which must be allowed
+	c.Check(dc.testPlugin(plugin), ErrorMatches, "validator naming failed")
+	c.Assert(diags, HasLen, 1)
+	c.Check(diags[0].Message, Matches, "validator naming runs sh; .*--allow-exec.*")
+
+	dc.AllowExec = true
+	diags = nil
+	c.Assert(dc.testPlugin(plugin), IsNil)
+	c.Check(diags, DeepEquals, []diagnostics.Diagnostic{{RuleID: "naming", Severity: diagnostics.Warning, Message: "m"}})
+	in, err := os.ReadFile(out)
+	c.Assert(err, IsNil)
+	var got validators.PluginInput
+	c.Assert(json.Unmarshal(in, &got), IsNil)
+	c.Check(got.Validator, Equals, "naming")
+	c.Check(got.Inputs, DeepEquals, map[string]string{"prefix": "hpc-"})
+	var bp map[string]interface{}
+	c.Assert(json.Unmarshal(got.Blueprint, &bp), IsNil)
+	c.Check(bp["blueprint_name"], Equals, dc.Config.BlueprintName)
+
+	failing := validatorConfig{Validator: "cmdb", Command: []string{"sh", "-c", `echo '{"diagnostics": [{"message": "not registered"}]}'`}}
+	c.Check(dc.testPlugin(failing), ErrorMatches, "validator cmdb failed")
+
+	// plugin validators run with the built-in validators
+	dc.Config.Validators = []validatorConfig{plugin, failing}
+	c.Check(dc.executeValidators(), ErrorMatches, validationErrorMsg)
+	dc.Config.Validators[1].Level = "WARNING"
+	c.Check(dc.executeValidators(), IsNil)
+}
+
+func (s *MySuite) TestExpressionValidator(c *C) {
+	dc := getDeploymentConfigForTest()
+	dc.Config.Vars.
+		Set("region", cty.StringVal("us-central1")).
+		Set("zone", cty.StringVal("us-east1-b"))
+	dc.Config.DeploymentGroups[0].Modules[0].Settings.
+		Set("zone", GlobalRef("zone").AsExpression().AsValue()).
+		Set("network", ModuleRef("network0", "network_name").AsExpression().AsValue()).
+		Set("count", cty.NumberIntVal(2))
+
+	act := expressionActivation(dc.Config)
+	settings := act["modules"].(map[string]interface{})["testModule"].(map[string]interface{})["settings"]
+	c.Check(settings, DeepEquals, map[string]interface{}{
+		"zone":    "us-east1-b",
+		"network": validators.Unknown("setting network of module testModule"),
+		"count":   int64(2),
+	})
+
+	zoneInRegion := validatorConfig{Validator: "zone_in_region", Expression: "vars.zone.startsWith(vars.region)"}
+	c.Check(dc.testExpression(zoneInRegion), ErrorMatches, "validator zone_in_region failed")
+	dc.Config.Vars.Set("zone", cty.StringVal("us-central1-a"))
+	c.Check(dc.testExpression(zoneInRegion), IsNil)
+
+	sameZone := validatorConfig{Validator: "same_zone", Expression: "modules.testModule.settings.zone == vars.zone"}
+	c.Check(dc.testExpression(sameZone), IsNil)
+
+	// settings set to outputs of modules are only known after deployment
+	network := validatorConfig{Validator: "network", Expression: `modules.testModule.settings.network == "default"`}
+	c.Check(dc.testExpression(network), ErrorMatches, "validator network failed")
+
+	// expression validators run with the built-in validators
+	dc.Config.Validators = []validatorConfig{sameZone, network}
+	c.Check(dc.executeValidators(), ErrorMatches, validationErrorMsg)
+	dc.Config.Validators[1].Skip = true
+	c.Check(dc.executeValidators(), IsNil)
+}
+
+func (s *MySuite) TestValidatorLevels(c *C) {
+	dc := getDeploymentConfigForTest()
+	failing := validatorConfig{Validator: "failing", Expression: "false"}
+
+	// the level of a validator overrides the level of the blueprint
+	type test struct {
+		global int
+		level  string
+		err    bool
+	}
+	for _, t := range []test{
+		{ValidationWarning, "", false},
+		{ValidationWarning, "ERROR", true},
+		{ValidationError, "", true},
+		{ValidationError, "WARNING", false},
+		{ValidationError, "IGNORE", false},
+		// the level of the blueprint disables all validators
+		{ValidationIgnore, "ERROR", false},
+	} {
+		dc.Config.ValidationLevel = t.global
+		v := failing
+		v.Level = t.level
+		dc.Config.Validators = []validatorConfig{v}
+		err := dc.executeValidators()
+		c.Check(err != nil, Equals, t.err, Commentf("global level %d, validator level %q", t.global, t.level))
+	}
+
+	dc.Config.Validators = []validatorConfig{{Validator: testModuleNotUsedName.String(), Level: "WARNING"}}
+	c.Check(dc.checkValidators(), IsNil)
+	dc.Config.Validators[0].Level = "warn"
+	c.Check(dc.checkValidators(), ErrorMatches, "validator test_module_not_used: invalid validation level.*")
+}
+
+func (s *MySuite) TestCachedTest(c *C) {
+	defer func() { cache.Enabled = false }()
+	cache.Enabled = true
+	defer os.Unsetenv(cache.DirEnvVar)
+	os.Setenv(cache.DirEnvVar, c.MkDir())
+	calls := 0
+	failing := func() error { calls++; return errors.New("failed") }
+	passing := func() error { calls++; return nil }
+
+	// failures are not cached
+	c.Check(cachedTest("test_project_exists", failing, "p"), NotNil)
+	c.Check(cachedTest("test_project_exists", failing, "p"), NotNil)
+	c.Check(calls, Equals, 2)
+
+	c.Check(cachedTest("test_project_exists", passing, "p"), IsNil)
+	c.Check(cachedTest("test_project_exists", failing, "p"), IsNil)
+	c.Check(calls, Equals, 3)
+
+	// results are cached by validator and arguments
+	c.Check(cachedTest("test_project_exists", failing, "q"), NotNil)
+	c.Check(cachedTest("test_zone_exists", failing, "p"), NotNil)
+	c.Check(calls, Equals, 5)
+}
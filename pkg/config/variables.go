@@ -0,0 +1,265 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// VariableDeclaration declares a deployment variable in the
+// deployment_variables section of a blueprint
+type VariableDeclaration struct {
+	// Type is a Terraform type constraint, e.g. "list(string)"; values of
+	// any type are accepted if it is not set
+	Type        string `yaml:"type,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	// Default is the value of the variable if vars does not set it; the
+	// variable is required if there is no default
+	Default    *YamlValue           `yaml:"default,omitempty"`
+	Validation []VariableValidation `yaml:"validation,omitempty"`
+	// Sensitive variables hold secrets, which are redacted from expanded
+	// blueprints and masked in logs
+	Sensitive bool `yaml:"sensitive,omitempty"`
+}
+
+// VariableValidation is a rule the value of a deployment variable must
+// satisfy
+type VariableValidation struct {
+	// Condition is an expression, as those of expression validators, that
+	// must be true; "value" is the value of the variable and "vars" are the
+	// deployment variables
+	Condition    string `yaml:"condition"`
+	ErrorMessage string `yaml:"error_message,omitempty"`
+}
+
+// PromptFunc asks for the value of what, a required deployment variable or
+// module setting without a value, of type ty
+type PromptFunc func(what string, description string, ty cty.Type) (cty.Value, error)
+
+// TypeConstraint returns the type of the declaration, cty.DynamicPseudoType
+// if it has none
+func (d VariableDeclaration) TypeConstraint() (cty.Type, error) {
+	return parseTypeConstraint(d.Type)
+}
+
+// parseTypeConstraint parses a Terraform type constraint, an empty one is
+// cty.DynamicPseudoType
+func parseTypeConstraint(s string) (cty.Type, error) {
+	if s == "" {
+		return cty.DynamicPseudoType, nil
+	}
+	expr, diags := hclsyntax.ParseExpression([]byte(s), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return cty.NilType, diags
+	}
+	ty, diags := typeexpr.TypeConstraint(expr)
+	if diags.HasErrors() {
+		return cty.NilType, diags
+	}
+	return ty, nil
+}
+
+// DeclaredVariables returns the names of the declared deployment variables,
+// sorted
+func (bp Blueprint) DeclaredVariables() []string {
+	names := maps.Keys(bp.DeploymentVariables)
+	slices.Sort(names)
+	return names
+}
+
+// applyVariableDeclarations sets the declared deployment variables that vars
+// does not set to their defaults, or asks for their values with prompt if
+// they are required and prompt is not nil, resolves references between
+// deployment variables, and checks the values of all declared variables
+// against their types and validation rules
+func (bp *Blueprint) applyVariableDeclarations(prompt PromptFunc) error {
+	for _, name := range bp.DeclaredVariables() {
+		d := bp.DeploymentVariables[name]
+		ty, err := d.TypeConstraint()
+		if err != nil {
+			return fmt.Errorf("deployment variable %s: invalid type %q: %v", name, d.Type, err)
+		}
+		if !bp.Vars.Has(name) && d.Default == nil && prompt != nil {
+			v, err := prompt("deployment variable "+name, d.Description, ty)
+			if err != nil {
+				return err
+			}
+			bp.Vars.Set(name, v)
+		}
+		if !bp.Vars.Has(name) {
+			if d.Default == nil {
+				return fmt.Errorf("deployment variable %s is required%s", name, describeSuffix(d))
+			}
+			if _, is := IsExpressionValue(d.Default.Unwrap()); is {
+				return fmt.Errorf("deployment variable %s: the default cannot be an expression", name)
+			}
+			bp.Vars.Set(name, d.Default.Unwrap())
+		}
+	}
+
+	// variables may refer to the defaults of others
+	if err := bp.resolveVarReferences(); err != nil {
+		return err
+	}
+
+	for _, name := range bp.DeclaredVariables() {
+		d := bp.DeploymentVariables[name]
+		ty, _ := d.TypeConstraint() // checked above
+		v := bp.Vars.Get(name)
+		if isDeployTimeRef(v) {
+			continue // the value is only known when the deployment is deployed
+		}
+		if _, err := convert.Convert(v, ty); err != nil {
+			return fmt.Errorf("deployment variable %s must be of type %s: %v", name, typeexpr.TypeString(ty), err)
+		}
+	}
+
+	// rules are checked once all defaults are set, as they can refer to
+	// other variables
+	for _, name := range bp.DeclaredVariables() {
+		if isDeployTimeRef(bp.Vars.Get(name)) {
+			continue
+		}
+		for _, r := range bp.DeploymentVariables[name].Validation {
+			if err := bp.checkVariableRule(name, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveVarReferences replaces the references to other deployment variables
+// in the values of deployment variables, at any depth, by the values they
+// refer to; variables referring to each other in a cycle are an error.
+// Module variables, which refer to module outputs, are left as is.
+func (bp *Blueprint) resolveVarReferences() error {
+	pending := map[string]bool{}
+	for name, v := range bp.Vars.Items() {
+		if len(valueReferences(v)) > 0 {
+			pending[name] = true
+		}
+	}
+
+	for len(pending) > 0 {
+		resolved := false
+		names := maps.Keys(pending)
+		slices.Sort(names)
+		for _, name := range names {
+			v := bp.Vars.Get(name)
+			ready := true
+			for _, r := range valueReferences(v) {
+				if !r.GlobalVar {
+					continue
+				}
+				if !bp.Vars.Has(r.Name) {
+					return fmt.Errorf("deployment variable %s: refers to deployment variable %s, which is not set", name, r.Name)
+				}
+				ready = ready && !pending[r.Name]
+			}
+			if !ready {
+				continue
+			}
+			if bp.IsModuleVar(name) && slices.Contains(bp.toolkitVars(), name) {
+				return fmt.Errorf("deployment variable %s can not refer to module outputs", name)
+			}
+			if !bp.IsModuleVar(name) {
+				ev, err := evalVarValue(v, *bp)
+				if err != nil {
+					return fmt.Errorf("deployment variable %s: %w", name, err)
+				}
+				bp.Vars.Set(name, ev)
+			}
+			delete(pending, name)
+			resolved = true
+		}
+		if !resolved {
+			return fmt.Errorf("deployment variables %s refer to each other in a cycle", strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// valueReferences returns the references of all expressions in the value
+func valueReferences(v cty.Value) []Reference {
+	rs := []Reference{}
+	cty.Walk(v, func(p cty.Path, v cty.Value) (bool, error) {
+		if e, is := IsExpressionValue(v); is {
+			rs = append(rs, e.References()...)
+		}
+		return true, nil
+	})
+	return rs
+}
+
+// evalVarValue evaluates the expressions in the value of a deployment
+// variable, raw HCL expressions are only known to Terraform
+func evalVarValue(v cty.Value, bp Blueprint) (cty.Value, error) {
+	return cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		e, is := IsExpressionValue(v)
+		if !is {
+			return v, nil
+		}
+		if _, raw := e.(RawExpression); raw {
+			return cty.NilVal, fmt.Errorf("can not use %s expressions in vars block", RawHCLTag)
+		}
+		return e.Eval(bp)
+	})
+}
+
+// describeSuffix returns ": <description>" for errors about the variable
+func describeSuffix(d VariableDeclaration) string {
+	if d.Description == "" {
+		return ""
+	}
+	return ": " + strings.TrimSpace(d.Description)
+}
+
+func (bp Blueprint) checkVariableRule(name string, r VariableValidation) error {
+	e, err := validators.CompileExpression(r.Condition)
+	if err != nil {
+		return fmt.Errorf("deployment variable %s: %v", name, err)
+	}
+	vars := map[string]interface{}{}
+	for k, v := range bp.Vars.Items() {
+		vars[k] = celValue(v, "deployment variable "+k)
+	}
+	activation := map[string]interface{}{"value": vars[name], "vars": vars}
+	res, err := e.Eval(activation)
+	if err != nil {
+		return fmt.Errorf("deployment variable %s: failed to evaluate %s: %v", name, e, err)
+	}
+	if ok, is := res.(bool); !is {
+		return fmt.Errorf("deployment variable %s: %s must evaluate to a bool", name, e)
+	} else if !ok {
+		msg := r.ErrorMessage
+		if msg == "" {
+			msg = fmt.Sprintf("%s is false", e)
+		}
+		return fmt.Errorf("deployment variable %s is invalid: %s", name, msg)
+	}
+	return nil
+}
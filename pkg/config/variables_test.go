@@ -0,0 +1,206 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+const declarationsForTest = `
+blueprint_name: decl
+vars:
+  deployment_name: hpc
+  node_count: 4
+deployment_variables:
+  node_count:
+    type: number
+    description: Number of compute nodes
+    validation:
+    - condition: value > 0 && value <= vars.max_nodes
+      error_message: node_count must be between 1 and max_nodes
+  max_nodes:
+    type: number
+    default: 10
+  zones:
+    type: list(string)
+    description: Zones of the nodes
+    default: [us-central1-a]
+deployment_groups: []
+`
+
+func declaredBlueprint(t *testing.T) Blueprint {
+	t.Helper()
+	dc, err := NewDeploymentConfigFromBytes([]byte(declarationsForTest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dc.Config
+}
+
+func TestApplyVariableDeclarations(t *testing.T) {
+	bp := declaredBlueprint(t)
+	if err := bp.applyVariableDeclarations(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := bp.Vars.Get("max_nodes"); !got.RawEquals(cty.NumberIntVal(10)) {
+		t.Errorf("got max_nodes %#v, want the default", got)
+	}
+	if got := bp.Vars.Get("zones"); !got.RawEquals(cty.TupleVal([]cty.Value{cty.StringVal("us-central1-a")})) {
+		t.Errorf("got zones %#v, want the default", got)
+	}
+
+	type test struct {
+		vars map[string]cty.Value
+		err  string
+	}
+	tests := map[string]test{
+		"missing":    {map[string]cty.Value{}, "node_count is required: Number of compute nodes"},
+		"wrong type": {map[string]cty.Value{"node_count": cty.StringVal("many")}, "must be of type number"},
+		"rule":       {map[string]cty.Value{"node_count": cty.NumberIntVal(20)}, "node_count must be between 1 and max_nodes"},
+		"other var":  {map[string]cty.Value{"node_count": cty.NumberIntVal(20), "max_nodes": cty.NumberIntVal(32)}, ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			bp := declaredBlueprint(t)
+			bp.Vars = NewDict(tc.vars)
+			err := bp.applyVariableDeclarations(nil)
+			if tc.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestResolveVarReferences(t *testing.T) {
+	yml := `
+blueprint_name: refs
+vars:
+  deployment_name: hpc
+  region: us-central1
+  zone: $(vars.zones[0])
+  labels:
+    owner: $(vars.owner)
+    nested: [{region: $(vars.region)}, \$(literal)]
+  owner: (("${var.team}-admin"))
+  team: hpc
+deployment_variables:
+  zones:
+    type: list(string)
+    default: [us-central1-a]
+deployment_groups: []
+`
+	dc, err := NewDeploymentConfigFromBytes([]byte(yml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := dc.Config
+	if err := bp.applyVariableDeclarations(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := bp.Vars.Get("zone"); !got.RawEquals(cty.StringVal("us-central1-a")) {
+		t.Errorf("got zone %#v, want the first of the default zones", got)
+	}
+	want := cty.ObjectVal(map[string]cty.Value{
+		"owner": cty.StringVal("hpc-admin"),
+		"nested": cty.TupleVal([]cty.Value{
+			cty.ObjectVal(map[string]cty.Value{"region": cty.StringVal("us-central1")}),
+			cty.StringVal(`\$(literal)`), // unescaped when written
+		}),
+	})
+	if got := bp.Vars.Get("labels"); !got.RawEquals(want) {
+		t.Errorf("got labels %#v, want %#v", got, want)
+	}
+
+	type test struct {
+		vars map[string]string
+		err  string
+	}
+	tests := map[string]test{
+		"cycle":     {map[string]string{"a": "$(vars.b)", "b": "$(vars.a)"}, "deployment variables a, b refer to each other in a cycle"},
+		"self":      {map[string]string{"a": "$(vars.a)"}, "deployment variables a refer to each other in a cycle"},
+		"not set":   {map[string]string{"a": "$(vars.b)"}, "refers to deployment variable b, which is not set"},
+		"module":    {map[string]string{"a": "$(vars.b)", "b": "$(net.subnet)"}, ""},
+		"raw":       {map[string]string{"a": "!hcl var.b", "b": "c"}, "can not use !hcl expressions"},
+		"chained":   {map[string]string{"a": "$(vars.b)", "b": "$(vars.c)", "c": "d"}, ""},
+		"no values": {map[string]string{"a": "b"}, ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var vars Dict
+			src := ""
+			for k, v := range tc.vars {
+				src += k + ": " + v + "\n"
+			}
+			if err := yaml.Unmarshal([]byte(src), &vars); err != nil {
+				t.Fatal(err)
+			}
+			bp := Blueprint{Vars: vars}
+			err := bp.resolveVarReferences()
+			if tc.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("got error %v, want %q", err, tc.err)
+			}
+			for n, v := range bp.Vars.Items() {
+				if err == nil && !bp.IsModuleVar(n) && len(valueReferences(v)) > 0 {
+					t.Errorf("got unresolved references in %s: %#v", n, v)
+				}
+			}
+		})
+	}
+}
+
+func TestMarshalVariableDeclarations(t *testing.T) {
+	bp := declaredBlueprint(t)
+	b, err := yaml.Marshal(bp.DeploymentVariables["zones"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "type: list(string)\ndescription: Zones of the nodes\ndefault:\n    - us-central1-a\n"
+	if string(b) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b, want)
+	}
+}
+
+func TestPromptVariables(t *testing.T) {
+	bp := declaredBlueprint(t)
+	bp.Vars = NewDict(map[string]cty.Value{})
+	asked := []string{}
+	prompt := func(what string, description string, ty cty.Type) (cty.Value, error) {
+		asked = append(asked, what)
+		if ty != cty.Number || description != "Number of compute nodes" {
+			t.Errorf("got type %s and description %q", ty.FriendlyName(), description)
+		}
+		return cty.NumberIntVal(3), nil
+	}
+	if err := bp.applyVariableDeclarations(prompt); err != nil {
+		t.Fatal(err)
+	}
+	// variables with defaults are not asked for
+	if len(asked) != 1 || asked[0] != "deployment variable node_count" {
+		t.Errorf("got questions %v", asked)
+	}
+	if got := bp.Vars.Get("node_count"); !got.RawEquals(cty.NumberIntVal(3)) {
+		t.Errorf("got node_count %#v", got)
+	}
+}
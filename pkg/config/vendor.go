@@ -0,0 +1,67 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"hpc-toolkit/pkg/offline"
+	"hpc-toolkit/pkg/sourcereader"
+)
+
+// replaced in tests
+var fetchModule = func(modPath string, dst string) error {
+	return sourcereader.Factory(modPath).GetModule(modPath, dst)
+}
+
+// localSource returns dir as a local module source
+func localSource(dir string) string {
+	dir = filepath.ToSlash(filepath.Clean(dir))
+	if sourcereader.IsLocalPath(dir) {
+		return dir
+	}
+	return "./" + dir
+}
+
+// VendorModules downloads the sources of all remote and Terraform Registry
+// modules to directories under vendorDir and rewrites the sources of the
+// modules to point to them, so the blueprint can be deployed without network
+// access to the module sources. Relative vendor directories are relative to
+// the working directory, as are all local sources. The vendor directory can
+// serve as the modules directory of an offline mirror.
+func (bp *Blueprint) VendorModules(vendorDir string) error {
+	vendored := map[string]string{}
+	return bp.WalkModules(func(m *Module) error {
+		if !sourcereader.IsRegistryPath(m.Source) && !sourcereader.IsRemotePath(m.Source) {
+			return nil
+		}
+		modPath := m.infoSource()
+		dir, ok := vendored[modPath]
+		if !ok {
+			dir = filepath.Join(vendorDir, offline.ModuleDirName(modPath))
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			if err := fetchModule(modPath, dir); err != nil {
+				return fmt.Errorf("failed to vendor module %s: %w", m.ID, err)
+			}
+			vendored[modPath] = dir
+		}
+		m.Source, m.Version = localSource(dir), ""
+		return nil
+	})
+}
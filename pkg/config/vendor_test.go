@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVendorModules(t *testing.T) {
+	fetched := []string{}
+	defer func(f func(string, string) error) { fetchModule = f }(fetchModule)
+	fetchModule = func(modPath string, dst string) error {
+		fetched = append(fetched, modPath)
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, "main.tf"), []byte{}, 0644)
+	}
+
+	vpc := "github.com/org/repo//modules/vpc?ref=v1"
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Name: "primary", Modules: []Module{
+		{ID: "net0", Source: registryNetwork, Version: "7.3.0"},
+		{ID: "vpc0", Source: vpc},
+		{ID: "vpc1", Source: vpc},
+		{ID: "vm", Source: "modules/compute/vm-instance"},
+		{ID: "local", Source: "./local/module"},
+	}}}}
+	dir := t.TempDir()
+	if err := bp.VendorModules(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{registryNetwork + "?version=7.3.0", vpc}
+	if diff := cmp.Diff(want, fetched); diff != "" {
+		t.Errorf("fetched modules diff (-want +got):\n%s", diff)
+	}
+	mods := bp.DeploymentGroups[0].Modules
+	for _, m := range mods[:3] {
+		if !strings.HasPrefix(m.Source, dir+"/") || m.Version != "" {
+			t.Errorf("module %s: got source %q and version %q, want a vendored source", m.ID, m.Source, m.Version)
+		}
+		if _, err := os.Stat(filepath.Join(m.Source, "main.tf")); err != nil {
+			t.Errorf("module %s: %v", m.ID, err)
+		}
+	}
+	if mods[1].Source != mods[2].Source {
+		t.Errorf("modules with the same source were vendored to %q and %q", mods[1].Source, mods[2].Source)
+	}
+	if mods[3].Source != "modules/compute/vm-instance" || mods[4].Source != "./local/module" {
+		t.Errorf("embedded and local sources must not be vendored, got %q and %q", mods[3].Source, mods[4].Source)
+	}
+}
+
+func TestLocalSource(t *testing.T) {
+	for in, want := range map[string]string{
+		"vendor/vpc":     "./vendor/vpc",
+		"./vendor/vpc/":  "./vendor/vpc",
+		"../vendor/vpc":  "../vendor/vpc",
+		"/tmp/vendor/vp": "/tmp/vendor/vp",
+	} {
+		if got := localSource(in); got != want {
+			t.Errorf("localSource(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
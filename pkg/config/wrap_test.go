@@ -0,0 +1,72 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"testing"
+
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestCheckWrapSettings(t *testing.T) {
+	type test struct {
+		name string
+		kind ModuleKind
+		wrap map[string]string
+		err  bool
+	}
+	tests := []test{
+		{"none", PackerKind, nil, false},
+		{"unknown kind", UnknownKind, map[string]string{"tags": "merge"}, false},
+		{"ok", TerraformKind, map[string]string{"tags": "merge", "disks": "flatten"}, false},
+		{"invalid name", TerraformKind, map[string]string{"tags": "merge("}, true},
+		{"packer", PackerKind, map[string]string{"tags": "merge"}, true},
+		{"helm", HelmKind, map[string]string{"values": "yamlencode"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			m := Module{ID: "vm", Kind: tc.kind, WrapSettings: tc.wrap}
+			bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Modules: []Module{m}}}}
+			err := checkWrapSettings(bp)
+			if tc.err != (err != nil) {
+				t.Errorf("got unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyMetadataWrapSettings(t *testing.T) {
+	mod := Module{ID: "vm", Source: "./modules/wrapped-vm", Kind: TerraformKind,
+		WrapSettings: map[string]string{"labels": "merge"},
+		Settings: NewDict(map[string]cty.Value{
+			"labels":   cty.EmptyObjectVal,
+			"metadata": cty.EmptyObjectVal,
+			"disks":    cty.NullVal(cty.DynamicPseudoType),
+		})}
+	setTestModuleInfo(mod, modulereader.ModuleInfo{
+		WrapSettings: map[string]string{"labels": "jsonencode", "metadata": "jsonencode", "disks": "flatten"},
+	})
+	bp := Blueprint{DeploymentGroups: []DeploymentGroup{{Modules: []Module{mod}}}}
+
+	bp.applyMetadataWrapSettings()
+	// settings wrapped in the blueprint, unset and null settings are left as is
+	want := map[string]string{"labels": "merge", "metadata": "jsonencode"}
+	if diff := cmp.Diff(want, bp.DeploymentGroups[0].Modules[0].WrapSettings); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
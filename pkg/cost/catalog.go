@@ -0,0 +1,232 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cost
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/compute/v1"
+)
+
+// IDs of the services of the Cloud Billing Catalog
+const (
+	computeService   = "services/6F81-5844-456A"
+	filestoreService = "services/D97E-AB26-5D95"
+)
+
+// descriptions of the SKUs of the capacity of disk types and Filestore tiers
+var (
+	diskSkus = map[string]string{
+		"pd-standard": "Storage PD Capacity",
+		"pd-balanced": "Balanced PD Capacity",
+		"pd-ssd":      "SSD backed PD Capacity",
+		"pd-extreme":  "Extreme PD Capacity",
+	}
+	filestoreSkus = map[string]string{
+		"BASIC_HDD":      "Filestore Capacity Basic HDD",
+		"STANDARD":       "Filestore Capacity Basic HDD",
+		"BASIC_SSD":      "Filestore Capacity Basic SSD",
+		"PREMIUM":        "Filestore Capacity Basic SSD",
+		"HIGH_SCALE_SSD": "Filestore Capacity High Scale",
+		"ENTERPRISE":     "Filestore Capacity Enterprise",
+	}
+	// machine families whose SKUs are not named after the family
+	familySkus = map[string]string{
+		"C2": "Compute optimized",
+		"M1": "Memory-optimized",
+		"M2": "Memory-optimized",
+	}
+)
+
+// shape is the number of vCPUs and GB of memory of a machine type
+type shape struct {
+	cpus     float64
+	memoryGB float64
+}
+
+// CatalogPricer prices resources with the on-demand prices, in USD, of the
+// Cloud Billing Catalog
+type CatalogPricer struct {
+	listSkus     func(service string) ([]*cloudbilling.Sku, error)
+	machineShape func(r Resource) (shape, error)
+	skus         map[string][]*cloudbilling.Sku
+}
+
+// NewCatalogPricer returns a pricer that reads the catalog with application
+// default credentials, and the vCPUs and memory of machine types from the
+// Compute Engine API in the project
+func NewCatalogPricer(projectID string) (*CatalogPricer, error) {
+	ctx := context.Background()
+	bs, err := cloudbilling.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := compute.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	listSkus := func(service string) ([]*cloudbilling.Sku, error) {
+		skus := []*cloudbilling.Sku{}
+		err := bs.Services.Skus.List(service).CurrencyCode("USD").Pages(ctx, func(r *cloudbilling.ListSkusResponse) error {
+			skus = append(skus, r.Skus...)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the SKUs of %s: %w", service, err)
+		}
+		return skus, nil
+	}
+	machineShape := func(r Resource) (shape, error) {
+		var mt *compute.MachineType
+		var err error
+		if r.Zone != "" {
+			mt, err = cs.MachineTypes.Get(projectID, r.Zone, r.Type).Do()
+			if err != nil {
+				return shape{}, err
+			}
+		} else {
+			err = cs.MachineTypes.AggregatedList(projectID).Filter("name = "+r.Type).Pages(ctx,
+				func(l *compute.MachineTypeAggregatedList) error {
+					for _, sl := range l.Items {
+						if mt == nil && len(sl.MachineTypes) > 0 {
+							mt = sl.MachineTypes[0]
+						}
+					}
+					return nil
+				})
+			if err != nil {
+				return shape{}, err
+			}
+			if mt == nil {
+				return shape{}, fmt.Errorf("machine type %s does not exist", r.Type)
+			}
+		}
+		return shape{cpus: float64(mt.GuestCpus), memoryGB: float64(mt.MemoryMb) / 1024}, nil
+	}
+	return &CatalogPricer{listSkus: listSkus, machineShape: machineShape, skus: map[string][]*cloudbilling.Sku{}}, nil
+}
+
+// HourlyPrice returns the hourly on-demand price of a unit of the resource
+func (p *CatalogPricer) HourlyPrice(r Resource) (float64, error) {
+	if r.Region == "" {
+		return 0, fmt.Errorf("the region or zone of module %s is not set", r.Module)
+	}
+	switch r.Kind {
+	case Instance:
+		return p.instancePrice(r)
+	case Accelerator:
+		model := strings.TrimPrefix(strings.TrimPrefix(r.Type, "nvidia-tesla-"), "nvidia-")
+		model = strings.ToUpper(strings.ReplaceAll(model, "-", " "))
+		return p.price(computeService, r.Region, func(s *cloudbilling.Sku) bool {
+			return s.Category.ResourceGroup == "GPU" && strings.Contains(s.Description, " "+model+" GPU")
+		})
+	case Disk:
+		desc, ok := diskSkus[r.Type]
+		if !ok {
+			return 0, fmt.Errorf("unknown disk type %s", r.Type)
+		}
+		return p.price(computeService, r.Region, descriptionPrefix(desc))
+	case Filestore:
+		desc, ok := filestoreSkus[r.Type]
+		if !ok {
+			return 0, fmt.Errorf("unknown Filestore tier %s", r.Type)
+		}
+		return p.price(filestoreService, r.Region, descriptionPrefix(desc))
+	default:
+		return 0, fmt.Errorf("unknown kind of resource %s", r.Kind)
+	}
+}
+
+// instancePrice returns the price of the vCPUs and memory of an instance
+func (p *CatalogPricer) instancePrice(r Resource) (float64, error) {
+	sh, err := p.machineShape(r)
+	if err != nil {
+		return 0, err
+	}
+	family := strings.ToUpper(strings.SplitN(r.Type, "-", 2)[0])
+	prefix := family + " "
+	if f, ok := familySkus[family]; ok {
+		prefix = f + " "
+	}
+	component := func(name string) func(*cloudbilling.Sku) bool {
+		return func(s *cloudbilling.Sku) bool {
+			return strings.HasPrefix(s.Description, prefix) &&
+				strings.Contains(s.Description, " "+name+" ") &&
+				!strings.Contains(s.Description, "Custom") &&
+				!strings.Contains(s.Description, "Sole Tenancy")
+		}
+	}
+	core, err := p.price(computeService, r.Region, component("Core"))
+	if err != nil {
+		return 0, err
+	}
+	ram, err := p.price(computeService, r.Region, component("Ram"))
+	if err != nil {
+		return 0, err
+	}
+	return sh.cpus*core + sh.memoryGB*ram, nil
+}
+
+func descriptionPrefix(desc string) func(*cloudbilling.Sku) bool {
+	return func(s *cloudbilling.Sku) bool {
+		return strings.HasPrefix(s.Description, desc) && !strings.Contains(s.Description, "Regional")
+	}
+}
+
+// price returns the hourly price of the first on-demand SKU of the service in
+// the region that matches
+func (p *CatalogPricer) price(service string, region string, match func(*cloudbilling.Sku) bool) (float64, error) {
+	skus, ok := p.skus[service]
+	if !ok {
+		var err error
+		if skus, err = p.listSkus(service); err != nil {
+			return 0, err
+		}
+		p.skus[service] = skus
+	}
+	for _, s := range skus {
+		if s.Category == nil || s.Category.UsageType != "OnDemand" || !slices.Contains(s.ServiceRegions, region) || !match(s) {
+			continue
+		}
+		return hourlyPrice(s)
+	}
+	return 0, fmt.Errorf("no price found in region %s", region)
+}
+
+// hourlyPrice returns the price of the highest tier of the SKU, per hour
+func hourlyPrice(s *cloudbilling.Sku) (float64, error) {
+	if len(s.PricingInfo) == 0 || s.PricingInfo[0].PricingExpression == nil {
+		return 0, fmt.Errorf("SKU %s has no price", s.Description)
+	}
+	pe := s.PricingInfo[0].PricingExpression
+	if len(pe.TieredRates) == 0 || pe.TieredRates[len(pe.TieredRates)-1].UnitPrice == nil {
+		return 0, fmt.Errorf("SKU %s has no price", s.Description)
+	}
+	m := pe.TieredRates[len(pe.TieredRates)-1].UnitPrice
+	price := float64(m.Units) + float64(m.Nanos)/1e9
+	switch pe.UsageUnit {
+	case "h", "GiBy.h":
+		return price, nil
+	case "GiBy.mo":
+		return price / HoursPerMonth, nil
+	default:
+		return 0, fmt.Errorf("SKU %s is priced per %s", s.Description, pe.UsageUnit)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cost
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"google.golang.org/api/cloudbilling/v1"
+)
+
+func sku(desc string, group string, unit string, units int64, nanos int64, regions ...string) *cloudbilling.Sku {
+	return &cloudbilling.Sku{
+		Description:    desc,
+		Category:       &cloudbilling.Category{ResourceGroup: group, UsageType: "OnDemand"},
+		ServiceRegions: regions,
+		PricingInfo: []*cloudbilling.PricingInfo{{PricingExpression: &cloudbilling.PricingExpression{
+			UsageUnit: unit,
+			TieredRates: []*cloudbilling.TierRate{
+				{UnitPrice: &cloudbilling.Money{}},
+				{UnitPrice: &cloudbilling.Money{Units: units, Nanos: nanos}},
+			},
+		}}},
+	}
+}
+
+func testPricer() *CatalogPricer {
+	skus := map[string][]*cloudbilling.Sku{
+		computeService: {
+			sku("N2 Custom Instance Core running in Americas", "CPU", "h", 0, 40000000, "us-central1"),
+			sku("N2 Instance Core running in EMEA", "CPU", "h", 0, 35000000, "europe-west4"),
+			sku("N2 Instance Core running in Americas", "CPU", "h", 0, 30000000, "us-central1"),
+			sku("N2 Instance Ram running in Americas", "RAM", "h", 0, 4000000, "us-central1"),
+			sku("Compute optimized Core running in Americas", "CPU", "h", 0, 33000000, "us-central1"),
+			sku("Compute optimized Ram running in Americas", "RAM", "h", 0, 4500000, "us-central1"),
+			sku("Nvidia Tesla A100 80GB GPU running in Americas", "GPU", "h", 3, 900000000, "us-central1"),
+			sku("Nvidia Tesla A100 GPU running in Americas", "GPU", "h", 2, 900000000, "us-central1"),
+			sku("Regional SSD backed PD Capacity in Iowa", "SSD", "GiBy.mo", 0, 340000000, "us-central1"),
+			sku("SSD backed PD Capacity in Iowa", "SSD", "GiBy.mo", 0, 170000000, "us-central1"),
+		},
+		filestoreService: {
+			sku("Filestore Capacity Basic SSD (Premium) in Iowa", "", "GiBy.h", 0, 410000, "us-central1"),
+		},
+	}
+	return &CatalogPricer{
+		listSkus: func(service string) ([]*cloudbilling.Sku, error) {
+			return skus[service], nil
+		},
+		machineShape: func(r Resource) (shape, error) {
+			switch r.Type {
+			case "n2-standard-4":
+				return shape{cpus: 4, memoryGB: 16}, nil
+			case "c2-standard-60":
+				return shape{cpus: 60, memoryGB: 240}, nil
+			default:
+				return shape{}, errors.New("machine type does not exist")
+			}
+		},
+		skus: map[string][]*cloudbilling.Sku{},
+	}
+}
+
+func TestCatalogPricer(t *testing.T) {
+	p := testPricer()
+	for _, tc := range []struct {
+		r    Resource
+		want float64
+		err  bool
+	}{
+		{Resource{Kind: Instance, Type: "n2-standard-4", Region: "us-central1"}, 4*0.03 + 16*0.004, false},
+		{Resource{Kind: Instance, Type: "c2-standard-60", Region: "us-central1"}, 60*0.033 + 240*0.0045, false},
+		{Resource{Kind: Instance, Type: "n2-standard-4", Region: "europe-west4"}, 0, true}, // no RAM price
+		{Resource{Kind: Instance, Type: "n2-standard-4"}, 0, true},                         // no region
+		{Resource{Kind: Instance, Type: "x9-huge", Region: "us-central1"}, 0, true},
+		{Resource{Kind: Accelerator, Type: "nvidia-tesla-a100", Region: "us-central1"}, 2.9, false},
+		{Resource{Kind: Accelerator, Type: "nvidia-a100-80gb", Region: "us-central1"}, 3.9, false},
+		{Resource{Kind: Accelerator, Type: "nvidia-l4", Region: "us-central1"}, 0, true},
+		{Resource{Kind: Disk, Type: "pd-ssd", Region: "us-central1"}, 0.17 / HoursPerMonth, false},
+		{Resource{Kind: Disk, Type: "pd-fancy", Region: "us-central1"}, 0, true},
+		{Resource{Kind: Filestore, Type: "BASIC_SSD", Region: "us-central1"}, 0.00041, false},
+	} {
+		t.Run(tc.r.Type+" "+tc.r.Region, func(t *testing.T) {
+			got, err := p.HourlyPrice(tc.r)
+			if (err != nil) != tc.err {
+				t.Fatalf("got error %v, want error %t", err, tc.err)
+			}
+			if math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("got %f, want %f", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCatalogPricerListsSkusOnce(t *testing.T) {
+	p := testPricer()
+	list := p.listSkus
+	calls := 0
+	p.listSkus = func(service string) ([]*cloudbilling.Sku, error) {
+		calls++
+		return list(service)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := p.HourlyPrice(Resource{Kind: Disk, Type: "pd-ssd", Region: "us-central1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls to list SKUs, want 1", calls)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cost estimates the cost of the compute and storage resources of a
+// deployment from the prices of the Cloud Billing Catalog
+package cost
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"golang.org/x/exp/slices"
+)
+
+// HoursPerMonth is the number of hours monthly costs are estimated for
+const HoursPerMonth = 730
+
+// Kinds of resources
+const (
+	Instance    = "instance"
+	Accelerator = "gpu"
+	Disk        = "disk"
+	Filestore   = "filestore"
+)
+
+// Resource is a billable resource of a module
+type Resource struct {
+	Group  string
+	Module string
+	// Kind is one of Instance, Accelerator, Disk and Filestore
+	Kind string
+	// Type is the machine type, accelerator type, disk type or Filestore tier
+	Type   string
+	Region string
+	Zone   string
+	// Quantity is the number of instances or accelerators, or the size of
+	// disks and Filestore instances in GB
+	Quantity float64
+}
+
+// Pricer returns the hourly price of a unit of a resource: an instance, an
+// accelerator or a GB of storage
+type Pricer interface {
+	HourlyPrice(r Resource) (float64, error)
+}
+
+// Item is the estimated cost of a resource
+type Item struct {
+	Resource
+	Hourly float64
+	// Err is set if the resource could not be priced
+	Err error
+}
+
+// Monthly returns the monthly cost of the item
+func (i Item) Monthly() float64 {
+	return i.Hourly * HoursPerMonth
+}
+
+// Estimate is the estimated cost of a deployment
+type Estimate struct {
+	Items []Item
+}
+
+// Hourly returns the total hourly cost of the items of the group, or of all
+// items if group is empty
+func (e Estimate) Hourly(group string) float64 {
+	total := 0.0
+	for _, i := range e.Items {
+		if group == "" || i.Group == group {
+			total += i.Hourly
+		}
+	}
+	return total
+}
+
+// Monthly returns the total monthly cost of the items of the group, or of all
+// items if group is empty
+func (e Estimate) Monthly(group string) float64 {
+	return e.Hourly(group) * HoursPerMonth
+}
+
+// Unpriced returns the items that could not be priced
+func (e Estimate) Unpriced() []Item {
+	res := []Item{}
+	for _, i := range e.Items {
+		if i.Err != nil {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// Price estimates the cost of the resources; resources that cannot be priced
+// are recorded with the error and cost nothing
+func Price(resources []Resource, p Pricer) Estimate {
+	e := Estimate{Items: []Item{}}
+	for _, r := range resources {
+		price, err := p.HourlyPrice(r)
+		e.Items = append(e.Items, Item{Resource: r, Hourly: price * r.Quantity, Err: err})
+	}
+	return e
+}
+
+// WriteSummary writes the estimated cost of each deployment group, in the
+// order of the items, and of the deployment
+func WriteSummary(w io.Writer, deploymentName string, e Estimate) {
+	groups := []string{}
+	for _, i := range e.Items {
+		if !slices.Contains(groups, i.Group) {
+			groups = append(groups, i.Group)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, g := range groups {
+		items := []Item{}
+		for _, i := range e.Items {
+			if i.Group == g {
+				items = append(items, i)
+			}
+		}
+		sort.SliceStable(items, func(a, b int) bool { return items[a].Module < items[b].Module })
+		fmt.Fprintf(tw, "Group %s\n", g)
+		fmt.Fprintln(tw, "  MODULE\tRESOURCE\tQUANTITY\tHOURLY\tMONTHLY")
+		for _, i := range items {
+			hourly, monthly := dollars(i.Hourly), dollars(i.Monthly())
+			if i.Err != nil {
+				hourly, monthly = "unknown", "unknown"
+			}
+			fmt.Fprintf(tw, "  %s\t%s %s\t%s\t%s\t%s\n", i.Module, i.Type, i.Kind, quantity(i.Resource), hourly, monthly)
+		}
+		fmt.Fprintf(tw, "  total\t\t\t%s\t%s\n", dollars(e.Hourly(g)), dollars(e.Monthly(g)))
+	}
+	tw.Flush()
+
+	for _, i := range e.Unpriced() {
+		fmt.Fprintf(w, "warning: %s %s of module %s is not included: %v\n", i.Type, i.Kind, i.Module, i.Err)
+	}
+	fmt.Fprintf(w, "Estimated cost of deployment %s: %s per hour, %s per month\n",
+		deploymentName, dollars(e.Hourly("")), dollars(e.Monthly("")))
+}
+
+func dollars(f float64) string {
+	return fmt.Sprintf("$%.2f", f)
+}
+
+func quantity(r Resource) string {
+	if r.Kind == Disk || r.Kind == Filestore {
+		return fmt.Sprintf("%g GB", r.Quantity)
+	}
+	return fmt.Sprintf("%g", r.Quantity)
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cost
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakePricer map[string]float64
+
+func (p fakePricer) HourlyPrice(r Resource) (float64, error) {
+	price, ok := p[r.Type]
+	if !ok {
+		return 0, errors.New("no price")
+	}
+	return price, nil
+}
+
+func TestPriceAndWriteSummary(t *testing.T) {
+	resources := []Resource{
+		{Group: "primary", Module: "homefs", Kind: Filestore, Type: "BASIC_SSD", Quantity: 2560},
+		{Group: "compute", Module: "login", Kind: Instance, Type: "n2-standard-4", Quantity: 1},
+		{Group: "compute", Module: "gpu_nodes", Kind: Instance, Type: "a2-highgpu-2g", Quantity: 4},
+		{Group: "compute", Module: "gpu_nodes", Kind: Accelerator, Type: "nvidia-tesla-a100", Quantity: 8},
+		{Group: "compute", Module: "gpu_nodes", Kind: Disk, Type: "pd-ssd", Quantity: 400},
+	}
+	p := fakePricer{"BASIC_SSD": 0.0004, "a2-highgpu-2g": 1, "nvidia-tesla-a100": 2, "pd-ssd": 0.0002}
+	e := Price(resources, p)
+
+	if got, want := e.Hourly("compute"), 4*1+8*2+400*0.0002; got != want {
+		t.Errorf("got hourly cost %f of group compute, want %f", got, want)
+	}
+	if got, want := e.Monthly(""), (2560*0.0004+4*1+8*2+400*0.0002)*HoursPerMonth; got != want {
+		t.Errorf("got monthly cost %f, want %f", got, want)
+	}
+	unpriced := e.Unpriced()
+	if len(unpriced) != 1 || unpriced[0].Module != "login" {
+		t.Errorf("got unpriced items %v, want the login instance", unpriced)
+	}
+
+	var buf bytes.Buffer
+	WriteSummary(&buf, "dep", e)
+	want := `Group primary
+  MODULE  RESOURCE             QUANTITY  HOURLY  MONTHLY
+  homefs  BASIC_SSD filestore  2560 GB   $1.02   $747.52
+  total                                  $1.02   $747.52
+Group compute
+  MODULE     RESOURCE                QUANTITY  HOURLY   MONTHLY
+  gpu_nodes  a2-highgpu-2g instance  4         $4.00    $2920.00
+  gpu_nodes  nvidia-tesla-a100 gpu   8         $16.00   $11680.00
+  gpu_nodes  pd-ssd disk             400 GB    $0.08    $58.40
+  login      n2-standard-4 instance  1         unknown  unknown
+  total                                        $20.08   $14658.40
+warning: n2-standard-4 instance of module login is not included: no price
+Estimated cost of deployment dep: $21.10 per hour, $15405.92 per month
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
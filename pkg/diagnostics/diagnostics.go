@@ -0,0 +1,250 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diagnostics writes the results of the validation of blueprints in
+// formats understood by CI pipelines and code scanning tools
+package diagnostics
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Severities of diagnostics
+const (
+	Error   = "error"
+	Warning = "warning"
+	// Pass is the severity of the results of rules that found no problem,
+	// which are only written by formats that list passed checks
+	Pass = "pass"
+)
+
+// Formats of diagnostics
+const (
+	JSON  = "json"
+	JUnit = "junit"
+	SARIF = "sarif"
+)
+
+// Formats returns the supported formats
+func Formats() []string {
+	return []string{JSON, JUnit, SARIF}
+}
+
+// Diagnostic is a result of a validator or policy
+type Diagnostic struct {
+	// RuleID identifies the check, e.g. the name of the validator
+	RuleID   string `json:"rule_id"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// File, Line and Column locate the diagnostic in the blueprint; Line and
+	// Column are 1-based, and 0 if unknown
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// Write writes the diagnostics in the format; version is the version of
+// ghpc recorded by formats that name the tool
+func Write(w io.Writer, format string, diags []Diagnostic, version string) error {
+	switch format {
+	case JSON:
+		return writeJSON(w, diags)
+	case JUnit:
+		return writeJUnit(w, diags)
+	case SARIF:
+		return writeSARIF(w, diags, version)
+	default:
+		return fmt.Errorf("unknown diagnostics format %q, must be one of %s", format, strings.Join(Formats(), ", "))
+	}
+}
+
+// findings returns the diagnostics that are not passed checks
+func findings(diags []Diagnostic) []Diagnostic {
+	res := []Diagnostic{}
+	for _, d := range diags {
+		if d.Severity != Pass {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+func writeJSON(w io.Writer, diags []Diagnostic) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Diagnostics []Diagnostic `json:"diagnostics"`
+	}{findings(diags)})
+}
+
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// location returns the location of the diagnostic as file:line:column
+func (d Diagnostic) location() string {
+	if d.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+	}
+	return d.File
+}
+
+// writeJUnit writes a test case per diagnostic, failed for errors; warnings
+// are recorded in the output of passed test cases
+func writeJUnit(w io.Writer, diags []Diagnostic) error {
+	suite := junitTestSuite{Name: "ghpc", TestCases: []junitTestCase{}}
+	for _, d := range diags {
+		tc := junitTestCase{Name: d.RuleID, ClassName: d.File}
+		text := d.Message
+		if loc := d.location(); loc != "" {
+			text = loc + ": " + text
+		}
+		switch d.Severity {
+		case Error:
+			tc.Failure = &junitFailure{Type: Error, Message: d.Message, Text: text}
+			suite.Failures++
+		case Warning:
+			tc.SystemOut = "warning: " + text
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	suite.Tests = len(suite.TestCases)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+const sarifSchema = "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json"
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver sarifDriver `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+func writeSARIF(w io.Writer, diags []Diagnostic, version string) error {
+	var run sarifRun
+	run.Tool.Driver = sarifDriver{
+		Name:           "ghpc",
+		Version:        version,
+		InformationURI: "https://github.com/GoogleCloudPlatform/hpc-toolkit",
+		Rules:          []sarifRule{},
+	}
+	run.Results = []sarifResult{}
+	rules := map[string]bool{}
+	for _, d := range diags {
+		// rules of passed checks are listed, so that their results are closed
+		// by code scanning tools
+		if !rules[d.RuleID] {
+			rules[d.RuleID] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.RuleID})
+		}
+		if d.Severity == Pass {
+			continue
+		}
+		r := sarifResult{RuleID: d.RuleID, Level: d.Severity, Message: sarifMessage{Text: d.Message}}
+		if d.File != "" {
+			loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: d.File}}}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+			r.Locations = []sarifLocation{loc}
+		}
+		run.Results = append(run.Results, r)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{Version: "2.1.0", Schema: sarifSchema, Runs: []sarifRun{run}})
+}
@@ -0,0 +1,112 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testDiags = []Diagnostic{
+	{RuleID: "test_project_exists", Severity: Pass, File: "bp.yaml", Line: 3, Column: 3},
+	{RuleID: "test_region_exists", Severity: Error, Message: "region not found", File: "bp.yaml", Line: 5, Column: 3},
+	{RuleID: "data.ghpc.labels.warning", Severity: Warning, Message: "missing label", File: "bp.yaml"},
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, JSON, testDiags, "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Diagnostics []Diagnostic }
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(testDiags[1:], got.Diagnostics); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, JUnit, testDiags, "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	var got junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := junitTestSuite{Name: "ghpc", Tests: 3, Failures: 1, TestCases: []junitTestCase{
+		{Name: "test_project_exists", ClassName: "bp.yaml"},
+		{Name: "test_region_exists", ClassName: "bp.yaml", Failure: &junitFailure{
+			Type: Error, Message: "region not found", Text: "bp.yaml:5:3: region not found"}},
+		{Name: "data.ghpc.labels.warning", ClassName: "bp.yaml", SystemOut: "warning: bp.yaml: missing label"},
+	}}
+	if len(got.Suites) != 1 {
+		t.Fatalf("got %d test suites, want 1", len(got.Suites))
+	}
+	if diff := cmp.Diff(want, got.Suites[0]); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, SARIF, testDiags, "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	var got sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "2.1.0" || len(got.Runs) != 1 {
+		t.Fatalf("got version %q with %d runs, want 2.1.0 with 1 run", got.Version, len(got.Runs))
+	}
+	run := got.Runs[0]
+	wantDriver := sarifDriver{
+		Name:           "ghpc",
+		Version:        "v1.0.0",
+		InformationURI: "https://github.com/GoogleCloudPlatform/hpc-toolkit",
+		Rules:          []sarifRule{{"test_project_exists"}, {"test_region_exists"}, {"data.ghpc.labels.warning"}},
+	}
+	if diff := cmp.Diff(wantDriver, run.Tool.Driver); diff != "" {
+		t.Errorf("driver diff (-want +got):\n%s", diff)
+	}
+	loc := func(line, col int) []sarifLocation {
+		l := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: "bp.yaml"}}}
+		if line > 0 {
+			l.PhysicalLocation.Region = &sarifRegion{StartLine: line, StartColumn: col}
+		}
+		return []sarifLocation{l}
+	}
+	wantResults := []sarifResult{
+		{RuleID: "test_region_exists", Level: Error, Message: sarifMessage{"region not found"}, Locations: loc(5, 3)},
+		{RuleID: "data.ghpc.labels.warning", Level: Warning, Message: sarifMessage{"missing label"}, Locations: loc(0, 0)},
+	}
+	if diff := cmp.Diff(wantResults, run.Results); diff != "" {
+		t.Errorf("results diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "yaml", testDiags, ""); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
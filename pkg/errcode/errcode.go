@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package errcode defines the catalog of machine-readable error codes and the
+// stable process exit codes of ghpc
+package errcode
+
+import (
+	"errors"
+)
+
+// Code is a machine-readable identifier of a class of failure
+type Code string
+
+// The catalog of error codes. Codes and their exit codes are stable and must
+// not be changed or reused; add new codes to the end of the catalog.
+const (
+	Unknown            Code = "UNKNOWN"
+	ConfigError        Code = "CONFIG_ERROR"
+	ValidationFailure  Code = "VALIDATION_FAILURE"
+	SourceFetchFailure Code = "SOURCE_FETCH_FAILURE"
+	WriteFailure       Code = "WRITE_FAILURE"
+	DeployFailure      Code = "DEPLOY_FAILURE"
+	PartialDeploy      Code = "PARTIAL_DEPLOY"
+	LockfileMismatch   Code = "LOCKFILE_MISMATCH"
+	IntegrityFailure   Code = "INTEGRITY_FAILURE"
+	HealthCheckFailure Code = "HEALTH_CHECK_FAILURE"
+	CostLimitExceeded  Code = "COST_LIMIT_EXCEEDED"
+	DriftDetected      Code = "DRIFT_DETECTED"
+)
+
+var exitCodes = map[Code]int{
+	Unknown:            1,
+	ConfigError:        2,
+	ValidationFailure:  3,
+	SourceFetchFailure: 4,
+	WriteFailure:       5,
+	DeployFailure:      6,
+	PartialDeploy:      7,
+	LockfileMismatch:   8,
+	IntegrityFailure:   9,
+	HealthCheckFailure: 10,
+	CostLimitExceeded:  11,
+	DriftDetected:      12,
+}
+
+// Codes returns the catalog of codes ordered by exit code
+func Codes() []Code {
+	return []Code{
+		Unknown, ConfigError, ValidationFailure, SourceFetchFailure,
+		WriteFailure, DeployFailure, PartialDeploy, LockfileMismatch,
+		IntegrityFailure, HealthCheckFailure, CostLimitExceeded, DriftDetected,
+	}
+}
+
+// ExitCode returns the process exit code for the code
+func (c Code) ExitCode() int {
+	if e, ok := exitCodes[c]; ok {
+		return e
+	}
+	return exitCodes[Unknown]
+}
+
+// FromExitCode returns the code of a process exit code of ghpc, e.g. of ghpc
+// run remotely; Unknown if it is not the exit code of a code
+func FromExitCode(exitCode int) Code {
+	for c, e := range exitCodes {
+		if e == exitCode {
+			return c
+		}
+	}
+	return Unknown
+}
+
+// Error attaches a Code to an error
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New attaches the code to err. Returns nil if err is nil; errors that
+// already carry a code keep it, so the innermost (most specific) code wins.
+func New(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ce *Error
+	if errors.As(err, &ce) {
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// Of returns the code attached to err, Unknown if none is attached
+func Of(err error) Code {
+	var ce *Error
+	if errors.As(err, &ce) {
+		return ce.Code
+	}
+	return Unknown
+}
+
+// ExitCode returns the process exit code for err, 0 if err is nil
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return Of(err).ExitCode()
+}
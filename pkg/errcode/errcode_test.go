@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	if New(ConfigError, nil) != nil {
+		t.Error("expected nil for nil error")
+	}
+
+	base := errors.New("bad")
+	err := New(ConfigError, base)
+	if !errors.Is(err, base) {
+		t.Error("expected wrapped error to unwrap to base")
+	}
+	if err.Error() != "bad" {
+		t.Errorf("expected message to be preserved, got %q", err.Error())
+	}
+
+	// innermost code wins, also through fmt wrapping
+	outer := New(WriteFailure, fmt.Errorf("while writing: %w", err))
+	if got := Of(outer); got != ConfigError {
+		t.Errorf("got %s, want %s", got, ConfigError)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if got := ExitCode(nil); got != 0 {
+		t.Errorf("got %d, want 0", got)
+	}
+	if got := ExitCode(errors.New("bad")); got != 1 {
+		t.Errorf("got %d, want 1", got)
+	}
+	if got := ExitCode(New(PartialDeploy, errors.New("bad"))); got != 7 {
+		t.Errorf("got %d, want 7", got)
+	}
+
+	seen := map[int]Code{}
+	for _, c := range Codes() {
+		if o, ok := seen[c.ExitCode()]; ok {
+			t.Errorf("codes %s and %s share exit code %d", c, o, c.ExitCode())
+		}
+		seen[c.ExitCode()] = c
+	}
+}
+
+func TestFromExitCode(t *testing.T) {
+	for _, c := range Codes() {
+		if got := FromExitCode(c.ExitCode()); got != c {
+			t.Errorf("got %s for exit code %d, want %s", got, c.ExitCode(), c)
+		}
+	}
+	if got := FromExitCode(42); got != Unknown {
+		t.Errorf("got %s, want %s", got, Unknown)
+	}
+}
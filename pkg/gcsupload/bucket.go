@@ -0,0 +1,130 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsupload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2/google"
+)
+
+// Bucket is the configuration of a bucket created by EnsureBucket
+type Bucket struct {
+	Name     string
+	Project  string
+	Location string
+	Labels   map[string]string
+	// Versioning keeps the noncurrent versions of objects
+	Versioning bool
+	// PreventPublicAccess enforces public access prevention, so that the
+	// objects can never be made public
+	PreventPublicAccess bool
+}
+
+type bucketResource struct {
+	Name             string            `json:"name"`
+	Location         string            `json:"location"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	IamConfiguration struct {
+		UniformBucketLevelAccess struct {
+			Enabled bool `json:"enabled"`
+		} `json:"uniformBucketLevelAccess"`
+		PublicAccessPrevention string `json:"publicAccessPrevention,omitempty"`
+	} `json:"iamConfiguration"`
+	Versioning *bucketVersioning `json:"versioning,omitempty"`
+}
+
+type bucketVersioning struct {
+	Enabled bool `json:"enabled"`
+}
+
+// DefaultClient returns an HTTP client that authenticates with the
+// application default credentials
+func DefaultClient(ctx context.Context) (*http.Client, error) {
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_write")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Storage client: %w", err)
+	}
+	return client, nil
+}
+
+// bucketExists returns whether the bucket exists
+func bucketExists(ctx context.Context, client *http.Client, name string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/storage/v1/b/%s?fields=name", storageURL, url.PathEscape(name)), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError(resp, "failed to get bucket gs://%s", name)
+	}
+}
+
+// EnsureBucket creates the bucket, with uniform bucket-level access, if it
+// does not exist, and returns whether it was created. Existing buckets are
+// left unchanged.
+func EnsureBucket(ctx context.Context, client *http.Client, b Bucket) (bool, error) {
+	exists, err := bucketExists(ctx, client, b.Name)
+	if err != nil || exists {
+		return false, err
+	}
+
+	r := bucketResource{Name: b.Name, Location: b.Location, Labels: b.Labels}
+	r.IamConfiguration.UniformBucketLevelAccess.Enabled = true
+	if b.PreventPublicAccess {
+		r.IamConfiguration.PublicAccessPrevention = "enforced"
+	}
+	if b.Versioning {
+		r.Versioning = &bucketVersioning{Enabled: true}
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/storage/v1/b?project=%s", storageURL, url.QueryEscape(b.Project)), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusConflict:
+		return false, responseError(resp, "failed to create bucket gs://%s: the name is taken by a bucket of another project", b.Name)
+	default:
+		return false, responseError(resp, "failed to create bucket gs://%s in project %s", b.Name, b.Project)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsupload
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEnsureBucket(t *testing.T) {
+	buckets := map[string]bucketResource{"existing": {Name: "existing"}}
+	projects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if _, ok := buckets[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/")]; !ok {
+				http.NotFound(w, r)
+			}
+		case http.MethodPost:
+			var b bucketResource
+			json.NewDecoder(r.Body).Decode(&b)
+			if _, ok := buckets[b.Name]; ok {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			buckets[b.Name] = b
+			projects[b.Name] = r.URL.Query().Get("project")
+		}
+	}))
+	defer srv.Close()
+	u := storageURL
+	defer func() { storageURL = u }()
+	storageURL = srv.URL
+	ctx := context.Background()
+
+	created, err := EnsureBucket(ctx, srv.Client(), Bucket{Name: "existing", Project: "test-project"})
+	if err != nil || created {
+		t.Errorf("got (%v, %v) for an existing bucket, want (false, nil)", created, err)
+	}
+
+	b := Bucket{
+		Name: "state", Project: "test-project", Location: "us-central1",
+		Labels: map[string]string{"ghpc_deployment": "golden"}, Versioning: true, PreventPublicAccess: true,
+	}
+	created, err = EnsureBucket(ctx, srv.Client(), b)
+	if err != nil || !created {
+		t.Fatalf("got (%v, %v), want (true, nil)", created, err)
+	}
+	got := buckets["state"]
+	if projects["state"] != "test-project" || got.Location != "us-central1" || got.Labels["ghpc_deployment"] != "golden" {
+		t.Errorf("bucket created with unexpected settings: %+v in project %q", got, projects["state"])
+	}
+	if !got.IamConfiguration.UniformBucketLevelAccess.Enabled {
+		t.Error("expected uniform bucket-level access")
+	}
+	if got.IamConfiguration.PublicAccessPrevention != "enforced" || got.Versioning == nil || !got.Versioning.Enabled {
+		t.Errorf("expected versioning and public access prevention, got %+v", got)
+	}
+
+	// versioning and public access prevention are optional
+	if _, err := EnsureBucket(ctx, srv.Client(), Bucket{Name: "plain", Project: "test-project"}); err != nil {
+		t.Fatal(err)
+	}
+	if p := buckets["plain"]; p.Versioning != nil || p.IamConfiguration.PublicAccessPrevention != "" {
+		t.Errorf("got unexpected settings %+v", p)
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcsupload uploads files to Cloud Storage with resumable uploads:
+// interrupted uploads are resumed by later runs, uploads are verified with
+// CRC32C checksums, files that are already uploaded are skipped and the upload
+// bandwidth can be limited. It also downloads files and creates the buckets of
+// Terraform backends.
+package gcsupload
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxRetries = 5
+
+// replaced in tests
+var (
+	storageURL   = "https://storage.googleapis.com"
+	retryBackoff = time.Second
+	// chunkSize must be a multiple of 256 KiB
+	chunkSize = 8 << 20
+)
+
+var rangeExp = regexp.MustCompile(`^bytes=0-(\d+)$`)
+
+// Status is the outcome of an upload
+type Status string
+
+// Outcomes of an upload
+const (
+	Uploaded  Status = "uploaded"
+	Resumed   Status = "resumed"
+	Unchanged Status = "unchanged"
+)
+
+// Uploader uploads files to Cloud Storage
+type Uploader struct {
+	Client *http.Client
+	// StateDir holds the sessions of interrupted uploads, so they can be
+	// resumed
+	StateDir string
+	// RateLimit is the maximum upload bandwidth in bytes per second, 0 for
+	// no limit
+	RateLimit int64
+}
+
+// NewUploader returns an uploader that authenticates with the application
+// default credentials
+func NewUploader(ctx context.Context, stateDir string, rateLimit int64) (*Uploader, error) {
+	client, err := DefaultClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Uploader{Client: client, StateDir: stateDir, RateLimit: rateLimit}, nil
+}
+
+// session is the state of an upload, saved until the upload completes
+type session struct {
+	URI         string `json:"uri"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Size        int64  `json:"size"`
+	CRC32C      string `json:"crc32c"`
+}
+
+type object struct {
+	Size   string `json:"size"`
+	CRC32C string `json:"crc32c"`
+}
+
+// fileCRC32C returns the base64 encoded CRC32C checksum of a file, as
+// reported by Cloud Storage
+func fileCRC32C(f *os.File) (string, error) {
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, h.Sum32())
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (u *Uploader) statePath(name string) string {
+	return filepath.Join(u.StateDir, name+".json")
+}
+
+func (u *Uploader) loadSession(name string) (session, bool) {
+	var s session
+	b, err := os.ReadFile(u.statePath(name))
+	if err != nil || json.Unmarshal(b, &s) != nil {
+		return s, false
+	}
+	return s, true
+}
+
+func (u *Uploader) saveSession(name string, s session) error {
+	if err := os.MkdirAll(u.StateDir, 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(u.statePath(name), b, 0644)
+}
+
+// remoteObject returns the destination object, nil if it does not exist
+func (u *Uploader) remoteObject(ctx context.Context, bucket string, name string) (*object, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/storage/v1/b/%s/o/%s?fields=size,crc32c", storageURL, bucket, url.PathEscape(name)), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var o object
+		if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+			return nil, fmt.Errorf("invalid response for gs://%s/%s: %w", bucket, name, err)
+		}
+		return &o, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, responseError(resp, "failed to get gs://%s/%s", bucket, name)
+	}
+}
+
+func responseError(resp *http.Response, format string, a ...interface{}) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s: %s: %s", fmt.Sprintf(format, a...), resp.Status, bytes.TrimSpace(body))
+}
+
+// startSession starts a resumable upload; Cloud Storage rejects the upload if
+// the checksum of the uploaded content does not match the CRC32C
+func (u *Uploader) startSession(ctx context.Context, bucket string, name string, size int64, crc string) (string, error) {
+	meta, err := json.Marshal(map[string]string{"name": name, "crc32c": crc})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable", storageURL, bucket), bytes.NewReader(meta))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, "failed to start upload to gs://%s/%s", bucket, name)
+	}
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return "", fmt.Errorf("failed to start upload to gs://%s/%s: no session URI returned", bucket, name)
+	}
+	return uri, nil
+}
+
+// errSessionExpired signals that an upload has to be restarted
+var errSessionExpired = errors.New("upload session expired")
+
+// put sends the bytes of the file from offset to the upload session and
+// returns the number of bytes Cloud Storage persisted, and whether the upload
+// is complete. A nil body queries the status of the session.
+func (u *Uploader) put(ctx context.Context, uri string, body []byte, offset int64, size int64) (int64, bool, error) {
+	var r io.Reader = bytes.NewReader(body)
+	contentRange := fmt.Sprintf("bytes */%d", size)
+	if len(body) > 0 {
+		contentRange = fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(body))-1, size)
+		r = u.throttle(r)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, r)
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Range", contentRange)
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return size, true, nil
+	case http.StatusPermanentRedirect: // 308: incomplete
+		m := rangeExp.FindStringSubmatch(resp.Header.Get("Range"))
+		if m == nil {
+			return 0, false, nil
+		}
+		last, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid range %q", resp.Header.Get("Range"))
+		}
+		return last + 1, false, nil
+	case http.StatusNotFound, http.StatusGone:
+		return 0, false, errSessionExpired
+	default:
+		err := responseError(resp, "upload failed")
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return 0, false, retryableError{err}
+		}
+		return 0, false, err
+	}
+}
+
+type retryableError struct{ error }
+
+func (e retryableError) Unwrap() error { return e.error }
+
+// ParseURL splits a gs://<bucket>/<object> URL into bucket and object
+func ParseURL(u string) (string, string, error) {
+	if !strings.HasPrefix(u, "gs://") {
+		return "", "", fmt.Errorf("%q is not a gs://<bucket>/<object> URL", u)
+	}
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(u, "gs://"), "/")
+	if bucket == "" || object == "" || strings.HasSuffix(object, "/") {
+		return "", "", fmt.Errorf("%q must name a bucket and an object: gs://<bucket>/<object>", u)
+	}
+	return bucket, object, nil
+}
+
+// Upload uploads the file at src to the gs://<bucket>/<object> URL dst. name
+// identifies the upload to resume it if it is interrupted. Files whose
+// checksum matches the destination object are not uploaded again.
+func (u *Uploader) Upload(ctx context.Context, name string, src string, dst string) (Status, error) {
+	bucket, objectName, err := ParseURL(dst)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := fi.Size()
+	crc, err := fileCRC32C(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum %s: %w", src, err)
+	}
+	matches := func(o *object) bool {
+		return o != nil && o.CRC32C == crc && o.Size == strconv.FormatInt(size, 10)
+	}
+
+	o, err := u.remoteObject(ctx, bucket, objectName)
+	if err != nil {
+		return "", err
+	}
+	if matches(o) {
+		os.Remove(u.statePath(name))
+		return Unchanged, nil
+	}
+
+	// resume the session of an interrupted upload of the same file
+	status, offset, done := Uploaded, int64(0), false
+	s, ok := u.loadSession(name)
+	if ok && s.Source == src && s.Destination == dst && s.Size == size && s.CRC32C == crc {
+		if offset, done, err = u.put(ctx, s.URI, nil, 0, size); err == nil {
+			status = Resumed
+		}
+	}
+	if status != Resumed {
+		uri, err := u.startSession(ctx, bucket, objectName, size, crc)
+		if err != nil {
+			return "", err
+		}
+		s = session{URI: uri, Source: src, Destination: dst, Size: size, CRC32C: crc}
+		if err := u.saveSession(name, s); err != nil {
+			return "", fmt.Errorf("failed to save upload state: %w", err)
+		}
+		offset = 0
+	}
+
+	if !done {
+		if err := u.send(ctx, f, s.URI, offset, size); err != nil {
+			return "", fmt.Errorf("failed to upload %s to %s: %w", src, dst, err)
+		}
+	}
+	os.Remove(u.statePath(name))
+
+	if o, err = u.remoteObject(ctx, bucket, objectName); err != nil {
+		return "", err
+	}
+	if !matches(o) {
+		return "", fmt.Errorf("the checksum of %s does not match the checksum of %s after upload", dst, src)
+	}
+	return status, nil
+}
+
+// Download downloads the gs://<bucket>/<object> URL src to the file at dst and
+// returns whether the object exists; dst is left unchanged if it does not
+func (u *Uploader) Download(ctx context.Context, src string, dst string) (bool, error) {
+	bucket, objectName, err := ParseURL(src)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", storageURL, bucket, url.PathEscape(objectName)), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError(resp, "failed to download %s", src)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return false, err
+	}
+	// the download is written to a temporary file, so that dst is never
+	// partially written
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return false, fmt.Errorf("failed to download %s: %w", src, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), dst)
+}
+
+// send uploads the file from offset in chunks, retrying transient failures
+// from the offset Cloud Storage persisted
+func (u *Uploader) send(ctx context.Context, f *os.File, uri string, offset int64, size int64) error {
+	buf := make([]byte, chunkSize)
+	retries := 0
+	for {
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		next, done, err := u.put(ctx, uri, buf[:n], offset, size)
+		if err == nil && done {
+			return nil
+		}
+		if err == nil && next > offset {
+			offset, retries = next, 0
+			continue
+		}
+		if err != nil && !isRetryable(err) {
+			return err
+		}
+		if retries++; retries > maxRetries {
+			if err == nil {
+				err = fmt.Errorf("no progress after %d attempts", maxRetries)
+			}
+			return err
+		}
+		time.Sleep(retryBackoff * time.Duration(1<<(retries-1)))
+		if next, done, err = u.put(ctx, uri, nil, 0, size); err == nil {
+			if done {
+				return nil
+			}
+			offset = next
+		}
+	}
+}
+
+func isRetryable(err error) bool {
+	var re retryableError
+	if errors.As(err, &re) {
+		return true
+	}
+	var ue *url.Error
+	return errors.As(err, &ue) && !errors.Is(err, context.Canceled)
+}
+
+// throttledReader limits the rate at which it is read from
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+func (u *Uploader) throttle(r io.Reader) io.Reader {
+	if u.RateLimit <= 0 {
+		return r
+	}
+	return &throttledReader{r: r, rate: u.RateLimit, start: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// read at most a tenth of a second's worth of bytes at a time
+	if max := t.rate/10 + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	due := time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second))
+	if wait := due - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
@@ -0,0 +1,277 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcsupload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGCS implements the parts of the Cloud Storage JSON API used by uploads
+type fakeGCS struct {
+	mu       sync.Mutex
+	srv      *httptest.Server
+	objects  map[string][]byte
+	sessions map[string][]byte
+	// failPuts fails the next PUT requests with a 503 after persisting them
+	failPuts int
+	// dropAfter fails PUT requests after that many were persisted with a 503,
+	// without persisting them, if positive
+	dropAfter int
+	puts      int
+}
+
+func newFakeGCS(t *testing.T) *fakeGCS {
+	f := &fakeGCS{objects: map[string][]byte{}, sessions: map[string][]byte{}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.srv.Close)
+	u, b, c := storageURL, retryBackoff, chunkSize
+	t.Cleanup(func() { storageURL, retryBackoff, chunkSize = u, b, c })
+	storageURL, retryBackoff, chunkSize = f.srv.URL, 0, 4
+	return f
+}
+
+func (f *fakeGCS) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/"):
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"), "/o/", 2)
+		data, ok := f.objects[parts[0]+"/"+parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			w.Write(data)
+			return
+		}
+		json.NewEncoder(w).Encode(object{Size: strconv.Itoa(len(data)), CRC32C: crcOf(data)})
+	case r.Method == http.MethodPost:
+		var meta map[string]string
+		json.NewDecoder(r.Body).Decode(&meta)
+		bucket := strings.Split(strings.TrimPrefix(r.URL.Path, "/upload/storage/v1/b/"), "/")[0]
+		id := fmt.Sprintf("/session/%s/%s", bucket, meta["name"])
+		f.sessions[id] = []byte{}
+		w.Header().Set("Location", f.srv.URL+id)
+	case r.Method == http.MethodPut:
+		data, ok := f.sessions[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var total int
+		cr := r.Header.Get("Content-Range")
+		if strings.HasPrefix(cr, "bytes */") {
+			total, _ = strconv.Atoi(strings.TrimPrefix(cr, "bytes */"))
+		} else {
+			if f.dropAfter > 0 && f.puts >= f.dropAfter {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var start, end int
+			fmt.Sscanf(cr, "bytes %d-%d/%d", &start, &end, &total)
+			if start == len(data) {
+				data = append(data, body...)
+			}
+			f.sessions[r.URL.Path] = data
+			f.puts++
+			if f.failPuts > 0 {
+				f.failPuts--
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if len(data) == total {
+			f.objects[strings.TrimPrefix(r.URL.Path, "/session/")] = data
+			delete(f.sessions, r.URL.Path)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("{}"))
+			return
+		}
+		if len(data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(data)-1))
+		}
+		w.WriteHeader(http.StatusPermanentRedirect)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func crcOf(data []byte) string {
+	f, _ := os.CreateTemp("", "crc")
+	defer os.Remove(f.Name())
+	f.Write(data)
+	f.Seek(0, io.SeekStart)
+	crc, _ := fileCRC32C(f)
+	f.Close()
+	return crc
+}
+
+func writeFile(t *testing.T, content string) string {
+	p := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseURL(t *testing.T) {
+	bucket, object, err := ParseURL("gs://b/dir/bundle.tar.gz")
+	if err != nil || bucket != "b" || object != "dir/bundle.tar.gz" {
+		t.Errorf("got %q %q %v", bucket, object, err)
+	}
+	for _, u := range []string{"b/o", "gs://b", "gs://b/", "gs:///o", "gs://b/dir/"} {
+		if _, _, err := ParseURL(u); err == nil {
+			t.Errorf("ParseURL(%q): expected an error", u)
+		}
+	}
+}
+
+func TestUpload(t *testing.T) {
+	gcs := newFakeGCS(t)
+	u := &Uploader{Client: http.DefaultClient, StateDir: t.TempDir()}
+	src := writeFile(t, "0123456789")
+	ctx := context.Background()
+
+	st, err := u.Upload(ctx, "bundle", src, "gs://b/bundle.tar.gz")
+	if err != nil || st != Uploaded {
+		t.Fatalf("got %v %v, want %v", st, err, Uploaded)
+	}
+	if got := string(gcs.objects["b/bundle.tar.gz"]); got != "0123456789" {
+		t.Errorf("got object %q", got)
+	}
+	if gcs.puts != 3 {
+		t.Errorf("got %d chunks, want 3", gcs.puts)
+	}
+
+	// unchanged files are not uploaded again
+	st, err = u.Upload(ctx, "bundle", src, "gs://b/bundle.tar.gz")
+	if err != nil || st != Unchanged {
+		t.Errorf("got %v %v, want %v", st, err, Unchanged)
+	}
+
+	// empty files
+	st, err = u.Upload(ctx, "empty", writeFile(t, ""), "gs://b/empty")
+	if err != nil || st != Uploaded {
+		t.Errorf("got %v %v, want %v", st, err, Uploaded)
+	}
+}
+
+func TestUploadRetriesTransientFailures(t *testing.T) {
+	gcs := newFakeGCS(t)
+	gcs.failPuts = 2
+	u := &Uploader{Client: http.DefaultClient, StateDir: t.TempDir()}
+
+	st, err := u.Upload(context.Background(), "bundle", writeFile(t, "0123456789"), "gs://b/bundle.tar.gz")
+	if err != nil || st != Uploaded {
+		t.Fatalf("got %v %v, want %v", st, err, Uploaded)
+	}
+	if got := string(gcs.objects["b/bundle.tar.gz"]); got != "0123456789" {
+		t.Errorf("got object %q", got)
+	}
+}
+
+func TestUploadResumes(t *testing.T) {
+	gcs := newFakeGCS(t)
+	u := &Uploader{Client: http.DefaultClient, StateDir: t.TempDir()}
+	src := writeFile(t, "0123456789")
+	ctx := context.Background()
+
+	// an interrupted upload, of which the first chunk was persisted
+	gcs.dropAfter = 1
+	if _, err := u.Upload(ctx, "bundle", src, "gs://b/bundle.tar.gz"); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if _, err := os.Stat(u.statePath("bundle")); err != nil {
+		t.Fatalf("the upload state was not saved: %v", err)
+	}
+
+	gcs.dropAfter = 0
+	st, err := u.Upload(ctx, "bundle", src, "gs://b/bundle.tar.gz")
+	if err != nil || st != Resumed {
+		t.Fatalf("got %v %v, want %v", st, err, Resumed)
+	}
+	if got := string(gcs.objects["b/bundle.tar.gz"]); got != "0123456789" {
+		t.Errorf("got object %q", got)
+	}
+	if _, err := os.Stat(u.statePath("bundle")); !os.IsNotExist(err) {
+		t.Error("the upload state was not removed")
+	}
+
+	// an interrupted upload of a file that changed since starts over
+	gcs.puts, gcs.dropAfter = 0, 1
+	if err := os.WriteFile(src, []byte("abcdefghij"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.Upload(ctx, "bundle", src, "gs://b/bundle.tar.gz"); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	gcs.dropAfter = 0
+	if err := os.WriteFile(src, []byte("ABCDEFGHIJ"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err = u.Upload(ctx, "bundle", src, "gs://b/bundle.tar.gz")
+	if err != nil || st != Uploaded {
+		t.Fatalf("got %v %v, want %v", st, err, Uploaded)
+	}
+	if got := string(gcs.objects["b/bundle.tar.gz"]); got != "ABCDEFGHIJ" {
+		t.Errorf("got object %q", got)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	f := newFakeGCS(t)
+	f.objects["b/dir/outputs.tfvars"] = []byte("x = 1\n")
+	u := &Uploader{Client: f.srv.Client()}
+	dst := filepath.Join(t.TempDir(), "artifacts", "outputs.tfvars")
+
+	found, err := u.Download(context.Background(), "gs://b/dir/outputs.tfvars", dst)
+	if err != nil || !found {
+		t.Fatalf("got (%v, %v), want (true, nil)", found, err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "x = 1\n" {
+		t.Errorf("got %q, want the content of the object", got)
+	}
+
+	found, err = u.Download(context.Background(), "gs://b/dir/missing.tfvars", filepath.Join(t.TempDir(), "missing"))
+	if err != nil || found {
+		t.Errorf("got (%v, %v) for a missing object, want (false, nil)", found, err)
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	u := &Uploader{RateLimit: 100}
+	start := time.Now()
+	n, err := io.Copy(io.Discard, u.throttle(strings.NewReader(strings.Repeat("x", 20))))
+	if err != nil || n != 20 {
+		t.Fatalf("got %d %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("20 bytes at 100 bytes/s read in %v, want about 200ms", elapsed)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health runs the health probes of the modules of a deployment after
+// it is deployed and reports the readiness of the deployment
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"golang.org/x/exp/slices"
+)
+
+// replaced in tests
+var (
+	pollInterval   = 10 * time.Second
+	attemptTimeout = 10 * time.Second
+	execCommand    = exec.CommandContext
+)
+
+// Check is a health probe of a module, with references resolved
+type Check struct {
+	Module config.ModuleID
+	Probe  modulereader.HealthProbe
+	// Err is set if the references of the probe could not be resolved
+	Err error
+	// Sensitive are the values of sensitive outputs the probe refers to,
+	// which are redacted from its target
+	Sensitive []string
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Check
+	Attempts int
+	Duration time.Duration
+}
+
+// Ready returns true if the probe of the check succeeded
+func (r Result) Ready() bool {
+	return r.Err == nil
+}
+
+// Target describes what the probe checks
+func (c Check) Target() string {
+	p := c.Probe
+	var t string
+	switch p.Kind() {
+	case "tcp":
+		t = "tcp " + net.JoinHostPort(p.TCP.Host, strconv.Itoa(p.TCP.Port))
+	case "url":
+		t = "url " + p.URL
+	case "command":
+		t = fmt.Sprintf("command on %s: %s", p.Command.Instance, p.Command.Command)
+	default:
+		return "unknown probe"
+	}
+	for _, v := range c.Sensitive {
+		t = strings.ReplaceAll(t, v, config.RedactedValue)
+	}
+	return t
+}
+
+// Checks returns the checks of the health probes of all modules of the
+// blueprint; references to module outputs are resolved with the outputs of
+// the deployment groups, as exported after deployment
+func Checks(bp config.Blueprint, outputs map[config.GroupName]map[string]cty.Value) []Check {
+	checks := []Check{}
+	sensitive := bp.SensitiveOutputs()
+	for _, g := range bp.DeploymentGroups {
+		for _, m := range g.Modules {
+			for _, p := range m.HealthProbes {
+				redact := []string{}
+				if p.Kind() == "command" && p.Command.Project == "" {
+					p.Command.Project = "$(vars.project_id)"
+				}
+				lookup := func(kind string, name string) (string, error) {
+					if kind == "vars" {
+						return stringValue(bp.Vars.Get(name), "deployment variable "+name)
+					}
+					v, ok := outputs[g.Name][config.AutomaticOutputName(name, m.ID)]
+					if !ok {
+						return "", fmt.Errorf("output %s of module %s was not exported by group %s", name, m.ID, g.Name)
+					}
+					s, err := stringValue(v, fmt.Sprintf("output %s of module %s", name, m.ID))
+					if err == nil && s != "" && slices.Contains(sensitive, config.ModuleRef(m.ID, name)) {
+						redact = append(redact, s)
+					}
+					return s, err
+				}
+				resolved, err := p.Resolve(lookup)
+				checks = append(checks, Check{Module: m.ID, Probe: resolved, Err: err, Sensitive: redact})
+			}
+		}
+	}
+	return checks
+}
+
+func stringValue(v cty.Value, what string) (string, error) {
+	if v.IsNull() || !v.IsKnown() {
+		return "", fmt.Errorf("%s is not set", what)
+	}
+	s, err := convert.Convert(v, cty.String)
+	if err != nil {
+		return "", fmt.Errorf("%s must be a string or number: %v", what, err)
+	}
+	return s.AsString(), nil
+}
+
+// probe runs a single attempt of the probe
+func probe(ctx context.Context, p modulereader.HealthProbe) error {
+	ctx, cancel := context.WithTimeout(ctx, attemptTimeout)
+	defer cancel()
+	switch p.Kind() {
+	case "tcp":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(p.TCP.Host, strconv.Itoa(p.TCP.Port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "url":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("%s returned %s", p.URL, resp.Status)
+		}
+		return nil
+	case "command":
+		args := []string{"compute", "ssh", p.Command.Instance, "--tunnel-through-iap", "--quiet",
+			"--project", p.Command.Project, "--command", p.Command.Command}
+		if p.Command.Zone != "" {
+			args = append(args, "--zone", p.Command.Zone)
+		}
+		out, err := execCommand(ctx, "gcloud", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	default:
+		return errors.New("probe must set one of tcp, url and command")
+	}
+}
+
+// wait waits for d, returns false if the context is done first
+func wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// Run runs the checks concurrently; each probe is retried until it succeeds
+// or the timeout expires, so that services that are still starting can become
+// ready
+func Run(ctx context.Context, checks []Check, timeout time.Duration) []Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		results[i].Check = c
+		if c.Err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(r *Result) {
+			defer wg.Done()
+			start := time.Now()
+			for {
+				r.Attempts++
+				if r.Err = probe(ctx, r.Probe); r.Err == nil || !wait(ctx, pollInterval) {
+					break
+				}
+			}
+			r.Duration = time.Since(start)
+		}(&results[i])
+	}
+	wg.Wait()
+	return results
+}
+
+// colorize wraps s in the ANSI color code if w is a terminal and colors are
+// not disabled with the NO_COLOR environment variable
+func colorize(w io.Writer, code string, s string) string {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("NO_COLOR") != "" {
+		return s
+	}
+	if fi, err := f.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return s
+	}
+	return "\033[" + code + "m" + s + "\033[0m"
+}
+
+// WriteSummary writes the readiness of each check and of the deployment
+func WriteSummary(w io.Writer, results []Result) {
+	failed := 0
+	fmt.Fprintln(w, "Health check summary:")
+	for _, r := range results {
+		status := colorize(w, "32", "READY    ")
+		if !r.Ready() {
+			status = colorize(w, "31", "NOT READY")
+			failed++
+		}
+		fmt.Fprintf(w, "  %s  %s/%s  %s\n", status, r.Module, r.Probe.Name, r.Target())
+		if !r.Ready() {
+			fmt.Fprintf(w, "             %v\n", r.Err)
+		}
+	}
+	if failed == 0 {
+		fmt.Fprintln(w, colorize(w, "32", fmt.Sprintf("Deployment is ready: %d of %d health checks passed", len(results), len(results))))
+		return
+	}
+	fmt.Fprintln(w, colorize(w, "31", fmt.Sprintf("Deployment is not ready: %d of %d health checks failed", failed, len(results))))
+}
@@ -0,0 +1,197 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulereader"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func fastPolling(t *testing.T) {
+	p, a := pollInterval, attemptTimeout
+	t.Cleanup(func() { pollInterval, attemptTimeout = p, a })
+	pollInterval, attemptTimeout = 10*time.Millisecond, time.Second
+}
+
+func TestChecks(t *testing.T) {
+	bp := config.Blueprint{
+		Vars: config.NewDict(map[string]cty.Value{
+			"project_id": cty.StringVal("proj"),
+			"zone":       cty.StringVal("us-central1-a"),
+		}),
+		DeploymentGroups: []config.DeploymentGroup{{
+			Name: "primary",
+			Modules: []config.Module{{
+				ID: "controller",
+				HealthProbes: []modulereader.HealthProbe{
+					{Name: "ssh", TCP: modulereader.TCPProbe{Host: "$(self.ip)", Port: 22}},
+					{Name: "slurmctld", Command: modulereader.CommandProbe{
+						Instance: "$(self.name)", Zone: "$(vars.zone)", Command: "systemctl is-active slurmctld"}},
+				},
+			}},
+		}},
+	}
+	outputs := map[config.GroupName]map[string]cty.Value{
+		"primary": {"ip_controller": cty.StringVal("10.0.0.2")},
+	}
+
+	checks := Checks(bp, outputs)
+	if len(checks) != 2 {
+		t.Fatalf("got %d checks, want 2", len(checks))
+	}
+	if checks[0].Err != nil {
+		t.Errorf("got unexpected error: %v", checks[0].Err)
+	}
+	if got := checks[0].Target(); got != "tcp 10.0.0.2:22" {
+		t.Errorf("got target %q", got)
+	}
+	// the output is missing, the project defaults to the deployment variable
+	if checks[1].Err == nil || !strings.Contains(checks[1].Err.Error(), "output name of module controller was not exported") {
+		t.Errorf("got unexpected error: %v", checks[1].Err)
+	}
+	if diff := cmp.Diff("proj", checks[1].Probe.Command.Project); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestChecksRedactSensitiveOutputs(t *testing.T) {
+	m := config.Module{
+		ID: "db", Source: "test::db", Kind: config.TerraformKind,
+		HealthProbes: []modulereader.HealthProbe{{Name: "url", URL: "http://$(self.host)/?token=$(self.token)"}},
+	}
+	modulereader.SetModuleInfo(m.Source, m.Kind.String(), modulereader.ModuleInfo{Outputs: []modulereader.OutputInfo{
+		{Name: "host"}, {Name: "token", Sensitive: true}}})
+	bp := config.Blueprint{DeploymentGroups: []config.DeploymentGroup{{Name: "primary", Modules: []config.Module{m}}}}
+	outputs := map[config.GroupName]map[string]cty.Value{
+		"primary": {"host_db": cty.StringVal("10.0.0.2"), "token_db": cty.StringVal("s3cr3t")},
+	}
+
+	checks := Checks(bp, outputs)
+	if got := checks[0].Probe.URL; got != "http://10.0.0.2/?token=s3cr3t" {
+		t.Errorf("got URL %q", got)
+	}
+	if got := checks[0].Target(); got != "url http://10.0.0.2/?token=(sensitive)" {
+		t.Errorf("got target %q", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	fastPolling(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	// the service becomes ready after a few requests
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if requests++; requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	var commands [][]string
+	e := execCommand
+	defer func() { execCommand = e }()
+	execCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		mu.Lock()
+		defer mu.Unlock()
+		commands = append(commands, append([]string{name}, args...))
+		if strings.Contains(strings.Join(args, " "), "failing") {
+			return exec.CommandContext(ctx, "false")
+		}
+		return exec.CommandContext(ctx, "true")
+	}
+
+	checks := []Check{
+		{Module: "a", Probe: modulereader.HealthProbe{Name: "tcp", TCP: modulereader.TCPProbe{Host: "127.0.0.1", Port: port}}},
+		{Module: "a", Probe: modulereader.HealthProbe{Name: "url", URL: srv.URL}},
+		{Module: "b", Probe: modulereader.HealthProbe{Name: "cmd", Command: modulereader.CommandProbe{
+			Instance: "vm", Zone: "z", Project: "p", Command: "true"}}},
+		{Module: "b", Probe: modulereader.HealthProbe{Name: "failing", Command: modulereader.CommandProbe{
+			Instance: "vm", Project: "p", Command: "failing"}}},
+	}
+	results := Run(context.Background(), checks, 200*time.Millisecond)
+
+	for i, want := range []bool{true, true, true, false} {
+		if results[i].Ready() != want {
+			t.Errorf("check %s: got ready %t, want %t: %v", results[i].Probe.Name, results[i].Ready(), want, results[i].Err)
+		}
+	}
+	if results[1].Attempts != 3 {
+		t.Errorf("got %d attempts of the url probe, want 3", results[1].Attempts)
+	}
+	if results[3].Attempts < 2 {
+		t.Errorf("expected the failing probe to be retried, got %d attempts", results[3].Attempts)
+	}
+	want := []string{"gcloud", "compute", "ssh", "vm", "--tunnel-through-iap", "--quiet",
+		"--project", "p", "--command", "true", "--zone", "z"}
+	found := false
+	for _, c := range commands {
+		found = found || cmp.Equal(c, want)
+	}
+	if !found {
+		t.Errorf("command probe did not run %v, got %v", want, commands)
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	results := []Result{
+		{Check: Check{Module: "a", Probe: modulereader.HealthProbe{Name: "web", URL: "http://x"}}},
+		{Check: Check{Module: "b", Probe: modulereader.HealthProbe{Name: "ssh", TCP: modulereader.TCPProbe{Host: "h", Port: 22}},
+			Err: net.ErrClosed}},
+	}
+	var buf bytes.Buffer
+	WriteSummary(&buf, results)
+	for _, s := range []string{
+		"READY      a/web  url http://x",
+		"NOT READY  b/ssh  tcp h:22",
+		"Deployment is not ready: 1 of 2 health checks failed",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("summary does not contain %q:\n%s", s, buf.String())
+		}
+	}
+}
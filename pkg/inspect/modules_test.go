@@ -15,9 +15,11 @@
 package inspect_test
 
 import (
+	"hpc-toolkit/pkg/cache"
 	"hpc-toolkit/pkg/inspect"
 	"hpc-toolkit/pkg/modulereader"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -48,6 +50,12 @@ func (m *modInfo) Role() string {
 
 var allMods []modInfo = nil
 
+func TestMain(m *testing.M) {
+	// do not read or pollute the disk cache of the user
+	cache.Enabled = false
+	os.Exit(m.Run())
+}
+
 func getModules() []modInfo {
 	if allMods != nil {
 		return allMods
@@ -19,6 +19,7 @@ import (
 	"hpc-toolkit/pkg/sourcereader"
 	"log"
 	"os"
+	"sort"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/ext/typeexpr"
@@ -33,14 +34,15 @@ import (
 // getHCLInfo is only a local path.
 func getHCLInfo(source string) (ModuleInfo, error) {
 	var module *tfconfig.Module
+	var fsys tfconfig.FS
 	ret := ModuleInfo{}
 
 	if sourcereader.IsEmbeddedPath(source) {
-		wrapFS := tfconfig.WrapFS(sourcereader.ModuleFS)
-		if !tfconfig.IsModuleDirOnFilesystem(wrapFS, source) {
+		fsys = tfconfig.WrapFS(sourcereader.ModuleFS)
+		if !tfconfig.IsModuleDirOnFilesystem(fsys, source) {
 			return ret, fmt.Errorf("Source is not a terraform or packer module: %s", source)
 		}
-		module, _ = tfconfig.LoadModuleFromFilesystem(wrapFS, source)
+		module, _ = tfconfig.LoadModuleFromFilesystem(fsys, source)
 	} else {
 		fileInfo, err := os.Stat(source)
 		if os.IsNotExist(err) {
@@ -55,7 +57,8 @@ func getHCLInfo(source string) (ModuleInfo, error) {
 		if !tfconfig.IsModuleDir(source) {
 			return ret, fmt.Errorf("Source is not a terraform or packer module: %s", source)
 		}
-		module, _ = tfconfig.LoadModule(source)
+		fsys = tfconfig.NewOsFs()
+		module, _ = tfconfig.LoadModuleFromFilesystem(fsys, source)
 	}
 
 	var vars []VarInfo
@@ -71,14 +74,27 @@ func getHCLInfo(source string) (ModuleInfo, error) {
 		vars = append(vars, vInfo)
 	}
 	ret.Inputs = vars
+	types := inferOutputTypes(fsys, module)
 	for _, v := range module.Outputs {
 		oInfo := OutputInfo{
 			Name:        v.Name,
 			Description: v.Description,
+			Sensitive:   v.Sensitive,
+			Type:        outputTypeString(types[v.Name]),
 		}
 		outs = append(outs, oInfo)
 	}
 	ret.Outputs = outs
+	for name, req := range module.RequiredProviders {
+		ret.RequiredProviders = append(ret.RequiredProviders, ProviderRequirement{
+			Name:               name,
+			Source:             req.Source,
+			VersionConstraints: req.VersionConstraints,
+		})
+	}
+	sort.Slice(ret.RequiredProviders, func(i, j int) bool {
+		return ret.RequiredProviders[i].Name < ret.RequiredProviders[j].Name
+	})
 	return ret, nil
 }
 
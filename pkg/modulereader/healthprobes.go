@@ -0,0 +1,167 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modulereader
+
+import (
+	"fmt"
+	"regexp"
+
+	"golang.org/x/exp/slices"
+)
+
+// probeRefExp matches references to outputs of the module, $(self.<output>),
+// and to deployment variables, $(vars.<name>), in health probes
+var probeRefExp = regexp.MustCompile(`\$\((self|vars)\.([A-Za-z0-9_-]+)\)`)
+
+// HealthProbe is a check of the readiness of a deployed module, run by
+// "ghpc deploy" after the last deployment group is deployed. Exactly one of
+// TCP, URL and Command is set. Values may reference outputs of the module as
+// $(self.<output>) and deployment variables as $(vars.<name>).
+type HealthProbe struct {
+	Name    string       `yaml:"name"`
+	TCP     TCPProbe     `yaml:"tcp,omitempty"`
+	URL     string       `yaml:"url,omitempty"`
+	Command CommandProbe `yaml:"command,omitempty"`
+}
+
+// TCPProbe succeeds if a connection to the port of the host can be opened
+type TCPProbe struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+// CommandProbe succeeds if the command exits with status 0 when run on a VM
+// instance over SSH, tunneled through Identity-Aware Proxy
+type CommandProbe struct {
+	Instance string `yaml:"instance"`
+	Zone     string `yaml:"zone,omitempty"`
+	// Project - is the project of the instance, the project_id deployment
+	// variable if unset
+	Project string `yaml:"project,omitempty"`
+	Command string `yaml:"command"`
+}
+
+// Kind returns the kind of the probe: "tcp", "url" or "command"
+func (p HealthProbe) Kind() string {
+	switch {
+	case p.TCP != TCPProbe{}:
+		return "tcp"
+	case p.URL != "":
+		return "url"
+	case p.Command != CommandProbe{}:
+		return "command"
+	default:
+		return ""
+	}
+}
+
+// values returns all values of the probe that may contain references
+func (p HealthProbe) values() []string {
+	return []string{p.TCP.Host, p.URL, p.Command.Instance, p.Command.Zone, p.Command.Project, p.Command.Command}
+}
+
+func (p HealthProbe) references(kind string) []string {
+	refs := []string{}
+	for _, v := range p.values() {
+		for _, m := range probeRefExp.FindAllStringSubmatch(v, -1) {
+			if m[1] == kind && !slices.Contains(refs, m[2]) {
+				refs = append(refs, m[2])
+			}
+		}
+	}
+	return refs
+}
+
+// SelfReferences returns the names of the outputs of the module referenced by
+// the probe
+func (p HealthProbe) SelfReferences() []string {
+	return p.references("self")
+}
+
+// VarReferences returns the names of the deployment variables referenced by
+// the probe
+func (p HealthProbe) VarReferences() []string {
+	return p.references("vars")
+}
+
+// Resolve returns the probe with references replaced by the values returned by
+// lookup for the kind of reference, "self" or "vars", and the name
+func (p HealthProbe) Resolve(lookup func(kind string, name string) (string, error)) (HealthProbe, error) {
+	var err error
+	resolve := func(v string) string {
+		return probeRefExp.ReplaceAllStringFunc(v, func(ref string) string {
+			m := probeRefExp.FindStringSubmatch(ref)
+			s, lerr := lookup(m[1], m[2])
+			if lerr != nil && err == nil {
+				err = lerr
+			}
+			return s
+		})
+	}
+	r := p
+	r.TCP.Host = resolve(p.TCP.Host)
+	r.URL = resolve(p.URL)
+	r.Command.Instance = resolve(p.Command.Instance)
+	r.Command.Zone = resolve(p.Command.Zone)
+	r.Command.Project = resolve(p.Command.Project)
+	r.Command.Command = resolve(p.Command.Command)
+	return r, err
+}
+
+// Check ensures the probe is well-formed and only references outputs of the
+// module that exist
+func (p HealthProbe) Check(outputs []OutputInfo) error {
+	if p.Name == "" {
+		return fmt.Errorf("health probe must have a name")
+	}
+	set := 0
+	for _, ok := range []bool{p.TCP != (TCPProbe{}), p.URL != "", p.Command != (CommandProbe{})} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("health probe %s must set exactly one of tcp, url and command", p.Name)
+	}
+	switch p.Kind() {
+	case "tcp":
+		if p.TCP.Host == "" || p.TCP.Port < 1 || p.TCP.Port > 65535 {
+			return fmt.Errorf("health probe %s must set the host and a port between 1 and 65535", p.Name)
+		}
+	case "command":
+		if p.Command.Instance == "" || p.Command.Command == "" {
+			return fmt.Errorf("health probe %s must set the instance and the command", p.Name)
+		}
+	}
+	for _, ref := range p.SelfReferences() {
+		if !slices.ContainsFunc(outputs, func(o OutputInfo) bool { return o.Name == ref }) {
+			return fmt.Errorf("health probe %s references $(self.%s), which is not an output of the module", p.Name, ref)
+		}
+	}
+	return nil
+}
+
+// checkHealthProbes validates the health probes declared in the metadata file
+// of a module
+func checkHealthProbes(source string, probes []HealthProbe, outputs []OutputInfo) error {
+	for _, p := range probes {
+		if err := p.Check(outputs); err != nil {
+			return fmt.Errorf("invalid %s of module %s: %v", MetadataFileName, source, err)
+		}
+	}
+	return nil
+}
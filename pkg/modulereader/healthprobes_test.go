@@ -0,0 +1,92 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modulereader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestHealthProbeCheck(c *C) {
+	outputs := []OutputInfo{{Name: "ip"}}
+	c.Check(HealthProbe{Name: "a", TCP: TCPProbe{Host: "$(self.ip)", Port: 22}}.Check(outputs), IsNil)
+	c.Check(HealthProbe{Name: "b", URL: "http://$(self.ip):8080/"}.Check(outputs), IsNil)
+	c.Check(HealthProbe{Name: "c", Command: CommandProbe{Instance: "vm", Command: "true"}}.Check(outputs), IsNil)
+
+	c.Check(HealthProbe{URL: "http://x"}.Check(outputs), ErrorMatches, ".*must have a name.*")
+	c.Check(HealthProbe{Name: "d"}.Check(outputs), ErrorMatches, ".*exactly one of.*")
+	c.Check(HealthProbe{Name: "e", URL: "http://x", TCP: TCPProbe{Host: "x", Port: 1}}.Check(outputs), ErrorMatches, ".*exactly one of.*")
+	c.Check(HealthProbe{Name: "f", TCP: TCPProbe{Host: "x"}}.Check(outputs), ErrorMatches, ".*port between.*")
+	c.Check(HealthProbe{Name: "g", Command: CommandProbe{Instance: "vm"}}.Check(outputs), ErrorMatches, ".*instance and the command.*")
+	c.Check(HealthProbe{Name: "h", URL: "http://$(self.name)"}.Check(outputs), ErrorMatches, `.*\$\(self.name\), which is not an output.*`)
+}
+
+func (s *MySuite) TestHealthProbeResolve(c *C) {
+	p := HealthProbe{Name: "a", Command: CommandProbe{
+		Instance: "$(self.name)", Zone: "$(vars.zone)", Command: "ping $(self.name)"}}
+	c.Check(p.SelfReferences(), DeepEquals, []string{"name"})
+	c.Check(p.VarReferences(), DeepEquals, []string{"zone"})
+
+	r, err := p.Resolve(func(kind string, name string) (string, error) {
+		return kind + "-" + name, nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(r.Command, DeepEquals, CommandProbe{Instance: "self-name", Zone: "vars-zone", Command: "ping self-name"})
+
+	_, err = p.Resolve(func(kind string, name string) (string, error) {
+		return "", fmt.Errorf("unknown %s", name)
+	})
+	c.Check(err, ErrorMatches, "unknown name")
+}
+
+func (s *MySuite) TestReadMetadata(c *C) {
+	dir := c.MkDir()
+	md, err := readMetadata(dir, dir)
+	c.Assert(err, IsNil)
+	c.Check(md, DeepEquals, ghpcMetadata{})
+
+	metadata := `
+spec:
+  requirements: {}
+ghpc:
+  health_probes:
+  - name: web
+    url: http://$(self.ip)/
+  wrap_settings:
+    tags: merge
+  inputs:
+    zone:
+      immutable: true
+`
+	c.Assert(os.WriteFile(filepath.Join(dir, MetadataFileName), []byte(metadata), 0644), IsNil)
+	md, err = readMetadata(dir, dir)
+	c.Assert(err, IsNil)
+	c.Check(md.HealthProbes, DeepEquals, []HealthProbe{{Name: "web", URL: "http://$(self.ip)/"}})
+	c.Check(md.WrapSettings, DeepEquals, map[string]string{"tags": "merge"})
+	c.Check(md.Inputs, DeepEquals, map[string]InputMetadata{"zone": {Immutable: true}})
+
+	c.Check(checkHealthProbes(dir, md.HealthProbes, []OutputInfo{{Name: "ip"}}), IsNil)
+	c.Check(checkHealthProbes(dir, md.HealthProbes, nil), ErrorMatches, ".*not an output of the module.*")
+
+	c.Check(checkWrapSettings(dir, md.WrapSettings, []VarInfo{{Name: "tags"}}), IsNil)
+	c.Check(checkWrapSettings(dir, md.WrapSettings, nil), ErrorMatches, ".*tags is not an input of the module.*")
+	c.Check(checkWrapSettings(dir, map[string]string{"tags": "merge("}, []VarInfo{{Name: "tags"}}),
+		ErrorMatches, `.*"merge\(" is not a valid function name.*`)
+}
@@ -0,0 +1,82 @@
+/**
+ * Copyright 2022 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modulereader
+
+import (
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/sourcereader"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// HelmReader implements Modulereader for Helm charts; the inputs of a chart
+// are the top-level keys of its values.yaml
+type HelmReader struct{}
+
+// NewHelmReader is a constructor for HelmReader
+func NewHelmReader() HelmReader {
+	return HelmReader{}
+}
+
+// GetInfo reads the ModuleInfo for a Helm chart
+func (r HelmReader) GetInfo(source string) (ModuleInfo, error) {
+	tmpDir, err := ioutil.TempDir("", "helmreader-*")
+	if err != nil {
+		return ModuleInfo{}, fmt.Errorf(
+			"failed to create temp directory for helm reader")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	modPath := path.Join(tmpDir, path.Base(source))
+	sourceReader := sourcereader.Factory(source)
+	if err = sourceReader.GetModule(source, modPath); err != nil {
+		return ModuleInfo{}, err
+	}
+	return getHelmInfo(modPath)
+}
+
+// getHelmInfo reads the inputs of the chart in dir
+func getHelmInfo(dir string) (ModuleInfo, error) {
+	if _, err := os.Stat(filepath.Join(dir, "Chart.yaml")); err != nil {
+		return ModuleInfo{}, fmt.Errorf("HelmReader: %s is not a Helm chart, it has no Chart.yaml", dir)
+	}
+	mi := ModuleInfo{Inputs: []VarInfo{}, Outputs: []OutputInfo{}}
+	b, err := os.ReadFile(filepath.Join(dir, "values.yaml"))
+	if errors.Is(err, os.ErrNotExist) {
+		return mi, nil
+	}
+	if err != nil {
+		return ModuleInfo{}, fmt.Errorf("HelmReader: %v", err)
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return ModuleInfo{}, fmt.Errorf("HelmReader: failed to parse values.yaml of %s: %v", dir, err)
+	}
+	keys := maps.Keys(values)
+	slices.Sort(keys)
+	for _, k := range keys {
+		mi.Inputs = append(mi.Inputs, VarInfo{Name: k, Type: "any", Default: values[k]})
+	}
+	return mi, nil
+}
@@ -0,0 +1,119 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modulereader
+
+import (
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/sourcereader"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// MetadataFileName is the name of the optional file of a module that
+// declares metadata used by ghpc
+const MetadataFileName = "metadata.yaml"
+
+// wrapperExp matches the names of the HCL functions settings are wrapped with
+var wrapperExp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// IsWrapperName returns whether name is a valid name of an HCL function a
+// setting is wrapped with, e.g. flatten or merge
+func IsWrapperName(name string) bool {
+	return wrapperExp.MatchString(name)
+}
+
+// ghpcMetadata is the ghpc section of the metadata file of a module
+type ghpcMetadata struct {
+	HealthProbes []HealthProbe `yaml:"health_probes"`
+	// WrapSettings - are the HCL functions settings of the module are wrapped
+	// with when written, by the name of the setting
+	WrapSettings map[string]string `yaml:"wrap_settings"`
+	// Inputs - are the properties of inputs of the module, by name
+	Inputs map[string]InputMetadata `yaml:"inputs"`
+}
+
+// InputMetadata are properties of an input of a module declared in its
+// metadata file
+type InputMetadata struct {
+	// Immutable inputs are part of the identity of resources, or map to
+	// attributes that cannot be updated in place, so that changing them
+	// after deployment recreates resources
+	Immutable bool `yaml:"immutable"`
+}
+
+// readMetadata reads the ghpc section of the metadata file of the module at
+// modPath, empty if it has none
+func readMetadata(source string, modPath string) (ghpcMetadata, error) {
+	var b []byte
+	var err error
+	if sourcereader.IsEmbeddedPath(source) {
+		b, err = sourcereader.ModuleFS.ReadFile(path.Join(modPath, MetadataFileName))
+	} else {
+		b, err = os.ReadFile(path.Join(modPath, MetadataFileName))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return ghpcMetadata{}, nil
+	}
+	if err != nil {
+		return ghpcMetadata{}, fmt.Errorf("failed to read %s of module %s: %v", MetadataFileName, source, err)
+	}
+
+	var md struct {
+		Ghpc ghpcMetadata `yaml:"ghpc"`
+	}
+	if err := yaml.Unmarshal(b, &md); err != nil {
+		return ghpcMetadata{}, fmt.Errorf("failed to parse %s of module %s: %v", MetadataFileName, source, err)
+	}
+	return md.Ghpc, nil
+}
+
+// checkWrapSettings validates the settings wrappers declared in the metadata
+// file of a module, which wrap inputs of the module
+func checkWrapSettings(source string, wrappers map[string]string, inputs []VarInfo) error {
+	for setting, fn := range wrappers {
+		if !slices.ContainsFunc(inputs, func(v VarInfo) bool { return v.Name == setting }) {
+			return fmt.Errorf("invalid %s of module %s: wrapped setting %s is not an input of the module",
+				MetadataFileName, source, setting)
+		}
+		if !IsWrapperName(fn) {
+			return fmt.Errorf("invalid %s of module %s: %q is not a valid function name to wrap setting %s with",
+				MetadataFileName, source, fn, setting)
+		}
+	}
+	return nil
+}
+
+// markImmutableInputs marks the inputs of the module that its metadata file
+// declares immutable
+func markImmutableInputs(source string, md map[string]InputMetadata, inputs []VarInfo) error {
+	for name := range md {
+		if !slices.ContainsFunc(inputs, func(v VarInfo) bool { return v.Name == name }) {
+			return fmt.Errorf("invalid %s of module %s: input %s is not an input of the module",
+				MetadataFileName, source, name)
+		}
+	}
+	for i := range inputs {
+		inputs[i].Immutable = md[inputs[i].Name].Immutable
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modulereader
+
+import (
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/terraform-config-inspect/tfconfig"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// functions of Terraform whose result is of a known type, whatever their
+// arguments
+var functionTypes = map[string]cty.Type{
+	"abs": cty.Number, "ceil": cty.Number, "floor": cty.Number, "length": cty.Number,
+	"max": cty.Number, "min": cty.Number, "parseint": cty.Number, "tonumber": cty.Number,
+	"alltrue": cty.Bool, "anytrue": cty.Bool, "can": cty.Bool, "contains": cty.Bool,
+	"endswith": cty.Bool, "startswith": cty.Bool, "tobool": cty.Bool,
+	"base64decode": cty.String, "base64encode": cty.String, "basename": cty.String,
+	"chomp": cty.String, "dirname": cty.String, "format": cty.String, "join": cty.String,
+	"jsonencode": cty.String, "lower": cty.String, "md5": cty.String, "replace": cty.String,
+	"sha256": cty.String, "substr": cty.String, "title": cty.String, "tostring": cty.String,
+	"trim": cty.String, "trimprefix": cty.String, "trimspace": cty.String, "trimsuffix": cty.String,
+	"upper": cty.String, "uuid": cty.String, "yamlencode": cty.String,
+	"split": cty.List(cty.String),
+}
+
+// typeScope holds what the type of an expression of a module is inferred from
+type typeScope struct {
+	vars   map[string]cty.Type
+	locals map[string]hclsyntax.Expression
+	// locals whose type is being inferred, which a local referring to itself
+	// would be inferred from again
+	inferring map[string]bool
+}
+
+// inferOutputTypes infers the types of the outputs of a module from their
+// value expressions; outputs of values whose type is only known when the
+// module is applied, e.g. attributes of resources, are of type
+// cty.DynamicPseudoType.
+func inferOutputTypes(fsys tfconfig.FS, module *tfconfig.Module) map[string]cty.Type {
+	s := typeScope{
+		vars:      map[string]cty.Type{},
+		locals:    map[string]hclsyntax.Expression{},
+		inferring: map[string]bool{},
+	}
+	for name, v := range module.Variables {
+		s.vars[name] = cty.DynamicPseudoType
+		if ty, err := getCtyType(v.Type); v.Type != "" && err == nil {
+			s.vars[name] = ty
+		}
+	}
+
+	// locals can be declared in any file of the module, which has blocks
+	// that tfconfig records the positions of
+	files := map[string]bool{}
+	for _, v := range module.Variables {
+		files[v.Pos.Filename] = true
+	}
+	for _, o := range module.Outputs {
+		files[o.Pos.Filename] = true
+	}
+	for _, r := range module.ManagedResources {
+		files[r.Pos.Filename] = true
+	}
+	for _, r := range module.DataResources {
+		files[r.Pos.Filename] = true
+	}
+	for _, c := range module.ModuleCalls {
+		files[c.Pos.Filename] = true
+	}
+	names := []string{}
+	for f := range files {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+
+	values := map[string]hclsyntax.Expression{}
+	for _, name := range names {
+		b, err := fsys.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		f, diags := hclsyntax.ParseConfig(b, name, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			continue
+		}
+		for _, block := range f.Body.(*hclsyntax.Body).Blocks {
+			switch {
+			case block.Type == "locals":
+				for n, a := range block.Body.Attributes {
+					s.locals[n] = a.Expr
+				}
+			case block.Type == "output" && len(block.Labels) == 1:
+				if a, ok := block.Body.Attributes["value"]; ok {
+					values[block.Labels[0]] = a.Expr
+				}
+			}
+		}
+	}
+
+	res := map[string]cty.Type{}
+	for name := range module.Outputs {
+		res[name] = cty.DynamicPseudoType
+		if e, ok := values[name]; ok {
+			res[name] = s.infer(e)
+		}
+	}
+	return res
+}
+
+// infer returns the type of the value of e, cty.DynamicPseudoType if it is
+// not known before the module is applied
+func (s typeScope) infer(e hclsyntax.Expression) cty.Type {
+	switch e := e.(type) {
+	case *hclsyntax.LiteralValueExpr:
+		if e.Val.IsNull() {
+			return cty.DynamicPseudoType
+		}
+		return e.Val.Type()
+	case *hclsyntax.TemplateExpr:
+		return cty.String
+	case *hclsyntax.TemplateWrapExpr:
+		return s.infer(e.Wrapped)
+	case *hclsyntax.ParenthesesExpr:
+		return s.infer(e.Expression)
+	case *hclsyntax.ScopeTraversalExpr:
+		return s.traversal(e.Traversal)
+	case *hclsyntax.ConditionalExpr:
+		if t, f := s.infer(e.TrueResult), s.infer(e.FalseResult); t.Equals(f) {
+			return t
+		}
+	case *hclsyntax.FunctionCallExpr:
+		if ty, ok := functionTypes[e.Name]; ok {
+			return ty
+		}
+	case *hclsyntax.TupleConsExpr:
+		if len(e.Exprs) == 0 {
+			return cty.DynamicPseudoType
+		}
+		ty := s.infer(e.Exprs[0])
+		for _, el := range e.Exprs[1:] {
+			if !s.infer(el).Equals(ty) {
+				return cty.DynamicPseudoType
+			}
+		}
+		if ty == cty.DynamicPseudoType {
+			return cty.DynamicPseudoType
+		}
+		return cty.List(ty)
+	case *hclsyntax.ObjectConsExpr:
+		attrs := map[string]cty.Type{}
+		for _, item := range e.Items {
+			k, diags := item.KeyExpr.Value(nil)
+			if diags.HasErrors() || k.IsNull() || !k.IsKnown() || k.Type() != cty.String {
+				return cty.DynamicPseudoType
+			}
+			attrs[k.AsString()] = s.infer(item.ValueExpr)
+		}
+		return cty.Object(attrs)
+	}
+	return cty.DynamicPseudoType
+}
+
+// traversal returns the type of a variable or local of the module
+func (s typeScope) traversal(t hcl.Traversal) cty.Type {
+	if len(t) != 2 {
+		return cty.DynamicPseudoType
+	}
+	attr, ok := t[1].(hcl.TraverseAttr)
+	if !ok {
+		return cty.DynamicPseudoType
+	}
+	switch t.RootName() {
+	case "var":
+		if ty, ok := s.vars[attr.Name]; ok {
+			return ty
+		}
+	case "local":
+		e, ok := s.locals[attr.Name]
+		if !ok || s.inferring[attr.Name] {
+			return cty.DynamicPseudoType
+		}
+		s.inferring[attr.Name] = true
+		defer delete(s.inferring, attr.Name)
+		return s.infer(e)
+	}
+	return cty.DynamicPseudoType
+}
+
+// outputTypeString returns the type of an output as it is recorded in
+// OutputInfo, empty if it is not known
+func outputTypeString(ty cty.Type) string {
+	if ty == cty.DynamicPseudoType {
+		return ""
+	}
+	return typeexpr.TypeString(ty)
+}
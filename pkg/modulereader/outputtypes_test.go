@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modulereader
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestInferOutputTypes(c *C) {
+	dir := c.MkDir()
+	variables := `
+variable "zones" {
+  type = list(string)
+}
+variable "anything" {}
+`
+	outputs := `
+locals {
+  name = "${var.anything}-vm"
+  loop = local.loop
+}
+
+resource "test_resource" "vm" {}
+
+output "zones" {
+  value = var.zones
+}
+output "name" {
+  value = local.name
+}
+output "count" {
+  value = length(var.zones)
+}
+output "ids" {
+  value = [test_resource.vm.id]
+}
+output "config" {
+  value = { enabled = true, port = 22, zone = var.zones[0] }
+}
+output "mode" {
+  value = var.anything == null ? "default" : "custom"
+}
+output "anything" {
+  value = var.anything
+}
+output "loop" {
+  value = local.loop
+}
+output "id" {
+  value = test_resource.vm.id
+}
+`
+	c.Assert(os.WriteFile(filepath.Join(dir, "variables.tf"), []byte(variables), 0644), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "outputs.tf"), []byte(outputs), 0644), IsNil)
+
+	info, err := getHCLInfo(dir)
+	c.Assert(err, IsNil)
+	got := map[string]string{}
+	for _, o := range info.Outputs {
+		got[o.Name] = o.Type
+	}
+	c.Check(got, DeepEquals, map[string]string{
+		"zones":    "list(string)",
+		"name":     "string",
+		"count":    "number",
+		"ids":      "",
+		"config":   "object({enabled=bool,port=number,zone=any})",
+		"mode":     "string",
+		"anything": "",
+		"loop":     "",
+		"id":       "",
+	})
+}
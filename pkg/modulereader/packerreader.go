@@ -85,5 +85,7 @@ func (r PackerReader) GetInfo(source string) (ModuleInfo, error) {
 	if err != nil {
 		return modInfo, fmt.Errorf("PackerReader: %v", err)
 	}
+	// sources and builders of Packer templates are not Terraform providers
+	modInfo.RequiredProviders = nil
 	return modInfo, nil
 }
@@ -19,11 +19,15 @@ package modulereader
 
 import (
 	"fmt"
+	"hpc-toolkit/pkg/cache"
 	"hpc-toolkit/pkg/sourcereader"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v3"
 )
@@ -35,6 +39,9 @@ type VarInfo struct {
 	Description string
 	Default     interface{}
 	Required    bool
+	// Immutable inputs force the recreation of resources when changed
+	// after deployment
+	Immutable bool
 }
 
 // OutputInfo stores information about module output values
@@ -42,6 +49,12 @@ type OutputInfo struct {
 	Name        string
 	Description string `yaml:",omitempty"`
 	Sensitive   bool   `yaml:",omitempty"`
+	// Managed is set on outputs ghpc adds to modules for use by other
+	// deployment groups, health probes and outputs of the deployment
+	Managed bool `yaml:",omitempty"`
+	// Type is the type of the value of the output, as inferred from its
+	// expression; empty if it is only known when the module is applied
+	Type string `yaml:"-"`
 	// DependsOn   []string `yaml:"depends_on,omitempty"`
 }
 
@@ -64,7 +77,7 @@ func (mo *OutputInfo) UnmarshalYAML(value *yaml.Node) error {
 	}
 
 	err = enforceMapKeys(fields, map[string]bool{
-		"name": true, "description": false, "sensitive": false},
+		"name": true, "description": false, "sensitive": false, "managed": false},
 	)
 	if err != nil {
 		return fmt.Errorf(yamlErrorMsg, value.Line, err)
@@ -100,6 +113,24 @@ type ModuleInfo struct {
 	Inputs       []VarInfo
 	Outputs      []OutputInfo
 	RequiredApis []string
+	// RequiredPermissions are the IAM permissions needed to deploy the module
+	RequiredPermissions []string
+	HealthProbes        []HealthProbe
+	// WrapSettings are the HCL functions inputs of the module are wrapped with
+	// when written, by the name of the input, as declared in its metadata
+	WrapSettings map[string]string
+	// RequiredProviders are the providers the module declares in
+	// required_providers, by name
+	RequiredProviders []ProviderRequirement
+}
+
+// ProviderRequirement is a provider a Terraform module requires
+type ProviderRequirement struct {
+	Name string
+	// Source is the source address of the provider, empty for the provider
+	// of the same name in the hashicorp namespace
+	Source             string
+	VersionConstraints []string
 }
 
 // GetOutputsAsMap returns the outputs list as a map for quicker access
@@ -116,29 +147,71 @@ type sourceAndKind struct {
 	kind   string
 }
 
-var modInfoCache = map[sourceAndKind]ModuleInfo{}
+var (
+	modInfoMu    sync.Mutex
+	modInfoCache = map[sourceAndKind]ModuleInfo{}
+	// modInfoLocks serializes reads of the same module, so that concurrent
+	// requests for one source fetch it once; distinct modules are read in parallel
+	modInfoLocks = map[sourceAndKind]*sync.Mutex{}
+)
+
+func lockModuleInfo(key sourceAndKind) *sync.Mutex {
+	modInfoMu.Lock()
+	defer modInfoMu.Unlock()
+	l, ok := modInfoLocks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		modInfoLocks[key] = l
+	}
+	l.Lock()
+	return l
+}
+
+func cachedModuleInfo(key sourceAndKind) (ModuleInfo, bool) {
+	modInfoMu.Lock()
+	defer modInfoMu.Unlock()
+	mi, ok := modInfoCache[key]
+	return mi, ok
+}
 
 // GetModuleInfo gathers information about a module at a given source using the
 // tfconfig package. It will add details about required APIs to be
 // enabled for that module.
 // There is a cache to avoid re-reading the module info for the same source and kind.
+// Across runs, module info is also cached on disk keyed by the module contents.
+// It is safe to call GetModuleInfo concurrently.
 func GetModuleInfo(source string, kind string) (ModuleInfo, error) {
 	key := sourceAndKind{source, kind}
-	if mi, ok := modInfoCache[key]; ok {
+	defer lockModuleInfo(key).Unlock()
+	if mi, ok := cachedModuleInfo(key); ok {
 		return mi, nil
 	}
 
+	mi, err := readModuleInfo(source, kind)
+	if err != nil {
+		return ModuleInfo{}, err
+	}
+
+	modInfoMu.Lock()
+	defer modInfoMu.Unlock()
+	modInfoCache[key] = mi
+	return mi, nil
+}
+
+func readModuleInfo(source string, kind string) (ModuleInfo, error) {
 	var modPath string
+	unversioned, _, _ := strings.Cut(source, "?")
 	switch {
-	case sourcereader.IsGitPath(source):
+	case sourcereader.IsRemotePath(source) || sourcereader.IsRegistryPath(unversioned):
 		tmpDir, err := ioutil.TempDir("", "module-*")
 		if err != nil {
 			return ModuleInfo{}, err
 		}
+		defer os.RemoveAll(tmpDir)
 		modPath = path.Join(tmpDir, "module")
 		sourceReader := sourcereader.Factory(source)
 		if err = sourceReader.GetModule(source, modPath); err != nil {
-			return ModuleInfo{}, fmt.Errorf("failed to clone git module at %s: %v", source, err)
+			return ModuleInfo{}, fmt.Errorf("failed to fetch module at %s: %v", source, err)
 		}
 
 	case sourcereader.IsEmbeddedPath(source) || sourcereader.IsLocalPath(source):
@@ -148,30 +221,95 @@ func GetModuleInfo(source string, kind string) (ModuleInfo, error) {
 		return ModuleInfo{}, fmt.Errorf("Source is not valid: %s", source)
 	}
 
-	reader := Factory(kind)
-	mi, err := reader.GetInfo(modPath)
+	mi, err := getCachedInfo(source, modPath, kind)
 	if err != nil {
 		return ModuleInfo{}, err
 	}
 
-	// add APIs required by the module, if known
+	if kind == "terraform" {
+		md, err := readMetadata(source, modPath)
+		if err != nil {
+			return ModuleInfo{}, err
+		}
+		if err := checkHealthProbes(source, md.HealthProbes, mi.Outputs); err != nil {
+			return ModuleInfo{}, err
+		}
+		if err := checkWrapSettings(source, md.WrapSettings, mi.Inputs); err != nil {
+			return ModuleInfo{}, err
+		}
+		if err := markImmutableInputs(source, md.Inputs, mi.Inputs); err != nil {
+			return ModuleInfo{}, err
+		}
+		mi.HealthProbes, mi.WrapSettings = md.HealthProbes, md.WrapSettings
+	}
+
+	// add APIs and permissions required by the module, if known
+	if known, ok := knownModulePath(source, modPath); ok {
+		mi.RequiredApis = defaultAPIList(known)
+		mi.RequiredPermissions = defaultPermissionList(known)
+	}
+
+	return mi, nil
+}
+
+// knownModulePath returns the path of an embedded module, or of a local copy
+// of the toolkit modules, relative to the root of the toolkit repository
+func knownModulePath(source string, modPath string) (string, bool) {
 	if sourcereader.IsEmbeddedPath(source) {
-		mi.RequiredApis = defaultAPIList(modPath)
-	} else if sourcereader.IsLocalPath(source) {
+		return modPath, true
+	}
+	if sourcereader.IsLocalPath(source) {
 		if idx := strings.Index(modPath, "/community/modules/"); idx != -1 {
-			mi.RequiredApis = defaultAPIList(modPath[idx+1:])
+			return modPath[idx+1:], true
 		} else if idx := strings.Index(modPath, "/modules/"); idx != -1 {
-			mi.RequiredApis = defaultAPIList(modPath[idx+1:])
+			return modPath[idx+1:], true
 		}
 	}
+	return "", false
+}
 
-	modInfoCache[key] = mi
+// getCachedInfo reads module info from the disk cache, falling back to parsing
+// the module at modPath and storing the result in the cache
+func getCachedInfo(source string, modPath string, kind string) (ModuleInfo, error) {
+	reader := Factory(kind)
+	if !cache.Enabled {
+		return reader.GetInfo(modPath)
+	}
+
+	var hash string
+	var err error
+	if sourcereader.IsEmbeddedPath(source) {
+		var sub fs.FS
+		if sub, err = fs.Sub(sourcereader.ModuleFS, modPath); err == nil {
+			hash, err = cache.HashFS(sub)
+		}
+	} else {
+		hash, err = cache.HashDir(modPath)
+	}
+	if err != nil { // let the reader report problems with the module
+		return reader.GetInfo(modPath)
+	}
+
+	// the version is bumped when ModuleInfo gains fields read from modules
+	key := cache.Key("module-info", "3", kind, hash)
+	var mi ModuleInfo
+	if cache.LoadInfo(key, &mi) {
+		return mi, nil
+	}
+	if mi, err = reader.GetInfo(modPath); err != nil {
+		return ModuleInfo{}, err
+	}
+	if err := cache.StoreInfo(key, mi); err != nil {
+		log.Printf("failed to cache info for module %s: %v", source, err)
+	}
 	return mi, nil
 }
 
 // SetModuleInfo sets the ModuleInfo for a given source and kind
 // NOTE: This is only used for testing
 func SetModuleInfo(source string, kind string, info ModuleInfo) {
+	modInfoMu.Lock()
+	defer modInfoMu.Unlock()
 	modInfoCache[sourceAndKind{source, kind}] = info
 }
 
@@ -183,6 +321,7 @@ type ModReader interface {
 var kinds = map[string]ModReader{
 	"terraform": NewTFReader(),
 	"packer":    NewPackerReader(),
+	"helm":      NewHelmReader(),
 }
 
 // IsValidReaderKind returns true if the kind input is valid
@@ -295,7 +434,11 @@ func defaultAPIList(source string) []string {
 			"compute.googleapis.com",
 		},
 		"community/modules/scripts/htcondor-install": {},
-		"community/modules/scripts/omnia-install":    {},
+		"community/modules/scripts/maintenance-schedule": {
+			"cloudscheduler.googleapis.com",
+			"iam.googleapis.com",
+		},
+		"community/modules/scripts/omnia-install": {},
 		"community/modules/scripts/pbspro-preinstall": {
 			"iam.googleapis.com",
 			"storage.googleapis.com",
@@ -340,3 +483,118 @@ func defaultAPIList(source string) []string {
 	}
 	return requiredAPIs
 }
+
+// IAM permissions needed to create the resources common to many modules
+var (
+	instancePermissions = []string{
+		"compute.disks.create",
+		"compute.instances.create",
+		"compute.instances.setMetadata",
+		"compute.instances.setServiceAccount",
+		"compute.subnetworks.use",
+		"iam.serviceAccounts.actAs",
+	}
+	instanceTemplatePermissions = []string{
+		"compute.instanceTemplates.create",
+		"compute.instanceTemplates.useReadOnly",
+		"compute.subnetworks.use",
+		"iam.serviceAccounts.actAs",
+	}
+	bucketPermissions = []string{
+		"storage.buckets.create",
+		"storage.objects.create",
+	}
+	serviceAccountPermissions = []string{
+		"iam.serviceAccounts.create",
+		"resourcemanager.projects.setIamPolicy",
+	}
+	secretPermissions = []string{
+		"secretmanager.secrets.create",
+		"secretmanager.versions.add",
+	}
+	networkPermissions = []string{
+		"compute.firewalls.create",
+		"compute.networks.create",
+		"compute.routers.create",
+		"compute.subnetworks.create",
+	}
+)
+
+// permissions concatenates lists of permissions
+func permissions(lists ...[]string) []string {
+	all := []string{}
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	return all
+}
+
+func defaultPermissionList(source string) []string {
+	// permissions needed to create the resources of the modules, see
+	// https://cloud.google.com/iam/docs/permissions-reference
+	staticPermissionMap := map[string][]string{
+		"community/modules/compute/htcondor-execute-point": permissions(instanceTemplatePermissions,
+			[]string{"compute.instanceGroupManagers.create"}),
+		"community/modules/compute/pbspro-execution": permissions(instancePermissions, bucketPermissions),
+		"community/modules/database/slurm-cloudsql-federation": {
+			"bigquery.connections.create",
+			"cloudsql.instances.create",
+			"cloudsql.users.create",
+		},
+		"community/modules/file-system/DDN-EXAScaler": permissions(instancePermissions, serviceAccountPermissions,
+			[]string{"deploymentmanager.deployments.create", "runtimeconfig.configs.create"}),
+		"community/modules/file-system/Intel-DAOS": permissions(instancePermissions, secretPermissions),
+		"community/modules/file-system/nfs-server": instancePermissions,
+		"community/modules/project/new-project": {
+			"billing.resourceAssociations.create",
+			"resourcemanager.projects.create",
+		},
+		"community/modules/project/service-account": serviceAccountPermissions,
+		"community/modules/project/service-enablement": {
+			"serviceusage.services.enable",
+		},
+		"community/modules/scheduler/htcondor-configure": permissions(serviceAccountPermissions, secretPermissions),
+		"community/modules/scheduler/pbspro-client":      permissions(instancePermissions, bucketPermissions),
+		"community/modules/scheduler/pbspro-server":      permissions(instancePermissions, bucketPermissions),
+		"community/modules/scheduler/schedmd-slurm-gcp-v5-controller": permissions(instancePermissions,
+			instanceTemplatePermissions, secretPermissions,
+			[]string{"pubsub.subscriptions.create", "pubsub.topics.create"}),
+		"community/modules/scheduler/schedmd-slurm-gcp-v5-hybrid": {
+			"pubsub.subscriptions.create",
+			"pubsub.topics.create",
+		},
+		"community/modules/scheduler/schedmd-slurm-gcp-v5-login": permissions(instancePermissions, instanceTemplatePermissions),
+		"community/modules/scripts/maintenance-schedule": permissions(serviceAccountPermissions,
+			[]string{"cloudscheduler.jobs.create"}),
+		"community/modules/scripts/pbspro-preinstall": bucketPermissions,
+		"community/modules/scripts/wait-for-startup": {
+			"compute.instances.getSerialPortOutput",
+		},
+		"modules/compute/vm-instance": permissions(instancePermissions,
+			[]string{"compute.resourcePolicies.create"}),
+		"modules/file-system/filestore": {
+			"file.instances.create",
+		},
+		"modules/file-system/cloud-storage-bucket": bucketPermissions,
+		"modules/monitoring/dashboard": {
+			"monitoring.dashboards.create",
+		},
+		"modules/network/pre-existing-vpc": {
+			"compute.networks.get",
+			"compute.subnetworks.get",
+		},
+		"modules/network/vpc": networkPermissions,
+		"modules/packer/custom-image": permissions(instancePermissions,
+			[]string{"compute.images.create", "compute.firewalls.create"}),
+		"modules/scheduler/batch-job-template": permissions(instanceTemplatePermissions,
+			[]string{"batch.jobs.create"}),
+		"modules/scheduler/batch-login-node": permissions(instancePermissions, bucketPermissions),
+		"modules/scripts/startup-script":     bucketPermissions,
+	}
+
+	requiredPermissions, found := staticPermissionMap[source]
+	if !found {
+		return []string{}
+	}
+	return requiredPermissions
+}
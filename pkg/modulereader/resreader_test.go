@@ -16,6 +16,7 @@ package modulereader
 
 import (
 	"embed"
+	"hpc-toolkit/pkg/cache"
 	"hpc-toolkit/pkg/sourcereader"
 	"io/ioutil"
 	"log"
@@ -50,6 +51,7 @@ variable "test_variable" {
 output "test_output" {
 	description = "This is just a test"
 	value       = "test_value"
+	sensitive   = true
 }
 `
 )
@@ -120,7 +122,7 @@ func (s *MySuite) TestGetModuleInfo_Embedded(c *C) {
 	c.Assert(err, ErrorMatches, expectedErr)
 
 	// Invalid: Unsupported Module Source
-	badSource := "gcs::https://www.googleapis.com/storage/v1/GoogleCloudPlatform/hpc-toolkit/modules"
+	badSource := "ftp://example.com/hpc-toolkit/modules.zip"
 	moduleInfo, err = GetModuleInfo(badSource, tfKindString)
 	expectedErr = "Source is not valid: .*"
 	c.Assert(err, ErrorMatches, expectedErr)
@@ -131,16 +133,36 @@ func (s *MySuite) TestGetModuleInfo_Git(c *C) {
 	// Invalid git repository - path does not exists
 	badGitRepo := "github.com:not/exist.git"
 	_, err := GetModuleInfo(badGitRepo, tfKindString)
-	expectedErr := "failed to clone git module at .*"
+	expectedErr := "failed to fetch module at .*"
 	c.Assert(err, ErrorMatches, expectedErr)
 
 	// Invalid: Unsupported Module Source
-	badSource := "gcs::https://www.googleapis.com/storage/v1/GoogleCloudPlatform/hpc-toolkit/modules"
+	badSource := "ftp://example.com/hpc-toolkit/modules.zip"
 	_, err = GetModuleInfo(badSource, tfKindString)
 	expectedErr = "Source is not valid: .*"
 	c.Assert(err, ErrorMatches, expectedErr)
 }
 
+func (s *MySuite) TestGetCachedInfo(c *C) {
+	cacheDir := c.MkDir()
+	os.Setenv(cache.DirEnvVar, cacheDir)
+	cache.Enabled = true
+	defer func() {
+		cache.Enabled = false
+		os.Unsetenv(cache.DirEnvVar)
+	}()
+
+	want, err := getCachedInfo(terraformDir, terraformDir, tfKindString)
+	c.Assert(err, IsNil)
+	entries, err := ioutil.ReadDir(filepath.Join(cacheDir, "info"))
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+
+	got, err := getCachedInfo(terraformDir, terraformDir, tfKindString)
+	c.Assert(err, IsNil)
+	c.Check(got, DeepEquals, want)
+}
+
 func (s *MySuite) TestGetModuleInfo_Local(c *C) {
 
 	// Success
@@ -156,7 +178,7 @@ func (s *MySuite) TestGetModuleInfo_Local(c *C) {
 	c.Assert(err, ErrorMatches, expectedErr)
 
 	// Invalid: Unsupported Module Source
-	badSource := "gcs::https://www.googleapis.com/storage/v1/GoogleCloudPlatform/hpc-toolkit/modules"
+	badSource := "ftp://example.com/hpc-toolkit/modules.zip"
 	moduleInfo, err = GetModuleInfo(badSource, tfKindString)
 	expectedErr = "Source is not valid: .*"
 	c.Assert(err, ErrorMatches, expectedErr)
@@ -192,6 +214,31 @@ func (s *MySuite) TestGetHCLInfo(c *C) {
 	_, err = getHCLInfo(pathToEmptyDir)
 	expectedErr = "Source is not a terraform or packer module: .*"
 	c.Assert(err, ErrorMatches, expectedErr)
+
+	// Required providers are read from required_providers
+	pathToProvidersDir := filepath.Join(tmpModuleDir, "providersModule")
+	c.Assert(os.Mkdir(pathToProvidersDir, 0755), IsNil)
+	versions := `
+terraform {
+  required_providers {
+    random = {
+      source  = "hashicorp/random"
+      version = "~> 3.0"
+    }
+    google = {
+      source  = "hashicorp/google"
+      version = ">= 4.42, < 5.0"
+    }
+  }
+}
+`
+	c.Assert(os.WriteFile(filepath.Join(pathToProvidersDir, "versions.tf"), []byte(versions), 0644), IsNil)
+	info, err := getHCLInfo(pathToProvidersDir)
+	c.Assert(err, IsNil)
+	c.Check(info.RequiredProviders, DeepEquals, []ProviderRequirement{
+		{Name: "google", Source: "hashicorp/google", VersionConstraints: []string{">= 4.42, < 5.0"}},
+		{Name: "random", Source: "hashicorp/random", VersionConstraints: []string{"~> 3.0"}},
+	})
 }
 
 // tfreader.go
@@ -201,7 +248,9 @@ func (s *MySuite) TestGetInfo_TFReder(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(info, DeepEquals, ModuleInfo{
 		Inputs:  []VarInfo{{Name: "test_variable", Type: "string", Description: "This is just a test", Required: true}},
-		Outputs: []OutputInfo{{Name: "test_output", Description: "This is just a test"}},
+		Outputs: []OutputInfo{{Name: "test_output", Description: "This is just a test", Sensitive: true, Type: "string"}},
+		// implied by the data source of main.tf
+		RequiredProviders: []ProviderRequirement{{Name: "test"}},
 	})
 
 }
@@ -221,6 +270,28 @@ func (s *MySuite) TestGetInfo_PackerReader(c *C) {
 	c.Check(infoAgain, DeepEquals, info)
 }
 
+// helmreader.go
+func (s *MySuite) TestGetInfo_HelmReader(c *C) {
+	chart := c.MkDir()
+	reader := NewHelmReader()
+	_, err := reader.GetInfo(chart)
+	c.Check(err, NotNil) // not a chart
+
+	c.Assert(os.WriteFile(filepath.Join(chart, "Chart.yaml"), []byte("name: kueue\nversion: 0.1.0\n"), 0644), IsNil)
+	info, err := reader.GetInfo(chart)
+	c.Assert(err, IsNil)
+	c.Check(info.Inputs, HasLen, 0)
+
+	values := "replicaCount: 1\ncontrollerManager:\n  featureGates: []\n"
+	c.Assert(os.WriteFile(filepath.Join(chart, "values.yaml"), []byte(values), 0644), IsNil)
+	info, err = reader.GetInfo(chart)
+	c.Assert(err, IsNil)
+	c.Check(info.Inputs, DeepEquals, []VarInfo{
+		{Name: "controllerManager", Type: "any", Default: map[string]interface{}{"featureGates": []interface{}{}}},
+		{Name: "replicaCount", Type: "any", Default: 1},
+	})
+}
+
 // metareader.go
 func (s *MySuite) TestGetInfo_MetaReader(c *C) {
 	// Not implemented, expect that error
@@ -355,8 +426,43 @@ func teardownTmpModule() {
 }
 
 func TestMain(m *testing.M) {
+	// do not read or pollute the disk cache of the user
+	cache.Enabled = false
 	createTmpModule()
 	code := m.Run()
 	teardownTmpModule()
 	os.Exit(code)
 }
+
+func (s *MySuite) TestMarkImmutableInputs(c *C) {
+	inputs := []VarInfo{{Name: "zone"}, {Name: "machine_type"}}
+	md := map[string]InputMetadata{"zone": {Immutable: true}, "machine_type": {}}
+	c.Assert(markImmutableInputs("./my-vm", md, inputs), IsNil)
+	c.Check(inputs[0].Immutable, Equals, true)
+	c.Check(inputs[1].Immutable, Equals, false)
+
+	c.Check(markImmutableInputs("./my-vm", map[string]InputMetadata{"region": {Immutable: true}}, inputs),
+		ErrorMatches, ".*input region is not an input of the module.*")
+
+	// the toolkit modules declare their immutable inputs
+	mi, err := GetModuleInfo("../../modules/compute/vm-instance", "terraform")
+	c.Assert(err, IsNil)
+	for _, in := range mi.Inputs {
+		c.Check(in.Immutable, Equals, in.Name == "zone" || in.Name == "disk_type" || in.Name == "instance_image" ||
+			in.Name == "local_ssd_count" || in.Name == "name_prefix", Commentf("input %s", in.Name))
+	}
+}
+
+func (s *MySuite) TestDefaultPermissionList(c *C) {
+	c.Check(defaultPermissionList("modules/file-system/filestore"), DeepEquals, []string{"file.instances.create"})
+	c.Check(defaultPermissionList("modules/compute/vm-instance"), DeepEquals, []string{
+		"compute.disks.create",
+		"compute.instances.create",
+		"compute.instances.setMetadata",
+		"compute.instances.setServiceAccount",
+		"compute.subnetworks.use",
+		"iam.serviceAccounts.actAs",
+		"compute.resourcePolicies.create",
+	})
+	c.Check(defaultPermissionList("./unknown/module"), DeepEquals, []string{})
+}
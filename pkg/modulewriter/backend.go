@@ -0,0 +1,139 @@
+/**
+* Copyright 2022 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BackendMigrationsName is the file in the artifacts directory that records
+// the groups whose state must be migrated to a new Terraform backend before
+// they are deployed
+const BackendMigrationsName = "backend_migrations.yaml"
+
+// backendMetadata is the file where terraform records the backend a working
+// directory was initialized with, which is compared with the configured
+// backend to migrate the state
+var backendMetadata = filepath.Join(".terraform", "terraform.tfstate")
+
+// BackendMigration is a pending migration of the state of a group
+type BackendMigration struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// ReadBackendMigrations reads the pending migrations of the deployment from
+// its artifacts directory
+func ReadBackendMigrations(artifactsDir string) (map[config.GroupName]BackendMigration, error) {
+	m := map[config.GroupName]BackendMigration{}
+	b, err := os.ReadFile(filepath.Join(artifactsDir, BackendMigrationsName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to read the backend migrations of the deployment: %w", err)
+	}
+	if m == nil {
+		m = map[config.GroupName]BackendMigration{}
+	}
+	return m, nil
+}
+
+// WriteBackendMigrations writes the pending migrations of the deployment to
+// its artifacts directory, or removes the file if there are none
+func WriteBackendMigrations(artifactsDir string, m map[config.GroupName]BackendMigration) error {
+	path := filepath.Join(artifactsDir, BackendMigrationsName)
+	if len(m) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// backendMigrations returns the migrations of the deployment being
+// overwritten that are still pending, and those of the groups whose backend
+// is changed by the blueprint
+func backendMigrations(dc config.DeploymentConfig, depDir string) (map[config.GroupName]BackendMigration, error) {
+	artifactsDir := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName)
+	m, err := ReadBackendMigrations(artifactsDir)
+	if err != nil {
+		return nil, err
+	}
+	prevFile := filepath.Join(artifactsDir, expandedBlueprintName)
+	if _, err := os.Stat(prevFile); err != nil {
+		return m, nil
+	}
+	prev, err := config.NewDeploymentConfig(prevFile)
+	if err != nil {
+		log.Printf("warning: could not read the blueprint of the existing deployment, changes to backends are not detected: %v", err)
+		return m, nil
+	}
+
+	for _, c := range dc.Config.BackendChanges(prev.Config, func(g config.GroupName) bool {
+		return isDeployedGroup(filepath.Join(depDir, string(g)))
+	}) {
+		log.Printf("%s; its state will be migrated to the new backend by \"ghpc deploy\"", c)
+		from := c.From
+		if pending, ok := m[c.Group]; ok {
+			// the state was not migrated from the first backend yet
+			from = pending.From
+		}
+		m[c.Group] = BackendMigration{From: from, To: c.To}
+	}
+	return m, nil
+}
+
+// restoreBackendMetadata copies the backend metadata of the groups to migrate
+// from their previous directory, so that terraform knows the backend their
+// state is in
+func restoreBackendMetadata(depDir string, m map[config.GroupName]BackendMigration) error {
+	prevDir := filepath.Join(depDir, HiddenGhpcDirName, prevDeploymentGroupDirName)
+	for g := range m {
+		b, err := os.ReadFile(filepath.Join(prevDir, string(g), backendMetadata))
+		if errors.Is(err, os.ErrNotExist) {
+			// the state was in the local backend
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(depDir, string(g), backendMetadata)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dest, b, 0644); err != nil {
+			return fmt.Errorf("failed to restore the backend of group %s: %w", g, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// backupInfix separates the name of a deployment directory from the time
+	// of its backups, e.g. hpc.backup-20231015-130611
+	backupInfix      = ".backup-"
+	backupTimeLayout = "20060102-150405"
+)
+
+// Backup is a backup of a deployment directory, made when it was overwritten
+type Backup struct {
+	Path string
+	Time time.Time
+}
+
+// BackupDir returns the directory of the backup of the deployment made at t
+func BackupDir(depDir string, t time.Time) string {
+	return filepath.Clean(depDir) + backupInfix + t.UTC().Format(backupTimeLayout)
+}
+
+// Backups returns the backups of the deployment, newest first
+func Backups(depDir string) ([]Backup, error) {
+	prefix := filepath.Clean(depDir) + backupInfix
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	backups := []Backup{}
+	for _, m := range matches {
+		t, err := time.Parse(backupTimeLayout, strings.TrimPrefix(m, prefix))
+		if err != nil {
+			continue // not a backup made by ghpc
+		}
+		if fi, err := os.Stat(m); err != nil || !fi.IsDir() {
+			continue
+		}
+		backups = append(backups, Backup{Path: m, Time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// PruneBackups removes the backups of the deployment but the newest keep; if
+// olderThan is set, only backups older than it are removed. It returns the
+// removed backups.
+func PruneBackups(depDir string, keep int, olderThan time.Duration, now time.Time) ([]Backup, error) {
+	backups, err := Backups(depDir)
+	if err != nil {
+		return nil, err
+	}
+	removed := []Backup{}
+	for i, b := range backups {
+		if i < keep || (olderThan > 0 && now.Sub(b.Time) < olderThan) {
+			continue
+		}
+		if err := os.RemoveAll(b.Path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, b)
+	}
+	return removed, nil
+}
+
+// removeState removes the Terraform state, Terraform working directories and
+// Packer manifests from a backup, which stay with the deployment
+func removeState(dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch d.Name() {
+		case ".terraform":
+			if d.IsDir() {
+				if err := os.RemoveAll(p); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+		case tfStateFileName, tfStateBackupFileName, PackerManifestName:
+			return os.Remove(p)
+		}
+		return nil
+	})
+}
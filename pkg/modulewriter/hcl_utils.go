@@ -38,6 +38,18 @@ func escapeLiteralVariables(s string) string {
 	return re.ReplaceAllString(s, `((`)
 }
 
+// escapeValue applies escapeLiteralVariables and escapeBlueprintVariables to
+// all strings in the value, at any depth
+func escapeValue(v cty.Value) cty.Value {
+	r, _ := cty.Transform(v, func(p cty.Path, v cty.Value) (cty.Value, error) {
+		if v.IsMarked() || v.IsNull() || !v.IsKnown() || v.Type() != cty.String {
+			return v, nil
+		}
+		return cty.StringVal(escapeBlueprintVariables(escapeLiteralVariables(v.AsString()))), nil
+	})
+	return r
+}
+
 // WriteHclAttributes writes tfvars/pkvars.hcl files
 func WriteHclAttributes(vars map[string]cty.Value, dst string) error {
 	if err := createBaseFile(dst); err != nil {
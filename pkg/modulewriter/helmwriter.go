@@ -0,0 +1,147 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"hpc-toolkit/pkg/config"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// HelmValuesFilename is the file, in the directory of a Helm chart, of
+	// the settings of the module
+	HelmValuesFilename = "ghpc-values.yaml"
+	// HelmInputsFilename is the file, in the directory of a Helm chart, of
+	// the settings of the module set from the outputs of earlier groups
+	HelmInputsFilename = "ghpc-inputs.yaml"
+)
+
+// HelmWriter writes Helm charts to the blueprint folder
+type HelmWriter struct {
+	numModules int
+}
+
+func (w *HelmWriter) getNumModules() int {
+	return w.numModules
+}
+
+func (w *HelmWriter) addNumModules(value int) {
+	w.numModules += value
+}
+
+// WriteHelmValues writes the values to a Helm values file
+func WriteHelmValues(values map[string]cty.Value, dst string) error {
+	v := escapeValue(cty.ObjectVal(values))
+	b, err := ctyjson.Marshal(v, v.Type())
+	if err != nil {
+		return err
+	}
+	// JSON is YAML, decoding it sorts the keys
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, append([]byte("# Generated by ghpc, do not edit\n"), out...), 0644)
+}
+
+// helmNamespaceFlag returns the --namespace flag of helm commands for the
+// releases of the group, if it sets a namespace
+func helmNamespaceFlag(g config.DeploymentGroup) string {
+	if g.Namespace == "" {
+		return ""
+	}
+	return " --namespace " + g.Namespace
+}
+
+// printHelmInstructions prints how to install the chart of a Helm module;
+// modules that use outputs of earlier groups are installed by ghpc deploy,
+// which sets their inputs
+func printHelmInstructions(w io.Writer, modPath string, mod config.Module, deployDir string, group config.DeploymentGroup, hasIgc bool) {
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Helm module '%s' was successfully created in directory %s\n", mod.ID, modPath)
+	if hasIgc {
+		fmt.Fprintln(w, "Its inputs are set from the outputs of earlier groups, which must be deployed or exported first. To deploy, run the following command:")
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "ghpc deploy %s --only %s\n", deployDir, group.Name)
+		return
+	}
+	namespace := helmNamespaceFlag(group)
+	if namespace != "" {
+		namespace += " --create-namespace"
+	}
+	fmt.Fprintln(w, "To deploy, run the following command with the credentials of the cluster:")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "helm upgrade --install %s %s%s --values %s\n", config.HelmRelease(mod.ID), modPath,
+		namespace, filepath.Join(modPath, HelmValuesFilename))
+}
+
+// writeDeploymentGroup writes the values of the settings of the modules of
+// a Helm group
+func (w HelmWriter) writeDeploymentGroup(
+	dc config.DeploymentConfig,
+	grpIdx int,
+	deployDir string,
+	instructionsFile io.Writer,
+) error {
+	depGroup := dc.Config.DeploymentGroups[grpIdx]
+	groupPath := filepath.Join(deployDir, string(depGroup.Name))
+
+	for _, mod := range depGroup.Modules {
+		// settings that use outputs of earlier groups are written by
+		// ghpc import-inputs
+		pure := config.Dict{}
+		for setting, v := range mod.Settings.Items() {
+			if len(config.FindIntergroupReferences(v, mod, dc.Config)) == 0 {
+				pure.Set(setting, v)
+			}
+		}
+		values, err := pure.Eval(dc.Config)
+		if err != nil {
+			return err
+		}
+
+		modPath := filepath.Join(groupPath, mod.DeploymentSource)
+		if err := WriteHelmValues(values.Items(), filepath.Join(modPath, HelmValuesFilename)); err != nil {
+			return fmt.Errorf("error writing %s of module %s: %v", HelmValuesFilename, mod.ID, err)
+		}
+		hasIgc := len(pure.Items()) < len(mod.Settings.Items())
+		printHelmInstructions(instructionsFile, modPath, mod, deployDir, depGroup, hasIgc)
+	}
+	return nil
+}
+
+// restoreState does nothing, the state of Helm releases is kept in the
+// cluster
+func (w HelmWriter) restoreState(deploymentDir string) error {
+	return nil
+}
+
+func (w HelmWriter) kind() config.ModuleKind {
+	return config.HelmKind
+}
@@ -0,0 +1,221 @@
+/**
+* Copyright 2023 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestName is the file in the artifacts directory that lists the files
+// written to the deployment directory with their checksums
+const ManifestName = "manifest.yaml"
+
+// Manifest records the files ghpc wrote to a deployment directory
+type Manifest struct {
+	// Files maps the paths of the files, relative to the deployment directory,
+	// to their SHA-256 checksums
+	Files map[string]string `yaml:"files"`
+}
+
+// ReadManifest reads the manifest of the deployment from its artifacts
+// directory, an empty manifest if there is none
+func ReadManifest(artifactsDir string) (Manifest, error) {
+	m := Manifest{Files: map[string]string{}}
+	b, err := os.ReadFile(filepath.Join(artifactsDir, ManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("failed to read the manifest of the deployment: %w", err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]string{}
+	}
+	return m, nil
+}
+
+// buildManifest lists the files of the deployment directory outside of the
+// .ghpc directory; it is called before the state of the groups is restored,
+// when the directory only holds files written by ghpc
+func buildManifest(depDir string) (Manifest, error) {
+	m := Manifest{Files: map[string]string{}}
+	err := filepath.WalkDir(depDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(depDir, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && rel == HiddenGhpcDirName {
+			return filepath.SkipDir
+		}
+		// the .gitignore is only written with a new deployment directory
+		if !d.Type().IsRegular() || rel == ".gitignore" {
+			return nil
+		}
+		sum, err := fileChecksum(p)
+		if err != nil {
+			return err
+		}
+		m.Files[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	return m, err
+}
+
+func fileChecksum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeManifest records the files of the deployment directory in its
+// artifacts directory and returns the manifest
+func writeManifest(depDir string) (Manifest, error) {
+	m, err := buildManifest(depDir)
+	if err != nil {
+		return m, fmt.Errorf("failed to list the files of the deployment: %w", err)
+	}
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+	p := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName, ManifestName)
+	return m, os.WriteFile(p, b, 0644)
+}
+
+// ModifiedFiles returns the files of the manifest of the deployment that were
+// changed or removed since ghpc wrote them, with the change
+func ModifiedFiles(depDir string) ([]string, error) {
+	m, err := ReadManifest(filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName))
+	if err != nil {
+		return nil, err
+	}
+	paths := maps.Keys(m.Files)
+	slices.Sort(paths)
+	modified := []string{}
+	for _, p := range paths {
+		sum, err := fileChecksum(filepath.Join(depDir, filepath.FromSlash(p)))
+		if errors.Is(err, os.ErrNotExist) {
+			modified = append(modified, p+" (removed)")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sum != m.Files[p] {
+			modified = append(modified, p+" (modified)")
+		}
+	}
+	return modified, nil
+}
+
+// warnModifiedFiles warns about the files of the deployment that were edited
+// since ghpc wrote them, before they are overwritten, and returns them
+func warnModifiedFiles(depDir string) []string {
+	modified, err := ModifiedFiles(depDir)
+	if err != nil {
+		log.Printf("warning: could not check the deployment for manual edits: %v", err)
+		return nil
+	}
+	if len(modified) == 0 {
+		return nil
+	}
+	log.Println("warning: these files of the deployment were edited since they were written by ghpc:")
+	for _, f := range modified {
+		log.Printf("  %s", f)
+	}
+	log.Printf("warning: the edits are overwritten; the previous deployment groups are kept in %s",
+		filepath.Join(depDir, HiddenGhpcDirName, prevDeploymentGroupDirName))
+	return modified
+}
+
+// Statuses of the groups of an overwritten deployment
+const (
+	GroupNew       = "new"
+	GroupChanged   = "changed"
+	GroupUnchanged = "unchanged"
+)
+
+// groupFiles returns the files of the manifest in the directory of group
+func (m Manifest) groupFiles(group config.GroupName) map[string]string {
+	files := map[string]string{}
+	for p, sum := range m.Files {
+		if strings.HasPrefix(p, string(group)+"/") {
+			files[p] = sum
+		}
+	}
+	return files
+}
+
+// keepUnchangedGroups moves back the previous directories of the groups whose
+// files are the same as those just written, so that only changed groups are
+// rewritten, and returns the status of each group. Groups with files edited
+// since they were written are rewritten.
+func keepUnchangedGroups(groups []config.DeploymentGroup, depDir string, prev Manifest, cur Manifest, edited []string) (map[config.GroupName]string, error) {
+	prevDir := filepath.Join(depDir, HiddenGhpcDirName, prevDeploymentGroupDirName)
+	status := map[config.GroupName]string{}
+	for _, g := range groups {
+		old := filepath.Join(prevDir, string(g.Name))
+		if _, err := os.Stat(old); err != nil {
+			status[g.Name] = GroupNew
+			continue
+		}
+		prevFiles := prev.groupFiles(g.Name)
+		isEdited := slices.ContainsFunc(edited, func(f string) bool {
+			return strings.HasPrefix(f, string(g.Name)+"/")
+		})
+		if len(prevFiles) == 0 || isEdited || !maps.Equal(prevFiles, cur.groupFiles(g.Name)) {
+			status[g.Name] = GroupChanged
+			continue
+		}
+		dir := filepath.Join(depDir, string(g.Name))
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(old, dir); err != nil {
+			return nil, fmt.Errorf("failed to keep unchanged deployment group %s: %w", g.Name, err)
+		}
+		status[g.Name] = GroupUnchanged
+	}
+	return status, nil
+}
@@ -18,6 +18,7 @@
 package modulewriter
 
 import (
+	"bytes"
 	"crypto/md5"
 	"embed"
 	"encoding/hex"
@@ -25,6 +26,8 @@ import (
 	"fmt"
 	"hpc-toolkit/pkg/config"
 	"hpc-toolkit/pkg/deploymentio"
+	"hpc-toolkit/pkg/errcode"
+	"hpc-toolkit/pkg/offline"
 	"hpc-toolkit/pkg/sourcereader"
 	"io"
 	"io/ioutil"
@@ -32,16 +35,31 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/otiai10/copy"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/slices"
 )
 
 // strings that get re-used throughout this package and others
 const (
 	HiddenGhpcDirName          = ".ghpc"
 	ArtifactsDirName           = "artifacts"
+	LogsDirName                = "logs"
+	UploadsDirName             = "uploads"
 	prevDeploymentGroupDirName = "previous_deployment_groups"
 	gitignoreTemplate          = "deployment.gitignore.tmpl"
 	artifactsWarningFilename   = "DO_NOT_MODIFY_THIS_DIRECTORY"
 	expandedBlueprintName      = "expanded_blueprint.yaml"
+	TerraformCLIConfigName     = "terraform.rc"
+	PackerManifestName         = "packer-manifest.json"
+	// DeployStateFilename is the file in the artifacts directory that records
+	// the deployment groups applied successfully, kept when the deployment is
+	// written again
+	DeployStateFilename = "deploy_state.yaml"
 )
 
 // ModuleWriter interface for writing modules to a deployment
@@ -61,6 +79,7 @@ type ModuleWriter interface {
 var kinds = map[string]ModuleWriter{
 	config.TerraformKind.String(): new(TFWriter),
 	config.PackerKind.String():    new(PackerWriter),
+	config.HelmKind.String():      new(HelmWriter),
 }
 
 //go:embed *.tmpl
@@ -71,7 +90,7 @@ func factory(kind string) ModuleWriter {
 	if !exists {
 		log.Fatalf(
 			"modulewriter: Module kind (%s) is not valid. "+
-				"kind must be in (terraform, packer).", kind)
+				"kind must be in (terraform, packer, helm).", kind)
 	}
 	return writer
 }
@@ -79,60 +98,147 @@ func factory(kind string) ModuleWriter {
 // WriteDeployment writes a deployment directory using modules defined the
 // environment blueprint.
 func WriteDeployment(dc config.DeploymentConfig, outputDir string, overwriteFlag bool) error {
+	return errcode.New(errcode.WriteFailure, writeDeployment(dc, outputDir, overwriteFlag))
+}
+
+func writeDeployment(dc config.DeploymentConfig, outputDir string, overwriteFlag bool) error {
 	deploymentName, err := dc.Config.DeploymentName()
 	if err != nil {
-		return err
+		return errcode.New(errcode.ConfigError, err)
 	}
 	deploymentDir := filepath.Join(outputDir, deploymentName)
 
+	// backing up a deployment overwrites it
+	overwriteFlag = overwriteFlag || dc.Backup
 	overwrite := isOverwriteAllowed(deploymentDir, &dc.Config, overwriteFlag)
-	if err := prepDepDir(deploymentDir, overwrite); err != nil {
-		return err
+	migrations := map[config.GroupName]BackendMigration{}
+	prevManifest, edited := Manifest{}, []string{}
+	if overwrite {
+		if err := checkImmutableSettings(dc, deploymentDir); err != nil {
+			return err
+		}
+		if migrations, err = backendMigrations(dc, deploymentDir); err != nil {
+			return err
+		}
+		edited = warnModifiedFiles(deploymentDir)
+		if prevManifest, err = ReadManifest(filepath.Join(deploymentDir, HiddenGhpcDirName, ArtifactsDirName)); err != nil {
+			return err
+		}
 	}
 
-	if err := copySource(deploymentDir, &dc.Config.DeploymentGroups); err != nil {
+	// The deployment is written to a staging directory, a copy of the
+	// existing deployment if it is overwritten, which then replaces it
+	stagingDir, err := stageDeployment(deploymentDir, overwrite)
+	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(stagingDir)
 
-	if err := createGroupDirs(deploymentDir, &dc.Config.DeploymentGroups); err != nil {
+	if err := prepDepDir(stagingDir, overwrite); err != nil {
 		return err
 	}
 
-	advancedDeployInstructions := filepath.Join(deploymentDir, "instructions.txt")
-	f, err := os.Create(advancedDeployInstructions)
-	if err != nil {
+	if err := copySource(stagingDir, &dc.Config.DeploymentGroups); err != nil {
+		return errcode.New(errcode.SourceFetchFailure, err)
+	}
+
+	if err := createGroupDirs(stagingDir, &dc.Config.DeploymentGroups); err != nil {
 		return err
 	}
-	defer f.Close()
+
+	// The instructions name the paths of the staging directory, which are
+	// replaced with those of the deployment directory when they are written
+	f := new(bytes.Buffer)
 	fmt.Fprintln(f, "Advanced Deployment Instructions")
 	fmt.Fprintln(f, "================================")
 
+	cliConfig, err := writeTerraformCLIConfig(stagingDir)
+	if err != nil {
+		return err
+	}
+	if cliConfig != "" {
+		fmt.Fprintln(f)
+		fmt.Fprintln(f, "Terraform must install providers from the offline mirror:")
+		fmt.Fprintln(f)
+		fmt.Fprintf(f, "export TF_CLI_CONFIG_FILE=%s\n", cliConfig)
+	}
+
 	for grpIdx, grp := range dc.Config.DeploymentGroups {
 		writer, ok := kinds[grp.Kind.String()]
 		if !ok {
 			return fmt.Errorf(
 				"invalid kind in deployment group %s, got '%s'", grp.Name, grp.Kind)
 		}
+		if len(dc.Config.ArtifactsOf(grp.Name)) > 0 {
+			fmt.Fprintln(f)
+			fmt.Fprintf(f, "Upload the artifacts of group '%s' before deploying it:\n", grp.Name)
+			fmt.Fprintf(f, "ghpc upload-artifacts %s --group %s\n", stagingDir, grp.Name)
+		}
 
-		err := writer.writeDeploymentGroup(dc, grpIdx, deploymentDir, f)
+		err := writer.writeDeploymentGroup(dc, grpIdx, stagingDir, f)
 		if err != nil {
 			return fmt.Errorf("error writing deployment group %s: %w", grp.Name, err)
 		}
 	}
 
-	writeDestroyInstructions(f, dc, deploymentDir)
+	if dc.Terragrunt && slices.ContainsFunc(dc.Config.DeploymentGroups, func(g config.DeploymentGroup) bool {
+		return g.Kind == config.TerraformKind
+	}) {
+		writeTerragruntInstructions(f, stagingDir)
+	}
+
+	writeDestroyInstructions(f, dc, stagingDir)
+
+	advancedDeployInstructions := filepath.Join(deploymentDir, "instructions.txt")
+	if err := writeInstructions(f.String(), stagingDir, deploymentDir); err != nil {
+		return err
+	}
+
+	if err := writeExpandedBlueprint(stagingDir, dc); err != nil {
+		return err
+	}
 
-	if err := writeExpandedBlueprint(deploymentDir, dc); err != nil {
+	// Record the written files before the state of the groups is restored
+	manifest, err := writeManifest(stagingDir)
+	if err != nil {
 		return err
 	}
+	if overwrite {
+		status, err := keepUnchangedGroups(dc.Config.DeploymentGroups, stagingDir, prevManifest, manifest, edited)
+		if err != nil {
+			return err
+		}
+		for _, g := range dc.Config.DeploymentGroups {
+			fmt.Printf("Deployment group %s: %s\n", g.Name, status[g.Name])
+		}
+		fmt.Println()
+	}
 
 	for _, writer := range kinds {
 		if writer.getNumModules() > 0 {
-			if err := writer.restoreState(deploymentDir); err != nil {
+			if err := writer.restoreState(stagingDir); err != nil {
 				return fmt.Errorf("error trying to restore terraform state: %w", err)
 			}
 		}
 	}
+	if err := restoreBackendMetadata(stagingDir, migrations); err != nil {
+		return err
+	}
+	artifactsDir := filepath.Join(stagingDir, HiddenGhpcDirName, ArtifactsDirName)
+	if err := WriteBackendMigrations(artifactsDir, migrations); err != nil {
+		return err
+	}
+
+	backupDir := ""
+	if dc.Backup && overwrite {
+		backupDir = BackupDir(deploymentDir, time.Now())
+	}
+	if err := swapDeployment(stagingDir, deploymentDir, backupDir); err != nil {
+		return err
+	}
+	if backupDir != "" {
+		fmt.Printf("The previous deployment, without its state, was moved to %s\n\n", backupDir)
+	}
 
 	fmt.Println("To deploy your infrastructure please run:")
 	fmt.Println()
@@ -141,11 +247,90 @@ func WriteDeployment(dc config.DeploymentConfig, outputDir string, overwriteFlag
 	fmt.Println("Find instructions for cleanly destroying infrastructure and advanced manual")
 	fmt.Println("deployment instructions at:")
 	fmt.Println()
-	fmt.Printf("%s\n", f.Name())
+	fmt.Printf("%s\n", advancedDeployInstructions)
 
 	return nil
 }
 
+// stageDeployment returns a new staging directory next to the deployment
+// directory, holding a copy of the existing deployment if it is overwritten;
+// the staging directory does not exist yet for a new deployment
+func stageDeployment(depDir string, overwrite bool) (string, error) {
+	if _, err := os.Stat(depDir); err == nil && !overwrite {
+		return "", &OverwriteDeniedError{fmt.Errorf("The directory already exists: %s", depDir)}
+	}
+	if err := os.MkdirAll(filepath.Dir(depDir), 0755); err != nil {
+		return "", err
+	}
+	stagingDir, err := os.MkdirTemp(filepath.Dir(depDir), "."+filepath.Base(depDir)+".staging-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the staging directory of the deployment: %w", err)
+	}
+	// cleaned like the paths the instructions are written with, e.g. without
+	// the "./" of a deployment in the working directory
+	stagingDir = filepath.Clean(stagingDir)
+	if err := os.Remove(stagingDir); err != nil {
+		return "", err
+	}
+	if overwrite {
+		if err := copy.Copy(depDir, stagingDir, copy.Options{PreserveTimes: true}); err != nil {
+			os.RemoveAll(stagingDir)
+			return "", fmt.Errorf("failed to copy the deployment to the staging directory %s: %w", stagingDir, err)
+		}
+	}
+	return stagingDir, nil
+}
+
+// writeInstructions writes the instructions of the deployment, with the paths
+// of the staging directory replaced by those of the deployment directory
+func writeInstructions(text string, stagingDir string, depDir string) error {
+	stagingAbs, err := filepath.Abs(stagingDir)
+	if err != nil {
+		return err
+	}
+	depAbs, err := filepath.Abs(depDir)
+	if err != nil {
+		return err
+	}
+	text = strings.ReplaceAll(text, stagingAbs, depAbs)
+	text = strings.ReplaceAll(text, stagingDir, depDir)
+	return os.WriteFile(filepath.Join(stagingDir, "instructions.txt"), []byte(text), 0644)
+}
+
+// swapDeployment replaces the deployment directory with the staging
+// directory. The previous deployment directory is renamed out of the way
+// first and removed last, or moved without its state to backupDir if set, so
+// that an interruption leaves either deployment whole: the previous one is
+// restored if the staging directory cannot be moved in place.
+func swapDeployment(stagingDir string, depDir string, backupDir string) error {
+	old := ""
+	if _, err := os.Stat(depDir); err == nil {
+		old = stagingDir + ".previous"
+		if err := os.Rename(depDir, old); err != nil {
+			return fmt.Errorf("failed to move the previous deployment out of the way: %w", err)
+		}
+	}
+	if err := os.Rename(stagingDir, depDir); err != nil {
+		if old != "" {
+			os.Rename(old, depDir)
+		}
+		return fmt.Errorf("failed to move the deployment in place: %w", err)
+	}
+	if old == "" {
+		return nil
+	}
+	if backupDir == "" {
+		return os.RemoveAll(old)
+	}
+	if err := removeState(old); err != nil {
+		return fmt.Errorf("failed to remove the state from the backup of the deployment at %s: %w", old, err)
+	}
+	if err := os.Rename(old, backupDir); err != nil {
+		return fmt.Errorf("failed to move the backup of the deployment from %s: %w", old, err)
+	}
+	return nil
+}
+
 func createGroupDirs(deploymentPath string, deploymentGroups *[]config.DeploymentGroup) error {
 	for _, grp := range *deploymentGroups {
 		groupPath := filepath.Join(deploymentPath, string(grp.Name))
@@ -162,19 +347,22 @@ func createGroupDirs(deploymentPath string, deploymentGroups *[]config.Deploymen
 
 // Get module source within deployment group
 // Rules are following:
-//   - git source
+//   - remote source (git, http archive, S3, GCS), fetched by terraform
 //     => keep the same source
-//   - packer
+//   - remote source with checksum, which terraform cannot verify, or with
+//     credentials configured in ghpc, or any remote or registry source offline
+//     => ./modules/<basename(source)>-<hash(source)>
+//   - packer or helm
 //     => <mod.ID>
 //   - embedded (source starts with "modules" or "comunity/modules")
 //     => ./modules/embedded/<source>
 //   - other
 //     => ./modules/<basename(source)>-<hash(abs(source))>
 func deploymentSource(mod config.Module) (string, error) {
-	if sourcereader.IsGitPath(mod.Source) && mod.Kind == config.TerraformKind {
+	if isPassthroughSource(mod) {
 		return mod.Source, nil
 	}
-	if mod.Kind == config.PackerKind {
+	if mod.Kind == config.PackerKind || mod.Kind == config.HelmKind {
 		return string(mod.ID), nil
 	}
 	if mod.Kind != config.TerraformKind {
@@ -184,6 +372,10 @@ func deploymentSource(mod config.Module) (string, error) {
 	if sourcereader.IsEmbeddedPath(mod.Source) {
 		return "./modules/" + filepath.Join("embedded", mod.Source), nil
 	}
+	if sourcereader.IsRemotePath(mod.Source) || sourcereader.IsRegistryPath(mod.Source) {
+		modPath := sourcereader.VersionedSource(mod.Source, mod.Version)
+		return fmt.Sprintf("./modules/%s-%s", remoteBase(mod.Source), shortHash(modPath)), nil
+	}
 	if !sourcereader.IsLocalPath(mod.Source) {
 		return "", fmt.Errorf("unuexpected module source %s", mod.Source)
 	}
@@ -196,6 +388,33 @@ func deploymentSource(mod config.Module) (string, error) {
 	return fmt.Sprintf("./modules/%s-%s", base, shortHash(abs)), nil
 }
 
+// isPassthroughSource checks if terraform can fetch the module source itself;
+// sources with checksums or with credentials configured in ghpc are not.
+// Terraform Registry modules are always fetched by terraform, at the version
+// pinned by ghpc. Offline, all modules are copied from the mirror, and modules
+// with an integrity are copied so that they can be verified.
+func isPassthroughSource(mod config.Module) bool {
+	if mod.Kind != config.TerraformKind || offline.Enabled || mod.Integrity != nil {
+		return false
+	}
+	if sourcereader.IsRegistryPath(mod.Source) {
+		return true
+	}
+	return sourcereader.IsRemotePath(mod.Source) &&
+		!sourcereader.HasChecksum(mod.Source) &&
+		!sourcereader.HasCredentials(mod.Source)
+}
+
+// remoteBase returns the name of the module directory or archive of a remote source
+func remoteBase(source string) string {
+	p, _, _ := strings.Cut(source, "?")
+	base := path.Base(p)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".zip"} {
+		base = strings.TrimSuffix(base, ext)
+	}
+	return base
+}
+
 // Returns first 4 characters of md5 sum in hex form
 func shortHash(s string) string {
 	h := md5.Sum([]byte(s))
@@ -217,7 +436,17 @@ func copyEmbeddedModules(base string) error {
 	return nil
 }
 
+// copySource copies the sources of the modules to the deployment. Remote
+// sources are fetched concurrently, by the same bounded pool of workers as the
+// module metadata.
 func copySource(deploymentPath string, deploymentGroups *[]config.DeploymentGroup) error {
+	type fetch struct {
+		mod *config.Module
+		dst string
+	}
+	fetches := []fetch{}
+	fetched := map[string]bool{}
+	verify := []fetch{}
 	for iGrp := range *deploymentGroups {
 		grp := &(*deploymentGroups)[iGrp]
 		basePath := filepath.Join(deploymentPath, string(grp.Name))
@@ -231,30 +460,65 @@ func copySource(deploymentPath string, deploymentGroups *[]config.DeploymentGrou
 			}
 			mod.DeploymentSource = ds
 
-			if sourcereader.IsGitPath(mod.Source) && mod.Kind == config.TerraformKind {
+			if isPassthroughSource(*mod) {
 				continue // do not download
 			}
+			dst := filepath.Join(basePath, mod.DeploymentSource)
 			factory(mod.Kind.String()).addNumModules(1)
 			if sourcereader.IsEmbeddedPath(mod.Source) && mod.Kind == config.TerraformKind {
 				copyEmbedded = true
+				if mod.Integrity != nil {
+					verify = append(verify, fetch{mod, dst})
+				}
 				continue // all embedded terraform modules fill be copied at once
 			}
-			/* Copy source files */
-			dst := filepath.Join(basePath, mod.DeploymentSource)
-			if _, err := os.Stat(dst); err == nil {
+			if _, err := os.Stat(dst); err == nil || fetched[dst] {
+				if mod.Integrity != nil {
+					verify = append(verify, fetch{mod, dst})
+				}
 				continue
 			}
-			reader := sourcereader.Factory(mod.Source)
-			if err := reader.GetModule(mod.Source, dst); err != nil {
-				return fmt.Errorf("failed to get module from %s to %s: %v", mod.Source, dst, err)
-			}
+			fetched[dst] = true
+			fetches = append(fetches, fetch{mod, dst})
 		}
 		if copyEmbedded {
 			if err := copyEmbeddedModules(basePath); err != nil {
 				return fmt.Errorf("failed to copy embedded modules: %v", err)
 			}
 		}
+	}
+
+	/* Copy source files, modules with integrity are verified before they are
+	cached or copied */
+	errs := config.RunModuleWorkers(len(fetches), func(i int) error {
+		f := fetches[i]
+		modPath := sourcereader.VersionedSource(f.mod.Source, f.mod.Version)
+		if f.mod.Integrity != nil {
+			if err := sourcereader.GetVerifiedModule(modPath, f.dst, *f.mod.Integrity); err != nil {
+				return fmt.Errorf("failed to get module %s from %s to %s: %w", f.mod.ID, f.mod.Source, f.dst, err)
+			}
+			return nil
+		}
+		if err := sourcereader.Factory(modPath).GetModule(modPath, f.dst); err != nil {
+			return fmt.Errorf("failed to get module from %s to %s: %v", f.mod.Source, f.dst, err)
+		}
+		return nil
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 
+	// embedded modules and modules already in the deployment are verified in
+	// place, embedded modules that fail are removed from the deployment
+	for _, f := range verify {
+		if err := f.mod.Integrity.Verify(f.dst); err != nil {
+			if sourcereader.IsEmbeddedPath(f.mod.Source) {
+				os.RemoveAll(f.dst)
+			}
+			return fmt.Errorf("failed to verify integrity of module %s from %s: %w", f.mod.ID, f.mod.Source, err)
+		}
 	}
 	return nil
 }
@@ -373,6 +637,14 @@ func prepDepDir(depDir string, overwrite bool) error {
 }
 
 func prepArtifactsDir(artifactsDir string) error {
+	// the deployed groups are still deployed once the deployment is written
+	// again, keep their state
+	statePath := filepath.Join(artifactsDir, DeployStateFilename)
+	state, err := os.ReadFile(statePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read the deployment state at %s: %w", statePath, err)
+	}
+
 	// cleanup previous artifacts on every write
 	if err := os.RemoveAll(artifactsDir); err != nil {
 		return fmt.Errorf(
@@ -382,6 +654,11 @@ func prepArtifactsDir(artifactsDir string) error {
 	if err := os.MkdirAll(artifactsDir, 0700); err != nil {
 		return err
 	}
+	if state != nil {
+		if err := os.WriteFile(statePath, state, 0644); err != nil {
+			return err
+		}
+	}
 
 	artifactsWarningFile := path.Join(artifactsDir, artifactsWarningFilename)
 	f, err := os.Create(artifactsWarningFile)
@@ -397,6 +674,84 @@ func prepArtifactsDir(artifactsDir string) error {
 	return nil
 }
 
+// isDeployedGroup checks if terraform has been initialized or applied in the
+// deployment group directory
+func isDeployedGroup(groupDir string) bool {
+	for _, f := range []string{"terraform.tfstate", ".terraform"} {
+		if _, err := os.Stat(filepath.Join(groupDir, f)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImmutableSettings compares the blueprint with the expanded blueprint of
+// the deployment being overwritten and reports changes to settings that would
+// recreate resources of deployed groups, according to the validation level
+func checkImmutableSettings(dc config.DeploymentConfig, depDir string) error {
+	if dc.Config.ValidationLevel == config.ValidationIgnore {
+		return nil
+	}
+	prevFile := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName, expandedBlueprintName)
+	if _, err := os.Stat(prevFile); err != nil {
+		return nil
+	}
+	prev, err := config.NewDeploymentConfig(prevFile)
+	if err != nil {
+		log.Printf("warning: could not read the blueprint of the existing deployment, changes to immutable settings are not checked: %v", err)
+		return nil
+	}
+
+	changes := dc.Config.ImmutableChanges(prev.Config, func(g config.GroupName) bool {
+		return isDeployedGroup(filepath.Join(depDir, string(g)))
+	})
+	if len(changes) == 0 {
+		return nil
+	}
+
+	prefix := "error: "
+	if dc.Config.ValidationLevel == config.ValidationWarning {
+		prefix = "warning: "
+	}
+	for _, c := range changes {
+		log.Print(prefix, c)
+	}
+	log.Println("Applying these changes to the existing deployment will destroy and recreate")
+	log.Println("the affected resources. Review the terraform plan before applying it.")
+	if dc.Config.ValidationLevel == config.ValidationWarning {
+		return nil
+	}
+	return errcode.New(errcode.ValidationFailure, fmt.Errorf(
+		"%d immutable settings of the existing deployment were changed; use \"--validation-level WARNING\" to proceed anyway",
+		len(changes)))
+}
+
+// writeTerraformCLIConfig writes, for offline deployments, a Terraform CLI
+// configuration that installs providers from the filesystem mirror of the
+// offline mirror and returns its absolute path; otherwise it removes any
+// previously written configuration and returns an empty path
+func writeTerraformCLIConfig(depDir string) (string, error) {
+	cfgPath, err := filepath.Abs(filepath.Join(depDir, HiddenGhpcDirName, TerraformCLIConfigName))
+	if err != nil {
+		return "", err
+	}
+	if !offline.Enabled {
+		if err := os.Remove(cfgPath); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return "", nil
+	}
+
+	hclFile := hclwrite.NewEmptyFile()
+	pi := hclFile.Body().AppendNewBlock("provider_installation", nil).Body()
+	pi.AppendNewBlock("filesystem_mirror", nil).Body().SetAttributeValue("path", cty.StringVal(offline.ProvidersDir()))
+	b := append([]byte("# Written by ghpc for offline deployments, use with TF_CLI_CONFIG_FILE\n"), hclFile.Bytes()...)
+	if err := os.WriteFile(cfgPath, b, 0644); err != nil {
+		return "", fmt.Errorf("failed to write Terraform CLI configuration %s: %w", cfgPath, err)
+	}
+	return cfgPath, nil
+}
+
 func writeExpandedBlueprint(depDir string, dc config.DeploymentConfig) error {
 	artifactsDir := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName)
 	blueprintFile := filepath.Join(artifactsDir, expandedBlueprintName)
@@ -424,9 +779,15 @@ func writeDestroyInstructions(w io.Writer, dc config.DeploymentConfig, deploymen
 		if grp.Kind == config.TerraformKind {
 			fmt.Fprintf(w, "terraform -chdir=%s destroy\n", grpPath)
 		}
+		if grp.Kind == config.HelmKind {
+			for i := len(grp.Modules) - 1; i >= 0; i-- {
+				fmt.Fprintf(w, "helm uninstall %s%s\n", config.HelmRelease(grp.Modules[i].ID), helmNamespaceFlag(grp))
+			}
+		}
 		if grp.Kind == config.PackerKind {
-			packerManifests = append(packerManifests, filepath.Join(grpPath, string(grp.Modules[0].ID), "packer-manifest.json"))
-
+			for _, mod := range grp.Modules {
+				packerManifests = append(packerManifests, filepath.Join(grpPath, string(mod.ID), PackerManifestName))
+			}
 		}
 	}
 
@@ -19,9 +19,12 @@ package modulewriter
 import (
 	"errors"
 	"fmt"
+	"hpc-toolkit/pkg/cache"
 	"hpc-toolkit/pkg/config"
 	"hpc-toolkit/pkg/deploymentio"
+	"hpc-toolkit/pkg/errcode"
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/offline"
 	"hpc-toolkit/pkg/sourcereader"
 	"io/ioutil"
 	"log"
@@ -76,6 +79,8 @@ func teardown() {
 // Test Data Producers
 func getDeploymentConfigForTest() config.DeploymentConfig {
 	testModuleSource := filepath.Join(testDir, terraformModuleDir)
+	// the dummy module is empty, it requires no providers
+	modulereader.SetModuleInfo(testModuleSource, config.TerraformKind.String(), modulereader.ModuleInfo{})
 	testModule := config.Module{
 		Source: testModuleSource,
 		Kind:   config.TerraformKind,
@@ -187,6 +192,24 @@ func (s *MySuite) TestPrepDepDir_OverwriteRealDep(c *C) {
 	c.Check(len(files2), Equals, 3) // .ghpc, .gitignore, and instructions file
 }
 
+func (s *MySuite) TestWriteDeployment_OverwriteKeepsDeployState(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_keep_state"))
+	artifactsDir := filepath.Join(testDir, "test_keep_state", HiddenGhpcDirName, ArtifactsDirName)
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwrite */), IsNil)
+
+	state := []byte("applied:\n  primary: 2023-01-01T00:00:00Z\n")
+	c.Assert(os.WriteFile(filepath.Join(artifactsDir, DeployStateFilename), state, 0644), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(artifactsDir, "stale"), []byte{}, 0644), IsNil)
+
+	c.Assert(WriteDeployment(testDC, testDir, true /* overwrite */), IsNil)
+	got, err := os.ReadFile(filepath.Join(artifactsDir, DeployStateFilename))
+	c.Assert(err, IsNil)
+	c.Check(string(got), Equals, string(state))
+	_, err = os.Stat(filepath.Join(artifactsDir, "stale"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
 func (s *MySuite) TestIsSubset(c *C) {
 	baseConfig := []string{"group1", "group2", "group3"}
 	subsetConfig := []string{"group1", "group2"}
@@ -228,6 +251,15 @@ func (s *MySuite) TestIsOverwriteAllowed(c *C) {
 }
 
 // modulewriter.go
+func (s *MySuite) TestIsDeployedGroup(c *C) {
+	grpDir := filepath.Join(testDir, "deployed_test", "group1")
+	os.MkdirAll(grpDir, 0755)
+	c.Check(isDeployedGroup(grpDir), Equals, false)
+
+	os.MkdirAll(filepath.Join(grpDir, ".terraform"), 0755)
+	c.Check(isDeployedGroup(grpDir), Equals, true)
+}
+
 func (s *MySuite) TestWriteDeployment(c *C) {
 	aferoFS := afero.NewMemMapFs()
 	aferoFS.MkdirAll("modules/red/pink", 0755)
@@ -249,6 +281,268 @@ func (s *MySuite) TestWriteDeployment(c *C) {
 	c.Check(err, IsNil)
 }
 
+func (s *MySuite) TestWriteDeployment_SecretVars(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_secrets"))
+	testDC.Config.Vars.Set("db_password", cty.StringVal("$(secret.projects/p/secrets/db/versions/latest)"))
+	testDC.Config.DeploymentGroups[0].Modules[0].Settings.Set(
+		"db_password", config.GlobalRef("db_password").AsExpression().AsValue())
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	groupDir := filepath.Join(testDir, "test_write_secrets", "test_resource_group")
+
+	// the secret is passed to Terraform when it is run, never written
+	exists, err := stringExistsInFile("db_password", filepath.Join(groupDir, "terraform.tfvars"))
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, false)
+	exists, err = stringExistsInFile("sensitive   = true", filepath.Join(groupDir, "variables.tf"))
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, true)
+}
+
+func (s *MySuite) TestWriteDeployment_RemoteOutputVars(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_remote_outputs"))
+	ref := config.RemoteOutputRef{Source: "gs://bucket/network", Output: "peers"}
+	testDC.Config.Vars.Set("peers", cty.StringVal(ref.String()))
+	testDC.Config.DeploymentVariables = map[string]config.VariableDeclaration{
+		"peers": {Type: "list(string)", Sensitive: true},
+	}
+	testDC.Config.DeploymentGroups[0].Modules[0].Settings.Set(
+		"peers", config.GlobalRef("peers").AsExpression().AsValue())
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	groupDir := filepath.Join(testDir, "test_write_remote_outputs", "test_resource_group")
+
+	// the output is passed to Terraform when it is run, never written
+	exists, err := stringExistsInFile("peers", filepath.Join(groupDir, "terraform.tfvars"))
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, false)
+	exists, err = stringExistsInFile("list(string)", filepath.Join(groupDir, "variables.tf"))
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, true)
+	exists, err = stringExistsInFile("sensitive   = true", filepath.Join(groupDir, "variables.tf"))
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, true)
+}
+
+func (s *MySuite) TestWriteDeployment_Manifest(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_manifest"))
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	depDir := filepath.Join(testDir, "test_write_manifest")
+
+	m, err := ReadManifest(filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName))
+	c.Assert(err, IsNil)
+	mainTf := "test_resource_group/main.tf"
+	sum, err := fileChecksum(filepath.Join(depDir, filepath.FromSlash(mainTf)))
+	c.Assert(err, IsNil)
+	c.Check(m.Files[mainTf], Equals, sum)
+	_, ok := m.Files[".gitignore"]
+	c.Check(ok, Equals, false)
+	for f := range m.Files {
+		c.Check(strings.HasPrefix(f, HiddenGhpcDirName), Equals, false)
+	}
+
+	modified, err := ModifiedFiles(depDir)
+	c.Assert(err, IsNil)
+	c.Check(modified, HasLen, 0)
+
+	// files written by terraform are not part of the deployment
+	c.Assert(os.WriteFile(filepath.Join(depDir, "test_resource_group", "terraform.tfstate"), []byte("{}"), 0644), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(depDir, filepath.FromSlash(mainTf)), []byte("# edited"), 0644), IsNil)
+	c.Assert(os.Remove(filepath.Join(depDir, "instructions.txt")), IsNil)
+	modified, err = ModifiedFiles(depDir)
+	c.Assert(err, IsNil)
+	c.Check(modified, DeepEquals, []string{"instructions.txt (removed)", mainTf + " (modified)"})
+
+	// the manifest lists the files written by the latest create
+	c.Assert(WriteDeployment(testDC, testDir, true /* overwriteFlag */), IsNil)
+	modified, err = ModifiedFiles(depDir)
+	c.Assert(err, IsNil)
+	c.Check(modified, HasLen, 0)
+	m, err = ReadManifest(filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName))
+	c.Assert(err, IsNil)
+	_, ok = m.Files["test_resource_group/terraform.tfstate"]
+	c.Check(ok, Equals, false)
+}
+
+func (s *MySuite) TestWriteDeployment_KeepsUnchangedGroups(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_unchanged"))
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	groupDir := filepath.Join(testDir, "test_write_unchanged", "test_resource_group")
+	marker := filepath.Join(groupDir, ".terraform", "marker")
+	c.Assert(os.MkdirAll(filepath.Dir(marker), 0755), IsNil)
+	c.Assert(os.WriteFile(marker, []byte("initialized"), 0644), IsNil)
+
+	// the directory of an unchanged group is kept as it was
+	c.Assert(WriteDeployment(testDC, testDir, true /* overwriteFlag */), IsNil)
+	_, err := os.Stat(marker)
+	c.Check(err, IsNil)
+
+	// groups with edited files are rewritten
+	mainTf := filepath.Join(groupDir, "main.tf")
+	c.Assert(os.WriteFile(mainTf, []byte("# edited"), 0644), IsNil)
+	c.Assert(WriteDeployment(testDC, testDir, true /* overwriteFlag */), IsNil)
+	exists, err := stringExistsInFile("# edited", mainTf)
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, false)
+	_, err = os.Stat(marker)
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// changed groups are rewritten
+	c.Assert(os.MkdirAll(filepath.Dir(marker), 0755), IsNil)
+	c.Assert(os.WriteFile(marker, []byte("initialized"), 0644), IsNil)
+	testDC.Config.DeploymentGroups[0].Modules[1].Settings.Set("moduleLabel", cty.StringVal("changed"))
+	c.Assert(WriteDeployment(testDC, testDir, true /* overwriteFlag */), IsNil)
+	exists, err = stringExistsInFile("changed", mainTf)
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, true)
+	_, err = os.Stat(marker)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *MySuite) TestWriteDeployment_Atomic(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_atomic"))
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	depDir := filepath.Join(testDir, "test_write_atomic")
+	mainTf := filepath.Join(depDir, "test_resource_group", "main.tf")
+	before, err := os.ReadFile(mainTf)
+	c.Assert(err, IsNil)
+
+	// a failed write leaves the deployment as it was
+	broken := getDeploymentConfigForTest()
+	broken.Config.Vars.Set("deployment_name", cty.StringVal("test_write_atomic"))
+	broken.Config.DeploymentGroups[0].Modules[1].Settings.Set("moduleLabel", cty.StringVal("changed"))
+	broken.Config.DeploymentGroups = append(broken.Config.DeploymentGroups,
+		config.DeploymentGroup{Name: "broken", Kind: config.UnknownKind})
+	c.Assert(WriteDeployment(broken, testDir, true /* overwriteFlag */), NotNil)
+	after, err := os.ReadFile(mainTf)
+	c.Assert(err, IsNil)
+	c.Check(string(after), Equals, string(before))
+	staging, err := filepath.Glob(filepath.Join(testDir, ".test_write_atomic.staging-*"))
+	c.Assert(err, IsNil)
+	c.Check(staging, HasLen, 0)
+
+	// the instructions name the deployment directory, not the staging one
+	c.Assert(WriteDeployment(testDC, testDir, true /* overwriteFlag */), IsNil)
+	b, err := os.ReadFile(filepath.Join(depDir, "instructions.txt"))
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(b), "staging"), Equals, false)
+	c.Check(strings.Contains(string(b), filepath.Join(depDir, "test_resource_group")), Equals, true)
+
+	// also for a deployment in the working directory
+	wd, err := os.Getwd()
+	c.Assert(err, IsNil)
+	defer os.Chdir(wd)
+	c.Assert(os.Chdir(testDir), IsNil)
+	c.Assert(WriteDeployment(testDC, ".", true /* overwriteFlag */), IsNil)
+	b, err = os.ReadFile(filepath.Join(depDir, "instructions.txt"))
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(b), "staging"), Equals, false)
+	c.Check(strings.Contains(string(b), "test_write_atomic/test_resource_group"), Equals, true)
+}
+
+func (s *MySuite) TestWriteDeployment_Backup(c *C) {
+	testDC := getDeploymentConfigForTest()
+	testDC.Config.Vars.Set("deployment_name", cty.StringVal("test_write_backup"))
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	depDir := filepath.Join(testDir, "test_write_backup")
+	state := filepath.Join(depDir, "test_resource_group", "terraform.tfstate")
+	c.Assert(os.WriteFile(state, []byte("{}"), 0644), IsNil)
+
+	// backing up overwrites the deployment without -w
+	testDC.Backup = true
+	c.Assert(WriteDeployment(testDC, testDir, false /* overwriteFlag */), IsNil)
+	backups, err := Backups(depDir)
+	c.Assert(err, IsNil)
+	c.Assert(backups, HasLen, 1)
+
+	// the state stays with the deployment
+	_, err = os.Stat(state)
+	c.Check(err, IsNil)
+	_, err = os.Stat(filepath.Join(backups[0].Path, "test_resource_group", "main.tf"))
+	c.Check(err, IsNil)
+	_, err = os.Stat(filepath.Join(backups[0].Path, "test_resource_group", "terraform.tfstate"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *MySuite) TestPruneBackups(c *C) {
+	dir := c.MkDir()
+	depDir := filepath.Join(dir, "hpc")
+	now := time.Date(2023, 10, 15, 12, 0, 0, 0, time.UTC)
+	paths := []string{}
+	for _, age := range []time.Duration{time.Hour, 24 * time.Hour, 48 * time.Hour, 72 * time.Hour} {
+		p := BackupDir(depDir, now.Add(-age))
+		c.Assert(os.MkdirAll(p, 0755), IsNil)
+		paths = append(paths, p)
+	}
+	// not backups made by ghpc
+	c.Assert(os.MkdirAll(depDir+".backup-latest", 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "other.backup-20231015-110000"), 0755), IsNil)
+
+	backups, err := Backups(depDir)
+	c.Assert(err, IsNil)
+	got := []string{}
+	for _, b := range backups {
+		got = append(got, b.Path)
+	}
+	c.Check(got, DeepEquals, paths)
+
+	removed, err := PruneBackups(depDir, 1, 36*time.Hour, now)
+	c.Assert(err, IsNil)
+	c.Check(removed, HasLen, 2)
+	backups, err = Backups(depDir)
+	c.Assert(err, IsNil)
+	c.Check(backups, HasLen, 2)
+
+	removed, err = PruneBackups(depDir, 0, 0, now)
+	c.Assert(err, IsNil)
+	c.Check(removed, HasLen, 2)
+	_, err = os.Stat(depDir + ".backup-latest")
+	c.Check(err, IsNil)
+}
+
+func (s *MySuite) TestKeepUnchangedGroups(c *C) {
+	depDir := c.MkDir()
+	prevDir := filepath.Join(depDir, HiddenGhpcDirName, prevDeploymentGroupDirName)
+	for _, g := range []string{"same", "edited", "changed", "unlisted"} {
+		c.Assert(os.MkdirAll(filepath.Join(prevDir, g), 0755), IsNil)
+		c.Assert(os.MkdirAll(filepath.Join(depDir, g), 0755), IsNil)
+	}
+	c.Assert(os.MkdirAll(filepath.Join(depDir, "added"), 0755), IsNil)
+	prev := Manifest{Files: map[string]string{
+		"same/main.tf":    "1",
+		"edited/main.tf":  "2",
+		"changed/main.tf": "3",
+	}}
+	cur := Manifest{Files: map[string]string{
+		"same/main.tf":     "1",
+		"edited/main.tf":   "2",
+		"changed/main.tf":  "4",
+		"unlisted/main.tf": "5",
+		"added/main.tf":    "6",
+	}}
+	groups := []config.DeploymentGroup{}
+	for _, g := range []config.GroupName{"same", "edited", "changed", "unlisted", "added"} {
+		groups = append(groups, config.DeploymentGroup{Name: g})
+	}
+
+	status, err := keepUnchangedGroups(groups, depDir, prev, cur, []string{"edited/main.tf (modified)"})
+	c.Assert(err, IsNil)
+	c.Check(status, DeepEquals, map[config.GroupName]string{
+		"same":     GroupUnchanged,
+		"edited":   GroupChanged,
+		"changed":  GroupChanged,
+		"unlisted": GroupChanged,
+		"added":    GroupNew,
+	})
+	_, err = os.Stat(filepath.Join(prevDir, "same"))
+	c.Check(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(filepath.Join(prevDir, "changed"))
+	c.Check(err, IsNil)
+}
+
 func (s *MySuite) TestCreateGroupDirs(c *C) {
 	// Setup
 	testDeployDir := filepath.Join(testDir, "test_createGroupDirs")
@@ -324,7 +618,8 @@ func (s *MySuite) TestRestoreTfState(c *C) {
 	//    ├── .ghpc
 	//       └── previous_resource_groups
 	//          └── fake_resource_group
-	//             └── terraform.tfstate
+	//             ├── terraform.tfstate
+	//             └── .terraform.lock.hcl
 	//    └── fake_resource_group
 	depDir := filepath.Join(testDir, "test_restore_state")
 	deploymentGroupName := "fake_resource_group"
@@ -340,6 +635,8 @@ func (s *MySuite) TestRestoreTfState(c *C) {
 	emptyFile.Close()
 	emptyFile, _ = os.Create(prevBuStateFile)
 	emptyFile.Close()
+	emptyFile, _ = os.Create(filepath.Join(prevDeploymentGroup, TFLockFileName))
+	emptyFile.Close()
 
 	testWriter := TFWriter{}
 	testWriter.restoreState(depDir)
@@ -351,6 +648,59 @@ func (s *MySuite) TestRestoreTfState(c *C) {
 	c.Check(err, IsNil)
 	_, err = os.Stat(curBuStateFile)
 	c.Check(err, IsNil)
+	_, err = os.Stat(filepath.Join(curDeploymentGroup, TFLockFileName))
+	c.Check(err, IsNil)
+}
+
+// packerwriter.go
+func (s *MySuite) TestRestorePackerManifest(c *C) {
+	depDir := c.MkDir()
+	prev := filepath.Join(depDir, HiddenGhpcDirName, prevDeploymentGroupDirName)
+	for _, mod := range []string{"image", "removed"} {
+		c.Assert(os.MkdirAll(filepath.Join(prev, "packer", mod), 0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(prev, "packer", mod, PackerManifestName), []byte("{}"), 0644), IsNil)
+	}
+	c.Assert(os.MkdirAll(filepath.Join(depDir, "packer", "image"), 0755), IsNil)
+
+	c.Assert(PackerWriter{}.restoreState(depDir), IsNil)
+	_, err := os.Stat(filepath.Join(depDir, "packer", "image", PackerManifestName))
+	c.Check(err, IsNil)
+	// manifests of modules that were removed are not restored
+	_, err = os.Stat(filepath.Join(depDir, "packer", "removed"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *MySuite) TestBackendMigrations(c *C) {
+	depDir := c.MkDir()
+	artifactsDir := filepath.Join(depDir, HiddenGhpcDirName, ArtifactsDirName)
+	c.Assert(os.MkdirAll(artifactsDir, 0755), IsNil)
+
+	m, err := ReadBackendMigrations(artifactsDir)
+	c.Assert(err, IsNil)
+	c.Check(m, HasLen, 0)
+
+	m["primary"] = BackendMigration{From: "gcs", To: "gcs"}
+	m["cluster"] = BackendMigration{From: "local", To: "gcs"}
+	c.Assert(WriteBackendMigrations(artifactsDir, m), IsNil)
+	got, err := ReadBackendMigrations(artifactsDir)
+	c.Assert(err, IsNil)
+	c.Check(got, DeepEquals, m)
+
+	// the backend terraform initialized the previous directory with is
+	// restored; groups in the local backend have none
+	prev := filepath.Join(depDir, HiddenGhpcDirName, prevDeploymentGroupDirName, "primary", ".terraform")
+	c.Assert(os.MkdirAll(prev, 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(prev, "terraform.tfstate"), []byte(`{"backend": {"type": "gcs"}}`), 0644), IsNil)
+	c.Assert(restoreBackendMetadata(depDir, m), IsNil)
+	b, err := os.ReadFile(filepath.Join(depDir, "primary", ".terraform", "terraform.tfstate"))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, `{"backend": {"type": "gcs"}}`)
+	_, err = os.Stat(filepath.Join(depDir, "cluster", ".terraform"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	c.Assert(WriteBackendMigrations(artifactsDir, nil), IsNil)
+	_, err = os.Stat(filepath.Join(artifactsDir, BackendMigrationsName))
+	c.Check(os.IsNotExist(err), Equals, true)
 }
 
 func (s *MySuite) TestGetTypeTokens(c *C) {
@@ -486,7 +836,7 @@ func (s *MySuite) TestWriteMain(c *C) {
 	// Simple success
 	testModules := []config.Module{}
 	testBackend := config.TerraformBackend{}
-	err := writeMain(testModules, testBackend, testMainDir)
+	err := writeMain(testModules, nil, testBackend, testMainDir)
 	c.Assert(err, IsNil)
 
 	// Test with modules
@@ -498,7 +848,7 @@ func (s *MySuite) TestWriteMain(c *C) {
 		}),
 	}
 	testModules = append(testModules, testModule)
-	err = writeMain(testModules, testBackend, testMainDir)
+	err = writeMain(testModules, nil, testBackend, testMainDir)
 	c.Assert(err, IsNil)
 	exists, err := stringExistsInFile("testSetting", mainFilePath)
 	c.Assert(err, IsNil)
@@ -516,28 +866,149 @@ func (s *MySuite) TestWriteMain(c *C) {
 	testBackend.Type = "gcs"
 	testBackend.Configuration.Set("bucket", cty.StringVal("a_bucket"))
 
-	err = writeMain(testModules, testBackend, testMainDir)
+	err = writeMain(testModules, nil, testBackend, testMainDir)
 	c.Assert(err, IsNil)
 	exists, err = stringExistsInFile("a_bucket", mainFilePath)
 	c.Assert(err, IsNil)
 	c.Assert(exists, Equals, true)
 
-	// Test with WrapSettingsWith
+	// Test with WrapSettings
 	testModuleWithWrap := config.Module{
 		ID: "test_module_with_wrap",
-		WrapSettingsWith: map[string][]string{
-			"wrappedSetting": {"list(flatten(", "))"},
+		WrapSettings: map[string]string{
+			"wrappedSetting": "flatten",
+			"mergedSetting":  "merge",
 		},
 		Settings: config.NewDict(map[string]cty.Value{
 			"wrappedSetting": cty.TupleVal([]cty.Value{
 				cty.StringVal("val1"),
 				cty.StringVal("val2")}),
+			"mergedSetting": cty.TupleVal([]cty.Value{
+				cty.MapVal(map[string]cty.Value{"a": cty.StringVal("b")}),
+				cty.MapVal(map[string]cty.Value{"c": cty.StringVal("d")})}),
 		}),
 	}
 	testModules = append(testModules, testModuleWithWrap)
-	err = writeMain(testModules, testBackend, testMainDir)
+	err = writeMain(testModules, nil, testBackend, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile(`flatten(["val1", "val2"])`, mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile("merge({", mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Test with raw HCL expressions, written verbatim
+	heredoc, err := config.ParseRawExpression("<<-EOT\n  #!/bin/bash\n  echo ${var.deployment_name}\nEOT")
+	c.Assert(err, IsNil)
+	testModules = append(testModules, config.Module{
+		ID: "test_module_with_raw",
+		Settings: config.NewDict(map[string]cty.Value{
+			"startup_script": heredoc.AsValue(),
+		}),
+	})
+	err = writeMain(testModules, nil, testBackend, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("startup_script = <<-EOT\n  #!/bin/bash\n  echo ${var.deployment_name}\nEOT\n", mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Test with settings set to null, written explicitly
+	testModules = append(testModules, config.Module{
+		ID: "test_module_with_null",
+		Settings: config.NewDict(map[string]cty.Value{
+			"labels": cty.NullVal(cty.DynamicPseudoType),
+			"zone":   cty.NullVal(cty.String),
+		}),
+	})
+	err = writeMain(testModules, nil, testBackend, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("labels = null\n  zone   = null\n", mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Test with module variables written as locals
+	raw, err := config.ParseRawExpression(`"${var.subnet}-${var.subnets[0]}"`)
+	c.Assert(err, IsNil)
+	locals := map[string]cty.Value{
+		"subnet":  config.ModuleRef("net", "subnet").AsExpression().AsValue(),
+		"subnets": cty.TupleVal([]cty.Value{config.GlobalRef("subnet").AsExpression().AsValue()}),
+	}
+	testModules = append(testModules, config.Module{
+		ID: "test_module_with_locals",
+		Settings: config.NewDict(map[string]cty.Value{
+			"subnets": config.GlobalRef("subnets").AsExpression().AsValue(),
+			"name":    raw.AsValue(),
+			"zone":    config.GlobalRef("subnets_zone").AsExpression().AsValue(),
+		}),
+	})
+	err = writeMain(testModules, locals, testBackend, testMainDir)
+	c.Assert(err, IsNil)
+	for _, want := range []string{
+		"locals {\n  subnet  = module.net.subnet\n  subnets = [local.subnet]\n}\n",
+		`name    = "${local.subnet}-${local.subnets[0]}"`,
+		"subnets = local.subnets\n",
+		"zone    = var.subnets_zone\n",
+	} {
+		exists, err = stringExistsInFile(want, mainFilePath)
+		c.Assert(err, IsNil)
+		c.Check(exists, Equals, true, Commentf("main.tf does not contain %q", want))
+	}
+
+	// Test with Terraform Registry module
+	testRegistryModule := config.Module{
+		ID:               "test_registry_module",
+		Source:           "registry.terraform.io/x/y/google",
+		DeploymentSource: "registry.terraform.io/x/y/google",
+		Version:          "1.2.0",
+	}
+	testModules = append(testModules, testRegistryModule)
+	err = writeMain(testModules, nil, testBackend, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile(`version = "1.2.0"`, mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Test with providers passed to the module
+	testModules = []config.Module{{
+		ID:               "test_module_in_europe",
+		DeploymentSource: "./modules/vm",
+		Providers:        map[string]string{"google": "google.europe"},
+	}}
+	err = writeMain(testModules, nil, testBackend, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("google = google.europe", mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Test with a renamed module
+	testModules = []config.Module{{
+		ID:               "network",
+		DeploymentSource: "./modules/vpc",
+		MovedFrom:        []config.ModuleID{"network1"},
+	}}
+	err = writeMain(testModules, nil, testBackend, testMainDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("moved {\n  from = module.network1\n  to   = module.network\n}", mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Test with sensitive settings
+	testModules = []config.Module{{
+		ID:                "test_module_with_secret",
+		DeploymentSource:  "./modules/db",
+		SensitiveSettings: []string{"password"},
+		Settings: config.NewDict(map[string]cty.Value{
+			"password": cty.StringVal("hunter22"),
+			"user":     cty.StringVal("admin"),
+		}),
+	}}
+	err = writeMain(testModules, nil, testBackend, testMainDir)
 	c.Assert(err, IsNil)
-	exists, err = stringExistsInFile("list(flatten(", mainFilePath)
+	exists, err = stringExistsInFile(`password = sensitive("hunter22")`, mainFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile(`user     = "admin"`, mainFilePath)
 	c.Assert(err, IsNil)
 	c.Assert(exists, Equals, true)
 }
@@ -552,7 +1023,7 @@ func (s *MySuite) TestWriteOutputs(c *C) {
 
 	// Simple success, no modules
 	testModules := []config.Module{}
-	err := writeOutputs(testModules, testOutputsDir)
+	err := writeOutputs(testModules, cty.NilVal, testOutputsDir)
 	c.Assert(err, IsNil)
 
 	// Success: Outputs added
@@ -562,7 +1033,7 @@ func (s *MySuite) TestWriteOutputs(c *C) {
 	}
 	moduleWithOutputs := config.Module{Outputs: outputList, ID: "testMod"}
 	testModules = []config.Module{moduleWithOutputs}
-	err = writeOutputs(testModules, testOutputsDir)
+	err = writeOutputs(testModules, cty.NilVal, testOutputsDir)
 	c.Assert(err, IsNil)
 
 	exists, err := stringExistsInFile("output1", outputsFilePath)
@@ -572,8 +1043,30 @@ func (s *MySuite) TestWriteOutputs(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(exists, Equals, true)
 
+	// Success: provenance output added
+	provenance := cty.ObjectVal(map[string]cty.Value{"commit": cty.StringVal("0123abcd")})
+	err = writeOutputs(testModules, provenance, testOutputsDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile(`output "ghpc_provenance"`, outputsFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile("0123abcd", outputsFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Success: outputs added by ghpc are marked as managed
+	moduleWithOutputs.Outputs = append(outputList, modulereader.OutputInfo{Name: "output3", Managed: true})
+	err = writeOutputs([]config.Module{moduleWithOutputs}, cty.NilVal, testOutputsDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("# managed by ghpc, do not edit\noutput \"output3_testMod\"", outputsFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile("# managed by ghpc, do not edit\noutput \"output1_testMod\"", outputsFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
 	// Failure: Bad path
-	err = writeOutputs(testModules, "not/a/real/path")
+	err = writeOutputs(testModules, cty.NilVal, "not/a/real/path")
 	c.Assert(err, ErrorMatches, "error creating outputs.tf file: .*")
 
 }
@@ -590,17 +1083,17 @@ func (s *MySuite) TestWriteVariables(c *C) {
 
 	// Simple success, empty vars
 	testVars := make(map[string]cty.Value)
-	err := writeVariables(testVars, noIntergroupVars, testVarDir)
+	err := writeVariables(testVars, noIntergroupVars, nil, testVarDir)
 	c.Assert(err, IsNil)
 
 	// Failure: Bad path
-	err = writeVariables(testVars, noIntergroupVars, "not/a/real/path")
+	err = writeVariables(testVars, noIntergroupVars, nil, "not/a/real/path")
 	c.Assert(err, ErrorMatches, "error creating variables.tf file: .*")
 
 	// Success, common vars
 	testVars["deployment_name"] = cty.StringVal("test_deployment")
 	testVars["project_id"] = cty.StringVal("test_project")
-	err = writeVariables(testVars, noIntergroupVars, testVarDir)
+	err = writeVariables(testVars, noIntergroupVars, nil, testVarDir)
 	c.Assert(err, IsNil)
 	exists, err := stringExistsInFile("\"deployment_name\"", varsFilePath)
 	c.Assert(err, IsNil)
@@ -609,8 +1102,16 @@ func (s *MySuite) TestWriteVariables(c *C) {
 	// Success, "dynamic type"
 	testVars = make(map[string]cty.Value)
 	testVars["project_id"] = cty.NullVal(cty.DynamicPseudoType)
-	err = writeVariables(testVars, noIntergroupVars, testVarDir)
+	err = writeVariables(testVars, noIntergroupVars, nil, testVarDir)
+	c.Assert(err, IsNil)
+
+	// Success, sensitive vars
+	testVars["db_password"] = cty.StringVal("hunter22")
+	err = writeVariables(testVars, noIntergroupVars, []string{"db_password"}, testVarDir)
 	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("sensitive   = true", varsFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
 }
 
 func (s *MySuite) TestWriteProviders(c *C) {
@@ -623,7 +1124,9 @@ func (s *MySuite) TestWriteProviders(c *C) {
 
 	// Simple success, empty vars
 	testVars := make(map[string]cty.Value)
-	err := writeProviders(testVars, testProvDir)
+	gcp := config.Blueprint{}
+	group := config.DeploymentGroup{Name: "primary"}
+	err := writeProviders(gcp.ProviderBlocks(group, testVars), testProvDir)
 	c.Assert(err, IsNil)
 	exists, err := stringExistsInFile("google-beta", provFilePath)
 	c.Assert(err, IsNil)
@@ -633,18 +1136,98 @@ func (s *MySuite) TestWriteProviders(c *C) {
 	c.Assert(exists, Equals, false)
 
 	// Failure: Bad Path
-	err = writeProviders(testVars, "not/a/real/path")
+	err = writeProviders(gcp.ProviderBlocks(group, testVars), "not/a/real/path")
 	c.Assert(err, ErrorMatches, "error creating providers.tf file: .*")
 
 	// Success: All vars
 	testVars["project_id"] = cty.StringVal("test_project")
 	testVars["zone"] = cty.StringVal("test_zone")
 	testVars["region"] = cty.StringVal("test_region")
-	err = writeProviders(testVars, testProvDir)
+	err = writeProviders(gcp.ProviderBlocks(group, testVars), testProvDir)
 	c.Assert(err, IsNil)
 	exists, err = stringExistsInFile("var.region", provFilePath)
 	c.Assert(err, IsNil)
 	c.Assert(exists, Equals, true)
+
+	// Success: providers of a group impersonating a service account
+	group.ImpersonateServiceAccount = "deployer@project.iam.gserviceaccount.com"
+	err = writeProviders(gcp.ProviderBlocks(group, testVars), testProvDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile(`impersonate_service_account = "deployer@project.iam.gserviceaccount.com"`, provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Success: AWS provider, configured with the region only
+	aws := config.Blueprint{Cloud: config.AWS}
+	err = writeProviders(aws.ProviderBlocks(group, testVars), testProvDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile(`provider "aws"`, provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile("google", provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+	exists, err = stringExistsInFile("var.project_id", provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	// Success: the azurerm provider requires a features block
+	azure := config.Blueprint{Cloud: config.Azure}
+	testVars["subscription_id"] = cty.StringVal("0000")
+	err = writeProviders(azure.ProviderBlocks(group, testVars), testProvDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile("subscription_id = var.subscription_id", provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile("features {", provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	c.Assert(writeVersions(aws.RequiredProviders(), testProvDir), IsNil)
+	exists, err = stringExistsInFile(`source  = "hashicorp/aws"`, filepath.Join(testProvDir, "versions.tf"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// Success: providers customized by terraform_providers
+	custom := config.Blueprint{TerraformProviders: map[string]config.TerraformProvider{
+		"google": {Version: "~> 5.0"},
+		"google.europe": {Configuration: config.NewDict(map[string]cty.Value{
+			"region": cty.StringVal("europe-west1"),
+		})},
+		"kubernetes": {Source: "hashicorp/kubernetes"},
+	}}
+	group.ImpersonateServiceAccount = ""
+	err = writeProviders(custom.ProviderBlocks(group, testVars), testProvDir)
+	c.Assert(err, IsNil)
+	exists, err = stringExistsInFile(`alias   = "europe"`, provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	exists, err = stringExistsInFile(`region  = "europe-west1"`, provFilePath)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	c.Assert(writeVersions(custom.RequiredProviders(), testProvDir), IsNil)
+	versions, err := os.ReadFile(filepath.Join(testProvDir, "versions.tf"))
+	c.Assert(err, IsNil)
+	c.Check(string(versions), Matches, `(?s).*google = \{\s+source  = "hashicorp/google"\s+version = "~> 5.0".*`)
+	c.Check(string(versions), Matches, `(?s).*kubernetes = \{\s+source = "hashicorp/kubernetes"\s+\}.*`)
+}
+
+func (s *MySuite) TestGetUsedDeploymentVars_GroupProject(c *C) {
+	bp := config.Blueprint{}
+	bp.Vars.Set("project_id", cty.StringVal("host-project"))
+	bp.Vars.Set("region", cty.StringVal("us-central1"))
+	bp.Vars.Set("labels", cty.EmptyObjectVal)
+	group := config.DeploymentGroup{Name: "service"}
+
+	// the project is only passed to groups that use it
+	c.Check(getUsedDeploymentVars(group, bp), DeepEquals, map[string]cty.Value{"labels": cty.EmptyObjectVal})
+
+	group.ProjectID = "service-project"
+	c.Check(getUsedDeploymentVars(group, bp), DeepEquals, map[string]cty.Value{
+		"labels":     cty.EmptyObjectVal,
+		"project_id": cty.StringVal("service-project"),
+	})
+	c.Check(bp.Vars.Get("project_id"), DeepEquals, cty.StringVal("host-project"))
 }
 
 // packerwriter.go
@@ -715,6 +1298,14 @@ func (s *MySuite) TestWriteDeploymentGroup_PackerWriter(c *C) {
 	testWriter.writeDeploymentGroup(testDC, 0, deploymentDir, f)
 	_, err = os.Stat(filepath.Join(moduleDir, packerAutoVarFilename))
 	c.Assert(err, IsNil)
+	_, err = os.Stat(filepath.Join(groupDir, PackerSharedVarsFilename))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// settings shared by the modules of the group
+	testDC.Config.DeploymentGroups[0].PackerSettings.Set("zone", cty.StringVal("us-central1-a"))
+	c.Assert(testWriter.writeDeploymentGroup(testDC, 0, deploymentDir, f), IsNil)
+	_, err = os.Stat(filepath.Join(groupDir, PackerSharedVarsFilename))
+	c.Assert(err, IsNil)
 }
 
 func (s *MySuite) TestWritePackerAutoVars(c *C) {
@@ -760,6 +1351,8 @@ func (s *MySuite) TestStringEscape(c *C) {
 }
 
 func TestMain(m *testing.M) {
+	// do not read or pollute the disk cache of the user
+	cache.Enabled = false
 	setup()
 	code := m.Run()
 	teardown()
@@ -773,6 +1366,24 @@ func (s *MySuite) TestDeploymentSource(c *C) {
 		c.Check(err, IsNil)
 		c.Check(s, Equals, "github.com/x/y.git")
 	}
+	{ // remote archive
+		m := config.Module{Kind: config.TerraformKind, Source: "gcs::https://www.googleapis.com/storage/v1/b/y.zip"}
+		s, err := deploymentSource(m)
+		c.Check(err, IsNil)
+		c.Check(s, Equals, m.Source)
+	}
+	{ // remote archive with checksum
+		m := config.Module{Kind: config.TerraformKind, Source: "https://example.com/x/y.tar.gz?checksum=sha256:abc"}
+		s, err := deploymentSource(m)
+		c.Check(err, IsNil)
+		c.Check(s, Matches, `^\./modules/y-\w\w\w\w$`)
+	}
+	{ // terraform registry
+		m := config.Module{Kind: config.TerraformKind, Source: "registry.terraform.io/x/y/google", Version: "1.2.0"}
+		s, err := deploymentSource(m)
+		c.Check(err, IsNil)
+		c.Check(s, Equals, m.Source)
+	}
 	{ // packer
 		m := config.Module{Kind: config.PackerKind, Source: "modules/packer/custom-image", ID: "custom-image"}
 		s, err := deploymentSource(m)
@@ -810,3 +1421,166 @@ func (s *MySuite) TestDeploymentSource(c *C) {
 		c.Check(s, Matches, `^\./modules/y-\w\w\w\w$`)
 	}
 }
+
+func (s *MySuite) TestDeploymentSource_Offline(c *C) {
+	defer func() { offline.Enabled = false }()
+	offline.Enabled = true
+
+	{ // git
+		m := config.Module{Kind: config.TerraformKind, Source: "github.com/x/y.git"}
+		s, err := deploymentSource(m)
+		c.Check(err, IsNil)
+		c.Check(s, Matches, `^\./modules/y.git-\w\w\w\w$`)
+	}
+	{ // terraform registry, versions are copied to different directories
+		m := config.Module{Kind: config.TerraformKind, Source: "registry.terraform.io/x/y/google", Version: "1.2.0"}
+		s, err := deploymentSource(m)
+		c.Check(err, IsNil)
+		c.Check(s, Matches, `^\./modules/google-\w\w\w\w$`)
+		m.Version = "1.3.0"
+		other, err := deploymentSource(m)
+		c.Check(err, IsNil)
+		c.Check(other, Not(Equals), s)
+	}
+}
+
+func (s *MySuite) TestWriteTerraformCLIConfig(c *C) {
+	depDir := filepath.Join(testDir, "cli_config")
+	c.Assert(os.MkdirAll(filepath.Join(depDir, HiddenGhpcDirName), 0755), IsNil)
+	cfgPath := filepath.Join(depDir, HiddenGhpcDirName, TerraformCLIConfigName)
+
+	defer func() { offline.Enabled, offline.MirrorDir = false, "" }()
+	offline.Enabled, offline.MirrorDir = true, "/mirror"
+	p, err := writeTerraformCLIConfig(depDir)
+	c.Assert(err, IsNil)
+	c.Check(filepath.IsAbs(p), Equals, true)
+	b, err := os.ReadFile(cfgPath)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Matches, `(?s).*provider_installation \{\s*filesystem_mirror \{\s*path = "/mirror/providers".*`)
+
+	// the configuration is removed when the deployment is written online
+	offline.Enabled = false
+	p, err = writeTerraformCLIConfig(depDir)
+	c.Assert(err, IsNil)
+	c.Check(p, Equals, "")
+	_, err = os.Stat(cfgPath)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *MySuite) TestCopySource_Integrity(c *C) {
+	modDir := filepath.Join(testDir, "integrity_module")
+	c.Assert(os.MkdirAll(modDir, 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(modDir, "main.tf"), []byte("# empty\n"), 0644), IsNil)
+	sum, err := cache.HashDir(modDir)
+	c.Assert(err, IsNil)
+
+	write := func(name string, checksum string) error {
+		groups := []config.DeploymentGroup{{
+			Name: "zero",
+			Modules: []config.Module{{
+				ID:        "mod",
+				Kind:      config.TerraformKind,
+				Source:    modDir,
+				Integrity: &sourcereader.Integrity{SHA256: checksum},
+			}},
+		}}
+		depDir := filepath.Join(testDir, name)
+		c.Assert(os.MkdirAll(filepath.Join(depDir, "zero"), 0755), IsNil)
+		return copySource(depDir, &groups)
+	}
+
+	c.Check(write("integrity_ok", "sha256:"+sum), IsNil)
+
+	err = write("integrity_mismatch", strings.Repeat("0", 64))
+	c.Check(err, ErrorMatches, ".*checksum mismatch.*")
+	c.Check(errcode.Of(err), Equals, errcode.IntegrityFailure)
+	entries, err := os.ReadDir(filepath.Join(testDir, "integrity_mismatch", "zero"))
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0) // the module is not copied
+}
+
+func (s *MySuite) TestWriteTerragrunt(c *C) {
+	bp := config.Blueprint{
+		DeploymentGroups: []config.DeploymentGroup{
+			{Name: "primary", Kind: config.TerraformKind, Modules: []config.Module{{ID: "network1"}}},
+			{Name: "image", Kind: config.PackerKind, Modules: []config.Module{{ID: "builder"}}},
+			{Name: "cluster", Kind: config.TerraformKind, Modules: []config.Module{{
+				ID: "compute",
+				Settings: config.NewDict(map[string]cty.Value{
+					"network": config.ModuleRef("network1", "network_self_link").AsExpression().AsValue(),
+					"image":   config.ModuleRef("builder", "image_name").AsExpression().AsValue(),
+				}),
+			}}},
+		},
+	}
+	groupDir := c.MkDir()
+
+	// no dependencies
+	c.Assert(writeTerragrunt(bp.DeploymentGroups[0], bp, groupDir), IsNil)
+	b, err := os.ReadFile(filepath.Join(groupDir, TerragruntFilename))
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(b), "dependency"), Equals, false)
+	c.Check(strings.Contains(string(b), "inputs"), Equals, false)
+
+	// inputs from Packer groups are not set
+	c.Assert(writeTerragrunt(bp.DeploymentGroups[2], bp, groupDir), IsNil)
+	b, err = os.ReadFile(filepath.Join(groupDir, TerragruntFilename))
+	c.Assert(err, IsNil)
+	c.Check(strings.TrimPrefix(string(b), license), Equals, `
+dependency "primary" {
+  config_path = "../primary"
+}
+
+inputs = {
+  network_self_link_network1 = dependency.primary.outputs.network_self_link_network1
+}
+`)
+
+	// explicit dependencies on Terraform groups not referenced
+	monitoring := config.DeploymentGroup{
+		Name: "monitoring", Kind: config.TerraformKind, Modules: []config.Module{{ID: "dashboard"}},
+		DependsOn: []config.GroupName{"primary", "image", "cluster"}}
+	bp.DeploymentGroups = append(bp.DeploymentGroups, monitoring)
+	c.Assert(writeTerragrunt(monitoring, bp, groupDir), IsNil)
+	b, err = os.ReadFile(filepath.Join(groupDir, TerragruntFilename))
+	c.Assert(err, IsNil)
+	c.Check(strings.TrimPrefix(string(b), license), Equals, `
+dependencies {
+  paths = ["../primary", "../cluster"]
+}
+`)
+}
+
+func (s *MySuite) TestWriteDeploymentGroup_HelmWriter(c *C) {
+	deploymentDir := c.MkDir()
+	moduleDir := filepath.Join(deploymentDir, "apps", "kueue")
+	c.Assert(os.MkdirAll(moduleDir, 0755), IsNil)
+
+	kueue := config.Module{
+		Kind:             config.HelmKind,
+		ID:               "kueue",
+		DeploymentSource: "kueue",
+		Settings: config.NewDict(map[string]cty.Value{
+			"replicaCount": cty.NumberIntVal(2),
+			"project":      config.GlobalRef("project_id").AsExpression().AsValue(),
+			"env": cty.ObjectVal(map[string]cty.Value{
+				"args": cty.TupleVal([]cty.Value{cty.StringVal(`\$(HOME)`), cty.StringVal(`\((x))`)}),
+			}),
+		}),
+	}
+	dc := config.DeploymentConfig{
+		Config: config.Blueprint{
+			Vars: config.NewDict(map[string]cty.Value{"project_id": cty.StringVal("my-project")}),
+			DeploymentGroups: []config.DeploymentGroup{
+				{Name: "apps", Kind: config.HelmKind, Namespace: "kueue-system", Modules: []config.Module{kueue}},
+			},
+		},
+	}
+	var instructions strings.Builder
+	c.Assert(HelmWriter{}.writeDeploymentGroup(dc, 0, deploymentDir, &instructions), IsNil)
+	b, err := os.ReadFile(filepath.Join(moduleDir, HelmValuesFilename))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, "# Generated by ghpc, do not edit\nenv:\n    args:\n        - $(HOME)\n        - ((x))\nproject: my-project\nreplicaCount: 2\n")
+	c.Check(instructions.String(), Matches,
+		`(?s).*helm upgrade --install kueue \S+/apps/kueue --namespace kueue-system --create-namespace --values \S+/ghpc-values.yaml.*`)
+}
@@ -19,6 +19,7 @@ package modulewriter
 import (
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 
 	"hpc-toolkit/pkg/config"
@@ -26,7 +27,12 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
-const packerAutoVarFilename = "defaults.auto.pkrvars.hcl"
+const (
+	packerAutoVarFilename = "defaults.auto.pkrvars.hcl"
+	// PackerSharedVarsFilename is the file, in the directory of a Packer
+	// group, of the packer_settings shared by the modules of the group
+	PackerSharedVarsFilename = "shared.pkrvars.hcl"
+)
 
 // PackerWriter writes packer to the blueprint folder
 type PackerWriter struct {
@@ -41,19 +47,28 @@ func (w *PackerWriter) addNumModules(value int) {
 	w.numModules += value
 }
 
-func printPackerInstructions(w io.Writer, modPath string, modID config.ModuleID, printImportInputs bool) {
+// printPackerInstructions prints how to build the image of a Packer module;
+// modules that use outputs of earlier groups are built by ghpc deploy, which
+// sets their inputs
+func printPackerInstructions(w io.Writer, modPath string, modID config.ModuleID, deployDir string, group config.GroupName, hasIgc bool, sharedVars bool) {
 	fmt.Fprintln(w)
 	fmt.Fprintf(w, "Packer group '%s' was successfully created in directory %s\n", modID, modPath)
+	if hasIgc {
+		fmt.Fprintln(w, "Its inputs are set from the outputs of earlier groups, which must be deployed or exported first. To deploy, run the following command:")
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "ghpc deploy %s --only %s\n", deployDir, group)
+		return
+	}
+	varFile := ""
+	if sharedVars {
+		varFile = fmt.Sprintf("-var-file=../%s ", PackerSharedVarsFilename)
+	}
 	fmt.Fprintln(w, "To deploy, run the following commands:")
 	fmt.Fprintln(w)
-	grpPath := filepath.Clean(filepath.Join(modPath, ".."))
-	if printImportInputs {
-		fmt.Fprintf(w, "ghpc import-inputs %s\n", grpPath)
-	}
 	fmt.Fprintf(w, "cd %s\n", modPath)
 	fmt.Fprintln(w, "packer init .")
-	fmt.Fprintln(w, "packer validate .")
-	fmt.Fprintln(w, "packer build .")
+	fmt.Fprintf(w, "packer validate %s.\n", varFile)
+	fmt.Fprintf(w, "packer build %s.\n", varFile)
 	fmt.Fprintln(w, "cd -")
 }
 
@@ -75,7 +90,23 @@ func (w PackerWriter) writeDeploymentGroup(
 	groupPath := filepath.Join(deployDir, string(depGroup.Name))
 	igcInputs := map[string]bool{}
 
-	for _, mod := range depGroup.Modules {
+	shared, err := depGroup.PackerSettings.Eval(dc.Config)
+	if err != nil {
+		return err
+	}
+	sharedVars := len(shared.Items()) > 0
+	if sharedVars {
+		if err := WriteHclAttributes(shared.Items(), filepath.Join(groupPath, PackerSharedVarsFilename)); err != nil {
+			return err
+		}
+	}
+
+	// modules are listed in the order they are built
+	modules, err := depGroup.PackerBuildOrder()
+	if err != nil {
+		return err
+	}
+	for _, mod := range modules {
 		pure := config.Dict{}
 		for setting, v := range mod.Settings.Items() {
 			igcRefs := config.FindIntergroupReferences(v, mod, dc.Config)
@@ -94,18 +125,42 @@ func (w PackerWriter) writeDeploymentGroup(
 		}
 
 		modPath := filepath.Join(groupPath, mod.DeploymentSource)
-		if err = writePackerAutovars(av.Items(), modPath); err != nil {
+		if err := writePackerAutovars(av.Items(), modPath); err != nil {
 			return err
 		}
 		hasIgc := len(pure.Items()) < len(mod.Settings.Items())
-		printPackerInstructions(instructionsFile, modPath, mod.ID, hasIgc)
+		printPackerInstructions(instructionsFile, modPath, mod.ID, deployDir, depGroup.Name, hasIgc, sharedVars)
 	}
 
 	return nil
 }
 
+// restoreState copies the Packer manifests of the previous deployment, which
+// record the images built by its Packer modules, to the modules that are
+// still in the deployment
 func (w PackerWriter) restoreState(deploymentDir string) error {
-	// TODO: restore packer-manifest.json if it exists
+	prevDeploymentGroupPath := filepath.Join(deploymentDir, HiddenGhpcDirName, prevDeploymentGroupDirName)
+	manifests, err := filepath.Glob(filepath.Join(prevDeploymentGroupPath, "*", "*", PackerManifestName))
+	if err != nil {
+		return err
+	}
+	for _, src := range manifests {
+		rel, err := filepath.Rel(prevDeploymentGroupPath, src)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(deploymentDir, rel)
+		if _, err := os.Stat(filepath.Dir(dest)); err != nil {
+			continue // the module was removed from the deployment
+		}
+		b, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(dest, b, 0644); err != nil {
+			return fmt.Errorf("failed to write previous Packer manifest %s, %w", dest, err)
+		}
+	}
 	return nil
 }
 
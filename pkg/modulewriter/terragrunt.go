@@ -0,0 +1,108 @@
+/**
+* Copyright 2022 Google LLC
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*      http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package modulewriter
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"hpc-toolkit/pkg/config"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// TerragruntFilename is the Terragrunt configuration written in the
+// directory of Terraform groups
+const TerragruntFilename = "terragrunt.hcl"
+
+// writeTerragrunt writes the terragrunt.hcl of a Terraform group, with a
+// dependency block per Terraform group it references and the intergroup
+// inputs read from the outputs of those groups. The other Terraform groups it
+// depends on are listed in a dependencies block. Inputs from Packer groups are
+// not set, they are set by ghpc import-inputs.
+func writeTerragrunt(group config.DeploymentGroup, bp config.Blueprint, groupPath string) error {
+	inputs := map[string]hcl.Traversal{}
+	deps := map[config.GroupName]bool{}
+	for _, r := range group.FindAllIntergroupReferences(bp) {
+		src := bp.ModuleGroupOrDie(r.Module)
+		if src.Kind != config.TerraformKind {
+			continue
+		}
+		deps[src.Name] = true
+		n := config.AutomaticOutputName(r.Name, r.Module)
+		inputs[n] = hcl.Traversal{
+			hcl.TraverseRoot{Name: "dependency"},
+			hcl.TraverseAttr{Name: string(src.Name)},
+			hcl.TraverseAttr{Name: "outputs"},
+			hcl.TraverseAttr{Name: n},
+		}
+	}
+
+	hclFile := hclwrite.NewEmptyFile()
+	hclBody := hclFile.Body()
+	names := maps.Keys(deps)
+	slices.Sort(names)
+	for _, n := range names {
+		hclBody.AppendNewline()
+		dep := hclBody.AppendNewBlock("dependency", []string{string(n)}).Body()
+		dep.SetAttributeValue("config_path", cty.StringVal(filepath.Join("..", string(n))))
+	}
+	paths := []cty.Value{}
+	for _, n := range group.DependsOn {
+		if g := bp.DeploymentGroups[bp.GroupIndex(n)]; g.Kind == config.TerraformKind && !deps[n] {
+			paths = append(paths, cty.StringVal(filepath.Join("..", string(n))))
+		}
+	}
+	if len(paths) > 0 {
+		hclBody.AppendNewline()
+		dependencies := hclBody.AppendNewBlock("dependencies", nil).Body()
+		dependencies.SetAttributeValue("paths", cty.ListVal(paths))
+	}
+	if len(inputs) > 0 {
+		hclBody.AppendNewline()
+		attrs := []hclwrite.ObjectAttrTokens{}
+		for _, n := range orderKeys(inputs) {
+			attrs = append(attrs, hclwrite.ObjectAttrTokens{
+				Name:  hclwrite.TokensForIdentifier(n),
+				Value: hclwrite.TokensForTraversal(inputs[n]),
+			})
+		}
+		hclBody.SetAttributeRaw("inputs", hclwrite.TokensForObject(attrs))
+	}
+
+	path := filepath.Join(groupPath, TerragruntFilename)
+	if err := createBaseFile(path); err != nil {
+		return fmt.Errorf("error creating %s file: %v", TerragruntFilename, err)
+	}
+	if err := appendHCLToFile(path, hclwrite.Format(hclFile.Bytes())); err != nil {
+		return fmt.Errorf("error writing HCL to %s file: %v", TerragruntFilename, err)
+	}
+	return nil
+}
+
+func writeTerragruntInstructions(w io.Writer, deploymentDir string) {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Or, to deploy the Terraform groups with Terragrunt, run the following commands:")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "cd %s\n", deploymentDir)
+	fmt.Fprintln(w, "terragrunt run-all apply")
+}
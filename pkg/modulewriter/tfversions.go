@@ -14,19 +14,31 @@
 
 package modulewriter
 
-const tfversions string = `
+import (
+	"fmt"
+	"strings"
+
+	"hpc-toolkit/pkg/config"
+)
+
+// tfversions returns the terraform block requiring the providers
+func tfversions(providers []config.Provider) string {
+	var b strings.Builder
+	b.WriteString(`
 terraform {
   required_version = ">= 1.2"
 
   required_providers {
-    google = {
-      source  = "hashicorp/google"
-      version = "~> 4.65.2"
-    }
-    google-beta = {
-      source  = "hashicorp/google-beta"
-      version = "~> 4.65.2"
-    }
-  }
+`)
+	for _, p := range providers {
+		if p.Version == "" {
+			fmt.Fprintf(&b, "    %s = {\n      source = %q\n    }\n", p.Name, p.Source)
+			continue
+		}
+		fmt.Fprintf(&b, "    %s = {\n      source  = %q\n      version = %q\n    }\n", p.Name, p.Source, p.Version)
+	}
+	b.WriteString(`  }
+}
+`)
+	return b.String()
 }
-`
@@ -25,6 +25,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/ext/typeexpr"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
@@ -34,11 +35,15 @@ import (
 
 	"hpc-toolkit/pkg/config"
 	"hpc-toolkit/pkg/modulereader"
+	"hpc-toolkit/pkg/sourcereader"
 )
 
 const (
 	tfStateFileName       = "terraform.tfstate"
 	tfStateBackupFileName = "terraform.tfstate.backup"
+	// TFLockFileName is the dependency lock file of the providers of a group,
+	// written by "ghpc providers lock"
+	TFLockFileName = ".terraform.lock.hcl"
 )
 
 // TFWriter writes terraform to the blueprint folder
@@ -82,6 +87,7 @@ func appendHCLToFile(path string, hclBytes []byte) error {
 
 func writeOutputs(
 	modules []config.Module,
+	provenance cty.Value,
 	dst string,
 ) error {
 	// Create hcl body
@@ -96,6 +102,12 @@ func writeOutputs(
 			outputs = append(outputs, outputName)
 
 			hclBody.AppendNewline()
+			if output.Managed {
+				hclBody.AppendUnstructuredTokens(hclwrite.Tokens{{
+					Type:  hclsyntax.TokenComment,
+					Bytes: []byte("# managed by ghpc, do not edit\n"),
+				}})
+			}
 			hclBlock := hclBody.AppendNewBlock("output", []string{outputName})
 			blockBody := hclBlock.Body()
 
@@ -112,6 +124,15 @@ func writeOutputs(
 		}
 	}
 
+	// The provenance of the blueprint, if the blueprint opts in to it
+	if !provenance.IsNull() {
+		outputs = append(outputs, config.ProvenanceOutput)
+		hclBody.AppendNewline()
+		blockBody := hclBody.AppendNewBlock("output", []string{config.ProvenanceOutput}).Body()
+		blockBody.SetAttributeValue("description", cty.StringVal("Git revision of the blueprint of the deployment"))
+		blockBody.SetAttributeValue("value", provenance)
+	}
+
 	if len(outputs) == 0 {
 		return nil
 	}
@@ -148,7 +169,7 @@ func getTypeTokens(v cty.Value) hclwrite.Tokens {
 	return simpleTokens(getHclType(v.Type()))
 }
 
-func writeVariables(vars map[string]cty.Value, extraVars []modulereader.VarInfo, dst string) error {
+func writeVariables(vars map[string]cty.Value, extraVars []modulereader.VarInfo, sensitive []string, dst string) error {
 	// Create file
 	variablesPath := filepath.Join(dst, "variables.tf")
 	if err := createBaseFile(variablesPath); err != nil {
@@ -179,6 +200,9 @@ func writeVariables(vars map[string]cty.Value, extraVars []modulereader.VarInfo,
 		blockBody := hclBlock.Body()
 		blockBody.SetAttributeValue("description", cty.StringVal(k.Description))
 		blockBody.SetAttributeRaw("type", simpleTokens(k.Type))
+		if slices.Contains(sensitive, k.Name) {
+			blockBody.SetAttributeValue("sensitive", cty.True)
+		}
 	}
 
 	// Write file
@@ -190,6 +214,7 @@ func writeVariables(vars map[string]cty.Value, extraVars []modulereader.VarInfo,
 
 func writeMain(
 	modules []config.Module,
+	locals map[string]cty.Value,
 	tfBackend config.TerraformBackend,
 	dst string,
 ) error {
@@ -215,6 +240,15 @@ func writeMain(
 		}
 	}
 
+	// Write the module variables used by the group
+	if len(locals) > 0 {
+		hclBody.AppendNewline()
+		localsBody := hclBody.AppendNewBlock("locals", []string{}).Body()
+		for _, n := range orderKeys(locals) {
+			localsBody.SetAttributeRaw(n, localTokens(TokensForValue(locals[n]), locals))
+		}
+	}
+
 	for _, mod := range modules {
 		hclBody.AppendNewline()
 		// Add block
@@ -223,26 +257,28 @@ func writeMain(
 
 		// Add source attribute
 		moduleBody.SetAttributeValue("source", cty.StringVal(mod.DeploymentSource))
+		if mod.Version != "" && sourcereader.IsRegistryPath(mod.DeploymentSource) {
+			moduleBody.SetAttributeValue("version", cty.StringVal(mod.Version))
+		}
+		if len(mod.Providers) > 0 {
+			moduleBody.SetAttributeRaw("providers", providersTokens(mod.Providers))
+		}
 
 		// For each Setting
 		for _, setting := range orderKeys(mod.Settings.Items()) {
 			value := mod.Settings.Get(setting)
-			if wrap, ok := mod.WrapSettingsWith[setting]; ok {
-				if len(wrap) != 2 {
-					return fmt.Errorf(
-						"invalid length of WrapSettingsWith for %s.%s, expected 2 got %d",
-						mod.ID, setting, len(wrap))
-				}
-				toks, err := tokensForWrapped(wrap[0], value, wrap[1])
+			if fn, ok := mod.WrapSettings[setting]; ok {
+				toks, err := tokensForWrapped(fn, value)
 				if err != nil {
 					return fmt.Errorf("failed to process %s.%s: %v", mod.ID, setting, err)
 				}
-				moduleBody.SetAttributeRaw(setting, toks)
+				moduleBody.SetAttributeRaw(setting, sensitiveTokens(mod, setting, localTokens(toks, locals)))
 			} else {
-				moduleBody.SetAttributeRaw(setting, TokensForValue(value))
+				moduleBody.SetAttributeRaw(setting, sensitiveTokens(mod, setting, localTokens(TokensForValue(value), locals)))
 			}
 		}
 	}
+	writeMovedBlocks(hclBody, modules)
 	// Write file
 	hclBytes := hclFile.Bytes()
 	hclBytes = hclwrite.Format(hclBytes)
@@ -252,33 +288,75 @@ func writeMain(
 	return nil
 }
 
-func tokensForWrapped(pref string, val cty.Value, suf string) (hclwrite.Tokens, error) {
-	var toks hclwrite.Tokens
-	if !val.Type().IsListType() && !val.Type().IsTupleType() {
-		return toks, fmt.Errorf(
-			"invalid value for wrapped setting, expected sequence, got %#v", val.Type())
+// writeMovedBlocks writes the moved blocks that move the state of the previous
+// IDs of modules to them
+func writeMovedBlocks(body *hclwrite.Body, modules []config.Module) {
+	moduleTraversal := func(id config.ModuleID) hcl.Traversal {
+		return hcl.Traversal{hcl.TraverseRoot{Name: "module"}, hcl.TraverseAttr{Name: string(id)}}
+	}
+	for _, mod := range modules {
+		for _, from := range mod.MovedFrom {
+			body.AppendNewline()
+			moved := body.AppendNewBlock("moved", []string{}).Body()
+			moved.SetAttributeTraversal("from", moduleTraversal(from))
+			moved.SetAttributeTraversal("to", moduleTraversal(mod.ID))
+		}
+	}
+}
+
+// variadicWrappers are the HCL functions settings are wrapped with that take
+// the elements of the setting, a sequence, as their arguments
+var variadicWrappers = []string{"merge", "concat", "coalesce", "coalescelist", "setintersection", "setproduct", "setunion"}
+
+// tokensForWrapped returns the tokens of a setting wrapped with the HCL
+// function fn, e.g. flatten(value) or merge(elements of value...)
+func tokensForWrapped(fn string, val cty.Value) (hclwrite.Tokens, error) {
+	args := []cty.Value{val}
+	if slices.Contains(variadicWrappers, fn) {
+		if !val.Type().IsListType() && !val.Type().IsTupleType() {
+			return nil, fmt.Errorf(
+				"invalid value for setting wrapped with %s, expected sequence, got %#v", fn, val.Type())
+		}
+		args = val.AsValueSlice()
 	}
-	toks = append(toks, simpleTokens(pref)...)
 
-	it, first := val.ElementIterator(), true
-	for it.Next() {
-		if !first {
+	toks := simpleTokens(fn + "(")
+	for i, arg := range args {
+		if i > 0 {
 			toks = append(toks, &hclwrite.Token{
 				Type:  hclsyntax.TokenComma,
 				Bytes: []byte{','}})
 		}
-		_, el := it.Element()
-		toks = append(toks, TokensForValue(el)...)
-		first = false
+		toks = append(toks, TokensForValue(arg)...)
 	}
-	toks = append(toks, simpleTokens(suf)...)
+	toks = append(toks, simpleTokens(")")...)
 
 	return toks, nil
 }
 
 var simpleTokens = hclwrite.TokensForIdentifier
 
-func writeProviders(vars map[string]cty.Value, dst string) error {
+// sensitiveTokens wraps the tokens of a sensitive setting of the module with
+// the sensitive function, so Terraform redacts the value from its output
+func sensitiveTokens(mod config.Module, setting string, toks hclwrite.Tokens) hclwrite.Tokens {
+	if !slices.Contains(mod.SensitiveSettings, setting) {
+		return toks
+	}
+	return hclwrite.TokensForFunctionCall("sensitive", toks)
+}
+
+// providersTokens returns the providers argument of a module block, which
+// maps the names of the providers of the module to provider references
+func providersTokens(providers map[string]string) hclwrite.Tokens {
+	attrs := []hclwrite.ObjectAttrTokens{}
+	for _, k := range orderKeys(providers) {
+		attrs = append(attrs, hclwrite.ObjectAttrTokens{Name: simpleTokens(k), Value: simpleTokens(providers[k])})
+	}
+	return hclwrite.TokensForObject(attrs)
+}
+
+// writeProviders writes the provider blocks of a deployment group
+func writeProviders(blocks []config.ProviderBlock, dst string) error {
 	// Create file
 	providersPath := filepath.Join(dst, "providers.tf")
 	if err := createBaseFile(providersPath); err != nil {
@@ -289,37 +367,37 @@ func writeProviders(vars map[string]cty.Value, dst string) error {
 	hclFile := hclwrite.NewEmptyFile()
 	hclBody := hclFile.Body()
 
-	for _, prov := range []string{"google", "google-beta"} {
+	for _, prov := range blocks {
 		hclBody.AppendNewline()
-		provBlock := hclBody.AppendNewBlock("provider", []string{prov})
+		provBlock := hclBody.AppendNewBlock("provider", []string{prov.Name})
 		provBody := provBlock.Body()
-		if _, ok := vars["project_id"]; ok {
-			provBody.SetAttributeRaw("project", simpleTokens("var.project_id"))
+		if prov.Alias != "" {
+			provBody.SetAttributeValue("alias", cty.StringVal(prov.Alias))
 		}
-		if _, ok := vars["zone"]; ok {
-			provBody.SetAttributeRaw("zone", simpleTokens("var.zone"))
+		for _, a := range prov.Arguments {
+			provBody.SetAttributeRaw(a.Name, TokensForValue(a.Value))
 		}
-		if _, ok := vars["region"]; ok {
-			provBody.SetAttributeRaw("region", simpleTokens("var.region"))
+		for _, b := range prov.Blocks {
+			provBody.AppendNewBlock(b, nil)
 		}
 	}
 
 	// Write file
-	hclBytes := hclFile.Bytes()
+	hclBytes := hclwrite.Format(hclFile.Bytes())
 	if err := appendHCLToFile(providersPath, hclBytes); err != nil {
 		return fmt.Errorf("error writing HCL to providers.tf file: %v", err)
 	}
 	return nil
 }
 
-func writeVersions(dst string) error {
+func writeVersions(providers []config.Provider, dst string) error {
 	// Create file
 	versionsPath := filepath.Join(dst, "versions.tf")
 	if err := createBaseFile(versionsPath); err != nil {
 		return fmt.Errorf("error creating versions.tf file: %v", err)
 	}
-	// Write hard-coded version information
-	if err := appendHCLToFile(versionsPath, []byte(tfversions)); err != nil {
+	// Write the version constraints of the providers
+	if err := appendHCLToFile(versionsPath, []byte(tfversions(providers))); err != nil {
 		return fmt.Errorf("error writing HCL to versions.tf file: %v", err)
 	}
 	return nil
@@ -365,48 +443,105 @@ func (w TFWriter) writeDeploymentGroup(
 
 	// Write main.tf file
 	doctoredModules := substituteIgcReferences(depGroup.Modules, intergroupVars)
+	locals := map[string]cty.Value{}
+	for _, n := range dc.Config.GroupModuleVars(depGroup) {
+		locals[n] = substituteIgcReferencesInValue(dc.Config.Vars.Get(n), intergroupVars)
+	}
 	if err := writeMain(
-		doctoredModules, depGroup.TerraformBackend, groupPath,
+		doctoredModules, locals, depGroup.TerraformBackend, groupPath,
 	); err != nil {
 		return fmt.Errorf("error writing main.tf file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
 
 	// Write variables.tf file
-	if err := writeVariables(deploymentVars, maps.Values(intergroupVars), groupPath); err != nil {
+	secretVars := dc.Config.SecretVars()
+	sensitiveVars := append(dc.Config.SensitiveVars(), maps.Keys(secretVars)...)
+	// inputs of sensitive outputs of earlier groups stay sensitive
+	for _, r := range dc.Config.SensitiveOutputs() {
+		if v, ok := intergroupVars[r]; ok {
+			sensitiveVars = append(sensitiveVars, v.Name)
+		}
+	}
+	// sensitive outputs of other deployments have the type of the output
+	// rather than of their reference
+	tfVars := maps.Clone(deploymentVars)
+	extraVars := maps.Values(intergroupVars)
+	remoteVars := dc.Config.RemoteOutputVars()
+	for n := range remoteVars {
+		if _, ok := tfVars[n]; !ok {
+			continue
+		}
+		delete(tfVars, n)
+		ty := dc.Config.DeploymentVariables[n].Type
+		if ty == "" {
+			ty = "any"
+		}
+		extraVars = append(extraVars, modulereader.VarInfo{
+			Name:        n,
+			Type:        ty,
+			Description: fmt.Sprintf("Toolkit deployment variable: %s", n),
+		})
+	}
+	if err := writeVariables(
+		tfVars, extraVars, sensitiveVars, groupPath,
+	); err != nil {
 		return fmt.Errorf(
 			"error writing variables.tf file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
 
 	// Write outputs.tf file
-	if err := writeOutputs(depGroup.Modules, groupPath); err != nil {
+	if err := writeOutputs(depGroup.Modules, dc.Config.ProvenanceValue(), groupPath); err != nil {
 		return fmt.Errorf(
 			"error writing outputs.tf file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
 
-	// Write terraform.tfvars file
-	if err := writeTfvars(deploymentVars, groupPath); err != nil {
+	// Write terraform.tfvars file, without the variables read from Secret
+	// Manager or sensitive outputs of other deployments, which are passed to
+	// Terraform when it is run
+	tfvars := maps.Clone(deploymentVars)
+	for n := range secretVars {
+		delete(tfvars, n)
+	}
+	for n := range remoteVars {
+		delete(tfvars, n)
+	}
+	if err := writeTfvars(tfvars, groupPath); err != nil {
 		return fmt.Errorf(
 			"error writing terraform.tfvars file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
 
 	// Write providers.tf file
-	if err := writeProviders(deploymentVars, groupPath); err != nil {
+	if err := writeProviders(dc.Config.ProviderBlocks(depGroup, deploymentVars), groupPath); err != nil {
 		return fmt.Errorf(
 			"error writing providers.tf file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
 
 	// Write versions.tf file
-	if err := writeVersions(groupPath); err != nil {
+	providers, err := dc.Config.GroupRequiredProviders(depGroup)
+	if err != nil {
+		return fmt.Errorf(
+			"error merging the provider requirements of deployment group %s: %v",
+			depGroup.Name, err)
+	}
+	if err := writeVersions(providers, groupPath); err != nil {
 		return fmt.Errorf(
 			"error writing versions.tf file for deployment group %s: %v",
 			depGroup.Name, err)
 	}
 
+	if dc.Terragrunt {
+		if err := writeTerragrunt(depGroup, dc.Config, groupPath); err != nil {
+			return fmt.Errorf(
+				"error writing %s file for deployment group %s: %v",
+				TerragruntFilename, depGroup.Name, err)
+		}
+	}
+
 	multiGroupDeployment := len(dc.Config.DeploymentGroups) > 1
 	printImportInputs := multiGroupDeployment && groupIndex > 0
 	printExportOutputs := multiGroupDeployment && groupIndex < len(dc.Config.DeploymentGroups)-1
@@ -416,7 +551,8 @@ func (w TFWriter) writeDeploymentGroup(
 	return nil
 }
 
-// Transfers state files from previous resource groups (in .ghpc/) to a newly written blueprint
+// Transfers state files and dependency lock files from previous resource
+// groups (in .ghpc/) to a newly written blueprint
 func (w TFWriter) restoreState(deploymentDir string) error {
 	prevDeploymentGroupPath := filepath.Join(
 		deploymentDir, HiddenGhpcDirName, prevDeploymentGroupDirName)
@@ -428,7 +564,7 @@ func (w TFWriter) restoreState(deploymentDir string) error {
 	}
 
 	for _, f := range files {
-		var tfStateFiles = []string{tfStateFileName, tfStateBackupFileName}
+		var tfStateFiles = []string{tfStateFileName, tfStateBackupFileName, TFLockFileName}
 		for _, stateFile := range tfStateFiles {
 			src := filepath.Join(prevDeploymentGroupPath, f.Name(), stateFile)
 			dest := filepath.Join(deploymentDir, f.Name(), stateFile)
@@ -460,14 +596,28 @@ func getUsedDeploymentVars(group config.DeploymentGroup, bp config.Blueprint) ma
 		"labels": true,
 	}
 
+	// including the variables used by the module variables of the group,
+	// which are written as locals
 	for _, mod := range group.Modules {
-		for _, v := range config.GetUsedDeploymentVars(mod.Settings.AsObject()) {
+		for _, v := range config.GetUsedDeploymentVars(bp.WithModuleVars(mod.Settings.AsObject())) {
 			groupInputs[v] = true
 		}
 	}
+	for _, v := range bp.GroupModuleVars(group) {
+		delete(groupInputs, v)
+	}
+
+	// the project of the group is always passed to the providers
+	if group.ProjectID != "" {
+		groupInputs[bp.TargetCloud().ProjectVar] = true
+	}
+	for _, v := range bp.ProviderVars() {
+		groupInputs[v] = true
+	}
 
 	filteredVars := make(map[string]cty.Value)
-	for key, val := range bp.Vars.Items() {
+	vars := bp.GroupVars(group)
+	for key, val := range vars.Items() {
 		if groupInputs[key] {
 			filteredVars[key] = val
 		}
@@ -486,7 +636,15 @@ func substituteIgcReferences(mods []config.Module, igcRefs map[config.Reference]
 // SubstituteIgcReferencesInModule updates expressions in Module settings to use
 // special IGC var name instead of the module reference
 func SubstituteIgcReferencesInModule(mod config.Module, igcRefs map[config.Reference]modulereader.VarInfo) config.Module {
-	v, _ := cty.Transform(mod.Settings.AsObject(), func(p cty.Path, v cty.Value) (cty.Value, error) {
+	v := substituteIgcReferencesInValue(mod.Settings.AsObject(), igcRefs)
+	mod.Settings = config.NewDict(v.AsValueMap())
+	return mod
+}
+
+// substituteIgcReferencesInValue updates expressions in the value to use
+// special IGC var name instead of the module reference
+func substituteIgcReferencesInValue(val cty.Value, igcRefs map[config.Reference]modulereader.VarInfo) cty.Value {
+	v, _ := cty.Transform(val, func(p cty.Path, v cty.Value) (cty.Value, error) {
 		e, is := config.IsExpressionValue(v)
 		if !is {
 			return v, nil
@@ -501,10 +659,40 @@ func SubstituteIgcReferencesInModule(mod config.Module, igcRefs map[config.Refer
 			rs := fmt.Sprintf("var.%s", oi.Name)
 			ue = strings.ReplaceAll(ue, s, rs)
 		}
+		if _, raw := e.(config.RawExpression); raw {
+			re, err := config.ParseRawExpression(ue)
+			if err != nil {
+				return v, err
+			}
+			return re.AsValue(), nil
+		}
 		return config.MustParseExpression(ue).AsValue(), nil
 	})
-	mod.Settings = config.NewDict(v.AsValueMap())
-	return mod
+	return v
+}
+
+// localTokens returns the tokens with the references to module variables,
+// deployment variables written as locals, replaced by references to the locals
+func localTokens(toks hclwrite.Tokens, locals map[string]cty.Value) hclwrite.Tokens {
+	if len(locals) == 0 {
+		return toks
+	}
+	sToks, _ := hclsyntax.LexExpression(toks.Bytes(), "", hcl.Pos{})
+	res := hclwrite.Tokens{}
+	for i, st := range sToks {
+		b := st.Bytes
+		if st.Type == hclsyntax.TokenIdent && string(b) == "var" && i+2 < len(sToks) &&
+			sToks[i+1].Type == hclsyntax.TokenDot && sToks[i+2].Type == hclsyntax.TokenIdent {
+			if _, ok := locals[string(sToks[i+2].Bytes)]; ok {
+				b = []byte("local")
+			}
+		}
+		if st.Type == hclsyntax.TokenEOF {
+			continue
+		}
+		res = append(res, &hclwrite.Token{Type: st.Type, Bytes: b})
+	}
+	return res
 }
 
 // FindIntergroupVariables returns all unique intergroup references made by
@@ -516,7 +704,7 @@ func FindIntergroupVariables(group config.DeploymentGroup, bp config.Blueprint)
 		n := config.AutomaticOutputName(r.Name, r.Module)
 		res[r] = modulereader.VarInfo{
 			Name:        n,
-			Type:        getHclType(cty.DynamicPseudoType),
+			Type:        typeexpr.TypeString(bp.OutputType(r)),
 			Description: "Automatically generated input from previous groups (ghpc import-inputs --help)",
 			Required:    true,
 		}
@@ -0,0 +1,90 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package offline configures ghpc to run without network access: module
+// sources are read from a local mirror directory, which also holds the
+// Terraform providers used by deployments
+package offline
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"hpc-toolkit/pkg/cache"
+)
+
+// MirrorDirEnvVar sets the mirror directory if no directory is given
+const MirrorDirEnvVar = "GHPC_MIRROR_DIR"
+
+const (
+	modulesDir   = "modules"
+	providersDir = "providers"
+)
+
+// Enabled controls whether module sources are read from the mirror instead
+// of being fetched over the network
+var Enabled = false
+
+// MirrorDir is the absolute path of the root directory of the mirror
+var MirrorDir string
+
+// Enable enables offline mode with the mirror at dir, or at the directory set
+// by MirrorDirEnvVar if dir is empty
+func Enable(dir string) error {
+	if dir == "" {
+		dir = os.Getenv(MirrorDirEnvVar)
+	}
+	if dir == "" {
+		return fmt.Errorf("offline mode requires a mirror directory, set it with --mirror-dir or %s", MirrorDirEnvVar)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("invalid mirror directory %s: %w", dir, err)
+	}
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		return fmt.Errorf("mirror directory %s does not exist", dir)
+	}
+	Enabled, MirrorDir = true, abs
+	return nil
+}
+
+// ModuleDirName returns the name of the directory a module path is mirrored
+// to: the last element of the source, made unique by a hash of the module path
+func ModuleDirName(modPath string) string {
+	source, _, _ := strings.Cut(modPath, "?")
+	if _, subdir, found := strings.Cut(strings.TrimPrefix(source, "git::"), "//"); found && subdir != "" {
+		source = subdir
+	}
+	name := path.Base(strings.TrimSuffix(source, ".git"))
+	return fmt.Sprintf("%s-%s", name, cache.Key(modPath)[:8])
+}
+
+// ModulesDir returns the directory of the mirror that holds module sources
+func ModulesDir() string {
+	return filepath.Join(MirrorDir, modulesDir)
+}
+
+// ModuleDir returns the directory of the mirror that holds a module path
+func ModuleDir(modPath string) string {
+	return filepath.Join(ModulesDir(), ModuleDirName(modPath))
+}
+
+// ProvidersDir returns the directory of the mirror that holds Terraform
+// providers, in the layout of a Terraform filesystem mirror
+func ProvidersDir() string {
+	return filepath.Join(MirrorDir, providersDir)
+}
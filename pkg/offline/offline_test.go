@@ -0,0 +1,72 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package offline
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const registryNetwork = "registry.terraform.io/terraform-google-modules/network/google"
+
+func TestModuleDirName(t *testing.T) {
+	for _, tc := range []struct {
+		modPath string
+		prefix  string
+	}{
+		{"github.com/org/repo//modules/network/vpc?ref=v1.0.0", "vpc-"},
+		{"git::https://example.com/org/repo.git?ref=main", "repo-"},
+		{registryNetwork + "?version=7.3.0", "google-"},
+		{registryNetwork + "//modules/subnets?version=7.3.0", "subnets-"},
+	} {
+		if got := ModuleDirName(tc.modPath); !strings.HasPrefix(got, tc.prefix) || len(got) != len(tc.prefix)+8 {
+			t.Errorf("ModuleDirName(%q) = %q, want %s<hash>", tc.modPath, got, tc.prefix)
+		}
+	}
+	if ModuleDirName(registryNetwork+"?version=7.3.0") == ModuleDirName(registryNetwork+"?version=7.2.0") {
+		t.Error("versions of a module must be mirrored to different directories")
+	}
+}
+
+func TestEnable(t *testing.T) {
+	defer func(e bool, d string) { Enabled, MirrorDir = e, d }(Enabled, MirrorDir)
+	dir := t.TempDir()
+
+	t.Setenv(MirrorDirEnvVar, "")
+	if err := Enable(""); err == nil {
+		t.Error("expected an error without a mirror directory")
+	}
+	if err := Enable(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing mirror directory")
+	}
+	if Enabled {
+		t.Fatal("offline mode enabled after errors")
+	}
+
+	t.Setenv(MirrorDirEnvVar, dir)
+	if err := Enable(""); err != nil {
+		t.Fatal(err)
+	}
+	if !Enabled || MirrorDir != dir {
+		t.Errorf("got Enabled=%v MirrorDir=%q, want true and %q", Enabled, MirrorDir, dir)
+	}
+	if got, want := ModuleDir("github.com/org/repo"), filepath.Join(dir, "modules", ModuleDirName("github.com/org/repo")); got != want {
+		t.Errorf("got module dir %q, want %q", got, want)
+	}
+	if got, want := ProvidersDir(), filepath.Join(dir, "providers"); got != want {
+		t.Errorf("got providers dir %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy evaluates Rego policies against expanded blueprints with the
+// Open Policy Agent CLI, so that organizations can enforce rules such as "no
+// public IPs" or "only approved machine types" before deployments are written
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// BundleEnvVar sets the policy bundle if no bundle is given
+const BundleEnvVar = "GHPC_POLICY_BUNDLE"
+
+// Package is the Rego package whose deny and warn rules are evaluated
+const Package = "ghpc"
+
+// Levels of the results of policies
+const (
+	Deny = "deny"
+	Warn = "warn"
+)
+
+// replaced in tests
+var execCommand = exec.Command
+
+// Result is a message of a deny or warn rule
+type Result struct {
+	Level   string
+	Message string
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("policy %s: %s", r.Level, r.Message)
+}
+
+// Evaluate evaluates the policies of the bundle against input, a JSON
+// document, and returns the messages of the deny rules followed by those of
+// the warn rules. The bundle is a directory or file of Rego policies and
+// data, or a bundle archive (.tar.gz).
+func Evaluate(bundle string, input []byte) ([]Result, error) {
+	flag := "--data"
+	if strings.HasSuffix(bundle, ".tar.gz") || strings.HasSuffix(bundle, ".tgz") {
+		flag = "--bundle"
+	}
+	cmd := execCommand("opa", "eval", "--format", "json", "--stdin-input", flag, bundle, "data."+Package)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("the opa command must be installed to evaluate the policies of %s, see https://www.openpolicyagent.org/docs/latest/#running-opa", bundle)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate the policies of %s: %v\n%s%s", bundle, err, out, stderr.String())
+	}
+	return parse(out)
+}
+
+// parse reads the results from the output of "opa eval --format json"
+func parse(out []byte) ([]Result, error) {
+	var res struct {
+		Result []struct {
+			Expressions []struct {
+				Value map[string]json.RawMessage `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse the output of opa: %v", err)
+	}
+	if len(res.Result) == 0 || len(res.Result[0].Expressions) == 0 {
+		return nil, fmt.Errorf("the policy bundle defines no rules in package %s", Package)
+	}
+	value := res.Result[0].Expressions[0].Value
+
+	results := []Result{}
+	for _, level := range []string{Deny, Warn} {
+		raw, ok := value[level]
+		if !ok {
+			continue
+		}
+		msgs, err := messages(raw)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s.%s: %v", Package, level, err)
+		}
+		for _, m := range msgs {
+			results = append(results, Result{Level: level, Message: m})
+		}
+	}
+	return results, nil
+}
+
+// messages reads the messages of a rule, a set of strings or of objects with
+// a msg field
+func messages(raw json.RawMessage) ([]string, error) {
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil {
+		return nil, fmt.Errorf("must be a set of messages")
+	}
+	msgs := []string{}
+	for _, e := range elems {
+		var s string
+		if json.Unmarshal(e, &s) == nil {
+			msgs = append(msgs, s)
+			continue
+		}
+		var o struct {
+			Msg string `json:"msg"`
+		}
+		if json.Unmarshal(e, &o) == nil && o.Msg != "" {
+			msgs = append(msgs, o.Msg)
+			continue
+		}
+		msgs = append(msgs, string(e))
+	}
+	slices.Sort(msgs)
+	return msgs, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	type test struct {
+		out  string
+		want []Result
+		err  string
+	}
+	tests := map[string]test{
+		"no results": {`{"result":[{"expressions":[{"value":{"deny":[],"warn":[]}}]}]}`, []Result{}, ""},
+		"only deny":  {`{"result":[{"expressions":[{"value":{"deny":["b","a"]}}]}]}`, []Result{{Deny, "a"}, {Deny, "b"}}, ""},
+		"deny and warn": {`{"result":[{"expressions":[{"value":{"warn":["w"],"deny":["d"],"other":true}}]}]}`,
+			[]Result{{Deny, "d"}, {Warn, "w"}}, ""},
+		"objects": {`{"result":[{"expressions":[{"value":{"deny":[{"msg":"m"},{"id":1}]}}]}]}`,
+			[]Result{{Deny, "m"}, {Deny, `{"id":1}`}}, ""},
+		"undefined": {`{}`, nil, "no rules in package ghpc"},
+		"not a set": {`{"result":[{"expressions":[{"value":{"deny":true}}]}]}`, nil, "ghpc.deny: must be a set"},
+		"not json":  {`oops`, nil, "failed to parse"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := parse([]byte(tc.out))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want an error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	e := execCommand
+	defer func() { execCommand = e }()
+	inputFile := filepath.Join(t.TempDir(), "input.json")
+	var args []string
+	execCommand = func(name string, arg ...string) *exec.Cmd {
+		args = append([]string{name}, arg...)
+		out := `{"result":[{"expressions":[{"value":{"deny":["d"],"warn":["w"]}}]}]}`
+		return exec.Command("sh", "-c", `cat > "$0"; printf '%s' "$1"`, inputFile, out)
+	}
+
+	got, err := Evaluate("policies", []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]Result{{Deny, "d"}, {Warn, "w"}}, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	wantArgs := []string{"opa", "eval", "--format", "json", "--stdin-input", "--data", "policies", "data.ghpc"}
+	if diff := cmp.Diff(wantArgs, args); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if input, _ := os.ReadFile(inputFile); string(input) != `{"a":1}` {
+		t.Errorf("got input %q", input)
+	}
+
+	if _, err := Evaluate("bundle.tar.gz", nil); err != nil {
+		t.Fatal(err)
+	}
+	if args[5] != "--bundle" {
+		t.Errorf("got %v, want --bundle for archives", args)
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	e := execCommand
+	defer func() { execCommand = e }()
+
+	execCommand = func(name string, arg ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "echo 'rego_parse_error'; exit 1")
+	}
+	if _, err := Evaluate("policies", nil); err == nil || !strings.Contains(err.Error(), "rego_parse_error") {
+		t.Errorf("got %v, want the output of opa in the error", err)
+	}
+
+	execCommand = func(name string, arg ...string) *exec.Cmd {
+		return exec.Command("ghpc-test-no-such-command")
+	}
+	if _, err := Evaluate("policies", nil); err == nil || !strings.Contains(err.Error(), "must be installed") {
+		t.Errorf("got %v, want an error asking to install opa", err)
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// replaced in tests
+var (
+	cloudBuildURL = "https://cloudbuild.googleapis.com"
+	storageURL    = "https://storage.googleapis.com"
+)
+
+// workspaceArchive is the archive of the workspace of a build after ghpc ran,
+// written back to the deployment directory
+const workspaceArchive = ".ghpc-workspace.tar.gz"
+
+// defaultClient returns an HTTP client that authenticates with the
+// application default credentials
+func defaultClient(ctx context.Context) (*http.Client, error) {
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloud Build client: %w", err)
+	}
+	return client, nil
+}
+
+// cloudBuildRunner runs ghpc in a Cloud Build build whose source is the
+// deployment directory, staged with its logs and workspace in a bucket
+type cloudBuildRunner struct {
+	client       *http.Client
+	project      string
+	bucket       string
+	image        string
+	timeout      time.Duration
+	pollInterval time.Duration
+}
+
+type buildStep struct {
+	Name         string   `json:"name"`
+	Entrypoint   string   `json:"entrypoint,omitempty"`
+	Args         []string `json:"args,omitempty"`
+	AllowFailure bool     `json:"allowFailure,omitempty"`
+	Status       string   `json:"status,omitempty"`
+}
+
+type storageSource struct {
+	Bucket string `json:"bucket"`
+	Object string `json:"object"`
+}
+
+type buildSource struct {
+	StorageSource storageSource `json:"storageSource"`
+}
+
+type buildOptions struct {
+	Logging string `json:"logging"`
+}
+
+type artifactObjects struct {
+	Location string   `json:"location"`
+	Paths    []string `json:"paths"`
+}
+
+type buildArtifacts struct {
+	Objects artifactObjects `json:"objects"`
+}
+
+// build is the part of a Cloud Build build used by the runner
+type build struct {
+	ID         string          `json:"id,omitempty"`
+	Status     string          `json:"status,omitempty"`
+	Source     *buildSource    `json:"source,omitempty"`
+	Steps      []buildStep     `json:"steps"`
+	Timeout    string          `json:"timeout,omitempty"`
+	LogsBucket string          `json:"logsBucket,omitempty"`
+	Options    *buildOptions   `json:"options,omitempty"`
+	Artifacts  *buildArtifacts `json:"artifacts,omitempty"`
+}
+
+// finished returns whether the build reached a final status
+func (b build) finished() bool {
+	switch b.Status {
+	case "SUCCESS", "FAILURE", "INTERNAL_ERROR", "TIMEOUT", "CANCELLED", "EXPIRED":
+		return true
+	}
+	return false
+}
+
+// responseError returns an error with the status and body of a failed request
+func responseError(resp *http.Response, format string, a ...interface{}) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s: %s: %s", fmt.Sprintf(format, a...), resp.Status, strings.TrimSpace(string(body)))
+}
+
+func (r *cloudBuildRunner) do(ctx context.Context, method string, u string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return r.client.Do(req)
+}
+
+// upload writes data to the object of the staging bucket
+func (r *cloudBuildRunner) upload(ctx context.Context, object string, data []byte) error {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", storageURL, url.PathEscape(r.bucket), url.QueryEscape(object))
+	resp, err := r.do(ctx, http.MethodPost, u, bytes.NewReader(data), http.Header{"Content-Type": {"application/gzip"}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "failed to upload gs://%s/%s", r.bucket, object)
+	}
+	return nil
+}
+
+// download returns the object of the bucket from offset on; it is empty if
+// the object does not exist or has no data after offset
+func (r *cloudBuildRunner) download(ctx context.Context, bucket string, object string, offset int64) ([]byte, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", storageURL, url.PathEscape(bucket), url.PathEscape(object))
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := r.do(ctx, http.MethodGet, u, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable:
+		return nil, nil
+	default:
+		return nil, responseError(resp, "failed to download gs://%s/%s", bucket, object)
+	}
+}
+
+// submit creates the build and returns its ID
+func (r *cloudBuildRunner) submit(ctx context.Context, b build) (string, error) {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("%s/v1/projects/%s/builds", cloudBuildURL, url.PathEscape(r.project))
+	resp, err := r.do(ctx, http.MethodPost, u, bytes.NewReader(body), http.Header{"Content-Type": {"application/json"}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, "failed to create build in project %s", r.project)
+	}
+	var op struct {
+		Metadata struct {
+			Build build `json:"build"`
+		} `json:"metadata"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&op); err != nil {
+		return "", err
+	}
+	if op.Metadata.Build.ID == "" {
+		return "", fmt.Errorf("the build created in project %s has no ID", r.project)
+	}
+	return op.Metadata.Build.ID, nil
+}
+
+func (r *cloudBuildRunner) get(ctx context.Context, id string) (build, error) {
+	var b build
+	u := fmt.Sprintf("%s/v1/projects/%s/builds/%s", cloudBuildURL, url.PathEscape(r.project), url.PathEscape(id))
+	resp, err := r.do(ctx, http.MethodGet, u, nil, nil)
+	if err != nil {
+		return b, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return b, responseError(resp, "failed to get build %s", id)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&b)
+	return b, err
+}
+
+// newBuild returns the build that runs ghpc on the source and stores the
+// workspace, whether ghpc fails or not, as an artifact under prefix
+func (r *cloudBuildRunner) newBuild(object string, prefix string, args []string) build {
+	b := build{
+		Source: &buildSource{StorageSource: storageSource{Bucket: r.bucket, Object: object}},
+		Steps: []buildStep{
+			{Name: r.image, Entrypoint: "ghpc", Args: append(append([]string{}, args...), "."), AllowFailure: true},
+			{Name: r.image, Entrypoint: "tar", Args: []string{
+				"czf", workspaceArchive, "--exclude=" + workspaceArchive, "--exclude=" + excludedDir, "."}},
+		},
+		LogsBucket: fmt.Sprintf("gs://%s/%s", r.bucket, prefix),
+		Options:    &buildOptions{Logging: "GCS_ONLY"},
+		Artifacts: &buildArtifacts{Objects: artifactObjects{
+			Location: fmt.Sprintf("gs://%s/%s/", r.bucket, prefix),
+			Paths:    []string{workspaceArchive},
+		}},
+	}
+	if r.timeout > 0 {
+		b.Timeout = fmt.Sprintf("%ds", int64(r.timeout.Seconds()))
+	}
+	return b
+}
+
+// Run packs the deployment directory, runs ghpc on it in a build, streaming
+// the build log, and unpacks the workspace of the build to the deployment
+// directory
+func (r *cloudBuildRunner) Run(ctx context.Context, deploymentDir string, args []string, out io.Writer) error {
+	var src bytes.Buffer
+	if err := packDir(deploymentDir, &src); err != nil {
+		return err
+	}
+	prefix := path.Join("ghpc", filepath.Base(filepath.Clean(deploymentDir)), time.Now().UTC().Format("20060102T150405Z"))
+	object := path.Join(prefix, "source.tar.gz")
+	log.Printf("uploading deployment %s to gs://%s/%s", deploymentDir, r.bucket, object)
+	if err := r.upload(ctx, object, src.Bytes()); err != nil {
+		return err
+	}
+
+	id, err := r.submit(ctx, r.newBuild(object, prefix, args))
+	if err != nil {
+		return err
+	}
+	log.Printf("running ghpc %s in Cloud Build build %s of project %s", strings.Join(args, " "), id, r.project)
+
+	logObject := path.Join(prefix, fmt.Sprintf("log-%s.txt", id))
+	var offset int64
+	streamLog := func() error {
+		data, err := r.download(ctx, r.bucket, logObject, offset)
+		if err != nil {
+			return err
+		}
+		offset += int64(len(data))
+		_, err = out.Write(data)
+		return err
+	}
+	var b build
+	for {
+		if b, err = r.get(ctx, id); err != nil {
+			return err
+		}
+		if err := streamLog(); err != nil {
+			return err
+		}
+		if b.finished() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.pollInterval):
+		}
+	}
+	if b.Status != "SUCCESS" {
+		return fmt.Errorf("build %s finished with status %s", id, b.Status)
+	}
+
+	ws, err := r.download(ctx, r.bucket, path.Join(prefix, workspaceArchive), 0)
+	if err != nil {
+		return err
+	}
+	if len(ws) == 0 {
+		return fmt.Errorf("build %s did not store its workspace in gs://%s/%s", id, r.bucket, prefix)
+	}
+	if err := unpackDir(bytes.NewReader(ws), deploymentDir); err != nil {
+		return fmt.Errorf("failed to write the workspace of build %s to %s: %w", id, deploymentDir, err)
+	}
+	if len(b.Steps) > 0 && b.Steps[0].Status != "SUCCESS" {
+		return fmt.Errorf("ghpc failed in build %s with status %s", id, b.Steps[0].Status)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeCloudBuild implements the parts of the Cloud Build and Cloud Storage
+// APIs used by the runner; the build succeeds on its second poll, adding a
+// state file to the workspace
+type fakeCloudBuild struct {
+	mu      sync.Mutex
+	t       *testing.T
+	objects map[string][]byte
+	build   build
+	polls   int
+	// ghpcStatus is the status of the ghpc step
+	ghpcStatus string
+}
+
+func newFakeCloudBuild(t *testing.T) *fakeCloudBuild {
+	f := &fakeCloudBuild{t: t, objects: map[string][]byte{}, ghpcStatus: "SUCCESS"}
+	srv := httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(srv.Close)
+	c, s := cloudBuildURL, storageURL
+	t.Cleanup(func() { cloudBuildURL, storageURL = c, s })
+	cloudBuildURL, storageURL = srv.URL, srv.URL
+	return f
+}
+
+func (f *fakeCloudBuild) prefix() string {
+	return strings.TrimPrefix(f.build.LogsBucket, "gs://bkt/")
+}
+
+// finish runs the build: the workspace is the source with a state file
+func (f *fakeCloudBuild) finish() {
+	dir := f.t.TempDir()
+	src := f.objects["bkt/"+f.build.Source.StorageSource.Object]
+	if err := unpackDir(bytes.NewReader(src), dir); err != nil {
+		f.t.Error(err)
+	}
+	writeFiles(f.t, dir, map[string]string{"primary/terraform.tfstate": "applied"})
+	var ws bytes.Buffer
+	if err := packDir(dir, &ws); err != nil {
+		f.t.Error(err)
+	}
+	f.objects["bkt/"+f.prefix()+"/"+workspaceArchive] = ws.Bytes()
+	f.build.Status = "SUCCESS"
+	f.build.Steps[0].Status = f.ghpcStatus
+	f.build.Steps[1].Status = "SUCCESS"
+}
+
+func (f *fakeCloudBuild) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/"):
+		bucket := strings.Split(strings.TrimPrefix(r.URL.Path, "/upload/storage/v1/b/"), "/")[0]
+		data, _ := io.ReadAll(r.Body)
+		f.objects[bucket+"/"+r.URL.Query().Get("name")] = data
+		w.Write([]byte("{}"))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/"):
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"), "/o/", 2)
+		name, _ := url.PathUnescape(parts[1])
+		data, ok := f.objects[parts[0]+"/"+name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var offset int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset)
+		if offset >= len(data) && offset > 0 {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Write(data[offset:])
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/projects/proj/builds":
+		if err := json.NewDecoder(r.Body).Decode(&f.build); err != nil {
+			f.t.Error(err)
+		}
+		f.build.ID, f.build.Status = "b1", "QUEUED"
+		json.NewEncoder(w).Encode(map[string]interface{}{"metadata": map[string]interface{}{"build": f.build}})
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/projects/proj/builds/b1":
+		f.polls++
+		logName := "bkt/" + f.prefix() + "/log-b1.txt"
+		f.objects[logName] = append(f.objects[logName], []byte(fmt.Sprintf("poll %d\n", f.polls))...)
+		if f.polls == 2 {
+			f.finish()
+		}
+		json.NewEncoder(w).Encode(f.build)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestCloudBuildRun(t *testing.T) {
+	f := newFakeCloudBuild(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"primary/main.tf": "module {}"})
+	r := cloudBuildRunner{client: http.DefaultClient, project: "proj", bucket: "bkt", image: "ghpc:test"}
+
+	var out bytes.Buffer
+	if err := r.Run(context.Background(), dir, []string{"deploy", "--auto-approve"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "poll 1\npoll 2\n"; got != want {
+		t.Errorf("got log %q, want %q", got, want)
+	}
+	if diff := cmp.Diff([]string{"deploy", "--auto-approve", "."}, f.build.Steps[0].Args); diff != "" {
+		t.Errorf("diff of ghpc arguments (-want +got):\n%s", diff)
+	}
+	want := map[string]string{"primary/main.tf": "module {}", "primary/terraform.tfstate": "applied"}
+	if diff := cmp.Diff(want, readFiles(t, dir)); diff != "" {
+		t.Errorf("diff of deployment (-want +got):\n%s", diff)
+	}
+
+	// the workspace is written back when ghpc fails
+	f = newFakeCloudBuild(t)
+	f.ghpcStatus = "FAILURE"
+	dir = t.TempDir()
+	writeFiles(t, dir, map[string]string{"primary/main.tf": "module {}"})
+	if err := r.Run(context.Background(), dir, []string{"deploy"}, io.Discard); err == nil {
+		t.Error("expected an error when ghpc fails")
+	}
+	if diff := cmp.Diff(want, readFiles(t, dir)); diff != "" {
+		t.Errorf("diff of deployment (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// workspace is the directory the deployment directory is mounted to in the
+// container
+const workspace = "/workspace"
+
+// containerRunner runs ghpc in a container whose workspace is the deployment
+// directory, with the application default credentials of this machine
+type containerRunner struct {
+	engine string
+	image  string
+}
+
+// credentialsFile returns the application default credentials of this
+// machine, empty if there are none
+func credentialsFile() string {
+	if f := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); f != "" {
+		return f
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	f := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+	if _, err := os.Stat(f); err != nil {
+		return ""
+	}
+	return f
+}
+
+// command returns the arguments of the container engine that run ghpc with
+// args on the deployment directory
+func (r *containerRunner) command(deploymentDir string, args []string, credentials string) ([]string, error) {
+	dir, err := filepath.Abs(deploymentDir)
+	if err != nil {
+		return nil, err
+	}
+	cmd := []string{"run", "--rm", "-v", dir + ":" + workspace, "-w", workspace, "-e", "HOME=/tmp"}
+	// files written to the deployment directory are owned by the user
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 {
+		cmd = append(cmd, "--user", fmt.Sprintf("%d:%d", uid, gid))
+	}
+	if credentials != "" {
+		cmd = append(cmd, "-v", credentials+":/credentials.json:ro", "-e", "GOOGLE_APPLICATION_CREDENTIALS=/credentials.json")
+	}
+	cmd = append(cmd, "--entrypoint", "ghpc", r.image)
+	cmd = append(cmd, args...)
+	return append(cmd, "."), nil
+}
+
+// Run runs ghpc in the container, which writes to the deployment directory
+// directly
+func (r *containerRunner) Run(ctx context.Context, deploymentDir string, args []string, out io.Writer) error {
+	credentials := credentialsFile()
+	if credentials == "" {
+		log.Printf("no application default credentials found, running ghpc in %s without credentials", r.image)
+	}
+	cmdArgs, err := r.command(deploymentDir, args, credentials)
+	if err != nil {
+		return err
+	}
+	log.Printf("running ghpc %s in container %s", strings.Join(args, " "), r.image)
+	cmd := exec.CommandContext(ctx, r.engine, cmdArgs...)
+	cmd.Stdout, cmd.Stderr = out, out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ghpc failed in container %s: %w", r.image, err)
+	}
+	return nil
+}
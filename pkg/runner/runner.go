@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package runner runs ghpc on a deployment directory in a remote execution
+// environment, such as Cloud Build or a container, which provides terraform,
+// packer and credentials, and streams its output back
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Names of the runners
+const (
+	// Local runs terraform and packer on this machine, without a runner
+	Local      = "local"
+	CloudBuild = "cloudbuild"
+	Container  = "container"
+)
+
+// Names returns the names of the runners
+func Names() []string {
+	return []string{Local, CloudBuild, Container}
+}
+
+// Runner runs ghpc in an execution environment
+type Runner interface {
+	// Run runs ghpc with args, followed by the copy of the deployment
+	// directory in the environment, and writes its output to out. Changes
+	// ghpc makes to the deployment directory, e.g. to the local Terraform
+	// state, are written back to it.
+	Run(ctx context.Context, deploymentDir string, args []string, out io.Writer) error
+}
+
+// Options configure the runners
+type Options struct {
+	// Image is the container image providing ghpc, terraform, packer and tar
+	Image string
+	// Project is the project Cloud Build builds run in
+	Project string
+	// Bucket is the Cloud Storage bucket Cloud Build builds are staged in
+	Bucket string
+	// Timeout is the maximum duration of Cloud Build builds
+	Timeout time.Duration
+}
+
+// New returns the runner with the name, nil for the local runner
+func New(ctx context.Context, name string, o Options) (Runner, error) {
+	switch name {
+	case Local:
+		return nil, nil
+	case CloudBuild:
+		if o.Image == "" || o.Project == "" || o.Bucket == "" {
+			return nil, fmt.Errorf("runner %s requires an image, a project and a staging bucket", name)
+		}
+		client, err := defaultClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &cloudBuildRunner{client: client, project: o.Project, bucket: o.Bucket, image: o.Image,
+			timeout: o.Timeout, pollInterval: 5 * time.Second}, nil
+	case Container:
+		if o.Image == "" {
+			return nil, fmt.Errorf("runner %s requires an image", name)
+		}
+		return &containerRunner{engine: "docker", image: o.Image}, nil
+	default:
+		return nil, fmt.Errorf("unknown runner %q, must be one of %s", name, strings.Join(Names(), ", "))
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPackUnpackDir(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"primary/main.tf":                    "module {}",
+		"primary/terraform.tfstate":          "{}",
+		"primary/.terraform/providers/x":     "provider",
+		".ghpc/artifacts/expanded_blueprint": "blueprint_name: test",
+	})
+	writeFiles(t, dst, map[string]string{"primary/main.tf": "old"})
+
+	var buf bytes.Buffer
+	if err := packDir(src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := unpackDir(&buf, dst); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"primary/main.tf":                    "module {}",
+		"primary/terraform.tfstate":          "{}",
+		".ghpc/artifacts/expanded_blueprint": "blueprint_name: test",
+	}
+	if diff := cmp.Diff(want, readFiles(t, dst)); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestContainerCommand(t *testing.T) {
+	dir := t.TempDir()
+	r := containerRunner{engine: "docker", image: "ghpc:test"}
+	got, err := r.command(dir, []string{"deploy", "--auto-approve"}, "/creds.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"run", "--rm", "-v", dir + ":/workspace", "-w", "/workspace", "-e", "HOME=/tmp",
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		"-v", "/creds.json:/credentials.json:ro", "-e", "GOOGLE_APPLICATION_CREDENTIALS=/credentials.json",
+		"--entrypoint", "ghpc", "ghpc:test", "deploy", "--auto-approve", "."}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	if r, err := New(ctx, Local, Options{}); r != nil || err != nil {
+		t.Errorf("got %v, %v for the local runner, want nil", r, err)
+	}
+	if _, err := New(ctx, Container, Options{}); err == nil {
+		t.Error("expected an error for a container runner without image")
+	}
+	if _, err := New(ctx, CloudBuild, Options{Image: "ghpc:test"}); err == nil {
+		t.Error("expected an error for a Cloud Build runner without project and bucket")
+	}
+	if _, err := New(ctx, "lambda", Options{}); err == nil {
+		t.Error("expected an error for an unknown runner")
+	}
+	if r, err := New(ctx, Container, Options{Image: "ghpc:test"}); r == nil || err != nil {
+		t.Errorf("got %v, %v for a container runner", r, err)
+	}
+}
@@ -0,0 +1,182 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	"golang.org/x/exp/slices"
+)
+
+// stateBackupsDir is the directory of the .ghpc directory of a deployment
+// where the Terraform states of groups are backed up before they are applied
+// or destroyed; unlike artifacts, it is kept when the deployment is written
+// again
+const stateBackupsDir = "state_backups"
+
+// MaxStateBackups is the number of backups of the state of each group that
+// are kept; older backups are removed when a new one is saved
+const MaxStateBackups = 20
+
+// StateBackup is a backup of the Terraform state of a group
+type StateBackup struct {
+	// Timestamp identifies the backup, e.g. "20230601T120000Z"
+	Timestamp string
+	Time      time.Time
+	Path      string
+}
+
+func stateBackupDir(deploymentRoot string, group config.GroupName) string {
+	return filepath.Join(deploymentRoot, modulewriter.HiddenGhpcDirName, stateBackupsDir, string(group))
+}
+
+// ListStateBackups returns the backups of the state of the group, oldest
+// first
+func ListStateBackups(deploymentRoot string, group config.GroupName) ([]StateBackup, error) {
+	dir := stateBackupDir(deploymentRoot, group)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []StateBackup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := []StateBackup{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".tfstate") {
+			continue
+		}
+		ts := strings.TrimSuffix(e.Name(), ".tfstate")
+		t, err := time.Parse(logTimeFormat, ts)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, StateBackup{Timestamp: ts, Time: t, Path: filepath.Join(dir, e.Name())})
+	}
+	// timestamps sort in chronological order
+	slices.SortFunc(backups, func(a, b StateBackup) bool { return a.Timestamp < b.Timestamp })
+	return backups, nil
+}
+
+// BackupState saves the Terraform state of the group in the working directory
+// of tf to the .ghpc directory of the deployment and returns its path. It
+// returns "" if the group has no state, and the path of the latest backup if
+// it is identical.
+func BackupState(tf *tfexec.Terraform, deploymentRoot string, group config.GroupName) (string, error) {
+	if err := initModule(tf); err != nil {
+		return "", err
+	}
+	state, err := tf.StatePull(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("failed to read the state of group %s: %w", group, err)
+	}
+	if strings.TrimSpace(state) == "" {
+		return "", nil
+	}
+
+	backups, err := ListStateBackups(deploymentRoot, group)
+	if err != nil {
+		return "", err
+	}
+	if len(backups) > 0 {
+		latest := backups[len(backups)-1]
+		if b, err := os.ReadFile(latest.Path); err == nil && string(b) == state {
+			return latest.Path, nil
+		}
+	}
+
+	dir := stateBackupDir(deploymentRoot, group)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, now().UTC().Format(logTimeFormat)+".tfstate")
+	// states may contain secrets
+	if err := os.WriteFile(path, []byte(state), 0600); err != nil {
+		return "", fmt.Errorf("failed to back up the state of group %s: %w", group, err)
+	}
+	log.Printf("backed up the state of group %s to %s", group, path)
+
+	for len(backups) >= MaxStateBackups {
+		if err := os.Remove(backups[0].Path); err != nil {
+			return "", err
+		}
+		backups = backups[1:]
+	}
+	return path, nil
+}
+
+// RestoreState replaces the Terraform state of the group in the working
+// directory of tf with its backup of the timestamp; the current state is
+// backed up first
+func RestoreState(tf *tfexec.Terraform, deploymentRoot string, group config.GroupName, timestamp string) error {
+	backups, err := ListStateBackups(deploymentRoot, group)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(backups, func(b StateBackup) bool { return b.Timestamp == timestamp })
+	if i < 0 {
+		return fmt.Errorf("group %s has no state backup %s; list its backups with \"ghpc state list\"", group, timestamp)
+	}
+	current, err := BackupState(tf, deploymentRoot, group)
+	if err != nil {
+		return err
+	}
+	if current == backups[i].Path {
+		log.Printf("the state of group %s is already the backup %s", group, timestamp)
+		return nil
+	}
+	// the backup is older than the current state, which terraform refuses
+	// to overwrite unless forced
+	if err := tf.StatePush(context.Background(), backups[i].Path, tfexec.Force(true)); err != nil {
+		return fmt.Errorf("failed to restore the state of group %s: %w", group, err)
+	}
+	log.Printf("restored the state of group %s from %s", group, backups[i].Path)
+	return nil
+}
+
+// MigrateBackend migrates the state of the group in the working directory of
+// tf to its new Terraform backend if "ghpc create" recorded that its backend
+// changed; the state is copied and left in the previous backend
+func MigrateBackend(tf *tfexec.Terraform, artifactsDir string, group config.GroupName) error {
+	m, err := modulewriter.ReadBackendMigrations(artifactsDir)
+	if err != nil {
+		return err
+	}
+	mig, ok := m[group]
+	if !ok {
+		return nil
+	}
+	log.Printf("migrating the state of group %s from the %s backend to the %s backend", group, mig.From, mig.To)
+	// -force-copy implies -migrate-state and answers its prompts
+	if err := tf.Init(context.Background(), tfexec.ForceCopy(true)); err != nil {
+		return &TfError{
+			help: fmt.Sprintf("migration of the state of group %s to its new backend failed; run \"terraform init -migrate-state\" in %s", group, tf.WorkingDir()),
+			err:  err,
+		}
+	}
+	delete(m, group)
+	return modulewriter.WriteBackendMigrations(artifactsDir, m)
+}
@@ -0,0 +1,47 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestListStateBackups(c *C) {
+	root := c.MkDir()
+	backups, err := ListStateBackups(root, "primary")
+	c.Assert(err, IsNil)
+	c.Check(backups, HasLen, 0)
+
+	dir := stateBackupDir(root, "primary")
+	c.Assert(os.MkdirAll(dir, 0755), IsNil)
+	for _, n := range []string{"20230602T080000Z.tfstate", "20230601T120000Z.tfstate", "notes.txt", "latest.tfstate"} {
+		c.Assert(os.WriteFile(filepath.Join(dir, n), []byte("{}"), 0600), IsNil)
+	}
+
+	backups, err = ListStateBackups(root, "primary")
+	c.Assert(err, IsNil)
+	c.Check(backups, DeepEquals, []StateBackup{
+		{Timestamp: "20230601T120000Z", Time: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC),
+			Path: filepath.Join(dir, "20230601T120000Z.tfstate")},
+		{Timestamp: "20230602T080000Z", Time: time.Date(2023, 6, 2, 8, 0, 0, 0, time.UTC),
+			Path: filepath.Join(dir, "20230602T080000Z.tfstate")},
+	})
+}
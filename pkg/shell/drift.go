@@ -0,0 +1,135 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"os/exec"
+	"strings"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+// Statuses of the groups of a deployment reported by ghpc status
+const (
+	StatusInSync      = "in_sync"
+	StatusDrifted     = "drifted"
+	StatusNotDeployed = "not_deployed"
+	StatusSkipped     = "skipped"
+	StatusError       = "error"
+)
+
+// DriftedResource is a resource that changed outside of terraform
+type DriftedResource struct {
+	Address string `json:"address"`
+	// Action is what terraform would record in its state: "update" if the
+	// resource was modified, "delete" if it was deleted
+	Action string `json:"action"`
+}
+
+// GroupStatus is whether the resources of a group match its Terraform state
+type GroupStatus struct {
+	Group  config.GroupName  `json:"group"`
+	Status string            `json:"status"`
+	Drift  []DriftedResource `json:"drift,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// DetectDrift runs a refresh-only plan of the group in the working directory
+// of tf and reports the resources that changed outside of terraform
+func DetectDrift(tf *tfexec.Terraform, group config.GroupName) GroupStatus {
+	st := GroupStatus{Group: group}
+	fail := func(err error) GroupStatus {
+		st.Status, st.Error = StatusError, err.Error()
+		return st
+	}
+	if err := initModule(tf); err != nil {
+		return fail(err)
+	}
+	state, err := tf.StatePull(context.Background())
+	if err != nil {
+		return fail(err)
+	}
+	if addrs, _, err := stateInstances(state); err != nil {
+		return fail(err)
+	} else if len(addrs) == 0 {
+		st.Status = StatusNotDeployed
+		return st
+	}
+
+	// terraform-exec does not support refresh-only plans
+	cmd := exec.Command(tf.ExecPath(), "plan", "-refresh-only", "-json", "-input=false", "-lock=false")
+	cmd.Dir = tf.WorkingDir()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, runErr := cmd.Output()
+	drift, errs := parseDrift(out)
+	if runErr != nil {
+		if len(errs) == 0 {
+			errs = []string{strings.TrimSpace(stderr.String())}
+		}
+		return fail(fmt.Errorf("terraform plan -refresh-only failed in %s: %v\n%s", tf.WorkingDir(), runErr, strings.Join(errs, "\n")))
+	}
+
+	st.Status, st.Drift = StatusInSync, drift
+	if len(drift) > 0 {
+		st.Status = StatusDrifted
+	}
+	return st
+}
+
+// driftMessage is a line of the output of terraform plan -json
+type driftMessage struct {
+	Type   string `json:"type"`
+	Change struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"change"`
+	Diagnostic struct {
+		Severity string `json:"severity"`
+		Summary  string `json:"summary"`
+		Detail   string `json:"detail"`
+	} `json:"diagnostic"`
+}
+
+// parseDrift returns the drifted resources and the errors reported in the
+// output of terraform plan -json
+func parseDrift(out []byte) ([]DriftedResource, []string) {
+	drift := []DriftedResource{}
+	errs := []string{}
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		var m driftMessage
+		if err := json.Unmarshal(line, &m); err != nil {
+			continue
+		}
+		switch m.Type {
+		case "resource_drift":
+			drift = append(drift, DriftedResource{Address: m.Change.Resource.Addr, Action: m.Change.Action})
+		case "diagnostic":
+			if m.Diagnostic.Severity == "error" {
+				errs = append(errs, strings.TrimSpace(m.Diagnostic.Summary+": "+m.Diagnostic.Detail))
+			}
+		}
+	}
+	return drift, errs
+}
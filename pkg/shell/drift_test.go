@@ -0,0 +1,40 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestParseDrift(c *C) {
+	out := `{"@level":"info","@message":"Terraform 1.5.0","type":"version"}
+{"@level":"info","@message":"module.network.google_compute_firewall.ssh: Drift detected (update)","change":{"resource":{"addr":"module.network.google_compute_firewall.ssh"},"action":"update"},"type":"resource_drift"}
+{"@level":"info","@message":"google_storage_bucket.logs: Drift detected (delete)","change":{"resource":{"addr":"google_storage_bucket.logs"},"action":"delete"},"type":"resource_drift"}
+{"@level":"warn","@message":"Warning: deprecated","diagnostic":{"severity":"warning","summary":"deprecated","detail":""},"type":"diagnostic"}
+{"@level":"info","@message":"Plan: 0 to add, 0 to change, 0 to destroy.","changes":{"add":0,"change":0,"remove":0,"operation":"plan"},"type":"change_summary"}
+`
+	drift, errs := parseDrift([]byte(out))
+	c.Check(drift, DeepEquals, []DriftedResource{
+		{Address: "module.network.google_compute_firewall.ssh", Action: "update"},
+		{Address: "google_storage_bucket.logs", Action: "delete"},
+	})
+	c.Check(errs, HasLen, 0)
+
+	drift, errs = parseDrift([]byte(`{"@level":"error","diagnostic":{"severity":"error","summary":"No credentials","detail":"run gcloud auth"},"type":"diagnostic"}`))
+	c.Check(drift, HasLen, 0)
+	c.Check(errs, DeepEquals, []string{"No credentials: run gcloud auth"})
+}
@@ -0,0 +1,201 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"bytes"
+	"encoding/json"
+	"hpc-toolkit/pkg/config"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of the events of deploy and destroy
+const (
+	EventGroupStarted    = "group_started"
+	EventPlanSummary     = "plan_summary"
+	EventResourcePlanned = "resource_planned"
+	EventResourceApplied = "resource_applied"
+	EventChangeSummary   = "change_summary"
+	EventGroupFinished   = "group_finished"
+	EventError           = "error"
+	EventFinished        = "finished"
+)
+
+// Statuses of finished groups and commands
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// ChangeCounts are the numbers of resources added, changed and removed by a
+// terraform apply or destroy
+type ChangeCounts struct {
+	Add       int    `json:"add"`
+	Change    int    `json:"change"`
+	Remove    int    `json:"remove"`
+	Operation string `json:"operation,omitempty"`
+}
+
+// Event is a step of a deployment, written as a line of JSON
+type Event struct {
+	Time    time.Time        `json:"time"`
+	Type    string           `json:"type"`
+	Command string           `json:"command,omitempty"`
+	Group   config.GroupName `json:"group,omitempty"`
+	// Resource and Action are the address of a resource and what was done to
+	// it, e.g. "create" or "delete"
+	Resource string        `json:"resource,omitempty"`
+	Action   string        `json:"action,omitempty"`
+	Changes  *ChangeCounts `json:"changes,omitempty"`
+	Status   string        `json:"status,omitempty"`
+	Message  string        `json:"message,omitempty"`
+}
+
+var events struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// replaced in tests
+var now = time.Now
+
+// StreamEvents writes the events of the deployment to w as newline-delimited
+// JSON; the output of terraform, packer and hooks is then written to the
+// standard error instead of the standard output
+func StreamEvents(w io.Writer) {
+	events.Lock()
+	defer events.Unlock()
+	events.w = w
+}
+
+// EventsEnabled returns whether events are written
+func EventsEnabled() bool {
+	events.Lock()
+	defer events.Unlock()
+	return events.w != nil
+}
+
+// EmitEvent writes an event, if events are enabled
+func EmitEvent(e Event) {
+	events.Lock()
+	defer events.Unlock()
+	if events.w == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = now().UTC()
+	}
+	// events are plain structs, which always encode
+	b, _ := json.Marshal(e)
+	events.w.Write(append(b, '\n'))
+}
+
+// ConsoleOutput returns where the output of the commands run by ghpc is
+// written: the standard output, unless events are written to it, with the
+// secrets masked as in the logs of groups
+func ConsoleOutput() io.Writer {
+	if EventsEnabled() {
+		return console(os.Stderr)
+	}
+	return console(os.Stdout)
+}
+
+// tfMessage is a line of the machine-readable output of terraform -json
+type tfMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"@message"`
+	Hook    struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"hook"`
+	// Change is the change to a resource of a planned_change message
+	Change struct {
+		Resource struct {
+			Addr string `json:"addr"`
+		} `json:"resource"`
+		Action string `json:"action"`
+	} `json:"change"`
+	Changes    *ChangeCounts `json:"changes"`
+	Diagnostic struct {
+		Severity string `json:"severity"`
+		Summary  string `json:"summary"`
+		Detail   string `json:"detail"`
+	} `json:"diagnostic"`
+}
+
+// tfEventWriter turns the machine-readable output of terraform into events of
+// the group, and keeps the errors terraform reports
+type tfEventWriter struct {
+	group  config.GroupName
+	buf    []byte
+	errors []string
+}
+
+func (w *tfEventWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.handle(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush handles the last line, if it is not terminated
+func (w *tfEventWriter) flush() {
+	if len(bytes.TrimSpace(w.buf)) > 0 {
+		w.handle(w.buf)
+	}
+	w.buf = nil
+}
+
+func (w *tfEventWriter) handle(line []byte) {
+	var m tfMessage
+	if err := json.Unmarshal(line, &m); err != nil {
+		// not a message of terraform, e.g. the output of a provisioner
+		return
+	}
+	switch m.Type {
+	case "planned_change":
+		EmitEvent(Event{Type: EventResourcePlanned, Group: w.group,
+			Resource: m.Change.Resource.Addr, Action: m.Change.Action})
+	case "apply_complete":
+		EmitEvent(Event{Type: EventResourceApplied, Group: w.group,
+			Resource: m.Hook.Resource.Addr, Action: m.Hook.Action})
+	case "change_summary":
+		// the changes of plans are reported by plan_summary
+		if m.Changes != nil && m.Changes.Operation != "plan" {
+			EmitEvent(Event{Type: EventChangeSummary, Group: w.group, Changes: m.Changes, Message: m.Message})
+		}
+	case "diagnostic":
+		if m.Diagnostic.Severity != "error" {
+			return
+		}
+		msg := strings.TrimSpace(m.Diagnostic.Summary + ": " + m.Diagnostic.Detail)
+		w.errors = append(w.errors, msg)
+		EmitEvent(Event{Type: EventError, Group: w.group, Message: msg})
+	}
+}
@@ -0,0 +1,63 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"bytes"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestTfEventWriter(c *C) {
+	var buf bytes.Buffer
+	StreamEvents(&buf)
+	defer StreamEvents(nil)
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC) }
+
+	w := &tfEventWriter{group: "primary"}
+	out := `{"@level":"info","@message":"Terraform 1.5.0","type":"version"}
+{"@level":"info","@message":"module.network.google_compute_network.vpc: Plan to create","change":{"resource":{"addr":"module.network.google_compute_network.vpc"},"action":"create"},"type":"planned_change"}
+{"@level":"info","@message":"Plan: 1 to add, 0 to change, 0 to destroy.","changes":{"add":1,"change":0,"remove":0,"operation":"plan"},"type":"change_summary"}
+{"@level":"info","@message":"module.network.google_compute_network.vpc: Creation complete after 21s","hook":{"resource":{"addr":"module.network.google_compute_network.vpc"},"action":"create","elapsed_seconds":21},"type":"apply_complete"}
+not json
+{"@level":"error","@message":"Error: Quota exceeded","diagnostic":{"severity":"error","summary":"Quota exceeded","detail":"Quota 'CPUS' exceeded."},"type":"diagnostic"}
+{"@level":"info","@message":"Apply complete! Resources: 1 added, 0 changed, 0 destroyed.","changes":{"add":1,"change":0,"remove":0,"operation":"apply"},"type":"change_summary"}`
+	// lines may be split across writes
+	w.Write([]byte(out[:100]))
+	w.Write([]byte(out[100:]))
+	w.flush()
+
+	c.Check(w.errors, DeepEquals, []string{"Quota exceeded: Quota 'CPUS' exceeded."})
+	c.Check(buf.String(), Equals, `{"time":"2023-06-01T12:00:00Z","type":"resource_planned","group":"primary","resource":"module.network.google_compute_network.vpc","action":"create"}
+{"time":"2023-06-01T12:00:00Z","type":"resource_applied","group":"primary","resource":"module.network.google_compute_network.vpc","action":"create"}
+{"time":"2023-06-01T12:00:00Z","type":"error","group":"primary","message":"Quota exceeded: Quota 'CPUS' exceeded."}
+{"time":"2023-06-01T12:00:00Z","type":"change_summary","group":"primary","changes":{"add":1,"change":0,"remove":0,"operation":"apply"},"message":"Apply complete! Resources: 1 added, 0 changed, 0 destroyed."}
+`)
+}
+
+func (s *MySuite) TestConsoleOutput(c *C) {
+	c.Check(EventsEnabled(), Equals, false)
+	c.Check(ConsoleOutput().(*maskingWriter).w, Equals, os.Stdout)
+	EmitEvent(Event{Type: EventGroupStarted}) // no-op
+
+	StreamEvents(&bytes.Buffer{})
+	defer StreamEvents(nil)
+	c.Check(ConsoleOutput().(*maskingWriter).w, Equals, os.Stderr)
+}
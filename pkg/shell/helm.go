@@ -0,0 +1,78 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"errors"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigureHelm errors if helm is not in the user PATH
+func ConfigureHelm() error {
+	_, err := exec.LookPath("helm")
+	if err != nil {
+		return &TfError{
+			help: "must have a copy of helm installed in PATH",
+			err:  err,
+		}
+	}
+	return nil
+}
+
+// ExecHelmCmd runs helm with arguments in the given working directory
+// optionally prints to stdout/stderr
+func ExecHelmCmd(workingDir string, printToScreen bool, args ...string) error {
+	return execCmd("helm", workingDir, printToScreen, args...)
+}
+
+// HelmInstallArgs returns the arguments of the helm command, run in groupDir,
+// that installs or upgrades the release of a module of the Helm group, with
+// the values of its settings and the inputs imported from earlier groups, if
+// any
+func HelmInstallArgs(groupDir string, group config.DeploymentGroup, mod config.Module) []string {
+	chart := "./" + string(mod.ID)
+	args := []string{"upgrade", "--install", config.HelmRelease(mod.ID), chart}
+	if group.Namespace != "" {
+		args = append(args, "--namespace", group.Namespace, "--create-namespace")
+	}
+	args = append(args, "--values", path.Join(chart, modulewriter.HelmValuesFilename))
+	if _, err := os.Stat(filepath.Join(groupDir, string(mod.ID), modulewriter.HelmInputsFilename)); err == nil {
+		args = append(args, "--values", path.Join(chart, modulewriter.HelmInputsFilename))
+	}
+	return append(args, "--wait")
+}
+
+// HelmUninstall uninstalls the release of a module of the Helm group in
+// groupDir; releases that are not installed are skipped
+func HelmUninstall(groupDir string, group config.DeploymentGroup, mod config.Module) error {
+	args := []string{"uninstall", config.HelmRelease(mod.ID)}
+	if group.Namespace != "" {
+		args = append(args, "--namespace", group.Namespace)
+	}
+	err := ExecHelmCmd(groupDir, true, append(args, "--wait")...)
+	var cerr *CmdError
+	if errors.As(err, &cerr) && strings.Contains(cerr.Output, "not found") {
+		return nil
+	}
+	return err
+}
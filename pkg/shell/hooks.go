@@ -0,0 +1,111 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyJson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// HookEnv returns the environment variables of the hooks of a group: the
+// deployment name, group and directory, the deployment variables of the group
+// as GHPC_VAR_<NAME>, the outputs of the group, if any, as GHPC_OUTPUT_<NAME>
+// and the service account it impersonates, if any. Values that are not
+// strings are encoded as JSON.
+func HookEnv(bp config.Blueprint, group config.GroupName, deploymentRoot string, outputs map[string]cty.Value) (map[string]string, error) {
+	name, err := bp.DeploymentName()
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]string{
+		"GHPC_DEPLOYMENT_NAME": name,
+		"GHPC_DEPLOYMENT_DIR":  deploymentRoot,
+		"GHPC_GROUP":           string(group),
+	}
+	add := func(prefix string, vals map[string]cty.Value) error {
+		for k, v := range vals {
+			s, err := envValue(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			env[prefix+strings.ToUpper(k)] = s
+		}
+		return nil
+	}
+	vars := bp.Vars
+	if i := bp.GroupIndex(group); i >= 0 {
+		g := bp.DeploymentGroups[i]
+		for k, v := range GroupEnv(g) {
+			env[k] = v
+		}
+		vars = bp.GroupVars(g)
+	}
+	if err := add("GHPC_VAR_", vars.Items()); err != nil {
+		return nil, err
+	}
+	if err := add("GHPC_OUTPUT_", outputs); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func envValue(v cty.Value) (string, error) {
+	if v.IsNull() {
+		return "", nil
+	}
+	if v.Type() == cty.String {
+		return v.AsString(), nil
+	}
+	b, err := ctyJson.Marshal(v, v.Type())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// RunHooks runs the commands of a hook of a group one after another with
+// "sh -c" in dir, with the environment of ghpc and env, and stops at the first
+// command that fails
+func RunHooks(hook string, group config.GroupName, commands []string, dir string, env map[string]string) error {
+	environ := os.Environ()
+	keys := maps.Keys(env)
+	slices.Sort(keys)
+	for _, k := range keys {
+		environ = append(environ, k+"="+env[k])
+	}
+	for _, c := range commands {
+		log.Printf("running %s hook of group %s: %s", hook, group, c)
+		cmd := exec.Command("sh", "-c", c)
+		cmd.Dir = dir
+		cmd.Env = environ
+		cmd.Stdout = withGroupLog(dir, ConsoleOutput())
+		cmd.Stderr = withGroupLog(dir, consoleErrors())
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook of group %s failed: %s: %w", hook, group, c, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"hpc-toolkit/pkg/config"
+	"os"
+	"path/filepath"
+
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestHookEnv(c *C) {
+	bp := config.Blueprint{Vars: config.NewDict(map[string]cty.Value{
+		"deployment_name": cty.StringVal("dep"),
+		"project_id":      cty.StringVal("proj"),
+		"labels":          cty.ObjectVal(map[string]cty.Value{"team": cty.StringVal("hpc")}),
+		"node_count":      cty.NumberIntVal(4),
+	})}
+	outputs := map[string]cty.Value{"network_name": cty.StringVal("vpc")}
+
+	env, err := HookEnv(bp, "primary", "dep-dir", outputs)
+	c.Assert(err, IsNil)
+	c.Check(env, DeepEquals, map[string]string{
+		"GHPC_DEPLOYMENT_NAME":     "dep",
+		"GHPC_DEPLOYMENT_DIR":      "dep-dir",
+		"GHPC_GROUP":               "primary",
+		"GHPC_VAR_DEPLOYMENT_NAME": "dep",
+		"GHPC_VAR_PROJECT_ID":      "proj",
+		"GHPC_VAR_LABELS":          `{"team":"hpc"}`,
+		"GHPC_VAR_NODE_COUNT":      "4",
+		"GHPC_OUTPUT_NETWORK_NAME": "vpc",
+	})
+
+	// groups that override the project and impersonate a service account
+	bp.DeploymentGroups = []config.DeploymentGroup{{
+		Name:                      "primary",
+		ProjectID:                 "service-proj",
+		ImpersonateServiceAccount: "deployer@service-proj.iam.gserviceaccount.com",
+	}}
+	env, err = HookEnv(bp, "primary", "dep-dir", nil)
+	c.Assert(err, IsNil)
+	c.Check(env["GHPC_VAR_PROJECT_ID"], Equals, "service-proj")
+	c.Check(env["GOOGLE_IMPERSONATE_SERVICE_ACCOUNT"], Equals, "deployer@service-proj.iam.gserviceaccount.com")
+
+	_, err = HookEnv(config.Blueprint{}, "primary", "dep-dir", nil)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestRunHooks(c *C) {
+	dir := c.MkDir()
+	env := map[string]string{"GHPC_GROUP": "primary"}
+
+	err := RunHooks("post_deploy", "primary", []string{"echo $GHPC_GROUP > out", "echo done >> out"}, dir, env)
+	c.Assert(err, IsNil)
+	b, err := os.ReadFile(filepath.Join(dir, "out"))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, "primary\ndone\n")
+
+	// commands after a failed command are not run
+	err = RunHooks("pre_deploy", "primary", []string{"exit 3", "touch never"}, dir, env)
+	c.Check(err, ErrorMatches, "pre_deploy hook of group primary failed: exit 3: exit status 3")
+	_, err = os.Stat(filepath.Join(dir, "never"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,78 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"errors"
+	"hpc-toolkit/pkg/config"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// BuiltImagesFilename is the file in the artifacts directory that records the
+// images built by the Packer groups of the deployment
+const BuiltImagesFilename = "built_images.yaml"
+
+// BuiltImage is an image built by a Packer module
+type BuiltImage struct {
+	Group  config.GroupName `yaml:"group"`
+	Module config.ModuleID  `yaml:"module"`
+	Name   string           `yaml:"name"`
+	// Family is the image family the image was added to, if any
+	Family  string    `yaml:"family,omitempty"`
+	BuiltAt time.Time `yaml:"built_at"`
+}
+
+// ReadBuiltImages reads the images recorded in the artifacts directory
+func ReadBuiltImages(artifactsDir string) ([]BuiltImage, error) {
+	images := []BuiltImage{}
+	b, err := os.ReadFile(filepath.Join(artifactsDir, BuiltImagesFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return images, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// RecordBuiltImage records the image in the artifacts directory, in place of
+// the image previously built by the same module
+func RecordBuiltImage(artifactsDir string, img BuiltImage) error {
+	images, err := ReadBuiltImages(artifactsDir)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(images, func(o BuiltImage) bool { return o.Group == img.Group && o.Module == img.Module })
+	if i < 0 {
+		images = append(images, img)
+	} else {
+		images[i] = img
+	}
+	b, err := yaml.Marshal(images)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(artifactsDir, BuiltImagesFilename), b, 0644)
+}
@@ -0,0 +1,45 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestRecordBuiltImage(c *C) {
+	dir := c.MkDir()
+	images, err := ReadBuiltImages(dir)
+	c.Assert(err, IsNil)
+	c.Check(images, HasLen, 0)
+
+	t := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	first := BuiltImage{Group: "packer", Module: "image", Name: "image-1", Family: "slurm", BuiltAt: t}
+	other := BuiltImage{Group: "packer-2", Module: "image", Name: "other-1", BuiltAt: t}
+	c.Assert(RecordBuiltImage(dir, first), IsNil)
+	c.Assert(RecordBuiltImage(dir, other), IsNil)
+
+	// a new image of the same module replaces the previous one
+	second := first
+	second.Name, second.BuiltAt = "image-2", t.Add(time.Hour)
+	c.Assert(RecordBuiltImage(dir, second), IsNil)
+
+	images, err = ReadBuiltImages(dir)
+	c.Assert(err, IsNil)
+	c.Check(images, DeepEquals, []BuiltImage{second, other})
+}
@@ -0,0 +1,355 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// MaxGroupLogs is the number of logs of each group that are kept; older logs
+// are removed when a new one is started
+const MaxGroupLogs = 10
+
+// logTimeFormat is the format of the times in the names of log files, which
+// sort in chronological order
+const logTimeFormat = "20060102T150405Z"
+
+// LogsDir returns the directory of the logs of the groups of a deployment
+func LogsDir(deploymentRoot string) string {
+	return filepath.Join(deploymentRoot, modulewriter.HiddenGhpcDirName, modulewriter.LogsDirName)
+}
+
+// GroupLog records the output of terraform, packer and hooks run for a group,
+// with the time of each line and its secrets masked
+type GroupLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	groupDir string
+	line     []byte // incomplete last line, written once complete
+}
+
+// minSecretLen is the length of the shortest secret masked in logs; shorter
+// values would mask unrelated output
+const minSecretLen = 4
+
+// secrets masked in the logs of groups, longest first
+var secrets = struct {
+	sync.Mutex
+	values []string
+}{}
+
+// MaskSecrets masks values, such as those of sensitive deployment variables,
+// in the logs of groups, in addition to those already masked
+func MaskSecrets(values []string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range values {
+		if len(v) >= minSecretLen && !slices.Contains(secrets.values, v) {
+			secrets.values = append(secrets.values, v)
+		}
+	}
+	slices.SortFunc(secrets.values, func(a, b string) bool { return len(a) > len(b) })
+}
+
+// secretPrefixLen returns the length of the longest end of b that is the
+// start of a secret, which may be completed by the next write
+func secretPrefixLen(b []byte) int {
+	secrets.Lock()
+	defer secrets.Unlock()
+	n := 0
+	for _, v := range secrets.values {
+		for k := len(v) - 1; k > n; k-- {
+			if k <= len(b) && bytes.HasSuffix(b, []byte(v[:k])) {
+				n = k
+				break
+			}
+		}
+	}
+	return n
+}
+
+// maskingWriter writes to w with the secrets masked. Output is written as it
+// comes, except for the end of a write that may be the start of a secret,
+// which is held back until the next write.
+type maskingWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending []byte
+}
+
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := maskSecrets(append(m.pending, p...))
+	keep := secretPrefixLen(b)
+	m.pending = append([]byte{}, b[len(b)-keep:]...)
+	if len(b) > keep {
+		if _, err := m.w.Write(b[:len(b)-keep]); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// consoles are the writers of the output of commands to the console, by file,
+// shared so that the output held back by one write is written by the next
+var consoles = struct {
+	sync.Mutex
+	m map[*os.File]*maskingWriter
+}{m: map[*os.File]*maskingWriter{}}
+
+// console returns the writer to f that masks the secrets
+func console(f *os.File) io.Writer {
+	consoles.Lock()
+	defer consoles.Unlock()
+	if _, ok := consoles.m[f]; !ok {
+		consoles.m[f] = &maskingWriter{w: f}
+	}
+	return consoles.m[f]
+}
+
+// consoleErrors returns where the errors of the commands run by ghpc are
+// written, with the secrets masked
+func consoleErrors() io.Writer {
+	return console(os.Stderr)
+}
+
+// maskSecrets replaces the secrets in line with config.RedactedValue
+func maskSecrets(line []byte) []byte {
+	secrets.Lock()
+	defer secrets.Unlock()
+	if len(secrets.values) == 0 {
+		return line
+	}
+	s := string(line)
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, config.RedactedValue)
+	}
+	return []byte(s)
+}
+
+// logs of the groups being deployed or destroyed, by group directory
+var groupLogs = struct {
+	sync.Mutex
+	m map[string]*GroupLog
+}{m: map[string]*GroupLog{}}
+
+// StartGroupLog creates the log of a command, "deploy" or "destroy", for a
+// group of the deployment; the output of the commands run in the directory of
+// the group is written to it until it is closed
+func StartGroupLog(deploymentRoot string, group config.GroupName, command string) (*GroupLog, error) {
+	dir := filepath.Join(LogsDir(deploymentRoot), string(group))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the logs directory %s: %w", dir, err)
+	}
+	// commands started in the same second are logged to different files
+	f, err := os.CreateTemp(dir, fmt.Sprintf("%s-%s-*.log", now().UTC().Format(logTimeFormat), command))
+	if err != nil {
+		return nil, err
+	}
+	if err := rotateLogs(dir); err != nil {
+		f.Close()
+		return nil, err
+	}
+	groupDir, err := filepath.Abs(filepath.Join(deploymentRoot, string(group)))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &GroupLog{f: f, groupDir: groupDir}
+	groupLogs.Lock()
+	groupLogs.m[groupDir] = l
+	groupLogs.Unlock()
+	fmt.Fprintf(l, "ghpc %s of group %s\n", command, group)
+	return l, nil
+}
+
+// rotateLogs removes the oldest logs in dir beyond MaxGroupLogs
+func rotateLogs(dir string) error {
+	names, err := logNames(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > MaxGroupLogs {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// logNames returns the names of the log files in dir, oldest first
+func logNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".log") {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Write writes the complete lines of p to the log, starting each line with
+// the current time; secrets are masked in whole lines, so those split across
+// writes are masked too
+func (l *GroupLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(p)
+	var b []byte
+	for {
+		i := slices.Index(p, '\n')
+		if i < 0 {
+			l.line = append(l.line, p...)
+			break
+		}
+		line := append(l.line, p[:i+1]...)
+		b = append(b, now().UTC().Format(time.RFC3339)+" "...)
+		b = append(b, maskSecrets(line)...)
+		l.line, p = nil, p[i+1:]
+	}
+	if len(b) == 0 {
+		return n, nil
+	}
+	if _, err := l.f.Write(b); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Finish records the outcome of the command, err if it failed, and closes the
+// log
+func (l *GroupLog) Finish(err error) error {
+	groupLogs.Lock()
+	delete(groupLogs.m, l.groupDir)
+	groupLogs.Unlock()
+
+	if len(l.line) > 0 {
+		l.Write([]byte("\n"))
+	}
+	if err != nil {
+		fmt.Fprintf(l, "failed: %v\n", err)
+	} else {
+		fmt.Fprintln(l, "succeeded")
+	}
+	return l.f.Close()
+}
+
+// groupLogOf returns the log of the group whose directory is dir, or contains
+// dir, such as the directory of a Packer module, if it is being recorded
+func groupLogOf(dir string) *GroupLog {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	groupLogs.Lock()
+	defer groupLogs.Unlock()
+	if l, ok := groupLogs.m[abs]; ok {
+		return l
+	}
+	return groupLogs.m[filepath.Dir(abs)]
+}
+
+// withGroupLog returns a writer to w and to the log of the group of dir, if
+// it is being recorded
+func withGroupLog(dir string, w io.Writer) io.Writer {
+	if l := groupLogOf(dir); l != nil {
+		return io.MultiWriter(w, l)
+	}
+	return w
+}
+
+// LogFile is a log of a command for a group
+type LogFile struct {
+	Group   config.GroupName
+	Command string
+	Time    time.Time
+	Path    string
+}
+
+// ListGroupLogs returns the logs of the group, or of all groups if group is
+// empty, by group and oldest first
+func ListGroupLogs(deploymentRoot string, group config.GroupName) ([]LogFile, error) {
+	root := LogsDir(deploymentRoot)
+	groups := []string{string(group)}
+	if group == "" {
+		entries, err := os.ReadDir(root)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		groups = nil
+		for _, e := range entries {
+			if e.IsDir() {
+				groups = append(groups, e.Name())
+			}
+		}
+	}
+
+	logs := []LogFile{}
+	for _, g := range groups {
+		dir := filepath.Join(root, g)
+		names, err := logNames(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			ts, command, ok := strings.Cut(strings.TrimSuffix(n, ".log"), "-")
+			if !ok {
+				continue
+			}
+			if i := strings.LastIndex(command, "-"); i >= 0 && isDigits(command[i+1:]) {
+				command = command[:i]
+			}
+			t, err := time.Parse(logTimeFormat, ts)
+			if err != nil {
+				continue
+			}
+			logs = append(logs, LogFile{Group: config.GroupName(g), Command: command, Time: t, Path: filepath.Join(dir, n)})
+		}
+	}
+	return logs, nil
+}
+
+// isDigits returns whether s is a non-empty string of decimal digits, such as
+// the unique suffix of the name of a log
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
@@ -0,0 +1,157 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// onlyLog returns the path of the only log in dir
+func onlyLog(c *C, dir string) string {
+	names, err := filepath.Glob(filepath.Join(dir, "20230601T120000Z-deploy-*.log"))
+	c.Assert(err, IsNil)
+	c.Assert(names, HasLen, 1)
+	return names[0]
+}
+
+func (s *MySuite) TestGroupLog(c *C) {
+	root := c.MkDir()
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC) }
+
+	l, err := StartGroupLog(root, "image", "deploy")
+	c.Assert(err, IsNil)
+
+	// commands run in the group directory and in the directories of its
+	// modules are recorded
+	var console bytes.Buffer
+	fmt.Fprint(withGroupLog(filepath.Join(root, "image", "builder"), &console), "building\nimage")
+	fmt.Fprint(withGroupLog(filepath.Join(root, "image"), &console), " done\n")
+	c.Check(console.String(), Equals, "building\nimage done\n")
+	c.Check(withGroupLog(filepath.Join(root, "primary"), &console), Equals, &console)
+
+	c.Assert(l.Finish(errors.New("exit status 1")), IsNil)
+	c.Check(groupLogOf(filepath.Join(root, "image")), IsNil)
+
+	b, err := os.ReadFile(onlyLog(c, filepath.Join(LogsDir(root), "image")))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, `2023-06-01T12:00:00Z ghpc deploy of group image
+2023-06-01T12:00:00Z building
+2023-06-01T12:00:00Z image done
+2023-06-01T12:00:00Z failed: exit status 1
+`)
+}
+
+func (s *MySuite) TestGroupLogMasksSecrets(c *C) {
+	root := c.MkDir()
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC) }
+	defer func() { secrets.values = nil }()
+	MaskSecrets([]string{"hunter22", "abc", "hunter2"})
+
+	l, err := StartGroupLog(root, "primary", "deploy")
+	c.Assert(err, IsNil)
+	// secrets split across writes are masked
+	fmt.Fprint(withGroupLog(filepath.Join(root, "primary"), io.Discard), "password: hunt")
+	fmt.Fprint(withGroupLog(filepath.Join(root, "primary"), io.Discard), "er22, id: abc\n")
+	c.Assert(l.Finish(nil), IsNil)
+
+	b, err := os.ReadFile(onlyLog(c, filepath.Join(LogsDir(root), "primary")))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, `2023-06-01T12:00:00Z ghpc deploy of group primary
+2023-06-01T12:00:00Z password: (sensitive), id: abc
+2023-06-01T12:00:00Z succeeded
+`)
+}
+
+func (s *MySuite) TestConsoleMasksSecrets(c *C) {
+	defer func() { secrets.values = nil }()
+	MaskSecrets([]string{"hunter22", "hunter2"})
+
+	var out bytes.Buffer
+	w := &maskingWriter{w: &out}
+	// the start of a secret is held back until it is known not to be one
+	fmt.Fprint(w, "password: hunt")
+	c.Check(out.String(), Equals, "password: ")
+	fmt.Fprint(w, "er22, user: hun")
+	c.Check(out.String(), Equals, "password: (sensitive), user: ")
+	fmt.Fprint(w, "ter\n")
+	c.Check(out.String(), Equals, "password: (sensitive), user: hunter\n")
+}
+
+func (s *MySuite) TestGroupLogsOfTheSameSecond(c *C) {
+	root := c.MkDir()
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC) }
+
+	first, err := StartGroupLog(root, "primary", "deploy")
+	c.Assert(err, IsNil)
+	second, err := StartGroupLog(root, "primary", "deploy")
+	c.Assert(err, IsNil)
+	c.Check(first.f.Name(), Not(Equals), second.f.Name())
+	c.Assert(first.Finish(nil), IsNil)
+	c.Assert(second.Finish(nil), IsNil)
+
+	logs, err := ListGroupLogs(root, "primary")
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	for _, l := range logs {
+		c.Check(l.Command, Equals, "deploy")
+		c.Check(l.Time, Equals, now())
+	}
+}
+
+func (s *MySuite) TestRotateAndListGroupLogs(c *C) {
+	root := c.MkDir()
+	defer func(f func() time.Time) { now = f }(now)
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < MaxGroupLogs+2; i++ {
+		now = func() time.Time { return start.Add(time.Duration(i) * time.Minute) }
+		l, err := StartGroupLog(root, "primary", "deploy")
+		c.Assert(err, IsNil)
+		c.Assert(l.Finish(nil), IsNil)
+	}
+	l, err := StartGroupLog(root, "image", "destroy")
+	c.Assert(err, IsNil)
+	c.Assert(l.Finish(nil), IsNil)
+
+	logs, err := ListGroupLogs(root, "primary")
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, MaxGroupLogs)
+	// the oldest logs were removed
+	c.Check(logs[0].Time, Equals, start.Add(2*time.Minute))
+	c.Check(logs[0].Command, Equals, "deploy")
+
+	logs, err = ListGroupLogs(root, "")
+	c.Assert(err, IsNil)
+	c.Check(logs, HasLen, MaxGroupLogs+1)
+	c.Check(logs[0].Group, Equals, config.GroupName("image"))
+	c.Check(logs[0].Command, Equals, "destroy")
+
+	logs, err = ListGroupLogs(c.MkDir(), "")
+	c.Check(err, IsNil)
+	c.Check(logs, HasLen, 0)
+}
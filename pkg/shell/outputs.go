@@ -0,0 +1,134 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"encoding/json"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// DeploymentOutputsFilename is the file in the artifacts directory the
+// outputs of the deployment are written to, with the extension of its format
+const DeploymentOutputsFilename = "deployment_outputs"
+
+// DeploymentOutputsFile returns the path of the file the outputs of the
+// deployment are written to in format, "json" or "yaml"
+func DeploymentOutputsFile(artifactsDir string, format string) string {
+	return filepath.Join(artifactsDir, DeploymentOutputsFilename+"."+format)
+}
+
+// DeploymentOutputs returns the outputs of the deployment, as selected by the
+// outputs block of the blueprint, from the outputs exported by their groups;
+// the outputs of groups that were not exported are omitted
+func DeploymentOutputs(bp config.Blueprint, artifactsDir string) (map[string]cty.Value, error) {
+	res := map[string]cty.Value{}
+	for _, o := range bp.Outputs {
+		r, err := o.Reference()
+		if err != nil {
+			return nil, err
+		}
+		g := bp.ModuleGroupOrDie(r.Module)
+		outputs, err := ReadOutputs(artifactsDir, g.Name)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := outputs[config.AutomaticOutputName(r.Name, r.Module)]; ok {
+			res[o.Name] = v
+		}
+	}
+	return res, nil
+}
+
+// WriteDeploymentOutputs writes the outputs of the deployment to a single
+// file in artifactsDir, in format "json" or "yaml", and stores the JSON file in
+// the artifact store; it does nothing if the blueprint has no outputs block.
+// Each output is written with whether it is sensitive, and the file is only
+// readable by its owner.
+func WriteDeploymentOutputs(bp config.Blueprint, artifactsDir string, format string) error {
+	if len(bp.Outputs) == 0 {
+		return nil
+	}
+	outputs, err := DeploymentOutputs(bp, artifactsDir)
+	if err != nil {
+		return err
+	}
+	// outputs of sensitive module outputs are sensitive too
+	sensitiveRefs := bp.SensitiveOutputs()
+	sensitive := map[string]bool{}
+	for _, o := range bp.Outputs {
+		r, err := o.Reference()
+		if err != nil {
+			return err
+		}
+		sensitive[o.Name] = o.Sensitive || slices.Contains(sensitiveRefs, r)
+	}
+	b, err := marshalDeploymentOutputs(outputs, sensitive, format)
+	if err != nil {
+		return err
+	}
+	path := DeploymentOutputsFile(artifactsDir, format)
+	log.Printf("writing %d of %d outputs of the deployment to file %s", len(outputs), len(bp.Outputs), path)
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return err
+	}
+	// WriteFile does not change the mode of an existing file
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+	if format != "json" {
+		return nil
+	}
+	return pushDeploymentOutputs(artifactsDir)
+}
+
+func marshalDeploymentOutputs(outputs map[string]cty.Value, sensitive map[string]bool, format string) ([]byte, error) {
+	entries := map[string]cty.Value{}
+	for n, o := range outputs {
+		entries[n] = cty.ObjectVal(map[string]cty.Value{
+			"value":     o,
+			"sensitive": cty.BoolVal(sensitive[n]),
+		})
+	}
+	v := cty.ObjectVal(entries)
+	b, err := ctyjson.Marshal(v, v.Type())
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, decoding it sorts the keys
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	switch format {
+	case "json":
+		b, err := json.MarshalIndent(doc, "", "  ")
+		return append(b, '\n'), err
+	case "yaml":
+		return yaml.Marshal(doc)
+	default:
+		return nil, fmt.Errorf("unsupported format of the deployment outputs %q, use \"json\" or \"yaml\"", format)
+	}
+}
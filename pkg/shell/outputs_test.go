@@ -0,0 +1,68 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"os"
+
+	"github.com/zclconf/go-cty/cty"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestWriteDeploymentOutputs(c *C) {
+	dir := c.MkDir()
+	bp := config.Blueprint{
+		DeploymentGroups: []config.DeploymentGroup{
+			{Name: "net", Modules: []config.Module{{ID: "vpc"}}},
+			{Name: "cluster", Modules: []config.Module{{ID: "login"}}},
+		},
+	}
+	// no outputs block, no file
+	c.Assert(WriteDeploymentOutputs(bp, dir, "json"), IsNil)
+	_, err := os.Stat(DeploymentOutputsFile(dir, "json"))
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	bp.Outputs = []config.DeploymentOutput{
+		{Name: "network", Value: "$(vpc.network_name)"},
+		{Name: "login_ip", Value: "$(login.external_ip)", Sensitive: true},
+	}
+	c.Assert(modulewriter.WriteHclAttributes(map[string]cty.Value{
+		"network_name_vpc": cty.StringVal("hpc-net"),
+		"subnet_name_vpc":  cty.StringVal("hpc-subnet"),
+	}, outputsFile(dir, "net")), IsNil)
+
+	// outputs of groups that were not exported are omitted
+	c.Assert(WriteDeploymentOutputs(bp, dir, "json"), IsNil)
+	b, err := os.ReadFile(DeploymentOutputsFile(dir, "json"))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, "{\n  \"network\": {\n    \"sensitive\": false,\n    \"value\": \"hpc-net\"\n  }\n}\n")
+	fi, err := os.Stat(DeploymentOutputsFile(dir, "json"))
+	c.Assert(err, IsNil)
+	c.Check(fi.Mode().Perm(), Equals, os.FileMode(0600))
+
+	c.Assert(modulewriter.WriteHclAttributes(map[string]cty.Value{
+		"external_ip_login": cty.StringVal("10.0.0.2"),
+	}, outputsFile(dir, "cluster")), IsNil)
+	c.Assert(WriteDeploymentOutputs(bp, dir, "yaml"), IsNil)
+	b, err = os.ReadFile(DeploymentOutputsFile(dir, "yaml"))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, "login_ip:\n    sensitive: true\n    value: 10.0.0.2\nnetwork:\n    sensitive: false\n    value: hpc-net\n")
+
+	c.Check(WriteDeploymentOutputs(bp, dir, "toml"), NotNil)
+}
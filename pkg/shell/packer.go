@@ -17,34 +17,170 @@
 package shell
 
 import (
+	"encoding/json"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/tools"
+	"io"
+	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/zclconf/go-cty/cty"
 )
 
-// ConfigurePacker errors if packer is not in the user PATH
-func ConfigurePacker() error {
-	_, err := exec.LookPath("packer")
+// maxCmdOutput is the size of the end of the output of commands kept to
+// explain their failure
+const maxCmdOutput = 64 * 1024
+
+// CmdError is the failure of a command with the end of its output
+type CmdError struct {
+	Err    error
+	Output string
+}
+
+func (e *CmdError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CmdError) Unwrap() error {
+	return e.Err
+}
+
+// tailWriter keeps the last maxCmdOutput bytes written to it
+type tailWriter struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.b = append(w.b, p...)
+	if len(w.b) > maxCmdOutput {
+		w.b = w.b[len(w.b)-maxCmdOutput:]
+	}
+	return len(p), nil
+}
+
+// packerPath returns the packer binary in PATH or the one installed by ghpc
+func packerPath() (string, error) {
+	path, err := tools.Packer.Find()
 	if err != nil {
-		return &TfError{
-			help: "must have a copy of packer installed in PATH",
+		return "", &TfError{
+			help: fmt.Sprintf("must have a copy of packer %s installed in PATH, or run ghpc tools install", tools.Packer.Constraints),
 			err:  err,
 		}
 	}
-	return nil
+	return path, nil
+}
+
+// ConfigurePacker errors if no supported packer binary is found
+func ConfigurePacker() error {
+	_, err := packerPath()
+	return err
 }
 
 // ExecPackerCmd runs packer with arguments in the given working directory
 // optionally prints to stdout/stderr
 func ExecPackerCmd(workingDir string, printToScreen bool, args ...string) error {
-	cmd := exec.Command("packer", args...)
+	path, err := packerPath()
+	if err != nil {
+		return err
+	}
+	return execCmd(path, workingDir, printToScreen, args...)
+}
+
+// execCmd runs the command with arguments in the given working directory,
+// logged to the log of the group, and optionally prints to stdout/stderr
+func execCmd(name string, workingDir string, printToScreen bool, args ...string) error {
+	cmd := exec.Command(name, args...)
 	cmd.Dir = workingDir
+	var out tailWriter
+	captured := withGroupLog(workingDir, &out)
+	cmd.Stdout, cmd.Stderr = captured, captured
 	if printToScreen {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = io.MultiWriter(ConsoleOutput(), captured)
+		cmd.Stderr = io.MultiWriter(consoleErrors(), captured)
 	}
 
 	if err := cmd.Run(); err != nil {
-		return err
+		return &CmdError{Err: err, Output: string(out.b)}
 	}
 	return nil
 }
+
+// packerManifest is the manifest written by the manifest post-processor of
+// Packer
+type packerManifest struct {
+	Builds []struct {
+		ArtifactID    string `json:"artifact_id"`
+		PackerRunUUID string `json:"packer_run_uuid"`
+	} `json:"builds"`
+	LastRunUUID string `json:"last_run_uuid"`
+}
+
+// ImageFromManifest returns the name of the image built by the last run of
+// the Packer module in moduleDir, as recorded in its manifest
+func ImageFromManifest(moduleDir string) (string, error) {
+	path := filepath.Join(moduleDir, modulewriter.PackerManifestName)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var m packerManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return "", fmt.Errorf("invalid Packer manifest %s: %w", path, err)
+	}
+	for i := len(m.Builds) - 1; i >= 0; i-- {
+		build := m.Builds[i]
+		if build.ArtifactID == "" || (m.LastRunUUID != "" && build.PackerRunUUID != m.LastRunUUID) {
+			continue
+		}
+		// googlecompute artifacts may be qualified by the project
+		parts := strings.Split(build.ArtifactID, ":")
+		return parts[len(parts)-1], nil
+	}
+	return "", fmt.Errorf("the Packer manifest %s records no image", path)
+}
+
+// ExportPackerOutputs exports the names of the images built by the Packer
+// modules of the group as their image_name outputs, for later deployment
+// groups; modules whose image is not used by later groups are skipped if they
+// have not been built
+func ExportPackerOutputs(deploymentRoot string, artifactsDir string, group config.DeploymentGroup) error {
+	outputs := map[string]cty.Value{}
+	for _, mod := range group.Modules {
+		image, err := ImageFromManifest(filepath.Join(deploymentRoot, string(group.Name), string(mod.ID)))
+		if err != nil {
+			if len(mod.Outputs) == 0 {
+				continue
+			}
+			return err
+		}
+		outputs[config.AutomaticOutputName(config.PackerImageOutput, mod.ID)] = cty.StringVal(image)
+	}
+	path := outputsFile(artifactsDir, group.Name)
+	log.Printf("writing images built by group %s to file %s", group.Name, path)
+	if err := modulewriter.WriteHclAttributes(outputs, path); err != nil {
+		return err
+	}
+	return pushOutputs(artifactsDir, group.Name)
+}
+
+// PackerArgs returns the arguments of a packer command run in a module of the
+// Packer group, which passes the variables shared by the modules of the group
+// to validate and build, if the group has any
+func PackerArgs(groupDir string, command string) []string {
+	args := []string{command}
+	if command == "validate" || command == "build" {
+		if _, err := os.Stat(filepath.Join(groupDir, modulewriter.PackerSharedVarsFilename)); err == nil {
+			args = append(args, "-var-file="+filepath.Join("..", modulewriter.PackerSharedVarsFilename))
+		}
+	}
+	return append(args, ".")
+}
@@ -18,9 +18,12 @@ package shell
 
 import (
 	"errors"
+	"hpc-toolkit/pkg/config"
 	"os"
 	"os/exec"
+	"path/filepath"
 
+	"github.com/zclconf/go-cty/cty"
 	. "gopkg.in/check.v1"
 )
 
@@ -51,3 +54,66 @@ func (s *MySuite) TestPacker(c *C) {
 	err = ExecPackerCmd(".", false)
 	c.Assert(err, NotNil)
 }
+
+func (s *MySuite) TestExportPackerOutputs(c *C) {
+	root := c.MkDir()
+	artifactsDir := filepath.Join(root, ".ghpc", "artifacts")
+	moduleDir := filepath.Join(root, "packer", "image")
+	c.Assert(os.MkdirAll(moduleDir, 0755), IsNil)
+	c.Assert(os.MkdirAll(artifactsDir, 0755), IsNil)
+	group := config.DeploymentGroup{Name: "packer", Kind: config.PackerKind, Modules: []config.Module{{ID: "image"}}}
+
+	_, err := ImageFromManifest(moduleDir)
+	c.Check(err, NotNil)
+
+	// the image of the last run is used
+	manifest := `{
+  "builds": [
+    {"artifact_id": "my-project:image-1", "packer_run_uuid": "a"},
+    {"artifact_id": "image-2", "packer_run_uuid": "b"},
+    {"artifact_id": "image-3", "packer_run_uuid": "a"}
+  ],
+  "last_run_uuid": "b"
+}`
+	c.Assert(os.WriteFile(filepath.Join(moduleDir, "packer-manifest.json"), []byte(manifest), 0644), IsNil)
+	c.Assert(ExportPackerOutputs(root, artifactsDir, group), IsNil)
+	outputs, err := ReadOutputs(artifactsDir, "packer")
+	c.Assert(err, IsNil)
+	c.Check(outputs, DeepEquals, map[string]cty.Value{"image_name_image": cty.StringVal("image-2")})
+
+	// artifacts may be qualified by the project
+	manifest = `{"builds": [{"artifact_id": "my-project:image-1", "packer_run_uuid": "a"}], "last_run_uuid": "a"}`
+	c.Assert(os.WriteFile(filepath.Join(moduleDir, "packer-manifest.json"), []byte(manifest), 0644), IsNil)
+	image, err := ImageFromManifest(moduleDir)
+	c.Assert(err, IsNil)
+	c.Check(image, Equals, "image-1")
+}
+
+func (s *MySuite) TestPackerArgs(c *C) {
+	groupDir := c.MkDir()
+	c.Check(PackerArgs(groupDir, "build"), DeepEquals, []string{"build", "."})
+
+	c.Assert(os.WriteFile(filepath.Join(groupDir, "shared.pkrvars.hcl"), []byte("zone = \"us-central1-a\"\n"), 0644), IsNil)
+	c.Check(PackerArgs(groupDir, "init"), DeepEquals, []string{"init", "."})
+	c.Check(PackerArgs(groupDir, "validate"), DeepEquals, []string{"validate", "-var-file=../shared.pkrvars.hcl", "."})
+	c.Check(PackerArgs(groupDir, "build"), DeepEquals, []string{"build", "-var-file=../shared.pkrvars.hcl", "."})
+}
+
+func (s *MySuite) TestHelmInstallArgs(c *C) {
+	groupDir := c.MkDir()
+	mod := config.Module{ID: "kueue_controller", Kind: config.HelmKind}
+	group := config.DeploymentGroup{Name: "apps", Kind: config.HelmKind, Modules: []config.Module{mod}}
+	c.Check(HelmInstallArgs(groupDir, group, mod), DeepEquals, []string{
+		"upgrade", "--install", "kueue-controller", "./kueue_controller",
+		"--values", "kueue_controller/ghpc-values.yaml", "--wait"})
+
+	// inputs imported from earlier groups override the settings
+	group.Namespace = "kueue-system"
+	c.Assert(os.MkdirAll(filepath.Join(groupDir, "kueue_controller"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(groupDir, "kueue_controller", "ghpc-inputs.yaml"), []byte("{}\n"), 0644), IsNil)
+	c.Check(HelmInstallArgs(groupDir, group, mod), DeepEquals, []string{
+		"upgrade", "--install", "kueue-controller", "./kueue_controller",
+		"--namespace", "kueue-system", "--create-namespace",
+		"--values", "kueue_controller/ghpc-values.yaml",
+		"--values", "kueue_controller/ghpc-inputs.yaml", "--wait"})
+}
@@ -0,0 +1,149 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+	tfjson "github.com/hashicorp/terraform-json"
+)
+
+// Actions of planned changes
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionReplace = "replace"
+)
+
+// PlannedChange is a change of a resource in a terraform plan
+type PlannedChange struct {
+	Address string
+	Action  string
+}
+
+// PlanSummary counts the changes of a terraform plan, as terraform does:
+// replaced resources are both added and destroyed
+type PlanSummary struct {
+	Add     int
+	Change  int
+	Destroy int
+	Changes []PlannedChange
+}
+
+// summarizePlan returns the changes of resources in a plan, ignoring data
+// sources that are read and resources that do not change
+func summarizePlan(p *tfjson.Plan) PlanSummary {
+	s := PlanSummary{Changes: []PlannedChange{}}
+	for _, rc := range p.ResourceChanges {
+		if rc.Change == nil {
+			continue
+		}
+		a := rc.Change.Actions
+		var action string
+		switch {
+		case a.Replace():
+			action = ActionReplace
+			s.Add++
+			s.Destroy++
+		case a.Create():
+			action = ActionCreate
+			s.Add++
+		case a.Update():
+			action = ActionUpdate
+			s.Change++
+		case a.Delete():
+			action = ActionDelete
+			s.Destroy++
+		default:
+			continue
+		}
+		s.Changes = append(s.Changes, PlannedChange{Address: rc.Address, Action: action})
+	}
+	return s
+}
+
+var actionSymbols = map[string]string{
+	ActionCreate:  "+",
+	ActionUpdate:  "~",
+	ActionDelete:  "-",
+	ActionReplace: "-/+",
+}
+
+// Line returns the counts of the changes, as terraform prints them
+func (s PlanSummary) Line() string {
+	return fmt.Sprintf("Plan: %d to add, %d to change, %d to destroy.", s.Add, s.Change, s.Destroy)
+}
+
+func (s PlanSummary) String() string {
+	var b strings.Builder
+	b.WriteString(s.Line())
+	for _, c := range s.Changes {
+		fmt.Fprintf(&b, "\n%3s %s", actionSymbols[c.Action], c.Address)
+	}
+	return b.String()
+}
+
+var planSummaries struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// WritePlanSummaries writes the summaries of the plans of the groups to w, in
+// addition to printing them
+func WritePlanSummaries(w io.Writer) {
+	planSummaries.Lock()
+	defer planSummaries.Unlock()
+	planSummaries.w = w
+}
+
+// summarizePlanFile prints the summary of the plan in the file, records it
+// and returns it; it returns nil if the plan cannot be read, which leaves
+// the full plan to be reviewed
+func summarizePlanFile(tf *tfexec.Terraform, path string) *PlanSummary {
+	// the plan in JSON, which includes the values of all resources, is not
+	// recorded in the log of the group
+	tf.SetStdout(nil)
+	p, err := tf.ShowPlanFile(context.Background(), path)
+	if l := groupLogOf(tf.WorkingDir()); l != nil {
+		tf.SetStdout(l)
+	}
+	if err != nil {
+		log.Printf("failed to summarize the plan of %s: %v", tf.WorkingDir(), err)
+		return nil
+	}
+	s := summarizePlan(p)
+	log.Printf("proposed changes to %s:\n%s", tf.WorkingDir(), s)
+
+	group := config.GroupName(filepath.Base(tf.WorkingDir()))
+	EmitEvent(Event{Type: EventPlanSummary, Group: group, Message: s.Line(),
+		Changes: &ChangeCounts{Add: s.Add, Change: s.Change, Remove: s.Destroy, Operation: "plan"}})
+	planSummaries.Lock()
+	defer planSummaries.Unlock()
+	if planSummaries.w != nil {
+		fmt.Fprintf(planSummaries.w, "Group %s\n%s\n\n", group, s)
+	}
+	return &s
+}
@@ -0,0 +1,52 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"encoding/json"
+
+	tfjson "github.com/hashicorp/terraform-json"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestSummarizePlan(c *C) {
+	in := `{
+  "format_version": "1.1",
+  "resource_changes": [
+    {"address": "data.google_compute_zones.available", "change": {"actions": ["read"]}},
+    {"address": "module.network.google_compute_network.vpc", "change": {"actions": ["no-op"]}},
+    {"address": "module.nodes.google_compute_instance.node[0]", "change": {"actions": ["create"]}},
+    {"address": "module.nodes.google_compute_instance.node[1]", "change": {"actions": ["delete", "create"]}},
+    {"address": "module.nodes.google_compute_firewall.ssh", "change": {"actions": ["update"]}},
+    {"address": "google_storage_bucket.logs", "change": {"actions": ["delete"]}}
+  ]
+}`
+	var p tfjson.Plan
+	c.Assert(json.Unmarshal([]byte(in), &p), IsNil)
+
+	summary := summarizePlan(&p)
+	c.Check(summary.Add, Equals, 2)
+	c.Check(summary.Change, Equals, 1)
+	c.Check(summary.Destroy, Equals, 2)
+	c.Check(summary.String(), Equals, `Plan: 2 to add, 1 to change, 2 to destroy.
+  + module.nodes.google_compute_instance.node[0]
+-/+ module.nodes.google_compute_instance.node[1]
+  ~ module.nodes.google_compute_firewall.ssh
+  - google_storage_bucket.logs`)
+
+	c.Check(summarizePlan(&tfjson.Plan{}).String(), Equals, "Plan: 0 to add, 0 to change, 0 to destroy.")
+}
@@ -0,0 +1,122 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"errors"
+	"hpc-toolkit/pkg/config"
+	"log"
+	"regexp"
+	"time"
+)
+
+// DefaultRetryPatterns match the errors of Google Cloud APIs that are usually
+// transient: exceeded quotas and rate limits, unavailable services and
+// dropped connections
+var DefaultRetryPatterns = []string{
+	`(?i)quota`,
+	`(?i)rate ?limit`,
+	`(?i)too many requests`,
+	`\b(429|500|502|503|504)\b`,
+	`(?i)service ?unavailable`,
+	`(?i)backend ?error`,
+	`(?i)internal error`,
+	`(?i)connection reset`,
+	`(?i)timeout|timed out`,
+	`(?i)resource is not ready`,
+}
+
+// replaced in tests
+var sleep = time.Sleep
+
+// RetryPolicy retries operations that fail with errors matching its patterns,
+// waiting an exponentially growing backoff between attempts
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Patterns       []*regexp.Regexp
+}
+
+// NewRetryPolicy returns the retry policy of the retry settings of a group;
+// maxAttempts, if positive, overrides the max_attempts setting
+func NewRetryPolicy(r config.GroupRetry, maxAttempts int) (RetryPolicy, error) {
+	p := RetryPolicy{MaxAttempts: 1, InitialBackoff: 30 * time.Second, MaxBackoff: 10 * time.Minute}
+	if r.MaxAttempts > 0 {
+		p.MaxAttempts = r.MaxAttempts
+	}
+	if maxAttempts > 0 {
+		p.MaxAttempts = maxAttempts
+	}
+	var err error
+	if r.InitialBackoff != "" {
+		if p.InitialBackoff, err = time.ParseDuration(r.InitialBackoff); err != nil {
+			return p, err
+		}
+	}
+	if r.MaxBackoff != "" {
+		if p.MaxBackoff, err = time.ParseDuration(r.MaxBackoff); err != nil {
+			return p, err
+		}
+	}
+	patterns := r.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultRetryPatterns
+	}
+	for _, s := range patterns {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return p, err
+		}
+		p.Patterns = append(p.Patterns, re)
+	}
+	return p, nil
+}
+
+// retryable returns whether the error, or the output of the command that
+// failed, matches a pattern of the policy
+func (p RetryPolicy) retryable(err error) bool {
+	msg := err.Error()
+	var ce *CmdError
+	if errors.As(err, &ce) {
+		msg += "\n" + ce.Output
+	}
+	for _, re := range p.Patterns {
+		if re.MatchString(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do runs f until it succeeds, fails with an error that is not retryable or
+// fails MaxAttempts times
+func (p RetryPolicy) Do(what string, f func() error) error {
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+		log.Printf("%s failed with a transient error (attempt %d of %d), retrying in %s: %v",
+			what, attempt, p.MaxAttempts, backoff, err)
+		sleep(backoff)
+		if backoff *= 2; backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"errors"
+	"hpc-toolkit/pkg/config"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestNewRetryPolicy(c *C) {
+	p, err := NewRetryPolicy(config.GroupRetry{}, 0)
+	c.Assert(err, IsNil)
+	c.Check(p.MaxAttempts, Equals, 1)
+	c.Check(p.InitialBackoff, Equals, 30*time.Second)
+	c.Check(p.Patterns, HasLen, len(DefaultRetryPatterns))
+
+	p, err = NewRetryPolicy(config.GroupRetry{MaxAttempts: 3, MaxBackoff: "1m", Patterns: []string{"EXHAUSTED"}}, 5)
+	c.Assert(err, IsNil)
+	c.Check(p.MaxAttempts, Equals, 5)
+	c.Check(p.MaxBackoff, Equals, time.Minute)
+	c.Check(p.Patterns, HasLen, 1)
+
+	_, err = NewRetryPolicy(config.GroupRetry{InitialBackoff: "soon"}, 0)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestRetryPolicyDo(c *C) {
+	var waits []time.Duration
+	defer func(f func(time.Duration)) { sleep = f }(sleep)
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	p, err := NewRetryPolicy(config.GroupRetry{MaxAttempts: 4, InitialBackoff: "10s", MaxBackoff: "15s"}, 0)
+	c.Assert(err, IsNil)
+
+	// transient errors are retried with a growing backoff until success
+	calls := 0
+	err = p.Do("test", func() error {
+		if calls++; calls < 3 {
+			return errors.New("Error 503: Service Unavailable")
+		}
+		return nil
+	})
+	c.Check(err, IsNil)
+	c.Check(calls, Equals, 3)
+	c.Check(waits, DeepEquals, []time.Duration{10 * time.Second, 15 * time.Second})
+
+	// other errors are not
+	calls, waits = 0, nil
+	err = p.Do("test", func() error { calls++; return errors.New("invalid machine type") })
+	c.Check(err, ErrorMatches, "invalid machine type")
+	c.Check(calls, Equals, 1)
+
+	// up to MaxAttempts times, matching the output of failed commands
+	calls = 0
+	err = p.Do("test", func() error {
+		calls++
+		return &CmdError{Err: errors.New("exit status 1"), Output: "Quota 'CPUS' exceeded"}
+	})
+	c.Check(err, ErrorMatches, "exit status 1")
+	c.Check(calls, Equals, 4)
+}
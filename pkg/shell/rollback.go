@@ -0,0 +1,220 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+// RollbackOnFailure controls whether a failed terraform apply is rolled back:
+// the resources it created are destroyed and the state from before the apply
+// is restored, unless resources that existed before it were changed
+var RollbackOnFailure = false
+
+// rawState is the part of the Terraform state format that identifies resources
+// and the objects they are bound to
+type rawState struct {
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   interface{}     `json:"index_key"`
+			Attributes json.RawMessage `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// stateInstances returns the addresses of the instances of the managed
+// resources of a Terraform state, as pulled with "terraform state pull", and
+// the attributes of the object each instance is bound to
+func stateInstances(state string) ([]string, map[string]string, error) {
+	addrs, attrs := []string{}, map[string]string{}
+	if strings.TrimSpace(state) == "" {
+		return addrs, attrs, nil
+	}
+	var s rawState
+	if err := json.Unmarshal([]byte(state), &s); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse Terraform state: %w", err)
+	}
+	for _, r := range s.Resources {
+		if r.Mode != "managed" {
+			continue
+		}
+		addr := r.Type + "." + r.Name
+		if r.Module != "" {
+			addr = r.Module + "." + addr
+		}
+		for _, i := range r.Instances {
+			var a string
+			switch k := i.IndexKey.(type) {
+			case nil:
+				a = addr
+			case string:
+				a = fmt.Sprintf("%s[%q]", addr, k)
+			case float64:
+				a = fmt.Sprintf("%s[%d]", addr, int(k))
+			default:
+				continue
+			}
+			// attributes are marshaled again to compare them regardless of
+			// the order of their keys
+			var v interface{}
+			if len(i.Attributes) > 0 {
+				if err := json.Unmarshal(i.Attributes, &v); err != nil {
+					return nil, nil, fmt.Errorf("failed to parse Terraform state: %w", err)
+				}
+			}
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, nil, err
+			}
+			addrs = append(addrs, a)
+			attrs[a] = string(b)
+		}
+	}
+	return addrs, attrs, nil
+}
+
+// createdAddresses returns the addresses of the state after that are not in
+// the state before
+func createdAddresses(before string, after string) ([]string, error) {
+	_, b, err := stateInstances(before)
+	if err != nil {
+		return nil, err
+	}
+	a, _, err := stateInstances(after)
+	if err != nil {
+		return nil, err
+	}
+	created := []string{}
+	for _, addr := range a {
+		if _, existed := b[addr]; !existed {
+			created = append(created, addr)
+		}
+	}
+	return created, nil
+}
+
+// changedAddresses returns the addresses of the state before whose objects
+// were replaced, updated or destroyed in the state after: the objects the
+// state before is bound to no longer exist as recorded
+func changedAddresses(before string, after string) ([]string, error) {
+	b, battrs, err := stateInstances(before)
+	if err != nil {
+		return nil, err
+	}
+	_, aattrs, err := stateInstances(after)
+	if err != nil {
+		return nil, err
+	}
+	changed := []string{}
+	for _, addr := range b {
+		if a, ok := aattrs[addr]; !ok || a != battrs[addr] {
+			changed = append(changed, addr)
+		}
+	}
+	return changed, nil
+}
+
+// errStateChanged is returned by rollback when resources that existed before
+// the apply were changed, so the state from before it can not be restored
+var errStateChanged = errors.New("the state from before the apply was not restored")
+
+// applyWithRollback runs apply; if it fails, the resources it created are
+// destroyed and the state from before it is restored
+func applyWithRollback(tf *tfexec.Terraform, apply func() error) error {
+	ctx := context.Background()
+	snapshot, err := tf.StatePull(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot the state before apply: %w", err)
+	}
+	f, err := os.CreateTemp("", "ghpc-state-*.tfstate")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(snapshot); err != nil {
+		return err
+	}
+
+	applyErr := apply()
+	if applyErr == nil {
+		os.Remove(f.Name())
+		return nil
+	}
+
+	log.Printf("terraform apply failed in %s, rolling back", tf.WorkingDir())
+	if err := rollback(ctx, tf, snapshot, f.Name()); errors.Is(err, errStateChanged) {
+		os.Remove(f.Name())
+		return fmt.Errorf("%w\nrollback incomplete: %v", applyErr, err)
+	} else if err != nil {
+		return fmt.Errorf("%w\nrollback failed, the state from before the apply is saved in %s: %v", applyErr, f.Name(), err)
+	}
+	os.Remove(f.Name())
+	log.Printf("rolled back %s to its state before the apply", tf.WorkingDir())
+	return applyErr
+}
+
+func rollback(ctx context.Context, tf *tfexec.Terraform, snapshot string, snapshotFile string) error {
+	after, err := tf.StatePull(ctx)
+	if err != nil {
+		return err
+	}
+	created, err := createdAddresses(snapshot, after)
+	if err != nil {
+		return err
+	}
+	changed, err := changedAddresses(snapshot, after)
+	if err != nil {
+		return err
+	}
+	if len(created) > 0 {
+		log.Printf("destroying %d resources created by the failed apply: %s", len(created), strings.Join(created, ", "))
+		opts := []tfexec.DestroyOption{}
+		for _, addr := range created {
+			opts = append(opts, tfexec.Target(addr))
+		}
+		tf.SetStdout(withGroupLog(tf.WorkingDir(), ConsoleOutput()))
+		tf.SetStderr(withGroupLog(tf.WorkingDir(), consoleErrors()))
+		defer tf.SetStdout(nil)
+		defer tf.SetStderr(nil)
+		if err := tf.Destroy(ctx, opts...); err != nil {
+			return err
+		}
+	}
+	if len(changed) > 0 {
+		// pushing the state from before the apply would bind these addresses
+		// to objects that no longer exist, and leave the new ones untracked
+		return fmt.Errorf("%w, resources that existed before it were replaced, updated or destroyed: %s; "+
+			"the state was left as is, deploy the group again to converge", errStateChanged, strings.Join(changed, ", "))
+	}
+	if strings.TrimSpace(snapshot) == "" {
+		// there was no state before the apply
+		return nil
+	}
+	return tf.StatePush(ctx, snapshotFile, tfexec.Force(true))
+}
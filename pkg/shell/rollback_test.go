@@ -0,0 +1,103 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestCreatedAddresses(c *C) {
+	before := `{
+  "version": 4,
+  "resources": [
+    {"mode": "managed", "type": "google_compute_network", "name": "vpc", "module": "module.network",
+     "instances": [{"index_key": null}]},
+    {"mode": "data", "type": "google_compute_zones", "name": "available",
+     "instances": [{}]}
+  ]
+}`
+	after := `{
+  "version": 4,
+  "resources": [
+    {"mode": "managed", "type": "google_compute_network", "name": "vpc", "module": "module.network",
+     "instances": [{}]},
+    {"mode": "data", "type": "google_compute_zones", "name": "available",
+     "instances": [{}]},
+    {"mode": "managed", "type": "google_compute_instance", "name": "node", "module": "module.nodes",
+     "instances": [{"index_key": 0}, {"index_key": 1}]},
+    {"mode": "managed", "type": "google_storage_bucket", "name": "b",
+     "instances": [{"index_key": "logs"}]}
+  ]
+}`
+	created, err := createdAddresses(before, after)
+	c.Assert(err, IsNil)
+	c.Check(created, DeepEquals, []string{
+		"module.nodes.google_compute_instance.node[0]",
+		"module.nodes.google_compute_instance.node[1]",
+		`google_storage_bucket.b["logs"]`,
+	})
+
+	// everything was created if there was no state before the apply
+	created, err = createdAddresses("", after)
+	c.Assert(err, IsNil)
+	c.Check(created, HasLen, 4)
+
+	_, err = createdAddresses("{", after)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestChangedAddresses(c *C) {
+	before := `{
+  "version": 4,
+  "resources": [
+    {"mode": "managed", "type": "google_compute_network", "name": "vpc",
+     "instances": [{"attributes": {"id": "vpc-1", "mtu": 1460}}]},
+    {"mode": "managed", "type": "google_compute_instance", "name": "login",
+     "instances": [{"attributes": {"id": "login-1", "machine_type": "n2-standard-2"}}]},
+    {"mode": "managed", "type": "google_storage_bucket", "name": "b",
+     "instances": [{"attributes": {"id": "b-1"}}]},
+    {"mode": "managed", "type": "google_compute_firewall", "name": "fw",
+     "instances": [{"attributes": {"id": "fw-1"}}]}
+  ]
+}`
+	after := `{
+  "version": 4,
+  "resources": [
+    {"mode": "managed", "type": "google_compute_network", "name": "vpc",
+     "instances": [{"attributes": {"mtu": 1460, "id": "vpc-1"}}]},
+    {"mode": "managed", "type": "google_compute_instance", "name": "login",
+     "instances": [{"attributes": {"id": "login-2", "machine_type": "n2-standard-2"}}]},
+    {"mode": "managed", "type": "google_storage_bucket", "name": "b",
+     "instances": [{"attributes": {"id": "b-1", "versioning": true}}]},
+    {"mode": "managed", "type": "google_compute_subnetwork", "name": "subnet",
+     "instances": [{"attributes": {"id": "subnet-1"}}]}
+  ]
+}`
+	// replaced, updated in place and destroyed; the formatting of the
+	// attributes does not matter
+	changed, err := changedAddresses(before, after)
+	c.Assert(err, IsNil)
+	c.Check(changed, DeepEquals, []string{
+		"google_compute_instance.login",
+		"google_storage_bucket.b",
+		"google_compute_firewall.fw",
+	})
+
+	changed, err = changedAddresses("", after)
+	c.Assert(err, IsNil)
+	c.Check(changed, HasLen, 0)
+}
@@ -0,0 +1,114 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"os"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// UseSecretVars reads the deployment variables of the blueprint that are
+// Secret Manager references, using the application default credentials, and
+// passes them to the Terraform commands run afterwards as TF_VAR_ environment
+// variables. It returns the values of the secrets, to be masked in logs.
+func UseSecretVars(bp config.Blueprint) ([]string, error) {
+	refs := bp.SecretVars()
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	values, err := readSecrets(context.Background(), refs)
+	if err != nil {
+		return nil, err
+	}
+	for n, v := range values {
+		if err := os.Setenv("TF_VAR_"+n, v); err != nil {
+			return nil, err
+		}
+	}
+	return maps.Values(values), nil
+}
+
+// UseRemoteOutputVars reads the sensitive outputs of other deployments the
+// deployment variables of the blueprint refer to, and passes them to the
+// Terraform commands run afterwards as TF_VAR_ environment variables. It
+// returns the values of the outputs, to be masked in logs.
+func UseRemoteOutputVars(bp config.Blueprint) ([]string, error) {
+	refs := bp.RemoteOutputVars()
+	names := maps.Keys(refs)
+	slices.Sort(names)
+	values := []string{}
+	for _, n := range names {
+		v, err := refs[n].Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read deployment variable %s: %w", n, err)
+		}
+		env, err := tfVarValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pass deployment variable %s to Terraform: %w", n, err)
+		}
+		if err := os.Setenv("TF_VAR_"+n, env); err != nil {
+			return nil, err
+		}
+		values = append(values, config.PrintedValues(v)...)
+	}
+	return values, nil
+}
+
+// tfVarValue returns v as the value of a TF_VAR_ environment variable:
+// strings as they are, and other values as JSON, which Terraform parses as
+// HCL for variables of complex types
+func tfVarValue(v cty.Value) (string, error) {
+	if v.Type() == cty.String {
+		return v.AsString(), nil
+	}
+	b, err := ctyjson.Marshal(v, v.Type())
+	return string(b), err
+}
+
+// readSecrets returns the values of the versions of the secrets, by name
+func readSecrets(ctx context.Context, refs map[string]config.SecretRef, opts ...option.ClientOption) (map[string]string, error) {
+	s, err := secretmanager.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Secret Manager client: %w", err)
+	}
+	names := maps.Keys(refs)
+	slices.Sort(names)
+	values := map[string]string{}
+	for _, n := range names {
+		r := refs[n]
+		resp, err := s.Projects.Secrets.Versions.Access(r.Name()).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret %s of deployment variable %s: %w", r.Name(), n, err)
+		}
+		b, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode secret %s of deployment variable %s: %w", r.Name(), n, err)
+		}
+		values[n] = string(b)
+	}
+	return values, nil
+}
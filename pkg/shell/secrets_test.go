@@ -0,0 +1,53 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"google.golang.org/api/option"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestReadSecrets(c *C) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "projects/p/secrets/db/versions/latest:access") {
+			http.Error(w, `{"error": {"code": 404, "message": "not found"}}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"payload": {"data": %q}}`, base64.StdEncoding.EncodeToString([]byte("hunter22")))
+	}))
+	defer srv.Close()
+	opts := []option.ClientOption{option.WithEndpoint(srv.URL), option.WithoutAuthentication()}
+
+	got, err := readSecrets(context.Background(), map[string]config.SecretRef{
+		"db_password": {Project: "p", Secret: "db", Version: "latest"},
+	}, opts...)
+	c.Assert(err, IsNil)
+	c.Check(got, DeepEquals, map[string]string{"db_password": "hunter22"})
+
+	_, err = readSecrets(context.Background(), map[string]config.SecretRef{
+		"license": {Project: "p", Secret: "license", Version: "2"},
+	}, opts...)
+	c.Check(err, ErrorMatches, "failed to read secret projects/p/secrets/license/versions/2 of deployment variable license: .*")
+}
@@ -0,0 +1,87 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"errors"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/modulewriter"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DeployStateFilename is the file in the artifacts directory that records the
+// deployment groups applied successfully
+const DeployStateFilename = modulewriter.DeployStateFilename
+
+// DeployState records when each deployment group was last applied
+// successfully, so that a failed deployment can be resumed
+type DeployState struct {
+	Applied map[config.GroupName]time.Time `yaml:"applied"`
+}
+
+// ReadDeployState reads the deployment state from the artifacts directory; the
+// state is empty if no group was applied yet
+func ReadDeployState(artifactsDir string) (DeployState, error) {
+	s := DeployState{Applied: map[config.GroupName]time.Time{}}
+	b, err := os.ReadFile(filepath.Join(artifactsDir, DeployStateFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return s, err
+	}
+	if s.Applied == nil {
+		s.Applied = map[config.GroupName]time.Time{}
+	}
+	return s, nil
+}
+
+// Write writes the deployment state to the artifacts directory
+func (s DeployState) Write(artifactsDir string) error {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(artifactsDir, DeployStateFilename), b, 0644)
+}
+
+// IsApplied returns whether the group was applied successfully
+func (s DeployState) IsApplied(g config.GroupName) bool {
+	_, ok := s.Applied[g]
+	return ok
+}
+
+// MarkApplied records that the group was applied successfully and writes the
+// state to the artifacts directory
+func (s DeployState) MarkApplied(artifactsDir string, g config.GroupName) error {
+	s.Applied[g] = time.Now().UTC()
+	return s.Write(artifactsDir)
+}
+
+// MarkDestroyed records that the group was destroyed and writes the state to
+// the artifacts directory
+func (s DeployState) MarkDestroyed(artifactsDir string, g config.GroupName) error {
+	delete(s.Applied, g)
+	return s.Write(artifactsDir)
+}
@@ -0,0 +1,41 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestDeployState(c *C) {
+	dir := c.MkDir()
+	state, err := ReadDeployState(dir)
+	c.Assert(err, IsNil)
+	c.Check(state.IsApplied("primary"), Equals, false)
+
+	c.Assert(state.MarkApplied(dir, "primary"), IsNil)
+	c.Assert(state.MarkApplied(dir, "cluster"), IsNil)
+	state, err = ReadDeployState(dir)
+	c.Assert(err, IsNil)
+	c.Check(state.IsApplied("primary"), Equals, true)
+	c.Check(state.IsApplied("cluster"), Equals, true)
+
+	c.Assert(state.MarkDestroyed(dir, "cluster"), IsNil)
+	state, err = ReadDeployState(dir)
+	c.Assert(err, IsNil)
+	c.Check(state.IsApplied("primary"), Equals, true)
+	c.Check(state.IsApplied("cluster"), Equals, false)
+}
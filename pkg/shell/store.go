@@ -0,0 +1,105 @@
+/**
+ * Copyright 2023 Google LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/gcsupload"
+	"hpc-toolkit/pkg/modulewriter"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// ArtifactStore is the gs://<bucket>/<prefix> URL the exported outputs of
+// deployment groups are also stored in, so that groups can be deployed from
+// different machines; outputs are only stored locally if it is empty
+var ArtifactStore = ""
+
+// storeURL returns the URL of the outputs of the group in the artifact store
+func storeURL(group config.GroupName) string {
+	return strings.TrimSuffix(ArtifactStore, "/") + "/" + filepath.Base(outputsFile("", group))
+}
+
+// storeUploader returns an uploader whose interrupted uploads are resumed from
+// the hidden directory of the deployment of artifactsDir
+func storeUploader(ctx context.Context, artifactsDir string) (*gcsupload.Uploader, error) {
+	stateDir := filepath.Join(filepath.Dir(artifactsDir), modulewriter.UploadsDirName)
+	return gcsupload.NewUploader(ctx, stateDir, 0)
+}
+
+// pushOutputs uploads the exported outputs of the group to the artifact store
+func pushOutputs(artifactsDir string, group config.GroupName) error {
+	if ArtifactStore == "" {
+		return nil
+	}
+	ctx := context.Background()
+	u, err := storeUploader(ctx, artifactsDir)
+	if err != nil {
+		return err
+	}
+	dst := storeURL(group)
+	log.Printf("storing outputs of group %s in %s", group, dst)
+	if _, err := u.Upload(ctx, "outputs-"+string(group), outputsFile(artifactsDir, group), dst); err != nil {
+		return fmt.Errorf("failed to store the outputs of group %s: %w", group, err)
+	}
+	return nil
+}
+
+// pushDeploymentOutputs uploads the JSON outputs of the deployment to the
+// artifact store, where the remote_outputs of other deployments read them
+func pushDeploymentOutputs(artifactsDir string) error {
+	if ArtifactStore == "" {
+		return nil
+	}
+	ctx := context.Background()
+	u, err := storeUploader(ctx, artifactsDir)
+	if err != nil {
+		return err
+	}
+	src := DeploymentOutputsFile(artifactsDir, "json")
+	dst := strings.TrimSuffix(ArtifactStore, "/") + "/" + filepath.Base(src)
+	log.Printf("storing outputs of the deployment in %s", dst)
+	if _, err := u.Upload(ctx, "deployment-outputs", src, dst); err != nil {
+		return fmt.Errorf("failed to store the outputs of the deployment: %w", err)
+	}
+	return nil
+}
+
+// pullOutputs downloads the outputs of the group from the artifact store to
+// artifactsDir and returns whether they were found
+func pullOutputs(artifactsDir string, group config.GroupName) (bool, error) {
+	if ArtifactStore == "" {
+		return false, nil
+	}
+	ctx := context.Background()
+	u, err := storeUploader(ctx, artifactsDir)
+	if err != nil {
+		return false, err
+	}
+	src := storeURL(group)
+	found, err := u.Download(ctx, src, outputsFile(artifactsDir, group))
+	if err != nil {
+		return false, fmt.Errorf("failed to read the outputs of group %s from the artifact store: %w", group, err)
+	}
+	if found {
+		log.Printf("read outputs of group %s from %s", group, src)
+	}
+	return found, nil
+}
@@ -19,15 +19,18 @@ package shell
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"hpc-toolkit/pkg/config"
 	"hpc-toolkit/pkg/modulereader"
 	"hpc-toolkit/pkg/modulewriter"
+	"hpc-toolkit/pkg/tools"
+	"hpc-toolkit/pkg/validators"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/hashicorp/terraform-exec/tfexec"
 	"github.com/zclconf/go-cty/cty"
@@ -62,18 +65,77 @@ type outputValue struct {
 	Value     cty.Value
 }
 
-// ConfigureTerraform returns a Terraform object used to execute commands
+// ConfigureTerraform returns a Terraform object used to execute commands,
+// with the terraform binary in PATH or the one installed by ghpc
 func ConfigureTerraform(workingDir string) (*tfexec.Terraform, error) {
-	path, err := exec.LookPath("terraform")
+	path, err := tools.Terraform.Find()
 	if err != nil {
 		return nil, &TfError{
-			help: "must have a copy of terraform installed in PATH",
+			help: fmt.Sprintf("must have a copy of terraform %s installed in PATH, or run ghpc tools install", tools.Terraform.Constraints),
 			err:  err,
 		}
 	}
 	return tfexec.NewTerraform(workingDir, path)
 }
 
+// GroupEnv returns the environment variables Terraform and the hooks of the
+// group are run with, in addition to the environment of ghpc
+func GroupEnv(group config.DeploymentGroup) map[string]string {
+	env := map[string]string{}
+	if sa := group.ImpersonateServiceAccount; sa != "" {
+		env[validators.ImpersonateEnvVar] = sa
+	}
+	return env
+}
+
+// ConfigureGroupTerraform returns a Terraform object used to execute commands
+// in the directory of the group, with the environment of the group
+func ConfigureGroupTerraform(groupDir string, group config.DeploymentGroup) (*tfexec.Terraform, error) {
+	tf, err := ConfigureTerraform(groupDir)
+	if err != nil {
+		return nil, err
+	}
+	env := GroupEnv(group)
+	if len(env) == 0 {
+		return tf, nil
+	}
+	// the environment set replaces that of ghpc, less the variables managed
+	// by terraform-exec
+	environ := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			environ[k] = v
+		}
+	}
+	for _, k := range tfexec.ProhibitedEnv(environ) {
+		delete(environ, k)
+	}
+	for k, v := range env {
+		environ[k] = v
+	}
+	if err := tf.SetEnv(environ); err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// UseTerraformCLIConfig configures terraform to use the CLI configuration
+// written to offline deployments, unless TF_CLI_CONFIG_FILE is already set
+func UseTerraformCLIConfig(deploymentRoot string) error {
+	if _, ok := os.LookupEnv("TF_CLI_CONFIG_FILE"); ok {
+		return nil
+	}
+	cfg, err := filepath.Abs(filepath.Join(deploymentRoot, modulewriter.HiddenGhpcDirName, modulewriter.TerraformCLIConfigName))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(cfg); err != nil {
+		return nil
+	}
+	log.Printf("installing terraform providers from the offline mirror configured in %s", cfg)
+	return os.Setenv("TF_CLI_CONFIG_FILE", cfg)
+}
+
 // this function executes a lightweight "terraform init" that is designed to
 // test if the root module was previously initialized and is consistent with
 // the current code; it will not download modules or configure backends, but it
@@ -105,6 +167,31 @@ func initModule(tf *tfexec.Terraform) error {
 	return err
 }
 
+// LockProviders writes the dependency lock file of a Terraform deployment
+// group with the checksums of its providers for the platforms, e.g.
+// "linux_amd64", or for the current platform if there are none; the modules
+// of the group are installed first, as the providers they require are locked
+func LockProviders(tf *tfexec.Terraform, platforms []string) error {
+	log.Printf("locking the providers of terraform module %s", tf.WorkingDir())
+	if err := tf.Get(context.Background()); err != nil {
+		return &TfError{
+			help: fmt.Sprintf("installing the modules of %s failed; manually resolve errors below", tf.WorkingDir()),
+			err:  err,
+		}
+	}
+	opts := []tfexec.ProvidersLockOption{}
+	for _, p := range platforms {
+		opts = append(opts, tfexec.Platform(p))
+	}
+	if err := tf.ProvidersLock(context.Background(), opts...); err != nil {
+		return &TfError{
+			help: fmt.Sprintf("locking the providers of %s failed; manually resolve errors below", tf.WorkingDir()),
+			err:  err,
+		}
+	}
+	return nil
+}
+
 func outputModule(tf *tfexec.Terraform) (map[string]cty.Value, error) {
 	log.Printf("collecting terraform outputs from %s", tf.WorkingDir())
 	output, err := tf.Output(context.Background())
@@ -139,8 +226,14 @@ func outputModule(tf *tfexec.Terraform) (map[string]cty.Value, error) {
 // may need to determine future-proof way of getting human-readable plan
 // https://github.com/hashicorp/terraform-exec/blob/1b7714111a94813e92936051fb3014fec81218d5/tfexec/plan.go#L128-L129
 func planModule(tf *tfexec.Terraform, path string, destroy bool) (bool, error) {
-	outOpt := tfexec.Out(path)
-	wantsChange, err := tf.Plan(context.Background(), outOpt, tfexec.Destroy(destroy))
+	opts := []tfexec.PlanOption{tfexec.Out(path), tfexec.Destroy(destroy)}
+	var wantsChange bool
+	var err error
+	if EventsEnabled() {
+		wantsChange, err = planEvents(tf, opts...)
+	} else {
+		wantsChange, err = tf.Plan(context.Background(), opts...)
+	}
 	if err != nil {
 		return false, &TfError{
 			help: fmt.Sprintf("terraform plan for %s failed; suggest running \"ghpc export-outputs\" on previous deployment groups to define inputs", tf.WorkingDir()),
@@ -151,7 +244,27 @@ func planModule(tf *tfexec.Terraform, path string, destroy bool) (bool, error) {
 	return wantsChange, nil
 }
 
-func promptForApply(tf *tfexec.Terraform, path string, b ApplyBehavior) bool {
+// planEvents plans with terraform -json, writing the changes it plans as
+// events of the group; the errors terraform reports are added to the error of
+// the plan
+func planEvents(tf *tfexec.Terraform, opts ...tfexec.PlanOption) (bool, error) {
+	w := &tfEventWriter{group: config.GroupName(filepath.Base(tf.WorkingDir()))}
+	// PlanJSON replaces the standard output of terraform, which is restored
+	// for the commands that follow the plan
+	if l := groupLogOf(tf.WorkingDir()); l != nil {
+		defer tf.SetStdout(l)
+	} else {
+		defer tf.SetStdout(nil)
+	}
+	wantsChange, err := tf.PlanJSON(context.Background(), withGroupLog(tf.WorkingDir(), w), opts...)
+	w.flush()
+	if err != nil && len(w.errors) > 0 {
+		return false, fmt.Errorf("%w\n%s", err, strings.Join(w.errors, "\n"))
+	}
+	return wantsChange, err
+}
+
+func promptForApply(tf *tfexec.Terraform, path string, summary *PlanSummary, b ApplyBehavior) bool {
 	switch b {
 	case AutomaticApply:
 		return true
@@ -161,15 +274,19 @@ func promptForApply(tf *tfexec.Terraform, path string, b ApplyBehavior) bool {
 			return false
 		}
 
-		re := regexp.MustCompile(`Plan: .*\n`)
-		summary := re.FindString(plan)
-
-		if summary == "" {
-			summary = fmt.Sprintf("Please review full proposed changes for %s", tf.WorkingDir())
+		var line string
+		if summary != nil {
+			line = summary.Line()
+		} else {
+			re := regexp.MustCompile(`Plan: .*\n`)
+			line = re.FindString(plan)
+		}
+		if line == "" {
+			line = fmt.Sprintf("Please review full proposed changes for %s", tf.WorkingDir())
 		}
 
 		changes := ProposedChanges{
-			Summary: summary,
+			Summary: line,
 			Full:    plan,
 		}
 
@@ -182,8 +299,11 @@ func promptForApply(tf *tfexec.Terraform, path string, b ApplyBehavior) bool {
 func applyPlanConsoleOutput(tf *tfexec.Terraform, path string) error {
 	planFileOpt := tfexec.DirOrPlan(path)
 	log.Printf("running terraform apply on group %s", tf.WorkingDir())
-	tf.SetStdout(os.Stdout)
-	tf.SetStderr(os.Stderr)
+	if EventsEnabled() {
+		return applyPlanEvents(tf, planFileOpt)
+	}
+	tf.SetStdout(withGroupLog(tf.WorkingDir(), ConsoleOutput()))
+	tf.SetStderr(withGroupLog(tf.WorkingDir(), consoleErrors()))
 	if err := tf.Apply(context.Background(), planFileOpt); err != nil {
 		return err
 	}
@@ -192,6 +312,22 @@ func applyPlanConsoleOutput(tf *tfexec.Terraform, path string) error {
 	return nil
 }
 
+// applyPlanEvents applies a plan with terraform -json, writing its progress
+// as events of the group; the errors terraform reports are added to the error
+// of the apply
+func applyPlanEvents(tf *tfexec.Terraform, planFileOpt *tfexec.DirOrPlanOption) error {
+	w := &tfEventWriter{group: config.GroupName(filepath.Base(tf.WorkingDir()))}
+	tf.SetStderr(withGroupLog(tf.WorkingDir(), consoleErrors()))
+	defer tf.SetStdout(nil)
+	defer tf.SetStderr(nil)
+	err := tf.ApplyJSON(context.Background(), withGroupLog(tf.WorkingDir(), w), planFileOpt)
+	w.flush()
+	if err != nil && len(w.errors) > 0 {
+		return fmt.Errorf("%w\n%s", err, strings.Join(w.errors, "\n"))
+	}
+	return err
+}
+
 // generate a Terraform plan to apply or destroy a module
 // recall "destroy" is just an alias for "apply -destroy"!
 // apply the plan automatically or after prompting the user
@@ -203,6 +339,13 @@ func applyOrDestroy(tf *tfexec.Terraform, b ApplyBehavior, destroy bool) error {
 		pastTense = "destroyed"
 	}
 
+	// the output of init and plan is only recorded in the log of the group
+	if l := groupLogOf(tf.WorkingDir()); l != nil {
+		tf.SetStdout(l)
+		tf.SetStderr(l)
+		defer tf.SetStdout(nil)
+		defer tf.SetStderr(nil)
+	}
 	if err := initModule(tf); err != nil {
 		return err
 	}
@@ -222,7 +365,8 @@ func applyOrDestroy(tf *tfexec.Terraform, b ApplyBehavior, destroy bool) error {
 	var apply bool
 	if wantsChange {
 		log.Printf("module in %s requires %s cloud infrastructure", tf.WorkingDir(), action)
-		apply = b == AutomaticApply || promptForApply(tf, f.Name(), b)
+		summary := summarizePlanFile(tf, f.Name())
+		apply = b == AutomaticApply || promptForApply(tf, f.Name(), summary, b)
 	} else {
 		log.Printf("cloud infrastructure in %s is already %s", tf.WorkingDir(), pastTense)
 	}
@@ -231,6 +375,9 @@ func applyOrDestroy(tf *tfexec.Terraform, b ApplyBehavior, destroy bool) error {
 		return nil
 	}
 
+	if !destroy && RollbackOnFailure {
+		return applyWithRollback(tf, func() error { return applyPlanConsoleOutput(tf, f.Name()) })
+	}
 	if err := applyPlanConsoleOutput(tf, f.Name()); err != nil {
 		return err
 	}
@@ -279,12 +426,76 @@ func ExportOutputs(tf *tfexec.Terraform, artifactsDir string, applyBehavior Appl
 		return err
 	}
 
-	return nil
+	return pushOutputs(artifactsDir, thisGroup)
+}
+
+// exportAppliedOutputs exports the outputs of a Terraform group that was
+// applied, but whose outputs are not exported, e.g. because the deployment
+// was written again, from its state and without planning it
+func exportAppliedOutputs(deploymentRoot string, artifactsDir string, group config.GroupName) error {
+	tf, err := ConfigureTerraform(filepath.Join(deploymentRoot, string(group)))
+	if err != nil {
+		return err
+	}
+	if err := initModule(tf); err != nil {
+		return err
+	}
+	outputValues, err := outputModule(tf)
+	if err != nil {
+		return err
+	}
+	if len(outputValues) == 0 {
+		return fmt.Errorf("group %s has no outputs, it may not be deployed", group)
+	}
+	path := outputsFile(artifactsDir, group)
+	log.Printf("writing outputs artifact from group %s to file %s", group, path)
+	if err := modulewriter.WriteHclAttributes(outputValues, path); err != nil {
+		return err
+	}
+	return pushOutputs(artifactsDir, group)
+}
+
+// fetchOutputs makes the outputs of the group available in artifactsDir. The
+// outputs in the artifact store replace the local ones, which are stale if the
+// group was deployed again from another machine, and outputs that were not
+// exported are exported from the state of the group, or from the manifest of
+// Packer groups.
+func fetchOutputs(deploymentRoot string, artifactsDir string, group config.DeploymentGroup) error {
+	found, err := pullOutputs(artifactsDir, group.Name)
+	if err != nil || found {
+		return err
+	}
+	if _, err := os.Stat(outputsFile(artifactsDir, group.Name)); !errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if group.Kind == config.PackerKind {
+		return ExportPackerOutputs(deploymentRoot, artifactsDir, group)
+	}
+	return exportAppliedOutputs(deploymentRoot, artifactsDir, group.Name)
+}
+
+// OutputsExported checks if the outputs of the group were exported to
+// artifactsDir, by ghpc deploy or ghpc export-outputs
+func OutputsExported(artifactsDir string, group config.GroupName) bool {
+	_, err := os.Stat(outputsFile(artifactsDir, group))
+	return err == nil
+}
+
+// ReadOutputs reads the outputs of a group exported by ExportOutputs; a group
+// without exported outputs has none
+func ReadOutputs(artifactsDir string, group config.GroupName) (map[string]cty.Value, error) {
+	path := outputsFile(artifactsDir, group)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return map[string]cty.Value{}, nil
+	}
+	return modulereader.ReadHclAttributes(path)
 }
 
 // ImportInputs will search artifactsDir for files produced by ExportOutputs and
 // combine/filter them for the input values needed by the group in the Terraform
-// working directory
+// working directory. The outputs of groups are read from the artifact store,
+// if set, and outputs that were not exported are exported from the Terraform
+// state of their group first.
 func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBlueprintFile string) error {
 	deploymentRoot := filepath.Clean(filepath.Join(deploymentGroupDir, ".."))
 
@@ -310,12 +521,20 @@ func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBluepr
 		}
 		log.Printf("collecting outputs for group %s from group %s", g.Name, groupName)
 		filepath := outputsFile(artifactsDir, groupName)
+		og, err := dc.Config.Group(groupName)
+		if err != nil {
+			return err
+		}
+		if err := fetchOutputs(deploymentRoot, artifactsDir, og); err != nil {
+			log.Printf("failed to fetch the outputs of group %s: %v", groupName, err)
+		}
 		groupOutputValues, err := modulereader.ReadHclAttributes(filepath)
 		if err != nil {
-			return &TfError{
-				help: fmt.Sprintf("consider running \"ghpc export-outputs %s/%s\"", deploymentRoot, groupName),
-				err:  err,
+			help := fmt.Sprintf("consider running \"ghpc export-outputs %s/%s\"", deploymentRoot, groupName)
+			if og.Kind == config.PackerKind {
+				help = fmt.Sprintf("deploy group %s to build its image first", groupName)
 			}
+			return &TfError{help: help, err: err}
 		}
 		intergroupValues := intersectMapKeys(intergroupOutputNames, groupOutputValues)
 		mergeMapsWithoutLoss(allInputValues, intergroupValues)
@@ -325,47 +544,56 @@ func ImportInputs(deploymentGroupDir string, artifactsDir string, expandedBluepr
 		return nil
 	}
 
-	var outfile string
 	switch g.Kind {
 	case config.TerraformKind:
-		outfile = filepath.Join(deploymentGroupDir, fmt.Sprintf("%s_inputs.auto.tfvars", g.Name))
-	case config.PackerKind:
-		thisGroupIdx := dc.Config.GroupIndex(g.Name)
-		packerGroup := dc.Config.DeploymentGroups[thisGroupIdx]
-		// Packer groups are enforced to have length 1
-		packerModule := packerGroup.Modules[0]
-		moduleID := string(packerModule.ID)
-		outfile = filepath.Join(deploymentGroupDir, moduleID, fmt.Sprintf("%s_inputs.auto.pkrvars.hcl", moduleID))
-
-		// evaluate Packer settings that contain intergroup references in the
-		// context of deployment variables and intergroup output values
-		intergroupSettings := config.Dict{}
-		for setting, value := range packerModule.Settings.Items() {
-			igcRefs := config.FindIntergroupReferences(value, packerModule, dc.Config)
-			if len(igcRefs) > 0 {
-				intergroupSettings.Set(setting, value)
-			}
-		}
-
-		igcVars := modulewriter.FindIntergroupVariables(packerGroup, dc.Config)
-		newModule := modulewriter.SubstituteIgcReferencesInModule(config.Module{Settings: intergroupSettings}, igcVars)
-
+		outfile := filepath.Join(deploymentGroupDir, fmt.Sprintf("%s_inputs.auto.tfvars", g.Name))
+		return writeInputs(g.Name, allInputValues, outfile)
+	case config.PackerKind, config.HelmKind:
 		varsValues := dc.Config.Vars.Items()
 		mergeMapsWithoutLoss(allInputValues, varsValues)
-		evaluatedSettings, err := newModule.Settings.Eval(config.Blueprint{Vars: config.NewDict(allInputValues)})
-		if err != nil {
-			return err
+		igcVars := modulewriter.FindIntergroupVariables(g, dc.Config)
+		for _, mod := range g.Modules {
+			// evaluate Packer and Helm settings that contain intergroup references in
+			// the context of deployment variables and intergroup output values
+			intergroupSettings := config.Dict{}
+			for setting, value := range mod.Settings.Items() {
+				igcRefs := config.FindIntergroupReferences(value, mod, dc.Config)
+				if len(igcRefs) > 0 {
+					intergroupSettings.Set(setting, value)
+				}
+			}
+			if len(intergroupSettings.Items()) == 0 {
+				continue
+			}
+
+			newModule := modulewriter.SubstituteIgcReferencesInModule(config.Module{Settings: intergroupSettings}, igcVars)
+			evaluatedSettings, err := newModule.Settings.Eval(config.Blueprint{Vars: config.NewDict(allInputValues)})
+			if err != nil {
+				return err
+			}
+			moduleID := string(mod.ID)
+			if g.Kind == config.HelmKind {
+				outfile := filepath.Join(deploymentGroupDir, moduleID, modulewriter.HelmInputsFilename)
+				log.Printf("writing outputs for group %s to file %s\n", g.Name, outfile)
+				if err := modulewriter.WriteHelmValues(evaluatedSettings.Items(), outfile); err != nil {
+					return err
+				}
+				continue
+			}
+			outfile := filepath.Join(deploymentGroupDir, moduleID, fmt.Sprintf("%s_inputs.auto.pkrvars.hcl", moduleID))
+			if err := writeInputs(g.Name, evaluatedSettings.Items(), outfile); err != nil {
+				return err
+			}
 		}
-		allInputValues = evaluatedSettings.Items()
+		return nil
 	default:
 		return fmt.Errorf("unexpected error: unknown module kind for group %s", g.Name)
 	}
-	log.Printf("writing outputs for group %s to file %s\n", g.Name, outfile)
-	if err := modulewriter.WriteHclAttributes(allInputValues, outfile); err != nil {
-		return err
-	}
+}
 
-	return nil
+func writeInputs(group config.GroupName, values map[string]cty.Value, outfile string) error {
+	log.Printf("writing outputs for group %s to file %s\n", group, outfile)
+	return modulewriter.WriteHclAttributes(values, outfile)
 }
 
 // Destroy destroys all infrastructure in the module working directory
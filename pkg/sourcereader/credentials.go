@@ -0,0 +1,250 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-getter"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// CredentialsFileEnvVar overrides the default location of the credentials
+	// file, ~/.ghpc/credentials.yaml
+	CredentialsFileEnvVar = "GHPC_CREDENTIALS_FILE"
+	// GitTokenEnvVar sets a token used for HTTPS git sources of the hosts
+	// listed in GitTokenHostsEnvVar
+	GitTokenEnvVar = "GHPC_GIT_TOKEN"
+	// GitTokenHostsEnvVar is the comma-separated list of hosts GitTokenEnvVar
+	// is sent to; the token is sent to no other host
+	GitTokenHostsEnvVar = "GHPC_GIT_TOKEN_HOSTS"
+
+	defaultGitUsername = "oauth2"
+)
+
+var (
+	// ErrGitAuth is returned when a git source could not be fetched because
+	// authentication failed
+	ErrGitAuth = errors.New("authentication failed")
+	// ErrGitRefNotFound is returned when a git source could not be fetched
+	// because the repository or the requested ref does not exist
+	ErrGitRefNotFound = errors.New("repository or ref not found")
+)
+
+// GitCredential configures authentication to the git repositories of a host.
+// HTTPS sources use Token, or the value of the environment variable TokenEnv;
+// SSH sources use the private key in SSHKeyFile (e.g. a deploy key) instead of
+// the SSH agent.
+type GitCredential struct {
+	Host       string `yaml:"host"`
+	Username   string `yaml:"username,omitempty"`
+	Token      string `yaml:"token,omitempty"`
+	TokenEnv   string `yaml:"token_env,omitempty"`
+	SSHKeyFile string `yaml:"ssh_key_file,omitempty"`
+}
+
+type credentialsFile struct {
+	Git []GitCredential `yaml:"git"`
+}
+
+func credentialsFilePath() (string, error) {
+	if p := os.Getenv(CredentialsFileEnvVar); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ghpc", "credentials.yaml"), nil
+}
+
+// loadGitCredentials reads the git credentials from the credentials file, a
+// missing file holds no credentials
+func loadGitCredentials() ([]GitCredential, error) {
+	p, err := credentialsFilePath()
+	if err != nil {
+		return nil, nil
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file %s: %w", p, err)
+	}
+	var cf credentialsFile
+	if err := yaml.Unmarshal(b, &cf); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file %s: %w", p, err)
+	}
+	return cf.Git, nil
+}
+
+// gitCredential returns the credential configured for host, if any
+func gitCredential(host string) (GitCredential, bool, error) {
+	creds, err := loadGitCredentials()
+	if err != nil {
+		return GitCredential{}, false, err
+	}
+	for _, c := range creds {
+		if c.Host == host {
+			return c, true, nil
+		}
+	}
+	if t := os.Getenv(GitTokenEnvVar); t != "" && slices.Contains(gitTokenHosts(), host) {
+		return GitCredential{Host: host, Token: t}, true, nil
+	}
+	return GitCredential{}, false, nil
+}
+
+// gitTokenHosts returns the hosts the token of GitTokenEnvVar is sent to
+func gitTokenHosts() []string {
+	hosts := []string{}
+	for _, h := range strings.Split(os.Getenv(GitTokenHostsEnvVar), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// authenticatedSource returns the source with credentials applied, and the
+// secret that must be redacted from messages. Sources other than git, and git
+// sources without configured credentials, are returned unchanged.
+func authenticatedSource(source string) (string, string, error) {
+	detected, err := getter.Detect(source, "", goGetterDetectors)
+	if err != nil || !strings.HasPrefix(detected, "git::") {
+		return source, "", nil
+	}
+	repo, subdir := getter.SourceDirSubdir(strings.TrimPrefix(detected, "git::"))
+	u, err := url.Parse(repo)
+	if err != nil {
+		return source, "", nil
+	}
+	cred, found, err := gitCredential(u.Hostname())
+	if err != nil || !found {
+		return source, "", err
+	}
+
+	secret := ""
+	switch u.Scheme {
+	case "https", "http":
+		token := cred.Token
+		if cred.TokenEnv != "" {
+			token = os.Getenv(cred.TokenEnv)
+		}
+		if token == "" {
+			return source, "", nil
+		}
+		username := cred.Username
+		if username == "" {
+			username = defaultGitUsername
+		}
+		u.User = url.UserPassword(username, token)
+		secret = token
+	case "ssh":
+		if cred.SSHKeyFile == "" {
+			return source, "", nil
+		}
+		key, err := os.ReadFile(cred.SSHKeyFile)
+		if err != nil {
+			return source, "", fmt.Errorf("failed to read SSH key for %s: %w", cred.Host, err)
+		}
+		q := u.Query()
+		secret = base64.StdEncoding.EncodeToString(key)
+		q.Set("sshkey", secret)
+		u.RawQuery = q.Encode()
+	default:
+		return source, "", nil
+	}
+
+	query := u.RawQuery
+	u.RawQuery = ""
+	res := "git::" + u.String()
+	if subdir != "" {
+		res += "//" + subdir
+	}
+	if query != "" {
+		res += "?" + query
+	}
+	return res, secret, nil
+}
+
+// HasCredentials checks if credentials are configured for the source
+func HasCredentials(source string) bool {
+	_, secret, err := authenticatedSource(source)
+	return err == nil && secret != ""
+}
+
+// redact removes secret from the error message
+func redact(err error, secret string) error {
+	if err == nil || secret == "" {
+		return err
+	}
+	msg := err.Error()
+	for _, s := range []string{secret, url.QueryEscape(secret), url.PathEscape(secret)} {
+		msg = strings.ReplaceAll(msg, s, "REDACTED")
+	}
+	return errors.New(msg)
+}
+
+var (
+	gitAuthMessages = []string{
+		"Authentication failed",
+		"Permission denied (publickey",
+		"could not read Username",
+		"could not read Password",
+		"terminal prompts disabled",
+		"The requested URL returned error: 401",
+		"The requested URL returned error: 403",
+	}
+	gitRefMessages = []string{
+		"did not match any file(s) known to git",
+		"couldn't find remote ref",
+		"Remote branch",
+		"not found in upstream origin",
+		"Repository not found",
+		"does not appear to be a git repository",
+		"unknown revision",
+	}
+)
+
+// classifyGitError wraps errors of git fetches with ErrGitAuth or
+// ErrGitRefNotFound, with hints on how to resolve them
+func classifyGitError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for _, m := range gitAuthMessages {
+		if strings.Contains(msg, m) {
+			return fmt.Errorf("%w: configure the SSH agent, a deploy key or a token (see %s, or %s and %s): %v",
+				ErrGitAuth, CredentialsFileEnvVar, GitTokenEnvVar, GitTokenHostsEnvVar, err)
+		}
+	}
+	for _, m := range gitRefMessages {
+		if strings.Contains(msg, m) {
+			return fmt.Errorf("%w: check the repository URL and the ?ref= of the source: %v", ErrGitRefNotFound, err)
+		}
+	}
+	return err
+}
@@ -99,6 +99,10 @@ func copyFSToTempDir(bfs BaseFS, modulePath string) (string, error) {
 
 // GetModule copies the embedded source to a provided destination (the deployment directory)
 func (r EmbeddedSourceReader) GetModule(modPath string, copyPath string) error {
+	return r.getModule(modPath, copyPath, nil)
+}
+
+func (r EmbeddedSourceReader) getModule(modPath string, copyPath string, verify verifyFunc) error {
 	if ModuleFS == nil {
 		return fmt.Errorf("embedded file system is not initialized")
 	}
@@ -113,6 +117,9 @@ func (r EmbeddedSourceReader) GetModule(modPath string, copyPath string) error {
 			modPath, modDir, err)
 		return err
 	}
+	if err := verify.check(modDir); err != nil {
+		return err
+	}
 
 	return copyFromPath(modDir, copyPath)
 }
@@ -151,7 +151,7 @@ func (s *MySuite) TestGetModule_Embedded(c *C) {
 	c.Assert(err, ErrorMatches, expectedErr)
 
 	// Invalid: Unsupported Module Source by EmbeddedSourceReader
-	badSource := "gcs::https://www.googleapis.com/storage/v1/GoogleCloudPlatform/hpc-toolkit/modules"
+	badSource := "ftp://example.com/hpc-toolkit/modules.zip"
 	err = reader.GetModule(badSource, dest)
 	expectedErr = "Source is not valid: .*"
 	c.Assert(err, ErrorMatches, expectedErr)
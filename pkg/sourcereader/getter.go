@@ -0,0 +1,153 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"context"
+	"fmt"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/offline"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-getter"
+)
+
+var goGetterDetectors = []getter.Detector{
+	new(getter.GitHubDetector),
+	new(getter.GitDetector),
+	new(getter.S3Detector),
+	new(getter.GCSDetector),
+}
+
+var goGetterGetters = map[string]getter.Getter{
+	"git":   &getter.GitGetter{Timeout: 5 * time.Minute},
+	"http":  &getter.HttpGetter{Netrc: true},
+	"https": &getter.HttpGetter{Netrc: true},
+	"s3":    &getter.S3Getter{Timeout: 5 * time.Minute},
+	"gcs":   &getter.GCSGetter{Timeout: 5 * time.Minute},
+}
+
+var goGetterDecompressors = getter.Decompressors
+
+// GoGetterSourceReader reads modules from remote sources using go-getter:
+// git repositories, http(s) archives, S3 and GCS
+type GoGetterSourceReader struct{}
+
+func getRemoteModule(srcPath string, destPath string) error {
+	src, secret, err := authenticatedSource(srcPath)
+	if err != nil {
+		return err
+	}
+	// fail instead of waiting for credentials to be typed in
+	if _, ok := os.LookupEnv("GIT_TERMINAL_PROMPT"); !ok {
+		os.Setenv("GIT_TERMINAL_PROMPT", "0")
+	}
+
+	client := getter.Client{
+		Src: src,
+		Dst: destPath,
+		Pwd: destPath,
+
+		Mode: getter.ClientModeDir,
+
+		Detectors:     goGetterDetectors,
+		Decompressors: goGetterDecompressors,
+		Getters:       goGetterGetters,
+		Ctx:           context.Background(),
+	}
+	if err := client.Get(); err != nil {
+		return classifyGitError(redact(err, secret))
+	}
+	// the remote URL recorded in the git metadata of the clone holds the
+	// credentials, modules do not need the metadata
+	if secret != "" {
+		return os.RemoveAll(filepath.Join(destPath, ".git"))
+	}
+	return nil
+}
+
+// GetModule fetches the remote source to a provided destination (the deployment directory)
+func (r GoGetterSourceReader) GetModule(modPath string, copyPath string) error {
+	return r.getModule(modPath, copyPath, nil)
+}
+
+func (r GoGetterSourceReader) getModule(modPath string, copyPath string, verify verifyFunc) error {
+	if !IsRemotePath(modPath) {
+		return fmt.Errorf("Source is not valid: %s", modPath)
+	}
+	if offline.Enabled {
+		return copyFromMirror(modPath, copyPath, verify)
+	}
+
+	// only sources whose content can not change are served from the cache,
+	// branches and tags are fetched every time
+	key := cache.Key("remote", modPath)
+	immutable := immutableSource(modPath)
+	if dir, ok := cache.SourceDir(key); ok && immutable {
+		if err := verify.check(dir); err != nil {
+			return err
+		}
+		return copyFromPath(dir, copyPath)
+	}
+	if cache.Enabled && immutable {
+		dir, err := cache.StoreSource(key, func(dst string) error {
+			if err := getRemoteModule(modPath, dst); err != nil {
+				return fmt.Errorf("failed to fetch module at %s: %v", modPath, err)
+			}
+			return verify.check(dst)
+		})
+		if err != nil {
+			return err
+		}
+		return copyFromPath(dir, copyPath)
+	}
+
+	modDir, err := ioutil.TempDir("", "remote-module-*")
+	defer os.RemoveAll(modDir)
+	writeDir := filepath.Join(modDir, "mod")
+	if err != nil {
+		return err
+	}
+
+	if err := getRemoteModule(modPath, writeDir); err != nil {
+		return fmt.Errorf("failed to fetch module at %s to tmp dir %s: %v",
+			modPath, writeDir, err)
+	}
+	if err := verify.check(writeDir); err != nil {
+		return err
+	}
+
+	return copyFromPath(writeDir, copyPath)
+}
+
+// immutableSource checks if the source is a git source whose ref is a full
+// commit SHA
+func immutableSource(source string) bool {
+	detected, err := getter.Detect(source, "", goGetterDetectors)
+	if err != nil || !strings.HasPrefix(detected, "git::") {
+		return false
+	}
+	repo, _ := getter.SourceDirSubdir(strings.TrimPrefix(detected, "git::"))
+	u, err := url.Parse(repo)
+	if err != nil {
+		return false
+	}
+	return commitExp.MatchString(u.Query().Get("ref"))
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"hpc-toolkit/pkg/offline"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestGetRemoteModule(c *C) {
+	// Setup
+	destDir := filepath.Join(testDir, "TestCopyGitRepository")
+	if err := os.Mkdir(destDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	// Success via HTTPS
+	destDirForHTTPS := filepath.Join(destDir, "https")
+	err := getRemoteModule("github.com/terraform-google-modules/terraform-google-project-factory//helpers", destDirForHTTPS)
+	c.Assert(err, IsNil)
+	fInfo, err := os.Stat(filepath.Join(destDirForHTTPS, "terraform_validate"))
+	c.Assert(err, IsNil)
+	c.Assert(fInfo.Name(), Equals, "terraform_validate")
+	c.Assert(fInfo.Size() > 0, Equals, true)
+	c.Assert(fInfo.IsDir(), Equals, false)
+
+	// Success via HTTPS (Root directory)
+	destDirForHTTPSRootDir := filepath.Join(destDir, "https-rootdir")
+	err = getRemoteModule("github.com/terraform-google-modules/terraform-google-service-accounts.git?ref=v4.1.1", destDirForHTTPSRootDir)
+	c.Assert(err, IsNil)
+	fInfo, err = os.Stat(filepath.Join(destDirForHTTPSRootDir, "main.tf"))
+	c.Assert(err, IsNil)
+	c.Assert(fInfo.Name(), Equals, "main.tf")
+	c.Assert(fInfo.Size() > 0, Equals, true)
+	c.Assert(fInfo.IsDir(), Equals, false)
+}
+
+func (s *MySuite) TestGetModule_Remote(c *C) {
+	reader := GoGetterSourceReader{}
+
+	// Invalid git repository - path does not exists
+	badGitRepo := "github.com:not/exist.git"
+	err := reader.GetModule(badGitRepo, tfKindString)
+	expectedErr := "failed to fetch module at .*"
+	c.Assert(err, ErrorMatches, expectedErr)
+
+	// Invalid: Unsupported Module Source
+	badSource := "ftp://example.com/hpc-toolkit/modules.zip"
+	err = reader.GetModule(badSource, tfKindString)
+	expectedErr = "Source is not valid: .*"
+	c.Assert(err, ErrorMatches, expectedErr)
+
+	// Archive with mismatching checksum
+	archive := filepath.Join(testDir, "TestGetModule_Remote", "module.tar.gz")
+	if err := os.MkdirAll(filepath.Dir(archive), 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(archive, []byte("not an archive"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(archive))))
+	defer srv.Close()
+	badChecksum := srv.URL + "/module.tar.gz?checksum=sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	err = reader.GetModule(badChecksum, filepath.Join(testDir, "TestGetModule_Remote", "dst"))
+	c.Assert(err, ErrorMatches, "(?s)failed to fetch module at .*Checksums did not match.*")
+}
+
+func (s *MySuite) TestGetModule_Offline(c *C) {
+	defer func() { offline.Enabled, offline.MirrorDir = false, "" }()
+	offline.Enabled, offline.MirrorDir = true, filepath.Join(testDir, "TestGetModule_Offline", "mirror")
+
+	reader := GoGetterSourceReader{}
+	source := "github.com:not/exist.git//modules/vpc?ref=v1"
+	dest := filepath.Join(testDir, "TestGetModule_Offline", "dest")
+
+	// missing from the mirror
+	err := reader.GetModule(source, dest)
+	c.Assert(err, ErrorMatches, "module .* is not in the offline mirror.*")
+
+	// copied from the mirror, without network access
+	modDir := offline.ModuleDir(source)
+	c.Assert(os.MkdirAll(modDir, 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(modDir, "main.tf"), []byte{}, 0644), IsNil)
+	c.Assert(reader.GetModule(source, dest), IsNil)
+	_, err = os.Stat(filepath.Join(dest, "main.tf"))
+	c.Assert(err, IsNil)
+
+	// commits are not resolved offline
+	res, err := Resolve(source)
+	c.Assert(err, IsNil)
+	c.Check(res.Commit, Equals, "")
+	c.Check(res.Checksum, Matches, "sha256:.*")
+}
+
+func (s *MySuite) TestImmutableSource(c *C) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	c.Check(immutableSource("github.com/org/repo//modules/vm?ref="+sha), Equals, true)
+	c.Check(immutableSource("git::https://example.com/repo.git?ref="+sha), Equals, true)
+
+	c.Check(immutableSource("github.com/org/repo//modules/vm"), Equals, false)
+	c.Check(immutableSource("github.com/org/repo//modules/vm?ref=v1.2.3"), Equals, false)
+	c.Check(immutableSource("github.com/org/repo//modules/vm?ref=main"), Equals, false)
+	c.Check(immutableSource("github.com/org/repo?ref=0123456"), Equals, false)
+	c.Check(immutableSource("https://example.com/module.tar.gz"), Equals, false)
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/errcode"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var checksumExp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// Integrity is the expected content of a module: the checksum of the module
+// files, as recorded in ghpc.lock, and/or a signature of that checksum
+// created with a private key, e.g. with "cosign sign-blob --key"
+type Integrity struct {
+	SHA256 string `yaml:"sha256,omitempty"`
+	// Signature - is the base64 encoded signature of the checksum
+	Signature string `yaml:"signature,omitempty"`
+	// PublicKey - is the PEM encoded public key, or the path to a file
+	// containing it, the signature is verified with
+	PublicKey string `yaml:"public_key,omitempty"`
+}
+
+// checksum returns the expected checksum, with the "sha256:" prefix
+func (i Integrity) checksum() string {
+	if i.SHA256 == "" || strings.HasPrefix(i.SHA256, "sha256:") {
+		return strings.ToLower(i.SHA256)
+	}
+	return "sha256:" + strings.ToLower(i.SHA256)
+}
+
+// Check ensures that the integrity settings can be verified
+func (i Integrity) Check() error {
+	if i.SHA256 == "" && i.Signature == "" {
+		return fmt.Errorf("integrity must set sha256, signature or both")
+	}
+	if i.SHA256 != "" && !checksumExp.MatchString(i.checksum()) {
+		return fmt.Errorf("integrity sha256 %q must be 64 hexadecimal characters, optionally prefixed with \"sha256:\"", i.SHA256)
+	}
+	if i.Signature == "" {
+		if i.PublicKey != "" {
+			return fmt.Errorf("integrity public_key is only used with a signature")
+		}
+		return nil
+	}
+	if _, err := base64.StdEncoding.DecodeString(i.Signature); err != nil {
+		return fmt.Errorf("integrity signature must be base64 encoded: %v", err)
+	}
+	if i.PublicKey == "" {
+		return fmt.Errorf("integrity signature requires a public_key")
+	}
+	_, err := i.publicKey()
+	return err
+}
+
+// publicKey parses the public key, read from a file unless it is PEM encoded
+func (i Integrity) publicKey() (crypto.PublicKey, error) {
+	b := []byte(i.PublicKey)
+	if !strings.HasPrefix(strings.TrimSpace(i.PublicKey), "-----BEGIN") {
+		var err error
+		if b, err = os.ReadFile(i.PublicKey); err != nil {
+			return nil, fmt.Errorf("failed to read integrity public_key: %v", err)
+		}
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("integrity public_key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid integrity public_key: %v", err)
+	}
+	return key, nil
+}
+
+// verifySignature verifies that sig is a signature of msg made with the
+// private key of key
+func verifySignature(key crypto.PublicKey, msg []byte, sig []byte) bool {
+	digest := sha256.Sum256(msg)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, msg, sig)
+	default:
+		return false
+	}
+}
+
+// Verify ensures that the module in dir has the expected checksum and that
+// the signature of its checksum is valid
+func (i Integrity) Verify(dir string) error {
+	sum, err := cache.HashDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to compute checksum of %s: %v", dir, err)
+	}
+	sum = "sha256:" + sum
+
+	if i.SHA256 != "" && sum != i.checksum() {
+		return errcode.New(errcode.IntegrityFailure,
+			fmt.Errorf("checksum mismatch: expected %s, got %s", i.checksum(), sum))
+	}
+	if i.Signature == "" {
+		return nil
+	}
+	key, err := i.publicKey()
+	if err != nil {
+		return errcode.New(errcode.ConfigError, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(i.Signature)
+	if err != nil || !verifySignature(key, []byte(sum), sig) {
+		return errcode.New(errcode.IntegrityFailure,
+			fmt.Errorf("signature verification failed for checksum %s", sum))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/errcode"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestIntegrityCheck(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	c.Assert(err, IsNil)
+	pub := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	sum := strings.Repeat("a", 64)
+
+	c.Check(Integrity{SHA256: sum}.Check(), IsNil)
+	c.Check(Integrity{SHA256: "sha256:" + sum}.Check(), IsNil)
+	c.Check(Integrity{Signature: "c2ln", PublicKey: pub}.Check(), IsNil)
+
+	c.Check(Integrity{}.Check(), ErrorMatches, ".*must set sha256, signature or both.*")
+	c.Check(Integrity{SHA256: "abc"}.Check(), ErrorMatches, ".*64 hexadecimal characters.*")
+	c.Check(Integrity{SHA256: sum, PublicKey: pub}.Check(), ErrorMatches, ".*only used with a signature.*")
+	c.Check(Integrity{Signature: "c2ln"}.Check(), ErrorMatches, ".*requires a public_key.*")
+	c.Check(Integrity{Signature: "!", PublicKey: pub}.Check(), ErrorMatches, ".*base64.*")
+	c.Check(Integrity{Signature: "c2ln", PublicKey: "-----BEGIN PUBLIC KEY-----\n"}.Check(), ErrorMatches, ".*not PEM encoded.*")
+	c.Check(Integrity{Signature: "c2ln", PublicKey: filepath.Join(testDir, "missing.pub")}.Check(), ErrorMatches, ".*failed to read.*")
+}
+
+func (s *MySuite) TestIntegrityVerify(c *C) {
+	modDir := filepath.Join(testDir, "integrity_module")
+	c.Assert(os.MkdirAll(modDir, 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(modDir, "main.tf"), []byte("# empty\n"), 0644), IsNil)
+	hash, err := cache.HashDir(modDir)
+	c.Assert(err, IsNil)
+	sum := "sha256:" + hash
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	c.Assert(err, IsNil)
+	keyFile := filepath.Join(testDir, "integrity.pub")
+	c.Assert(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644), IsNil)
+	sign := func(msg string) string {
+		digest := sha256.Sum256([]byte(msg))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		c.Assert(err, IsNil)
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	{ // checksum, with and without prefix
+		c.Check(Integrity{SHA256: sum}.Verify(modDir), IsNil)
+		c.Check(Integrity{SHA256: strings.ToUpper(hash)}.Verify(modDir), IsNil)
+		err := Integrity{SHA256: strings.Repeat("0", 64)}.Verify(modDir)
+		c.Check(err, ErrorMatches, ".*checksum mismatch.*")
+		c.Check(errcode.Of(err), Equals, errcode.IntegrityFailure)
+	}
+	{ // signature of the checksum
+		c.Check(Integrity{Signature: sign(sum), PublicKey: keyFile}.Verify(modDir), IsNil)
+		c.Check(Integrity{SHA256: sum, Signature: sign(sum), PublicKey: keyFile}.Verify(modDir), IsNil)
+		err := Integrity{Signature: sign("sha256:other"), PublicKey: keyFile}.Verify(modDir)
+		c.Check(err, ErrorMatches, ".*signature verification failed.*")
+		c.Check(errcode.Of(err), Equals, errcode.IntegrityFailure)
+	}
+	{ // modified module
+		c.Assert(os.WriteFile(filepath.Join(modDir, "extra.tf"), []byte("# extra\n"), 0644), IsNil)
+		c.Check(Integrity{SHA256: sum}.Verify(modDir), NotNil)
+		c.Check(Integrity{Signature: sign(sum), PublicKey: keyFile}.Verify(modDir), NotNil)
+	}
+}
+
+func (s *MySuite) TestGetVerifiedModule(c *C) {
+	mismatch := Integrity{SHA256: strings.Repeat("0", 64)}
+	notCopied := func(dst string) {
+		_, err := os.Stat(dst)
+		c.Check(os.IsNotExist(err), Equals, true)
+	}
+
+	{ // local module
+		modDir := filepath.Join(testDir, "verified_module")
+		c.Assert(os.MkdirAll(modDir, 0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(modDir, "main.tf"), []byte("# empty\n"), 0644), IsNil)
+		hash, err := cache.HashDir(modDir)
+		c.Assert(err, IsNil)
+
+		dst := filepath.Join(testDir, "TestGetVerifiedModule", "local")
+		err = GetVerifiedModule(modDir, dst, mismatch)
+		c.Check(errcode.Of(err), Equals, errcode.IntegrityFailure)
+		notCopied(dst)
+
+		c.Assert(GetVerifiedModule(modDir, dst, Integrity{SHA256: hash}), IsNil)
+		_, err = os.Stat(filepath.Join(dst, "main.tf"))
+		c.Check(err, IsNil)
+	}
+
+	{ // registry module, not cached unless verified
+		defer func(u string) { registryURL = u }(registryURL)
+		srv := fakeRegistry("1.0.0")
+		defer srv.Close()
+		src := VersionedSource("registry.terraform.io/ns/name/google", "1.0.0")
+
+		fetched := filepath.Join(testDir, "TestGetVerifiedModule", "fetched")
+		c.Assert(RegistrySourceReader{}.GetModule(src, fetched), IsNil)
+		hash, err := cache.HashDir(fetched)
+		c.Assert(err, IsNil)
+
+		os.Setenv(cache.DirEnvVar, c.MkDir())
+		cache.Enabled = true
+		defer func() {
+			cache.Enabled = false
+			os.Unsetenv(cache.DirEnvVar)
+		}()
+		key := cache.Key("registry", "ns/name/google", "1.0.0")
+
+		dst := filepath.Join(testDir, "TestGetVerifiedModule", "registry")
+		err = GetVerifiedModule(src, dst, mismatch)
+		c.Check(errcode.Of(err), Equals, errcode.IntegrityFailure)
+		notCopied(dst)
+		_, ok := cache.SourceDir(key)
+		c.Check(ok, Equals, false)
+
+		c.Assert(GetVerifiedModule(src, dst, Integrity{SHA256: hash}), IsNil)
+		_, ok = cache.SourceDir(key)
+		c.Check(ok, Equals, true)
+		_, err = os.Stat(filepath.Join(dst, "main.tf"))
+		c.Check(err, IsNil)
+	}
+}
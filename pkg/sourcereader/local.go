@@ -24,6 +24,10 @@ type LocalSourceReader struct{}
 
 // GetModule copies the local source to a provided destination (the deployment directory)
 func (r LocalSourceReader) GetModule(modPath string, copyPath string) error {
+	return r.getModule(modPath, copyPath, nil)
+}
+
+func (r LocalSourceReader) getModule(modPath string, copyPath string, verify verifyFunc) error {
 	if !IsLocalPath(modPath) {
 		return fmt.Errorf("Source is not valid: %s", modPath)
 	}
@@ -31,6 +35,9 @@ func (r LocalSourceReader) GetModule(modPath string, copyPath string) error {
 	if _, err := os.Stat(modPath); os.IsNotExist(err) {
 		return fmt.Errorf("Local module doesn't exist at %s", modPath)
 	}
+	if err := verify.check(modPath); err != nil {
+		return err
+	}
 
 	return copyFromPath(modPath, copyPath)
 }
@@ -120,7 +120,7 @@ func (s *MySuite) TestGetModule_Local(c *C) {
 	c.Assert(err, ErrorMatches, expectedErr)
 
 	// Invalid: Unsupported Module Source by LocalSourceReader
-	badSource := "gcs::https://www.googleapis.com/storage/v1/GoogleCloudPlatform/hpc-toolkit/modules"
+	badSource := "ftp://example.com/hpc-toolkit/modules.zip"
 	err = reader.GetModule(badSource, dest)
 	expectedErr = "Source is not valid: .*"
 	c.Assert(err, ErrorMatches, expectedErr)
@@ -0,0 +1,278 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"encoding/json"
+	"fmt"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/offline"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+const registryHost = "registry.terraform.io"
+
+// registryURL is the base URL of the modules API of the Terraform Registry
+var registryURL = "https://registry.terraform.io/v1/modules"
+
+var registryClient = &http.Client{Timeout: time.Minute}
+
+// RegistrySourceReader reads modules from the Terraform Registry. The module
+// path is a registry source, optionally followed by "?version=<constraint>"
+// (see VersionedSource); the latest version matching the constraint is read.
+type RegistrySourceReader struct{}
+
+// IsRegistryPath checks if a source path points to a module of the Terraform
+// Registry, i.e. has the form registry.terraform.io/<namespace>/<name>/<provider>
+// with an optional //<subdir>
+func IsRegistryPath(source string) bool {
+	_, _, err := parseRegistrySource(source)
+	return err == nil
+}
+
+// parseRegistrySource splits a registry source into the address of the module,
+// <namespace>/<name>/<provider>, and the subdirectory within it
+func parseRegistrySource(source string) (string, string, error) {
+	if !strings.HasPrefix(source, registryHost+"/") {
+		return "", "", fmt.Errorf("%s is not a Terraform Registry source", source)
+	}
+	addr, subdir, _ := strings.Cut(strings.TrimPrefix(source, registryHost+"/"), "//")
+	parts := strings.Split(addr, "/")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("Terraform Registry source %s must have the form %s/<namespace>/<name>/<provider>",
+			source, registryHost)
+	}
+	for _, p := range parts {
+		if p == "" || strings.ContainsAny(p, "?:@") {
+			return "", "", fmt.Errorf("Terraform Registry source %s is not valid", source)
+		}
+	}
+	return addr, subdir, nil
+}
+
+// VersionedSource returns the module path that identifies version of a
+// registry source, other sources are returned unchanged
+func VersionedSource(source string, version string) string {
+	if version == "" || !IsRegistryPath(source) {
+		return source
+	}
+	return source + "?" + url.Values{"version": {version}}.Encode()
+}
+
+// splitVersionedSource is the inverse of VersionedSource
+func splitVersionedSource(modPath string) (string, string, error) {
+	source, query, _ := strings.Cut(modPath, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid module path %s: %v", modPath, err)
+	}
+	return source, values.Get("version"), nil
+}
+
+func registryGet(u string) (*http.Response, error) {
+	resp, err := registryClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("module not found in the Terraform Registry: %s", u)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("request to the Terraform Registry failed: %s: %s", u, resp.Status)
+	}
+}
+
+// registryVersions lists the published versions of the module at addr
+func registryVersions(addr string) ([]*version.Version, error) {
+	resp, err := registryGet(fmt.Sprintf("%s/%s/versions", registryURL, addr))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Modules []struct {
+			Versions []struct {
+				Version string `json:"version"`
+			} `json:"versions"`
+		} `json:"modules"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid response from the Terraform Registry for %s: %v", addr, err)
+	}
+	versions := []*version.Version{}
+	for _, m := range body.Modules {
+		for _, v := range m.Versions {
+			if pv, err := version.NewVersion(v.Version); err == nil {
+				versions = append(versions, pv)
+			}
+		}
+	}
+	return versions, nil
+}
+
+// ExactVersion returns the version matched by a constraint that matches a
+// single version, e.g. "1.2.0" or "= 1.2.0"
+func ExactVersion(constraint string) (string, bool) {
+	v := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(constraint), "="))
+	_, err := version.NewVersion(v)
+	return v, err == nil
+}
+
+// ResolveRegistryVersion returns the latest version of the registry source
+// that matches the constraint, an empty constraint matches any version but
+// pre-releases
+func ResolveRegistryVersion(source string, constraint string) (string, error) {
+	addr, _, err := parseRegistrySource(source)
+	if err != nil {
+		return "", err
+	}
+	if offline.Enabled {
+		return "", fmt.Errorf("the version constraint %q of %s cannot be resolved offline, "+
+			"use an exact version or record the resolution in the lockfile", constraint, source)
+	}
+	cs, err := version.NewConstraint(constraint)
+	if constraint == "" {
+		cs, err = version.Constraints{}, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid version constraint %q for %s: %v", constraint, source, err)
+	}
+	versions, err := registryVersions(addr)
+	if err != nil {
+		return "", err
+	}
+
+	var best *version.Version
+	for _, v := range versions {
+		if len(cs) == 0 && v.Prerelease() != "" {
+			continue
+		}
+		if cs.Check(v) && (best == nil || v.GreaterThan(best)) {
+			best = v
+		}
+	}
+	if best == nil {
+		return "", fmt.Errorf("no version of %s matches constraint %q", source, constraint)
+	}
+	return best.String(), nil
+}
+
+// registryDownloadSource returns the go-getter source of a version of the
+// module at addr
+func registryDownloadSource(addr string, v string) (string, error) {
+	u := fmt.Sprintf("%s/%s/%s/download", registryURL, addr, v)
+	resp, err := registryGet(u)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	src := resp.Header.Get("X-Terraform-Get")
+	if src == "" {
+		return "", fmt.Errorf("the Terraform Registry did not return a location for %s version %s", addr, v)
+	}
+	// the location may be relative to the download URL
+	if strings.HasPrefix(src, "/") || strings.HasPrefix(src, "./") || strings.HasPrefix(src, "../") {
+		base, _ := url.Parse(u)
+		ref, err := url.Parse(src)
+		if err != nil {
+			return "", fmt.Errorf("invalid location %q of %s version %s: %v", src, addr, v, err)
+		}
+		src = base.ResolveReference(ref).String()
+	}
+	return src, nil
+}
+
+// GetModule downloads the version of the registry module to a provided
+// destination (the deployment directory)
+func (r RegistrySourceReader) GetModule(modPath string, copyPath string) error {
+	return r.getModule(modPath, copyPath, nil)
+}
+
+func (r RegistrySourceReader) getModule(modPath string, copyPath string, verify verifyFunc) error {
+	source, constraint, err := splitVersionedSource(modPath)
+	if err != nil {
+		return err
+	}
+	addr, subdir, err := parseRegistrySource(source)
+	if err != nil {
+		return fmt.Errorf("Source is not valid: %s", modPath)
+	}
+	v, exact := ExactVersion(constraint)
+	if !exact {
+		if v, err = ResolveRegistryVersion(source, constraint); err != nil {
+			return err
+		}
+	}
+	if offline.Enabled {
+		return copyFromMirror(VersionedSource(source, v), copyPath, verify)
+	}
+
+	key := cache.Key("registry", addr, v)
+	fetch := func(dst string) error {
+		src, err := registryDownloadSource(addr, v)
+		if err == nil {
+			err = getRemoteModule(src, dst)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch module %s version %s: %v", source, v, err)
+		}
+		return nil
+	}
+	if dir, ok := cache.SourceDir(key); ok {
+		if err := verify.check(filepath.Join(dir, subdir)); err != nil {
+			return err
+		}
+		return copyFromPath(filepath.Join(dir, subdir), copyPath)
+	}
+	if cache.Enabled {
+		dir, err := cache.StoreSource(key, func(dst string) error {
+			if err := fetch(dst); err != nil {
+				return err
+			}
+			return verify.check(filepath.Join(dst, subdir))
+		})
+		if err != nil {
+			return err
+		}
+		return copyFromPath(filepath.Join(dir, subdir), copyPath)
+	}
+
+	modDir, err := ioutil.TempDir("", "registry-module-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(modDir)
+	writeDir := filepath.Join(modDir, "mod")
+	if err := fetch(writeDir); err != nil {
+		return err
+	}
+	if err := verify.check(filepath.Join(writeDir, subdir)); err != nil {
+		return err
+	}
+	return copyFromPath(filepath.Join(writeDir, subdir), copyPath)
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"hpc-toolkit/pkg/offline"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+// moduleArchive returns a .tar.gz archive holding files
+func moduleArchive(files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// fakeRegistry serves the versions of a single module, ns/name/google, whose
+// archives hold the version in main.tf
+func fakeRegistry(versions ...string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/modules/ns/name/google/versions", func(w http.ResponseWriter, r *http.Request) {
+		vs := ""
+		for i, v := range versions {
+			if i > 0 {
+				vs += ","
+			}
+			vs += fmt.Sprintf(`{"version": %q}`, v)
+		}
+		fmt.Fprintf(w, `{"modules": [{"versions": [%s]}]}`, vs)
+	})
+	for _, v := range versions {
+		v := v
+		mux.HandleFunc(fmt.Sprintf("/v1/modules/ns/name/google/%s/download", v), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Terraform-Get", fmt.Sprintf("/archives/%s.tar.gz", v))
+			w.WriteHeader(http.StatusNoContent)
+		})
+		mux.HandleFunc(fmt.Sprintf("/archives/%s.tar.gz", v), func(w http.ResponseWriter, r *http.Request) {
+			w.Write(moduleArchive(map[string]string{
+				"main.tf":             "# " + v,
+				"modules/sub/main.tf": "# sub " + v,
+			}))
+		})
+	}
+	srv := httptest.NewServer(mux)
+	registryURL = srv.URL + "/v1/modules"
+	return srv
+}
+
+func (s *MySuite) TestIsRegistryPath(c *C) {
+	c.Check(IsRegistryPath("registry.terraform.io/ns/name/google"), Equals, true)
+	c.Check(IsRegistryPath("registry.terraform.io/ns/name/google//modules/sub"), Equals, true)
+	c.Check(IsRegistryPath("registry.terraform.io/ns/name"), Equals, false)
+	c.Check(IsRegistryPath("registry.terraform.io/ns/name/google?version=1.0.0"), Equals, false)
+	c.Check(IsRegistryPath("ns/name/google"), Equals, false)
+	c.Check(IsRegistryPath("github.com/ns/name"), Equals, false)
+}
+
+func (s *MySuite) TestVersionedSource(c *C) {
+	src := "registry.terraform.io/ns/name/google"
+	c.Check(VersionedSource(src, ""), Equals, src)
+	c.Check(VersionedSource("./modules/vpc", "1.0.0"), Equals, "./modules/vpc")
+
+	versioned := VersionedSource(src, "~> 1.0")
+	c.Check(Factory(versioned), FitsTypeOf, RegistrySourceReader{})
+	gotSrc, gotVersion, err := splitVersionedSource(versioned)
+	c.Assert(err, IsNil)
+	c.Check(gotSrc, Equals, src)
+	c.Check(gotVersion, Equals, "~> 1.0")
+}
+
+func (s *MySuite) TestExactVersion(c *C) {
+	for _, tc := range []struct {
+		constraint string
+		version    string
+		exact      bool
+	}{
+		{"1.2.0", "1.2.0", true},
+		{"= 1.2.0", "1.2.0", true},
+		{"~> 1.2", "", false},
+		{">= 1.0, < 2.0", "", false},
+		{"", "", false},
+	} {
+		v, exact := ExactVersion(tc.constraint)
+		c.Check(exact, Equals, tc.exact, Commentf("constraint %q", tc.constraint))
+		if tc.exact {
+			c.Check(v, Equals, tc.version)
+		}
+	}
+}
+
+func (s *MySuite) TestResolveRegistryVersion(c *C) {
+	defer func(u string) { registryURL = u }(registryURL)
+	srv := fakeRegistry("1.0.0", "1.2.0", "1.10.1", "2.0.0", "2.1.0-beta")
+	defer srv.Close()
+
+	src := "registry.terraform.io/ns/name/google"
+	for _, tc := range []struct {
+		constraint string
+		want       string
+	}{
+		{"", "2.0.0"},
+		{"~> 1.0", "1.10.1"},
+		{"~> 1.2.0", "1.2.0"},
+		{">= 1.0, < 1.5", "1.2.0"},
+		{"2.1.0-beta", "2.1.0-beta"},
+	} {
+		got, err := ResolveRegistryVersion(src, tc.constraint)
+		c.Assert(err, IsNil)
+		c.Check(got, Equals, tc.want, Commentf("constraint %q", tc.constraint))
+	}
+
+	_, err := ResolveRegistryVersion(src, "~> 3.0")
+	c.Check(err, ErrorMatches, "no version of .* matches constraint .*")
+	_, err = ResolveRegistryVersion(src, "not a constraint")
+	c.Check(err, ErrorMatches, "invalid version constraint .*")
+	_, err = ResolveRegistryVersion("registry.terraform.io/ns/other/google", "")
+	c.Check(err, ErrorMatches, "module not found in the Terraform Registry: .*")
+
+	defer func() { offline.Enabled = false }()
+	offline.Enabled = true
+	_, err = ResolveRegistryVersion(src, "~> 1.0")
+	c.Check(err, ErrorMatches, ".* cannot be resolved offline.*")
+}
+
+func (s *MySuite) TestGetModule_Registry(c *C) {
+	defer func(u string) { registryURL = u }(registryURL)
+	srv := fakeRegistry("1.0.0", "1.2.0", "2.0.0")
+	defer srv.Close()
+
+	reader := RegistrySourceReader{}
+	dst := filepath.Join(testDir, "TestGetModule_Registry")
+
+	src := VersionedSource("registry.terraform.io/ns/name/google", "~> 1.0")
+	c.Assert(reader.GetModule(src, filepath.Join(dst, "constraint")), IsNil)
+	b, err := os.ReadFile(filepath.Join(dst, "constraint", "main.tf"))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, "# 1.2.0")
+
+	src = VersionedSource("registry.terraform.io/ns/name/google//modules/sub", "2.0.0")
+	c.Assert(reader.GetModule(src, filepath.Join(dst, "subdir")), IsNil)
+	b, err = os.ReadFile(filepath.Join(dst, "subdir", "main.tf"))
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, "# sub 2.0.0")
+
+	err = reader.GetModule("./modules/vpc", filepath.Join(dst, "local"))
+	c.Check(err, ErrorMatches, "Source is not valid: .*")
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/offline"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-getter"
+)
+
+var (
+	commitExp      = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	shortCommitExp = regexp.MustCompile(`^[0-9a-f]{7,39}$`)
+)
+
+// Resolution identifies the content a non-local module source resolved to
+type Resolution struct {
+	// Commit is the commit of git sources the ref resolved to
+	Commit string
+	// Checksum is a hash of the names and contents of the module files
+	Checksum string
+}
+
+// Resolve fetches the module at modPath and returns the commit and checksum
+// it resolved to. Commits are not resolved offline.
+func Resolve(modPath string) (Resolution, error) {
+	tmpDir, err := ioutil.TempDir("", "resolve-*")
+	if err != nil {
+		return Resolution{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+	dir := filepath.Join(tmpDir, "module")
+	if err := Factory(modPath).GetModule(modPath, dir); err != nil {
+		return Resolution{}, err
+	}
+	sum, err := cache.HashDir(dir)
+	if err != nil {
+		return Resolution{}, err
+	}
+
+	res := Resolution{Checksum: "sha256:" + sum}
+	if IsRemotePath(modPath) && !offline.Enabled {
+		if res.Commit, err = gitCommit(modPath); err != nil {
+			return Resolution{}, err
+		}
+	}
+	return res, nil
+}
+
+// gitCommit returns the commit the ref of a git source points to, and an
+// empty string for other sources
+func gitCommit(source string) (string, error) {
+	src, secret, err := authenticatedSource(source)
+	if err != nil {
+		return "", err
+	}
+	detected, err := getter.Detect(src, "", goGetterDetectors)
+	if err != nil || !strings.HasPrefix(detected, "git::") {
+		return "", nil
+	}
+	repo, _ := getter.SourceDirSubdir(strings.TrimPrefix(detected, "git::"))
+	u, err := url.Parse(repo)
+	if err != nil {
+		return "", fmt.Errorf("invalid git source %s: %v", source, err)
+	}
+	q := u.Query()
+	ref, sshKey := q.Get("ref"), q.Get("sshkey")
+	u.RawQuery = ""
+	if commitExp.MatchString(ref) {
+		return ref, nil
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	cmd := exec.Command("git", "ls-remote", u.String(), ref, ref+"^{}")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if sshKey != "" {
+		keyFile, err := writeSSHKey(sshKey)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(keyFile)
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes", keyFile))
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("git ls-remote failed: %v: %s", err, stderr.String())
+		return "", classifyGitError(redact(err, secret))
+	}
+	return commitOfRef(string(out), ref, source)
+}
+
+// commitOfRef returns the commit of ref in the output of git ls-remote. Tags
+// take precedence over branches, as in git, and annotated tags resolve to the
+// commit they point to.
+func commitOfRef(out string, ref string, source string) (string, error) {
+	shas := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if sha, name, found := strings.Cut(line, "\t"); found {
+			shas[name] = sha
+		}
+	}
+	for _, name := range []string{"refs/tags/" + ref + "^{}", "refs/tags/" + ref, "refs/heads/" + ref, ref} {
+		if sha, ok := shas[name]; ok {
+			return sha, nil
+		}
+	}
+	if shortCommitExp.MatchString(ref) {
+		return ref, nil // an abbreviated commit
+	}
+	return "", fmt.Errorf("%w: ref %s of %s", ErrGitRefNotFound, ref, source)
+}
+
+func writeSSHKey(encoded string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid SSH key: %v", err)
+	}
+	f, err := ioutil.TempFile("", "ghpc-ssh-key-*")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		return "", err
+	}
+	if _, err := f.Write(key); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sourcereader
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+// gitRepo creates a git repository with a commit of main.tf, returning the
+// path of the repository and the commit
+func gitRepo(dir string, content string) (string, string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.tf"), []byte(content), 0644); err != nil {
+		log.Fatal(err)
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.Output()
+		if err != nil {
+			log.Fatalf("git %v: %v", args, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "-b", "main")
+	git("add", "main.tf")
+	git("commit", "-q", "-m", "module")
+	git("tag", "-a", "v1.0.0", "-m", "release")
+	return dir, git("rev-parse", "HEAD")
+}
+
+func (s *MySuite) TestResolve(c *C) {
+	repo, commit := gitRepo(filepath.Join(testDir, "TestResolve", "repo"), "# v1")
+	src := "git::file://" + repo + "?ref=v1.0.0"
+
+	res, err := Resolve(src)
+	c.Assert(err, IsNil)
+	c.Check(res.Commit, Equals, commit)
+	c.Check(res.Checksum, Matches, "sha256:[0-9a-f]{64}")
+
+	// the same content resolves to the same checksum
+	again, err := Resolve("git::file://" + repo + "?ref=main")
+	c.Assert(err, IsNil)
+	c.Check(again, DeepEquals, res)
+
+	_, err = gitCommit("git::file://" + repo + "?ref=v2.0.0")
+	c.Check(errors.Is(err, ErrGitRefNotFound), Equals, true)
+}
+
+func (s *MySuite) TestCommitOfRef(c *C) {
+	out := "1111111111111111111111111111111111111111\trefs/heads/v1\n" +
+		"2222222222222222222222222222222222222222\trefs/tags/v1\n" +
+		"3333333333333333333333333333333333333333\trefs/tags/v1^{}\n" +
+		"4444444444444444444444444444444444444444\trefs/heads/main\n" +
+		"5555555555555555555555555555555555555555\trefs/heads/feature/main\n"
+
+	for ref, want := range map[string]string{
+		"v1":      "3333333333333333333333333333333333333333",
+		"main":    "4444444444444444444444444444444444444444",
+		"abc1234": "abc1234",
+	} {
+		got, err := commitOfRef(out, ref, "src")
+		c.Assert(err, IsNil)
+		c.Check(got, Equals, want, Commentf("ref %s", ref))
+	}
+
+	_, err := commitOfRef(out, "v2", "src")
+	c.Check(errors.Is(err, ErrGitRefNotFound), Equals, true)
+}
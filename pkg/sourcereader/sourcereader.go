@@ -15,15 +15,20 @@
 package sourcereader
 
 import (
+	"fmt"
 	"hpc-toolkit/pkg/deploymentio"
+	"hpc-toolkit/pkg/offline"
 	"log"
+	"net/url"
+	"os"
 	"strings"
 )
 
 const (
 	local = iota
 	embedded
-	github
+	remote
+	registry
 )
 
 // SourceReader interface for reading modules from a source
@@ -32,10 +37,21 @@ type SourceReader interface {
 	GetModule(modPath string, copyPath string) error
 }
 
+// verifyFunc checks the content of a fetched module before it is cached or
+// copied, nil skips the check
+type verifyFunc func(dir string) error
+
+// verifyingReader is implemented by the readers that can check the content of
+// a module before it is cached or copied to its destination
+type verifyingReader interface {
+	getModule(modPath string, copyPath string, verify verifyFunc) error
+}
+
 var readers = map[int]SourceReader{
 	local:    LocalSourceReader{},
 	embedded: EmbeddedSourceReader{},
-	github:   GitSourceReader{},
+	remote:   GoGetterSourceReader{},
+	registry: RegistrySourceReader{},
 }
 
 // IsLocalPath checks if a source path is a local FS path
@@ -57,20 +73,56 @@ func IsGitPath(source string) bool {
 		strings.HasPrefix(source, "git::")
 }
 
+// prefixes of sources fetched with go-getter, forced getters use the
+// "<getter>::" form
+var remotePrefixes = []string{
+	"github.com", "git@",
+	"git::", "s3::", "gcs::",
+	"https://", "http://",
+}
+
+// IsRemotePath checks if a source path points to a remote source: a git
+// repository, an http(s) archive or an S3 or GCS bucket
+func IsRemotePath(source string) bool {
+	for _, p := range remotePrefixes {
+		if strings.HasPrefix(source, p) {
+			return true
+		}
+	}
+	// S3 and GCS URLs without a forced getter
+	return strings.Contains(source, ".amazonaws.com/") ||
+		strings.HasPrefix(source, "www.googleapis.com/storage/")
+}
+
+// HasChecksum checks if a remote source requests checksum verification with
+// the "checksum" query parameter
+func HasChecksum(source string) bool {
+	_, query, found := strings.Cut(source, "?")
+	if !found {
+		return false
+	}
+	values, err := url.ParseQuery(query)
+	return err == nil && values.Has("checksum")
+}
+
 // Factory returns a SourceReader of module path
 func Factory(modPath string) SourceReader {
-	validPrefixes := []string{
+	validPrefixes := append([]string{
 		"/", "./", "../",
 		"modules/", "community/modules/",
-		"git@", "github.com",
-	}
+		registryHost + "/",
+	}, remotePrefixes...)
+	// registry sources may be followed by the version, see VersionedSource
+	unversioned, _, _ := strings.Cut(modPath, "?")
 	switch {
 	case IsLocalPath(modPath):
 		return readers[local]
 	case IsEmbeddedPath(modPath):
 		return readers[embedded]
-	case IsGitPath(modPath):
-		return readers[github]
+	case IsRemotePath(modPath):
+		return readers[remote]
+	case IsRegistryPath(unversioned):
+		return readers[registry]
 	default:
 		log.Fatalf(
 			"Source (%s) not valid, must begin with one of: %s",
@@ -80,6 +132,46 @@ func Factory(modPath string) SourceReader {
 	return nil
 }
 
+// GetVerifiedModule copies the module to copyPath like GetModule, once its
+// content has been verified against integrity. A module that fails
+// verification is neither cached nor copied, and if copyPath did not exist it
+// is removed whenever the module can not be fully copied.
+func GetVerifiedModule(modPath string, copyPath string, integrity Integrity) error {
+	r, ok := Factory(modPath).(verifyingReader)
+	if !ok {
+		return fmt.Errorf("the integrity of module %s can not be verified", modPath)
+	}
+	_, statErr := os.Stat(copyPath)
+	if err := r.getModule(modPath, copyPath, integrity.Verify); err != nil {
+		if os.IsNotExist(statErr) {
+			os.RemoveAll(copyPath)
+		}
+		return err
+	}
+	return nil
+}
+
+// check runs verify on dir, if set
+func (verify verifyFunc) check(dir string) error {
+	if verify == nil {
+		return nil
+	}
+	return verify(dir)
+}
+
+// copyFromMirror copies the module path from the offline mirror
+func copyFromMirror(modPath string, copyPath string, verify verifyFunc) error {
+	dir := offline.ModuleDir(modPath)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("module %s is not in the offline mirror, expected it at %s; add it with ghpc vendor --vendor-dir %s",
+			modPath, dir, offline.ModulesDir())
+	}
+	if err := verify.check(dir); err != nil {
+		return err
+	}
+	return copyFromPath(dir, copyPath)
+}
+
 func copyFromPath(modPath string, copyPath string) error {
 	// currently supporting only local blueprint directory
 	deploymentio := deploymentio.GetDeploymentioLocal()
@@ -16,6 +16,7 @@ package sourcereader
 
 import (
 	"fmt"
+	"hpc-toolkit/pkg/cache"
 	"io/ioutil"
 	"log"
 	"os"
@@ -62,6 +63,8 @@ func teardown() {
 }
 
 func TestMain(m *testing.M) {
+	// do not read or pollute the disk cache of the user
+	cache.Enabled = false
 	setup()
 	createTmpModule()
 	code := m.Run()
@@ -144,11 +147,45 @@ func (s *MySuite) TestFactory(c *C) {
 
 	// GitHub modules
 	ghSrcString := Factory("github.com/modules")
-	c.Assert(reflect.TypeOf(ghSrcString), Equals, reflect.TypeOf(GitSourceReader{}))
+	c.Assert(reflect.TypeOf(ghSrcString), Equals, reflect.TypeOf(GoGetterSourceReader{}))
 
 	// Git modules
 	gitSrcString := Factory("git::https://gitlab.com/modules")
-	c.Assert(reflect.TypeOf(gitSrcString), Equals, reflect.TypeOf(GitSourceReader{}))
+	c.Assert(reflect.TypeOf(gitSrcString), Equals, reflect.TypeOf(GoGetterSourceReader{}))
+
+	// GCS modules
+	gcsSrcString := Factory("gcs::https://www.googleapis.com/storage/v1/bucket/modules.zip")
+	c.Assert(reflect.TypeOf(gcsSrcString), Equals, reflect.TypeOf(GoGetterSourceReader{}))
+}
+
+func (s *MySuite) TestIsRemotePath(c *C) {
+	for _, src := range []string{
+		"github.com/org/repo//modules/vpc?ref=v1.0.0",
+		"git@github.com:org/repo.git",
+		"git::https://gitlab.com/org/repo.git",
+		"https://example.com/modules/vpc.tar.gz",
+		"s3::https://s3.amazonaws.com/bucket/vpc.zip",
+		"bucket.s3.amazonaws.com/vpc.zip",
+		"gcs::https://www.googleapis.com/storage/v1/bucket/vpc.zip",
+		"www.googleapis.com/storage/v1/bucket/vpc.zip",
+	} {
+		c.Check(IsRemotePath(src), Equals, true, Commentf(src))
+	}
+	for _, src := range []string{
+		"modules/network/vpc",
+		"./modules/vpc",
+		"/abs/modules/vpc",
+		"ftp://example.com/vpc.zip",
+	} {
+		c.Check(IsRemotePath(src), Equals, false, Commentf(src))
+	}
+}
+
+func (s *MySuite) TestHasChecksum(c *C) {
+	c.Check(HasChecksum("https://example.com/vpc.zip?checksum=sha256:abc"), Equals, true)
+	c.Check(HasChecksum("github.com/org/repo?ref=v1&checksum=md5:abc"), Equals, true)
+	c.Check(HasChecksum("github.com/org/repo?ref=v1"), Equals, false)
+	c.Check(HasChecksum("https://example.com/vpc.zip"), Equals, false)
 }
 
 func (s *MySuite) TestCopyFromPath(c *C) {
@@ -0,0 +1,204 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tools locates the terraform and packer binaries ghpc runs, and
+// installs verified releases of them to ~/.ghpc/bin when those in PATH are
+// missing or of versions ghpc does not support
+package tools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+
+	"hpc-toolkit/pkg/offline"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hc-install/product"
+	"github.com/hashicorp/hc-install/releases"
+)
+
+// DirEnvVar overrides the default directory binaries are installed to
+const DirEnvVar = "GHPC_BIN_DIR"
+
+// AutoInstall controls whether Find installs a binary that is missing from
+// PATH or of an unsupported version; ghpc enables it unless it runs offline
+var AutoInstall = false
+
+// Tool is a binary ghpc runs
+type Tool struct {
+	Name string
+	// Constraints are the versions ghpc supports
+	Constraints string
+	// Version is the version installed when none in PATH satisfies the
+	// constraints
+	Version string
+}
+
+// Terraform runs Terraform deployment groups; its constraints are those of
+// the versions.tf files ghpc writes
+var Terraform = Tool{Name: "terraform", Constraints: ">= 1.2", Version: "1.5.7"}
+
+// Packer builds the images of Packer deployment groups
+var Packer = Tool{Name: "packer", Constraints: ">= 1.7.9, < 2.0.0", Version: "1.9.4"}
+
+// Tools returns the tools ghpc manages
+func Tools() []Tool {
+	return []Tool{Terraform, Packer}
+}
+
+// Dir returns the directory binaries are installed to
+func Dir() (string, error) {
+	if d := os.Getenv(DirEnvVar); d != "" {
+		return d, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate binaries directory: %w", err)
+	}
+	return filepath.Join(home, ".ghpc", "bin"), nil
+}
+
+func (t Tool) binaryName() string {
+	if runtime.GOOS == "windows" {
+		return t.Name + ".exe"
+	}
+	return t.Name
+}
+
+// InstalledPath returns the path Version of the tool is installed at
+func (t Tool) InstalledPath() (string, error) {
+	d, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, t.Name, t.Version, t.binaryName()), nil
+}
+
+// versionRegexp matches the version printed by "terraform version", e.g.
+// "Terraform v1.5.7", and "packer version", e.g. "Packer v1.9.4" or "1.7.9"
+var versionRegexp = regexp.MustCompile(`(?m)^(?:[A-Za-z]+ )?v?([0-9]+\.[0-9]+\.[0-9]+\S*)`)
+
+// parseVersion returns the version in the output of the version command
+func parseVersion(out string) (*version.Version, error) {
+	m := versionRegexp.FindStringSubmatch(out)
+	if m == nil {
+		return nil, fmt.Errorf("no version found in %q", out)
+	}
+	return version.NewVersion(m[1])
+}
+
+// version returns the version of the binary at path
+func (t Tool) version(path string) (*version.Version, error) {
+	out, err := exec.Command(path, "version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s version: %w", path, err)
+	}
+	return parseVersion(string(out))
+}
+
+// supports returns an error if the binary at path is not of a supported
+// version
+func (t Tool) supports(path string) error {
+	c, err := version.NewConstraint(t.Constraints)
+	if err != nil {
+		return err
+	}
+	v, err := t.version(path)
+	if err != nil {
+		return err
+	}
+	if !c.Check(v) {
+		return fmt.Errorf("%s is version %s, ghpc requires %s %s", path, v, t.Name, t.Constraints)
+	}
+	return nil
+}
+
+// Find returns the path of a binary of the tool of a supported version: the
+// one in PATH, or else the one installed to Dir, which is installed if
+// AutoInstall is enabled
+func (t Tool) Find() (string, error) {
+	path, err := exec.LookPath(t.Name)
+	if err == nil {
+		if err = t.supports(path); err == nil {
+			return path, nil
+		}
+	}
+
+	installed, ierr := t.InstalledPath()
+	if ierr != nil {
+		return "", ierr
+	}
+	if _, serr := os.Stat(installed); serr == nil {
+		return installed, nil
+	}
+	if !AutoInstall {
+		if offline.Enabled {
+			return "", fmt.Errorf("%w; %s %s is not installed offline, install it to %s", err, t.Name, t.Version, installed)
+		}
+		return "", err
+	}
+	log.Printf("%v; installing %s %s to %s", err, t.Name, t.Version, filepath.Dir(installed))
+	return t.Install()
+}
+
+// Install downloads Version of the tool from releases.hashicorp.com to Dir,
+// verifying its checksum and the signature of the checksums, and returns the
+// path of the binary
+func (t Tool) Install() (string, error) {
+	path, err := t.InstalledPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	v, err := version.NewVersion(t.Version)
+	if err != nil {
+		return "", err
+	}
+
+	// the binary is unpacked next to its final directory, so that an
+	// interrupted installation is not mistaken for an installed binary
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), t.Version+"-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	ev := &releases.ExactVersion{
+		Product: product.Product{
+			Name:       t.Name,
+			BinaryName: t.binaryName,
+		},
+		Version:    v,
+		InstallDir: tmp,
+	}
+	if _, err := ev.Install(context.Background()); err != nil {
+		return "", fmt.Errorf("failed to install %s %s: %w", t.Name, t.Version, err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", fmt.Errorf("failed to install %s %s: %w", t.Name, t.Version, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tools
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	type test struct {
+		out  string
+		want string
+	}
+	tests := []test{
+		{"Terraform v1.5.7\non linux_amd64\n", "1.5.7"},
+		{"Packer v1.9.4\n", "1.9.4"},
+		{"1.7.9\n", "1.7.9"},
+		{"Terraform v1.6.0-beta1\non darwin_arm64\n", "1.6.0-beta1"},
+	}
+	for _, tc := range tests {
+		v, err := parseVersion(tc.out)
+		if err != nil {
+			t.Errorf("%q: %v", tc.out, err)
+			continue
+		}
+		if got := v.String(); got != tc.want {
+			t.Errorf("%q: got %s, want %s", tc.out, got, tc.want)
+		}
+	}
+	if _, err := parseVersion("packer: usage"); err == nil {
+		t.Error("expected error for output without a version")
+	}
+}
+
+// fakeTool writes an executable printing the version output to dir
+func fakeTool(t *testing.T, dir string, name string, out string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\necho '"+out+"'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFind(t *testing.T) {
+	pathDir, binDir := t.TempDir(), t.TempDir()
+	t.Setenv("PATH", pathDir)
+	t.Setenv(DirEnvVar, binDir)
+	tool := Tool{Name: "terraform", Constraints: ">= 1.2", Version: "1.5.7"}
+
+	// missing
+	if _, err := tool.Find(); err == nil {
+		t.Error("expected error for missing binary")
+	}
+
+	// supported version in PATH
+	inPath := fakeTool(t, pathDir, "terraform", "Terraform v1.3.0")
+	if got, err := tool.Find(); err != nil || got != inPath {
+		t.Errorf("got %q, %v, want %q", got, err, inPath)
+	}
+
+	// unsupported version in PATH, not installed
+	fakeTool(t, pathDir, "terraform", "Terraform v1.1.9")
+	if _, err := tool.Find(); err == nil {
+		t.Error("expected error for unsupported version")
+	}
+
+	// unsupported version in PATH, installed
+	installed, err := tool.InstalledPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(installed), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fakeTool(t, filepath.Dir(installed), "terraform", "Terraform v1.5.7")
+	if got, err := tool.Find(); err != nil || got != installed {
+		t.Errorf("got %q, %v, want %q", got, err, installed)
+	}
+
+	// installing an installed version does not download it
+	if got, err := tool.Install(); err != nil || got != installed {
+		t.Errorf("got %q, %v, want %q", got, err, installed)
+	}
+}
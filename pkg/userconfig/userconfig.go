@@ -0,0 +1,173 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package userconfig reads the user-level configuration file of ghpc, which
+// sets defaults shared by all blueprints, e.g. the Terraform backend or the
+// service account to impersonate
+package userconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/validators"
+	"hpc-toolkit/pkg/vault"
+
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// PathEnvVar overrides the default location of the configuration file
+const PathEnvVar = "GHPC_CONFIG"
+
+// CredentialsEnvVar sets the application default credentials
+const CredentialsEnvVar = "GOOGLE_APPLICATION_CREDENTIALS"
+
+// Config holds the defaults of the configuration file; they apply beneath
+// the values set in blueprints, flags and environment variables
+type Config struct {
+	// TerraformBackendDefaults is used by blueprints that set no
+	// terraform_backend_defaults
+	TerraformBackendDefaults config.TerraformBackend `yaml:"terraform_backend_defaults,omitempty"`
+	// Labels are added to the labels of blueprints that do not set them
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// ValidationLevel is used if --validation-level is not set
+	ValidationLevel string `yaml:"validation_level,omitempty"`
+	// CacheDir is used if GHPC_CACHE_DIR is not set
+	CacheDir string `yaml:"cache_dir,omitempty"`
+	// Credentials is used if GOOGLE_APPLICATION_CREDENTIALS is not set
+	Credentials string `yaml:"credentials,omitempty"`
+	// ImpersonateServiceAccount is used if
+	// GOOGLE_IMPERSONATE_SERVICE_ACCOUNT is not set
+	ImpersonateServiceAccount string `yaml:"impersonate_service_account,omitempty"`
+	// Vault reads credentials and secrets from HashiCorp Vault, unless the
+	// environment variables they are passed in are set
+	Vault vault.Config `yaml:"vault,omitempty"`
+}
+
+// Path returns the location of the configuration file,
+// ~/.config/ghpc/config.yaml on Linux unless GHPC_CONFIG or
+// XDG_CONFIG_HOME is set
+func Path() (string, error) {
+	if p := os.Getenv(PathEnvVar); p != "" {
+		return p, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the ghpc configuration file: %w", err)
+	}
+	return filepath.Join(dir, "ghpc", "config.yaml"), nil
+}
+
+// Load reads the configuration file; it is empty if the file does not exist
+func Load() (Config, error) {
+	p, err := Path()
+	if err != nil {
+		return Config{}, err
+	}
+	return read(p)
+}
+
+func read(path string) (Config, error) {
+	var c Config
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to read the ghpc configuration file %s: %w", path, err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return c, fmt.Errorf("failed to parse the ghpc configuration file %s: %w", path, err)
+	}
+	if c.ValidationLevel != "" {
+		if _, err := config.ParseValidationLevel(c.ValidationLevel); err != nil {
+			return c, fmt.Errorf("ghpc configuration file %s: validation_level: %w", path, err)
+		}
+	}
+	c.CacheDir, c.Credentials = expandHome(c.CacheDir), expandHome(c.Credentials)
+	return c, nil
+}
+
+// expandHome replaces a leading ~ of a path with the home directory
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, strings.TrimPrefix(p, "~"))
+}
+
+// ApplyEnv sets the environment variables of the cache directory, the
+// credentials and the impersonated service account that are not already set,
+// so that they are used by ghpc, Terraform and Packer
+func (c Config) ApplyEnv() error {
+	for name, v := range map[string]string{
+		cache.DirEnvVar:              c.CacheDir,
+		CredentialsEnvVar:            c.Credentials,
+		validators.ImpersonateEnvVar: c.ImpersonateServiceAccount,
+	} {
+		if v == "" || os.Getenv(name) != "" {
+			continue
+		}
+		if err := os.Setenv(name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyBlueprint sets the Terraform backend of the blueprint, if it sets
+// none, and adds the labels it does not set
+func (c Config) ApplyBlueprint(bp *config.Blueprint) error {
+	if bp.TerraformBackendDefaults.Type == "" {
+		bp.TerraformBackendDefaults = c.TerraformBackendDefaults
+	}
+	if len(c.Labels) == 0 {
+		return nil
+	}
+	labels := map[string]cty.Value{}
+	if bp.Vars.Has("labels") {
+		v := bp.Vars.Get("labels")
+		if !v.Type().IsObjectType() && !v.Type().IsMapType() {
+			return fmt.Errorf("labels of the ghpc configuration file cannot be added to vars.labels of type %s",
+				v.Type().FriendlyName())
+		}
+		if !v.IsNull() {
+			for k, l := range v.AsValueMap() {
+				labels[k] = l
+			}
+		}
+	}
+	for k, v := range c.Labels {
+		if _, ok := labels[k]; !ok {
+			labels[k] = cty.StringVal(v)
+		}
+	}
+	bp.Vars.Set("labels", cty.ObjectVal(labels))
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package userconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hpc-toolkit/pkg/cache"
+	"hpc-toolkit/pkg/config"
+	"hpc-toolkit/pkg/validators"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPath(t *testing.T) {
+	t.Setenv(PathEnvVar, "/etc/ghpc.yaml")
+	if got, _ := Path(); got != "/etc/ghpc.yaml" {
+		t.Errorf("Path() = %q, want %s", got, "/etc/ghpc.yaml")
+	}
+	t.Setenv(PathEnvVar, "")
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	if got, _ := Path(); got != "/xdg/ghpc/config.yaml" {
+		t.Errorf("Path() = %q, want %s", got, "/xdg/ghpc/config.yaml")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Setenv(PathEnvVar, filepath.Join(t.TempDir(), "missing.yaml"))
+	if c, err := Load(); err != nil || c.TerraformBackendDefaults.Type != "" || c.Labels != nil {
+		t.Errorf("Load() of a missing file = %#v, %v; want empty", c, err)
+	}
+
+	t.Setenv("HOME", "/home/me")
+	t.Setenv(PathEnvVar, writeConfig(t, `
+terraform_backend_defaults:
+  type: gcs
+  configuration:
+    bucket: my-state
+labels:
+  team: hpc
+validation_level: ERROR
+cache_dir: ~/ghpc-cache
+impersonate_service_account: deployer@my-project.iam.gserviceaccount.com
+vault:
+  address: https://vault.example.com:8200
+  gcp_token_path: gcp/roleset/deployer/token
+`))
+	c, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TerraformBackendDefaults.Type != "gcs" || c.TerraformBackendDefaults.Configuration.Get("bucket") != cty.StringVal("my-state") {
+		t.Errorf("unexpected backend %#v", c.TerraformBackendDefaults)
+	}
+	if diff := cmp.Diff(map[string]string{"team": "hpc"}, c.Labels); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if c.ValidationLevel != "ERROR" || c.CacheDir != "/home/me/ghpc-cache" ||
+		c.ImpersonateServiceAccount != "deployer@my-project.iam.gserviceaccount.com" {
+		t.Errorf("unexpected config %#v", c)
+	}
+	if !c.Vault.Enabled() || c.Vault.Address != "https://vault.example.com:8200" {
+		t.Errorf("unexpected vault config %#v", c.Vault)
+	}
+
+	for _, content := range []string{"validation_level: LOUD\n", "unknown: 1\n", "labels: [a]\n"} {
+		t.Setenv(PathEnvVar, writeConfig(t, content))
+		if _, err := Load(); err == nil {
+			t.Errorf("Load() of %q: expected an error", content)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv(cache.DirEnvVar, "/set/by/user")
+	t.Setenv(CredentialsEnvVar, "")
+	t.Setenv(validators.ImpersonateEnvVar, "")
+	c := Config{CacheDir: "/from/config", ImpersonateServiceAccount: "sa@p.iam.gserviceaccount.com"}
+	if err := c.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv(cache.DirEnvVar); got != "/set/by/user" {
+		t.Errorf("%s = %q, the environment must take precedence", cache.DirEnvVar, got)
+	}
+	if got := os.Getenv(validators.ImpersonateEnvVar); got != c.ImpersonateServiceAccount {
+		t.Errorf("%s = %q, want %q", validators.ImpersonateEnvVar, got, c.ImpersonateServiceAccount)
+	}
+	if got := os.Getenv(CredentialsEnvVar); got != "" {
+		t.Errorf("%s = %q, want unset", CredentialsEnvVar, got)
+	}
+}
+
+func TestApplyBlueprint(t *testing.T) {
+	c := Config{
+		TerraformBackendDefaults: config.TerraformBackend{Type: "gcs"},
+		Labels:                   map[string]string{"team": "hpc", "env": "dev"},
+	}
+
+	bp := config.Blueprint{}
+	bp.Vars.Set("labels", cty.ObjectVal(map[string]cty.Value{"env": cty.StringVal("prod")}))
+	bp.TerraformBackendDefaults = config.TerraformBackend{Type: "s3"}
+	if err := c.ApplyBlueprint(&bp); err != nil {
+		t.Fatal(err)
+	}
+	if bp.TerraformBackendDefaults.Type != "s3" {
+		t.Errorf("backend of the blueprint must take precedence, got %q", bp.TerraformBackendDefaults.Type)
+	}
+	want := cty.ObjectVal(map[string]cty.Value{"env": cty.StringVal("prod"), "team": cty.StringVal("hpc")})
+	if got := bp.Vars.Get("labels"); !got.RawEquals(want) {
+		t.Errorf("labels = %#v, want %#v", got, want)
+	}
+
+	bp = config.Blueprint{}
+	if err := c.ApplyBlueprint(&bp); err != nil {
+		t.Fatal(err)
+	}
+	if bp.TerraformBackendDefaults.Type != "gcs" {
+		t.Errorf("backend = %q, want gcs", bp.TerraformBackendDefaults.Type)
+	}
+	want = cty.ObjectVal(map[string]cty.Value{"env": cty.StringVal("dev"), "team": cty.StringVal("hpc")})
+	if got := bp.Vars.Get("labels"); !got.RawEquals(want) {
+		t.Errorf("labels = %#v, want %#v", got, want)
+	}
+
+	bp = config.Blueprint{}
+	bp.Vars.Set("labels", cty.StringVal("team=hpc"))
+	if err := c.ApplyBlueprint(&bp); err == nil {
+		t.Error("expected an error for labels that are not a map")
+	}
+}
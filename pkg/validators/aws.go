@@ -0,0 +1,59 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const awsCredentialsError = "AWS credentials are not configured or are invalid, configure them following https://docs.aws.amazon.com/cli/latest/userguide/cli-chap-configure.html"
+const awsRegionError = "region %s is not an AWS region"
+const awsZoneInRegionError = "availability zone %s is not in AWS region %s, the zones of the region are %s"
+
+// TestAwsCredentials errors if the AWS CLI has no valid credentials
+func TestAwsCredentials() error {
+	if _, err := runCLI("aws", "sts", "get-caller-identity"); err != nil {
+		return fmt.Errorf("%s: %v", awsCredentialsError, err)
+	}
+	return nil
+}
+
+// TestAwsRegionExists errors if region is not an AWS region
+func TestAwsRegionExists(region string) error {
+	regions, err := cliNames("aws", "ec2", "describe-regions", "--all-regions", "--query", "Regions[].RegionName")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(regions, region) {
+		return fmt.Errorf(awsRegionError, region)
+	}
+	return nil
+}
+
+// TestAwsZoneInRegion errors if zone is not an availability zone of region
+func TestAwsZoneInRegion(zone string, region string) error {
+	zones, err := cliNames("aws", "ec2", "describe-availability-zones", "--region", region,
+		"--all-availability-zones", "--query", "AvailabilityZones[].ZoneName")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(zones, zone) {
+		return fmt.Errorf(awsZoneInRegionError, zone, region, strings.Join(zones, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const azureSubscriptionError = "subscription %s does not exist or your Azure credentials cannot access it, sign in with \"az login\""
+const azureLocationError = "location %s is not available in subscription %s, the locations of the subscription are %s"
+
+// TestAzureSubscriptionExists errors if the Azure CLI cannot access the
+// subscription
+func TestAzureSubscriptionExists(subscriptionID string) error {
+	if _, err := runCLI("az", "account", "show", "--subscription", subscriptionID); err != nil {
+		return fmt.Errorf("%s: %v", fmt.Sprintf(azureSubscriptionError, subscriptionID), err)
+	}
+	return nil
+}
+
+// TestAzureLocationExists errors if location is not a location of the
+// subscription
+func TestAzureLocationExists(subscriptionID string, location string) error {
+	locations, err := cliNames("az", "account", "list-locations", "--subscription", subscriptionID, "--query", "[].name")
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(locations, location) {
+		return fmt.Errorf(azureLocationError, location, subscriptionID, strings.Join(locations, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"fmt"
+	"log"
+
+	"hpc-toolkit/pkg/cost"
+)
+
+// TestBudget fails if the estimated monthly cost of the resources, priced
+// with the pricer that newPricer returns, exceeds maxBudget. newPricer is only
+// called if there are resources to price.
+func TestBudget(maxBudget float64, resources []cost.Resource, newPricer func() (cost.Pricer, error)) error {
+	if len(resources) == 0 {
+		return nil
+	}
+	p, err := newPricer()
+	if err != nil {
+		return handleClientError(err)
+	}
+	return checkBudget(cost.Price(resources, p), maxBudget)
+}
+
+func checkBudget(e cost.Estimate, maxBudget float64) error {
+	for _, i := range e.Unpriced() {
+		log.Printf("warning: %s %s of module %s is not included in the estimated cost: %v", i.Type, i.Kind, i.Module, i.Err)
+	}
+	if e.Monthly("") <= maxBudget {
+		return nil
+	}
+	groups := []string{}
+	for _, i := range e.Items {
+		if len(groups) == 0 || groups[len(groups)-1] != i.Group {
+			groups = append(groups, i.Group)
+		}
+	}
+	for _, g := range groups {
+		log.Printf("deployment group %s is estimated to cost $%.2f per month", g, e.Monthly(g))
+	}
+	return fmt.Errorf("the estimated monthly cost of the deployment, $%.2f, exceeds max_budget of $%.2f; "+
+		"reduce the number or size of instances, disks and file systems, or raise max_budget",
+		e.Monthly(""), maxBudget)
+}
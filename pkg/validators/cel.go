@@ -0,0 +1,212 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"golang.org/x/exp/slices"
+)
+
+// Expressions of validators declared in blueprints are in the Common
+// Expression Language (CEL, https://github.com/google/cel-spec). "vars" and
+// "modules", and "value" in the rules of deployment variables, are variables
+// of dynamic type.
+//
+// Values of the activations are nil, bool, int64, float64, string,
+// []interface{}, map[string]interface{} and Unknown, and so are the results.
+
+// Unknown is a value that is only known after deployment, such as a module
+// setting set to an output of another module. It describes the value; using it
+// in an expression is an error.
+type Unknown string
+
+// Expression is a compiled CEL expression
+type Expression struct {
+	src string
+	prg cel.Program
+}
+
+// String returns the source of the expression
+func (e Expression) String() string {
+	return e.src
+}
+
+// CompileExpression parses and checks a CEL expression
+func CompileExpression(src string) (Expression, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("vars", cel.DynType),
+		cel.Variable("modules", cel.DynType),
+		cel.Variable("value", cel.DynType),
+		cel.CrossTypeNumericComparisons(true),
+	)
+	if err != nil {
+		return Expression{}, err
+	}
+	ast, iss := env.Compile(src)
+	if iss.Err() != nil {
+		return Expression{}, issuesError(iss)
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return Expression{}, err
+	}
+	return Expression{src: src, prg: prg}, nil
+}
+
+// issuesError returns the errors of compiling an expression on a single line,
+// without the excerpts of the source cel-go adds
+func issuesError(iss *cel.Issues) error {
+	msgs := []string{}
+	for _, e := range iss.Errors() {
+		msgs = append(msgs, fmt.Sprintf("%s at position %d", e.Message, e.Location.Column()))
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// Eval evaluates the expression with the variables of the activation. It
+// fails if the result depends on an Unknown value.
+func (e Expression) Eval(activation map[string]interface{}) (interface{}, error) {
+	c := converter{}
+	vars := map[string]interface{}{}
+	for k, v := range activation {
+		vars[k] = c.celValue(v)
+	}
+	out, _, err := e.prg.Eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return nativeValue(out)
+}
+
+// converter converts the values of an activation to CEL values, each Unknown
+// to a CEL unknown whose attribute is its description
+type converter struct {
+	unknowns int64
+}
+
+func (c *converter) celValue(v interface{}) ref.Val {
+	switch v := v.(type) {
+	case Unknown:
+		c.unknowns++
+		return types.NewUnknown(c.unknowns, types.NewAttributeTrail(string(v)))
+	case []interface{}:
+		elems := make([]ref.Val, len(v))
+		for i, e := range v {
+			elems[i] = c.celValue(e)
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, elems)
+	case map[string]interface{}:
+		m := make(map[ref.Val]ref.Val, len(v))
+		for k, e := range v {
+			m[types.String(k)] = c.celValue(e)
+		}
+		return types.NewRefValMap(types.DefaultTypeAdapter, m)
+	default:
+		return types.DefaultTypeAdapter.NativeToValue(v)
+	}
+}
+
+// unknownError describes the first unknown value of u
+func unknownError(u *types.Unknown) error {
+	what := []string{}
+	for _, id := range u.IDs() {
+		trails, _ := u.GetAttributeTrails(id)
+		for _, t := range trails {
+			what = append(what, t.Variable())
+		}
+	}
+	if len(what) == 0 {
+		return errors.New("the value is only known after deployment")
+	}
+	slices.Sort(what)
+	return fmt.Errorf("%s is only known after deployment", what[0])
+}
+
+// nativeValue converts a result of an expression to the values of
+// activations
+func nativeValue(v ref.Val) (interface{}, error) {
+	switch v := v.(type) {
+	case *types.Unknown:
+		return nil, unknownError(v)
+	case types.Null:
+		return nil, nil
+	case types.Bool:
+		return bool(v), nil
+	case types.Int:
+		return int64(v), nil
+	case types.Uint:
+		return float64(v), nil
+	case types.Double:
+		return float64(v), nil
+	case types.String:
+		return string(v), nil
+	case traits.Lister:
+		res := []interface{}{}
+		for it := v.Iterator(); it.HasNext() == types.True; {
+			e, err := nativeValue(it.Next())
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, e)
+		}
+		return res, nil
+	case traits.Mapper:
+		res := map[string]interface{}{}
+		for it := v.Iterator(); it.HasNext() == types.True; {
+			k := it.Next()
+			ks, ok := k.(types.String)
+			if !ok {
+				return nil, fmt.Errorf("unsupported key of type %s in result", k.Type().TypeName())
+			}
+			e, err := nativeValue(v.Get(k))
+			if err != nil {
+				return nil, err
+			}
+			res[string(ks)] = e
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unsupported result of type %s", v.Type().TypeName())
+	}
+}
+
+// typeName returns the CEL type name of a value of an activation or result
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var activation = map[string]interface{}{
+	"vars": map[string]interface{}{
+		"region": "us-central1",
+		"zone":   "us-central1-a",
+		"nodes":  int64(4),
+		"ratio":  0.5,
+		"labels": map[string]interface{}{"team": "hpc"},
+	},
+	"modules": map[string]interface{}{
+		"compute": map[string]interface{}{
+			"settings": map[string]interface{}{
+				"machine_type": "c2-standard-60",
+				"subnetwork":   Unknown("setting subnetwork of module compute"),
+			},
+		},
+		"login": map[string]interface{}{
+			"settings": map[string]interface{}{"machine_type": "n2-standard-4", "public_ip": true},
+		},
+	},
+}
+
+func TestEval(t *testing.T) {
+	tests := map[string]interface{}{
+		`vars.zone.startsWith(vars.region)`:                   true,
+		`vars.zone.endsWith("-b")`:                            false,
+		`vars.zone.contains("central")`:                       true,
+		`vars.zone.matches("^[a-z]+-[a-z]+[0-9]-[a-z]$")`:     true,
+		`matches(vars.region, "east")`:                        false,
+		`size(vars.zone) == 13 && vars.zone.size() == 13`:     true,
+		`vars["nodes"] * 2 + 1`:                               int64(9),
+		`vars.nodes / 3`:                                      int64(1),
+		`vars.nodes % 3`:                                      int64(1),
+		`-vars.nodes`:                                         int64(-4),
+		`vars.ratio * 2.0`:                                    1.0,
+		`double(vars.nodes) * vars.ratio`:                     2.0,
+		`int(vars.ratio * 10.0)`:                              int64(5),
+		`string(vars.nodes) + "x"`:                            "4x",
+		`vars.nodes == 4.0`:                                   true,
+		`vars.nodes > 3 && vars.ratio < 1`:                    true,
+		`"a" < "b"`:                                           true,
+		`!(vars.nodes >= 5)`:                                  true,
+		`vars.nodes > 10 ? "big" : "small"`:                   "small",
+		`"team" in vars.labels`:                               true,
+		`vars.labels.team in ["hpc", "ml"]`:                   true,
+		`has(vars.labels.team) && !has(vars.labels.owner)`:    true,
+		`[1, 2] + [3]`:                                        []interface{}{int64(1), int64(2), int64(3)},
+		`{"a": 1}.a`:                                          int64(1),
+		`[1, 2, 3].all(x, x > 0)`:                             true,
+		`[1, 2, 3].exists(x, x > 2)`:                          true,
+		`[1, 2, 3].exists_one(x, x > 1)`:                      false,
+		`[1, 2, 3].filter(x, x % 2 == 1)`:                     []interface{}{int64(1), int64(3)},
+		`[1, 2, 3].map(x, x * x)`:                             []interface{}{int64(1), int64(4), int64(9)},
+		`[1, 2, 3].map(x, x > 1, x * 10)`:                     []interface{}{int64(20), int64(30)},
+		`modules.map(m, m).size() == 2 && "login" in modules`: true,
+		`modules.all(m, modules[m].settings.machine_type.startsWith("c2") || m == "login")`:      true,
+		`modules.exists(m, has(modules[m].settings.public_ip) && modules[m].settings.public_ip)`: true,
+		// the result is decided by the left operand, whatever the right one
+		`true || modules.compute.settings.subnetwork == ""`:      true,
+		`modules.compute.settings.subnetwork == "" || true`:      true,
+		`has(modules.compute.settings.subnetwork)`:               true,
+		`r"\d" == "\\d" && 'it\'s' == "it's"`:                    true,
+		`0x10 == 16 && 1e2 == 100.0`:                             true,
+		`null == null && [1] != [2] && {"a": [1]} == {"a": [1]}`: true,
+		`-9223372036854775808 < 0`:                               true,
+		`{"a": modules.login.settings.machine_type}`:             map[string]interface{}{"a": "n2-standard-4"},
+	}
+	for src, want := range tests {
+		t.Run(src, func(t *testing.T) {
+			e, err := CompileExpression(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := e.Eval(activation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := map[string]string{
+		`vars.missing`:                              "no such key: missing",
+		`vars.nodes + vars.ratio`:                   "no such overload",
+		`vars.nodes / 0`:                            "division by zero",
+		`vars.zone.startsWith(vars.nodes)`:          "no such overload",
+		`modules.compute.settings.subnetwork == ""`: "setting subnetwork of module compute is only known after deployment",
+		`modules.compute.settings`:                  "setting subnetwork of module compute is only known after deployment",
+		`[1][2]`:                                    "index out of bounds",
+		`vars.nodes ? 1 : 2`:                        "no such overload",
+		`vars.zone.matches("[")`:                    "missing closing ]",
+		`9223372036854775807 + 1`:                   "overflow",
+		`9223372036854775807 + vars.nodes`:          "overflow",
+		`vars.nodes * 9223372036854775807`:          "overflow",
+	}
+	for src, want := range tests {
+		t.Run(src, func(t *testing.T) {
+			e, err := CompileExpression(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.Eval(activation); err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("got error %v, want an error containing %q", err, want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := map[string]string{
+		`vars.zone ==`:             "Syntax error: mismatched input '<EOF>'",
+		`(1 + 2`:                   "Syntax error: missing ')'",
+		`"unterminated`:            "token recognition error",
+		`undefined`:                "undeclared reference to 'undefined'",
+		`vars.zone.lower()`:        "undeclared reference to 'lower'",
+		`size(1, 2)`:               "found no matching overload for 'size'",
+		`"a".startsWith(1)`:        "found no matching overload for 'startsWith'",
+		`has(vars)`:                "invalid argument to has() macro",
+		`[1].all(1, true)`:         "argument must be a simple name",
+		`vars.zone # comment`:      "token recognition error at: '#'",
+		`vars.zone vars.region`:    "Syntax error: mismatched input 'vars'",
+		`9223372036854775808 == 0`: "invalid int literal",
+	}
+	for src, want := range tests {
+		t.Run(src, func(t *testing.T) {
+			if _, err := CompileExpression(src); err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("got error %v, want an error containing %q", err, want)
+			}
+		})
+	}
+}
+
+func TestTestExpression(t *testing.T) {
+	compile := func(src string) Expression {
+		e, err := CompileExpression(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	if err := TestExpression(compile(`vars.zone.startsWith(vars.region)`), activation, ""); err != nil {
+		t.Error(err)
+	}
+	if err := TestExpression(compile(`vars.nodes > 8`), activation, "too few nodes"); err == nil {
+		t.Error("want an error for a false expression")
+	}
+	if err := TestExpression(compile(`vars.nodes`), activation, ""); err == nil || !strings.Contains(err.Error(), "must evaluate to a bool") {
+		t.Errorf("got %v, want an error for a non-bool expression", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// runCLI runs the command line tool of a cloud, such as aws or az, with args
+// and returns its JSON output
+var runCLI = func(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, append(args, "--output", "json")...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, fmt.Errorf("%s must be in your PATH to validate the blueprint", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %v\n%s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// cliNames returns the names a command line tool prints as a JSON list
+func cliNames(name string, args ...string) ([]string, error) {
+	out, err := runCLI(name, args...)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	if err := json.Unmarshal(out, &names); err != nil {
+		return nil, fmt.Errorf("failed to parse the output of %s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return names, nil
+}
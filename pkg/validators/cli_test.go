@@ -0,0 +1,74 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// stubCLI replaces the command line tools of clouds with the outputs of
+// their commands, e.g. "aws ec2 describe-regions"; other commands fail
+func stubCLI(t *testing.T, outputs map[string]string) {
+	t.Helper()
+	prev := runCLI
+	t.Cleanup(func() { runCLI = prev })
+	runCLI = func(name string, args ...string) ([]byte, error) {
+		if out, ok := outputs[strings.Join(append([]string{name}, args[:2]...), " ")]; ok {
+			return []byte(out), nil
+		}
+		return nil, errors.New("Unable to locate credentials")
+	}
+}
+
+func TestAwsValidators(t *testing.T) {
+	stubCLI(t, map[string]string{
+		"aws ec2 describe-regions":            `["us-east-1", "eu-west-1"]`,
+		"aws ec2 describe-availability-zones": `["us-east-1a", "us-east-1b"]`,
+	})
+	if err := TestAwsCredentials(); err == nil || !strings.Contains(err.Error(), "AWS credentials") {
+		t.Errorf("got %v, want an error for missing credentials", err)
+	}
+	if err := TestAwsRegionExists("us-east-1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := TestAwsRegionExists("us-central1"); err == nil {
+		t.Error("expected an error for an unknown region")
+	}
+	if err := TestAwsZoneInRegion("us-east-1b", "us-east-1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := TestAwsZoneInRegion("eu-west-1a", "us-east-1")
+	if err == nil || !strings.Contains(err.Error(), "us-east-1a, us-east-1b") {
+		t.Errorf("got %v, want an error listing the zones of the region", err)
+	}
+}
+
+func TestAzureValidators(t *testing.T) {
+	stubCLI(t, map[string]string{
+		"az account list-locations": `["eastus", "westeurope"]`,
+	})
+	if err := TestAzureSubscriptionExists("0000"); err == nil || !strings.Contains(err.Error(), "az login") {
+		t.Errorf("got %v, want an error for an inaccessible subscription", err)
+	}
+	if err := TestAzureLocationExists("0000", "westeurope"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := TestAzureLocationExists("0000", "us-east-1")
+	if err == nil || !strings.Contains(err.Error(), "eastus, westeurope") {
+		t.Errorf("got %v, want an error listing the locations", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Severities of the diagnostics of validator plugins
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// PluginInput is the JSON document written to the standard input of
+// validator plugins
+type PluginInput struct {
+	// Validator is the name of the validator in the blueprint
+	Validator string `json:"validator"`
+	// Inputs are the evaluated inputs of the validator
+	Inputs map[string]string `json:"inputs"`
+	// Blueprint is the expanded blueprint, as written by "ghpc expand"
+	Blueprint json.RawMessage `json:"blueprint"`
+}
+
+// Diagnostic is a finding of a validator plugin
+type Diagnostic struct {
+	// Severity is "error", the default, or "warning"
+	Severity string `json:"severity,omitempty"`
+	Message  string `json:"message"`
+	// Module is the ID of the module the diagnostic is about, if any
+	Module string `json:"module,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	if d.Module != "" {
+		return fmt.Sprintf("module %s: %s", d.Module, d.Message)
+	}
+	return d.Message
+}
+
+// PluginOutput is the JSON document validator plugins write to their
+// standard output
+type PluginOutput struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// RunPlugin runs the command of a validator plugin with input on its standard
+// input and returns the diagnostics it writes to its standard output
+func RunPlugin(command []string, input PluginInput) ([]Diagnostic, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("validator %s: command must be set", input.Validator)
+	}
+	in, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, runErr := cmd.Output()
+	if errors.Is(runErr, exec.ErrNotFound) {
+		return nil, fmt.Errorf("validator %s: command %s not found", input.Validator, command[0])
+	}
+
+	var po PluginOutput
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &po); err != nil {
+			return nil, fmt.Errorf("validator %s: failed to parse the diagnostics of %s: %v", input.Validator, command[0], err)
+		}
+	}
+	for i, d := range po.Diagnostics {
+		switch d.Severity {
+		case "":
+			po.Diagnostics[i].Severity = SeverityError
+		case SeverityError, SeverityWarning:
+		default:
+			return nil, fmt.Errorf("validator %s: diagnostic %q has invalid severity %q (%q, %q)",
+				input.Validator, d.Message, d.Severity, SeverityError, SeverityWarning)
+		}
+	}
+	if runErr != nil {
+		// diagnostics explain the failure, if there are any
+		if len(po.Diagnostics) == 0 {
+			return nil, fmt.Errorf("validator %s: %s failed: %v\n%s", input.Validator, command[0], runErr,
+				strings.TrimSpace(stderr.String()))
+		}
+		if !hasErrors(po.Diagnostics) {
+			po.Diagnostics = append(po.Diagnostics, Diagnostic{
+				Severity: SeverityError, Message: fmt.Sprintf("%s failed: %v", command[0], runErr)})
+		}
+	}
+	return po.Diagnostics, nil
+}
+
+func hasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// TestPlugin prints the diagnostics of a validator plugin and fails if any
+// of them is an error
+func TestPlugin(validator string, diags []Diagnostic) error {
+	for _, d := range diags {
+		log.Printf("%s: %s", d.Severity, d)
+	}
+	if hasErrors(diags) {
+		return fmt.Errorf("validator %s reported one or more errors", validator)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunPlugin(t *testing.T) {
+	type test struct {
+		script string
+		want   []Diagnostic
+		err    string
+	}
+	tests := map[string]test{
+		"no output":      {"cat > /dev/null", nil, ""},
+		"no diagnostics": {`echo '{"diagnostics": []}'`, []Diagnostic{}, ""},
+		"diagnostics": {`echo '{"diagnostics": [{"message": "e", "module": "m"}, {"severity": "warning", "message": "w"}]}'`,
+			[]Diagnostic{{SeverityError, "e", "m"}, {SeverityWarning, "w", ""}}, ""},
+		"reads input": {`grep -q '"validator":"naming"' && echo '{"diagnostics": [{"message": "read"}]}'`,
+			[]Diagnostic{{SeverityError, "read", ""}}, ""},
+		"failed with warnings": {`echo '{"diagnostics": [{"severity": "warning", "message": "w"}]}'; exit 3`,
+			[]Diagnostic{{SeverityWarning, "w", ""}, {SeverityError, "sh failed: exit status 3", ""}}, ""},
+		"failed":           {"echo oops >&2; exit 1", nil, "sh failed: exit status 1\noops"},
+		"invalid output":   {"echo oops", nil, "failed to parse the diagnostics"},
+		"invalid severity": {`echo '{"diagnostics": [{"severity": "fatal", "message": "f"}]}'`, nil, "invalid severity"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := RunPlugin([]string{"sh", "-c", tc.script}, PluginInput{Validator: "naming"})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want an error containing %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunPluginNotFound(t *testing.T) {
+	_, err := RunPlugin([]string{"ghpc-no-such-validator"}, PluginInput{Validator: "naming"})
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got error %v, want a command not found error", err)
+	}
+}
+
+func TestTestPlugin(t *testing.T) {
+	if err := TestPlugin("naming", []Diagnostic{{SeverityWarning, "w", ""}}); err != nil {
+		t.Errorf("warnings must not fail, got %v", err)
+	}
+	if err := TestPlugin("naming", []Diagnostic{{SeverityWarning, "w", ""}, {SeverityError, "e", ""}}); err == nil {
+		t.Error("errors must fail")
+	}
+}
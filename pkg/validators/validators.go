@@ -19,10 +19,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
 	"strings"
+	"time"
 
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 	serviceusage "google.golang.org/api/serviceusage/v1"
 )
@@ -37,6 +42,10 @@ const computeDisabledMsg = "the Compute Engine API must be enabled in project %s
 const serviceDisabledMsg = "the Service Usage API must be enabled in project %s to validate that all APIs needed by the blueprint are enabled"
 const unusedModuleMsg = "module %s uses module %s, but matching setting and outputs were not found. This may be because the value is set explicitly or set by a prior used module"
 const unusedModuleError = "One or more used modules could not have their settings and outputs linked."
+const unavailableTypeMsg = "%s %s is not available in zone %s of project ID %s"
+const unavailableTypeError = "one or more machine or accelerator types are not available in zone %s"
+const missingPermissionMsg = "%s: permission is missing in project %s"
+const missingPermissionsError = "%s missing %d of the IAM permissions required by the blueprint in project %s, please grant them as listed above"
 const unusedDeploymentVariableMsg = "the deployment variable \"%s\" was not used in this blueprint"
 const unusedDeploymentVariableError = "one or more deployment variables was not used by any modules"
 
@@ -82,19 +91,56 @@ func TestModuleNotUsed(unusedModules map[string][]string) error {
 	return nil
 }
 
+// TestExpression validates that a CEL expression declared in the blueprint
+// evaluates to true with the activation, which holds the deployment variables
+// and the modules of the blueprint. message, if set, is printed on failure.
+func TestExpression(e Expression, activation map[string]interface{}, message string) error {
+	v, err := e.Eval(activation)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate %s: %v", e, err)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return fmt.Errorf("%s must evaluate to a bool, got %s", e, typeName(v))
+	}
+	if !b {
+		if message != "" {
+			log.Print(message)
+		}
+		return fmt.Errorf("%s is false", e)
+	}
+	return nil
+}
+
 // TestApisEnabled tests whether APIs are enabled in given project
-func TestApisEnabled(projectID string, requiredAPIs []string) error {
+func TestApisEnabled(projectID string, requiredAPIs []string, opts ...option.ClientOption) error {
+	disabled, err := DisabledApis(projectID, requiredAPIs, opts...)
+	if err != nil {
+		return err
+	}
+	for _, api := range disabled {
+		log.Printf("%s: service is disabled in project %s", api, projectID)
+		log.Printf(enableAPImsg, api, projectID)
+	}
+	if len(disabled) > 0 {
+		return fmt.Errorf("one or more required APIs are disabled in project %s, please enable them as instructed above", projectID)
+	}
+	return nil
+}
+
+// DisabledApis returns the APIs that are disabled in given project
+func DisabledApis(projectID string, requiredAPIs []string, opts ...option.ClientOption) ([]string, error) {
 	// can return immediately if there are 0 APIs to test
 	if len(requiredAPIs) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	ctx := context.Background()
 
-	s, err := serviceusage.NewService(ctx, option.WithQuotaProject(projectID))
+	s, err := serviceusage.NewService(ctx, append([]option.ClientOption{option.WithQuotaProject(projectID)}, opts...)...)
 	if err != nil {
 		err = handleClientError(err)
-		return err
+		return nil, err
 	}
 
 	prefix := "projects/" + projectID
@@ -105,44 +151,90 @@ func TestApisEnabled(projectID string, requiredAPIs []string) error {
 
 	resp, err := s.Services.BatchGet(prefix).Names(serviceNames...).Do()
 	if err != nil {
-		var herr *googleapi.Error
-		if !errors.As(err, &herr) {
-			return fmt.Errorf("unhandled error: %s", err)
-		}
-		ok, reason, metadata := getErrorReason(*herr)
-		if !ok {
-			return fmt.Errorf("unhandled error: %s", err)
-		}
-		switch reason {
-		case "SERVICE_DISABLED":
-			log.Printf(enableAPImsg, "serviceusage.googleapis.com", projectID)
-			return fmt.Errorf(serviceDisabledMsg, projectID)
-		case "SERVICE_CONFIG_NOT_FOUND_OR_PERMISSION_DENIED":
-			return fmt.Errorf("service %s does not exist in project %s", metadata["services"], projectID)
-		case "USER_PROJECT_DENIED":
-			return fmt.Errorf(projectError, projectID)
-		case "SU_MISSING_NAMES":
-			// occurs if API list is empty and 0 APIs to validate
-			return nil
-		default:
-			return fmt.Errorf("unhandled error: %s", err)
-		}
+		return nil, serviceUsageError(projectID, err)
 	}
 
-	var errored bool
+	var disabled []string
 	for _, service := range resp.Services {
 		if service.State == "DISABLED" {
-			errored = true
-			log.Printf("%s: service is disabled in project %s", service.Config.Name, projectID)
-			log.Printf(enableAPImsg, service.Config.Name, projectID)
+			disabled = append(disabled, service.Config.Name)
 		}
 	}
-	if errored {
-		return fmt.Errorf("one or more required APIs are disabled in project %s, please enable them as instructed above", projectID)
+	return disabled, nil
+}
+
+func serviceUsageError(projectID string, err error) error {
+	var herr *googleapi.Error
+	if !errors.As(err, &herr) {
+		return fmt.Errorf("unhandled error: %s", err)
+	}
+	ok, reason, metadata := getErrorReason(*herr)
+	if !ok {
+		return fmt.Errorf("unhandled error: %s", err)
+	}
+	switch reason {
+	case "SERVICE_DISABLED":
+		log.Printf(enableAPImsg, "serviceusage.googleapis.com", projectID)
+		return fmt.Errorf(serviceDisabledMsg, projectID)
+	case "SERVICE_CONFIG_NOT_FOUND_OR_PERMISSION_DENIED":
+		return fmt.Errorf("service %s does not exist in project %s", metadata["services"], projectID)
+	case "USER_PROJECT_DENIED":
+		return fmt.Errorf(projectError, projectID)
+	case "SU_MISSING_NAMES":
+		// occurs if API list is empty and 0 APIs to validate
+		return nil
+	default:
+		return fmt.Errorf("unhandled error: %s", err)
+	}
+}
+
+// maxBatchEnabledApis is the number of APIs a single call to batchEnable
+// accepts
+const maxBatchEnabledApis = 20
+
+// EnableApis enables APIs in given project and waits for them to be enabled
+func EnableApis(projectID string, apis []string, opts ...option.ClientOption) error {
+	ctx := context.Background()
+
+	s, err := serviceusage.NewService(ctx, append([]option.ClientOption{option.WithQuotaProject(projectID)}, opts...)...)
+	if err != nil {
+		return handleClientError(err)
+	}
+
+	prefix := "projects/" + projectID
+	for start := 0; start < len(apis); start += maxBatchEnabledApis {
+		end := start + maxBatchEnabledApis
+		if end > len(apis) {
+			end = len(apis)
+		}
+		for _, api := range apis[start:end] {
+			log.Printf("%s: enabling service in project %s", api, projectID)
+		}
+		req := &serviceusage.BatchEnableServicesRequest{ServiceIds: apis[start:end]}
+		op, err := s.Services.BatchEnable(prefix, req).Do()
+		if err != nil {
+			if err := serviceUsageError(projectID, err); err != nil {
+				return err
+			}
+			continue
+		}
+		for !op.Done {
+			time.Sleep(enablePollInterval)
+			if op, err = s.Operations.Get(op.Name).Do(); err != nil {
+				return fmt.Errorf("failed to wait for APIs to be enabled in project %s: %w", projectID, err)
+			}
+		}
+		if op.Error != nil {
+			return fmt.Errorf("failed to enable APIs in project %s: %s", projectID, op.Error.Message)
+		}
 	}
 	return nil
 }
 
+// enablePollInterval is the interval between checks of whether APIs are
+// enabled
+const enablePollInterval = 2 * time.Second
+
 // TestProjectExists whether projectID exists / is accessible with credentials
 func TestProjectExists(projectID string) error {
 	ctx := context.Background()
@@ -233,3 +325,198 @@ func TestZoneInRegion(projectID string, zone string, region string) error {
 
 	return nil
 }
+
+// maxSuggestedZones is the number of zones suggested for unavailable machine
+// and accelerator types
+const maxSuggestedZones = 3
+
+// nearbyZones returns up to maxSuggestedZones of the zones, preferring those
+// in the region of zone, then those in the same geography (e.g. "us")
+func nearbyZones(zone string, zones []string) []string {
+	region := zone[:strings.LastIndex(zone+"-", "-")]
+	geo := strings.SplitN(zone, "-", 2)[0]
+	rank := func(z string) int {
+		switch {
+		case strings.HasPrefix(z, region+"-"):
+			return 0
+		case strings.HasPrefix(z, geo+"-"):
+			return 1
+		default:
+			return 2
+		}
+	}
+	nearby := []string{}
+	for _, z := range zones {
+		if z != zone {
+			nearby = append(nearby, z)
+		}
+	}
+	sort.Slice(nearby, func(i, j int) bool {
+		ri, rj := rank(nearby[i]), rank(nearby[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return nearby[i] < nearby[j]
+	})
+	if len(nearby) > maxSuggestedZones {
+		nearby = nearby[:maxSuggestedZones]
+	}
+	return nearby
+}
+
+// scopeZone returns the zone of a scope of an aggregated list, "zones/<zone>"
+func scopeZone(scope string) (string, bool) {
+	if !strings.HasPrefix(scope, "zones/") {
+		return "", false
+	}
+	return strings.TrimPrefix(scope, "zones/"), true
+}
+
+func isNotFound(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
+
+// logUnavailable logs that a type is unavailable in zone, with the nearby zones
+// that offer it
+func logUnavailable(kind string, name string, projectID string, zone string, zones []string) {
+	msg := fmt.Sprintf(unavailableTypeMsg, kind, name, zone, projectID)
+	if nearby := nearbyZones(zone, zones); len(nearby) > 0 {
+		msg += fmt.Sprintf("; it is available in %s", strings.Join(nearby, ", "))
+	} else {
+		msg += "; it is not available in any zone of the project"
+	}
+	log.Print(msg)
+}
+
+// TestMachineTypesAvailable tests whether machine types and accelerator types
+// are offered in zone, and suggests nearby zones that offer those which are not
+func TestMachineTypesAvailable(projectID string, zone string, machineTypes []string, acceleratorTypes []string, opts ...option.ClientOption) error {
+	ctx := context.Background()
+	s, err := compute.NewService(ctx, opts...)
+	if err != nil {
+		return handleClientError(err)
+	}
+
+	unavailable := false
+	for _, mt := range machineTypes {
+		_, err := s.MachineTypes.Get(projectID, zone, mt).Fields("name").Do()
+		if err == nil {
+			continue
+		}
+		if !isNotFound(err) {
+			return fmt.Errorf("failed to get machine type %s in zone %s: %w", mt, zone, err)
+		}
+		zones := []string{}
+		err = s.MachineTypes.AggregatedList(projectID).Filter("name = "+mt).Fields("items/*/machineTypes/name", "nextPageToken").
+			Pages(ctx, func(l *compute.MachineTypeAggregatedList) error {
+				for scope, items := range l.Items {
+					if z, ok := scopeZone(scope); ok && len(items.MachineTypes) > 0 {
+						zones = append(zones, z)
+					}
+				}
+				return nil
+			})
+		if err != nil {
+			return fmt.Errorf("failed to list zones of machine type %s: %w", mt, err)
+		}
+		logUnavailable("machine type", mt, projectID, zone, zones)
+		unavailable = true
+	}
+
+	for _, at := range acceleratorTypes {
+		_, err := s.AcceleratorTypes.Get(projectID, zone, at).Fields("name").Do()
+		if err == nil {
+			continue
+		}
+		if !isNotFound(err) {
+			return fmt.Errorf("failed to get accelerator type %s in zone %s: %w", at, zone, err)
+		}
+		zones := []string{}
+		err = s.AcceleratorTypes.AggregatedList(projectID).Filter("name = "+at).Fields("items/*/acceleratorTypes/name", "nextPageToken").
+			Pages(ctx, func(l *compute.AcceleratorTypeAggregatedList) error {
+				for scope, items := range l.Items {
+					if z, ok := scopeZone(scope); ok && len(items.AcceleratorTypes) > 0 {
+						zones = append(zones, z)
+					}
+				}
+				return nil
+			})
+		if err != nil {
+			return fmt.Errorf("failed to list zones of accelerator type %s: %w", at, err)
+		}
+		logUnavailable("accelerator type", at, projectID, zone, zones)
+		unavailable = true
+	}
+
+	if unavailable {
+		return fmt.Errorf(unavailableTypeError, zone)
+	}
+	return nil
+}
+
+// ImpersonateEnvVar sets the service account impersonated by Terraform, and
+// whose permissions are tested instead of those of the credentials
+const ImpersonateEnvVar = "GOOGLE_IMPERSONATE_SERVICE_ACCOUNT"
+
+// maxTestedPermissions is the number of permissions a single call to
+// testIamPermissions accepts
+const maxTestedPermissions = 100
+
+// TestIamPermissions tests whether the credentials, or the service account
+// they impersonate if set, have the permissions in the project
+func TestIamPermissions(projectID string, serviceAccount string, permissions []string) error {
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	opts := []option.ClientOption{}
+	who := "your credentials are"
+	if serviceAccount != "" {
+		ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: serviceAccount,
+			Scopes:          []string{cloudresourcemanager.CloudPlatformScope},
+		})
+		if err != nil {
+			return handleClientError(err)
+		}
+		opts = append(opts, option.WithTokenSource(ts))
+		who = fmt.Sprintf("the service account %s is", serviceAccount)
+	}
+	s, err := cloudresourcemanager.NewService(ctx, opts...)
+	if err != nil {
+		return handleClientError(err)
+	}
+
+	granted := map[string]bool{}
+	for start := 0; start < len(permissions); start += maxTestedPermissions {
+		end := start + maxTestedPermissions
+		if end > len(permissions) {
+			end = len(permissions)
+		}
+		req := &cloudresourcemanager.TestIamPermissionsRequest{Permissions: permissions[start:end]}
+		resp, err := s.Projects.TestIamPermissions(projectID, req).Do()
+		if err != nil {
+			if isNotFound(err) {
+				return fmt.Errorf(projectError, projectID)
+			}
+			return fmt.Errorf("failed to test IAM permissions in project %s: %w", projectID, err)
+		}
+		for _, p := range resp.Permissions {
+			granted[p] = true
+		}
+	}
+
+	missing := 0
+	for _, p := range permissions {
+		if !granted[p] {
+			log.Printf(missingPermissionMsg, p, projectID)
+			missing++
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf(missingPermissionsError, who, missing, projectID)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validators
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/cost"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNearbyZones(t *testing.T) {
+	type test struct {
+		zone  string
+		zones []string
+		want  []string
+	}
+	tests := map[string]test{
+		"none": {"us-central1-a", []string{}, []string{}},
+		"same region first": {"us-central1-a",
+			[]string{"europe-west4-a", "us-east1-b", "us-central1-f", "us-central1-a", "us-central1-b"},
+			[]string{"us-central1-b", "us-central1-f", "us-east1-b"}},
+		"same geography": {"us-central1-a",
+			[]string{"europe-west4-a", "us-west1-b", "asia-east1-a", "us-east4-c"},
+			[]string{"us-east4-c", "us-west1-b", "asia-east1-a"}},
+		"elsewhere": {"us-central1-a",
+			[]string{"europe-west4-b", "asia-east1-a"},
+			[]string{"asia-east1-a", "europe-west4-b"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, nearbyZones(tc.zone, tc.zones)); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScopeZone(t *testing.T) {
+	if z, ok := scopeZone("zones/us-central1-a"); !ok || z != "us-central1-a" {
+		t.Errorf("got %q, %t", z, ok)
+	}
+	if _, ok := scopeZone("regions/us-central1"); ok {
+		t.Error("want no zone for a region scope")
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	e := cost.Estimate{Items: []cost.Item{
+		{Resource: cost.Resource{Group: "primary", Module: "homefs", Kind: cost.Filestore}, Hourly: 0.5},
+		{Resource: cost.Resource{Group: "compute", Module: "nodes", Kind: cost.Instance}, Hourly: 2},
+		{Resource: cost.Resource{Group: "compute", Module: "login", Kind: cost.Instance}, Err: errors.New("no price")},
+	}}
+	// $2.50 per hour is $1825 per month
+	if err := checkBudget(e, 2000); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+	if err := checkBudget(e, 1825); err != nil {
+		t.Errorf("got unexpected error: %v", err)
+	}
+	err := checkBudget(e, 1000)
+	if err == nil || !strings.Contains(err.Error(), "$1825.00, exceeds max_budget of $1000.00") {
+		t.Errorf("got error %v, want the budget to be exceeded", err)
+	}
+}
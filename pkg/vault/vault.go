@@ -0,0 +1,213 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vault reads short-lived cloud credentials and secrets from
+// HashiCorp Vault, for sites that prohibit long-lived keys on workstations
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// Environment variables of the Vault CLI, which set the address, the token
+// and the namespace unless the configuration sets them
+const (
+	AddrEnvVar      = "VAULT_ADDR"
+	TokenEnvVar     = "VAULT_TOKEN"
+	NamespaceEnvVar = "VAULT_NAMESPACE"
+)
+
+// Environment variables the credentials are passed to Terraform in
+const (
+	GCPTokenEnvVar     = "GOOGLE_OAUTH_ACCESS_TOKEN"
+	AWSAccessKeyEnvVar = "AWS_ACCESS_KEY_ID"
+	AWSSecretKeyEnvVar = "AWS_SECRET_ACCESS_KEY"
+	AWSSessionEnvVar   = "AWS_SESSION_TOKEN"
+)
+
+// Config sets what is read from Vault
+type Config struct {
+	// Address of the Vault server, defaults to VAULT_ADDR
+	Address string `yaml:"address,omitempty"`
+	// Namespace of Vault Enterprise, defaults to VAULT_NAMESPACE
+	Namespace string `yaml:"namespace,omitempty"`
+	// GCPTokenPath is the path of an OAuth2 access token of the GCP secrets
+	// engine, e.g. gcp/roleset/deployer/token
+	GCPTokenPath string `yaml:"gcp_token_path,omitempty"`
+	// AWSCredsPath is the path of credentials of the AWS secrets engine,
+	// e.g. aws/sts/deployer
+	AWSCredsPath string `yaml:"aws_creds_path,omitempty"`
+	// Env sets environment variables to fields of secrets, given as
+	// PATH#FIELD, e.g. secret/data/ghpc#encryption_key
+	Env map[string]string `yaml:"env,omitempty"`
+}
+
+// Enabled checks if the configuration reads anything from Vault
+func (c Config) Enabled() bool {
+	return c.GCPTokenPath != "" || c.AWSCredsPath != "" || len(c.Env) > 0
+}
+
+// Client reads secrets from a Vault server with a token
+type Client struct {
+	Address   string
+	Token     string
+	Namespace string
+	HTTP      *http.Client
+}
+
+// NewClient returns a client of the server of the configuration, with the
+// token of VAULT_TOKEN or ~/.vault-token, written by vault login
+func (c Config) NewClient() (Client, error) {
+	cl := Client{Address: c.Address, Namespace: c.Namespace, HTTP: http.DefaultClient}
+	if cl.Address == "" {
+		cl.Address = os.Getenv(AddrEnvVar)
+	}
+	if cl.Address == "" {
+		return Client{}, fmt.Errorf("the address of the Vault server is not set, set vault.address or %s", AddrEnvVar)
+	}
+	if cl.Namespace == "" {
+		cl.Namespace = os.Getenv(NamespaceEnvVar)
+	}
+	cl.Token = os.Getenv(TokenEnvVar)
+	if cl.Token == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Client{}, err
+		}
+		b, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return Client{}, fmt.Errorf("no Vault token, run vault login or set %s", TokenEnvVar)
+		}
+		if err != nil {
+			return Client{}, err
+		}
+		cl.Token = strings.TrimSpace(string(b))
+	}
+	return cl, nil
+}
+
+// Read returns the data of the secret at path
+func (cl Client) Read(ctx context.Context, path string) (map[string]interface{}, error) {
+	url := strings.TrimSuffix(cl.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", cl.Token)
+	if cl.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", cl.Namespace)
+	}
+	resp, err := cl.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from Vault: %w", path, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from Vault: %w", path, err)
+	}
+	var body struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		json.Unmarshal(b, &body) // the errors are reported if the body has them
+		return nil, fmt.Errorf("failed to read %s from Vault: %s %s", path, resp.Status, strings.Join(body.Errors, "; "))
+	}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("failed to parse %s from Vault: %w", path, err)
+	}
+	return body.Data, nil
+}
+
+// field returns the string field of the data of a secret; fields of
+// secrets of the KV version 2 engine are nested in "data"
+func field(data map[string]interface{}, path string, name string) (string, error) {
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data[name]; !ok {
+			data = nested
+		}
+	}
+	switch v := data[name].(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("secret %s of Vault has no field %s", path, name)
+	default:
+		return "", fmt.Errorf("field %s of secret %s of Vault is not a string", name, path)
+	}
+}
+
+// Fetch returns the environment variables the configuration sets to
+// credentials and secrets read from Vault; those that set is true for are
+// not read, so that credentials set in the environment are used instead
+func (c Config) Fetch(ctx context.Context, cl Client, set func(string) bool) (map[string]string, error) {
+	env := map[string]string{}
+	if c.GCPTokenPath != "" && !set(GCPTokenEnvVar) {
+		data, err := cl.Read(ctx, c.GCPTokenPath)
+		if err != nil {
+			return nil, err
+		}
+		if env[GCPTokenEnvVar], err = field(data, c.GCPTokenPath, "token"); err != nil {
+			return nil, err
+		}
+	}
+	if c.AWSCredsPath != "" && !set(AWSAccessKeyEnvVar) {
+		data, err := cl.Read(ctx, c.AWSCredsPath)
+		if err != nil {
+			return nil, err
+		}
+		if env[AWSAccessKeyEnvVar], err = field(data, c.AWSCredsPath, "access_key"); err != nil {
+			return nil, err
+		}
+		if env[AWSSecretKeyEnvVar], err = field(data, c.AWSCredsPath, "secret_key"); err != nil {
+			return nil, err
+		}
+		// credentials of IAM users have no session token
+		if tok, ok := data["security_token"].(string); ok && tok != "" {
+			env[AWSSessionEnvVar] = tok
+		}
+	}
+	names := maps.Keys(c.Env)
+	slices.Sort(names)
+	for _, n := range names {
+		if set(n) {
+			continue
+		}
+		path, name, ok := strings.Cut(c.Env[n], "#")
+		if !ok || path == "" || name == "" {
+			return nil, fmt.Errorf("vault.env.%s: expected PATH#FIELD, got %q", n, c.Env[n])
+		}
+		data, err := cl.Read(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		if env[n], err = field(data, path, name); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// secrets of the fake Vault server, by path
+var fakeSecrets = map[string]string{
+	"gcp/roleset/deployer/token": `{"data": {"token": "ya29.token", "expires_at_seconds": 1685620800}}`,
+	"aws/sts/deployer":           `{"data": {"access_key": "ASIA1", "secret_key": "s3cr3t", "security_token": "session"}}`,
+	"secret/data/ghpc":           `{"data": {"data": {"encryption_key": "k3y"}, "metadata": {"version": 2}}}`,
+	"kv/ghpc":                    `{"data": {"password": "hunter22"}}`,
+}
+
+func fakeVault(t *testing.T) Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "tok" || r.Header.Get("X-Vault-Namespace") != "hpc" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors": ["permission denied"]}`)
+			return
+		}
+		body, ok := fakeSecrets[r.URL.Path[len("/v1/"):]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": []}`)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return Client{Address: srv.URL, Token: "tok", Namespace: "hpc", HTTP: srv.Client()}
+}
+
+func TestFetch(t *testing.T) {
+	cl := fakeVault(t)
+	c := Config{
+		GCPTokenPath: "gcp/roleset/deployer/token",
+		AWSCredsPath: "aws/sts/deployer",
+		Env: map[string]string{
+			"GOOGLE_ENCRYPTION_KEY": "secret/data/ghpc#encryption_key",
+			"DB_PASSWORD":           "kv/ghpc#password",
+		},
+	}
+	unset := func(string) bool { return false }
+	got, err := c.Fetch(context.Background(), cl, unset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		GCPTokenEnvVar:          "ya29.token",
+		AWSAccessKeyEnvVar:      "ASIA1",
+		AWSSecretKeyEnvVar:      "s3cr3t",
+		AWSSessionEnvVar:        "session",
+		"GOOGLE_ENCRYPTION_KEY": "k3y",
+		"DB_PASSWORD":           "hunter22",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+
+	// credentials set in the environment are not read
+	got, err = c.Fetch(context.Background(), cl, func(n string) bool { return n != "DB_PASSWORD" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{"DB_PASSWORD": "hunter22"}, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	cl := fakeVault(t)
+	unset := func(string) bool { return false }
+	for _, c := range []Config{
+		{GCPTokenPath: "gcp/roleset/missing/token"},
+		{Env: map[string]string{"KEY": "kv/ghpc"}},
+		{Env: map[string]string{"KEY": "kv/ghpc#missing"}},
+	} {
+		if _, err := c.Fetch(context.Background(), cl, unset); err == nil {
+			t.Errorf("Fetch() of %#v: expected an error", c)
+		}
+	}
+
+	cl.Token = "wrong"
+	_, err := Config{GCPTokenPath: "gcp/roleset/deployer/token"}.Fetch(context.Background(), cl, unset)
+	if err == nil || err.Error() != "failed to read gcp/roleset/deployer/token from Vault: 403 Forbidden permission denied" {
+		t.Errorf("got error %v, want permission denied", err)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(AddrEnvVar, "")
+	t.Setenv(TokenEnvVar, "")
+	t.Setenv(NamespaceEnvVar, "hpc")
+
+	if _, err := (Config{}).NewClient(); err == nil {
+		t.Error("expected an error without address")
+	}
+	t.Setenv(AddrEnvVar, "https://vault.example.com:8200")
+	if _, err := (Config{}).NewClient(); err == nil {
+		t.Error("expected an error without token")
+	}
+	if err := os.WriteFile(filepath.Join(home, ".vault-token"), []byte("tok\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cl, err := Config{Address: "https://vault.internal:8200"}.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Address != "https://vault.internal:8200" || cl.Token != "tok" || cl.Namespace != "hpc" {
+		t.Errorf("unexpected client %#v", cl)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wizard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// ErrAborted is returned when the input ends before all questions are answered
+var ErrAborted = errors.New("wizard aborted")
+
+// Prompter asks questions on out and reads the answers from in
+type Prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewPrompter is a constructor for Prompter
+func NewPrompter(in io.Reader, out io.Writer) *Prompter {
+	return &Prompter{in: bufio.NewReader(in), out: out}
+}
+
+// line reads an answer, the default if the answer is empty
+func (p *Prompter) line(question string, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	s, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || s == "") {
+		fmt.Fprintln(p.out)
+		return "", ErrAborted
+	}
+	if s = strings.TrimSpace(s); s == "" {
+		return def, nil
+	}
+	return s, nil
+}
+
+// Choose asks to choose one of the options, by number or by name, until a
+// valid option is chosen
+func (p *Prompter) Choose(question string, options []string, def string) (string, error) {
+	fmt.Fprintln(p.out, question)
+	for i, o := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, o)
+	}
+	for {
+		s, err := p.line("Choose", def)
+		if err != nil {
+			return "", err
+		}
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= len(options) {
+			return options[n-1], nil
+		}
+		if slices.Contains(options, s) {
+			return s, nil
+		}
+		fmt.Fprintf(p.out, "%q is not one of the options\n", s)
+	}
+}
+
+// Number asks for a number of at least min, until a valid number is given
+func (p *Prompter) Number(question string, def int, min int) (int, error) {
+	for {
+		s, err := p.line(question, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		if n, err := strconv.Atoi(s); err == nil && n >= min {
+			return n, nil
+		}
+		fmt.Fprintf(p.out, "%q is not a number of at least %d\n", s, min)
+	}
+}
+
+// Text asks for a text
+func (p *Prompter) Text(question string, def string) (string, error) {
+	return p.line(question, def)
+}
+
+// Parse asks for an answer until parse accepts it, printing the errors of
+// parse
+func (p *Prompter) Parse(question string, parse func(string) error) error {
+	for {
+		s, err := p.line(question, "")
+		if err != nil {
+			return err
+		}
+		if err := parse(s); err == nil {
+			return nil
+		} else {
+			fmt.Fprintln(p.out, err)
+		}
+	}
+}
+
+// Ask asks the questions that are not answered in given, which holds the
+// answers given on the command line, and returns the completed answers
+func Ask(p *Prompter, given Answers) (Answers, error) {
+	var err error
+	a := given
+	if a.Workload == "" {
+		if a.Workload, err = p.Choose("What type of workload will the cluster run?", Workloads(), MPI); err != nil {
+			return a, err
+		}
+	}
+	if a.Budget == "" {
+		if a.Budget, err = p.Choose("What is the budget? Low budgets use smaller and Spot VMs.", Budgets(), Medium); err != nil {
+			return a, err
+		}
+	}
+	def := Defaults(a.Workload, a.Budget)
+
+	if a.Workload == AITraining {
+		if a.Accelerator == "" {
+			if a.Accelerator, err = p.Choose("Which GPUs should the nodes have?", Accelerators(), def.Accelerator); err != nil {
+				return a, err
+			}
+		}
+		if a.GPUsPerNode == 0 {
+			counts := []string{}
+			for _, n := range GPUCounts(a.Accelerator) {
+				counts = append(counts, strconv.Itoa(n))
+			}
+			defCount := strconv.Itoa(defaultGPUs(a.Accelerator, def.GPUsPerNode))
+			s, err := p.Choose("How many GPUs per node?", counts, defCount)
+			if err != nil {
+				return a, err
+			}
+			a.GPUsPerNode, _ = strconv.Atoi(s)
+		}
+	}
+	if a.Nodes == 0 {
+		if a.Nodes, err = p.Number("What is the maximum number of compute nodes?", def.Nodes, 1); err != nil {
+			return a, err
+		}
+	}
+
+	texts := []struct {
+		question string
+		value    *string
+		def      string
+	}{
+		{"Project ID (leave empty to set it later)", &a.ProjectID, ""},
+		{"Deployment name", &a.DeploymentName, def.DeploymentName},
+		{"Region", &a.Region, def.Region},
+	}
+	for _, t := range texts {
+		if *t.value != "" {
+			continue
+		}
+		if *t.value, err = p.Text(t.question, t.def); err != nil {
+			return a, err
+		}
+	}
+	if a.Zone == "" {
+		if a.Zone, err = p.Text("Zone", a.Region+"-a"); err != nil {
+			return a, err
+		}
+	}
+	return a, nil
+}
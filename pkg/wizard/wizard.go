@@ -0,0 +1,309 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wizard generates starter blueprints from the answers to a few
+// questions about the workload, scale and budget of a cluster
+package wizard
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"hpc-toolkit/pkg/config"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// Workloads the wizard generates blueprints for
+const (
+	MPI        = "mpi"
+	AITraining = "ai-training"
+	Genomics   = "genomics"
+)
+
+// Budgets trade cost for performance: low budgets use smaller and Spot VMs,
+// high budgets larger VMs placed close to each other
+const (
+	Low    = "low"
+	Medium = "medium"
+	High   = "high"
+)
+
+// Answers are the choices the blueprint is generated from
+type Answers struct {
+	Workload string
+	// Nodes is the maximum number of compute nodes
+	Nodes  int
+	Budget string
+	// Accelerator and GPUsPerNode are the GPUs of AI training nodes
+	Accelerator    string
+	GPUsPerNode    int
+	ProjectID      string
+	Region         string
+	Zone           string
+	DeploymentName string
+}
+
+// Workloads returns the supported workloads
+func Workloads() []string {
+	return []string{MPI, AITraining, Genomics}
+}
+
+// Budgets returns the supported budgets
+func Budgets() []string {
+	return []string{Low, Medium, High}
+}
+
+// acceleratorMachines maps accelerators to the machine types with the number
+// of GPUs attached
+var acceleratorMachines = map[string]map[int]string{
+	"nvidia-l4":        {1: "g2-standard-8", 2: "g2-standard-24", 4: "g2-standard-48", 8: "g2-standard-96"},
+	"nvidia-a100":      {1: "a2-highgpu-1g", 2: "a2-highgpu-2g", 4: "a2-highgpu-4g", 8: "a2-highgpu-8g"},
+	"nvidia-a100-80gb": {1: "a2-ultragpu-1g", 2: "a2-ultragpu-2g", 4: "a2-ultragpu-4g", 8: "a2-ultragpu-8g"},
+	"nvidia-h100-80gb": {8: "a3-highgpu-8g"},
+}
+
+// Accelerators returns the supported accelerators
+func Accelerators() []string {
+	return []string{"nvidia-l4", "nvidia-a100", "nvidia-a100-80gb", "nvidia-h100-80gb"}
+}
+
+// GPUCounts returns the supported numbers of GPUs per node of an accelerator
+func GPUCounts(accelerator string) []int {
+	counts := []int{}
+	for n := range acceleratorMachines[accelerator] {
+		counts = append(counts, n)
+	}
+	slices.Sort(counts)
+	return counts
+}
+
+// Defaults returns the answers used for questions that are not asked, which
+// depend on the workload and budget
+func Defaults(workload string, budget string) Answers {
+	a := Answers{
+		Workload:       workload,
+		Budget:         budget,
+		Nodes:          map[string]int{MPI: 16, AITraining: 4, Genomics: 50}[workload],
+		Region:         "us-central1",
+		DeploymentName: strings.ReplaceAll(workload, "ai-training", "ai") + "-cluster",
+	}
+	if workload == AITraining {
+		a.Accelerator = map[string]string{Low: "nvidia-l4", Medium: "nvidia-a100", High: "nvidia-a100-80gb"}[budget]
+		a.GPUsPerNode = map[string]int{Low: 1, Medium: 4, High: 8}[budget]
+	}
+	return a
+}
+
+// defaultGPUs returns the default number of GPUs per node for the accelerator,
+// the largest supported number if def is not supported
+func defaultGPUs(accelerator string, def int) int {
+	counts := GPUCounts(accelerator)
+	if len(counts) == 0 || slices.Contains(counts, def) {
+		return def
+	}
+	return counts[len(counts)-1]
+}
+
+// Complete returns the answers with defaults for the questions that are not
+// answered
+func Complete(a Answers) Answers {
+	if a.Workload == "" {
+		a.Workload = MPI
+	}
+	if a.Budget == "" {
+		a.Budget = Medium
+	}
+	def := Defaults(a.Workload, a.Budget)
+	if a.Nodes == 0 {
+		a.Nodes = def.Nodes
+	}
+	if a.Workload == AITraining {
+		if a.Accelerator == "" {
+			a.Accelerator = def.Accelerator
+		}
+		if a.GPUsPerNode == 0 {
+			a.GPUsPerNode = defaultGPUs(a.Accelerator, def.GPUsPerNode)
+		}
+	}
+	if a.DeploymentName == "" {
+		a.DeploymentName = def.DeploymentName
+	}
+	if a.Region == "" {
+		a.Region = def.Region
+	}
+	if a.Zone == "" {
+		a.Zone = a.Region + "-a"
+	}
+	return a
+}
+
+// Check ensures the answers are supported
+func (a Answers) Check() error {
+	if !slices.Contains(Workloads(), a.Workload) {
+		return fmt.Errorf("workload %q must be one of %s", a.Workload, strings.Join(Workloads(), ", "))
+	}
+	if !slices.Contains(Budgets(), a.Budget) {
+		return fmt.Errorf("budget %q must be one of %s", a.Budget, strings.Join(Budgets(), ", "))
+	}
+	if a.Nodes < 1 {
+		return fmt.Errorf("the number of nodes must be positive, got %d", a.Nodes)
+	}
+	if a.Workload == AITraining {
+		machines, ok := acceleratorMachines[a.Accelerator]
+		if !ok {
+			return fmt.Errorf("accelerator %q must be one of %s", a.Accelerator, strings.Join(Accelerators(), ", "))
+		}
+		if _, ok := machines[a.GPUsPerNode]; !ok {
+			return fmt.Errorf("%s nodes can have %v GPUs, got %d", a.Accelerator, GPUCounts(a.Accelerator), a.GPUsPerNode)
+		}
+	}
+	if a.Region == "" || a.Zone == "" {
+		return fmt.Errorf("the region and the zone must be set")
+	}
+	if !strings.HasPrefix(a.Zone, a.Region+"-") {
+		return fmt.Errorf("zone %s is not in region %s", a.Zone, a.Region)
+	}
+	return nil
+}
+
+type module struct {
+	ID       string                 `yaml:"id"`
+	Source   string                 `yaml:"source"`
+	Use      []string               `yaml:"use,omitempty"`
+	Settings map[string]interface{} `yaml:"settings,omitempty"`
+}
+
+type group struct {
+	Group   string   `yaml:"group"`
+	Modules []module `yaml:"modules"`
+}
+
+type blueprint struct {
+	BlueprintName    string                 `yaml:"blueprint_name"`
+	Vars             map[string]interface{} `yaml:"vars"`
+	DeploymentGroups []group                `yaml:"deployment_groups"`
+}
+
+// computeNodes returns the machine type and settings of the compute nodes
+func computeNodes(a Answers) (string, map[string]interface{}, map[string]interface{}) {
+	nodeGroup := map[string]interface{}{"node_count_dynamic_max": a.Nodes}
+	partition := map[string]interface{}{}
+	var machineType string
+	switch a.Workload {
+	case MPI:
+		// tightly-coupled jobs need compute-optimized VMs, close to each other
+		machineType = map[string]string{Low: "c2-standard-30", Medium: "c2-standard-60", High: "h3-standard-88"}[a.Budget]
+		nodeGroup["bandwidth_tier"] = "gvnic_enabled"
+		partition["enable_placement"] = true
+	case AITraining:
+		machineType = acceleratorMachines[a.Accelerator][a.GPUsPerNode]
+		nodeGroup["bandwidth_tier"] = "gvnic_enabled"
+		nodeGroup["disk_size_gb"] = 200
+		partition["enable_placement"] = a.Budget == High
+	case Genomics:
+		// many independent jobs, which share nodes
+		machineType = map[string]string{Low: "n2-standard-8", Medium: "n2-standard-16", High: "n2-standard-32"}[a.Budget]
+		partition["enable_placement"] = false
+		partition["exclusive"] = false
+	}
+	nodeGroup["machine_type"] = machineType
+	// jobs of tightly-coupled workloads fail when a Spot VM is preempted
+	if a.Budget == Low && a.Workload != MPI {
+		nodeGroup["enable_spot_vm"] = true
+	}
+	return machineType, nodeGroup, partition
+}
+
+// Blueprint generates the blueprint for the answers
+func Blueprint(a Answers) ([]byte, error) {
+	if err := a.Check(); err != nil {
+		return nil, err
+	}
+	vars := map[string]interface{}{
+		"project_id":      a.ProjectID,
+		"deployment_name": a.DeploymentName,
+		"region":          a.Region,
+		"zone":            a.Zone,
+	}
+	machineType, nodeGroup, partition := computeNodes(a)
+	partition["partition_name"] = "compute"
+	partition["is_default"] = true
+
+	storage := []string{"homefs"}
+	mods := []module{
+		{ID: "network1", Source: "modules/network/vpc"},
+		{ID: "homefs", Source: "modules/file-system/filestore", Use: []string{"network1"},
+			Settings: map[string]interface{}{"local_mount": "/home"}},
+	}
+	if a.Workload != MPI {
+		// datasets and reference genomes are staged in a bucket
+		mods = append(mods, module{ID: "data_bucket", Source: "community/modules/file-system/cloud-storage-bucket",
+			Settings: map[string]interface{}{"local_mount": "/data"}})
+		storage = append(storage, "data_bucket")
+	}
+	mods = append(mods,
+		module{ID: "compute_node_group", Source: "community/modules/compute/schedmd-slurm-gcp-v5-node-group",
+			Settings: nodeGroup},
+		module{ID: "compute_partition", Source: "community/modules/compute/schedmd-slurm-gcp-v5-partition",
+			Use: append(append([]string{"network1"}, storage...), "compute_node_group"), Settings: partition},
+		module{ID: "slurm_controller", Source: "community/modules/scheduler/schedmd-slurm-gcp-v5-controller",
+			Use:      append([]string{"network1", "compute_partition"}, storage...),
+			Settings: map[string]interface{}{"disable_controller_public_ips": false}},
+		module{ID: "slurm_login", Source: "community/modules/scheduler/schedmd-slurm-gcp-v5-login",
+			Use:      []string{"network1", "slurm_controller"},
+			Settings: map[string]interface{}{"machine_type": "n2-standard-4", "disable_login_public_ips": false}},
+	)
+
+	bp := blueprint{
+		BlueprintName:    a.Workload + "-slurm",
+		Vars:             vars,
+		DeploymentGroups: []group{{Group: "primary", Modules: mods}},
+	}
+	var buf bytes.Buffer
+	buf.WriteString(config.YamlLicense)
+	fmt.Fprintf(&buf, "\n---\n\n# Generated by ghpc wizard for %s workloads with a %s budget: up to %d\n",
+		a.Workload, a.Budget, a.Nodes)
+	fmt.Fprintf(&buf, "# %s compute nodes", machineType)
+	if a.Workload == AITraining {
+		fmt.Fprintf(&buf, " with %d %s GPUs each", a.GPUsPerNode, a.Accelerator)
+	}
+	buf.WriteString(". Check that the machine type is available in the zone\n" +
+		"# and that the project has the quota for it before deploying. Documentation\n" +
+		"# of the modules: https://github.com/GoogleCloudPlatform/hpc-toolkit/blob/main/modules/README.md\n\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(bp); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	out := buf.Bytes()
+	if a.ProjectID == "" {
+		out = bytes.Replace(out, []byte(`project_id: ""`), []byte("project_id:  ## Set GCP Project ID Here ##"), 1)
+	}
+
+	// the generated blueprint must be valid
+	dc, err := config.NewDeploymentConfigFromBytes(out)
+	if err != nil {
+		return nil, fmt.Errorf("generated blueprint is invalid: %w", err)
+	}
+	if _, err := dc.Config.DeploymentName(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
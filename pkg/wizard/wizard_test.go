@@ -0,0 +1,207 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wizard
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"hpc-toolkit/pkg/config"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestComplete(t *testing.T) {
+	type test struct {
+		given Answers
+		want  Answers
+	}
+	tests := map[string]test{
+		"empty": {Answers{}, Answers{
+			Workload: MPI, Budget: Medium, Nodes: 16, Region: "us-central1", Zone: "us-central1-a",
+			DeploymentName: "mpi-cluster"}},
+		"ai": {Answers{Workload: AITraining, Budget: High, Region: "europe-west4"}, Answers{
+			Workload: AITraining, Budget: High, Nodes: 4, Accelerator: "nvidia-a100-80gb", GPUsPerNode: 8,
+			Region: "europe-west4", Zone: "europe-west4-a", DeploymentName: "ai-cluster"}},
+		"h100 has 8 GPUs": {Answers{Workload: AITraining, Budget: Low, Accelerator: "nvidia-h100-80gb"}, Answers{
+			Workload: AITraining, Budget: Low, Nodes: 4, Accelerator: "nvidia-h100-80gb", GPUsPerNode: 8,
+			Region: "us-central1", Zone: "us-central1-a", DeploymentName: "ai-cluster"}},
+		"given": {Answers{Workload: Genomics, Budget: Low, Nodes: 7, Zone: "us-central1-f", DeploymentName: "dna"}, Answers{
+			Workload: Genomics, Budget: Low, Nodes: 7, Region: "us-central1", Zone: "us-central1-f",
+			DeploymentName: "dna"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, Complete(tc.given)); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ok := Complete(Answers{Workload: AITraining})
+	type test struct {
+		change func(*Answers)
+		err    string
+	}
+	tests := map[string]test{
+		"ok":          {func(a *Answers) {}, ""},
+		"workload":    {func(a *Answers) { a.Workload = "render" }, "workload"},
+		"budget":      {func(a *Answers) { a.Budget = "huge" }, "budget"},
+		"nodes":       {func(a *Answers) { a.Nodes = 0 }, "number of nodes"},
+		"accelerator": {func(a *Answers) { a.Accelerator = "nvidia-k80" }, "accelerator"},
+		"gpus":        {func(a *Answers) { a.Accelerator, a.GPUsPerNode = "nvidia-h100-80gb", 2 }, "[8] GPUs"},
+		"no zone":     {func(a *Answers) { a.Zone = "" }, "must be set"},
+		"zone":        {func(a *Answers) { a.Zone = "us-east1-b" }, "not in region"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := ok
+			tc.change(&a)
+			err := a.Check()
+			if tc.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("got error %v, want an error containing %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestBlueprint(t *testing.T) {
+	for _, w := range Workloads() {
+		for _, b := range Budgets() {
+			t.Run(w+"/"+b, func(t *testing.T) {
+				out, err := Blueprint(Complete(Answers{Workload: w, Budget: b}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				dc, err := config.NewDeploymentConfigFromBytes(out)
+				if err != nil {
+					t.Fatal(err)
+				}
+				bp := dc.Config
+				if bp.BlueprintName != w+"-slurm" {
+					t.Errorf("got blueprint name %q", bp.BlueprintName)
+				}
+				if !bytes.Contains(out, []byte("## Set GCP Project ID Here ##")) {
+					t.Error("want a placeholder for the project ID")
+				}
+				mods := bp.DeploymentGroups[0].Modules
+				hasBucket := false
+				for _, m := range mods {
+					if m.ID == "data_bucket" {
+						hasBucket = true
+					}
+				}
+				if hasBucket != (w != MPI) {
+					t.Errorf("got data bucket %t for %s", hasBucket, w)
+				}
+			})
+		}
+	}
+}
+
+func TestBlueprintNodes(t *testing.T) {
+	a := Complete(Answers{Workload: AITraining, Budget: Low, Nodes: 3, ProjectID: "my-project"})
+	out, err := Blueprint(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc, err := config.NewDeploymentConfigFromBytes(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodeGroup config.Module
+	for _, m := range dc.Config.DeploymentGroups[0].Modules {
+		if m.ID == "compute_node_group" {
+			nodeGroup = m
+		}
+	}
+	got := map[string]string{}
+	for _, s := range []string{"machine_type", "node_count_dynamic_max", "enable_spot_vm"} {
+		got[s] = nodeGroup.Settings.Get(s).GoString()
+	}
+	want := map[string]string{
+		"machine_type":           `cty.StringVal("g2-standard-8")`,
+		"node_count_dynamic_max": "cty.NumberIntVal(3)",
+		"enable_spot_vm":         "cty.True",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if !bytes.Contains(out, []byte("project_id: my-project")) {
+		t.Error("want the project ID set")
+	}
+}
+
+func TestBlueprintInvalid(t *testing.T) {
+	if _, err := Blueprint(Answers{Workload: MPI}); err == nil {
+		t.Error("want an error for incomplete answers")
+	}
+}
+
+func TestAsk(t *testing.T) {
+	// ai-training, low budget, h100 (8 GPUs only), default nodes, project,
+	// default deployment name, region and zone; invalid answers are asked again
+	in := "2\nlow\nnvidia-k80\n4\n\nmany\n0\n6\nmy-project\n\neurope-west4\n\n"
+	var out bytes.Buffer
+	got, err := Ask(NewPrompter(strings.NewReader(in), &out), Answers{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Answers{
+		Workload: AITraining, Budget: Low, Accelerator: "nvidia-h100-80gb", GPUsPerNode: 8, Nodes: 6,
+		ProjectID: "my-project", DeploymentName: "ai-cluster", Region: "europe-west4", Zone: "europe-west4-a",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	for _, s := range []string{`"nvidia-k80" is not one of the options`, `"many" is not a number`, "Zone [europe-west4-a]"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("want %q in output:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestAskGiven(t *testing.T) {
+	given := Answers{Workload: MPI, Budget: High, Nodes: 2, ProjectID: "p", DeploymentName: "d",
+		Region: "us-east1", Zone: "us-east1-b"}
+	var out bytes.Buffer
+	got, err := Ask(NewPrompter(strings.NewReader(""), &out), given)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(given, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+	if out.Len() != 0 {
+		t.Errorf("want no questions, got:\n%s", out.String())
+	}
+}
+
+func TestAskAborted(t *testing.T) {
+	_, err := Ask(NewPrompter(strings.NewReader("1\n"), &bytes.Buffer{}), Answers{})
+	if !errors.Is(err, ErrAborted) {
+		t.Errorf("got %v, want %v", err, ErrAborted)
+	}
+}
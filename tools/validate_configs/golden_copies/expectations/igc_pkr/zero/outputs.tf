@@ -14,12 +14,14 @@
   * limitations under the License.
   */
 
+# managed by ghpc, do not edit
 output "subnetwork_name_network0" {
   description = "Automatically-generated output exported for use by later deployment groups"
   value       = module.network0.subnetwork_name
   sensitive   = true
 }
 
+# managed by ghpc, do not edit
 output "startup_script_script" {
   description = "Automatically-generated output exported for use by later deployment groups"
   value       = module.script.startup_script
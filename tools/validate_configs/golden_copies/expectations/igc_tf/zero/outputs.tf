@@ -24,6 +24,7 @@ output "subnetwork_name_network0" {
   value       = module.network0.subnetwork_name
 }
 
+# managed by ghpc, do not edit
 output "network_id_network0" {
   description = "Automatically-generated output exported for use by later deployment groups"
   value       = module.network0.network_id